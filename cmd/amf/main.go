@@ -0,0 +1,92 @@
+// Command amf runs the Access and Mobility Management Function: N2
+// towards the gNBs and its services on the SBI, registered with the NRF
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/amf"
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"go.uber.org/zap"
+)
+
+// Timeouts of the SBI requests and of the shutdown once drained
+const (
+	requestTimeout  = 10 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+func main() {
+	configPath := flag.String("config", "configs", "directory holding config.yaml")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+	amfCfg, err := amf.NewConfig(cfg)
+	if err != nil {
+		logger.Fatal("Invalid AMF configuration", zap.Error(err))
+	}
+
+	m := metrics.New("amf")
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		metricsServer = m.Serve(cfg.Metrics.Port)
+	}
+	adminServer := metrics.ServeAdmin(cfg.Admin.Host, cfg.Admin.Port)
+	registry := health.NewRegistryFromConfig(cfg)
+
+	client := sbi.NewClient("amf", requestTimeout, m)
+	a, err := amf.New(amfCfg, amf.NewNFs(client, amfCfg), metrics.NewAMFMetrics(m, amfCfg.InstanceID))
+	if err != nil {
+		logger.Fatal("Failed to create the AMF", zap.Error(err))
+	}
+	n2 := amf.NewN2Server(amfCfg)
+	a.Attach(n2)
+	if err := n2.Start(); err != nil {
+		logger.Fatal("Failed to start N2", zap.Error(err))
+	}
+
+	server := sbi.NewServer("amf", cfg.Server.Host, cfg.Server.Port, m)
+	a.RegisterServices(server)
+	server.MountHealth(registry)
+	server.Start()
+
+	nrf := sbi.NewNRFRegistration(client, cfg.NRF.URL, a.Profile(),
+		time.Duration(cfg.NRF.RegistrationRetry)*time.Second, time.Duration(cfg.NRF.HeartbeatInterval)*time.Second)
+	registry.Register(health.CheckNRFRegistration, health.Readiness, nrf.Check)
+	nrf.Start()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// Readiness fails for the drain delay before the NF deregisters and
+	// its listeners close
+	registry.Drain(context.Background(), time.Duration(cfg.Health.DrainDelay)*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := nrf.Deregister(ctx); err != nil {
+		logger.Warn("Failed to deregister from the NRF", zap.Error(err))
+	}
+	n2.Shutdown(ctx)
+	server.Shutdown(ctx)
+	a.Close()
+	adminServer.Shutdown(ctx)
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	logger.Info("AMF stopped")
+}
@@ -0,0 +1,108 @@
+// Command smf runs the Session Management Function: its services on the
+// SBI, registered with the NRF, and N4 towards the UPFs
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"github.com/0had0/5G-core/pkg/smf"
+	"go.uber.org/zap"
+)
+
+// Timeouts of the SBI requests and of the shutdown once drained
+const (
+	requestTimeout  = 10 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+func main() {
+	configPath := flag.String("config", "configs", "directory holding config.yaml")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+	smfCfg, err := smf.NewConfig(cfg)
+	if err != nil {
+		logger.Fatal("Invalid SMF configuration", zap.Error(err))
+	}
+
+	m := metrics.New("smf")
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		metricsServer = m.Serve(cfg.Metrics.Port)
+	}
+	adminServer := metrics.ServeAdmin(cfg.Admin.Host, cfg.Admin.Port)
+	registry := health.NewRegistryFromConfig(cfg)
+
+	client := sbi.NewClient("smf", requestTimeout, m)
+	nfs := smf.NewNFs(client, smfCfg)
+	n4, err := smf.NewN4Client(smfCfg)
+	if err != nil {
+		logger.Fatal("Failed to start N4", zap.Error(err))
+	}
+	nfs.N4 = n4
+	if nfs.Store, err = smf.NewAddressStore(cfg); err != nil {
+		logger.Fatal("Failed to create the address store", zap.Error(err))
+	}
+	if nfs.Charging, err = smf.NewChargingSink(smfCfg); err != nil {
+		logger.Fatal("Failed to create the charging sink", zap.Error(err))
+	}
+	s, err := smf.New(smfCfg, nfs, metrics.NewSMFMetrics(m, smfCfg.InstanceID))
+	if err != nil {
+		logger.Fatal("Failed to create the SMF", zap.Error(err))
+	}
+	s.RegisterHealth(registry)
+
+	server := sbi.NewServer("smf", cfg.Server.Host, cfg.Server.Port, m)
+	s.RegisterServices(server)
+	server.MountHealth(registry)
+	server.Start()
+
+	profile := models.NfProfile{
+		NfInstanceID:   smfCfg.InstanceID,
+		NfType:         models.NfTypeSMF,
+		NfInstanceName: smfCfg.Name,
+		Capacity:       cfg.NetworkFunction.Capacity,
+		Locality:       smfCfg.Locality,
+	}
+	nrf := sbi.NewNRFRegistration(client, cfg.NRF.URL, profile,
+		time.Duration(cfg.NRF.RegistrationRetry)*time.Second, time.Duration(cfg.NRF.HeartbeatInterval)*time.Second)
+	registry.Register(health.CheckNRFRegistration, health.Readiness, nrf.Check)
+	nrf.Start()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// Readiness fails for the drain delay before the NF deregisters and
+	// its listeners close
+	registry.Drain(context.Background(), time.Duration(cfg.Health.DrainDelay)*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := nrf.Deregister(ctx); err != nil {
+		logger.Warn("Failed to deregister from the NRF", zap.Error(err))
+	}
+	server.Shutdown(ctx)
+	s.Close()
+	n4.Close()
+	adminServer.Shutdown(ctx)
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	logger.Info("SMF stopped")
+}
@@ -0,0 +1,89 @@
+// Command upf runs the User Plane Function: N4 towards the SMFs and the
+// GTP-U forwarding between N3 and the data networks of N6. Its probes
+// are served on the SBI port, on which it offers no service.
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"github.com/0had0/5G-core/pkg/upf"
+	"go.uber.org/zap"
+)
+
+// Timeouts of the SBI requests and of the shutdown once drained
+const (
+	requestTimeout  = 10 * time.Second
+	shutdownTimeout = 10 * time.Second
+)
+
+func main() {
+	configPath := flag.String("config", "configs", "directory holding config.yaml")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+	upfCfg, err := upf.NewConfig(cfg)
+	if err != nil {
+		logger.Fatal("Invalid UPF configuration", zap.Error(err))
+	}
+
+	m := metrics.New("upf")
+	var metricsServer *http.Server
+	if cfg.Metrics.Enabled {
+		metricsServer = m.Serve(cfg.Metrics.Port)
+	}
+	adminServer := metrics.ServeAdmin(cfg.Admin.Host, cfg.Admin.Port)
+	registry := health.NewRegistryFromConfig(cfg)
+
+	u, err := upf.Listen(upfCfg, metrics.NewUPFMetrics(m, upfCfg.InstanceID))
+	if err != nil {
+		logger.Fatal("Failed to start the UPF", zap.Error(err))
+	}
+	u.RegisterHealth(registry)
+
+	server := sbi.NewServer("upf", cfg.Server.Host, cfg.Server.Port, m)
+	server.MountHealth(registry)
+	server.Start()
+
+	client := sbi.NewClient("upf", requestTimeout, m)
+	nrf := sbi.NewNRFRegistration(client, cfg.NRF.URL, u.Profile(),
+		time.Duration(cfg.NRF.RegistrationRetry)*time.Second, time.Duration(cfg.NRF.HeartbeatInterval)*time.Second)
+	registry.Register(health.CheckNRFRegistration, health.Readiness, nrf.Check)
+	nrf.Start()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// Readiness fails for the drain delay before the NF deregisters and
+	// its listeners close
+	registry.Drain(context.Background(), time.Duration(cfg.Health.DrainDelay)*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := nrf.Deregister(ctx); err != nil {
+		logger.Warn("Failed to deregister from the NRF", zap.Error(err))
+	}
+	server.Shutdown(ctx)
+	if err := u.Close(); err != nil {
+		logger.Warn("Failed to close the UPF", zap.Error(err))
+	}
+	adminServer.Shutdown(ctx)
+	if metricsServer != nil {
+		metricsServer.Shutdown(ctx)
+	}
+	logger.Info("UPF stopped")
+}
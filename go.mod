@@ -3,6 +3,7 @@ module github.com/0had0/5G-core
 go 1.21
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/gin-gonic/gin v1.9.1
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/golang/protobuf v1.5.3
@@ -10,6 +11,7 @@ require (
 	github.com/prometheus/client_golang v1.16.0
 	github.com/spf13/viper v1.16.0
 	go.uber.org/zap v1.26.0
+	golang.org/x/sys v0.10.0
 	google.golang.org/grpc v1.58.2
 	k8s.io/apimachinery v0.28.2
 	k8s.io/client-go v0.28.2
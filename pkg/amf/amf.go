@@ -0,0 +1,546 @@
+package amf
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/common/timer"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// maxNGAPID is the largest AMF UE NGAP ID (TS 38.413 9.3.3.1)
+const maxNGAPID = 1<<40 - 1
+
+// AMF runs the UE procedures of the AMF. It handles the UE associated
+// messages of the N2 server and keeps the UE contexts.
+type AMF struct {
+	config  *Config
+	nfs     NFs
+	metrics *metrics.AMFMetrics
+	log     *zap.Logger
+	gutis   *gutiAllocator
+	n2      *N2Server
+	timers  *timer.Wheel
+
+	mu         sync.RWMutex
+	ranUEs     map[int64]*UE // by AMF UE NGAP ID
+	supis      map[string]*UE
+	nextNGAPID int64
+
+	// Namf_EventExposure subscriptions by identifier
+	eventsMu       sync.RWMutex
+	eventSubs      map[string]*eventSubscription
+	nextEventSubID uint64
+}
+
+// New creates an AMF using the given NF consumers, restoring the 5G-GUTIs
+// it allocated before a restart. Metrics are recorded on m when it is
+// not nil.
+func New(cfg *Config, nfs NFs, m *metrics.AMFMetrics) (*AMF, error) {
+	log := logger.Named("amf")
+	gutis, err := newGUTIAllocator(cfg, log)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AMF{
+		config:    cfg,
+		nfs:       nfs,
+		metrics:   m,
+		log:       log,
+		gutis:     gutis,
+		timers:    newTimers(cfg),
+		ranUEs:    make(map[int64]*UE),
+		supis:     make(map[string]*UE),
+		eventSubs: make(map[string]*eventSubscription),
+	}, nil
+}
+
+// Attach makes the AMF handle the UE associated messages of an N2
+// server, whose gNBs become the targets of N2 handovers. It must be
+// called before the server starts.
+func (a *AMF) Attach(s *N2Server) {
+	a.n2 = s
+	s.SetHandler(a.HandleNGAP)
+	s.SetLostHandler(a.gnbLost)
+}
+
+// UE returns the context of a UE by SUPI
+func (a *AMF) UE(supi string) (*UE, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	ue, ok := a.supis[supi]
+	return ue, ok
+}
+
+// Close stops the UE timers of the AMF and writes its pending 5G-GUTI
+// records
+func (a *AMF) Close() {
+	a.timers.Stop()
+	a.gutis.close()
+}
+
+// HandleNGAP handles the UE associated NGAP messages of a gNB. It is the
+// MessageHandler of the N2 server.
+func (a *AMF) HandleNGAP(gnb *GNB, msg ngap.Message) {
+	switch m := msg.(type) {
+	case *ngap.InitialUEMessage:
+		a.handleInitialUEMessage(gnb, m)
+	case *ngap.UplinkNASTransport:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if !ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					ue.log.Warn("Dropping NAS message from a stale N2 connection")
+					return
+				}
+				a.setLocation(ue, m.UserLocationInformation)
+				a.handleNAS(ue, m.NASPDU)
+			})
+		}
+	case *ngap.InitialContextSetupResponse:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					ue.asContext = true
+					a.sessionsSetUp(ue, m.PDUSessionResourceSetup, m.PDUSessionResourceFailedSetup)
+					a.deliverPending(ue)
+				}
+			})
+		}
+	case *ngap.InitialContextSetupFailure:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					ue.log.Warn("Initial context setup failed", zap.Stringer("cause", m.Cause))
+					a.releaseN2(ue, ngap.CauseRadioNetworkUnspecified)
+				}
+			})
+		}
+	case *ngap.PDUSessionResourceSetupResponse:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					a.sessionsSetUp(ue, m.PDUSessionResourceSetup, m.PDUSessionResourceFailedSetup)
+				}
+			})
+		}
+	case *ngap.PDUSessionResourceModifyResponse:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					a.sessionsModified(ue, m.PDUSessionResourceModify, m.PDUSessionResourceFailedModify)
+				}
+			})
+		}
+	case *ngap.UEContextReleaseRequest:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() {
+				if ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+					ue.log.Info("gNB requested UE context release", zap.Stringer("cause", m.Cause))
+					a.releaseN2(ue, m.Cause)
+				}
+			})
+		}
+	case *ngap.UEContextReleaseComplete:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.releaseComplete(ue, m.AMFUENGAPID) })
+		}
+	case *ngap.PathSwitchRequest:
+		if ue := a.ranUE(gnb, m.SourceAMFUENGAPID); ue != nil {
+			ue.run(func() { a.handlePathSwitchRequest(ue, gnb, m) })
+		} else {
+			rejectPathSwitch(gnb, m, ngap.CauseRadioNetworkUnknownLocalUEID)
+		}
+	case *ngap.HandoverRequired:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.handleHandoverRequired(ue, gnb, m) })
+		}
+	case *ngap.HandoverRequestAcknowledge:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.handleHandoverRequestAcknowledge(ue, gnb, m) })
+		}
+	case *ngap.HandoverFailure:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.handleHandoverFailure(ue, gnb, m) })
+		}
+	case *ngap.HandoverNotify:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.handleHandoverNotify(ue, gnb, m) })
+		}
+	case *ngap.HandoverCancel:
+		if ue := a.ranUE(gnb, m.AMFUENGAPID); ue != nil {
+			ue.run(func() { a.handleHandoverCancel(ue, gnb, m) })
+		}
+	default:
+		gnb.log.Debug("Dropping unhandled NGAP message", zap.String("message", fmt.Sprintf("%T", msg)))
+	}
+}
+
+// handleInitialUEMessage binds the UE sending its first NAS message over
+// a new N2 connection, reusing its context when it is known
+func (a *AMF) handleInitialUEMessage(gnb *GNB, m *ngap.InitialUEMessage) {
+	ue := a.identify(m)
+	id := a.allocateNGAPID(ue)
+
+	ue.run(func() {
+		// A UE coming back on a new connection lost the previous one
+		if ue.gnb != nil {
+			ue.log.Info("Releasing stale N2 connection of UE", zap.String("gnb", ue.gnb.Key()))
+			a.releaseN2(ue, ngap.CauseRadioNetworkRadioConnectionLost)
+		}
+
+		ue.gnb, ue.amfUENGAPID, ue.ranUENGAPID = gnb, id, m.RANUENGAPID
+		ue.asContext = false
+		a.setLocation(ue, m.UserLocationInformation)
+		ue.setCMState(CMConnected)
+		a.stopPaging(ue)
+		a.stopReachability(ue)
+		if rm, _ := ue.State(); rm == RMRegistered {
+			a.setReachable(ue, true)
+		}
+		a.handleNAS(ue, m.NASPDU)
+	})
+}
+
+// identify returns the context of the UE identified by the 5G-S-TMSI or
+// 5G-GUTI of an Initial UE Message, or a new context
+func (a *AMF) identify(m *ngap.InitialUEMessage) *UE {
+	if m.FiveGSTMSI != nil {
+		if e, ok := a.gutis.lookup(m.FiveGSTMSI.AMFSetID, m.FiveGSTMSI.AMFPointer, m.FiveGSTMSI.FiveGTMSI); ok {
+			return a.contextOf(e)
+		}
+	} else {
+		switch msg := peekInitialMessage(m.NASPDU).(type) {
+		case *nas.RegistrationRequest:
+			if guti := msg.MobileIdentity.GUTI; msg.MobileIdentity.Type == nas.IdentityGUTI {
+				if e, ok := a.gutis.lookup(guti.AMFSetID, guti.AMFPointer, guti.TMSI); ok && e.guti == *guti {
+					return a.contextOf(e)
+				}
+			}
+		case *nas.ServiceRequest:
+			if e, ok := a.gutis.lookup(msg.STMSI.AMFSetID, msg.STMSI.AMFPointer, msg.STMSI.TMSI); ok {
+				return a.contextOf(e)
+			}
+		case *nas.DeregistrationRequestUEOriginating:
+			if guti := msg.MobileIdentity.GUTI; msg.MobileIdentity.Type == nas.IdentityGUTI {
+				if e, ok := a.gutis.lookup(guti.AMFSetID, guti.AMFPointer, guti.TMSI); ok && e.guti == *guti {
+					return a.contextOf(e)
+				}
+			}
+		}
+	}
+
+	return &UE{
+		log:     a.log,
+		metrics: a.metrics,
+	}
+}
+
+// peekInitialMessage returns the initial NAS message of a UE sent in
+// clear or only integrity protected
+func peekInitialMessage(pdu []byte) nas.Message {
+	if nas.IsSecurityProtected(pdu) {
+		spm, err := nas.DecodeSecurityProtected(pdu)
+		if err != nil || spm.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCiphered ||
+			spm.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext {
+			return nil
+		}
+		pdu = spm.Payload
+	}
+
+	msg, err := nas.Decode(pdu)
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// contextOf returns the context of the UE holding a 5G-GUTI. A GUTI
+// restored after a restart gets a new context knowing only the SUPI, so
+// the UE is authenticated again without being asked for its identity.
+func (a *AMF) contextOf(e gutiEntry) *UE {
+	if e.ue != nil {
+		return e.ue
+	}
+
+	guti := e.guti
+	ue := &UE{
+		SUPI:    e.supi,
+		GUTI:    &guti,
+		log:     a.log.With(logger.SUPI(e.supi)),
+		metrics: a.metrics,
+	}
+	return a.gutis.attach(guti.TMSI, ue)
+}
+
+// allocateNGAPID binds a new AMF UE NGAP ID to the UE
+func (a *AMF) allocateNGAPID(ue *UE) int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	id := a.nextNGAPID
+	a.nextNGAPID = (a.nextNGAPID + 1) & maxNGAPID
+	a.ranUEs[id] = ue
+	return id
+}
+
+// releaseNGAPID frees an AMF UE NGAP ID
+func (a *AMF) releaseNGAPID(id int64) {
+	a.mu.Lock()
+	delete(a.ranUEs, id)
+	a.mu.Unlock()
+}
+
+// ranUE returns the UE bound to an AMF UE NGAP ID
+func (a *AMF) ranUE(gnb *GNB, amfID int64) *UE {
+	a.mu.RLock()
+	ue, ok := a.ranUEs[amfID]
+	a.mu.RUnlock()
+
+	if !ok {
+		gnb.log.Warn("Unknown AMF UE NGAP ID", zap.Int64("amf_ue_ngap_id", amfID))
+		return nil
+	}
+	return ue
+}
+
+// handleNAS decodes an uplink NAS message, checking its integrity
+// against the security context of the UE, and runs its procedure
+func (a *AMF) handleNAS(ue *UE, pdu []byte) {
+	protected := false
+	if nas.IsSecurityProtected(pdu) {
+		spm, err := nas.DecodeSecurityProtected(pdu)
+		if err != nil {
+			ue.log.Warn("Dropping malformed NAS message", zap.Error(err))
+			return
+		}
+
+		switch {
+		case ue.Security != nil:
+			payload, err := ue.Security.unprotect(spm)
+			if err == nil {
+				pdu, protected = payload, true
+				break
+			}
+			ue.log.Warn("NAS integrity check failed", zap.Error(err))
+			fallthrough
+		default:
+			// Initial messages from a UE whose context is gone are still
+			// readable when they are not ciphered; they are handled as
+			// unprotected
+			if spm.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCiphered ||
+				spm.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext {
+				ue.log.Warn("Dropping ciphered NAS message without security context")
+				return
+			}
+			pdu = spm.Payload
+		}
+	}
+
+	msg, err := nas.Decode(pdu)
+	if err != nil {
+		ue.log.Warn("Failed to decode NAS message", zap.Error(err))
+		return
+	}
+	ue.log.Debug("Received NAS message", zap.String("message", fmt.Sprintf("%T", msg)),
+		zap.Bool("protected", protected))
+	if !protected && !acceptedUnprotected(msg) {
+		ue.log.Warn("Dropping NAS message without integrity protection",
+			zap.String("message", fmt.Sprintf("%T", msg)))
+		return
+	}
+
+	switch m := msg.(type) {
+	case *nas.RegistrationRequest:
+		a.handleRegistrationRequest(ue, m, protected)
+	case *nas.IdentityResponse:
+		a.handleIdentityResponse(ue, m)
+	case *nas.AuthenticationResponse:
+		a.handleAuthenticationResponse(ue, m)
+	case *nas.AuthenticationFailure:
+		a.handleAuthenticationFailure(ue, m)
+	case *nas.SecurityModeComplete:
+		a.handleSecurityModeComplete(ue, m, protected)
+	case *nas.SecurityModeReject:
+		a.handleSecurityModeReject(ue, m)
+	case *nas.RegistrationComplete:
+		a.handleRegistrationComplete(ue, protected)
+	case *nas.DeregistrationRequestUEOriginating:
+		a.handleDeregistrationRequest(ue, m, protected)
+	case *nas.ServiceRequest:
+		a.handleServiceRequest(ue, m, protected)
+	case *nas.ULNASTransport:
+		a.handleULNASTransport(ue, m, protected)
+	case *nas.Status5GMM:
+		ue.log.Warn("UE reported 5GMM status", zap.Stringer("cause", m.Cause))
+	default:
+		ue.log.Debug("Dropping unhandled NAS message", zap.String("message", fmt.Sprintf("%T", msg)))
+	}
+}
+
+// acceptedUnprotected reports whether a NAS message is processed without
+// integrity protection (TS 24.501 4.4.4.3): those a UE sends before a
+// security context is set up, or after losing it. An Identity Response
+// is only when it carries a SUCI.
+func acceptedUnprotected(msg nas.Message) bool {
+	switch m := msg.(type) {
+	case *nas.RegistrationRequest, *nas.AuthenticationResponse, *nas.AuthenticationFailure,
+		*nas.SecurityModeReject, *nas.DeregistrationRequestUEOriginating,
+		*nas.DeregistrationAcceptUETerminated, *nas.ServiceRequest:
+		return true
+	case *nas.IdentityResponse:
+		return m.MobileIdentity.Type == nas.IdentitySUCI
+	default:
+		return false
+	}
+}
+
+// sendNAS sends a NAS message to the UE, protected by its security
+// context when there is one
+func (a *AMF) sendNAS(ue *UE, msg nas.Message) {
+	a.sendNASWithHeader(ue, msg, nas.SecurityHeaderIntegrityProtectedAndCiphered)
+}
+
+// sendNASWithHeader sends a NAS message with the given security header
+// type, or in clear without a security context
+func (a *AMF) sendNASWithHeader(ue *UE, msg nas.Message, headerType nas.SecurityHeaderType) {
+	pdu, ok := a.encodeNAS(ue, msg, headerType)
+	if !ok {
+		return
+	}
+
+	err := ue.gnb.Send(&ngap.DownlinkNASTransport{
+		AMFUENGAPID: ue.amfUENGAPID,
+		RANUENGAPID: ue.ranUENGAPID,
+		NASPDU:      pdu,
+	})
+	if err != nil {
+		ue.log.Error("Failed to send NAS message", zap.String("message", fmt.Sprintf("%T", msg)), zap.Error(err))
+	}
+}
+
+// sendNASWithContextSetup sends a NAS message along with the AS context
+// of the UE when the gNB does not hold it yet (TS 38.413 8.3.1), and
+// sets up the resources of PDU sessions
+func (a *AMF) sendNASWithContextSetup(ue *UE, msg nas.Message, setups []sessionSetup) {
+	if ue.asContext || ue.Security == nil || len(ue.AllowedNSSAI) == 0 {
+		a.sendNAS(ue, msg)
+		if len(setups) > 0 {
+			a.setupSessions(ue, setups)
+		}
+		return
+	}
+
+	// The KgNB is derived from the uplink COUNT before the message
+	// advances the downlink one
+	kgnb := ue.Security.initialKgNB()
+	pdu, ok := a.encodeNAS(ue, msg, nas.SecurityHeaderIntegrityProtectedAndCiphered)
+	if !ok {
+		return
+	}
+
+	guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+	req := &ngap.InitialContextSetupRequest{
+		AMFUENGAPID:            ue.amfUENGAPID,
+		RANUENGAPID:            ue.ranUENGAPID,
+		GUAMI:                  guami,
+		AllowedNSSAI:           ue.AllowedNSSAI,
+		UESecurityCapabilities: securityCapabilities(ue.SecurityCapability),
+		SecurityKey:            kgnb,
+		NASPDU:                 pdu,
+	}
+	if len(setups) > 0 {
+		// The UE-AMBR is required along with PDU sessions
+		ambr := ueAMBR(ue)
+		req.UEAggregateMaximumBitRate = &ambr
+		req.PDUSessionResourceSetup = a.setupItems(ue, setups)
+	}
+	if err := ue.gnb.Send(req); err != nil {
+		ue.log.Error("Failed to send Initial Context Setup Request", zap.Error(err))
+	}
+}
+
+// encodeNAS encodes a NAS message for the N2 connection of the UE,
+// protected by its security context when there is one
+func (a *AMF) encodeNAS(ue *UE, msg nas.Message, headerType nas.SecurityHeaderType) ([]byte, bool) {
+	if ue.gnb == nil {
+		ue.log.Warn("Dropping NAS message for UE without N2 connection",
+			zap.String("message", fmt.Sprintf("%T", msg)))
+		return nil, false
+	}
+
+	pdu, err := nas.Encode(msg)
+	if err == nil && ue.Security != nil {
+		pdu, err = ue.Security.protect(pdu, headerType)
+	}
+	if err != nil {
+		ue.log.Error("Failed to encode NAS message", zap.Error(err))
+		return nil, false
+	}
+	return pdu, true
+}
+
+// releaseN2 asks the gNB to release the N2 connection of the UE. The UE
+// stays bound until the gNB completes the release.
+func (a *AMF) releaseN2(ue *UE, cause ngap.Cause) {
+	if ue.handover != nil {
+		a.cancelHandover(ue, cause, true)
+	}
+
+	err := ue.gnb.Send(&ngap.UEContextReleaseCommand{
+		UENGAPIDs: ngap.UENGAPIDs{AMFUENGAPID: ue.amfUENGAPID, RANUENGAPID: ue.ranUENGAPID},
+		Cause:     cause,
+	})
+	if err != nil {
+		ue.log.Error("Failed to send UE Context Release Command", zap.Error(err))
+	}
+	ue.gnb = nil
+	ue.asContext = false
+}
+
+// releaseComplete ends the N2 connection of a UE, dropping its context
+// when it is not registered
+func (a *AMF) releaseComplete(ue *UE, amfID int64) {
+	a.releaseNGAPID(amfID)
+
+	// A release of a connection already replaced by a newer one, or of
+	// the other side of a handover
+	if ue.gnb != nil && ue.amfUENGAPID != amfID {
+		return
+	}
+	ue.gnb = nil
+	ue.asContext = false
+	ue.setCMState(CMIdle)
+
+	if rm, _ := ue.State(); rm == RMDeregistered {
+		a.forget(ue)
+	} else {
+		a.deactivateSessions(ue)
+		a.startReachability(ue)
+	}
+}
+
+// forget drops the context of a deregistered UE and frees its 5G-GUTIs
+func (a *AMF) forget(ue *UE) {
+	a.mu.Lock()
+	if ue.SUPI != "" && a.supis[ue.SUPI] == ue {
+		delete(a.supis, ue.SUPI)
+	}
+	a.mu.Unlock()
+
+	a.gutis.release(ue, ue.GUTI)
+	a.gutis.release(ue, ue.oldGUTI)
+
+	// A deregistered UE is no longer reachable
+	if ue.paging != nil {
+		ue.paging.timer.Stop()
+		ue.paging = nil
+	}
+	ue.pending = nil
+	a.stopReachability(ue)
+}
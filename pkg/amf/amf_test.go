@@ -0,0 +1,739 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/timer"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/security"
+	"go.uber.org/zap"
+)
+
+// The subscriber and network of the tests
+const (
+	testSUPI = "imsi-208930000000001"
+	testTAC  = 1
+)
+
+var (
+	testPLMN  = models.PlmnID{Mcc: "208", Mnc: "93"}
+	testSlice = models.Snssai{Sst: 1, Sd: "010203"}
+
+	// 5G AKA challenge and anchor key handed out by the fake AUSF
+	testRAND    = bytesOf(0x11, 16)
+	testAUTN    = bytesOf(0x22, 16)
+	testRESStar = bytesOf(0x33, 16)
+	testKseaf   = bytesOf(0x44, 32)
+)
+
+// recvTimeout bounds the wait for a message of the AMF
+const recvTimeout = 2 * time.Second
+
+// timerStep is the resolution of the fake clock, finer than the ticks
+// of the UE timers so that they start between two ticks
+const timerStep = timerTick / 4
+
+// bytesOf returns n bytes of value b
+func bytesOf(b byte, n int) []byte {
+	s := make([]byte, n)
+	for i := range s {
+		s[i] = b
+	}
+	return s
+}
+
+// testConfig returns the settings of an AMF serving two tracking areas
+// of testPLMN, with NIA2 and NEA2 preferred
+func testConfig(clock timer.Clock) *Config {
+	return &Config{
+		Name:             "amf-test",
+		RelativeCapacity: 255,
+		ServedGUAMIs:     []ngap.GUAMI{{PLMNIdentity: testPLMN, AMFRegionID: 1, AMFSetID: 1}},
+		PLMNSupport: []ngap.PLMNSupportItem{
+			{PLMNIdentity: testPLMN, SliceSupportList: []models.Snssai{testSlice}},
+		},
+		SupportedTACs:          map[uint32]bool{testTAC: true, testTAC + 1: true},
+		RegistrationAreaSize:   1,
+		InstanceID:             "6b1c3e5a-2f7d-4c1e-9a4b-1f2e3d4c5b6a",
+		CallbackURI:            "http://amf.test",
+		IntegrityOrder:         []uint8{2, 1},
+		CipheringOrder:         []uint8{2, 1, 0},
+		T3512:                  54 * time.Minute,
+		T3513:                  6 * time.Second,
+		PagingRetries:          2,
+		MobileReachableMargin:  4 * time.Minute,
+		ImplicitDeregistration: 4 * time.Minute,
+		Clock:                  clock,
+	}
+}
+
+// fakeNFs plays the AUSF, UDM, PCF, NSSF, SMF and notified NFs of a
+// single subscriber, recording the requests of the AMF
+type fakeNFs struct {
+	mu    sync.Mutex
+	calls []string
+
+	// smUpdates holds the SM context updates sent to the SMF
+	smUpdates []models.SmContextUpdateData
+}
+
+// record notes a request
+func (f *fakeNFs) record(call string) {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+}
+
+// takeCalls returns the requests recorded since the last call
+func (f *fakeNFs) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+// takeSMUpdates returns the SM context updates recorded since the last
+// call
+func (f *fakeNFs) takeSMUpdates() []models.SmContextUpdateData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	updates := f.smUpdates
+	f.smUpdates = nil
+	return updates
+}
+
+func (f *fakeNFs) Authenticate(ctx context.Context, info models.AuthenticationInfo) (*models.UEAuthenticationCtx, error) {
+	f.record("AUSF.Authenticate")
+	return &models.UEAuthenticationCtx{
+		AuthType: models.AuthType5GAKA,
+		Var5gAuthData: models.Av5gAka{
+			Rand:      hex.EncodeToString(testRAND),
+			Autn:      hex.EncodeToString(testAUTN),
+			HxresStar: hex.EncodeToString(security.HXRESStar(testRAND, testRESStar)),
+		},
+	}, nil
+}
+
+func (f *fakeNFs) Confirm(ctx context.Context, authCtx *models.UEAuthenticationCtx, resStar string) (*models.ConfirmationDataResponse, error) {
+	f.record("AUSF.Confirm")
+	if resStar != hex.EncodeToString(testRESStar) {
+		return &models.ConfirmationDataResponse{AuthResult: models.AuthResultFailure}, nil
+	}
+	return &models.ConfirmationDataResponse{
+		AuthResult: models.AuthResultSuccess,
+		Supi:       testSUPI,
+		Kseaf:      hex.EncodeToString(testKseaf),
+	}, nil
+}
+
+func (f *fakeNFs) RegisterAMF(ctx context.Context, supi string, reg models.Amf3GppAccessRegistration) error {
+	f.record("UDM.RegisterAMF")
+	return nil
+}
+
+func (f *fakeNFs) GetAMData(ctx context.Context, supi string, plmn models.PlmnID) (*models.AccessAndMobilitySubscriptionData, error) {
+	f.record("UDM.GetAMData")
+	return &models.AccessAndMobilitySubscriptionData{
+		Nssai: &models.Nssai{DefaultSingleNssais: []models.Snssai{testSlice}},
+	}, nil
+}
+
+func (f *fakeNFs) Subscribe(ctx context.Context, supi string, sub models.SdmSubscription) (string, error) {
+	f.record("UDM.Subscribe")
+	return "http://udm.test/sdm-subscriptions/1", nil
+}
+
+func (f *fakeNFs) Unsubscribe(ctx context.Context, uri string) error {
+	f.record("UDM.Unsubscribe")
+	return nil
+}
+
+func (f *fakeNFs) DeregisterAMF(ctx context.Context, supi string, guami models.Guami) error {
+	f.record("UDM.DeregisterAMF")
+	return nil
+}
+
+func (f *fakeNFs) CreatePolicyAssociation(ctx context.Context, req models.PolicyAssociationRequest) (string, *models.PolicyAssociation, error) {
+	f.record("PCF.CreatePolicyAssociation")
+	return "http://pcf.test/policies/1", &models.PolicyAssociation{}, nil
+}
+
+func (f *fakeNFs) DeletePolicyAssociation(ctx context.Context, uri string) error {
+	f.record("PCF.DeletePolicyAssociation")
+	return nil
+}
+
+func (f *fakeNFs) SelectSlices(ctx context.Context, info models.SliceInfoForRegistration, tai models.Tai) (*models.AuthorizedNetworkSliceInfo, error) {
+	f.record("NSSF.SelectSlices")
+	return &models.AuthorizedNetworkSliceInfo{
+		AllowedNssaiList: []models.AllowedNssai{{
+			AccessType:        models.AccessType3GPP,
+			AllowedSnssaiList: []models.AllowedSnssai{{AllowedSnssai: testSlice}},
+		}},
+	}, nil
+}
+
+func (f *fakeNFs) CreateSMContext(ctx context.Context, data models.SmContextCreateData) (string, error) {
+	f.record("SMF.CreateSMContext")
+	return "http://smf.test/sm-contexts/1", nil
+}
+
+func (f *fakeNFs) UpdateSMContext(ctx context.Context, smContextRef string, data models.SmContextUpdateData) (*models.SmContextUpdatedData, error) {
+	f.record("SMF.UpdateSMContext")
+	f.mu.Lock()
+	f.smUpdates = append(f.smUpdates, data)
+	f.mu.Unlock()
+
+	// The N2 SM information answered is named after the one received
+	resp := &models.SmContextUpdatedData{UpCnxState: data.UpCnxState}
+	if data.N2SmInfoType != "" {
+		resp.BinaryDataN2SmInformation = []byte(data.N2SmInfoType)
+	}
+	return resp, nil
+}
+
+func (f *fakeNFs) ReleaseSMContext(ctx context.Context, smContextRef string, data models.SmContextReleaseData) error {
+	f.record("SMF.ReleaseSMContext")
+	return nil
+}
+
+func (f *fakeNFs) NotifyN1N2TransferFailure(ctx context.Context, uri string, n models.N1N2MsgTxfrFailureNotification) error {
+	f.record("Notifier.NotifyN1N2TransferFailure")
+	return nil
+}
+
+func (f *fakeNFs) NotifyN1Message(ctx context.Context, uri string, n models.N1MessageNotification) error {
+	f.record("Notifier.NotifyN1Message")
+	return nil
+}
+
+func (f *fakeNFs) NotifyEvent(ctx context.Context, uri string, n models.AmfEventNotification) error {
+	f.record("Notifier.NotifyEvent")
+	return nil
+}
+
+// fakeConn is the N2 association of a gNB, decoding what the AMF sends
+type fakeConn struct {
+	sent chan ngap.Message
+}
+
+func (c *fakeConn) ReadMsg() ([]byte, error) { return nil, io.EOF }
+
+func (c *fakeConn) WriteMsg(b []byte) error {
+	msg, err := ngap.Decode(b)
+	if err != nil {
+		return err
+	}
+	c.sent <- msg
+	return nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 38412} }
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 38412}
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// harness runs an AMF on a fake clock with a single set up gNB serving
+// both tracking areas
+type harness struct {
+	t     *testing.T
+	amf   *AMF
+	n2    *N2Server
+	gnb   *GNB
+	conn  *fakeConn
+	nfs   *fakeNFs
+	clock *timer.FakeClock
+
+	nextRANUENGAPID int64
+}
+
+// newHarness creates an AMF and sets up its gNB
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	clock := timer.NewFakeClock(time.Unix(0, 0))
+	cfg := testConfig(clock)
+	nfs := &fakeNFs{}
+	a, err := New(cfg, NFs{AUSF: nfs, UDM: nfs, PCF: nfs, NSSF: nfs, SMF: nfs, Notifier: nfs}, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(a.Close)
+	clock.Add(timerTick / 2)
+
+	n2 := NewN2Server(cfg)
+	a.Attach(n2)
+	conn := &fakeConn{sent: make(chan ngap.Message, 64)}
+	h := &harness{
+		t:     t,
+		amf:   a,
+		n2:    n2,
+		gnb:   &GNB{conn: conn, log: zap.NewNop()},
+		conn:  conn,
+		nfs:   nfs,
+		clock: clock,
+	}
+
+	tas := make([]ngap.SupportedTAItem, 0, len(cfg.SupportedTACs))
+	for tac := range cfg.SupportedTACs {
+		tas = append(tas, ngap.SupportedTAItem{
+			TAC:               tac,
+			BroadcastPLMNList: []ngap.BroadcastPLMNItem{{PLMNIdentity: testPLMN, SliceSupportList: []models.Snssai{testSlice}}},
+		})
+	}
+	h.send(&ngap.NGSetupRequest{
+		GlobalRANNodeID:  ngap.GlobalRANNodeID{PLMNIdentity: testPLMN, GNBID: 1, GNBIDLength: 22},
+		RANNodeName:      "gnb-test",
+		SupportedTAList:  tas,
+		DefaultPagingDRX: ngap.PagingDRX128,
+	})
+	recv[*ngap.NGSetupResponse](h)
+	return h
+}
+
+// send passes a message of the gNB through the NGAP codec to the N2
+// server
+func (h *harness) send(msg ngap.Message) {
+	h.t.Helper()
+	h.sendFrom(h.gnb, msg)
+}
+
+// sendFrom passes a message of another gNB through the NGAP codec to the
+// N2 server
+func (h *harness) sendFrom(gnb *GNB, msg ngap.Message) {
+	h.t.Helper()
+
+	b, err := ngap.Encode(msg)
+	if err != nil {
+		h.t.Fatalf("encoding %T: %v", msg, err)
+	}
+	decoded, err := ngap.Decode(b)
+	if err != nil {
+		h.t.Fatalf("decoding %T: %v", msg, err)
+	}
+	h.n2.dispatch(gnb, decoded)
+}
+
+// settle waits for the procedures queued on the known UEs to finish
+func (h *harness) settle() {
+	h.amf.mu.RLock()
+	ues := make(map[*UE]bool)
+	for _, ue := range h.amf.ranUEs {
+		ues[ue] = true
+	}
+	for _, ue := range h.amf.supis {
+		ues[ue] = true
+	}
+	h.amf.mu.RUnlock()
+
+	for ue := range ues {
+		ue.call(func() {})
+	}
+}
+
+// advance moves the clock by d, a step at a time, running the expired
+// UE timers
+func (h *harness) advance(d time.Duration) {
+	for end := h.clock.Now().Add(d); h.clock.Now().Before(end); {
+		h.clock.Add(timerStep)
+		h.amf.timers.Advance()
+		h.settle()
+	}
+}
+
+// advanceUntilSent moves the clock a step at a time until the AMF sends
+// a message, for at most max, and returns the message and the time it
+// took
+func (h *harness) advanceUntilSent(max time.Duration) (ngap.Message, time.Duration) {
+	h.t.Helper()
+
+	start := h.clock.Now()
+	for h.clock.Now().Sub(start) < max {
+		h.advance(timerStep)
+		select {
+		case msg := <-h.conn.sent:
+			return msg, h.clock.Now().Sub(start)
+		default:
+		}
+	}
+	h.t.Fatalf("nothing sent to the gNB within %v", max)
+	return nil, 0
+}
+
+// expectNothing checks that the AMF sent no message
+func (h *harness) expectNothing() {
+	h.t.Helper()
+
+	h.settle()
+	select {
+	case msg := <-h.conn.sent:
+		h.t.Fatalf("unexpected %T sent to the gNB", msg)
+	default:
+	}
+}
+
+// expectCalls checks the requests the AMF sent to other NFs since the
+// last check
+func (h *harness) expectCalls(want ...string) {
+	h.t.Helper()
+
+	got := h.nfs.takeCalls()
+	if len(got) != len(want) {
+		h.t.Fatalf("NF requests = %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			h.t.Fatalf("NF requests = %v, want %v", got, want)
+		}
+	}
+}
+
+// recv returns the next message sent to the gNB, which must be a T
+func recv[T ngap.Message](h *harness) T {
+	h.t.Helper()
+	return recvFrom[T](h, h.conn)
+}
+
+// recvFrom returns the next message sent over an N2 association, which
+// must be a T
+func recvFrom[T ngap.Message](h *harness, conn *fakeConn) T {
+	h.t.Helper()
+
+	var zero T
+	select {
+	case msg := <-conn.sent:
+		m, ok := msg.(T)
+		if !ok {
+			h.t.Fatalf("got %T sent to the gNB, want %T", msg, zero)
+		}
+		return m
+	case <-time.After(recvTimeout):
+		h.t.Fatalf("no %T sent to the gNB", zero)
+	}
+	return zero
+}
+
+// testUE plays the subscriber: it runs its side of the NAS procedures
+// over the harness gNB
+type testUE struct {
+	h *harness
+
+	// N2 connection, once the AMF answered
+	ranUENGAPID int64
+	amfUENGAPID int64
+	tac         uint32
+
+	sec  *SecurityContext
+	guti *nas.GUTI
+}
+
+// newTestUE creates a UE without NAS security context
+func newTestUE(h *harness) *testUE {
+	return &testUE{h: h}
+}
+
+// context returns the AMF context of the UE
+func (u *testUE) context() *UE {
+	u.h.t.Helper()
+
+	ue, ok := u.h.amf.UE(testSUPI)
+	if !ok {
+		u.h.t.Fatalf("no context for %s", testSUPI)
+	}
+	return ue
+}
+
+// state returns the RM and CM states of the UE in the AMF, deregistered
+// and idle once its context is dropped
+func (u *testUE) state() (RMState, CMState) {
+	u.h.settle()
+	ue, ok := u.h.amf.UE(testSUPI)
+	if !ok {
+		return RMDeregistered, CMIdle
+	}
+	return ue.State()
+}
+
+// location returns the user location of the UE in a tracking area
+func location(tac uint32) ngap.UserLocationInformation {
+	return ngap.UserLocationInformation{
+		NRCGI: ngap.NRCGI{PLMNIdentity: testPLMN, NRCellIdentity: 0x10},
+		TAI:   ngap.TAI{PLMNIdentity: testPLMN, TAC: tac},
+	}
+}
+
+// sendInitial sends a NAS message over a new N2 connection in a tracking
+// area
+func (u *testUE) sendInitial(tac uint32, pdu []byte) {
+	u.h.nextRANUENGAPID++
+	u.ranUENGAPID, u.amfUENGAPID, u.tac = u.h.nextRANUENGAPID, -1, tac
+	u.h.send(&ngap.InitialUEMessage{
+		RANUENGAPID:             u.ranUENGAPID,
+		NASPDU:                  pdu,
+		UserLocationInformation: location(tac),
+		RRCEstablishmentCause:   ngap.RRCEstablishmentCauseMoSignalling,
+	})
+}
+
+// sendUplink sends a NAS message over the N2 connection of the UE
+func (u *testUE) sendUplink(pdu []byte) {
+	u.h.send(&ngap.UplinkNASTransport{
+		AMFUENGAPID:             u.amfUENGAPID,
+		RANUENGAPID:             u.ranUENGAPID,
+		NASPDU:                  pdu,
+		UserLocationInformation: location(u.tac),
+	})
+}
+
+// plain encodes a NAS message in clear
+func (u *testUE) plain(msg nas.Message) []byte {
+	u.h.t.Helper()
+
+	pdu, err := nas.Encode(msg)
+	if err != nil {
+		u.h.t.Fatalf("encoding %T: %v", msg, err)
+	}
+	return pdu
+}
+
+// protect protects a NAS message with the security context of the UE,
+// advancing its uplink COUNT
+func (u *testUE) protect(msg nas.Message, headerType nas.SecurityHeaderType) []byte {
+	u.h.t.Helper()
+
+	payload := u.plain(msg)
+	count := u.sec.ULCount
+	var err error
+	if isCiphered(headerType) {
+		if payload, err = cipher(u.sec.Algorithms.Ciphering, u.sec.KNASenc, count, security.DirectionUplink, payload); err != nil {
+			u.h.t.Fatalf("ciphering %T: %v", msg, err)
+		}
+	}
+	spm := &nas.SecurityProtectedMessage{HeaderType: headerType, SequenceNumber: uint8(count), Payload: payload}
+	spm.MAC, err = integrity(u.sec.Algorithms.Integrity, u.sec.KNASint, count, security.DirectionUplink,
+		append([]byte{spm.SequenceNumber}, payload...))
+	if err != nil {
+		u.h.t.Fatalf("protecting %T: %v", msg, err)
+	}
+	u.sec.ULCount++
+	return spm.Encode()
+}
+
+// isCiphered reports whether a security header type ciphers the message
+func isCiphered(headerType nas.SecurityHeaderType) bool {
+	return headerType == nas.SecurityHeaderIntegrityProtectedAndCiphered ||
+		headerType == nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext
+}
+
+// decode checks and decodes a downlink NAS message. A Security Mode
+// Command makes the UE take the new security context it announces.
+func (u *testUE) decode(pdu []byte) nas.Message {
+	u.h.t.Helper()
+
+	if !nas.IsSecurityProtected(pdu) {
+		msg, err := nas.Decode(pdu)
+		if err != nil {
+			u.h.t.Fatalf("decoding NAS message: %v", err)
+		}
+		return msg
+	}
+
+	spm, err := nas.DecodeSecurityProtected(pdu)
+	if err != nil {
+		u.h.t.Fatalf("decoding protected NAS message: %v", err)
+	}
+	if spm.HeaderType == nas.SecurityHeaderIntegrityProtectedWithNewContext {
+		msg := u.decodePlain(spm.Payload)
+		cmd, ok := msg.(*nas.SecurityModeCommand)
+		if !ok {
+			u.h.t.Fatalf("new security context announced by a %T", msg)
+		}
+		u.sec = newSecurityContext(cmd.NgKSI, testKseaf, testSUPI, abba, cmd.SelectedAlgorithms)
+	}
+	if u.sec == nil {
+		u.h.t.Fatalf("protected NAS message without security context")
+	}
+
+	count := u.sec.DLCount&^0xff | uint32(spm.SequenceNumber)
+	mac, err := integrity(u.sec.Algorithms.Integrity, u.sec.KNASint, count, security.DirectionDownlink,
+		append([]byte{spm.SequenceNumber}, spm.Payload...))
+	if err != nil || mac != spm.MAC {
+		u.h.t.Fatalf("downlink NAS MAC mismatch at COUNT %d", count)
+	}
+	payload := spm.Payload
+	if isCiphered(spm.HeaderType) {
+		if payload, err = cipher(u.sec.Algorithms.Ciphering, u.sec.KNASenc, count, security.DirectionDownlink, payload); err != nil {
+			u.h.t.Fatalf("deciphering NAS message: %v", err)
+		}
+	}
+	u.sec.DLCount = count + 1
+	return u.decodePlain(payload)
+}
+
+// decodePlain decodes a plain NAS message
+func (u *testUE) decodePlain(pdu []byte) nas.Message {
+	u.h.t.Helper()
+
+	msg, err := nas.Decode(pdu)
+	if err != nil {
+		u.h.t.Fatalf("decoding NAS message: %v", err)
+	}
+	return msg
+}
+
+// recvNAS returns the next NAS message for the UE, carried by a
+// Downlink NAS Transport or an Initial Context Setup Request, which must
+// be a T. The context setup is answered.
+func recvNAS[T nas.Message](u *testUE) T {
+	u.h.t.Helper()
+
+	var pdu []byte
+	switch m := recv[ngap.Message](u.h).(type) {
+	case *ngap.DownlinkNASTransport:
+		u.checkIDs(m.AMFUENGAPID, m.RANUENGAPID)
+		pdu = m.NASPDU
+	case *ngap.InitialContextSetupRequest:
+		u.checkIDs(m.AMFUENGAPID, m.RANUENGAPID)
+		pdu = m.NASPDU
+		defer func() {
+			u.h.send(&ngap.InitialContextSetupResponse{AMFUENGAPID: u.amfUENGAPID, RANUENGAPID: u.ranUENGAPID})
+		}()
+		// The KgNB comes from the uplink COUNT of the last message
+		if want := u.sec.KgNB(); !bytes.Equal(m.SecurityKey, want) {
+			u.h.t.Errorf("KgNB = %x, want %x", m.SecurityKey, want)
+		}
+	default:
+		u.h.t.Fatalf("got %T sent to the gNB, want a NAS message", m)
+	}
+
+	var zero T
+	msg, ok := u.decode(pdu).(T)
+	if !ok {
+		u.h.t.Fatalf("got NAS %T, want %T", u.decodePlain(pdu), zero)
+	}
+	return msg
+}
+
+// checkIDs checks the RAN UE NGAP ID of a message for the UE and learns
+// the AMF UE NGAP ID
+func (u *testUE) checkIDs(amfID, ranID int64) {
+	u.h.t.Helper()
+
+	if ranID != u.ranUENGAPID {
+		u.h.t.Fatalf("RAN UE NGAP ID = %d, want %d", ranID, u.ranUENGAPID)
+	}
+	if u.amfUENGAPID >= 0 && amfID != u.amfUENGAPID {
+		u.h.t.Fatalf("AMF UE NGAP ID = %d, want %d", amfID, u.amfUENGAPID)
+	}
+	u.amfUENGAPID = amfID
+}
+
+// register runs an initial registration with the SUCI of the UE
+func (u *testUE) register() {
+	u.h.t.Helper()
+
+	u.sendInitial(testTAC, u.plain(&nas.RegistrationRequest{
+		RegistrationType: nas.RegistrationTypeInitial,
+		NgKSI:            nas.NgKSI{KSI: nas.NoKeyAvailable},
+		MobileIdentity: nas.MobileIdentity{Type: nas.IdentitySUCI, SUCI: &nas.SUCI{
+			PlmnID:           testPLMN,
+			RoutingIndicator: "0000",
+			SchemeOutput:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+		}},
+		UESecurityCapability: &nas.UESecurityCapability{EA: 0xf0, IA: 0xf0},
+	}))
+
+	auth := recvNAS[*nas.AuthenticationRequest](u)
+	if !bytes.Equal(auth.RAND, testRAND) || !bytes.Equal(auth.AUTN, testAUTN) {
+		u.h.t.Fatalf("authentication challenge RAND %x AUTN %x, want those of the AUSF", auth.RAND, auth.AUTN)
+	}
+	u.sendUplink(u.plain(&nas.AuthenticationResponse{RESStar: testRESStar}))
+
+	cmd := recvNAS[*nas.SecurityModeCommand](u)
+	if cmd.SelectedAlgorithms != (nas.SecurityAlgorithms{Ciphering: 2, Integrity: 2}) {
+		u.h.t.Errorf("selected algorithms = %+v, want NEA2 and NIA2", cmd.SelectedAlgorithms)
+	}
+	u.sendUplink(u.protect(&nas.SecurityModeComplete{}, nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext))
+
+	u.accept(true)
+}
+
+// accept receives the Registration Accept, confirming a new 5G-GUTI
+// with a Registration Complete
+func (u *testUE) accept(newGUTI bool) {
+	u.h.t.Helper()
+
+	accept := recvNAS[*nas.RegistrationAccept](u)
+	if (accept.GUTI != nil) != newGUTI {
+		u.h.t.Fatalf("Registration Accept with GUTI %v, want new GUTI %v", accept.GUTI, newGUTI)
+	}
+	if len(accept.AllowedNSSAI) != 1 || accept.AllowedNSSAI[0] != testSlice {
+		u.h.t.Errorf("allowed NSSAI = %v, want %v", accept.AllowedNSSAI, testSlice)
+	}
+	if newGUTI {
+		u.guti = accept.GUTI
+		u.sendUplink(u.protect(&nas.RegistrationComplete{}, nas.SecurityHeaderIntegrityProtectedAndCiphered))
+	}
+	u.h.settle()
+}
+
+// release has the gNB release the N2 connection of the UE, which moves
+// to CM-IDLE
+func (u *testUE) release() {
+	u.h.t.Helper()
+
+	u.h.send(&ngap.UEContextReleaseRequest{
+		AMFUENGAPID: u.amfUENGAPID,
+		RANUENGAPID: u.ranUENGAPID,
+		Cause:       ngap.CauseRadioNetworkUserInactivity,
+	})
+	u.releaseComplete()
+}
+
+// releaseComplete completes the UE context release commanded by the AMF
+func (u *testUE) releaseComplete() {
+	u.h.t.Helper()
+
+	cmd := recv[*ngap.UEContextReleaseCommand](u.h)
+	u.checkIDs(cmd.UENGAPIDs.AMFUENGAPID, cmd.UENGAPIDs.RANUENGAPID)
+	u.h.send(&ngap.UEContextReleaseComplete{AMFUENGAPID: u.amfUENGAPID, RANUENGAPID: u.ranUENGAPID})
+	u.h.settle()
+}
+
+// update sends a Registration Request of the given type with the
+// 5G-GUTI of the UE from CM-IDLE, integrity protected by its context
+func (u *testUE) update(regType nas.RegistrationType, tac uint32) {
+	u.h.t.Helper()
+
+	u.sendInitial(tac, u.protect(&nas.RegistrationRequest{
+		RegistrationType:     regType,
+		NgKSI:                u.sec.NgKSI,
+		MobileIdentity:       nas.MobileIdentity{Type: nas.IdentityGUTI, GUTI: u.guti},
+		UESecurityCapability: &nas.UESecurityCapability{EA: 0xf0, IA: 0xf0},
+	}, nas.SecurityHeaderIntegrityProtected))
+}
+
+// deregistrationRequest returns a UE originating Deregistration Request
+// with the 5G-GUTI of the UE
+func (u *testUE) deregistrationRequest(switchOff bool) *nas.DeregistrationRequestUEOriginating {
+	return &nas.DeregistrationRequestUEOriginating{
+		DeregistrationType: nas.DeregistrationType{SwitchOff: switchOff, Access: nas.Access3GPP},
+		NgKSI:              u.sec.NgKSI,
+		MobileIdentity:     nas.MobileIdentity{Type: nas.IdentityGUTI, GUTI: u.guti},
+	}
+}
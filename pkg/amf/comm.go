@@ -0,0 +1,366 @@
+package amf
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"go.uber.org/zap"
+)
+
+// commPrefix is the path of the UE contexts of Namf_Communication
+const commPrefix = "/namf-comm/v1/ue-contexts/"
+
+// gutiContextPrefix starts the UE context IDs made of a 5G-GUTI
+const gutiContextPrefix = "5g-guti-"
+
+// n1Classes maps the NAS transport payloads relayed to other NFs to
+// their N1 message class
+var n1Classes = map[nas.PayloadContainerType]models.N1MessageClass{
+	nas.PayloadContainerSMS:      models.N1MessageClassSMS,
+	nas.PayloadContainerLPP:      models.N1MessageClassLPP,
+	nas.PayloadContainerUEPolicy: models.N1MessageClassUPDP,
+}
+
+// handleUEContexts serves the UE contexts of Namf_Communication (TS
+// 29.518 5.2). A UE context is identified by the SUPI of the UE, or by
+// its 5G-GUTI for context transfers between AMFs.
+func (a *AMF) handleUEContexts(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, commPrefix), "/", 2)
+	ue, ok := a.ueContext(parts[0])
+	if !ok {
+		sbi.WriteError(w, apperrors.NewNotFoundError("UE context not found", nil))
+		return
+	}
+
+	resource := ""
+	if len(parts) == 2 {
+		resource = parts[1]
+	}
+	switch {
+	case resource == "transfer":
+		if allow(w, r, http.MethodPost) {
+			a.transferUEContext(w, r, ue)
+		}
+	case resource == "transfer-update":
+		if allow(w, r, http.MethodPost) {
+			a.updateTransferStatus(w, r, ue)
+		}
+	case resource == "n1-n2-messages":
+		if allow(w, r, http.MethodPost) {
+			a.transferN1N2Message(w, r, ue)
+		}
+	case resource == "n1-n2-messages/subscriptions":
+		if allow(w, r, http.MethodPost) {
+			a.subscribeN1Messages(w, r, ue)
+		}
+	case strings.HasPrefix(resource, "n1-n2-messages/subscriptions/"):
+		id := strings.TrimPrefix(resource, "n1-n2-messages/subscriptions/")
+		if id == "" || strings.Contains(id, "/") {
+			sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+			return
+		}
+		if allow(w, r, http.MethodDelete) {
+			a.unsubscribeN1Messages(w, ue, id)
+		}
+	default:
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+	}
+}
+
+// ueContext returns the UE identified by a SUPI or by a 5G-GUTI given as
+// "5g-guti-" followed by the PLMN, AMF identifier and 5G-TMSI, e.g.
+// "5g-guti-20893cafe0000000001"
+func (a *AMF) ueContext(id string) (*UE, bool) {
+	if !strings.HasPrefix(id, gutiContextPrefix) {
+		return a.UE(id)
+	}
+
+	g := strings.TrimPrefix(id, gutiContextPrefix)
+	if len(g) != 19 && len(g) != 20 {
+		return nil, false
+	}
+	plmn, amfID, tmsi := g[:len(g)-14], g[len(g)-14:len(g)-8], g[len(g)-8:]
+	region, err1 := strconv.ParseUint(amfID[:2], 16, 8)
+	setPointer, err2 := strconv.ParseUint(amfID[2:], 16, 16)
+	t, err3 := strconv.ParseUint(tmsi, 16, 32)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return nil, false
+	}
+
+	e, ok := a.gutis.lookup(uint16(setPointer>>6), uint8(setPointer&0x3f), uint32(t))
+	if !ok || e.ue == nil || e.guti.PlmnID.String() != plmn || e.guti.AMFRegionID != uint8(region) {
+		return nil, false
+	}
+	return e.ue, true
+}
+
+// transferUEContext gives the context of a UE to the new AMF it
+// registers with (TS 29.518 5.2.2.2.1)
+func (a *AMF) transferUEContext(w http.ResponseWriter, r *http.Request, ue *UE) {
+	var req models.UeContextTransferReqData
+	m, err := sbi.ReadMultipart(r, &req)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if req.RegRequest != nil {
+		req.BinaryDataN1Message = m.Part(req.RegRequest.N1MessageContent.ContentID)
+	}
+
+	var rsp *models.UeContextTransferRspData
+	ue.call(func() { rsp, err = a.ueContextTransfer(ue, req) })
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	sbi.WriteJSON(w, http.StatusOK, rsp)
+}
+
+// ueContextTransfer returns the context of the UE once the Registration
+// Request received by the new AMF passes the integrity check, unless the
+// new AMF validated the UE itself. The PDU sessions are only given on
+// mobility registrations.
+func (a *AMF) ueContextTransfer(ue *UE, req models.UeContextTransferReqData) (*models.UeContextTransferRspData, error) {
+	switch req.Reason {
+	case models.TransferReasonInitReg, models.TransferReasonMobiReg, models.TransferReasonMobiRegUEValidated:
+	default:
+		return nil, apperrors.NewBadRequestError("invalid transfer reason "+string(req.Reason), nil)
+	}
+	if rm, _ := ue.State(); rm != RMRegistered || ue.Security == nil {
+		return nil, apperrors.NewNotFoundError("UE context not found", nil)
+	}
+	if req.Reason != models.TransferReasonMobiRegUEValidated {
+		if len(req.BinaryDataN1Message) == 0 {
+			return nil, apperrors.NewBadRequestError("missing Registration Request", nil)
+		}
+		if err := ue.checkIntegrity(req.BinaryDataN1Message); err != nil {
+			ue.log.Warn("Refusing UE context transfer", zap.Error(err))
+			return nil, apperrors.NewForbiddenError("Registration Request integrity check failed", err)
+		}
+	}
+
+	sc := ue.Security
+	ueCtx := models.UeContext{
+		Supi: ue.SUPI,
+		Pei:  ue.PEI,
+		SeafData: &models.SeafData{
+			NgKsi:  models.NgKsi{Tsc: "NATIVE", Ksi: int(sc.NgKSI.KSI)},
+			KeyAmf: models.KeyAmf{KeyType: "KAMF", KeyVal: hex.EncodeToString(sc.Kamf)},
+			Nh:     hex.EncodeToString(sc.NH),
+			Ncc:    int(sc.NCC),
+		},
+		MmContextList: []models.MmContext{{
+			AccessType: models.AccessType3GPP,
+			NasSecurityMode: &models.NasSecurityMode{
+				IntegrityAlgorithm: algorithmName(integrityAlgorithms, sc.Algorithms.Integrity),
+				CipheringAlgorithm: algorithmName(cipheringAlgorithms, sc.Algorithms.Ciphering),
+			},
+			NasDownlinkCount:     int(sc.DLCount),
+			NasUplinkCount:       int(sc.ULCount),
+			UeSecurityCapability: ue.SecurityCapability.Bytes(),
+			AllowedNssai:         ue.AllowedNSSAI,
+		}},
+	}
+	if ue.AMData != nil {
+		ueCtx.SubUeAmbr = ue.AMData.SubscribedUeAmbr
+	}
+	if req.Reason != models.TransferReasonInitReg {
+		for id, s := range ue.PDUSessions {
+			ueCtx.SessionContextList = append(ueCtx.SessionContextList, models.PduSessionContext{
+				PduSessionID: id,
+				SmContextRef: s.SMContextRef,
+				SNssai:       s.SNSSAI,
+				Dnn:          s.DNN,
+				AccessType:   models.AccessType3GPP,
+			})
+		}
+	}
+
+	ue.log.Info("UE context given to new AMF", zap.String("reason", string(req.Reason)),
+		zap.Int("pdu_sessions", len(ueCtx.SessionContextList)))
+	return &models.UeContextTransferRspData{UeContext: ueCtx}, nil
+}
+
+// updateTransferStatus ends a UE context transfer. A UE taken over by
+// the new AMF is deregistered here without notifying the UDM, which the
+// new AMF registers with (TS 29.518 5.2.2.2.2).
+func (a *AMF) updateTransferStatus(w http.ResponseWriter, r *http.Request, ue *UE) {
+	var req models.UeRegStatusUpdateReqData
+	if _, err := sbi.ReadMultipart(r, &req); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+
+	switch req.TransferStatus {
+	case models.TransferStatusTransferred:
+		ue.call(func() {
+			ue.log.Info("UE context moved to new AMF")
+			ue.reg = nil
+			a.setRMState(ue, RMDeregistered)
+			if ue.gnb != nil {
+				a.releaseN2(ue, ngap.CauseNASDeregister)
+			} else {
+				a.forget(ue)
+			}
+		})
+	case models.TransferStatusNotTransferred:
+		ue.log.Debug("UE context not taken over by new AMF")
+	default:
+		sbi.WriteError(w, apperrors.NewBadRequestError("invalid transfer status "+string(req.TransferStatus), nil))
+		return
+	}
+	sbi.WriteJSON(w, http.StatusOK, models.UeRegStatusUpdateRspData{RegStatusTransferComplete: true})
+}
+
+// transferN1N2Message relays the N1 message and N2 information of
+// another NF to the UE. A UE being paged is answered with 202 and the
+// URI of the transfer (TS 29.518 5.2.2.3.1).
+func (a *AMF) transferN1N2Message(w http.ResponseWriter, r *http.Request, ue *UE) {
+	var req models.N1N2MessageTransferReqData
+	m, err := sbi.ReadMultipart(r, &req)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if c := req.N1MessageContainer; c != nil {
+		req.BinaryDataN1Message = m.Part(c.N1MessageContent.ContentID)
+	}
+	if c := req.N2InfoContainer; c != nil && c.SmInfo != nil && c.SmInfo.N2InfoContent != nil {
+		req.BinaryDataN2Information = m.Part(c.SmInfo.N2InfoContent.NgapData.ContentID)
+	}
+
+	cause, id, err := a.N1N2MessageTransfer(ue.SUPI, req)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	rsp := models.N1N2MessageTransferRspData{Cause: cause}
+	if id == "" {
+		sbi.WriteJSON(w, http.StatusOK, rsp)
+		return
+	}
+	w.Header().Set("Location", a.transferURI(ue, id))
+	sbi.WriteJSON(w, http.StatusAccepted, rsp)
+}
+
+// subscribeN1Messages subscribes another NF to the uplink N1 messages of
+// a class sent by the UE (TS 29.518 5.2.2.3.3)
+func (a *AMF) subscribeN1Messages(w http.ResponseWriter, r *http.Request, ue *UE) {
+	var req models.UeN1N2InfoSubscriptionCreateData
+	if _, err := sbi.ReadMultipart(r, &req); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if req.N2InformationClass != "" {
+		sbi.WriteError(w, apperrors.NewBadRequestError("unsupported N2 information class "+string(req.N2InformationClass), nil))
+		return
+	}
+	if !relayedClass(req.N1MessageClass) || req.N1NotifyCallbackURI == "" {
+		sbi.WriteError(w, apperrors.NewBadRequestError("invalid N1 message subscription", nil))
+		return
+	}
+
+	var id string
+	ue.call(func() {
+		ue.nextSubscriptionID++
+		id = strconv.FormatUint(ue.nextSubscriptionID, 10)
+		if ue.n1Subscriptions == nil {
+			ue.n1Subscriptions = make(map[string]models.UeN1N2InfoSubscriptionCreateData)
+		}
+		ue.n1Subscriptions[id] = req
+	})
+
+	ue.log.Debug("N1 message subscription created", zap.String("subscription_id", id),
+		zap.String("class", string(req.N1MessageClass)))
+	w.Header().Set("Location", a.apiRoot()+commPrefix+ue.SUPI+"/n1-n2-messages/subscriptions/"+id)
+	sbi.WriteJSON(w, http.StatusCreated, models.UeN1N2InfoSubscriptionCreatedData{N1n2NotifySubscriptionID: id})
+}
+
+// unsubscribeN1Messages removes an N1 message subscription of the UE
+// (TS 29.518 5.2.2.3.4)
+func (a *AMF) unsubscribeN1Messages(w http.ResponseWriter, ue *UE, id string) {
+	found := false
+	ue.call(func() {
+		_, found = ue.n1Subscriptions[id]
+		delete(ue.n1Subscriptions, id)
+	})
+	if !found {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Subscription not found", nil))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// relayedClass reports whether N1 messages of a class are relayed to
+// subscribers
+func relayedClass(class models.N1MessageClass) bool {
+	for _, c := range n1Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// handleULNASTransport relays the payload of an uplink NAS transport to
+// the SMF for 5GSM messages, or to the NFs subscribed to its class
+func (a *AMF) handleULNASTransport(ue *UE, m *nas.ULNASTransport, protected bool) {
+	if rm, _ := ue.State(); !protected || rm != RMRegistered {
+		ue.log.Warn("Dropping UL NAS Transport from UE not registered")
+		return
+	}
+	if m.PayloadContainerType == nas.PayloadContainerN1SMInformation {
+		a.handleN1SM(ue, m)
+		return
+	}
+	class, ok := n1Classes[m.PayloadContainerType]
+	if !ok {
+		ue.log.Debug("Dropping unhandled UL NAS Transport payload", zap.Uint8("type", uint8(m.PayloadContainerType)))
+		return
+	}
+
+	sent := 0
+	for id, sub := range ue.n1Subscriptions {
+		if sub.N1MessageClass != class || a.nfs.Notifier == nil {
+			continue
+		}
+		id, uri := id, sub.N1NotifyCallbackURI
+		n := models.N1MessageNotification{
+			N1NotifySubscriptionID: id,
+			N1MessageContainer:     models.N1MessageContainer{N1MessageClass: class},
+			BinaryDataN1Message:    m.PayloadContainer,
+		}
+		go func() {
+			if err := a.nfs.Notifier.NotifyN1Message(context.Background(), uri, n); err != nil {
+				ue.log.Warn("Failed to relay N1 message", zap.String("subscription_id", id), zap.Error(err))
+			}
+		}()
+		sent++
+	}
+	if sent == 0 {
+		ue.log.Debug("No subscriber for N1 message", zap.String("class", string(class)))
+	}
+}
+
+// checkIntegrity checks a NAS message sent by the UE to another AMF
+// against the security context of the UE
+func (ue *UE) checkIntegrity(pdu []byte) error {
+	if !nas.IsSecurityProtected(pdu) {
+		return fmt.Errorf("NAS message not integrity protected")
+	}
+	spm, err := nas.DecodeSecurityProtected(pdu)
+	if err != nil {
+		return err
+	}
+	_, err = ue.Security.unprotect(spm)
+	return err
+}
@@ -0,0 +1,479 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/sbi"
+)
+
+// ueContextPath is the Namf_Communication UE context of the test UE
+const ueContextPath = commPrefix + testSUPI
+
+// serveSBI serves the SBI services of the AMF over HTTP and returns
+// their API root
+func (h *harness) serveSBI() string {
+	// As RegisterServices, whose server keeps its mux to itself
+	mux := http.NewServeMux()
+	mux.HandleFunc(commPrefix, h.amf.handleUEContexts)
+	mux.HandleFunc(eventsPrefix, h.amf.handleEventSubscriptions)
+	mux.HandleFunc(eventsPrefix+"/", h.amf.handleEventSubscriptions)
+	srv := httptest.NewServer(mux)
+	h.t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// sbiResponse is the answer of the AMF to an SBI request
+type sbiResponse struct {
+	status   int
+	location string
+	body     []byte
+}
+
+// problem returns the error type of an error answer
+func (r sbiResponse) problem(t *testing.T) apperrors.ErrorType {
+	t.Helper()
+
+	var problem struct {
+		Type    apperrors.ErrorType `json:"type"`
+		Message string              `json:"message"`
+	}
+	if err := json.Unmarshal(r.body, &problem); err != nil {
+		t.Fatalf("decoding problem %q: %v", r.body, err)
+	}
+	if problem.Message == "" {
+		t.Errorf("problem %q without message", r.body)
+	}
+	return problem.Type
+}
+
+// sbiCall sends a request with a JSON body, none when body is nil
+func sbiCall(t *testing.T, method, url string, body interface{}) sbiResponse {
+	t.Helper()
+
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatalf("encoding request: %v", err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", sbi.ContentTypeJSON)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	var rsp bytes.Buffer
+	if _, err := rsp.ReadFrom(resp.Body); err != nil {
+		t.Fatalf("reading answer: %v", err)
+	}
+	return sbiResponse{status: resp.StatusCode, location: resp.Header.Get("Location"), body: rsp.Bytes()}
+}
+
+// registeredUE registers the test UE in CM-CONNECTED with PDU session 1
+// and serves the SBI services of the AMF
+func registeredUE(t *testing.T) (*testUE, string) {
+	t.Helper()
+
+	h := newHarness(t)
+	u := newTestUE(h)
+	u.register()
+	ue := u.context()
+	ue.call(func() {
+		ue.PDUSessions = map[uint8]*PDUSession{
+			1: {ID: 1, SNSSAI: testSlice, DNN: "internet", SMContextRef: "http://smf.test/sm-contexts/1", Active: true},
+		}
+	})
+	h.nfs.takeCalls()
+	return u, h.serveSBI()
+}
+
+func TestUEContextHandlers(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+
+		status  int
+		problem apperrors.ErrorType
+		check   func(t *testing.T, u *testUE, rsp sbiResponse)
+	}{
+		{
+			name:   "context transfer of a validated UE",
+			method: http.MethodPost,
+			path:   ueContextPath + "/transfer",
+			body:   models.UeContextTransferReqData{Reason: models.TransferReasonMobiRegUEValidated, AccessType: models.AccessType3GPP},
+			status: http.StatusOK,
+			check: func(t *testing.T, u *testUE, rsp sbiResponse) {
+				var data models.UeContextTransferRspData
+				if err := json.Unmarshal(rsp.body, &data); err != nil {
+					t.Fatal(err)
+				}
+				ctx := data.UeContext
+				if ctx.Supi != testSUPI || ctx.SeafData == nil || len(ctx.MmContextList) != 1 {
+					t.Errorf("UE context = %+v, want the keys and MM context of %s", ctx, testSUPI)
+				}
+				if len(ctx.SessionContextList) != 1 || ctx.SessionContextList[0].SmContextRef != "http://smf.test/sm-contexts/1" {
+					t.Errorf("sessions = %+v, want PDU session 1 on a mobility registration", ctx.SessionContextList)
+				}
+			},
+		},
+		{
+			name:   "context transfer by 5G-GUTI",
+			method: http.MethodPost,
+			body:   models.UeContextTransferReqData{Reason: models.TransferReasonMobiRegUEValidated, AccessType: models.AccessType3GPP},
+			status: http.StatusOK,
+		},
+		{
+			name:    "context transfer for an unknown reason",
+			method:  http.MethodPost,
+			path:    ueContextPath + "/transfer",
+			body:    models.UeContextTransferReqData{Reason: "EMERGENCY", AccessType: models.AccessType3GPP},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:    "context transfer without Registration Request",
+			method:  http.MethodPost,
+			path:    ueContextPath + "/transfer",
+			body:    models.UeContextTransferReqData{Reason: models.TransferReasonInitReg, AccessType: models.AccessType3GPP},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:    "context transfer with an invalid body",
+			method:  http.MethodPost,
+			path:    ueContextPath + "/transfer",
+			body:    "transfer",
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:    "context transfer of an unknown UE",
+			method:  http.MethodPost,
+			path:    commPrefix + "imsi-208930000000099/transfer",
+			body:    models.UeContextTransferReqData{Reason: models.TransferReasonMobiRegUEValidated},
+			status:  http.StatusNotFound,
+			problem: apperrors.ErrorTypeNotFound,
+		},
+		{
+			name:    "context transfer by an unknown 5G-GUTI",
+			method:  http.MethodPost,
+			path:    commPrefix + gutiContextPrefix + "20893010040ffffffff/transfer",
+			body:    models.UeContextTransferReqData{Reason: models.TransferReasonMobiRegUEValidated},
+			status:  http.StatusNotFound,
+			problem: apperrors.ErrorTypeNotFound,
+		},
+		{
+			name:    "context transfer read",
+			method:  http.MethodGet,
+			path:    ueContextPath + "/transfer",
+			status:  http.StatusMethodNotAllowed,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:   "transfer not taken over",
+			method: http.MethodPost,
+			path:   ueContextPath + "/transfer-update",
+			body:   models.UeRegStatusUpdateReqData{TransferStatus: models.TransferStatusNotTransferred},
+			status: http.StatusOK,
+			check: func(t *testing.T, u *testUE, rsp sbiResponse) {
+				if rm, _ := u.state(); rm != RMRegistered {
+					t.Errorf("UE %v after a transfer not taken over, want it kept", rm)
+				}
+			},
+		},
+		{
+			name:   "transfer taken over",
+			method: http.MethodPost,
+			path:   ueContextPath + "/transfer-update",
+			body:   models.UeRegStatusUpdateReqData{TransferStatus: models.TransferStatusTransferred},
+			status: http.StatusOK,
+			check: func(t *testing.T, u *testUE, rsp sbiResponse) {
+				u.releaseComplete()
+				if _, ok := u.h.amf.UE(testSUPI); ok {
+					t.Errorf("context kept after the transfer")
+				}
+				// The new AMF registers with the UDM itself
+				u.h.expectCalls()
+			},
+		},
+		{
+			name:    "transfer update with an unknown status",
+			method:  http.MethodPost,
+			path:    ueContextPath + "/transfer-update",
+			body:    models.UeRegStatusUpdateReqData{TransferStatus: "LOST"},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:    "N1N2 transfer without message",
+			method:  http.MethodPost,
+			path:    ueContextPath + "/n1-n2-messages",
+			body:    models.N1N2MessageTransferReqData{PduSessionID: 1},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:   "N1N2 transfer of an unsupported class",
+			method: http.MethodPost,
+			path:   ueContextPath + "/n1-n2-messages",
+			body: models.N1N2MessageTransferReqData{
+				N1MessageContainer: &models.N1MessageContainer{N1MessageClass: models.N1MessageClassLPP},
+				PduSessionID:       1,
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:   "N1 message subscription",
+			method: http.MethodPost,
+			path:   ueContextPath + "/n1-n2-messages/subscriptions",
+			body: models.UeN1N2InfoSubscriptionCreateData{
+				N1MessageClass:      models.N1MessageClassSMS,
+				N1NotifyCallbackURI: "http://smsf.test/n1",
+			},
+			status: http.StatusCreated,
+			check: func(t *testing.T, u *testUE, rsp sbiResponse) {
+				var data models.UeN1N2InfoSubscriptionCreatedData
+				if err := json.Unmarshal(rsp.body, &data); err != nil {
+					t.Fatal(err)
+				}
+				want := "http://amf.test" + ueContextPath + "/n1-n2-messages/subscriptions/" + data.N1n2NotifySubscriptionID
+				if data.N1n2NotifySubscriptionID == "" || rsp.location != want {
+					t.Errorf("subscription %q at %q, want one at %q", data.N1n2NotifySubscriptionID, rsp.location, want)
+				}
+			},
+		},
+		{
+			name:   "N2 information subscription",
+			method: http.MethodPost,
+			path:   ueContextPath + "/n1-n2-messages/subscriptions",
+			body: models.UeN1N2InfoSubscriptionCreateData{
+				N2InformationClass:  "NRPPa",
+				N2NotifyCallbackURI: "http://lmf.test/n2",
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:   "N1 message subscription to 5GSM messages",
+			method: http.MethodPost,
+			path:   ueContextPath + "/n1-n2-messages/subscriptions",
+			body: models.UeN1N2InfoSubscriptionCreateData{
+				N1MessageClass:      models.N1MessageClassSM,
+				N1NotifyCallbackURI: "http://smf.test/n1",
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name:    "unknown N1 message subscription",
+			method:  http.MethodDelete,
+			path:    ueContextPath + "/n1-n2-messages/subscriptions/7",
+			status:  http.StatusNotFound,
+			problem: apperrors.ErrorTypeNotFound,
+		},
+		{
+			name:    "unknown resource",
+			method:  http.MethodGet,
+			path:    ueContextPath + "/location",
+			status:  http.StatusNotFound,
+			problem: apperrors.ErrorTypeNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, root := registeredUE(t)
+			path := tt.path
+			if path == "" {
+				path = commPrefix + gutiContextPrefix + strings.ReplaceAll(u.guti.String(), "-", "") + "/transfer"
+			}
+
+			rsp := sbiCall(t, tt.method, root+path, tt.body)
+			if rsp.status != tt.status {
+				t.Fatalf("status = %d %s, want %d", rsp.status, rsp.body, tt.status)
+			}
+			if tt.problem != "" {
+				if got := rsp.problem(t); got != tt.problem {
+					t.Errorf("problem type = %s, want %s", got, tt.problem)
+				}
+			}
+			if tt.check != nil {
+				tt.check(t, u, rsp)
+			}
+		})
+	}
+}
+
+func TestUEContextTransferIntegrity(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// regRequest returns the Registration Request the new AMF got
+		regRequest func(u *testUE) []byte
+		err        apperrors.ErrorType
+	}{
+		{
+			name: "integrity checked",
+			regRequest: func(u *testUE) []byte {
+				return u.protect(&nas.RegistrationRequest{
+					RegistrationType: nas.RegistrationTypeMobilityUpdating,
+					NgKSI:            u.sec.NgKSI,
+					MobileIdentity:   nas.MobileIdentity{Type: nas.IdentityGUTI, GUTI: u.guti},
+				}, nas.SecurityHeaderIntegrityProtected)
+			},
+		},
+		{
+			name: "integrity check failed",
+			regRequest: func(u *testUE) []byte {
+				pdu := u.protect(&nas.RegistrationRequest{
+					RegistrationType: nas.RegistrationTypeMobilityUpdating,
+					NgKSI:            u.sec.NgKSI,
+					MobileIdentity:   nas.MobileIdentity{Type: nas.IdentityGUTI, GUTI: u.guti},
+				}, nas.SecurityHeaderIntegrityProtected)
+				pdu[len(pdu)-1] ^= 0xff
+				return pdu
+			},
+			err: apperrors.ErrorTypeForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, root := registeredUE(t)
+			req := &sbi.Multipart{
+				JSON: models.UeContextTransferReqData{
+					Reason:     models.TransferReasonMobiReg,
+					AccessType: models.AccessType3GPP,
+					RegRequest: &models.N1MessageContainer{
+						N1MessageClass:   models.N1MessageClass5GMM,
+						N1MessageContent: models.RefToBinaryData{ContentID: "n1msg"},
+					},
+				},
+				Parts: []sbi.Part{{ContentID: "n1msg", ContentType: sbi.ContentTypeNAS, Body: tt.regRequest(u)}},
+			}
+
+			var rsp models.UeContextTransferRspData
+			client := sbi.NewClient("amf", recvTimeout, nil)
+			err := client.Post(context.Background(), root+ueContextPath+"/transfer", req, &rsp)
+			var appErr apperrors.AppError
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("transfer error = %v", err)
+			case tt.err == "" && rsp.UeContext.Supi != testSUPI:
+				t.Errorf("UE context of %q, want %s", rsp.UeContext.Supi, testSUPI)
+			case tt.err != "" && (!errors.As(err, &appErr) || appErr.Type != tt.err):
+				t.Errorf("transfer error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestN1N2MessageTransferHandler(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// idle releases the N2 connection of the UE first
+		idle     bool
+		status   int
+		cause    models.N1N2MessageTransferCause
+		location bool
+	}{
+		{
+			name:   "UE in CM-CONNECTED",
+			status: http.StatusOK,
+			cause:  models.N1N2TransferInitiated,
+		},
+		{
+			name:     "UE in CM-IDLE",
+			idle:     true,
+			status:   http.StatusAccepted,
+			cause:    models.N1N2AttemptingToReachUE,
+			location: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, root := registeredUE(t)
+			if tt.idle {
+				u.release()
+				u.h.nfs.takeCalls()
+			}
+			req := &sbi.Multipart{
+				JSON: models.N1N2MessageTransferReqData{
+					N1MessageContainer: &models.N1MessageContainer{
+						N1MessageClass:   models.N1MessageClassSM,
+						N1MessageContent: models.RefToBinaryData{ContentID: "n1msg"},
+					},
+					PduSessionID: 1,
+				},
+				Parts: []sbi.Part{{ContentID: "n1msg", ContentType: sbi.ContentTypeNAS, Body: []byte{0x2e, 0x01, 0x01, 0xcb}}},
+			}
+
+			var rsp models.N1N2MessageTransferRspData
+			client := sbi.NewClient("smf", recvTimeout, nil)
+			location, err := client.Create(context.Background(), root+ueContextPath+"/n1-n2-messages", req, &rsp)
+			if err != nil {
+				t.Fatalf("transfer error = %v", err)
+			}
+			if rsp.Cause != tt.cause {
+				t.Errorf("cause = %s, want %s", rsp.Cause, tt.cause)
+			}
+			if (location != "") != tt.location {
+				t.Errorf("transfer at %q, want a location %v", location, tt.location)
+			}
+
+			if tt.idle {
+				recv[*ngap.Paging](u.h)
+				return
+			}
+			dl := recv[*ngap.DownlinkNASTransport](u.h)
+			msg, ok := u.decode(dl.NASPDU).(*nas.DLNASTransport)
+			if !ok || msg.PayloadContainerType != nas.PayloadContainerN1SMInformation ||
+				!bytes.Equal(msg.PayloadContainer, []byte{0x2e, 0x01, 0x01, 0xcb}) {
+				t.Errorf("DL NAS transport = %+v, want the 5GSM message", msg)
+			}
+		})
+	}
+}
+
+func TestN1MessageSubscriptionLifecycle(t *testing.T) {
+	u, root := registeredUE(t)
+
+	rsp := sbiCall(t, http.MethodPost, root+ueContextPath+"/n1-n2-messages/subscriptions", models.UeN1N2InfoSubscriptionCreateData{
+		N1MessageClass:      models.N1MessageClassLPP,
+		N1NotifyCallbackURI: "http://lmf.test/n1",
+	})
+	if rsp.status != http.StatusCreated {
+		t.Fatalf("subscription status = %d %s, want %d", rsp.status, rsp.body, http.StatusCreated)
+	}
+	subscription := strings.TrimPrefix(rsp.location, u.h.amf.apiRoot())
+
+	if rsp := sbiCall(t, http.MethodGet, root+subscription, nil); rsp.status != http.StatusMethodNotAllowed {
+		t.Errorf("read status = %d, want %d", rsp.status, http.StatusMethodNotAllowed)
+	}
+	if rsp := sbiCall(t, http.MethodDelete, root+subscription, nil); rsp.status != http.StatusNoContent {
+		t.Errorf("delete status = %d %s, want %d", rsp.status, rsp.body, http.StatusNoContent)
+	}
+	rsp = sbiCall(t, http.MethodDelete, root+subscription, nil)
+	if rsp.status != http.StatusNotFound || rsp.problem(t) != apperrors.ErrorTypeNotFound {
+		t.Errorf("second delete status = %d %s, want %d", rsp.status, rsp.body, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,279 @@
+// Package amf implements the Access and Mobility Management Function
+package amf
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/common/timer"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap"
+)
+
+// maxTAIList is the number of tracking areas a NAS TAI list holds
+const maxTAIList = 16
+
+// Config holds the AMF settings derived from the configuration file
+type Config struct {
+	// Name is the AMF name sent to gNBs
+	Name string
+
+	// RelativeCapacity is the weight of this AMF among the AMFs of its set
+	RelativeCapacity uint8
+
+	// ServedGUAMIs holds one GUAMI per supported PLMN
+	ServedGUAMIs []ngap.GUAMI
+
+	// PLMNSupport holds the supported PLMNs and their slices
+	PLMNSupport []ngap.PLMNSupportItem
+
+	// SupportedTACs holds the served tracking area codes
+	SupportedTACs map[uint32]bool
+
+	// RegistrationAreaSize is the number of tracking areas in the
+	// registration area of a UE
+	RegistrationAreaSize int
+
+	// N2 transport and listening address
+	N2Transport string
+	N2Address   string
+
+	// InstanceID is the NF instance ID of the AMF
+	InstanceID string
+
+	// CallbackURI is the API root given to other NFs for notifications
+	CallbackURI string
+
+	// API roots of the NFs used during registration
+	AUSFURI string
+	UDMURI  string
+	PCFURI  string
+	NSSFURI string
+
+	// SMFURI is the API root of the SMF PDU sessions are established in,
+	// empty when there is none
+	SMFURI string
+
+	// NAS algorithms in order of preference
+	IntegrityOrder []uint8
+	CipheringOrder []uint8
+
+	// T3512 is the periodic registration timer given to UEs whose
+	// subscription sets none
+	T3512 time.Duration
+
+	// T3513 is the time a paged UE has to answer before the paging is
+	// repeated, up to PagingRetries times
+	T3513         time.Duration
+	PagingRetries int
+
+	// A UE in CM-IDLE is deemed unreachable once its T3512 plus
+	// MobileReachableMargin elapsed without news from it, then
+	// deregistered after ImplicitDeregistration (TS 23.501 5.3.3.2.2)
+	MobileReachableMargin  time.Duration
+	ImplicitDeregistration time.Duration
+
+	// Clock drives the UE timers, the system clock when nil
+	Clock timer.Clock
+
+	// GUTIStore is the file keeping allocated 5G-GUTIs across restarts,
+	// empty when they are not kept
+	GUTIStore string
+
+	// TMSIReuseDelay is how long a released 5G-TMSI is held back
+	TMSIReuseDelay time.Duration
+}
+
+// NewConfig builds the AMF settings from the application configuration
+func NewConfig(cfg *config.Config) (*Config, error) {
+	amf := cfg.AMF
+
+	if amf.RegionID < 0 || amf.RegionID > 0xff {
+		return nil, fmt.Errorf("invalid AMF region ID %d", amf.RegionID)
+	}
+	if amf.SetID < 0 || amf.SetID > 0x3ff {
+		return nil, fmt.Errorf("invalid AMF set ID %d", amf.SetID)
+	}
+	if amf.PointerToSetID < 0 || amf.PointerToSetID > 0x3f {
+		return nil, fmt.Errorf("invalid AMF pointer %d", amf.PointerToSetID)
+	}
+	if len(amf.PlmnSupportList) == 0 {
+		return nil, fmt.Errorf("no supported PLMN configured")
+	}
+	if len(amf.SupportedTACs) == 0 {
+		return nil, fmt.Errorf("no supported TAC configured")
+	}
+	if amf.RegistrationAreaSize < 1 || amf.RegistrationAreaSize > maxTAIList {
+		return nil, fmt.Errorf("invalid registration area size %d", amf.RegistrationAreaSize)
+	}
+
+	c := &Config{
+		Name:                   amf.Name,
+		SupportedTACs:          make(map[uint32]bool),
+		RegistrationAreaSize:   amf.RegistrationAreaSize,
+		N2Transport:            amf.NGAP.Transport,
+		N2Address:              net.JoinHostPort(amf.NGAP.Host, strconv.Itoa(amf.NGAP.Port)),
+		InstanceID:             cfg.NetworkFunction.InstanceID,
+		CallbackURI:            amf.CallbackURI,
+		AUSFURI:                amf.Peers.AUSF,
+		UDMURI:                 amf.Peers.UDM,
+		PCFURI:                 amf.Peers.PCF,
+		NSSFURI:                amf.Peers.NSSF,
+		SMFURI:                 amf.Peers.SMF,
+		T3512:                  time.Duration(amf.T3512) * time.Second,
+		T3513:                  time.Duration(amf.T3513) * time.Second,
+		PagingRetries:          amf.PagingRetries,
+		MobileReachableMargin:  time.Duration(amf.MobileReachableMargin) * time.Second,
+		ImplicitDeregistration: time.Duration(amf.ImplicitDeregistration) * time.Second,
+		GUTIStore:              amf.GUTIStore,
+		TMSIReuseDelay:         time.Duration(amf.TMSIReuseDelay) * time.Second,
+	}
+	if c.Name == "" {
+		c.Name = cfg.NetworkFunction.InstanceID
+	}
+
+	// Capacity above 255 is reported as the maximum
+	switch capacity := cfg.NetworkFunction.Capacity; {
+	case capacity > 0xff:
+		c.RelativeCapacity = 0xff
+	case capacity > 0:
+		c.RelativeCapacity = uint8(capacity)
+	}
+
+	for _, tac := range amf.SupportedTACs {
+		if tac > 0xffffff {
+			return nil, fmt.Errorf("invalid TAC %d", tac)
+		}
+		c.SupportedTACs[tac] = true
+	}
+
+	for _, p := range amf.PlmnSupportList {
+		plmn := models.PlmnID{Mcc: p.Mcc, Mnc: p.Mnc}
+		if _, err := plmn.Bytes(); err != nil {
+			return nil, err
+		}
+		if len(p.SnssaiList) == 0 {
+			return nil, fmt.Errorf("no slice configured for PLMN %s", plmn)
+		}
+		for _, s := range p.SnssaiList {
+			if _, err := s.SdBytes(); err != nil {
+				return nil, err
+			}
+		}
+
+		c.ServedGUAMIs = append(c.ServedGUAMIs, ngap.GUAMI{
+			PLMNIdentity: plmn,
+			AMFRegionID:  uint8(amf.RegionID),
+			AMFSetID:     uint16(amf.SetID),
+			AMFPointer:   uint8(amf.PointerToSetID),
+		})
+		c.PLMNSupport = append(c.PLMNSupport, ngap.PLMNSupportItem{
+			PLMNIdentity:     plmn,
+			SliceSupportList: p.SnssaiList,
+		})
+	}
+
+	var err error
+	if c.IntegrityOrder, err = parseAlgorithms(amf.Security.IntegrityOrder, integrityAlgorithms); err != nil {
+		return nil, err
+	}
+	if c.CipheringOrder, err = parseAlgorithms(amf.Security.CipheringOrder, cipheringAlgorithms); err != nil {
+		return nil, err
+	}
+	if c.T3512 <= 0 {
+		return nil, fmt.Errorf("invalid T3512 %d", amf.T3512)
+	}
+	if c.T3513 <= 0 {
+		return nil, fmt.Errorf("invalid T3513 %d", amf.T3513)
+	}
+	if c.PagingRetries < 0 {
+		return nil, fmt.Errorf("invalid paging retries %d", amf.PagingRetries)
+	}
+	if c.MobileReachableMargin < 0 {
+		return nil, fmt.Errorf("invalid mobile reachable margin %d", amf.MobileReachableMargin)
+	}
+	if c.ImplicitDeregistration <= 0 {
+		return nil, fmt.Errorf("invalid implicit deregistration timer %d", amf.ImplicitDeregistration)
+	}
+	if c.TMSIReuseDelay < 0 {
+		return nil, fmt.Errorf("invalid 5G-TMSI reuse delay %d", amf.TMSIReuseDelay)
+	}
+
+	return c, nil
+}
+
+// SupportsPLMN reports whether the AMF serves the PLMN
+func (c *Config) SupportsPLMN(plmn models.PlmnID) bool {
+	for _, p := range c.PLMNSupport {
+		if p.PLMNIdentity == plmn {
+			return true
+		}
+	}
+	return false
+}
+
+// SupportsTAI reports whether the AMF serves the tracking area
+func (c *Config) SupportsTAI(tai ngap.TAI) bool {
+	return c.SupportedTACs[tai.TAC] && c.SupportsPLMN(tai.PLMNIdentity)
+}
+
+// RegistrationArea returns the tracking areas assigned to a UE in a
+// tracking area: its own, then the served ones with the closest codes,
+// up to the registration area size. Operators are expected to number
+// neighbouring tracking areas with close codes.
+func (c *Config) RegistrationArea(tai models.Tai) []models.Tai {
+	current, err := tai.TacValue()
+	if err != nil || !c.SupportsPLMN(tai.PlmnID) {
+		return nil
+	}
+
+	distance := func(tac uint32) uint32 {
+		if tac > current {
+			return tac - current
+		}
+		return current - tac
+	}
+	tacs := make([]uint32, 0, len(c.SupportedTACs))
+	for tac := range c.SupportedTACs {
+		tacs = append(tacs, tac)
+	}
+	sort.Slice(tacs, func(i, j int) bool {
+		if di, dj := distance(tacs[i]), distance(tacs[j]); di != dj {
+			return di < dj
+		}
+		return tacs[i] < tacs[j]
+	})
+	if len(tacs) > c.RegistrationAreaSize {
+		tacs = tacs[:c.RegistrationAreaSize]
+	}
+
+	tais := make([]models.Tai, len(tacs))
+	for i, tac := range tacs {
+		tais[i] = models.NewTai(tai.PlmnID, tac)
+	}
+	return tais
+}
+
+// GUAMI returns the served GUAMI of a PLMN
+func (c *Config) GUAMI(plmn models.PlmnID) (ngap.GUAMI, bool) {
+	for _, g := range c.ServedGUAMIs {
+		if g.PLMNIdentity == plmn {
+			return g, true
+		}
+	}
+	return ngap.GUAMI{}, false
+}
+
+// ServingNetworkName returns the serving network name of a PLMN used in
+// authentication (TS 24.501 9.12.1)
+func ServingNetworkName(plmn models.PlmnID) string {
+	mnc := plmn.Mnc
+	if len(mnc) == 2 {
+		mnc = "0" + mnc
+	}
+	return fmt.Sprintf("5G:mnc%s.mcc%s.3gppnetwork.org", mnc, plmn.Mcc)
+}
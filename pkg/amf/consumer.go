@@ -0,0 +1,372 @@
+package amf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/sbi"
+)
+
+// AUSF is the consumer of Nausf_UEAuthentication
+type AUSF interface {
+	// Authenticate requests a 5G AKA challenge for a UE
+	Authenticate(ctx context.Context, info models.AuthenticationInfo) (*models.UEAuthenticationCtx, error)
+
+	// Confirm sends the RES* returned by the UE for a challenge
+	Confirm(ctx context.Context, authCtx *models.UEAuthenticationCtx, resStar string) (*models.ConfirmationDataResponse, error)
+}
+
+// UDM is the consumer of Nudm_UECM and Nudm_SDM
+type UDM interface {
+	// RegisterAMF registers the AMF as serving AMF of a UE on 3GPP access
+	RegisterAMF(ctx context.Context, supi string, reg models.Amf3GppAccessRegistration) error
+
+	// GetAMData returns the access and mobility subscription of a UE
+	GetAMData(ctx context.Context, supi string, plmn models.PlmnID) (*models.AccessAndMobilitySubscriptionData, error)
+
+	// Subscribe subscribes to changes of the subscription of a UE and
+	// returns the subscription URI
+	Subscribe(ctx context.Context, supi string, sub models.SdmSubscription) (string, error)
+
+	// Unsubscribe removes the subscription at uri
+	Unsubscribe(ctx context.Context, uri string) error
+
+	// DeregisterAMF removes the AMF as serving AMF of a UE
+	DeregisterAMF(ctx context.Context, supi string, guami models.Guami) error
+}
+
+// PCF is the consumer of Npcf_AMPolicyControl
+type PCF interface {
+	// CreatePolicyAssociation creates an AM policy association and
+	// returns its URI
+	CreatePolicyAssociation(ctx context.Context, req models.PolicyAssociationRequest) (string, *models.PolicyAssociation, error)
+
+	// DeletePolicyAssociation deletes the AM policy association at uri
+	DeletePolicyAssociation(ctx context.Context, uri string) error
+}
+
+// NSSF is the consumer of Nnssf_NSSelection
+type NSSF interface {
+	// SelectSlices returns the slices a registering UE is allowed
+	SelectSlices(ctx context.Context, info models.SliceInfoForRegistration, tai models.Tai) (*models.AuthorizedNetworkSliceInfo, error)
+}
+
+// SMF is the consumer of Nsmf_PDUSession used to establish the PDU
+// sessions of a UE, to update them on handover and on CM state changes,
+// and to release them when the UE is deregistered
+type SMF interface {
+	// CreateSMContext creates the SM context of a PDU session requested by
+	// the UE and returns its URI. An SMContextRejection is returned when
+	// the SMF rejects the PDU session with an N1 message for the UE.
+	CreateSMContext(ctx context.Context, data models.SmContextCreateData) (string, error)
+
+	// UpdateSMContext sends an update, with its N2 SM information, to the
+	// SM context at smContextRef
+	UpdateSMContext(ctx context.Context, smContextRef string, data models.SmContextUpdateData) (*models.SmContextUpdatedData, error)
+
+	// ReleaseSMContext releases the SM context at smContextRef
+	ReleaseSMContext(ctx context.Context, smContextRef string, data models.SmContextReleaseData) error
+}
+
+// SMContextRejection is an SM context creation rejected by the SMF
+type SMContextRejection struct {
+	Err error
+
+	// Cause is the application error cause given by the SMF
+	Cause string
+
+	// N1SmMsg is the PDU Session Establishment Reject for the UE
+	N1SmMsg []byte
+}
+
+func (e *SMContextRejection) Error() string { return e.Err.Error() }
+
+func (e *SMContextRejection) Unwrap() error { return e.Err }
+
+// Notifier sends the notifications other NFs subscribed to
+type Notifier interface {
+	// NotifyN1N2TransferFailure tells the sender of N1N2 messages that the
+	// UE could not be reached
+	NotifyN1N2TransferFailure(ctx context.Context, uri string, n models.N1N2MsgTxfrFailureNotification) error
+
+	// NotifyN1Message relays an uplink N1 message of a UE to an NF
+	// subscribed to its class
+	NotifyN1Message(ctx context.Context, uri string, n models.N1MessageNotification) error
+
+	// NotifyEvent reports AMF events to an event exposure subscriber
+	NotifyEvent(ctx context.Context, uri string, n models.AmfEventNotification) error
+}
+
+// NFs holds the consumers of the NFs used by the AMF procedures
+type NFs struct {
+	AUSF AUSF
+	UDM  UDM
+	PCF  PCF
+	NSSF NSSF
+
+	// SMF is nil when the AMF has no SMF to relay N2 SM information to;
+	// PDU sessions are then released on handover
+	SMF SMF
+
+	Notifier Notifier
+}
+
+// NewNFs creates consumers of the NFs at the configured API roots
+func NewNFs(client *sbi.Client, cfg *Config) NFs {
+	nfs := NFs{
+		AUSF: &ausfClient{client: client, root: cfg.AUSFURI},
+		UDM:  &udmClient{client: client, root: cfg.UDMURI},
+		PCF:  &pcfClient{client: client, root: cfg.PCFURI},
+		NSSF: &nssfClient{client: client, root: cfg.NSSFURI, instanceID: cfg.InstanceID},
+
+		Notifier: &notifier{client: client},
+	}
+	if cfg.SMFURI != "" {
+		nfs.SMF = &smfClient{client: client, root: cfg.SMFURI}
+	}
+	return nfs
+}
+
+// ausfClient calls the AUSF over HTTP
+type ausfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// Authenticate implements AUSF
+func (c *ausfClient) Authenticate(ctx context.Context, info models.AuthenticationInfo) (*models.UEAuthenticationCtx, error) {
+	authCtx := &models.UEAuthenticationCtx{}
+	if err := c.client.Post(ctx, c.root+"/nausf-auth/v1/ue-authentications", info, authCtx); err != nil {
+		return nil, err
+	}
+	if authCtx.AuthType != models.AuthType5GAKA {
+		return nil, fmt.Errorf("unsupported authentication method %q", authCtx.AuthType)
+	}
+	return authCtx, nil
+}
+
+// Confirm implements AUSF
+func (c *ausfClient) Confirm(ctx context.Context, authCtx *models.UEAuthenticationCtx, resStar string) (*models.ConfirmationDataResponse, error) {
+	link, ok := authCtx.Links["5g-aka"]
+	if !ok {
+		return nil, fmt.Errorf("authentication context without 5g-aka link")
+	}
+
+	// The AUSF may answer with a path relative to its API root
+	href := link.Href
+	if strings.HasPrefix(href, "/") {
+		href = c.root + href
+	}
+
+	resp := &models.ConfirmationDataResponse{}
+	if err := c.client.Put(ctx, href, models.ConfirmationData{ResStar: resStar}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// udmClient calls the UDM over HTTP
+type udmClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// RegisterAMF implements UDM
+func (c *udmClient) RegisterAMF(ctx context.Context, supi string, reg models.Amf3GppAccessRegistration) error {
+	return c.client.Put(ctx, c.root+"/nudm-uecm/v1/"+url.PathEscape(supi)+"/registrations/amf-3gpp-access", reg, nil)
+}
+
+// GetAMData implements UDM
+func (c *udmClient) GetAMData(ctx context.Context, supi string, plmn models.PlmnID) (*models.AccessAndMobilitySubscriptionData, error) {
+	query, err := jsonQuery("plmn-id", plmn)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &models.AccessAndMobilitySubscriptionData{}
+	if err := c.client.Get(ctx, c.root+"/nudm-sdm/v2/"+url.PathEscape(supi)+"/am-data?"+query.Encode(), data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Subscribe implements UDM
+func (c *udmClient) Subscribe(ctx context.Context, supi string, sub models.SdmSubscription) (string, error) {
+	created := &models.SdmSubscription{}
+	location, err := c.client.Create(ctx, c.root+"/nudm-sdm/v2/"+url.PathEscape(supi)+"/sdm-subscriptions", sub, created)
+	if err != nil {
+		return "", err
+	}
+	if location == "" && created.SubscriptionID != "" {
+		location = c.root + "/nudm-sdm/v2/" + url.PathEscape(supi) + "/sdm-subscriptions/" + created.SubscriptionID
+	}
+	return location, nil
+}
+
+// Unsubscribe implements UDM
+func (c *udmClient) Unsubscribe(ctx context.Context, uri string) error {
+	return c.client.Delete(ctx, uri)
+}
+
+// DeregisterAMF implements UDM
+func (c *udmClient) DeregisterAMF(ctx context.Context, supi string, guami models.Guami) error {
+	return c.client.Patch(ctx, c.root+"/nudm-uecm/v1/"+url.PathEscape(supi)+"/registrations/amf-3gpp-access",
+		models.Amf3GppAccessRegistrationModification{Guami: guami, PurgeFlag: true}, nil)
+}
+
+// pcfClient calls the PCF over HTTP
+type pcfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// CreatePolicyAssociation implements PCF
+func (c *pcfClient) CreatePolicyAssociation(ctx context.Context, req models.PolicyAssociationRequest) (string, *models.PolicyAssociation, error) {
+	assoc := &models.PolicyAssociation{}
+	location, err := c.client.Create(ctx, c.root+"/npcf-am-policy-control/v1/policies", req, assoc)
+	if err != nil {
+		return "", nil, err
+	}
+	return location, assoc, nil
+}
+
+// DeletePolicyAssociation implements PCF
+func (c *pcfClient) DeletePolicyAssociation(ctx context.Context, uri string) error {
+	return c.client.Delete(ctx, uri)
+}
+
+// nssfClient calls the NSSF over HTTP
+type nssfClient struct {
+	client     *sbi.Client
+	root       string
+	instanceID string
+}
+
+// SelectSlices implements NSSF
+func (c *nssfClient) SelectSlices(ctx context.Context, info models.SliceInfoForRegistration, tai models.Tai) (*models.AuthorizedNetworkSliceInfo, error) {
+	query, err := jsonQuery("slice-info-request-for-registration", info)
+	if err != nil {
+		return nil, err
+	}
+	taiQuery, err := jsonQuery("tai", tai)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("tai", taiQuery.Get("tai"))
+	query.Set("nf-type", string(models.NfTypeAMF))
+	query.Set("nf-id", c.instanceID)
+
+	sliceInfo := &models.AuthorizedNetworkSliceInfo{}
+	if err := c.client.Get(ctx, c.root+"/nnssf-nsselection/v2/network-slice-information?"+query.Encode(), sliceInfo); err != nil {
+		return nil, err
+	}
+	return sliceInfo, nil
+}
+
+// smfClient calls the SMF over HTTP
+type smfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// CreateSMContext implements SMF. The 5GSM message of the UE is sent as
+// a binary part referred to by the creation data.
+func (c *smfClient) CreateSMContext(ctx context.Context, data models.SmContextCreateData) (string, error) {
+	data.N1SmMsg = &models.RefToBinaryData{ContentID: "n1SmMsg"}
+	body := &sbi.Multipart{
+		JSON:  &data,
+		Parts: []sbi.Part{{ContentID: "n1SmMsg", ContentType: sbi.ContentTypeNAS, Body: data.BinaryDataN1SmMessage}},
+	}
+
+	// The answer is SmContextCreatedData, or SmContextCreateError with
+	// the N1 message rejecting the PDU session
+	var root json.RawMessage
+	rsp := &sbi.Multipart{JSON: &root}
+	location, err := c.client.Create(ctx, c.root+"/nsmf-pdusession/v1/sm-contexts", body, rsp)
+	if err != nil {
+		var createErr models.SmContextCreateError
+		if len(root) > 0 && json.Unmarshal(root, &createErr) == nil && createErr.N1SmMsg != nil {
+			return "", &SMContextRejection{
+				Err:     err,
+				Cause:   createErr.Error.Cause,
+				N1SmMsg: rsp.Part(createErr.N1SmMsg.ContentID),
+			}
+		}
+		return "", err
+	}
+	if location == "" {
+		return "", fmt.Errorf("SM context created without location")
+	}
+	if strings.HasPrefix(location, "/") {
+		location = c.root + location
+	}
+	return location, nil
+}
+
+// UpdateSMContext implements SMF. N2 SM information is sent and received
+// as a binary part.
+func (c *smfClient) UpdateSMContext(ctx context.Context, smContextRef string, data models.SmContextUpdateData) (*models.SmContextUpdatedData, error) {
+	body := &sbi.Multipart{JSON: &data}
+	if len(data.BinaryDataN2SmInformation) > 0 {
+		data.N2SmInfo = &models.RefToBinaryData{ContentID: "n2SmInfo"}
+		body.Parts = []sbi.Part{{ContentID: "n2SmInfo", ContentType: sbi.ContentTypeNGAP, Body: data.BinaryDataN2SmInformation}}
+	}
+
+	updated := &models.SmContextUpdatedData{}
+	rsp := &sbi.Multipart{JSON: updated}
+	if err := c.client.Post(ctx, smContextRef+"/modify", body, rsp); err != nil {
+		return nil, err
+	}
+	if updated.N2SmInfo != nil {
+		updated.BinaryDataN2SmInformation = rsp.Part(updated.N2SmInfo.ContentID)
+	}
+	return updated, nil
+}
+
+// ReleaseSMContext implements SMF
+func (c *smfClient) ReleaseSMContext(ctx context.Context, smContextRef string, data models.SmContextReleaseData) error {
+	return c.client.Post(ctx, smContextRef+"/release", data, nil)
+}
+
+// notifier posts notifications to the callback URIs of other NFs
+type notifier struct {
+	client *sbi.Client
+}
+
+// NotifyN1N2TransferFailure implements Notifier
+func (n *notifier) NotifyN1N2TransferFailure(ctx context.Context, uri string, notification models.N1N2MsgTxfrFailureNotification) error {
+	return n.client.Post(ctx, uri, notification, nil)
+}
+
+// NotifyN1Message implements Notifier. The N1 message is sent as a
+// binary part referred to by the container.
+func (n *notifier) NotifyN1Message(ctx context.Context, uri string, notification models.N1MessageNotification) error {
+	notification.N1MessageContainer.N1MessageContent.ContentID = "n1msg"
+	return n.client.Post(ctx, uri, &sbi.Multipart{
+		JSON: notification,
+		Parts: []sbi.Part{{
+			ContentID:   "n1msg",
+			ContentType: sbi.ContentTypeNAS,
+			Body:        notification.BinaryDataN1Message,
+		}},
+	}, nil)
+}
+
+// NotifyEvent implements Notifier
+func (n *notifier) NotifyEvent(ctx context.Context, uri string, notification models.AmfEventNotification) error {
+	return n.client.Post(ctx, uri, notification, nil)
+}
+
+// jsonQuery returns a query holding v encoded as JSON, the encoding of
+// structured SBI query parameters
+func jsonQuery(name string, v interface{}) (url.Values, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", name, err)
+	}
+	return url.Values{name: []string{string(b)}}, nil
+}
@@ -0,0 +1,244 @@
+package amf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"go.uber.org/zap"
+)
+
+// eventsPrefix is the path of the subscriptions of Namf_EventExposure
+const eventsPrefix = "/namf-evts/v1/subscriptions"
+
+// eventSubscription is a subscription of another NF to AMF events
+type eventSubscription struct {
+	id  string
+	sub models.AmfEventSubscription
+}
+
+// wants reports whether the subscription asks for an event of the UE
+func (s *eventSubscription) wants(ue *UE, event models.AmfEventType) bool {
+	if !s.sub.AnyUE && s.sub.Supi != ue.SUPI {
+		return false
+	}
+	for _, e := range s.sub.EventList {
+		if e.Type == event {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEventSubscriptions serves the subscriptions collection of
+// Namf_EventExposure (TS 29.518 5.3)
+func (a *AMF) handleEventSubscriptions(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, eventsPrefix), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		a.createEventSubscription(w, r)
+	case id != "" && !strings.Contains(id, "/") && r.Method == http.MethodDelete:
+		if !a.deleteEventSubscription(id) {
+			sbi.WriteError(w, apperrors.NewNotFoundError("Subscription not found", nil))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case id == "" || !strings.Contains(id, "/"):
+		methodNotAllowed(w, r)
+	default:
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+	}
+}
+
+// createEventSubscription subscribes another NF to AMF events. The
+// events of a single UE asking for an immediate report are reported in
+// the answer.
+func (a *AMF) createEventSubscription(w http.ResponseWriter, r *http.Request) {
+	var req models.AmfCreateEventSubscription
+	if _, err := sbi.ReadMultipart(r, &req); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	sub := req.Subscription
+	if err := checkEventSubscription(sub); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+
+	var reports []models.AmfEventReport
+	if !sub.AnyUE {
+		ue, ok := a.UE(sub.Supi)
+		if !ok {
+			sbi.WriteError(w, apperrors.NewNotFoundError("UE context not found", nil))
+			return
+		}
+		done := make(chan []models.AmfEventReport, 1)
+		ue.run(func() {
+			var immediate []models.AmfEventReport
+			for _, e := range sub.EventList {
+				if e.ImmediateFlag {
+					immediate = append(immediate, eventReport(ue, e.Type))
+				}
+			}
+			done <- immediate
+		})
+		reports = <-done
+	}
+
+	a.eventsMu.Lock()
+	a.nextEventSubID++
+	s := &eventSubscription{id: strconv.FormatUint(a.nextEventSubID, 10), sub: sub}
+	a.eventSubs[s.id] = s
+	a.eventsMu.Unlock()
+
+	a.log.Info("Event subscription created", zap.String("subscription_id", s.id), zap.String("nf_id", sub.NfID),
+		zap.Int("events", len(sub.EventList)))
+	w.Header().Set("Location", a.apiRoot()+eventsPrefix+"/"+s.id)
+	sbi.WriteJSON(w, http.StatusCreated, models.AmfCreatedEventSubscription{
+		Subscription:   sub,
+		SubscriptionID: s.id,
+		ReportList:     reports,
+	})
+}
+
+// checkEventSubscription checks that a subscription targets UEs with
+// events the AMF reports
+func checkEventSubscription(sub models.AmfEventSubscription) error {
+	if sub.EventNotifyURI == "" {
+		return apperrors.NewBadRequestError("missing event notification URI", nil)
+	}
+	if sub.Supi == "" && !sub.AnyUE {
+		return apperrors.NewBadRequestError("subscription without SUPI or anyUE", nil)
+	}
+	if len(sub.EventList) == 0 {
+		return apperrors.NewBadRequestError("empty event list", nil)
+	}
+	for _, e := range sub.EventList {
+		switch e.Type {
+		case models.AmfEventLocationReport, models.AmfEventReachabilityReport, models.AmfEventRegistrationStateReport:
+		default:
+			return apperrors.NewBadRequestError("unsupported event "+string(e.Type), nil)
+		}
+	}
+	return nil
+}
+
+// deleteEventSubscription removes a subscription and reports whether it
+// existed
+func (a *AMF) deleteEventSubscription(id string) bool {
+	a.eventsMu.Lock()
+	defer a.eventsMu.Unlock()
+
+	if _, ok := a.eventSubs[id]; !ok {
+		return false
+	}
+	delete(a.eventSubs, id)
+	a.log.Info("Event subscription deleted", zap.String("subscription_id", id))
+	return true
+}
+
+// report notifies the subscribers of an event of the UE. Notifications
+// are sent in the background so that slow subscribers do not hold up
+// the procedures of the UE.
+func (a *AMF) report(ue *UE, event models.AmfEventType) {
+	if ue.SUPI == "" || a.nfs.Notifier == nil {
+		return
+	}
+
+	a.eventsMu.RLock()
+	var subs []*eventSubscription
+	for _, s := range a.eventSubs {
+		if s.wants(ue, event) {
+			subs = append(subs, s)
+		}
+	}
+	a.eventsMu.RUnlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	report := eventReport(ue, event)
+	for _, s := range subs {
+		s := s
+		go func() {
+			err := a.nfs.Notifier.NotifyEvent(context.Background(), s.sub.EventNotifyURI, models.AmfEventNotification{
+				NotifyCorrelationID: s.sub.NotifyCorrelationID,
+				ReportList:          []models.AmfEventReport{report},
+			})
+			if err != nil {
+				ue.log.Warn("Failed to notify event", zap.String("subscription_id", s.id),
+					zap.String("event", string(event)), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// eventReport returns the current state of the UE for an event
+func eventReport(ue *UE, event models.AmfEventType) models.AmfEventReport {
+	r := models.AmfEventReport{
+		Type:      event,
+		State:     models.AmfEventState{Active: true},
+		TimeStamp: time.Now(),
+		Supi:      ue.SUPI,
+	}
+
+	switch event {
+	case models.AmfEventLocationReport:
+		r.Location = &models.UserLocation{NrLocation: &models.NrLocation{Tai: ue.TAI, Ncgi: ue.NCGI}}
+	case models.AmfEventReachabilityReport:
+		r.Reachability = models.UeUnreachable
+		if ue.reachable {
+			r.Reachability = models.UeReachable
+		}
+	case models.AmfEventRegistrationStateReport:
+		state := models.RmStateDeregistered
+		if rm, _ := ue.State(); rm == RMRegistered {
+			state = models.RmStateRegistered
+		}
+		r.RmInfoList = []models.RmInfo{{RmState: state, AccessType: models.AccessType3GPP}}
+	}
+	return r
+}
+
+// setLocation records the location of the UE reported by the gNB
+func (a *AMF) setLocation(ue *UE, uli ngap.UserLocationInformation) {
+	tai := uli.TAI.Model()
+	ncgi := models.Ncgi{
+		PlmnID:   uli.NRCGI.PLMNIdentity,
+		NrCellID: fmt.Sprintf("%09x", uli.NRCGI.NRCellIdentity),
+	}
+	if tai == ue.TAI && ncgi == ue.NCGI {
+		return
+	}
+	ue.TAI, ue.NCGI = tai, ncgi
+	a.report(ue, models.AmfEventLocationReport)
+}
+
+// setRMState moves the UE to a registration management state, reporting
+// the change to subscribers. A registered UE is reachable, a
+// deregistered one is not.
+func (a *AMF) setRMState(ue *UE, s RMState) {
+	if rm, _ := ue.State(); rm == s {
+		return
+	}
+	ue.setRMState(s)
+	a.report(ue, models.AmfEventRegistrationStateReport)
+	a.setReachable(ue, s == RMRegistered)
+}
+
+// setReachable records whether the UE can be reached, reporting changes
+// to subscribers
+func (a *AMF) setReachable(ue *UE, reachable bool) {
+	if ue.reachable == reachable {
+		return
+	}
+	ue.reachable = reachable
+	a.report(ue, models.AmfEventReachabilityReport)
+}
@@ -0,0 +1,182 @@
+package amf
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+// ueEventSubscription returns a subscription of the test UE to events,
+// reported at once
+func ueEventSubscription(events ...models.AmfEventType) models.AmfCreateEventSubscription {
+	sub := models.AmfEventSubscription{
+		EventNotifyURI:      "http://nef.test/events",
+		NotifyCorrelationID: "1",
+		NfID:                "nef-test",
+		Supi:                testSUPI,
+	}
+	for _, e := range events {
+		sub.EventList = append(sub.EventList, models.AmfEvent{Type: e, ImmediateFlag: true})
+	}
+	return models.AmfCreateEventSubscription{Subscription: sub}
+}
+
+func TestEventSubscriptionHandlers(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// edit changes a subscription of the test UE to its registration
+		// state
+		edit func(sub *models.AmfEventSubscription)
+
+		status  int
+		problem apperrors.ErrorType
+		reports []models.AmfEventType
+	}{
+		{
+			name:    "subscription of a UE",
+			status:  http.StatusCreated,
+			reports: []models.AmfEventType{models.AmfEventRegistrationStateReport},
+		},
+		{
+			name: "subscription of a UE to several events",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.EventList = append(sub.EventList,
+					models.AmfEvent{Type: models.AmfEventLocationReport, ImmediateFlag: true},
+					models.AmfEvent{Type: models.AmfEventReachabilityReport})
+			},
+			status:  http.StatusCreated,
+			reports: []models.AmfEventType{models.AmfEventRegistrationStateReport, models.AmfEventLocationReport},
+		},
+		{
+			name: "subscription of any UE",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.Supi, sub.AnyUE = "", true
+			},
+			status: http.StatusCreated,
+		},
+		{
+			name: "subscription without notification URI",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.EventNotifyURI = ""
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name: "subscription without UE",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.Supi = ""
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name: "subscription without event",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.EventList = nil
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name: "subscription to an unsupported event",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.EventList = append(sub.EventList, models.AmfEvent{Type: "UES_IN_AREA_REPORT"})
+			},
+			status:  http.StatusBadRequest,
+			problem: apperrors.ErrorTypeBadRequest,
+		},
+		{
+			name: "subscription of an unknown UE",
+			edit: func(sub *models.AmfEventSubscription) {
+				sub.Supi = "imsi-208930000000099"
+			},
+			status:  http.StatusNotFound,
+			problem: apperrors.ErrorTypeNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, root := registeredUE(t)
+			req := ueEventSubscription(models.AmfEventRegistrationStateReport)
+			if tt.edit != nil {
+				tt.edit(&req.Subscription)
+			}
+
+			rsp := sbiCall(t, http.MethodPost, root+eventsPrefix, req)
+			if rsp.status != tt.status {
+				t.Fatalf("status = %d %s, want %d", rsp.status, rsp.body, tt.status)
+			}
+			if tt.problem != "" {
+				if got := rsp.problem(t); got != tt.problem {
+					t.Errorf("problem type = %s, want %s", got, tt.problem)
+				}
+				return
+			}
+
+			var created models.AmfCreatedEventSubscription
+			if err := json.Unmarshal(rsp.body, &created); err != nil {
+				t.Fatal(err)
+			}
+			if want := u.h.amf.apiRoot() + eventsPrefix + "/" + created.SubscriptionID; created.SubscriptionID == "" || rsp.location != want {
+				t.Errorf("subscription %q at %q, want one at %q", created.SubscriptionID, rsp.location, want)
+			}
+			if len(created.ReportList) != len(tt.reports) {
+				t.Fatalf("reports = %+v, want %v", created.ReportList, tt.reports)
+			}
+			for i, r := range created.ReportList {
+				if r.Type != tt.reports[i] || r.Supi != testSUPI {
+					t.Errorf("report %d = %s of %s, want %s of %s", i, r.Type, r.Supi, tt.reports[i], testSUPI)
+				}
+				if r.Type == models.AmfEventRegistrationStateReport &&
+					(len(r.RmInfoList) != 1 || r.RmInfoList[0].RmState != models.RmStateRegistered) {
+					t.Errorf("registration state = %+v, want registered", r.RmInfoList)
+				}
+			}
+		})
+	}
+}
+
+func TestEventSubscriptionResources(t *testing.T) {
+	u, root := registeredUE(t)
+	rsp := sbiCall(t, http.MethodPost, root+eventsPrefix, ueEventSubscription(models.AmfEventReachabilityReport))
+	if rsp.status != http.StatusCreated {
+		t.Fatalf("subscription status = %d %s, want %d", rsp.status, rsp.body, http.StatusCreated)
+	}
+	subscription := strings.TrimPrefix(rsp.location, u.h.amf.apiRoot())
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+
+		status  int
+		problem apperrors.ErrorType
+	}{
+		{"read collection", http.MethodGet, eventsPrefix, http.StatusMethodNotAllowed, apperrors.ErrorTypeBadRequest},
+		{"post to subscription", http.MethodPost, subscription, http.StatusMethodNotAllowed, apperrors.ErrorTypeBadRequest},
+		{"below subscription", http.MethodDelete, subscription + "/events", http.StatusNotFound, apperrors.ErrorTypeNotFound},
+		{"delete unknown subscription", http.MethodDelete, eventsPrefix + "/99", http.StatusNotFound, apperrors.ErrorTypeNotFound},
+		{"delete subscription", http.MethodDelete, subscription, http.StatusNoContent, ""},
+		{"delete subscription again", http.MethodDelete, subscription, http.StatusNotFound, apperrors.ErrorTypeNotFound},
+	}
+	// The cases run in order against the same subscription
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := sbiCall(t, tt.method, root+tt.path, nil)
+			if rsp.status != tt.status {
+				t.Fatalf("status = %d %s, want %d", rsp.status, rsp.body, tt.status)
+			}
+			if tt.problem != "" {
+				if got := rsp.problem(t); got != tt.problem {
+					t.Errorf("problem type = %s, want %s", got, tt.problem)
+				}
+			}
+		})
+	}
+}
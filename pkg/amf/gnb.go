@@ -0,0 +1,87 @@
+package amf
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/ngap/transport"
+	"go.uber.org/zap"
+)
+
+// GNB is the context of a gNB connected over N2
+type GNB struct {
+	// Set by a successful NG Setup
+	ID           ngap.GlobalRANNodeID
+	Name         string
+	SupportedTAs []ngap.SupportedTAItem
+	PagingDRX    ngap.PagingDRX
+
+	conn  transport.Conn
+	log   *zap.Logger
+	mu    sync.RWMutex
+	setUp bool
+}
+
+// Key returns the identifier of the gNB, unique across PLMNs
+func (g *GNB) Key() string {
+	return gnbKey(g.ID)
+}
+
+// gnbKey formats a global gNB ID as "plmn-gnbid/length"
+func gnbKey(id ngap.GlobalRANNodeID) string {
+	return fmt.Sprintf("%s-%x/%d", id.PLMNIdentity, id.GNBID, id.GNBIDLength)
+}
+
+// RemoteAddr returns the address of the gNB
+func (g *GNB) RemoteAddr() string {
+	return g.conn.RemoteAddr().String()
+}
+
+// IsSetUp reports whether NG Setup completed
+func (g *GNB) IsSetUp() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.setUp
+}
+
+// ServesTAI reports whether the gNB broadcasts the tracking area
+func (g *GNB) ServesTAI(tai ngap.TAI) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, ta := range g.SupportedTAs {
+		if ta.TAC != tai.TAC {
+			continue
+		}
+		for _, p := range ta.BroadcastPLMNList {
+			if p.PLMNIdentity == tai.PLMNIdentity {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Send encodes and sends an NGAP message to the gNB
+func (g *GNB) Send(msg ngap.Message) error {
+	b, err := ngap.Encode(msg)
+	if err != nil {
+		return err
+	}
+	g.log.Debug("Sending NGAP message", zap.String("message", fmt.Sprintf("%T", msg)))
+	return g.conn.WriteMsg(b)
+}
+
+// setup records the outcome of a successful NG Setup
+func (g *GNB) setup(req *ngap.NGSetupRequest) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.ID = req.GlobalRANNodeID
+	g.Name = req.RANNodeName
+	g.SupportedTAs = req.SupportedTAList
+	g.PagingDRX = req.DefaultPagingDRX
+	g.setUp = true
+	g.log = g.log.With(zap.String("gnb", g.Key()))
+}
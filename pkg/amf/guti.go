@@ -0,0 +1,480 @@
+package amf
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// reservedTMSI is never allocated: a USIM stores all ones when it holds
+// no valid TMSI (TS 23.003 2.4)
+const reservedTMSI = 0xffffffff
+
+// maxTMSIAttempts bounds the random draws of an allocation
+const maxTMSIAttempts = 64
+
+// gutiAllocator allocates the 5G-GUTIs of the AMF from its served GUAMIs
+// and maps them back to UE contexts. Released 5G-TMSIs are held back for
+// a delay so a UE still using a stale GUTI is not taken for the next
+// holder. Allocations are logged to a file when one is configured, so UEs
+// keep their GUTI across AMF restarts.
+type gutiAllocator struct {
+	guamis     []ngap.GUAMI
+	path       string
+	reuseDelay time.Duration
+	log        *zap.Logger
+
+	mu       sync.Mutex
+	tmsis    map[uint32]*gutiEntry
+	released map[uint32]time.Time // end of the reuse delay
+	pending  []gutiRecord         // not yet written to the store
+	pruned   time.Time            // last removal of expired reuse delays
+
+	// store is the log file, appended to by the writer goroutine only
+	store *os.File
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// gutiEntry is an allocated 5G-GUTI. Entries restored from the file have
+// no UE context until the UE comes back.
+type gutiEntry struct {
+	guti nas.GUTI
+	supi string
+	ue   *UE
+}
+
+// gutiRecord is a line of the 5G-GUTI store. The store is a log of JSON
+// lines: a header with the AMF identifier, then the allocations and
+// releases in the order they were made. It is compacted when the AMF
+// starts.
+type gutiRecord struct {
+	Op          string         `json:"op"`
+	AMFRegionID uint8          `json:"amfRegionId,omitempty"`
+	AMFSetID    uint16         `json:"amfSetId,omitempty"`
+	AMFPointer  uint8          `json:"amfPointer,omitempty"`
+	PlmnID      *models.PlmnID `json:"plmnId,omitempty"`
+	TMSI        uint32         `json:"tmsi,omitempty"`
+	Supi        string         `json:"supi,omitempty"`
+	Until       *time.Time     `json:"until,omitempty"` // end of the reuse delay of a release
+}
+
+// Operations of the 5G-GUTI store records
+const (
+	gutiOpAMF      = "amf"
+	gutiOpAllocate = "allocate"
+	gutiOpRelease  = "release"
+)
+
+// newGUTIAllocator creates the allocator of the AMF, restoring the
+// allocations logged in the configured file and starting to log the new
+// ones
+func newGUTIAllocator(cfg *Config, log *zap.Logger) (*gutiAllocator, error) {
+	if len(cfg.ServedGUAMIs) == 0 {
+		return nil, fmt.Errorf("no served GUAMI configured")
+	}
+
+	g := &gutiAllocator{
+		guamis:     cfg.ServedGUAMIs,
+		path:       cfg.GUTIStore,
+		reuseDelay: cfg.TMSIReuseDelay,
+		log:        log,
+		tmsis:      make(map[uint32]*gutiEntry),
+		released:   make(map[uint32]time.Time),
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if g.path != "" {
+		if err := g.load(); err != nil {
+			return nil, err
+		}
+		go g.run()
+	}
+	return g, nil
+}
+
+// guami returns the GUAMI of the AMF in a PLMN
+func (g *gutiAllocator) guami(plmn models.PlmnID) (ngap.GUAMI, bool) {
+	for _, guami := range g.guamis {
+		if guami.PLMNIdentity == plmn {
+			return guami, true
+		}
+	}
+	return ngap.GUAMI{}, false
+}
+
+// served reports whether an AMF set and pointer identify this AMF. The
+// served GUAMIs share them, only the PLMN differs.
+func (g *gutiAllocator) served(setID uint16, pointer uint8) bool {
+	return g.guamis[0].AMFSetID == setID && g.guamis[0].AMFPointer == pointer
+}
+
+// allocate gives the UE a new 5G-GUTI in a PLMN. The 5G-TMSI is drawn at
+// random so it cannot be predicted from earlier ones (TS 33.501 6.12.3).
+func (g *gutiAllocator) allocate(ue *UE, plmn models.PlmnID) (*nas.GUTI, error) {
+	guami, ok := g.guami(plmn)
+	if !ok {
+		return nil, fmt.Errorf("no GUAMI served in PLMN %s", plmn)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.pruneReleased(now)
+	for i := 0; i < maxTMSIAttempts; i++ {
+		var b [4]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			return nil, fmt.Errorf("failed to draw 5G-TMSI: %w", err)
+		}
+		tmsi := binary.BigEndian.Uint32(b[:])
+		if tmsi == reservedTMSI || g.tmsis[tmsi] != nil {
+			continue
+		}
+		if until, ok := g.released[tmsi]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(g.released, tmsi)
+		}
+
+		guti := nas.GUTI{
+			PlmnID:      guami.PLMNIdentity,
+			AMFRegionID: guami.AMFRegionID,
+			AMFSetID:    guami.AMFSetID,
+			AMFPointer:  guami.AMFPointer,
+			TMSI:        tmsi,
+		}
+		g.tmsis[tmsi] = &gutiEntry{guti: guti, supi: ue.SUPI, ue: ue}
+		plmn := guti.PlmnID
+		g.record(gutiRecord{Op: gutiOpAllocate, PlmnID: &plmn, TMSI: tmsi, Supi: ue.SUPI})
+		return &guti, nil
+	}
+	return nil, fmt.Errorf("no free 5G-TMSI after %d attempts", maxTMSIAttempts)
+}
+
+// release frees a 5G-GUTI of the UE. The 5G-TMSI is held back for the
+// reuse delay.
+func (g *gutiAllocator) release(ue *UE, guti *nas.GUTI) {
+	if guti == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e := g.tmsis[guti.TMSI]
+	if e == nil || e.ue != ue {
+		return
+	}
+	delete(g.tmsis, guti.TMSI)
+
+	now := time.Now()
+	g.pruneReleased(now)
+
+	rec := gutiRecord{Op: gutiOpRelease, TMSI: guti.TMSI}
+	if g.reuseDelay > 0 {
+		until := now.Add(g.reuseDelay)
+		g.released[guti.TMSI] = until
+		rec.Until = &until
+	}
+	g.record(rec)
+}
+
+// lookup returns the allocation of a 5G-TMSI given with the AMF set and
+// pointer of a 5G-S-TMSI or 5G-GUTI
+func (g *gutiAllocator) lookup(setID uint16, pointer uint8, tmsi uint32) (gutiEntry, bool) {
+	if !g.served(setID, pointer) {
+		return gutiEntry{}, false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e := g.tmsis[tmsi]
+	if e == nil {
+		return gutiEntry{}, false
+	}
+	return *e, true
+}
+
+// attach binds a restored 5G-GUTI to the new context of its UE. It
+// returns the context bound first when another message raced for it.
+func (g *gutiAllocator) attach(tmsi uint32, ue *UE) *UE {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	e := g.tmsis[tmsi]
+	if e == nil {
+		return ue
+	}
+	if e.ue == nil {
+		e.ue = ue
+	}
+	return e.ue
+}
+
+// load restores the allocations logged in the file, then compacts it
+// and opens it for appending
+func (g *gutiAllocator) load() error {
+	f, err := os.Open(g.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read 5G-GUTI store: %w", err)
+	default:
+		err = g.replay(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read 5G-GUTI store: %w", err)
+		}
+	}
+
+	if err := g.compact(); err != nil {
+		return fmt.Errorf("failed to compact 5G-GUTI store: %w", err)
+	}
+	if g.store, err = os.OpenFile(g.path, os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return fmt.Errorf("failed to open 5G-GUTI store: %w", err)
+	}
+
+	g.log.Info("Restored 5G-GUTIs", zap.Int("gutis", len(g.tmsis)), zap.Int("held_back", len(g.released)))
+	return nil
+}
+
+// replay applies the records of the store in order. Allocations made
+// under other AMF identifiers are dropped: UEs holding them are no longer
+// routed here. Records that cannot be parsed, such as one cut short by a
+// crash, are skipped.
+func (g *gutiAllocator) replay(r io.Reader) error {
+	now := time.Now()
+	header := false
+	skipped := 0
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		var rec gutiRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			skipped++
+			continue
+		}
+
+		switch rec.Op {
+		case gutiOpAMF:
+			guami := g.guamis[0]
+			if rec.AMFRegionID != guami.AMFRegionID || !g.served(rec.AMFSetID, rec.AMFPointer) {
+				g.log.Warn("Dropping 5G-GUTIs allocated under another AMF identifier")
+				return nil
+			}
+			header = true
+		case gutiOpAllocate:
+			if !header || rec.PlmnID == nil {
+				skipped++
+				continue
+			}
+			guami, ok := g.guami(*rec.PlmnID)
+			if !ok {
+				continue
+			}
+			g.tmsis[rec.TMSI] = &gutiEntry{
+				guti: nas.GUTI{
+					PlmnID:      guami.PLMNIdentity,
+					AMFRegionID: guami.AMFRegionID,
+					AMFSetID:    guami.AMFSetID,
+					AMFPointer:  guami.AMFPointer,
+					TMSI:        rec.TMSI,
+				},
+				supi: rec.Supi,
+			}
+			delete(g.released, rec.TMSI)
+		case gutiOpRelease:
+			if !header {
+				skipped++
+				continue
+			}
+			delete(g.tmsis, rec.TMSI)
+			if rec.Until != nil && now.Before(*rec.Until) {
+				g.released[rec.TMSI] = *rec.Until
+			}
+		default:
+			skipped++
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if skipped > 0 {
+		g.log.Warn("Skipped invalid 5G-GUTI store records", zap.Int("records", skipped))
+	}
+	return nil
+}
+
+// compact replaces the store with the header and one record per
+// allocated or held back 5G-TMSI
+func (g *gutiAllocator) compact() error {
+	guami := g.guamis[0]
+	recs := []gutiRecord{{
+		Op:          gutiOpAMF,
+		AMFRegionID: guami.AMFRegionID,
+		AMFSetID:    guami.AMFSetID,
+		AMFPointer:  guami.AMFPointer,
+	}}
+	for tmsi, e := range g.tmsis {
+		plmn := e.guti.PlmnID
+		recs = append(recs, gutiRecord{Op: gutiOpAllocate, PlmnID: &plmn, TMSI: tmsi, Supi: e.supi})
+	}
+	for tmsi, until := range g.released {
+		until := until
+		recs = append(recs, gutiRecord{Op: gutiOpRelease, TMSI: tmsi, Until: &until})
+	}
+
+	b, err := encodeRecords(recs)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(g.path, b)
+}
+
+// record queues a record for the store writer. g.mu is held.
+func (g *gutiAllocator) record(rec gutiRecord) {
+	if g.store == nil {
+		return
+	}
+	g.pending = append(g.pending, rec)
+	select {
+	case g.flush <- struct{}{}:
+	default:
+	}
+}
+
+// run appends the queued records to the store until close. Records
+// queued while a write is syncing go out together in the next one.
+func (g *gutiAllocator) run() {
+	defer close(g.done)
+
+	for {
+		select {
+		case <-g.flush:
+			g.write()
+		case <-g.stop:
+			g.write()
+			return
+		}
+	}
+}
+
+// write appends the queued records to the store and syncs it, outside
+// g.mu so that allocations do not wait for the disk. Failures are
+// logged: the AMF keeps serving, only without these allocations
+// surviving a restart.
+func (g *gutiAllocator) write() {
+	g.mu.Lock()
+	recs := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	if len(recs) == 0 {
+		return
+	}
+	b, err := encodeRecords(recs)
+	if err == nil {
+		if _, err = g.store.Write(b); err == nil {
+			err = g.store.Sync()
+		}
+	}
+	if err != nil {
+		g.log.Error("Failed to save 5G-GUTIs", zap.String("path", g.path), zap.Error(err))
+	}
+}
+
+// pruneReleased forgets the 5G-TMSIs whose reuse delay is over, at most
+// once per delay, so the held back ones do not pile up with or without a
+// store. g.mu is held.
+func (g *gutiAllocator) pruneReleased(now time.Time) {
+	if now.Sub(g.pruned) < g.reuseDelay {
+		return
+	}
+	for tmsi, until := range g.released {
+		if !now.Before(until) {
+			delete(g.released, tmsi)
+		}
+	}
+	g.pruned = now
+}
+
+// close writes the queued records and closes the store
+func (g *gutiAllocator) close() {
+	if g.store == nil {
+		return
+	}
+	close(g.stop)
+	<-g.done
+
+	if err := g.store.Close(); err != nil {
+		g.log.Error("Failed to close 5G-GUTI store", zap.String("path", g.path), zap.Error(err))
+	}
+}
+
+// encodeRecords encodes store records as JSON lines
+func encodeRecords(recs []gutiRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileSync writes b to a temporary file renamed over path, syncing
+// the file and then its directory so the rename survives a crash
+func writeFileSync(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,157 @@
+package amf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// newTestGUTIs creates an allocator of an AMF keeping its 5G-GUTIs in path
+func newTestGUTIs(t *testing.T, path string, pointer uint8) *gutiAllocator {
+	t.Helper()
+	g, err := newGUTIAllocator(&Config{
+		ServedGUAMIs:   []ngap.GUAMI{{PLMNIdentity: testPLMN, AMFRegionID: 1, AMFSetID: 1, AMFPointer: pointer}},
+		GUTIStore:      path,
+		TMSIReuseDelay: time.Hour,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newGUTIAllocator() error = %v", err)
+	}
+	return g
+}
+
+// lines returns the records of the store
+func lines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Split(bytes.TrimSuffix(b, []byte("\n")), []byte("\n"))
+}
+
+func TestGUTIStoreRestore(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// corrupt alters the store before the restart
+		corrupt func(t *testing.T, path string)
+		pointer uint8
+
+		kept bool
+	}{
+		{
+			name: "restart",
+			kept: true,
+		},
+		{
+			name: "torn last record",
+			corrupt: func(t *testing.T, path string) {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				if _, err := f.WriteString(`{"op":"release","tm`); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kept: true,
+		},
+		{
+			name:    "other AMF pointer",
+			pointer: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "amf", "gutis")
+
+			g := newTestGUTIs(t, path, 0)
+			kept, err := g.allocate(&UE{SUPI: testSUPI}, testPLMN)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ue := &UE{SUPI: "imsi-208930000000002"}
+			freed, err := g.allocate(ue, testPLMN)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.release(ue, freed)
+			g.close()
+			if n := len(lines(t, path)); n != 4 {
+				t.Fatalf("%d records logged, want 4", n)
+			}
+
+			if tt.corrupt != nil {
+				tt.corrupt(t, path)
+			}
+			g = newTestGUTIs(t, path, tt.pointer)
+			defer g.close()
+
+			e, ok := g.lookup(1, tt.pointer, kept.TMSI)
+			if ok != tt.kept {
+				t.Fatalf("5G-GUTI restored = %v, want %v", ok, tt.kept)
+			}
+			if !tt.kept {
+				if n := len(lines(t, path)); n != 1 {
+					t.Errorf("%d records after compaction, want the header only", n)
+				}
+				return
+			}
+			if e.guti != *kept || e.supi != testSUPI {
+				t.Errorf("restored %s of %s, want %s of %s", e.guti, e.supi, kept, testSUPI)
+			}
+			if _, ok := g.lookup(1, 0, freed.TMSI); ok {
+				t.Errorf("released 5G-GUTI %s restored", freed)
+			}
+			if _, ok := g.released[freed.TMSI]; !ok {
+				t.Errorf("released 5G-TMSI %08x not held back", freed.TMSI)
+			}
+			if n := len(lines(t, path)); n != 3 {
+				t.Errorf("%d records after compaction, want 3", n)
+			}
+		})
+	}
+}
+
+// TestGUTIReleasedPruned checks that the 5G-TMSIs held back by an
+// allocator without store are forgotten once their reuse delay is over
+func TestGUTIReleasedPruned(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	g, err := newGUTIAllocator(&Config{
+		ServedGUAMIs:   []ngap.GUAMI{{PLMNIdentity: testPLMN, AMFRegionID: 1, AMFSetID: 1}},
+		TMSIReuseDelay: delay,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("newGUTIAllocator() error = %v", err)
+	}
+	defer g.close()
+
+	churn := func(n int) {
+		for i := 0; i < n; i++ {
+			ue := &UE{SUPI: testSUPI}
+			guti, err := g.allocate(ue, testPLMN)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.release(ue, guti)
+		}
+	}
+
+	churn(100)
+	if n := len(g.released); n != 100 {
+		t.Fatalf("%d 5G-TMSIs held back, want 100", n)
+	}
+
+	time.Sleep(2 * delay)
+	churn(1)
+	if n := len(g.released); n != 1 {
+		t.Errorf("%d 5G-TMSIs held back after their delay, want the last one", n)
+	}
+}
@@ -0,0 +1,492 @@
+package amf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// unlimitedBitRate is the largest NGAP bit rate, given as UE-AMBR when
+// the subscription sets none
+const unlimitedBitRate = 4000000000000
+
+// Values of the handover_type metric label
+const (
+	handoverXn = "xn"
+	handoverN2 = "n2"
+)
+
+// Errors relaying the N2 SM information of a PDU session
+var (
+	errUnknownSession = errors.New("unknown PDU session")
+	errNoSMF          = errors.New("no SMF to relay N2 SM information to")
+)
+
+// handover is an N2 handover in progress (TS 23.502 4.9.1.3). The UE
+// stays bound to the source gNB until the target notifies its arrival.
+type handover struct {
+	handoverType ngap.HandoverType
+
+	source      *GNB
+	sourceAMFID int64
+	sourceRANID int64
+
+	target      *GNB
+	targetAMFID int64
+	targetRANID int64
+
+	// acknowledged tells that the target gNB allocated its resources and
+	// targetRANID is known
+	acknowledged bool
+
+	// sessions holds the PDU sessions being handed over
+	sessions []uint8
+}
+
+// handlePathSwitchRequest moves the N2 connection and the user plane of
+// the UE to the target gNB of an Xn handover (TS 23.502 4.9.1.2.2). The
+// target gets the next hop key of the UE; the source gNB releases the UE
+// by itself.
+func (a *AMF) handlePathSwitchRequest(ue *UE, gnb *GNB, m *ngap.PathSwitchRequest) {
+	reject := func(reason string, cause ngap.Cause) {
+		ue.log.Warn("Rejecting path switch", zap.String("reason", reason), zap.String("gnb", gnb.Key()))
+		a.recordHandover(handoverXn, false)
+		rejectPathSwitch(gnb, m, cause)
+	}
+
+	rm, _ := ue.State()
+	switch {
+	case ue.gnb == nil || ue.amfUENGAPID != m.SourceAMFUENGAPID:
+		reject("UE not connected over the source AMF UE NGAP ID", ngap.CauseRadioNetworkUnknownLocalUEID)
+		return
+	case rm != RMRegistered || ue.Security == nil:
+		reject("UE not registered", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	case ue.handover != nil:
+		reject("N2 handover in progress", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	case !a.config.SupportsTAI(m.UserLocationInformation.TAI):
+		reject("target tracking area not served", ngap.CauseRadioNetworkHOTargetNotAllowed)
+		return
+	}
+
+	tai := m.UserLocationInformation.TAI.Model()
+	var switched, released []ngap.PDUSessionResourceItem
+	for _, item := range m.ToBeSwitched {
+		transfer, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			Tai:                       &tai,
+			ToBeSwitched:              true,
+			N2SmInfoType:              models.N2SmInfoPathSwitchReq,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Warn("PDU session not switched", zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+			released = append(released, unsuccessfulTransfer(item.PDUSessionID, sessionFailureCause(err)))
+			continue
+		}
+		switched = append(switched, ngap.PDUSessionResourceItem{PDUSessionID: item.PDUSessionID, Transfer: transfer})
+	}
+	for _, item := range m.FailedToSetup {
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			FailedToBeSwitched:        true,
+			N2SmInfoType:              models.N2SmInfoPathSwitchSetupFail,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Debug("Failed to report PDU session not set up in target gNB",
+				zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+		}
+	}
+	if len(switched) == 0 {
+		reject("no PDU session switched", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	}
+
+	source := ue.gnb
+	ue.gnb, ue.ranUENGAPID = gnb, m.RANUENGAPID
+	a.setLocation(ue, m.UserLocationInformation)
+	ue.asContext = true
+
+	ack := &ngap.PathSwitchRequestAcknowledge{
+		AMFUENGAPID:     ue.amfUENGAPID,
+		RANUENGAPID:     ue.ranUENGAPID,
+		SecurityContext: ue.Security.nextHop(),
+		Switched:        switched,
+		Released:        released,
+		AllowedNSSAI:    ue.AllowedNSSAI,
+	}
+	// The target gNB must use the capabilities the UE gave the AMF, not
+	// the ones the source gNB passed on (TS 33.501 6.7.3.1)
+	if caps := securityCapabilities(ue.SecurityCapability); caps != m.UESecurityCapabilities {
+		ue.log.Warn("UE security capabilities from target gNB differ from the stored ones")
+		ack.UESecurityCapabilities = &caps
+	}
+	a.send(ue, gnb, ack)
+
+	a.recordHandover(handoverXn, true)
+	ue.log.Info("UE switched to new gNB", zap.String("source", source.Key()), zap.String("target", gnb.Key()),
+		zap.Int("switched", len(switched)), zap.Int("released", len(released)))
+}
+
+// rejectPathSwitch answers a Path Switch Request with a failure,
+// releasing all its PDU sessions
+func rejectPathSwitch(gnb *GNB, m *ngap.PathSwitchRequest, cause ngap.Cause) {
+	released := make([]ngap.PDUSessionResourceItem, 0, len(m.ToBeSwitched))
+	for _, item := range m.ToBeSwitched {
+		released = append(released, unsuccessfulTransfer(item.PDUSessionID, cause))
+	}
+
+	err := gnb.Send(&ngap.PathSwitchRequestFailure{
+		AMFUENGAPID: m.SourceAMFUENGAPID,
+		RANUENGAPID: m.RANUENGAPID,
+		Released:    released,
+	})
+	if err != nil {
+		gnb.log.Error("Failed to send Path Switch Request Failure", zap.Error(err))
+	}
+}
+
+// handleHandoverRequired prepares an N2 handover: the SMFs of the PDU
+// sessions give the transfers for the target gNB, which is asked to
+// allocate resources (TS 23.502 4.9.1.3.2)
+func (a *AMF) handleHandoverRequired(ue *UE, gnb *GNB, m *ngap.HandoverRequired) {
+	reject := func(reason string, cause ngap.Cause) {
+		ue.log.Warn("Rejecting handover", zap.String("reason", reason), zap.Stringer("cause", cause))
+		a.recordHandover(handoverN2, false)
+		a.send(ue, gnb, &ngap.HandoverPreparationFailure{
+			AMFUENGAPID: m.AMFUENGAPID,
+			RANUENGAPID: m.RANUENGAPID,
+			Cause:       cause,
+		})
+	}
+
+	rm, _ := ue.State()
+	switch {
+	case !ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID):
+		reject("stale N2 connection", ngap.CauseRadioNetworkUnknownLocalUEID)
+		return
+	case rm != RMRegistered || ue.Security == nil || !ue.asContext:
+		reject("UE not registered", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	case ue.handover != nil:
+		reject("handover already in progress", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	case m.HandoverType != ngap.HandoverTypeIntra5GS:
+		reject("inter-system handover not supported", ngap.CauseRadioNetworkHOTargetNotAllowed)
+		return
+	}
+
+	target, ok := a.targetGNB(m.TargetID.GlobalRANNodeID)
+	if !ok {
+		reject("target gNB not connected", ngap.CauseRadioNetworkUnknownTargetID)
+		return
+	}
+	tai := m.TargetID.SelectedTAI
+	if !a.config.SupportsTAI(tai) || !target.ServesTAI(tai) {
+		reject("target tracking area not served", ngap.CauseRadioNetworkHOTargetNotAllowed)
+		return
+	}
+
+	targetID := targetIDModel(m.TargetID)
+	ho := &handover{
+		handoverType: m.HandoverType,
+		source:       gnb,
+		sourceAMFID:  m.AMFUENGAPID,
+		sourceRANID:  m.RANUENGAPID,
+		target:       target,
+	}
+	var sessions []ngap.PDUSessionResourceSetupItemHOReq
+	for _, item := range m.PDUSessionResources {
+		transfer, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			HoState:                   models.HoStatePreparing,
+			TargetID:                  targetID,
+			N2SmInfoType:              models.N2SmInfoHandoverRequired,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Warn("PDU session not handed over", zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+			continue
+		}
+		ho.sessions = append(ho.sessions, item.PDUSessionID)
+		sessions = append(sessions, ngap.PDUSessionResourceSetupItemHOReq{
+			PDUSessionID: item.PDUSessionID,
+			SNSSAI:       ue.PDUSessions[item.PDUSessionID].SNSSAI,
+			Transfer:     transfer,
+		})
+	}
+	if len(sessions) == 0 {
+		reject("no PDU session can be handed over", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	}
+
+	guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+	ho.targetAMFID = a.allocateNGAPID(ue)
+	ue.handover = ho
+	err := target.Send(&ngap.HandoverRequest{
+		AMFUENGAPID:                        ho.targetAMFID,
+		HandoverType:                       m.HandoverType,
+		Cause:                              m.Cause,
+		UEAggregateMaximumBitRate:          ueAMBR(ue),
+		UESecurityCapabilities:             securityCapabilities(ue.SecurityCapability),
+		SecurityContext:                    ue.Security.nextHop(),
+		PDUSessionResources:                sessions,
+		AllowedNSSAI:                       ue.AllowedNSSAI,
+		SourceToTargetTransparentContainer: m.SourceToTargetTransparentContainer,
+		GUAMI:                              guami,
+	})
+	if err != nil {
+		ue.log.Error("Failed to send Handover Request", zap.Error(err))
+		a.cancelHandover(ue, ngap.CauseRadioNetworkHOFailureInTarget, false)
+		reject("target gNB unreachable", ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	}
+	ue.log.Info("Handover preparation started", zap.String("target", target.Key()), zap.Stringer("cause", m.Cause),
+		zap.Int("pdu_sessions", len(sessions)))
+}
+
+// handleHandoverRequestAcknowledge gives the source gNB the transfers
+// the SMFs built from the resources allocated by the target gNB
+func (a *AMF) handleHandoverRequestAcknowledge(ue *UE, gnb *GNB, m *ngap.HandoverRequestAcknowledge) {
+	ho := ue.handover
+	if ho == nil || ho.acknowledged || ho.target != gnb || ho.targetAMFID != m.AMFUENGAPID {
+		ue.log.Warn("Dropping Handover Request Acknowledge without handover in progress")
+		return
+	}
+	ho.targetRANID, ho.acknowledged = m.RANUENGAPID, true
+
+	var handedOver, toRelease []ngap.PDUSessionResourceItem
+	ho.sessions = ho.sessions[:0]
+	for _, item := range m.Admitted {
+		transfer, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			HoState:                   models.HoStatePrepared,
+			N2SmInfoType:              models.N2SmInfoHandoverReqAck,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Warn("PDU session not handed over", zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+			toRelease = append(toRelease, unsuccessfulTransfer(item.PDUSessionID, sessionFailureCause(err)))
+			continue
+		}
+		ho.sessions = append(ho.sessions, item.PDUSessionID)
+		handedOver = append(handedOver, ngap.PDUSessionResourceItem{PDUSessionID: item.PDUSessionID, Transfer: transfer})
+	}
+	for _, item := range m.FailedToSetup {
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			N2SmInfoType:              models.N2SmInfoHandoverResAllocFail,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Debug("Failed to report PDU session not set up in target gNB",
+				zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+		}
+		toRelease = append(toRelease, unsuccessfulTransfer(item.PDUSessionID, ngap.CauseRadioNetworkHOFailureInTarget))
+	}
+
+	if len(handedOver) == 0 {
+		ue.log.Warn("Handover failed", zap.String("reason", "no PDU session admitted by target gNB"))
+		a.failHandover(ue, ngap.CauseRadioNetworkHOFailureInTarget)
+		return
+	}
+
+	a.send(ue, ho.source, &ngap.HandoverCommand{
+		AMFUENGAPID:                        ho.sourceAMFID,
+		RANUENGAPID:                        ho.sourceRANID,
+		HandoverType:                       ho.handoverType,
+		PDUSessionResources:                handedOver,
+		ToRelease:                          toRelease,
+		TargetToSourceTransparentContainer: m.TargetToSourceTransparentContainer,
+	})
+}
+
+// handleHandoverFailure ends a handover the target gNB could not
+// allocate resources for
+func (a *AMF) handleHandoverFailure(ue *UE, gnb *GNB, m *ngap.HandoverFailure) {
+	ho := ue.handover
+	if ho == nil || ho.acknowledged || ho.target != gnb || ho.targetAMFID != m.AMFUENGAPID {
+		ue.log.Warn("Dropping Handover Failure without handover in progress")
+		return
+	}
+
+	ue.log.Warn("Handover failed", zap.String("reason", "target gNB rejected the handover"), zap.Stringer("cause", m.Cause))
+	a.failHandover(ue, ngap.CauseRadioNetworkHOFailureInTarget)
+}
+
+// failHandover cancels the handover in progress and tells the source gNB
+func (a *AMF) failHandover(ue *UE, cause ngap.Cause) {
+	ho := ue.handover
+	a.cancelHandover(ue, cause, ho.acknowledged)
+	a.recordHandover(handoverN2, false)
+	a.send(ue, ho.source, &ngap.HandoverPreparationFailure{
+		AMFUENGAPID: ho.sourceAMFID,
+		RANUENGAPID: ho.sourceRANID,
+		Cause:       cause,
+	})
+}
+
+// handleHandoverNotify binds the UE to the target gNB it arrived in and
+// releases its context in the source gNB (TS 23.502 4.9.1.3.3)
+func (a *AMF) handleHandoverNotify(ue *UE, gnb *GNB, m *ngap.HandoverNotify) {
+	ho := ue.handover
+	if ho == nil || !ho.acknowledged || ho.target != gnb ||
+		ho.targetAMFID != m.AMFUENGAPID || ho.targetRANID != m.RANUENGAPID {
+		ue.log.Warn("Dropping Handover Notify without handover in progress")
+		return
+	}
+	ue.handover = nil
+
+	tai := m.UserLocationInformation.TAI.Model()
+	ue.gnb, ue.amfUENGAPID, ue.ranUENGAPID = gnb, ho.targetAMFID, ho.targetRANID
+	a.setLocation(ue, m.UserLocationInformation)
+	ue.asContext = true
+
+	for _, id := range ho.sessions {
+		_, err := a.updateSession(ue, id, models.SmContextUpdateData{HoState: models.HoStateCompleted, Tai: &tai})
+		if err != nil {
+			ue.log.Warn("Failed to complete handover of PDU session", zap.Uint8("pdu_session_id", id), zap.Error(err))
+		}
+	}
+
+	// The AMF UE NGAP ID of the source is freed by its release complete
+	a.send(ue, ho.source, &ngap.UEContextReleaseCommand{
+		UENGAPIDs: ngap.UENGAPIDs{AMFUENGAPID: ho.sourceAMFID, RANUENGAPID: ho.sourceRANID},
+		Cause:     ngap.CauseRadioNetworkSuccessfulHandover,
+	})
+
+	a.recordHandover(handoverN2, true)
+	ue.log.Info("UE handed over", zap.String("source", ho.source.Key()), zap.String("target", gnb.Key()))
+	if !ue.inRegistrationArea(tai) {
+		ue.log.Debug("UE left its registration area", zap.String("tac", tai.Tac))
+	}
+}
+
+// handleHandoverCancel abandons the handover of the UE at the request of
+// the source gNB (TS 23.502 4.9.1.4)
+func (a *AMF) handleHandoverCancel(ue *UE, gnb *GNB, m *ngap.HandoverCancel) {
+	if ue.handover != nil && ue.boundTo(gnb, m.AMFUENGAPID, m.RANUENGAPID) {
+		ue.log.Info("Handover cancelled", zap.Stringer("cause", m.Cause))
+		a.cancelHandover(ue, ngap.CauseRadioNetworkHandoverCancelled, true)
+		a.recordHandover(handoverN2, false)
+	}
+
+	a.send(ue, gnb, &ngap.HandoverCancelAcknowledge{AMFUENGAPID: m.AMFUENGAPID, RANUENGAPID: m.RANUENGAPID})
+}
+
+// cancelHandover drops the handover in progress and cancels it in the
+// SMFs. The target gNB is asked to release what it allocated unless it
+// holds nothing; its AMF UE NGAP ID is then freed by the release
+// complete.
+func (a *AMF) cancelHandover(ue *UE, cause ngap.Cause, releaseTarget bool) {
+	ho := ue.handover
+	ue.handover = nil
+
+	for _, id := range ho.sessions {
+		if _, err := a.updateSession(ue, id, models.SmContextUpdateData{HoState: models.HoStateCancelled}); err != nil {
+			ue.log.Warn("Failed to cancel handover of PDU session", zap.Uint8("pdu_session_id", id), zap.Error(err))
+		}
+	}
+
+	if !releaseTarget {
+		a.releaseNGAPID(ho.targetAMFID)
+		return
+	}
+	ids := ngap.UENGAPIDs{AMFUENGAPID: ho.targetAMFID, RANUENGAPID: ho.targetRANID, AMFOnly: !ho.acknowledged}
+	a.send(ue, ho.target, &ngap.UEContextReleaseCommand{UENGAPIDs: ids, Cause: cause})
+}
+
+// targetGNB returns the connected gNB with the given global ID
+func (a *AMF) targetGNB(id ngap.GlobalRANNodeID) (*GNB, bool) {
+	if a.n2 == nil {
+		return nil, false
+	}
+	return a.n2.GNB(id)
+}
+
+// updateSession sends an SM context update to the SMF of a PDU session
+// and returns the NGAP transfer of its answer
+func (a *AMF) updateSession(ue *UE, id uint8, data models.SmContextUpdateData) ([]byte, error) {
+	session := ue.PDUSessions[id]
+	if session == nil {
+		return nil, errUnknownSession
+	}
+	if a.nfs.SMF == nil {
+		return nil, errNoSMF
+	}
+
+	resp, err := a.nfs.SMF.UpdateSMContext(context.Background(), session.SMContextRef, data)
+	if err != nil {
+		return nil, err
+	}
+	return resp.BinaryDataN2SmInformation, nil
+}
+
+// sessionFailureCause returns the NGAP cause of a PDU session the AMF
+// could not move
+func sessionFailureCause(err error) ngap.Cause {
+	if errors.Is(err, errUnknownSession) {
+		return ngap.CauseRadioNetworkUnknownPDUSessionID
+	}
+	return ngap.CauseRadioNetworkHOFailureInTarget
+}
+
+// unsuccessfulTransfer returns a PDU session with the transfer telling
+// the gNB why it was not switched or handed over
+func unsuccessfulTransfer(id uint8, cause ngap.Cause) ngap.PDUSessionResourceItem {
+	// Only an invalid cause fails to encode
+	transfer, _ := ngap.EncodeUnsuccessfulTransfer(cause)
+	return ngap.PDUSessionResourceItem{PDUSessionID: id, Transfer: transfer}
+}
+
+// ueAMBR returns the subscribed UE-AMBR, unlimited in the directions the
+// subscription does not set
+func ueAMBR(ue *UE) ngap.UEAggregateMaximumBitRate {
+	ambr := ngap.UEAggregateMaximumBitRate{Downlink: unlimitedBitRate, Uplink: unlimitedBitRate}
+	if ue.AMData == nil || ue.AMData.SubscribedUeAmbr == nil {
+		return ambr
+	}
+
+	if dl, err := models.ParseBitRate(ue.AMData.SubscribedUeAmbr.Downlink); err == nil {
+		ambr.Downlink = min(dl, unlimitedBitRate)
+	}
+	if ul, err := models.ParseBitRate(ue.AMData.SubscribedUeAmbr.Uplink); err == nil {
+		ambr.Uplink = min(ul, unlimitedBitRate)
+	}
+	return ambr
+}
+
+// targetIDModel converts a handover target to its SBI model
+func targetIDModel(id ngap.TargetID) *models.NgRanTargetID {
+	return &models.NgRanTargetID{
+		RanNodeID: models.GlobalRanNodeID{
+			PlmnID: id.GlobalRANNodeID.PLMNIdentity,
+			GNbID: &models.GNbID{
+				BitLength: id.GlobalRANNodeID.GNBIDLength,
+				GNBValue:  fmt.Sprintf("%06x", id.GlobalRANNodeID.GNBID),
+			},
+		},
+		Tai: id.SelectedTAI.Model(),
+	}
+}
+
+// send sends a UE associated NGAP message to a gNB
+func (a *AMF) send(ue *UE, gnb *GNB, msg ngap.Message) {
+	if err := gnb.Send(msg); err != nil {
+		ue.log.Error("Failed to send NGAP message", zap.String("message", fmt.Sprintf("%T", msg)), zap.Error(err))
+	}
+}
+
+// recordHandover counts a handover by type and result
+func (a *AMF) recordHandover(handoverType string, success bool) {
+	if a.metrics == nil {
+		return
+	}
+	result := metrics.ResultSuccess
+	if !success {
+		result = metrics.ResultFailure
+	}
+	a.metrics.Handovers.WithLabelValues(handoverType, result).Inc()
+}
@@ -0,0 +1,474 @@
+package amf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// targetID is the global ID of the gNB the UE moves to, serving the
+// second tracking area only
+var targetID = ngap.GlobalRANNodeID{PLMNIdentity: testPLMN, GNBID: 2, GNBIDLength: 22}
+
+// targetRANUENGAPID is the RAN UE NGAP ID the target gNB gives the UE
+const targetRANUENGAPID = 7
+
+// mobility is a registered UE in CM-CONNECTED with a PDU session, next
+// to a target gNB it can move to
+type mobility struct {
+	h          *harness
+	u          *testUE
+	target     *GNB
+	targetConn *fakeConn
+}
+
+// newMobility registers a UE with PDU session 1 and sets up the target
+// gNB
+func newMobility(t *testing.T) *mobility {
+	t.Helper()
+
+	h := newHarness(t)
+	u := newTestUE(h)
+	u.register()
+	ue := u.context()
+	ue.call(func() {
+		ue.PDUSessions = map[uint8]*PDUSession{
+			1: {ID: 1, SNSSAI: testSlice, DNN: "internet", SMContextRef: "http://smf.test/sm-contexts/1", Active: true},
+		}
+	})
+	h.nfs.takeCalls()
+
+	conn := &fakeConn{sent: make(chan ngap.Message, 64)}
+	target := &GNB{conn: conn, log: zap.NewNop()}
+	h.sendFrom(target, &ngap.NGSetupRequest{
+		GlobalRANNodeID: targetID,
+		RANNodeName:     "gnb-target",
+		SupportedTAList: []ngap.SupportedTAItem{{
+			TAC:               testTAC + 1,
+			BroadcastPLMNList: []ngap.BroadcastPLMNItem{{PLMNIdentity: testPLMN, SliceSupportList: []models.Snssai{testSlice}}},
+		}},
+		DefaultPagingDRX: ngap.PagingDRX128,
+	})
+	recvFrom[*ngap.NGSetupResponse](h, conn)
+	return &mobility{h: h, u: u, target: target, targetConn: conn}
+}
+
+// required returns a Handover Required of the source gNB moving PDU
+// sessions to the target gNB in a tracking area
+func (m *mobility) required(tac uint32, sessions ...uint8) *ngap.HandoverRequired {
+	req := &ngap.HandoverRequired{
+		AMFUENGAPID:  m.u.amfUENGAPID,
+		RANUENGAPID:  m.u.ranUENGAPID,
+		HandoverType: ngap.HandoverTypeIntra5GS,
+		Cause:        ngap.CauseRadioNetworkUnspecified,
+		TargetID: ngap.TargetID{
+			GlobalRANNodeID: targetID,
+			SelectedTAI:     ngap.TAI{PLMNIdentity: testPLMN, TAC: tac},
+		},
+		SourceToTargetTransparentContainer: []byte{0x01, 0x02},
+	}
+	for _, id := range sessions {
+		req.PDUSessionResources = append(req.PDUSessionResources, ngap.PDUSessionResourceItem{
+			PDUSessionID: id,
+			Transfer:     []byte{0x00},
+		})
+	}
+	return req
+}
+
+// prepare runs the handover preparation up to the Handover Request of
+// the target gNB, and returns it
+func (m *mobility) prepare() *ngap.HandoverRequest {
+	m.h.t.Helper()
+
+	m.h.send(m.required(testTAC+1, 1))
+	req := recvFrom[*ngap.HandoverRequest](m.h, m.targetConn)
+	if len(req.PDUSessionResources) != 1 || req.PDUSessionResources[0].PDUSessionID != 1 ||
+		req.PDUSessionResources[0].SNSSAI != testSlice ||
+		string(req.PDUSessionResources[0].Transfer) != string(models.N2SmInfoHandoverRequired) {
+		m.h.t.Errorf("Handover Request sessions = %+v, want session 1 with the transfer of the SMF", req.PDUSessionResources)
+	}
+	if req.SecurityContext.NextHopChainingCount != 1 || len(req.SecurityContext.NextHopNH) != 32 {
+		m.h.t.Errorf("Handover Request security context = %+v, want the first next hop", req.SecurityContext)
+	}
+	if !bytes.Equal(req.SourceToTargetTransparentContainer, []byte{0x01, 0x02}) {
+		m.h.t.Errorf("source to target container = %x, want the one of the source gNB", req.SourceToTargetTransparentContainer)
+	}
+	return req
+}
+
+// acknowledge has the target gNB admit PDU session 1 and returns the
+// Handover Command given to the source gNB
+func (m *mobility) acknowledge(req *ngap.HandoverRequest) *ngap.HandoverCommand {
+	m.h.t.Helper()
+
+	m.h.sendFrom(m.target, &ngap.HandoverRequestAcknowledge{
+		AMFUENGAPID:                        req.AMFUENGAPID,
+		RANUENGAPID:                        targetRANUENGAPID,
+		Admitted:                           []ngap.PDUSessionResourceItem{{PDUSessionID: 1, Transfer: []byte{0x00}}},
+		TargetToSourceTransparentContainer: []byte{0x03},
+	})
+	cmd := recv[*ngap.HandoverCommand](m.h)
+	if cmd.AMFUENGAPID != m.u.amfUENGAPID || cmd.RANUENGAPID != m.u.ranUENGAPID {
+		m.h.t.Errorf("Handover Command for %d/%d, want the source connection %d/%d",
+			cmd.AMFUENGAPID, cmd.RANUENGAPID, m.u.amfUENGAPID, m.u.ranUENGAPID)
+	}
+	if len(cmd.PDUSessionResources) != 1 ||
+		string(cmd.PDUSessionResources[0].Transfer) != string(models.N2SmInfoHandoverReqAck) || len(cmd.ToRelease) != 0 {
+		m.h.t.Errorf("Handover Command sessions = %+v, released %+v, want session 1 handed over",
+			cmd.PDUSessionResources, cmd.ToRelease)
+	}
+	return cmd
+}
+
+// connection returns the gNB and the NGAP IDs the UE is bound to
+func (m *mobility) connection() (*GNB, int64, int64) {
+	ue := m.u.context()
+	var gnb *GNB
+	var amfID, ranID int64
+	ue.call(func() { gnb, amfID, ranID = ue.gnb, ue.amfUENGAPID, ue.ranUENGAPID })
+	return gnb, amfID, ranID
+}
+
+// hoStates returns the handover states of the SM context updates sent
+// since the last call
+func (m *mobility) hoStates() []models.HoState {
+	var states []models.HoState
+	for _, update := range m.h.nfs.takeSMUpdates() {
+		states = append(states, update.HoState)
+	}
+	return states
+}
+
+func TestN2Handover(t *testing.T) {
+	tests := []struct {
+		name string
+		flow func(m *mobility)
+
+		// states are the handover states of the SM context updates
+		states   []models.HoState
+		onTarget bool
+	}{
+		{
+			name: "handover completed",
+			flow: func(m *mobility) {
+				req := m.prepare()
+				m.acknowledge(req)
+				m.h.sendFrom(m.target, &ngap.HandoverNotify{
+					AMFUENGAPID:             req.AMFUENGAPID,
+					RANUENGAPID:             targetRANUENGAPID,
+					UserLocationInformation: location(testTAC + 1),
+				})
+				cmd := recv[*ngap.UEContextReleaseCommand](m.h)
+				if cmd.UENGAPIDs.AMFUENGAPID != m.u.amfUENGAPID || cmd.Cause != ngap.CauseRadioNetworkSuccessfulHandover {
+					m.h.t.Errorf("source released %+v with %v, want %d with successful handover",
+						cmd.UENGAPIDs, cmd.Cause, m.u.amfUENGAPID)
+				}
+				m.h.send(&ngap.UEContextReleaseComplete{AMFUENGAPID: m.u.amfUENGAPID, RANUENGAPID: m.u.ranUENGAPID})
+				m.h.settle()
+			},
+			states:   []models.HoState{models.HoStatePreparing, models.HoStatePrepared, models.HoStateCompleted},
+			onTarget: true,
+		},
+		{
+			name: "target gNB fails",
+			flow: func(m *mobility) {
+				req := m.prepare()
+				m.h.sendFrom(m.target, &ngap.HandoverFailure{
+					AMFUENGAPID: req.AMFUENGAPID,
+					Cause:       ngap.CauseRadioNetworkUnspecified,
+				})
+				fail := recv[*ngap.HandoverPreparationFailure](m.h)
+				if fail.Cause != ngap.CauseRadioNetworkHOFailureInTarget {
+					m.h.t.Errorf("preparation failure cause = %v, want %v", fail.Cause, ngap.CauseRadioNetworkHOFailureInTarget)
+				}
+			},
+			states: []models.HoState{models.HoStatePreparing, models.HoStateCancelled},
+		},
+		{
+			name: "only unknown PDU session admitted",
+			flow: func(m *mobility) {
+				req := m.prepare()
+				m.h.sendFrom(m.target, &ngap.HandoverRequestAcknowledge{
+					AMFUENGAPID: req.AMFUENGAPID,
+					RANUENGAPID: targetRANUENGAPID,
+					Admitted:    []ngap.PDUSessionResourceItem{{PDUSessionID: 5, Transfer: []byte{0x00}}},
+				})
+				cmd := recvFrom[*ngap.UEContextReleaseCommand](m.h, m.targetConn)
+				if cmd.UENGAPIDs.AMFUENGAPID != req.AMFUENGAPID || cmd.UENGAPIDs.RANUENGAPID != targetRANUENGAPID {
+					m.h.t.Errorf("target released %+v, want %d/%d", cmd.UENGAPIDs, req.AMFUENGAPID, targetRANUENGAPID)
+				}
+				recv[*ngap.HandoverPreparationFailure](m.h)
+			},
+			states: []models.HoState{models.HoStatePreparing},
+		},
+		{
+			name: "source gNB cancels",
+			flow: func(m *mobility) {
+				req := m.prepare()
+				m.acknowledge(req)
+				m.h.send(&ngap.HandoverCancel{
+					AMFUENGAPID: m.u.amfUENGAPID,
+					RANUENGAPID: m.u.ranUENGAPID,
+					Cause:       ngap.CauseRadioNetworkUnspecified,
+				})
+				cmd := recvFrom[*ngap.UEContextReleaseCommand](m.h, m.targetConn)
+				if cmd.UENGAPIDs.AMFUENGAPID != req.AMFUENGAPID || cmd.Cause != ngap.CauseRadioNetworkHandoverCancelled {
+					m.h.t.Errorf("target released %+v with %v, want %d with handover cancelled",
+						cmd.UENGAPIDs, cmd.Cause, req.AMFUENGAPID)
+				}
+				recv[*ngap.HandoverCancelAcknowledge](m.h)
+			},
+			states: []models.HoState{models.HoStatePreparing, models.HoStatePrepared, models.HoStateCancelled},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMobility(t)
+			_, sourceAMFID, _ := m.connection()
+
+			tt.flow(m)
+			m.h.expectNothing()
+			if got := m.hoStates(); !equalHoStates(got, tt.states) {
+				t.Errorf("SM context updates in states %q, want %q", got, tt.states)
+			}
+
+			gnb, amfID, ranID := m.connection()
+			switch {
+			case tt.onTarget && (gnb != m.target || amfID == sourceAMFID || ranID != targetRANUENGAPID):
+				t.Errorf("UE bound to %v %d/%d, want the target gNB", gnb, amfID, ranID)
+			case !tt.onTarget && (gnb != m.h.gnb || amfID != sourceAMFID):
+				t.Errorf("UE bound to %v %d, want the source gNB", gnb, amfID)
+			}
+			if rm, cm := m.u.state(); rm != RMRegistered || cm != CMConnected {
+				t.Errorf("state = %v %v, want registered and connected", rm, cm)
+			}
+			ue := m.u.context()
+			ue.call(func() {
+				if ue.handover != nil {
+					t.Errorf("handover still in progress")
+				}
+				if tt.onTarget && ue.TAI.Tac != "000002" {
+					t.Errorf("TAI = %v, want the one of the target gNB", ue.TAI)
+				}
+			})
+		})
+	}
+}
+
+// equalHoStates reports whether two lists of handover states are equal
+func equalHoStates(a, b []models.HoState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandoverRequiredRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   func(m *mobility) *ngap.HandoverRequired
+		cause ngap.Cause
+	}{
+		{
+			name: "unknown target gNB",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				req := m.required(testTAC+1, 1)
+				req.TargetID.GlobalRANNodeID.GNBID = 3
+				return req
+			},
+			cause: ngap.CauseRadioNetworkUnknownTargetID,
+		},
+		{
+			name: "tracking area not served by the target gNB",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				return m.required(testTAC, 1)
+			},
+			cause: ngap.CauseRadioNetworkHOTargetNotAllowed,
+		},
+		{
+			name: "tracking area not served by the AMF",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				return m.required(testTAC+5, 1)
+			},
+			cause: ngap.CauseRadioNetworkHOTargetNotAllowed,
+		},
+		{
+			name: "inter-system handover",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				req := m.required(testTAC+1, 1)
+				req.HandoverType = ngap.HandoverType5GSToEPS
+				return req
+			},
+			cause: ngap.CauseRadioNetworkHOTargetNotAllowed,
+		},
+		{
+			name: "unknown PDU session",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				return m.required(testTAC+1, 5)
+			},
+			cause: ngap.CauseRadioNetworkHOFailureInTarget,
+		},
+		{
+			name: "stale N2 connection",
+			req: func(m *mobility) *ngap.HandoverRequired {
+				req := m.required(testTAC+1, 1)
+				req.RANUENGAPID++
+				return req
+			},
+			cause: ngap.CauseRadioNetworkUnknownLocalUEID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMobility(t)
+			req := tt.req(m)
+
+			m.h.send(req)
+			fail := recv[*ngap.HandoverPreparationFailure](m.h)
+			if fail.Cause != tt.cause {
+				t.Errorf("cause = %v, want %v", fail.Cause, tt.cause)
+			}
+			if fail.AMFUENGAPID != req.AMFUENGAPID || fail.RANUENGAPID != req.RANUENGAPID {
+				t.Errorf("failure for %d/%d, want %d/%d", fail.AMFUENGAPID, fail.RANUENGAPID, req.AMFUENGAPID, req.RANUENGAPID)
+			}
+			m.h.expectNothing()
+			select {
+			case msg := <-m.targetConn.sent:
+				t.Errorf("unexpected %T sent to the target gNB", msg)
+			default:
+			}
+			m.h.expectCalls()
+		})
+	}
+}
+
+func TestPathSwitch(t *testing.T) {
+	// ueCaps are the NGAP security capabilities of the registered UE
+	ueCaps := securityCapabilities(nas.UESecurityCapability{EA: 0xf0, IA: 0xf0})
+	unknownSession, _ := ngap.EncodeUnsuccessfulTransfer(ngap.CauseRadioNetworkUnknownPDUSessionID)
+
+	tests := []struct {
+		name string
+
+		// edit changes the Path Switch Request of PDU session 1 from the
+		// second tracking area
+		edit func(m *mobility, req *ngap.PathSwitchRequest)
+
+		switched bool
+		released []ngap.PDUSessionResourceItem
+		newCaps  bool
+	}{
+		{
+			name:     "path switched",
+			switched: true,
+		},
+		{
+			name: "unknown PDU session released",
+			edit: func(m *mobility, req *ngap.PathSwitchRequest) {
+				req.ToBeSwitched = append(req.ToBeSwitched, ngap.PDUSessionResourceItem{PDUSessionID: 5, Transfer: []byte{0x00}})
+			},
+			switched: true,
+			released: []ngap.PDUSessionResourceItem{{PDUSessionID: 5, Transfer: unknownSession}},
+		},
+		{
+			name: "security capabilities corrected",
+			edit: func(m *mobility, req *ngap.PathSwitchRequest) {
+				req.UESecurityCapabilities.NREncryptionAlgorithms = 0
+			},
+			switched: true,
+			newCaps:  true,
+		},
+		{
+			name: "no PDU session switched",
+			edit: func(m *mobility, req *ngap.PathSwitchRequest) {
+				req.ToBeSwitched[0].PDUSessionID = 5
+			},
+		},
+		{
+			name: "unknown source AMF UE NGAP ID",
+			edit: func(m *mobility, req *ngap.PathSwitchRequest) {
+				req.SourceAMFUENGAPID = 99
+			},
+		},
+		{
+			name: "tracking area not served",
+			edit: func(m *mobility, req *ngap.PathSwitchRequest) {
+				req.UserLocationInformation = location(testTAC + 5)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMobility(t)
+			_, sourceAMFID, _ := m.connection()
+			req := &ngap.PathSwitchRequest{
+				RANUENGAPID:             targetRANUENGAPID,
+				SourceAMFUENGAPID:       sourceAMFID,
+				UserLocationInformation: location(testTAC + 1),
+				UESecurityCapabilities:  ueCaps,
+				ToBeSwitched:            []ngap.PDUSessionResourceItem{{PDUSessionID: 1, Transfer: []byte{0x00}}},
+			}
+			if tt.edit != nil {
+				tt.edit(m, req)
+			}
+
+			m.h.sendFrom(m.target, req)
+			gnb, amfID, ranID := m.connection()
+			if !tt.switched {
+				fail := recvFrom[*ngap.PathSwitchRequestFailure](m.h, m.targetConn)
+				if fail.RANUENGAPID != targetRANUENGAPID || len(fail.Released) != len(req.ToBeSwitched) {
+					t.Errorf("failure for %d releasing %+v, want %d releasing all sessions",
+						fail.RANUENGAPID, fail.Released, targetRANUENGAPID)
+				}
+				if gnb != m.h.gnb || amfID != sourceAMFID {
+					t.Errorf("UE bound to %v %d, want the source gNB", gnb, amfID)
+				}
+				return
+			}
+
+			ack := recvFrom[*ngap.PathSwitchRequestAcknowledge](m.h, m.targetConn)
+			if ack.AMFUENGAPID != sourceAMFID || ack.RANUENGAPID != targetRANUENGAPID {
+				t.Errorf("acknowledge for %d/%d, want %d/%d", ack.AMFUENGAPID, ack.RANUENGAPID, sourceAMFID, targetRANUENGAPID)
+			}
+			if len(ack.Switched) != 1 || ack.Switched[0].PDUSessionID != 1 ||
+				string(ack.Switched[0].Transfer) != string(models.N2SmInfoPathSwitchReq) {
+				t.Errorf("switched = %+v, want session 1 with the transfer of the SMF", ack.Switched)
+			}
+			if len(ack.Released) != len(tt.released) {
+				t.Fatalf("released = %+v, want %+v", ack.Released, tt.released)
+			}
+			for i := range ack.Released {
+				if ack.Released[i].PDUSessionID != tt.released[i].PDUSessionID ||
+					!bytes.Equal(ack.Released[i].Transfer, tt.released[i].Transfer) {
+					t.Errorf("released = %+v, want %+v", ack.Released, tt.released)
+				}
+			}
+			if ack.SecurityContext.NextHopChainingCount != 1 {
+				t.Errorf("next hop chaining count = %d, want 1", ack.SecurityContext.NextHopChainingCount)
+			}
+			if (ack.UESecurityCapabilities != nil) != tt.newCaps ||
+				(tt.newCaps && *ack.UESecurityCapabilities != ueCaps) {
+				t.Errorf("security capabilities = %+v, want the stored ones %v", ack.UESecurityCapabilities, tt.newCaps)
+			}
+			if gnb != m.target || amfID != sourceAMFID || ranID != targetRANUENGAPID {
+				t.Errorf("UE bound to %v %d/%d, want the target gNB", gnb, amfID, ranID)
+			}
+
+			updates := m.h.nfs.takeSMUpdates()
+			if len(updates) != 1 || !updates[0].ToBeSwitched || updates[0].Tai == nil || updates[0].Tai.Tac != "000002" {
+				t.Errorf("SM context updates = %+v, want session 1 switched to the new tracking area", updates)
+			}
+			m.h.expectNothing()
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package amf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/ngap/transport"
+	"go.uber.org/zap"
+)
+
+// MessageHandler handles the NGAP messages of a set up gNB other than
+// the interface management ones handled by the N2 server
+type MessageHandler func(gnb *GNB, msg ngap.Message)
+
+// LostHandler is told about a gNB whose association closed, along with
+// the N2 connections of its UEs
+type LostHandler func(gnb *GNB)
+
+// N2Server terminates the N2 interface: it accepts gNB associations,
+// runs NG Setup and keeps the gNB contexts
+type N2Server struct {
+	config   *Config
+	log      *zap.Logger
+	listener transport.Listener
+	handler  MessageHandler
+	lost     LostHandler
+
+	mu    sync.RWMutex
+	gnbs  map[string]*GNB
+	conns map[*GNB]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewN2Server creates an N2 server for the given AMF settings
+func NewN2Server(cfg *Config) *N2Server {
+	return &N2Server{
+		config: cfg,
+		log:    logger.Named("ngap"),
+		gnbs:   make(map[string]*GNB),
+		conns:  make(map[*GNB]struct{}),
+	}
+}
+
+// SetHandler sets the handler of UE associated messages. It must be
+// called before Start.
+func (s *N2Server) SetHandler(handler MessageHandler) {
+	s.handler = handler
+}
+
+// SetLostHandler sets the handler of closed gNB associations. It must be
+// called before Start.
+func (s *N2Server) SetLostHandler(handler LostHandler) {
+	s.lost = handler
+}
+
+// Start listens on the configured address and accepts gNBs in the
+// background
+func (s *N2Server) Start() error {
+	listener, err := transport.Listen(s.config.N2Transport, s.config.N2Address)
+	if err != nil {
+		return fmt.Errorf("listening for N2 on %s: %w", s.config.N2Address, err)
+	}
+	s.listener = listener
+
+	s.log.Info("Starting N2 server",
+		zap.String("address", listener.Addr().String()),
+		zap.String("transport", s.config.N2Transport))
+
+	s.wg.Add(1)
+	go s.accept()
+	return nil
+}
+
+// Addr returns the listening address
+func (s *N2Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops accepting gNBs and closes their associations
+func (s *N2Server) Shutdown(ctx context.Context) error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+
+	s.mu.RLock()
+	for gnb := range s.conns {
+		gnb.conn.Close()
+	}
+	s.mu.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GNB returns the set up gNB with the given global ID
+func (s *N2Server) GNB(id ngap.GlobalRANNodeID) (*GNB, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gnb, ok := s.gnbs[gnbKey(id)]
+	return gnb, ok
+}
+
+// GNBs returns the set up gNBs
+func (s *N2Server) GNBs() []*GNB {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	gnbs := make([]*GNB, 0, len(s.gnbs))
+	for _, gnb := range s.gnbs {
+		gnbs = append(gnbs, gnb)
+	}
+	return gnbs
+}
+
+// accept accepts gNB associations until the listener is closed
+func (s *N2Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Error("Failed to accept N2 association", zap.Error(err))
+			}
+			return
+		}
+
+		gnb := &GNB{
+			conn: conn,
+			log:  s.log.With(zap.String("remote", conn.RemoteAddr().String())),
+		}
+		s.mu.Lock()
+		s.conns[gnb] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(gnb)
+	}
+}
+
+// serve reads the messages of a gNB until its association is closed
+func (s *N2Server) serve(gnb *GNB) {
+	defer s.wg.Done()
+	defer s.remove(gnb)
+
+	gnb.log.Info("gNB connected")
+	for {
+		b, err := gnb.conn.ReadMsg()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				gnb.log.Warn("N2 association failed", zap.Error(err))
+			}
+			return
+		}
+
+		msg, err := ngap.Decode(b)
+		if err != nil {
+			gnb.log.Warn("Failed to decode NGAP message", zap.Error(err))
+			continue
+		}
+		s.dispatch(gnb, msg)
+	}
+}
+
+// dispatch handles a decoded message
+func (s *N2Server) dispatch(gnb *GNB, msg ngap.Message) {
+	if req, ok := msg.(*ngap.NGSetupRequest); ok {
+		s.handleNGSetupRequest(gnb, req)
+		return
+	}
+
+	if !gnb.IsSetUp() {
+		gnb.log.Warn("Dropping NGAP message received before NG Setup",
+			zap.String("message", fmt.Sprintf("%T", msg)))
+		return
+	}
+	if s.handler == nil {
+		gnb.log.Debug("Dropping unhandled NGAP message", zap.String("message", fmt.Sprintf("%T", msg)))
+		return
+	}
+	s.handler(gnb, msg)
+}
+
+// handleNGSetupRequest accepts a gNB serving at least one of the
+// configured tracking areas (TS 38.413 8.7.1)
+func (s *N2Server) handleNGSetupRequest(gnb *GNB, req *ngap.NGSetupRequest) {
+	log := gnb.log.With(zap.String("gnb", gnbKey(req.GlobalRANNodeID)))
+
+	if cause, reason, ok := s.checkSupportedTAs(req.SupportedTAList); !ok {
+		log.Warn("Rejecting NG Setup", zap.String("reason", reason), zap.Stringer("cause", cause))
+		if err := gnb.Send(&ngap.NGSetupFailure{Cause: cause}); err != nil {
+			log.Error("Failed to send NG Setup Failure", zap.Error(err))
+		}
+		return
+	}
+
+	gnb.setup(req)
+
+	// A gNB setting up again over a new association replaces the old one
+	s.mu.Lock()
+	old, exists := s.gnbs[gnb.Key()]
+	s.gnbs[gnb.Key()] = gnb
+	s.mu.Unlock()
+	if exists && old != gnb {
+		log.Info("Replacing previous association of gNB", zap.String("previous", old.RemoteAddr()))
+		old.conn.Close()
+	}
+
+	resp := &ngap.NGSetupResponse{
+		AMFName:             s.config.Name,
+		RelativeAMFCapacity: s.config.RelativeCapacity,
+		PLMNSupportList:     s.config.PLMNSupport,
+	}
+	for _, guami := range s.config.ServedGUAMIs {
+		resp.ServedGUAMIList = append(resp.ServedGUAMIList, ngap.ServedGUAMIItem{GUAMI: guami})
+	}
+	if err := gnb.Send(resp); err != nil {
+		log.Error("Failed to send NG Setup Response", zap.Error(err))
+		return
+	}
+	log.Info("gNB set up", zap.String("name", req.RANNodeName), zap.Int("tas", len(req.SupportedTAList)))
+}
+
+// checkSupportedTAs looks for a tracking area served by both the gNB and
+// the AMF. NGAP has no dedicated cause for unserved tracking areas, so
+// both an unknown PLMN and an unknown TAC are rejected with unknown-PLMN;
+// the returned reason tells them apart in the logs.
+func (s *N2Server) checkSupportedTAs(tas []ngap.SupportedTAItem) (ngap.Cause, string, bool) {
+	plmnSupported := false
+	for _, ta := range tas {
+		for _, p := range ta.BroadcastPLMNList {
+			if !s.config.SupportsPLMN(p.PLMNIdentity) {
+				continue
+			}
+			plmnSupported = true
+			if s.config.SupportedTACs[ta.TAC] {
+				return ngap.Cause{}, "", true
+			}
+		}
+	}
+
+	if !plmnSupported {
+		return ngap.CauseMiscUnknownPLMN, "no supported PLMN", false
+	}
+	return ngap.CauseMiscUnknownPLMN, "no supported TAC", false
+}
+
+// remove forgets a gNB whose association closed
+func (s *N2Server) remove(gnb *GNB) {
+	gnb.conn.Close()
+
+	s.mu.Lock()
+	delete(s.conns, gnb)
+	if gnb.IsSetUp() && s.gnbs[gnb.Key()] == gnb {
+		delete(s.gnbs, gnb.Key())
+	}
+	s.mu.Unlock()
+
+	gnb.log.Info("gNB disconnected")
+	if s.lost != nil {
+		s.lost(gnb)
+	}
+}
@@ -0,0 +1,308 @@
+package amf
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/common/timer"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// n1n2Transfer is an N1N2 message transfer waiting for the UE
+type n1n2Transfer struct {
+	id  string
+	req models.N1N2MessageTransferReqData
+}
+
+// paging is the paging of a UE in progress (TS 23.502 4.2.3.3). Each
+// expiry of T3513 pages the UE again until the retries run out.
+type paging struct {
+	attempts int
+	timer    *timer.Timer
+}
+
+// N1N2MessageTransfer sends the N1 message and N2 information of another
+// NF to a registered UE (TS 29.518 5.2.2.3.1). A UE in CM-IDLE is paged
+// and gets them once it is back in CM-CONNECTED; the returned ID then
+// identifies the transfer.
+func (a *AMF) N1N2MessageTransfer(supi string, req models.N1N2MessageTransferReqData) (models.N1N2MessageTransferCause, string, error) {
+	if err := checkN1N2Transfer(req); err != nil {
+		return "", "", err
+	}
+	ue, ok := a.UE(supi)
+	if !ok {
+		return "", "", apperrors.NewNotFoundError("UE context not found", nil)
+	}
+
+	type result struct {
+		cause models.N1N2MessageTransferCause
+		id    string
+		err   error
+	}
+	done := make(chan result, 1)
+	ue.run(func() {
+		cause, id, err := a.transferN1N2(ue, req)
+		done <- result{cause, id, err}
+	})
+	r := <-done
+	return r.cause, r.id, r.err
+}
+
+// checkN1N2Transfer checks that a transfer holds a 5GSM message or the
+// PDU session resource setup or modification of a PDU session, the only
+// ones the AMF relays
+func checkN1N2Transfer(req models.N1N2MessageTransferReqData) error {
+	if req.N1MessageContainer == nil && req.N2InfoContainer == nil {
+		return apperrors.NewBadRequestError("no N1 message or N2 information", nil)
+	}
+
+	if c := req.N1MessageContainer; c != nil {
+		if c.N1MessageClass != models.N1MessageClassSM {
+			return apperrors.NewBadRequestError("unsupported N1 message class "+string(c.N1MessageClass), nil)
+		}
+		if len(req.BinaryDataN1Message) == 0 {
+			return apperrors.NewBadRequestError("missing N1 message content", nil)
+		}
+		if req.PduSessionID == 0 && req.N2InfoContainer == nil {
+			return apperrors.NewBadRequestError("missing PDU session ID", nil)
+		}
+	}
+
+	if c := req.N2InfoContainer; c != nil {
+		if c.N2InformationClass != models.N2InformationClassSM || c.SmInfo == nil || c.SmInfo.N2InfoContent == nil {
+			return apperrors.NewBadRequestError("N2 information without SM information", nil)
+		}
+		if t := c.SmInfo.N2InfoContent.NgapIeType; t != models.N2SmInfoPduResSetupReq && t != models.N2SmInfoPduResModReq {
+			return apperrors.NewBadRequestError("unsupported N2 SM information "+string(t), nil)
+		}
+		if len(req.BinaryDataN2Information) == 0 {
+			return apperrors.NewBadRequestError("missing N2 information content", nil)
+		}
+	}
+	return nil
+}
+
+// transferN1N2 delivers an N1N2 message transfer, queuing it while the
+// UE is unreachable
+func (a *AMF) transferN1N2(ue *UE, req models.N1N2MessageTransferReqData) (models.N1N2MessageTransferCause, string, error) {
+	if rm, _ := ue.State(); rm != RMRegistered {
+		return "", "", apperrors.NewNotFoundError("UE not registered", nil)
+	}
+	if id := transferSession(req); ue.PDUSessions[id] == nil {
+		return "", "", apperrors.NewNotFoundError("unknown PDU session "+strconv.Itoa(int(id)), nil)
+	}
+
+	switch {
+	case ue.gnb != nil && ue.asContext:
+		a.deliverN1N2(ue, req)
+		return models.N1N2TransferInitiated, "", nil
+	case ue.gnb != nil:
+		// Sent once the gNB holds the AS context of the UE
+		ue.pending = append(ue.pending, &n1n2Transfer{req: req})
+		return models.N1N2TransferInitiated, "", nil
+	case req.SkipInd && req.N2InfoContainer == nil:
+		return models.N1N2MsgNotTransferred, "", nil
+	case ue.GUTI == nil:
+		return "", "", apperrors.NewTimeoutError("UE not reachable", nil)
+	}
+
+	ue.nextTransferID++
+	t := &n1n2Transfer{id: strconv.FormatUint(ue.nextTransferID, 10), req: req}
+	ue.pending = append(ue.pending, t)
+	if ue.paging == nil {
+		a.page(ue)
+	}
+	return models.N1N2AttemptingToReachUE, t.id, nil
+}
+
+// transferSession returns the PDU session of an N1N2 message transfer
+func transferSession(req models.N1N2MessageTransferReqData) uint8 {
+	if req.N2InfoContainer != nil {
+		return req.N2InfoContainer.SmInfo.PduSessionID
+	}
+	return req.PduSessionID
+}
+
+// isSetup reports whether an N1N2 message transfer sets up the resources
+// of a PDU session
+func isSetup(req models.N1N2MessageTransferReqData) bool {
+	return req.N2InfoContainer != nil && req.N2InfoContainer.SmInfo.N2InfoContent.NgapIeType == models.N2SmInfoPduResSetupReq
+}
+
+// deliverN1N2 sends an N1N2 message transfer to the UE in CM-CONNECTED.
+// The 5GSM message of a PDU session resource setup or modification goes
+// along with it.
+func (a *AMF) deliverN1N2(ue *UE, req models.N1N2MessageTransferReqData) {
+	var n1 []byte
+	if req.N1MessageContainer != nil {
+		n1 = req.BinaryDataN1Message
+	}
+
+	switch {
+	case req.N2InfoContainer == nil:
+		a.sendSM(ue, req.PduSessionID, n1)
+	case isSetup(req):
+		a.setupSessions(ue, []sessionSetup{{
+			id:       req.N2InfoContainer.SmInfo.PduSessionID,
+			transfer: req.BinaryDataN2Information,
+			n1:       n1,
+		}})
+	default:
+		a.modifySession(ue, req.N2InfoContainer.SmInfo.PduSessionID, req.BinaryDataN2Information, n1)
+	}
+}
+
+// deliverPending sends the N1N2 message transfers that waited for the
+// UE to be reachable
+func (a *AMF) deliverPending(ue *UE) {
+	pending := ue.pending
+	ue.pending = nil
+	for _, t := range pending {
+		a.deliverN1N2(ue, t.req)
+	}
+}
+
+// sendSM sends a 5GSM message of a PDU session to the UE
+func (a *AMF) sendSM(ue *UE, id uint8, msg []byte) {
+	a.sendNAS(ue, &nas.DLNASTransport{
+		PayloadContainerType: nas.PayloadContainerN1SMInformation,
+		PayloadContainer:     msg,
+		PDUSessionID:         &id,
+	})
+}
+
+// page asks the gNBs serving the registration area of the UE to page it,
+// then starts T3513
+func (a *AMF) page(ue *UE) {
+	p := ue.paging
+	if p == nil {
+		p = &paging{}
+		ue.paging = p
+		ue.log.Info("Paging UE", zap.Int("pending", len(ue.pending)))
+	}
+	p.attempts++
+
+	tais := make([]ngap.TAI, 0, len(ue.RegistrationArea))
+	for _, t := range ue.RegistrationArea {
+		if tac, err := t.TacValue(); err == nil {
+			tais = append(tais, ngap.TAI{PLMNIdentity: t.PlmnID, TAC: tac})
+		}
+	}
+	msg := &ngap.Paging{
+		UEPagingIdentity: ngap.FiveGSTMSI{
+			AMFSetID:   ue.GUTI.AMFSetID,
+			AMFPointer: ue.GUTI.AMFPointer,
+			FiveGTMSI:  ue.GUTI.TMSI,
+		},
+		TAIListForPaging: tais,
+	}
+
+	sent := 0
+	for _, gnb := range a.pagingGNBs(tais) {
+		if err := gnb.Send(msg); err != nil {
+			ue.log.Warn("Failed to send Paging", zap.String("gnb", gnb.Key()), zap.Error(err))
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		ue.log.Warn("No gNB to page UE in its registration area")
+	}
+
+	p.timer = a.timers.AfterFunc(a.config.T3513, func() {
+		ue.run(func() { a.pagingExpired(ue, p) })
+	})
+}
+
+// pagingGNBs returns the connected gNBs serving one of the tracking
+// areas
+func (a *AMF) pagingGNBs(tais []ngap.TAI) []*GNB {
+	if a.n2 == nil {
+		return nil
+	}
+
+	var gnbs []*GNB
+	for _, gnb := range a.n2.GNBs() {
+		if !gnb.IsSetUp() {
+			continue
+		}
+		for _, tai := range tais {
+			if gnb.ServesTAI(tai) {
+				gnbs = append(gnbs, gnb)
+				break
+			}
+		}
+	}
+	return gnbs
+}
+
+// pagingExpired handles the expiry of T3513: the UE is paged again, or
+// the transfers waiting for it fail once the retries run out
+func (a *AMF) pagingExpired(ue *UE, p *paging) {
+	if ue.paging != p {
+		return
+	}
+	if p.attempts <= a.config.PagingRetries {
+		ue.log.Debug("T3513 expired, paging UE again", zap.Int("attempt", p.attempts+1))
+		a.page(ue)
+		return
+	}
+
+	ue.paging = nil
+	ue.log.Warn("UE did not answer paging", zap.Int("attempts", p.attempts))
+	a.recordPaging(false)
+	a.failPending(ue, models.N1N2UENotResponding)
+}
+
+// stopPaging ends the paging of a UE that answered it
+func (a *AMF) stopPaging(ue *UE) {
+	if ue.paging == nil {
+		return
+	}
+	ue.paging.timer.Stop()
+	ue.log.Debug("UE answered paging", zap.Int("attempts", ue.paging.attempts))
+	ue.paging = nil
+	a.recordPaging(true)
+}
+
+// failPending drops the transfers waiting for the UE and notifies their
+// senders
+func (a *AMF) failPending(ue *UE, cause models.N1N2MessageTransferCause) {
+	pending := ue.pending
+	ue.pending = nil
+
+	for _, t := range pending {
+		if t.req.N1n2FailureTxfNotifURI == "" || a.nfs.Notifier == nil {
+			continue
+		}
+		err := a.nfs.Notifier.NotifyN1N2TransferFailure(context.Background(), t.req.N1n2FailureTxfNotifURI,
+			models.N1N2MsgTxfrFailureNotification{Cause: cause, N1n2MsgDataURI: a.transferURI(ue, t.id)})
+		if err != nil {
+			ue.log.Warn("Failed to notify N1N2 message transfer failure", zap.Error(err))
+		}
+	}
+}
+
+// transferURI returns the URI of an N1N2 message transfer
+func (a *AMF) transferURI(ue *UE, id string) string {
+	return a.apiRoot() + commPrefix +
+		url.PathEscape(ue.SUPI) + "/n1-n2-messages/" + id
+}
+
+// recordPaging counts a paging by result
+func (a *AMF) recordPaging(success bool) {
+	if a.metrics == nil {
+		return
+	}
+	result := metrics.ResultSuccess
+	if !success {
+		result = metrics.ResultFailure
+	}
+	a.metrics.Pagings.WithLabelValues(result).Inc()
+}
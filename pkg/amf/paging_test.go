@@ -0,0 +1,98 @@
+package amf
+
+import (
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap"
+)
+
+// failureNotifyURI is where the fake SMF hears of undelivered transfers
+const failureNotifyURI = "http://smf.test/n1n2-failure"
+
+// transferToIdleUE gives the registered UE in CM-IDLE a PDU session and
+// has the SMF send it a 5GSM message, which starts paging
+func transferToIdleUE(t *testing.T, u *testUE) {
+	t.Helper()
+
+	ue := u.context()
+	ue.call(func() {
+		ue.PDUSessions = map[uint8]*PDUSession{
+			1: {ID: 1, SNSSAI: testSlice, DNN: "internet", SMContextRef: "http://smf.test/sm-contexts/1"},
+		}
+	})
+	cause, id, err := u.h.amf.N1N2MessageTransfer(testSUPI, models.N1N2MessageTransferReqData{
+		N1MessageContainer:     &models.N1MessageContainer{N1MessageClass: models.N1MessageClassSM},
+		BinaryDataN1Message:    []byte{0x2e, 0x01, 0x01, 0xcb},
+		PduSessionID:           1,
+		N1n2FailureTxfNotifURI: failureNotifyURI,
+	})
+	if err != nil {
+		t.Fatalf("N1N2MessageTransfer() error = %v", err)
+	}
+	if cause != models.N1N2AttemptingToReachUE || id == "" {
+		t.Fatalf("N1N2MessageTransfer() = %q, %q, want %q with a transfer ID", cause, id, models.N1N2AttemptingToReachUE)
+	}
+}
+
+// checkPaging checks that msg asks the gNB to page the UE
+func checkPaging(t *testing.T, u *testUE, msg ngap.Message) {
+	t.Helper()
+
+	p, ok := msg.(*ngap.Paging)
+	if !ok {
+		t.Fatalf("got %T sent to the gNB, want a Paging", msg)
+	}
+	if p.UEPagingIdentity.FiveGTMSI != u.guti.TMSI {
+		t.Errorf("paged 5G-TMSI %08x, want %08x", p.UEPagingIdentity.FiveGTMSI, u.guti.TMSI)
+	}
+	if len(p.TAIListForPaging) != 1 || p.TAIListForPaging[0].TAC != testTAC {
+		t.Errorf("paged in %v, want the registration area", p.TAIListForPaging)
+	}
+}
+
+func TestPagingT3513(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+	}{
+		{"no retry", 0},
+		{"one retry", 1},
+		{"two retries", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.amf.config.PagingRetries = tt.retries
+			t3513 := h.amf.config.T3513
+			u := newTestUE(h)
+			u.register()
+			u.release()
+			h.expectCalls(initialRegistrationCalls...)
+
+			transferToIdleUE(t, u)
+			checkPaging(t, u, recv[ngap.Message](h))
+			for attempt := 1; attempt <= tt.retries; attempt++ {
+				msg, elapsed := h.advanceUntilSent(2 * t3513)
+				checkPaging(t, u, msg)
+				if elapsed < t3513 || elapsed > t3513+timerTick {
+					t.Errorf("paging %d sent %v after the previous one, want T3513 of %v", attempt+1, elapsed, t3513)
+				}
+			}
+
+			// The last expiry fails the transfer
+			h.advance(t3513 - timerStep)
+			h.expectNothing()
+			h.expectCalls()
+			h.advance(timerTick)
+			h.expectNothing()
+			h.expectCalls("Notifier.NotifyN1N2TransferFailure")
+			h.advance(3 * t3513)
+			h.expectNothing()
+			h.expectCalls()
+			if rm, _ := u.state(); rm != RMRegistered {
+				t.Errorf("RM state = %v, want %v", rm, RMRegistered)
+			}
+		})
+	}
+}
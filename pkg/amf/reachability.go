@@ -0,0 +1,162 @@
+package amf
+
+import (
+	"context"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/timer"
+	"github.com/0had0/5G-core/pkg/models"
+	"go.uber.org/zap"
+)
+
+// The UE timers run on a wheel of one second ticks turning about once an
+// hour, the order of T3512
+const (
+	timerTick  = time.Second
+	timerSlots = 4096
+)
+
+// reachability follows a registered UE in CM-IDLE: the mobile reachable
+// timer runs first, then the implicit deregistration timer once the UE
+// is deemed unreachable (TS 23.501 5.3.3.2.2)
+type reachability struct {
+	timer       *timer.Timer
+	unreachable bool
+}
+
+// newTimers creates the wheel of the UE timers and starts it
+func newTimers(cfg *Config) *timer.Wheel {
+	clock := cfg.Clock
+	if clock == nil {
+		clock = timer.SystemClock
+	}
+	w := timer.NewWheel(clock, timerTick, timerSlots)
+	w.Start()
+	return w
+}
+
+// startReachability starts the mobile reachable timer of a registered UE
+// entering CM-IDLE. It runs past T3512 so that a UE late for its
+// periodic registration update is not deemed unreachable.
+func (a *AMF) startReachability(ue *UE) {
+	a.stopReachability(ue)
+
+	t3512 := ue.T3512
+	if t3512 <= 0 {
+		t3512 = a.config.T3512
+	}
+	r := &reachability{}
+	ue.reach = r
+	r.timer = a.timers.AfterFunc(t3512+a.config.MobileReachableMargin, func() {
+		ue.run(func() { a.reachabilityExpired(ue, r) })
+	})
+}
+
+// stopReachability stops the timers of a UE that was heard from or is
+// no longer registered
+func (a *AMF) stopReachability(ue *UE) {
+	if ue.reach == nil {
+		return
+	}
+	ue.reach.timer.Stop()
+	ue.reach = nil
+}
+
+// reachabilityExpired handles the expiry of the mobile reachable timer,
+// after which the UE is unreachable and the implicit deregistration
+// timer starts, and the expiry of that timer, which deregisters the UE
+func (a *AMF) reachabilityExpired(ue *UE, r *reachability) {
+	if ue.reach != r {
+		return
+	}
+	if !r.unreachable {
+		r.unreachable = true
+		ue.log.Info("Mobile reachable timer expired, UE unreachable")
+		a.setReachable(ue, false)
+		r.timer = a.timers.AfterFunc(a.config.ImplicitDeregistration, func() {
+			ue.run(func() { a.reachabilityExpired(ue, r) })
+		})
+		return
+	}
+
+	ue.reach = nil
+	a.deregisterImplicitly(ue)
+}
+
+// deregisterImplicitly deregisters a UE that stayed unreachable and
+// drops its context (TS 23.502 4.2.2.3.1)
+func (a *AMF) deregisterImplicitly(ue *UE) {
+	ue.log.Info("Implicit deregistration timer expired, deregistering UE")
+	a.deregister(ue)
+	if a.metrics != nil {
+		a.metrics.ImplicitDeregistrations.Inc()
+	}
+	a.forget(ue)
+}
+
+// deregister releases the PDU sessions, AM policy association and
+// registration in the UDM of a UE, which moves to RM-DEREGISTERED
+func (a *AMF) deregister(ue *UE) {
+	ctx := context.Background()
+
+	a.releaseSessions(ue)
+	if ue.PolicyAssociationURI != "" {
+		if err := a.nfs.PCF.DeletePolicyAssociation(ctx, ue.PolicyAssociationURI); err != nil {
+			ue.log.Warn("Failed to delete AM policy association", zap.Error(err))
+		}
+		ue.PolicyAssociationURI = ""
+	}
+	if ue.SDMSubscriptionURI != "" {
+		if err := a.nfs.UDM.Unsubscribe(ctx, ue.SDMSubscriptionURI); err != nil {
+			ue.log.Warn("Failed to unsubscribe from subscriber data changes", zap.Error(err))
+		}
+		ue.SDMSubscriptionURI = ""
+	}
+	if ue.AMData != nil {
+		guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+		if err := a.nfs.UDM.DeregisterAMF(ctx, ue.SUPI, guami.Model()); err != nil {
+			ue.log.Warn("UE context management deregistration failed", zap.Error(err))
+		}
+		ue.AMData = nil
+	}
+
+	a.failPending(ue, models.N1N2UENotResponding)
+	a.setRMState(ue, RMDeregistered)
+}
+
+// releaseSessions has the SMFs release the PDU sessions of a UE
+func (a *AMF) releaseSessions(ue *UE) {
+	for id, session := range ue.PDUSessions {
+		if a.nfs.SMF == nil {
+			break
+		}
+		err := a.nfs.SMF.ReleaseSMContext(context.Background(), session.SMContextRef, models.SmContextReleaseData{})
+		if err != nil {
+			ue.log.Warn("Failed to release PDU session", zap.Uint8("pdu_session_id", id), zap.Error(err))
+		}
+	}
+	ue.PDUSessions = nil
+}
+
+// gnbLost moves the UEs connected through a lost gNB to CM-IDLE, as if
+// their N2 connections had been released
+func (a *AMF) gnbLost(gnb *GNB) {
+	a.mu.RLock()
+	ues := make(map[int64]*UE, len(a.ranUEs))
+	for id, ue := range a.ranUEs {
+		ues[id] = ue
+	}
+	a.mu.RUnlock()
+
+	for id, ue := range ues {
+		id, ue := id, ue
+		ue.run(func() {
+			if ue.gnb != gnb || ue.amfUENGAPID != id {
+				return
+			}
+			ue.log.Info("N2 connection lost with gNB", zap.String("gnb", gnb.Key()))
+			ue.handover = nil
+			a.releaseComplete(ue, id)
+		})
+	}
+}
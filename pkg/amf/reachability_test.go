@@ -0,0 +1,106 @@
+package amf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/nas"
+)
+
+// reachable reports whether the AMF deems the UE reachable
+func (u *testUE) reachable() bool {
+	ue := u.context()
+	var reachable bool
+	ue.call(func() { reachable = ue.reachable })
+	return reachable
+}
+
+func TestReachabilityTimers(t *testing.T) {
+	cfg := testConfig(nil)
+	mobileReachable := cfg.T3512 + cfg.MobileReachableMargin
+	deregistration := mobileReachable + cfg.ImplicitDeregistration
+
+	tests := []struct {
+		name      string
+		idle      time.Duration // time spent in CM-IDLE
+		rm        RMState
+		reachable bool
+		calls     []string
+	}{
+		{"before the mobile reachable timer expires", mobileReachable - timerStep, RMRegistered, true, nil},
+		{"mobile reachable timer expired", mobileReachable + timerTick, RMRegistered, false, nil},
+		{"before implicit deregistration", deregistration - timerStep, RMRegistered, false, nil},
+		{"implicit deregistration", deregistration + timerTick, RMDeregistered, false, deregistrationCalls},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			u := newTestUE(h)
+			u.register()
+			u.release()
+			h.expectCalls(initialRegistrationCalls...)
+
+			h.advance(tt.idle)
+			h.expectNothing()
+			h.expectCalls(tt.calls...)
+			if rm, _ := u.state(); rm != tt.rm {
+				t.Fatalf("RM state = %v, want %v", rm, tt.rm)
+			}
+			if tt.rm == RMDeregistered {
+				if _, ok := h.amf.UE(testSUPI); ok {
+					t.Error("context kept after implicit deregistration")
+				}
+				if _, ok := h.amf.gutis.lookup(u.guti.AMFSetID, u.guti.AMFPointer, u.guti.TMSI); ok {
+					t.Errorf("5G-GUTI %s kept after implicit deregistration", u.guti)
+				}
+				return
+			}
+			if got := u.reachable(); got != tt.reachable {
+				t.Errorf("reachable = %v, want %v", got, tt.reachable)
+			}
+		})
+	}
+}
+
+func TestReachabilityRestartsWhenUEIsHeardFrom(t *testing.T) {
+	cfg := testConfig(nil)
+	mobileReachable := cfg.T3512 + cfg.MobileReachableMargin
+
+	tests := []struct {
+		name  string
+		after time.Duration // time in CM-IDLE before the UE comes back
+	}{
+		{"while reachable", mobileReachable / 2},
+		{"once unreachable", mobileReachable + cfg.ImplicitDeregistration/2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			u := newTestUE(h)
+			u.register()
+			u.release()
+
+			h.advance(tt.after)
+			u.update(nas.RegistrationTypePeriodicUpdating, testTAC)
+			u.accept(false)
+			if !u.reachable() {
+				t.Error("UE back in CM-CONNECTED is not reachable")
+			}
+			u.release()
+
+			// The timers run again from the new release
+			h.advance(mobileReachable - timerStep)
+			if !u.reachable() {
+				t.Error("UE unreachable before its restarted mobile reachable timer expired")
+			}
+			h.advance(timerTick)
+			if u.reachable() {
+				t.Error("UE reachable after its restarted mobile reachable timer expired")
+			}
+			h.expectNothing()
+			if rm, _ := u.state(); rm != RMRegistered {
+				t.Errorf("RM state = %v, want %v", rm, RMRegistered)
+			}
+		})
+	}
+}
@@ -0,0 +1,620 @@
+package amf
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"time"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/security"
+	"go.uber.org/zap"
+)
+
+// abba is the Anti-Bidding down Between Architectures parameter sent in
+// authentication and bound into Kamf (TS 33.501 A.7.1)
+var abba = []byte{0x00, 0x00}
+
+// maxAllowedNSSAI is the number of slices an allowed NSSAI holds
+// (TS 24.501 9.11.3.37)
+const maxAllowedNSSAI = 8
+
+// registrationState is the message a registration waits for
+type registrationState int
+
+const (
+	regWaitIdentity registrationState = iota
+	regWaitAuthentication
+	regWaitSecurityMode
+	regWaitComplete
+)
+
+// String returns the message awaited in the state
+func (s registrationState) String() string {
+	switch s {
+	case regWaitIdentity:
+		return "identity-response"
+	case regWaitAuthentication:
+		return "authentication-response"
+	case regWaitSecurityMode:
+		return "security-mode-complete"
+	default:
+		return "registration-complete"
+	}
+}
+
+// registration is a registration procedure in progress (TS 23.502
+// 4.2.2.2.2)
+type registration struct {
+	state registrationState
+	req   *nas.RegistrationRequest
+
+	// 5G AKA challenge sent to the UE
+	authCtx        *models.UEAuthenticationCtx
+	rand           []byte
+	ngKSI          nas.NgKSI
+	resynchronized bool
+
+	// previousSecurity is restored when the UE rejects a new context
+	previousSecurity *SecurityContext
+}
+
+// expect checks that a registration waits for the given message,
+// answering with a 5GMM status otherwise
+func (a *AMF) expect(ue *UE, state registrationState) bool {
+	if ue.reg != nil && ue.reg.state == state {
+		return true
+	}
+	ue.log.Warn("Unexpected NAS message", zap.Stringer("expected", state))
+	a.sendNAS(ue, &nas.Status5GMM{Cause: nas.Cause5GMMMessageTypeNotCompatibleWithState})
+	return false
+}
+
+// handleRegistrationRequest starts a registration. A new request from
+// the UE replaces the registration in progress.
+func (a *AMF) handleRegistrationRequest(ue *UE, req *nas.RegistrationRequest, protected bool) {
+	if a.metrics != nil {
+		a.metrics.RegistrationAttempts.WithLabelValues(req.RegistrationType.String()).Inc()
+	}
+	if ue.reg != nil {
+		ue.log.Info("Restarting registration", zap.Stringer("waiting_for", ue.reg.state))
+	}
+	ue.reg = &registration{req: req}
+	ue.log.Info("Registration requested",
+		zap.Stringer("type", req.RegistrationType), zap.Stringer("identity_type", req.MobileIdentity.Type))
+
+	tac, err := ue.TAI.TacValue()
+	if err != nil || !a.config.SupportsTAI(ngap.TAI{PLMNIdentity: ue.TAI.PlmnID, TAC: tac}) {
+		a.rejectRegistration(ue, nas.Cause5GMMTrackingAreaNotAllowed)
+		return
+	}
+	if req.RegistrationType == nas.RegistrationTypeMobilityUpdating && ue.RegistrationArea != nil &&
+		!ue.inRegistrationArea(ue.TAI) {
+		ue.log.Info("UE left its registration area", zap.String("tac", ue.TAI.Tac))
+	}
+
+	switch {
+	case req.UESecurityCapability != nil:
+		ue.SecurityCapability = *req.UESecurityCapability
+	case req.RegistrationType == nas.RegistrationTypeInitial:
+		a.rejectRegistration(ue, nas.Cause5GMMInvalidMandatoryInformation)
+		return
+	}
+
+	switch id := req.MobileIdentity; id.Type {
+	case nas.IdentitySUCI:
+		ue.SUCI = id.SUCI.String()
+		a.authenticate(ue, nil)
+	case nas.IdentityGUTI:
+		switch {
+		case ue.SUPI == "":
+			// The GUTI was not allocated here or is forgotten
+			ue.reg.state = regWaitIdentity
+			a.sendNAS(ue, &nas.IdentityRequest{IdentityType: nas.IdentitySUCI})
+		case protected:
+			// The current security context vouches for the UE
+			a.completeRegistration(ue)
+		default:
+			a.authenticate(ue, nil)
+		}
+	default:
+		a.rejectRegistration(ue, nas.Cause5GMMUEIdentityCannotBeDerived)
+	}
+}
+
+// handleIdentityResponse authenticates the UE with its SUCI
+func (a *AMF) handleIdentityResponse(ue *UE, m *nas.IdentityResponse) {
+	if !a.expect(ue, regWaitIdentity) {
+		return
+	}
+	if m.MobileIdentity.Type != nas.IdentitySUCI || m.MobileIdentity.SUCI == nil {
+		a.rejectRegistration(ue, nas.Cause5GMMUEIdentityCannotBeDerived)
+		return
+	}
+
+	ue.SUCI = m.MobileIdentity.SUCI.String()
+	a.authenticate(ue, nil)
+}
+
+// authenticate asks the AUSF for a 5G AKA challenge and sends it to the
+// UE (TS 33.501 6.1.3.2)
+func (a *AMF) authenticate(ue *UE, resync *models.ResynchronizationInfo) {
+	info := models.AuthenticationInfo{
+		SupiOrSuci:            ue.SUCI,
+		ServingNetworkName:    ServingNetworkName(ue.TAI.PlmnID),
+		ResynchronizationInfo: resync,
+	}
+	if info.SupiOrSuci == "" {
+		info.SupiOrSuci = ue.SUPI
+	}
+
+	authCtx, err := a.nfs.AUSF.Authenticate(context.Background(), info)
+	if err != nil {
+		ue.log.Warn("Authentication request to AUSF failed", zap.Error(err))
+		a.rejectRegistration(ue, nfFailureCause(err, nas.Cause5GMMUEIdentityCannotBeDerived))
+		return
+	}
+
+	rand, err := hex.DecodeString(authCtx.Var5gAuthData.Rand)
+	if err != nil || len(rand) != 16 {
+		ue.log.Warn("AUSF returned an invalid RAND")
+		a.rejectRegistration(ue, nas.Cause5GMMProtocolErrorUnspecified)
+		return
+	}
+	autn, err := hex.DecodeString(authCtx.Var5gAuthData.Autn)
+	if err != nil || len(autn) != 16 {
+		ue.log.Warn("AUSF returned an invalid AUTN")
+		a.rejectRegistration(ue, nas.Cause5GMMProtocolErrorUnspecified)
+		return
+	}
+
+	ue.reg.state = regWaitAuthentication
+	ue.reg.authCtx, ue.reg.rand = authCtx, rand
+	ue.reg.ngKSI = ue.allocateKSI()
+	ue.log.Debug("Sending authentication challenge", logger.RAND(rand), logger.AUTN(autn))
+	a.sendNAS(ue, &nas.AuthenticationRequest{
+		NgKSI: ue.reg.ngKSI,
+		ABBA:  abba,
+		RAND:  rand,
+		AUTN:  autn,
+	})
+}
+
+// handleAuthenticationResponse checks the RES* of the UE against the
+// expected HRES*, then has the AUSF confirm it
+func (a *AMF) handleAuthenticationResponse(ue *UE, m *nas.AuthenticationResponse) {
+	if !a.expect(ue, regWaitAuthentication) {
+		return
+	}
+
+	if !checkHRESStar(ue.reg.rand, m.RESStar, ue.reg.authCtx.Var5gAuthData.HxresStar) {
+		ue.log.Warn("UE authentication failed", zap.String("reason", "HRES* mismatch"))
+		a.rejectAuthentication(ue)
+		return
+	}
+
+	resp, err := a.nfs.AUSF.Confirm(context.Background(), ue.reg.authCtx, hex.EncodeToString(m.RESStar))
+	if err != nil {
+		ue.log.Warn("Authentication confirmation to AUSF failed", zap.Error(err))
+		a.rejectRegistration(ue, nfFailureCause(err, nas.Cause5GMMIllegalUE))
+		return
+	}
+	if resp.AuthResult != models.AuthResultSuccess {
+		ue.log.Warn("UE authentication failed", zap.String("reason", string(resp.AuthResult)))
+		a.rejectAuthentication(ue)
+		return
+	}
+
+	kseaf, err := hex.DecodeString(resp.Kseaf)
+	if err != nil || resp.Supi == "" {
+		ue.log.Warn("AUSF returned an invalid confirmation")
+		a.rejectRegistration(ue, nas.Cause5GMMProtocolErrorUnspecified)
+		return
+	}
+
+	a.bindSUPI(ue, resp.Supi)
+	ue.log.Info("UE authenticated")
+	a.startSecurityMode(ue, kseaf)
+}
+
+// checkHRESStar compares the HRES* of the UE response with the HXRES*
+// of the challenge (TS 33.501 6.1.3.2)
+func checkHRESStar(rand, resStar []byte, hxresStar string) bool {
+	expected, err := hex.DecodeString(hxresStar)
+	if err != nil || len(expected) != 16 {
+		return false
+	}
+	return bytes.Equal(security.HXRESStar(rand, resStar), expected)
+}
+
+// handleAuthenticationFailure resynchronises the sequence numbers once
+// on a synch failure and gives up on any other failure
+func (a *AMF) handleAuthenticationFailure(ue *UE, m *nas.AuthenticationFailure) {
+	if !a.expect(ue, regWaitAuthentication) {
+		return
+	}
+
+	ue.log.Warn("UE rejected the authentication challenge", zap.Stringer("cause", m.Cause))
+	if m.Cause == nas.Cause5GMMSynchFailure && !ue.reg.resynchronized && len(m.AUTS) == 14 {
+		ue.reg.resynchronized = true
+		a.authenticate(ue, &models.ResynchronizationInfo{
+			Rand: hex.EncodeToString(ue.reg.rand),
+			Auts: hex.EncodeToString(m.AUTS),
+		})
+		return
+	}
+
+	// The UE does not trust the network: there is nothing to reject
+	a.failRegistration(ue, m.Cause, ngap.CauseNASAuthenticationFailure)
+}
+
+// rejectAuthentication tells the UE it failed authentication
+func (a *AMF) rejectAuthentication(ue *UE) {
+	a.sendNAS(ue, &nas.AuthenticationReject{})
+	a.failRegistration(ue, nas.Cause5GMMIllegalUE, ngap.CauseNASAuthenticationFailure)
+}
+
+// startSecurityMode activates a new NAS security context with the
+// algorithms preferred by the AMF among those of the UE (TS 33.501
+// 6.7.2)
+func (a *AMF) startSecurityMode(ue *UE, kseaf []byte) {
+	emergency := ue.reg.req != nil && ue.reg.req.RegistrationType == nas.RegistrationTypeEmergency
+	algorithms, ok := selectAlgorithms(a.config, ue.SecurityCapability, emergency)
+	if !ok {
+		ue.log.Warn("No NAS security algorithm in common with the UE")
+		a.rejectRegistration(ue, nas.Cause5GMMUESecurityCapabilitiesMismatch)
+		return
+	}
+	sc := newSecurityContext(ue.reg.ngKSI, kseaf, ue.SUPI, abba, algorithms)
+	ue.log.Debug("Selected NAS security algorithms",
+		zap.Uint8("ciphering", algorithms.Ciphering), zap.Uint8("integrity", algorithms.Integrity))
+
+	// The command is the first message protected by the new context
+	ue.reg.previousSecurity, ue.Security = ue.Security, sc
+	ue.reg.state = regWaitSecurityMode
+	a.sendNASWithHeader(ue, &nas.SecurityModeCommand{
+		SelectedAlgorithms:           algorithms,
+		NgKSI:                        sc.NgKSI,
+		ReplayedUESecurityCapability: ue.SecurityCapability,
+		IMEISVRequest:                ue.PEI == "",
+	}, nas.SecurityHeaderIntegrityProtectedWithNewContext)
+}
+
+// handleSecurityModeComplete takes the complete Registration Request
+// when the UE first sent only its cleartext IEs, then registers the UE
+func (a *AMF) handleSecurityModeComplete(ue *UE, m *nas.SecurityModeComplete, protected bool) {
+	if !a.expect(ue, regWaitSecurityMode) {
+		return
+	}
+	if !protected {
+		ue.log.Warn("Dropping Security Mode Complete not protected by the new context")
+		return
+	}
+	ue.reg.previousSecurity = nil
+
+	if m.IMEISV != nil && m.IMEISV.Type == nas.IdentityIMEISV {
+		ue.PEI = "imeisv-" + m.IMEISV.PEI
+	}
+	if m.NASMessageContainer != nil {
+		msg, err := nas.Decode(m.NASMessageContainer)
+		if req, ok := msg.(*nas.RegistrationRequest); ok {
+			ue.reg.req = req
+			if req.UESecurityCapability != nil {
+				ue.SecurityCapability = *req.UESecurityCapability
+			}
+		} else {
+			ue.log.Warn("Ignoring invalid NAS message container", zap.Error(err))
+		}
+	}
+
+	a.completeRegistration(ue)
+}
+
+// handleSecurityModeReject restores the previous security context and
+// ends the registration
+func (a *AMF) handleSecurityModeReject(ue *UE, m *nas.SecurityModeReject) {
+	if !a.expect(ue, regWaitSecurityMode) {
+		return
+	}
+
+	ue.log.Warn("UE rejected the security mode", zap.Stringer("cause", m.Cause))
+	ue.Security = ue.reg.previousSecurity
+	a.failRegistration(ue, m.Cause, ngap.CauseNASUnspecified)
+}
+
+// completeRegistration registers an authenticated UE with the UDM, NSSF
+// and PCF as needed and accepts it. A registered UE updating its
+// registration keeps its subscription and policy.
+func (a *AMF) completeRegistration(ue *UE) {
+	req := ue.reg.req
+	rm, _ := ue.State()
+	initial := rm == RMDeregistered || ue.AMData == nil
+
+	if initial {
+		if cause, ok := a.registerWithUDM(ue); !ok {
+			a.rejectRegistration(ue, cause)
+			return
+		}
+	}
+	if initial || req.RegistrationType != nas.RegistrationTypePeriodicUpdating || req.RequestedNSSAI != nil {
+		if cause, ok := a.selectSlices(ue); !ok {
+			a.rejectRegistration(ue, cause)
+			return
+		}
+	}
+	if initial {
+		a.createPolicyAssociation(ue)
+	}
+
+	a.acceptRegistration(ue)
+}
+
+// registerWithUDM registers the AMF as serving AMF of the UE, fetches
+// its access and mobility subscription and subscribes to its changes
+func (a *AMF) registerWithUDM(ue *UE) (nas.Cause5GMM, bool) {
+	ctx := context.Background()
+	guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+
+	err := a.nfs.UDM.RegisterAMF(ctx, ue.SUPI, models.Amf3GppAccessRegistration{
+		AmfInstanceID:          a.config.InstanceID,
+		DeregCallbackURI:       a.callbackURI(ue, "dereg-notify"),
+		Guami:                  guami.Model(),
+		RatType:                models.RatTypeNR,
+		InitialRegistrationInd: ue.reg.req.RegistrationType == nas.RegistrationTypeInitial,
+		Pei:                    ue.PEI,
+	})
+	if err != nil {
+		ue.log.Warn("UE context management registration failed", zap.Error(err))
+		return nfFailureCause(err, nas.Cause5GMM5GSServicesNotAllowed), false
+	}
+
+	data, err := a.nfs.UDM.GetAMData(ctx, ue.SUPI, ue.TAI.PlmnID)
+	if err != nil {
+		ue.log.Warn("Fetching access and mobility subscription failed", zap.Error(err))
+		return nfFailureCause(err, nas.Cause5GMMPLMNNotAllowed), false
+	}
+	ue.AMData = data
+
+	ue.T3512 = a.config.T3512
+	if data.SubsRegTimer > 0 {
+		ue.T3512 = time.Duration(data.SubsRegTimer) * time.Second
+	}
+
+	// Without the subscription the AMF misses updates, but the UE can
+	// still be served
+	uri, err := a.nfs.UDM.Subscribe(ctx, ue.SUPI, models.SdmSubscription{
+		NfInstanceID:          a.config.InstanceID,
+		CallbackReference:     a.callbackURI(ue, "sdm-notify"),
+		MonitoredResourceURIs: []string{"/" + ue.SUPI + "/am-data"},
+	})
+	if err != nil {
+		ue.log.Warn("Subscription to subscriber data changes failed", zap.Error(err))
+	}
+	ue.SDMSubscriptionURI = uri
+	return 0, true
+}
+
+// selectSlices has the NSSF compute the allowed NSSAI from the
+// requested and subscribed slices
+func (a *AMF) selectSlices(ue *UE) (nas.Cause5GMM, bool) {
+	info := models.SliceInfoForRegistration{RequestedNssai: ue.reg.req.RequestedNSSAI}
+	if ue.AMData != nil && ue.AMData.Nssai != nil {
+		for _, s := range ue.AMData.Nssai.DefaultSingleNssais {
+			info.SubscribedNssai = append(info.SubscribedNssai, models.SubscribedSnssai{SubscribedSnssai: s, DefaultIndication: true})
+		}
+		for _, s := range ue.AMData.Nssai.SingleNssais {
+			info.SubscribedNssai = append(info.SubscribedNssai, models.SubscribedSnssai{SubscribedSnssai: s})
+		}
+	}
+
+	sliceInfo, err := a.nfs.NSSF.SelectSlices(context.Background(), info, ue.TAI)
+	if err != nil {
+		ue.log.Warn("Network slice selection failed", zap.Error(err))
+		return nfFailureCause(err, nas.Cause5GMMNoNetworkSlicesAvailable), false
+	}
+
+	var allowed []models.Snssai
+	for _, n := range sliceInfo.AllowedNssaiList {
+		if n.AccessType != models.AccessType3GPP {
+			continue
+		}
+		for _, s := range n.AllowedSnssaiList {
+			allowed = append(allowed, s.AllowedSnssai)
+		}
+	}
+	if len(allowed) == 0 {
+		ue.log.Warn("No network slice allowed for UE")
+		return nas.Cause5GMMNoNetworkSlicesAvailable, false
+	}
+	if len(allowed) > maxAllowedNSSAI {
+		allowed = allowed[:maxAllowedNSSAI]
+	}
+	ue.AllowedNSSAI = allowed
+	return 0, true
+}
+
+// createPolicyAssociation creates the AM policy association of the UE.
+// The UE is served with local policy when the PCF cannot be reached.
+func (a *AMF) createPolicyAssociation(ue *UE) {
+	guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+	tai := ue.TAI
+
+	uri, _, err := a.nfs.PCF.CreatePolicyAssociation(context.Background(), models.PolicyAssociationRequest{
+		NotificationURI: a.callbackURI(ue, "am-policy"),
+		Supi:            ue.SUPI,
+		Pei:             ue.PEI,
+		AccessType:      models.AccessType3GPP,
+		RatType:         models.RatTypeNR,
+		Tai:             &tai,
+		ServingPlmn:     ue.TAI.PlmnID,
+		Guami:           guami.Model(),
+		AllowedSnssais:  ue.AllowedNSSAI,
+	})
+	if err != nil {
+		ue.log.Warn("AM policy association failed, using local policy", zap.Error(err))
+		return
+	}
+	ue.PolicyAssociationURI = uri
+}
+
+// callbackURI returns the URI other NFs notify about the UE
+func (a *AMF) callbackURI(ue *UE, name string) string {
+	return a.apiRoot() + "/namf-callback/v1/" + url.PathEscape(ue.SUPI) + "/" + name
+}
+
+// acceptRegistration moves the UE to RM-REGISTERED and sends the
+// Registration Accept with a registration area around the current
+// tracking area, and a new 5G-GUTI except on periodic updates
+func (a *AMF) acceptRegistration(ue *UE) {
+	req := ue.reg.req
+	accept := &nas.RegistrationAccept{
+		Result:       nas.RegistrationResult{Access: nas.Access3GPP},
+		TAIList:      a.config.RegistrationArea(ue.TAI),
+		AllowedNSSAI: ue.AllowedNSSAI,
+	}
+	if ue.T3512 > 0 {
+		t3512 := ue.T3512
+		accept.T3512 = &t3512
+	}
+	if req.RegistrationType != nas.RegistrationTypePeriodicUpdating || ue.GUTI == nil {
+		guti, err := a.reallocateGUTI(ue)
+		if err != nil {
+			ue.log.Error("5G-GUTI allocation failed", zap.Error(err))
+			a.rejectRegistration(ue, nas.Cause5GMMProtocolErrorUnspecified)
+			return
+		}
+		accept.GUTI = guti
+	}
+
+	ue.RegistrationArea = accept.TAIList
+	a.setRMState(ue, RMRegistered)
+	if a.metrics != nil {
+		a.metrics.RegistrationSuccesses.WithLabelValues(req.RegistrationType.String()).Inc()
+	}
+
+	// A new GUTI is confirmed by a Registration Complete
+	if accept.GUTI != nil {
+		ue.reg.state = regWaitComplete
+	} else {
+		ue.reg = nil
+	}
+	ue.log.Info("Registration accepted", zap.Int("allowed_slices", len(ue.AllowedNSSAI)),
+		zap.Int("registration_area", len(accept.TAIList)))
+	a.sendNASWithContextSetup(ue, accept, nil)
+}
+
+// handleRegistrationComplete ends the registration. The UE confirmed
+// its new 5G-GUTI, so the previous one is freed.
+func (a *AMF) handleRegistrationComplete(ue *UE, protected bool) {
+	if !protected {
+		ue.log.Warn("Dropping Registration Complete without integrity protection")
+		return
+	}
+	if !a.expect(ue, regWaitComplete) {
+		return
+	}
+	ue.reg = nil
+	a.gutis.release(ue, ue.oldGUTI)
+	ue.oldGUTI = nil
+	ue.log.Debug("Registration complete", zap.String("guti", ue.GUTI.String()))
+}
+
+// handleDeregistrationRequest deregisters a UE at its request, then
+// releases its N2 connection; the context is dropped once the release
+// completes (TS 23.502 4.2.2.3.2). A UE switching off gets no answer.
+// An unprotected request from a UE with a security context is still
+// honoured, the UE having possibly lost it (TS 24.501 5.5.2.2.1).
+func (a *AMF) handleDeregistrationRequest(ue *UE, m *nas.DeregistrationRequestUEOriginating, protected bool) {
+	ue.log.Info("UE requested deregistration", zap.Bool("switch_off", m.DeregistrationType.SwitchOff),
+		zap.Bool("protected", protected))
+	ue.reg = nil
+	a.deregister(ue)
+
+	if !m.DeregistrationType.SwitchOff {
+		a.sendNAS(ue, &nas.DeregistrationAcceptUEOriginating{})
+	}
+	a.releaseN2(ue, ngap.CauseNASDeregister)
+}
+
+// rejectRegistration sends a Registration Reject and ends the
+// registration
+func (a *AMF) rejectRegistration(ue *UE, cause nas.Cause5GMM) {
+	ue.log.Warn("Registration rejected", zap.Stringer("cause", cause))
+	a.sendNAS(ue, &nas.RegistrationReject{Cause: cause})
+	a.failRegistration(ue, cause, ngap.CauseNASDeregister)
+}
+
+// failRegistration ends a failed registration: the UE is deregistered
+// and its N2 connection released
+func (a *AMF) failRegistration(ue *UE, cause nas.Cause5GMM, release ngap.Cause) {
+	if a.metrics != nil && ue.reg != nil {
+		a.metrics.RegistrationFailures.WithLabelValues(ue.reg.req.RegistrationType.String(), cause.String()).Inc()
+	}
+	ue.reg = nil
+	a.setRMState(ue, RMDeregistered)
+	if ue.gnb != nil {
+		a.releaseN2(ue, release)
+	}
+}
+
+// nfFailureCause maps the failure of a request to another NF to a 5GMM
+// cause: rejected when the NF refused the UE, a protocol error when the
+// NF could not answer
+func nfFailureCause(err error, rejected nas.Cause5GMM) nas.Cause5GMM {
+	var appErr apperrors.AppError
+	if errors.As(err, &appErr) {
+		switch appErr.Type {
+		case apperrors.ErrorTypeNotFound, apperrors.ErrorTypeForbidden:
+			return rejected
+		}
+	}
+	return nas.Cause5GMMProtocolErrorUnspecified
+}
+
+// bindSUPI records the SUPI of an authenticated UE. An older context of
+// the same subscriber is dropped.
+func (a *AMF) bindSUPI(ue *UE, supi string) {
+	a.mu.Lock()
+	old := a.supis[supi]
+	a.supis[supi] = ue
+	a.mu.Unlock()
+
+	ue.SUPI = supi
+	ue.log = a.log.With(logger.SUPI(supi))
+
+	if old != nil && old != ue {
+		ue.log.Info("Replacing previous context of UE")
+		old.run(func() {
+			old.reg = nil
+			a.setRMState(old, RMDeregistered)
+			if old.gnb != nil {
+				a.releaseN2(old, ngap.CauseNASUnspecified)
+			} else {
+				a.forget(old)
+			}
+		})
+	}
+}
+
+// reallocateGUTI gives the UE a new 5G-GUTI in the PLMN of its
+// tracking area. The current GUTI stays valid until the UE confirms the
+// new one (TS 24.501 5.5.1.2.4); a GUTI still unconfirmed from an
+// earlier registration is freed.
+func (a *AMF) reallocateGUTI(ue *UE) (*nas.GUTI, error) {
+	guti, err := a.gutis.allocate(ue, ue.TAI.PlmnID)
+	if err != nil {
+		return nil, err
+	}
+
+	if ue.oldGUTI != nil {
+		a.gutis.release(ue, ue.oldGUTI)
+	}
+	ue.oldGUTI, ue.GUTI = ue.GUTI, guti
+	return guti, nil
+}
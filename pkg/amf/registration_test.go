@@ -0,0 +1,256 @@
+package amf
+
+import (
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/nas"
+)
+
+// Requests of a first registration with the core network
+var initialRegistrationCalls = []string{
+	"AUSF.Authenticate", "AUSF.Confirm",
+	"UDM.RegisterAMF", "UDM.GetAMData", "UDM.Subscribe",
+	"NSSF.SelectSlices",
+	"PCF.CreatePolicyAssociation",
+}
+
+// Requests of a deregistration of a registered UE
+var deregistrationCalls = []string{
+	"PCF.DeletePolicyAssociation", "UDM.Unsubscribe", "UDM.DeregisterAMF",
+}
+
+func TestRegistrationFlows(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// idle registers the UE and releases its N2 connection first
+		idle bool
+		flow func(u *testUE)
+
+		calls []string
+		rm    RMState
+		cm    CMState
+	}{
+		{
+			name:  "initial registration",
+			flow:  (*testUE).register,
+			calls: initialRegistrationCalls,
+			rm:    RMRegistered,
+			cm:    CMConnected,
+		},
+		{
+			name: "initial registration then release",
+			flow: func(u *testUE) {
+				u.register()
+				u.release()
+			},
+			calls: initialRegistrationCalls,
+			rm:    RMRegistered,
+			cm:    CMIdle,
+		},
+		{
+			name: "mobility registration update",
+			idle: true,
+			flow: func(u *testUE) {
+				old := *u.guti
+				u.update(nas.RegistrationTypeMobilityUpdating, testTAC+1)
+				u.accept(true)
+				if *u.guti == old {
+					u.h.t.Errorf("5G-GUTI %s kept after a mobility registration update", old)
+				}
+				if ue := u.context(); ue.oldGUTI != nil || ue.reg != nil {
+					u.h.t.Errorf("registration still waiting for %v with old GUTI %v", ue.reg, ue.oldGUTI)
+				}
+				if area := u.context().RegistrationArea; len(area) != 1 || area[0].Tac != "000002" {
+					u.h.t.Errorf("registration area = %v, want the new tracking area", area)
+				}
+			},
+			calls: []string{"NSSF.SelectSlices"},
+			rm:    RMRegistered,
+			cm:    CMConnected,
+		},
+		{
+			name: "periodic registration update",
+			idle: true,
+			flow: func(u *testUE) {
+				old := *u.guti
+				u.update(nas.RegistrationTypePeriodicUpdating, testTAC)
+				u.accept(false)
+				if ue := u.context(); ue.GUTI == nil || *ue.GUTI != old || ue.reg != nil {
+					u.h.t.Errorf("GUTI %v, registration %v after a periodic update, want %s and none",
+						ue.GUTI, ue.reg, old)
+				}
+			},
+			rm: RMRegistered,
+			cm: CMConnected,
+		},
+		{
+			name: "periodic registration update then release",
+			idle: true,
+			flow: func(u *testUE) {
+				u.update(nas.RegistrationTypePeriodicUpdating, testTAC)
+				u.accept(false)
+				u.release()
+			},
+			rm: RMRegistered,
+			cm: CMIdle,
+		},
+		{
+			name: "deregistration from CM-IDLE",
+			idle: true,
+			flow: func(u *testUE) {
+				u.sendInitial(testTAC, u.protect(u.deregistrationRequest(false), nas.SecurityHeaderIntegrityProtected))
+				recvNAS[*nas.DeregistrationAcceptUEOriginating](u)
+				u.releaseComplete()
+			},
+			calls: deregistrationCalls,
+			rm:    RMDeregistered,
+			cm:    CMIdle,
+		},
+		{
+			name: "deregistration in CM-CONNECTED",
+			flow: func(u *testUE) {
+				u.register()
+				u.h.expectCalls(initialRegistrationCalls...)
+				u.sendUplink(u.protect(u.deregistrationRequest(false), nas.SecurityHeaderIntegrityProtectedAndCiphered))
+				recvNAS[*nas.DeregistrationAcceptUEOriginating](u)
+				u.releaseComplete()
+			},
+			calls: deregistrationCalls,
+			rm:    RMDeregistered,
+			cm:    CMIdle,
+		},
+		{
+			name: "switch off",
+			idle: true,
+			flow: func(u *testUE) {
+				u.sendInitial(testTAC, u.protect(u.deregistrationRequest(true), nas.SecurityHeaderIntegrityProtected))
+				u.releaseComplete()
+			},
+			calls: deregistrationCalls,
+			rm:    RMDeregistered,
+			cm:    CMIdle,
+		},
+		{
+			name: "deregistration without integrity protection",
+			idle: true,
+			flow: func(u *testUE) {
+				u.sendInitial(testTAC, u.plain(u.deregistrationRequest(true)))
+				u.releaseComplete()
+			},
+			calls: deregistrationCalls,
+			rm:    RMDeregistered,
+			cm:    CMIdle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			u := newTestUE(h)
+			if tt.idle {
+				u.register()
+				u.release()
+				h.expectCalls(initialRegistrationCalls...)
+			}
+
+			tt.flow(u)
+			h.expectNothing()
+			h.expectCalls(tt.calls...)
+			if rm, cm := u.state(); rm != tt.rm || cm != tt.cm {
+				t.Errorf("state = %v %v, want %v %v", rm, cm, tt.rm, tt.cm)
+			}
+			if _, ok := h.amf.UE(testSUPI); ok != (tt.rm == RMRegistered) {
+				t.Errorf("context kept = %v in %v", ok, tt.rm)
+			}
+		})
+	}
+}
+
+func TestUnprotectedNASDropped(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// before brings the UE to the state the message is sent in
+		before func(u *testUE)
+		msg    nas.Message
+	}{
+		{
+			name: "security mode complete",
+			before: func(u *testUE) {
+				u.sendInitial(testTAC, u.plain(&nas.RegistrationRequest{
+					RegistrationType: nas.RegistrationTypeInitial,
+					NgKSI:            nas.NgKSI{KSI: nas.NoKeyAvailable},
+					MobileIdentity: nas.MobileIdentity{Type: nas.IdentitySUCI, SUCI: &nas.SUCI{
+						PlmnID:           testPLMN,
+						RoutingIndicator: "0000",
+						SchemeOutput:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+					}},
+					UESecurityCapability: &nas.UESecurityCapability{EA: 0xf0, IA: 0xf0},
+				}))
+				recvNAS[*nas.AuthenticationRequest](u)
+				u.sendUplink(u.plain(&nas.AuthenticationResponse{RESStar: testRESStar}))
+				recvNAS[*nas.SecurityModeCommand](u)
+			},
+			msg: &nas.SecurityModeComplete{},
+		},
+		{
+			name: "registration complete",
+			before: func(u *testUE) {
+				u.register()
+				u.release()
+				u.update(nas.RegistrationTypeMobilityUpdating, testTAC)
+				accept := recvNAS[*nas.RegistrationAccept](u)
+				u.guti = accept.GUTI
+			},
+			msg: &nas.RegistrationComplete{},
+		},
+		{
+			name:   "UL NAS transport",
+			before: (*testUE).register,
+			msg: &nas.ULNASTransport{
+				PayloadContainerType: nas.PayloadContainerN1SMInformation,
+				PayloadContainer:     []byte{0x2e, 0x01, 0x01, 0xc1},
+			},
+		},
+		{
+			name:   "identity response with a 5G-GUTI",
+			before: (*testUE).register,
+			msg: &nas.IdentityResponse{
+				MobileIdentity: nas.MobileIdentity{Type: nas.IdentityGUTI, GUTI: &nas.GUTI{PlmnID: testPLMN, TMSI: 1}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			u := newTestUE(h)
+			tt.before(u)
+			h.settle()
+			h.nfs.takeCalls()
+
+			var reg *registration
+			if ue, ok := h.amf.UE(testSUPI); ok {
+				ue.call(func() { reg = ue.reg })
+			}
+			var state registrationState
+			if reg != nil {
+				state = reg.state
+			}
+			rm, cm := u.state()
+
+			u.sendUplink(u.plain(tt.msg))
+			h.expectNothing()
+			h.expectCalls()
+			if rm2, cm2 := u.state(); rm2 != rm || cm2 != cm {
+				t.Errorf("state moved from %v %v to %v %v", rm, cm, rm2, cm2)
+			}
+			if ue, ok := h.amf.UE(testSUPI); ok {
+				ue.call(func() {
+					if ue.reg != reg || (reg != nil && reg.state != state) {
+						t.Errorf("registration moved on")
+					}
+				})
+			}
+		})
+	}
+}
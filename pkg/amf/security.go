@@ -0,0 +1,247 @@
+package amf
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/security"
+)
+
+// NAS ciphering and integrity algorithms by name (TS 33.501 5.11.1.1)
+var (
+	cipheringAlgorithms = map[string]uint8{"NEA0": 0, "NEA1": 1, "NEA2": 2, "NEA3": 3}
+	integrityAlgorithms = map[string]uint8{"NIA0": 0, "NIA1": 1, "NIA2": 2, "NIA3": 3}
+)
+
+// nullIntegrity is NIA0, allowed for unauthenticated emergency sessions
+// only (TS 33.501 5.5.2)
+const nullIntegrity = 0
+
+// Algorithms the AMF implements; preferred algorithms missing here are
+// skipped during selection
+var (
+	implementedCiphering = map[uint8]bool{0: true, 1: true, 2: true, 3: true}
+	implementedIntegrity = map[uint8]bool{0: true, 1: true, 2: true, 3: true}
+)
+
+// parseAlgorithms converts algorithm names to identifiers
+func parseAlgorithms(names []string, known map[string]uint8) ([]uint8, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no NAS security algorithm configured")
+	}
+
+	algs := make([]uint8, 0, len(names))
+	for _, name := range names {
+		alg, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown NAS security algorithm %q", name)
+		}
+		algs = append(algs, alg)
+	}
+	return algs, nil
+}
+
+// algorithmName returns the name of an algorithm identifier
+func algorithmName(known map[string]uint8, alg uint8) string {
+	for name, id := range known {
+		if id == alg {
+			return name
+		}
+	}
+	return ""
+}
+
+// selectAlgorithms picks the most preferred algorithms implemented by
+// the AMF and supported by the UE (TS 33.501 6.7.1). NIA0 is skipped
+// unless the registration is an emergency one.
+func selectAlgorithms(cfg *Config, capability nas.UESecurityCapability, emergency bool) (nas.SecurityAlgorithms, bool) {
+	var selected nas.SecurityAlgorithms
+	found := false
+	for _, alg := range cfg.IntegrityOrder {
+		if alg == nullIntegrity && !emergency {
+			continue
+		}
+		if implementedIntegrity[alg] && capability.Supports5GIA(alg) {
+			selected.Integrity, found = alg, true
+			break
+		}
+	}
+	if !found {
+		return selected, false
+	}
+
+	for _, alg := range cfg.CipheringOrder {
+		if implementedCiphering[alg] && capability.Supports5GEA(alg) {
+			selected.Ciphering = alg
+			return selected, true
+		}
+	}
+	return selected, false
+}
+
+// bearer3GPP is the NAS connection identifier of 3GPP access, used as
+// the BEARER input of the NAS algorithms (TS 33.501 6.4.3.1)
+const bearer3GPP = 0
+
+// maxNASCount is the largest NAS COUNT: a 16-bit overflow counter and an
+// 8-bit sequence number (TS 33.501 6.4.3.1)
+const maxNASCount = 0xffffff
+
+// SecurityContext is the 5G NAS security context of a UE (TS 33.501
+// 6.3)
+type SecurityContext struct {
+	NgKSI nas.NgKSI
+
+	// Kseaf is the anchor key returned by the AUSF
+	Kseaf []byte
+
+	// Kamf is derived from Kseaf for the serving AMF
+	Kamf []byte
+
+	Algorithms nas.SecurityAlgorithms
+
+	// NAS keys of the selected algorithms, derived from Kamf
+	KNASenc []byte
+	KNASint []byte
+
+	// NAS COUNTs: overflow counter and sequence number
+	ULCount uint32
+	DLCount uint32
+
+	// AS key chain: the KgNB of the last Initial Context Setup, then the
+	// next hop key and its chaining count given at each handover
+	kgnb []byte
+	NH   []byte
+	NCC  uint8
+}
+
+// newSecurityContext derives the keys of a new NAS security context
+// from the anchor key of an authentication (TS 33.501 6.2.2)
+func newSecurityContext(ngKSI nas.NgKSI, kseaf []byte, supi string, abba []byte, algorithms nas.SecurityAlgorithms) *SecurityContext {
+	kamf := security.KAMF(kseaf, supi, abba)
+	return &SecurityContext{
+		NgKSI:      ngKSI,
+		Kseaf:      kseaf,
+		Kamf:       kamf,
+		Algorithms: algorithms,
+		KNASenc:    security.AlgorithmKey(kamf, security.NASEncAlg, algorithms.Ciphering),
+		KNASint:    security.AlgorithmKey(kamf, security.NASIntAlg, algorithms.Integrity),
+	}
+}
+
+// KgNB derives the key of the gNB serving the UE from the uplink NAS
+// COUNT of the last message received (TS 33.501 6.9.2.1.1)
+func (sc *SecurityContext) KgNB() []byte {
+	count := sc.ULCount
+	if count > 0 {
+		count--
+	}
+	return security.KgNB(sc.Kamf, count, security.Access3GPP)
+}
+
+// initialKgNB derives the KgNB given to the gNB in an Initial Context
+// Setup, restarting the next hop chain from it (TS 33.501 6.9.2.1.1)
+func (sc *SecurityContext) initialKgNB() []byte {
+	sc.kgnb = sc.KgNB()
+	sc.NH, sc.NCC = nil, 0
+	return sc.kgnb
+}
+
+// nextHop advances the next hop chain for a handover: the first NH is
+// derived from the initial KgNB, the next ones from the previous NH
+// (TS 33.501 6.9.2.3)
+func (sc *SecurityContext) nextHop() ngap.SecurityContext {
+	sync := sc.NH
+	if sync == nil {
+		if sc.kgnb == nil {
+			sc.kgnb = sc.KgNB()
+		}
+		sync = sc.kgnb
+	}
+	sc.NH = security.NH(sc.Kamf, sync)
+	sc.NCC = (sc.NCC + 1) & 7
+	return ngap.SecurityContext{NextHopChainingCount: sc.NCC, NextHopNH: sc.NH}
+}
+
+// securityCapabilities converts the NAS security capability of a UE to
+// the NGAP masks, which leave out the null algorithms
+func securityCapabilities(c nas.UESecurityCapability) ngap.UESecurityCapabilities {
+	caps := ngap.UESecurityCapabilities{
+		NREncryptionAlgorithms:          uint16(c.EA&0x7f) << 9,
+		NRIntegrityProtectionAlgorithms: uint16(c.IA&0x7f) << 9,
+	}
+	if c.EPS {
+		caps.EUTRAEncryptionAlgorithms = uint16(c.EEA&0x7f) << 9
+		caps.EUTRAIntegrityProtectionAlgorithms = uint16(c.EIA&0x7f) << 9
+	}
+	return caps
+}
+
+// protect wraps a plain NAS message, ciphering it when the header type
+// asks for it, and advances the downlink COUNT (TS 24.501 4.4.3)
+func (sc *SecurityContext) protect(payload []byte, headerType nas.SecurityHeaderType) ([]byte, error) {
+	count := sc.DLCount
+	if headerType == nas.SecurityHeaderIntegrityProtectedAndCiphered ||
+		headerType == nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext {
+		var err error
+		if payload, err = cipher(sc.Algorithms.Ciphering, sc.KNASenc, count, security.DirectionDownlink, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	msg := &nas.SecurityProtectedMessage{
+		HeaderType:     headerType,
+		SequenceNumber: uint8(count),
+		Payload:        payload,
+	}
+	mac, err := integrity(sc.Algorithms.Integrity, sc.KNASint, count, security.DirectionDownlink,
+		append([]byte{msg.SequenceNumber}, payload...))
+	if err != nil {
+		return nil, err
+	}
+	msg.MAC = mac
+	sc.DLCount = (sc.DLCount + 1) & maxNASCount
+	return msg.Encode(), nil
+}
+
+// unprotect checks the MAC of an uplink message and returns its plain
+// payload. The uplink COUNT is estimated from the sequence number and
+// only advanced when the MAC matches.
+func (sc *SecurityContext) unprotect(msg *nas.SecurityProtectedMessage) ([]byte, error) {
+	count := sc.ULCount&^0xff | uint32(msg.SequenceNumber)
+	if msg.SequenceNumber < uint8(sc.ULCount) {
+		count += 0x100
+	}
+	count &= maxNASCount
+
+	mac, err := integrity(sc.Algorithms.Integrity, sc.KNASint, count, security.DirectionUplink,
+		append([]byte{msg.SequenceNumber}, msg.Payload...))
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mac[:], msg.MAC[:]) != 1 {
+		return nil, fmt.Errorf("NAS MAC mismatch at COUNT %d", count)
+	}
+
+	payload := msg.Payload
+	if msg.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCiphered ||
+		msg.HeaderType == nas.SecurityHeaderIntegrityProtectedAndCipheredWithNewContext {
+		if payload, err = cipher(sc.Algorithms.Ciphering, sc.KNASenc, count, security.DirectionUplink, payload); err != nil {
+			return nil, err
+		}
+	}
+	sc.ULCount = (count + 1) & maxNASCount
+	return payload, nil
+}
+
+// integrity computes the MAC of a NAS message
+func integrity(alg uint8, key []byte, count uint32, direction uint8, data []byte) ([4]byte, error) {
+	return security.NIA(alg, key, count, bearer3GPP, direction, data)
+}
+
+// cipher ciphers or deciphers a NAS message
+func cipher(alg uint8, key []byte, count uint32, direction uint8, data []byte) ([]byte, error) {
+	return security.NEA(alg, key, count, bearer3GPP, direction, data)
+}
@@ -0,0 +1,95 @@
+package amf
+
+import (
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/security"
+)
+
+func TestSelectAlgorithms(t *testing.T) {
+	cfg := &Config{IntegrityOrder: []uint8{2, 1, 0}, CipheringOrder: []uint8{0, 2, 1}}
+
+	tests := []struct {
+		name string
+
+		// ia and ea are the algorithms of the UE, NIA0 and NEA0 in the
+		// high bit
+		ia, ea    uint8
+		emergency bool
+
+		want nas.SecurityAlgorithms
+		ok   bool
+	}{
+		{
+			name: "preferred algorithms",
+			ia:   0xe0,
+			ea:   0xe0,
+			want: nas.SecurityAlgorithms{Integrity: 2, Ciphering: 0},
+			ok:   true,
+		},
+		{
+			name: "first supported by the UE",
+			ia:   0x40,
+			ea:   0x40,
+			want: nas.SecurityAlgorithms{Integrity: 1, Ciphering: 1},
+			ok:   true,
+		},
+		{
+			name: "null integrity only",
+			ia:   0x80,
+			ea:   0x80,
+		},
+		{
+			name:      "null integrity for an emergency registration",
+			ia:        0x80,
+			ea:        0x80,
+			emergency: true,
+			want:      nas.SecurityAlgorithms{Integrity: 0, Ciphering: 0},
+			ok:        true,
+		},
+		{
+			name: "no ciphering in common",
+			ia:   0x20,
+			ea:   0x10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := selectAlgorithms(cfg, nas.UESecurityCapability{EA: tt.ea, IA: tt.ia}, tt.emergency)
+			if ok != tt.ok || ok && got != tt.want {
+				t.Errorf("selectAlgorithms() = %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestUnprotectMAC(t *testing.T) {
+	algorithms := nas.SecurityAlgorithms{Integrity: 2, Ciphering: 2}
+	ue := newSecurityContext(nas.NgKSI{}, bytesOf(0x44, 32), testSUPI, abba, algorithms)
+	dl := newSecurityContext(nas.NgKSI{}, bytesOf(0x44, 32), testSUPI, abba, algorithms)
+
+	// The UE side protects with the uplink direction of the same keys
+	payload := []byte{0x7e, 0x00, 0x43}
+	mac, err := integrity(algorithms.Integrity, ue.KNASint, 0, security.DirectionUplink, append([]byte{0}, payload...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &nas.SecurityProtectedMessage{
+		HeaderType: nas.SecurityHeaderIntegrityProtected,
+		MAC:        mac,
+		Payload:    payload,
+	}
+
+	tampered := *msg
+	tampered.MAC[3] ^= 1
+	if _, err := dl.unprotect(&tampered); err == nil {
+		t.Error("unprotect() accepted a wrong MAC")
+	}
+	if got, err := dl.unprotect(msg); err != nil || string(got) != string(payload) {
+		t.Errorf("unprotect() = %x, %v, want %x", got, err, payload)
+	}
+	if dl.ULCount != 1 {
+		t.Errorf("uplink COUNT = %d after one message, want 1", dl.ULCount)
+	}
+}
@@ -0,0 +1,233 @@
+package amf
+
+import (
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// sessionSetup is a PDU session whose resources are set up in the gNB,
+// with the 5GSM message for the UE when there is one
+type sessionSetup struct {
+	id       uint8
+	transfer []byte
+	n1       []byte
+}
+
+// handleServiceRequest brings a registered UE back to CM-CONNECTED. The
+// user plane of the PDU sessions with uplink data is reactivated, and
+// the transfers waiting for the UE are delivered (TS 23.502 4.2.3.2).
+func (a *AMF) handleServiceRequest(ue *UE, m *nas.ServiceRequest, protected bool) {
+	rm, _ := ue.State()
+	switch {
+	case ue.SUPI == "" || !protected:
+		// The UE registers again to get a new context
+		a.rejectService(ue, nas.Cause5GMMUEIdentityCannotBeDerived)
+		return
+	case rm != RMRegistered:
+		a.rejectService(ue, nas.Cause5GMMImplicitlyDeregistered)
+		return
+	}
+
+	if m.NASMessageContainer != nil {
+		msg, err := nas.Decode(m.NASMessageContainer)
+		if req, ok := msg.(*nas.ServiceRequest); ok {
+			m = req
+		} else {
+			ue.log.Warn("Ignoring invalid NAS message container", zap.Error(err))
+		}
+	}
+
+	var status nas.PDUSessionStatus
+	for id := range ue.PDUSessions {
+		status.Set(id)
+	}
+	accept := &nas.ServiceAccept{PDUSessionStatus: &status}
+
+	// Transfers waiting for the UE set up their PDU sessions themselves
+	var setups []sessionSetup
+	var messages []*n1n2Transfer
+	for _, t := range ue.pending {
+		if !isSetup(t.req) {
+			messages = append(messages, t)
+			continue
+		}
+		setup := sessionSetup{id: transferSession(t.req), transfer: t.req.BinaryDataN2Information}
+		if t.req.N1MessageContainer != nil {
+			setup.n1 = t.req.BinaryDataN1Message
+		}
+		setups = append(setups, setup)
+	}
+	ue.pending = nil
+
+	if m.UplinkDataStatus != nil {
+		var failed nas.PDUSessionStatus
+		for _, id := range m.UplinkDataStatus.IDs() {
+			if hasSetup(setups, id) {
+				continue
+			}
+			transfer, err := a.updateSession(ue, id, models.SmContextUpdateData{UpCnxState: models.UpCnxStateActivating})
+			if err != nil {
+				ue.log.Warn("PDU session not reactivated", zap.Uint8("pdu_session_id", id), zap.Error(err))
+				failed.Set(id)
+				continue
+			}
+			setups = append(setups, sessionSetup{id: id, transfer: transfer})
+		}
+		accept.PDUSessionReactivationResult = &failed
+	}
+
+	ue.log.Info("Service request accepted", zap.Uint8("service_type", uint8(m.ServiceType)),
+		zap.Int("pdu_sessions", len(setups)))
+	a.sendNASWithContextSetup(ue, accept, setups)
+	for _, t := range messages {
+		a.deliverN1N2(ue, t.req)
+	}
+}
+
+// hasSetup reports whether the resources of a PDU session are set up
+func hasSetup(setups []sessionSetup, id uint8) bool {
+	for _, s := range setups {
+		if s.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// rejectService sends a Service Reject and releases the N2 connection
+func (a *AMF) rejectService(ue *UE, cause nas.Cause5GMM) {
+	ue.log.Warn("Service request rejected", zap.Stringer("cause", cause))
+	a.sendNAS(ue, &nas.ServiceReject{Cause: cause})
+	a.releaseN2(ue, ngap.CauseNASNormalRelease)
+}
+
+// setupSessions asks the gNB to set up the resources of PDU sessions
+func (a *AMF) setupSessions(ue *UE, setups []sessionSetup) {
+	err := ue.gnb.Send(&ngap.PDUSessionResourceSetupRequest{
+		AMFUENGAPID:             ue.amfUENGAPID,
+		RANUENGAPID:             ue.ranUENGAPID,
+		PDUSessionResourceSetup: a.setupItems(ue, setups),
+	})
+	if err != nil {
+		ue.log.Error("Failed to send PDU Session Resource Setup Request", zap.Error(err))
+	}
+}
+
+// setupItems returns the NGAP items of PDU session setups, with their
+// 5GSM messages protected for the UE
+func (a *AMF) setupItems(ue *UE, setups []sessionSetup) []ngap.PDUSessionResourceSetupItem {
+	items := make([]ngap.PDUSessionResourceSetupItem, 0, len(setups))
+	for _, s := range setups {
+		session := ue.PDUSessions[s.id]
+		if session == nil {
+			continue
+		}
+
+		item := ngap.PDUSessionResourceSetupItem{PDUSessionID: s.id, SNSSAI: session.SNSSAI, Transfer: s.transfer}
+		if s.n1 != nil {
+			id := s.id
+			item.NASPDU, _ = a.encodeNAS(ue, &nas.DLNASTransport{
+				PayloadContainerType: nas.PayloadContainerN1SMInformation,
+				PayloadContainer:     s.n1,
+				PDUSessionID:         &id,
+			}, nas.SecurityHeaderIntegrityProtectedAndCiphered)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// sessionsSetUp relays to the SMFs the outcome of a PDU session resource
+// setup in the gNB
+func (a *AMF) sessionsSetUp(ue *UE, setUp, failed []ngap.PDUSessionResourceItem) {
+	for _, item := range setUp {
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			N2SmInfoType:              models.N2SmInfoPduResSetupRsp,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Warn("Failed to activate PDU session", zap.Uint8("pdu_session_id", item.PDUSessionID), zap.Error(err))
+			continue
+		}
+		ue.PDUSessions[item.PDUSessionID].Active = true
+	}
+	for _, item := range failed {
+		ue.log.Warn("gNB failed to set up PDU session", zap.Uint8("pdu_session_id", item.PDUSessionID))
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			N2SmInfoType:              models.N2SmInfoPduResSetupFail,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Debug("Failed to report PDU session not set up", zap.Uint8("pdu_session_id", item.PDUSessionID),
+				zap.Error(err))
+		}
+	}
+}
+
+// deactivateSessions has the SMFs release the user plane connection of
+// the PDU sessions of a UE going to CM-IDLE (TS 23.502 4.2.6)
+func (a *AMF) deactivateSessions(ue *UE) {
+	for id, session := range ue.PDUSessions {
+		if !session.Active {
+			continue
+		}
+		session.Active = false
+		_, err := a.updateSession(ue, id, models.SmContextUpdateData{UpCnxState: models.UpCnxStateDeactivated})
+		if err != nil {
+			ue.log.Warn("Failed to deactivate PDU session", zap.Uint8("pdu_session_id", id), zap.Error(err))
+		}
+	}
+}
+
+// modifySession asks the gNB to change the QoS flows of a PDU session,
+// with the 5GSM message for the UE when there is one
+func (a *AMF) modifySession(ue *UE, id uint8, transfer, n1 []byte) {
+	if ue.PDUSessions[id] == nil {
+		return
+	}
+
+	item := ngap.PDUSessionResourceModifyItem{PDUSessionID: id, Transfer: transfer}
+	if n1 != nil {
+		item.NASPDU, _ = a.encodeNAS(ue, &nas.DLNASTransport{
+			PayloadContainerType: nas.PayloadContainerN1SMInformation,
+			PayloadContainer:     n1,
+			PDUSessionID:         &id,
+		}, nas.SecurityHeaderIntegrityProtectedAndCiphered)
+	}
+	err := ue.gnb.Send(&ngap.PDUSessionResourceModifyRequest{
+		AMFUENGAPID:              ue.amfUENGAPID,
+		RANUENGAPID:              ue.ranUENGAPID,
+		PDUSessionResourceModify: []ngap.PDUSessionResourceModifyItem{item},
+	})
+	if err != nil {
+		ue.log.Error("Failed to send PDU Session Resource Modify Request", zap.Error(err))
+	}
+}
+
+// sessionsModified relays to the SMFs the outcome of a PDU session
+// resource modification in the gNB
+func (a *AMF) sessionsModified(ue *UE, modified, failed []ngap.PDUSessionResourceItem) {
+	for _, item := range modified {
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			N2SmInfoType:              models.N2SmInfoPduResModRsp,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Warn("Failed to report PDU session modified", zap.Uint8("pdu_session_id", item.PDUSessionID),
+				zap.Error(err))
+		}
+	}
+	for _, item := range failed {
+		ue.log.Warn("gNB failed to modify PDU session", zap.Uint8("pdu_session_id", item.PDUSessionID))
+		_, err := a.updateSession(ue, item.PDUSessionID, models.SmContextUpdateData{
+			N2SmInfoType:              models.N2SmInfoPduResModFail,
+			BinaryDataN2SmInformation: item.Transfer,
+		})
+		if err != nil {
+			ue.log.Debug("Failed to report PDU session not modified", zap.Uint8("pdu_session_id", item.PDUSessionID),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package amf
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/sbi"
+)
+
+// SBI services of the AMF (TS 29.518)
+const (
+	ServiceComm = "namf-comm"
+	ServiceEvts = "namf-evts"
+)
+
+// RegisterServices serves Namf_Communication, Namf_EventExposure and the
+// callbacks of the AMF on an SBI server
+func (a *AMF) RegisterServices(s *sbi.Server) {
+	s.HandleFunc(commPrefix, a.handleUEContexts)
+	s.HandleFunc(eventsPrefix, a.handleEventSubscriptions)
+	s.HandleFunc(eventsPrefix+"/", a.handleEventSubscriptions)
+	s.HandleFunc(callbackPrefix, a.handleCallbacks)
+}
+
+// Profile returns the NF profile of the AMF, listing the services it
+// serves at its callback URI
+func (a *AMF) Profile() models.NfProfile {
+	profile := models.NfProfile{
+		NfInstanceID:   a.config.InstanceID,
+		NfType:         models.NfTypeAMF,
+		NfInstanceName: a.config.Name,
+		NfStatus:       models.NfStatusRegistered,
+		Capacity:       int(a.config.RelativeCapacity),
+	}
+
+	root, err := url.Parse(a.apiRoot())
+	if err != nil {
+		return profile
+	}
+	host := root.Hostname()
+	port, _ := strconv.Atoi(root.Port())
+	var addresses []string
+	if ip := net.ParseIP(host); ip != nil {
+		addresses = []string{host}
+		if ip.To4() != nil {
+			profile.IPv4Addresses = addresses
+		} else {
+			profile.IPv6Addresses = addresses
+		}
+	} else {
+		profile.FQDN = host
+	}
+
+	for _, name := range []string{ServiceComm, ServiceEvts} {
+		service := models.NfService{
+			ServiceInstanceID: name,
+			ServiceName:       name,
+			Versions: []models.NfServiceVersion{{
+				APIVersion:     "v1",
+				APIFullVersion: "1.0.0",
+				URIPrefix:      a.apiRoot() + "/" + name + "/v1",
+			}},
+			Scheme:      root.Scheme,
+			IPAddresses: addresses,
+			Port:        port,
+			URIPrefix:   a.apiRoot(),
+		}
+		if addresses == nil {
+			service.FQDN = host
+		}
+		profile.NfServices = append(profile.NfServices, service)
+	}
+	return profile
+}
+
+// apiRoot returns the API root other NFs reach the AMF at
+func (a *AMF) apiRoot() string {
+	return strings.TrimSuffix(a.config.CallbackURI, "/")
+}
+
+// allow reports whether a request uses the method of its resource,
+// answering it with 405 otherwise
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	methodNotAllowed(w, r)
+	return false
+}
+
+// methodNotAllowed answers a request using a method its resource does
+// not support
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	sbi.WriteError(w, apperrors.AppError{
+		Type:    apperrors.ErrorTypeBadRequest,
+		Message: fmt.Sprintf("Method %s not allowed", r.Method),
+		Code:    http.StatusMethodNotAllowed,
+	})
+}
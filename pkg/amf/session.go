@@ -0,0 +1,144 @@
+package amf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"go.uber.org/zap"
+)
+
+// Callback resources of the AMF, under callbackPrefix/{supi}
+const (
+	callbackPrefix  = "/namf-callback/v1/"
+	smContextStatus = "sm-context-status"
+)
+
+// handleN1SM relays a 5GSM message of the UE to the SMF. Requests for new
+// PDU sessions create an SM context (TS 23.502 4.3.2.2.1); the 5GSM
+// messages of established sessions are not relayed yet.
+func (a *AMF) handleN1SM(ue *UE, m *nas.ULNASTransport) {
+	if m.PDUSessionID == nil {
+		ue.log.Warn("Dropping 5GSM message without PDU session ID")
+		return
+	}
+	id := *m.PDUSessionID
+	if m.RequestType == nil || *m.RequestType != nas.RequestTypeInitialRequest {
+		ue.log.Debug("Dropping 5GSM message of an established PDU session", zap.Uint8("pdu_session_id", id))
+		return
+	}
+	if a.nfs.SMF == nil {
+		ue.log.Warn("No SMF to establish PDU session", zap.Uint8("pdu_session_id", id))
+		a.returnSM(ue, id, m.PayloadContainer)
+		return
+	}
+	snssai, ok := sessionSlice(ue, m.SNSSAI)
+	if !ok {
+		ue.log.Warn("PDU session requested in a slice not allowed", zap.Uint8("pdu_session_id", id))
+		a.returnSM(ue, id, m.PayloadContainer)
+		return
+	}
+
+	guami, _ := a.config.GUAMI(ue.TAI.PlmnID)
+	g := guami.Model()
+	ref, err := a.nfs.SMF.CreateSMContext(context.Background(), models.SmContextCreateData{
+		Supi:                  ue.SUPI,
+		Pei:                   ue.PEI,
+		PduSessionID:          id,
+		Dnn:                   m.DNN,
+		SNssai:                snssai,
+		RequestType:           models.RequestTypeInitialRequest,
+		ServingNfID:           a.config.InstanceID,
+		Guami:                 &g,
+		ServingNetwork:        ue.TAI.PlmnID,
+		AnType:                models.AccessType3GPP,
+		RatType:               models.RatTypeNR,
+		UeLocation:            &models.UserLocation{NrLocation: &models.NrLocation{Tai: ue.TAI, Ncgi: ue.NCGI}},
+		SmContextStatusURI:    a.callbackURI(ue, smContextStatus+"/"+strconv.Itoa(int(id))),
+		BinaryDataN1SmMessage: m.PayloadContainer,
+	})
+	var rejection *SMContextRejection
+	switch {
+	case errors.As(err, &rejection) && len(rejection.N1SmMsg) > 0:
+		ue.log.Info("SMF rejected PDU session", zap.Uint8("pdu_session_id", id), zap.String("cause", rejection.Cause))
+		a.sendSM(ue, id, rejection.N1SmMsg)
+		return
+	case err != nil:
+		ue.log.Warn("Failed to create SM context", zap.Uint8("pdu_session_id", id), zap.Error(err))
+		a.returnSM(ue, id, m.PayloadContainer)
+		return
+	}
+
+	if ue.PDUSessions == nil {
+		ue.PDUSessions = make(map[uint8]*PDUSession)
+	}
+	ue.PDUSessions[id] = &PDUSession{ID: id, SNSSAI: snssai, DNN: m.DNN, SMContextRef: ref}
+	ue.log.Info("SM context created", zap.Uint8("pdu_session_id", id), zap.String("sm_context", ref))
+}
+
+// sessionSlice returns the slice of a new PDU session: the requested one
+// when it is allowed, or the first allowed one
+func sessionSlice(ue *UE, requested *models.Snssai) (models.Snssai, bool) {
+	for _, s := range ue.AllowedNSSAI {
+		if requested == nil || s == *requested {
+			return s, true
+		}
+	}
+	return models.Snssai{}, false
+}
+
+// returnSM sends a 5GSM message back to the UE when it could not be
+// relayed to an SMF (TS 24.501 5.4.5.2.5)
+func (a *AMF) returnSM(ue *UE, id uint8, msg []byte) {
+	cause := nas.Cause5GMMPayloadWasNotForwarded
+	a.sendNAS(ue, &nas.DLNASTransport{
+		PayloadContainerType: nas.PayloadContainerN1SMInformation,
+		PayloadContainer:     msg,
+		PDUSessionID:         &id,
+		Cause:                &cause,
+	})
+}
+
+// handleCallbacks serves the notifications other NFs send about a UE.
+// Only SM context status notifications are handled.
+func (a *AMF) handleCallbacks(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, callbackPrefix), "/")
+	if len(parts) != 3 || parts[1] != smContextStatus {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 8)
+	if err != nil {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+		return
+	}
+	ue, ok := a.UE(parts[0])
+	if !ok {
+		sbi.WriteError(w, apperrors.NewNotFoundError("UE context not found", nil))
+		return
+	}
+
+	var n models.SmContextStatusNotification
+	if _, err := sbi.ReadMultipart(r, &n); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	ue.call(func() {
+		if n.StatusInfo.ResourceStatus != models.ResourceStatusReleased || ue.PDUSessions[uint8(id)] == nil {
+			return
+		}
+		delete(ue.PDUSessions, uint8(id))
+		ue.log.Info("SMF released PDU session", zap.Uint64("pdu_session_id", id),
+			zap.String("cause", n.StatusInfo.Cause))
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
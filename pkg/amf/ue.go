@@ -0,0 +1,257 @@
+package amf
+
+import (
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"go.uber.org/zap"
+)
+
+// RMState is the registration management state of a UE (TS 23.501
+// 5.3.2)
+type RMState int
+
+const (
+	RMDeregistered RMState = iota
+	RMRegistered
+)
+
+// String returns the 3GPP name of the state
+func (s RMState) String() string {
+	if s == RMRegistered {
+		return "RM-REGISTERED"
+	}
+	return "RM-DEREGISTERED"
+}
+
+// CMState is the connection management state of a UE (TS 23.501 5.3.3)
+type CMState int
+
+const (
+	CMIdle CMState = iota
+	CMConnected
+)
+
+// String returns the 3GPP name of the state
+func (s CMState) String() string {
+	if s == CMConnected {
+		return "CM-CONNECTED"
+	}
+	return "CM-IDLE"
+}
+
+// UE is the AMF context of a UE. Its fields are only used from the
+// procedure loop of the UE, see run.
+type UE struct {
+	// Identities
+	SUPI string
+	SUCI string
+	PEI  string
+	GUTI *nas.GUTI
+
+	// oldGUTI is the previous 5G-GUTI, still valid until the UE confirms
+	// the new one
+	oldGUTI *nas.GUTI
+
+	// TAI and NCGI are the last location reported by the gNB
+	TAI  models.Tai
+	NCGI models.Ncgi
+
+	// RegistrationArea is the list of tracking areas given in the last
+	// Registration Accept
+	RegistrationArea []models.Tai
+
+	SecurityCapability nas.UESecurityCapability
+	Security           *SecurityContext
+
+	// Set during registration
+	AMData               *models.AccessAndMobilitySubscriptionData
+	AllowedNSSAI         []models.Snssai
+	SDMSubscriptionURI   string
+	PolicyAssociationURI string
+	T3512                time.Duration
+
+	// PDUSessions holds the established PDU sessions by identifier
+	PDUSessions map[uint8]*PDUSession
+
+	// N2 association, set in CM-CONNECTED
+	gnb         *GNB
+	amfUENGAPID int64
+	ranUENGAPID int64
+
+	// asContext tells that the gNB holds the AS context of the UE, set up
+	// by an Initial Context Setup or a handover
+	asContext bool
+
+	// reg is the registration in progress
+	reg *registration
+
+	// handover is the N2 handover in progress
+	handover *handover
+
+	// paging is the paging in progress of the UE in CM-IDLE
+	paging *paging
+
+	// pending holds the N1N2 message transfers waiting for the UE to be
+	// reachable, and nextTransferID identifies the next one
+	pending        []*n1n2Transfer
+	nextTransferID uint64
+
+	// n1Subscriptions holds the subscriptions of other NFs to the N1
+	// messages of the UE by identifier
+	n1Subscriptions    map[string]models.UeN1N2InfoSubscriptionCreateData
+	nextSubscriptionID uint64
+
+	// reachable is the reachability last reported to event subscribers
+	reachable bool
+
+	// reach follows the reachability of the UE in CM-IDLE
+	reach *reachability
+
+	// nextKSI is the key set identifier of the next authentication
+	nextKSI uint8
+
+	log     *zap.Logger
+	metrics *metrics.AMFMetrics
+
+	mu      sync.RWMutex
+	rmState RMState
+	cmState CMState
+
+	queueMu sync.Mutex
+	queue   []func()
+	running bool
+}
+
+// PDUSession is a PDU session of a UE as known to the AMF
+type PDUSession struct {
+	ID     uint8
+	SNSSAI models.Snssai
+	DNN    string
+
+	// SMContextRef is the URI of the SM context in the SMF
+	SMContextRef string
+
+	// Active tells that the user plane connection is established
+	Active bool
+}
+
+// State returns the registration and connection management states
+func (ue *UE) State() (RMState, CMState) {
+	ue.mu.RLock()
+	defer ue.mu.RUnlock()
+	return ue.rmState, ue.cmState
+}
+
+// setRMState moves the UE to a registration management state
+func (ue *UE) setRMState(s RMState) {
+	ue.mu.Lock()
+	old := ue.rmState
+	ue.rmState = s
+	ue.mu.Unlock()
+
+	if old == s {
+		return
+	}
+	ue.log.Info("UE registration state changed", zap.Stringer("from", old), zap.Stringer("to", s))
+	if ue.metrics != nil {
+		if s == RMRegistered {
+			ue.metrics.RegisteredUEs.Inc()
+		} else {
+			ue.metrics.RegisteredUEs.Dec()
+		}
+	}
+}
+
+// setCMState moves the UE to a connection management state
+func (ue *UE) setCMState(s CMState) {
+	ue.mu.Lock()
+	old := ue.cmState
+	ue.cmState = s
+	ue.mu.Unlock()
+
+	if old == s {
+		return
+	}
+	ue.log.Debug("UE connection state changed", zap.Stringer("from", old), zap.Stringer("to", s))
+	if ue.metrics != nil {
+		if s == CMConnected {
+			ue.metrics.ConnectedUEs.Inc()
+		} else {
+			ue.metrics.ConnectedUEs.Dec()
+		}
+	}
+}
+
+// run queues f on the procedure loop of the UE. Procedures of a UE run
+// one at a time in arrival order, so they may block on other NFs without
+// holding up the gNB.
+func (ue *UE) run(f func()) {
+	ue.queueMu.Lock()
+	defer ue.queueMu.Unlock()
+
+	ue.queue = append(ue.queue, f)
+	if !ue.running {
+		ue.running = true
+		go ue.drain()
+	}
+}
+
+// call runs f on the procedure loop of the UE and waits for it to
+// return
+func (ue *UE) call(f func()) {
+	done := make(chan struct{})
+	ue.run(func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+// drain runs queued procedures until the queue is empty
+func (ue *UE) drain() {
+	for {
+		ue.queueMu.Lock()
+		if len(ue.queue) == 0 {
+			ue.running = false
+			ue.queueMu.Unlock()
+			return
+		}
+		f := ue.queue[0]
+		ue.queue = ue.queue[1:]
+		ue.queueMu.Unlock()
+
+		f()
+	}
+}
+
+// boundTo reports whether the UE is connected over the given N2
+// connection
+func (ue *UE) boundTo(gnb *GNB, amfID, ranID int64) bool {
+	return ue.gnb == gnb && ue.amfUENGAPID == amfID && ue.ranUENGAPID == ranID
+}
+
+// allocateKSI returns the key set identifier of a new authentication,
+// avoiding the one of the current context
+func (ue *UE) allocateKSI() nas.NgKSI {
+	ksi := ue.nextKSI
+	if ue.Security != nil && ue.Security.NgKSI.KSI == ksi {
+		ksi = (ksi + 1) % nas.NoKeyAvailable
+	}
+	ue.nextKSI = (ksi + 1) % nas.NoKeyAvailable
+	return nas.NgKSI{KSI: ksi}
+}
+
+// inRegistrationArea reports whether a tracking area belongs to the
+// registration area of the UE
+func (ue *UE) inRegistrationArea(tai models.Tai) bool {
+	for _, t := range ue.RegistrationArea {
+		if t == tai {
+			return true
+		}
+	}
+	return false
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"strings"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/models"
 	"go.uber.org/zap"
 )
 
@@ -56,7 +58,17 @@ type Config struct {
 
 	// Logging configuration
 	Logging struct {
-		Level string
+		Level   string
+		Loggers map[string]string // per sub-logger levels, e.g. "ngap": "debug"
+		Privacy struct {
+			Mode string // "clear", "mask" or "hash"
+			Salt string // key for the "hash" mode, random per process when empty
+		}
+		Encoding string   // "json" or "console"
+		Outputs  []string // "stdout", "stderr" or file paths
+		Rotation logger.RotationOptions
+		Sampling map[string]logger.SamplingOptions // keyed by level
+		Audit    logger.AuditOptions
 	}
 
 	// Metrics configuration
@@ -64,23 +76,178 @@ type Config struct {
 		Enabled bool
 		Port    int
 	}
+
+	// Admin endpoints configuration. They are not authenticated, so they
+	// are served apart from the metrics, on localhost by default.
+	Admin struct {
+		Host string
+		Port int
+	}
+
+	// AMF configuration
+	AMF struct {
+		Name           string // AMF name sent in NG Setup, defaults to the instance ID
+		RegionID       int
+		SetID          int
+		PointerToSetID int
+		SupportedTACs  []uint32
+		// Number of tracking areas given to a UE as registration area, 1 to 16
+		RegistrationAreaSize int
+		PlmnSupportList      []struct {
+			Mcc        string
+			Mnc        string
+			SnssaiList []models.Snssai
+		}
+		NGAP struct {
+			Host      string
+			Port      int
+			Transport string // "sctp" or "tcp"
+		}
+		// API root of the AMF given to other NFs for notifications
+		CallbackURI string
+		// API roots of the NFs used during registration
+		Peers struct {
+			AUSF string
+			UDM  string
+			PCF  string
+			NSSF string
+			SMF  string
+		}
+		Security struct {
+			IntegrityOrder []string // preferred first, e.g. ["NIA2", "NIA1"]
+			CipheringOrder []string // preferred first, e.g. ["NEA0", "NEA2", "NEA1"]
+		}
+		T3512 int // periodic registration timer in seconds
+		T3513 int // paging retry timer in seconds
+		// Pagings repeated after the first one before giving up on a UE
+		PagingRetries int
+		// Seconds the mobile reachable timer runs past T3512
+		MobileReachableMargin  int
+		ImplicitDeregistration int // implicit deregistration timer in seconds
+		// File keeping allocated 5G-GUTIs across restarts, empty to disable
+		GUTIStore      string
+		TMSIReuseDelay int // seconds a released 5G-TMSI is held back
+	}
+
+	// SMF configuration
+	SMF struct {
+		UpfSelectionMode string   // "proximity", "load" or "performance"
+		DnnList          []string // DNNs served, the first one by default
+		// Default pools of every DNN and slice, UEs getting /64 prefixes of
+		// the IPv6 one
+		IPv4AddressPool struct {
+			Start string
+			End   string
+		}
+		IPv6AddressPool struct {
+			Prefix       string
+			PrefixLength int
+		}
+		// Pools of specific DNNs and slices, preferred to the default ones
+		Pools []struct {
+			Name   string
+			Dnn    string         // every DNN when empty
+			Snssai *models.Snssai // every slice when unset
+			IPv4   struct {
+				Start string
+				End   string
+			}
+			IPv6 struct {
+				Prefix       string
+				PrefixLength int
+			}
+		}
+		// Seconds a released address or prefix is held back before reuse
+		AddressQuarantine int
+		// API root of the SMF given to other NFs for notifications
+		CallbackURI string
+		// API roots of the NFs used during PDU session establishment
+		Peers struct {
+			AMF string
+			UDM string
+			PCF string
+		}
+		// UPFs the SMF sets up PDU sessions in
+		UPFs []struct {
+			NodeID   string
+			N4       string          // PFCP endpoint, host:port
+			N3       string          // GTP-U address given to gNBs
+			N9       string          // GTP-U address given to other UPFs, if it can be an I-UPF
+			DnnList  []string        // DNNs served, all when empty
+			Snssais  []models.Snssai // slices served, all when empty
+			Locality string
+		}
+		// PFCP endpoint of the SMF on N4
+		PFCP struct {
+			Address string // local host:port
+			NodeID  string // IP address given to the UPFs
+			T1      int    // seconds a request waits for its response
+			N1      int    // times a request is sent again before giving up
+		}
+		// Whether UPFs registered in the NRF are used besides the above
+		DiscoverUPFs bool
+		// Seconds between PFCP heartbeats measuring the UPF round trip times
+		UpfHeartbeatInterval int
+		// Localities of the gNBs by TAC, UEs of other TACs being local to
+		// the SMF. PDU sessions anchored in a UPF of another locality go
+		// through an I-UPF of the UE locality when there is one.
+		Localities []struct {
+			Name string
+			Tacs []string
+		}
+		// Seconds a PDU session of SSC mode 3 is kept once the UE was asked
+		// to move to a new one anchored closer to it
+		Ssc3AddressLifetime int
+		// Usage reporting of the UPFs and charging data records
+		Charging struct {
+			Sink            string // "none", "file" or "local", a stand-in for the CHF
+			File            string // file the "file" sink appends JSON records to
+			RatingGroup     int    // rating group of traffic without charging decision
+			VolumeThreshold int    // octets after which UPFs report usage, 0 for none
+			TimeThreshold   int    // seconds after which UPFs report usage, 0 for none
+		}
+	}
+
+	// UPF configuration
+	UPF struct {
+		// IP address or FQDN given to the SMFs, the instance ID when empty
+		NodeID string
+		// Data networks reached over N6, each through a TUN device
+		DataNetworks []struct {
+			Name   string
+			Device string // TUN device, named after the DNN when empty
+			Pools  []struct {
+				CIDR string // UE addresses routed to the device
+			}
+		}
+		// Local endpoints by interface: "n3" for GTP-U, also used on N9,
+		// and "n4" for PFCP
+		Interfaces []struct {
+			Name      string
+			Endpoints []string
+		}
+		// Downlink packets buffered for each PDU session while its UE is
+		// idle, unless the SMF suggests another number
+		BufferedPackets int
+		// Bit rates in bps of the QoS flows by QFI, enforced when the
+		// SMF gives none
+		QosProfiles []struct {
+			ID                int
+			GuaranteedBitrate uint64
+			MaximumBitrate    uint64
+		}
+	}
+
+	// Health probe configuration
+	Health struct {
+		Timeout    int // seconds allowed for each check
+		DrainDelay int // seconds readiness reports down before deregistration
+	}
 }
 
 // LoadConfig loads the configuration from environment variables and config files
 func LoadConfig(configPath string) (*Config, error) {
-	config := &Config{}
-
-	// Set up viper to read from config files and environment variables
-	v := viper.New()
-	v.SetConfigName("config")
-	v.SetConfigType("yaml")
-	v.AddConfigPath(configPath)
-	v.AddConfigPath(".")
-	v.AutomaticEnv()
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-
-	// Set default values
-	setDefaults(v)
+	v := newViper(configPath)
 
 	// Read the config file
 	err := v.ReadInConfig()
@@ -93,15 +260,98 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 
 	// Unmarshal the configuration
-	err = v.Unmarshal(config)
+	config, err := unmarshal(v)
 	if err != nil {
-		logger.Error("Failed to unmarshal config", zap.Error(err))
 		return nil, err
 	}
 
-	// Initialize logger with configured log level
-	logger.Initialize(config.Logging.Level)
+	// Initialize logger with configured outputs and log levels
+	err = logger.InitializeWithOptions(config.LoggerOptions())
+	if err != nil {
+		logger.Error("Failed to initialize logger", zap.Error(err))
+		return nil, err
+	}
+	if err := logger.ApplyLevels(config.Logging.Level, config.Logging.Loggers, "config"); err != nil {
+		logger.Warn("Invalid logging configuration", zap.Error(err))
+	}
+	if err := logger.SetPrivacy(config.Logging.Privacy.Mode, config.Logging.Privacy.Salt); err != nil {
+		logger.Error("Invalid logging privacy mode", zap.Error(err))
+		return nil, err
+	}
+	if logger.Privacy() == logger.PrivacyHash && config.Logging.Privacy.Salt == "" {
+		logger.Warn("No logging privacy salt configured, hashing with a random one")
+	}
+
+	return config, nil
+}
+
+// LoggerOptions returns the logger options described by the logging
+// configuration
+func (c *Config) LoggerOptions() logger.Options {
+	return logger.Options{
+		Level:    c.Logging.Level,
+		Encoding: c.Logging.Encoding,
+		Outputs:  c.Logging.Outputs,
+		Rotation: c.Logging.Rotation,
+		Sampling: c.Logging.Sampling,
+		Audit:    c.Logging.Audit,
+	}
+}
+
+// WatchConfig watches the config file and applies changes at runtime.
+// Log levels are updated directly; onChange, if not nil, receives the
+// reloaded configuration so callers can apply the rest.
+func WatchConfig(configPath string, onChange func(*Config)) error {
+	v := newViper(configPath)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+
+	v.OnConfigChange(func(e fsnotify.Event) {
+		config, err := unmarshal(v)
+		if err != nil {
+			return
+		}
+		logger.Info("Config file changed", zap.String("file", e.Name))
 
+		if err := logger.ApplyLevels(config.Logging.Level, config.Logging.Loggers, "config reload"); err != nil {
+			logger.Warn("Invalid logging configuration", zap.Error(err))
+		}
+		if err := logger.SetPrivacy(config.Logging.Privacy.Mode, config.Logging.Privacy.Salt); err != nil {
+			logger.Warn("Invalid logging privacy mode", zap.Error(err))
+		}
+		if onChange != nil {
+			onChange(config)
+		}
+	})
+	v.WatchConfig()
+
+	return nil
+}
+
+// newViper sets up viper to read from config files and environment variables
+func newViper(configPath string) *viper.Viper {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(configPath)
+	v.AddConfigPath(".")
+	v.AutomaticEnv()
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Set default values
+	setDefaults(v)
+
+	return v
+}
+
+// unmarshal decodes the configuration held by v
+func unmarshal(v *viper.Viper) (*Config, error) {
+	config := &Config{}
+	if err := v.Unmarshal(config); err != nil {
+		logger.Error("Failed to unmarshal config", zap.Error(err))
+		return nil, err
+	}
 	return config, nil
 }
 
@@ -132,8 +382,62 @@ func setDefaults(v *viper.Viper) {
 
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.privacy.mode", "hash")
+	v.SetDefault("logging.encoding", "json")
+	v.SetDefault("logging.outputs", []string{"stdout"})
+	v.SetDefault("logging.rotation.maxSize", 100)
+	v.SetDefault("logging.rotation.maxBackups", 10)
 
 	// Metrics defaults
 	v.SetDefault("metrics.enabled", true)
 	v.SetDefault("metrics.port", 9090)
+
+	// Admin defaults
+	v.SetDefault("admin.host", "127.0.0.1")
+	v.SetDefault("admin.port", 9099)
+
+	// AMF defaults
+	v.SetDefault("amf.ngap.host", "0.0.0.0")
+	v.SetDefault("amf.ngap.port", 38412)
+	v.SetDefault("amf.ngap.transport", "sctp")
+	v.SetDefault("amf.callbackURI", "http://amf:8080")
+	v.SetDefault("amf.peers.ausf", "http://ausf:8080")
+	v.SetDefault("amf.peers.udm", "http://udm:8080")
+	v.SetDefault("amf.peers.pcf", "http://pcf:8080")
+	v.SetDefault("amf.peers.nssf", "http://nssf:8080")
+	v.SetDefault("amf.peers.smf", "http://smf:8080")
+	v.SetDefault("amf.security.integrityOrder", []string{"NIA2", "NIA1"})
+	v.SetDefault("amf.security.cipheringOrder", []string{"NEA0", "NEA2", "NEA1"})
+	v.SetDefault("amf.t3512", 3240)
+	v.SetDefault("amf.t3513", 6)
+	v.SetDefault("amf.pagingRetries", 2)
+	v.SetDefault("amf.mobileReachableMargin", 240)
+	v.SetDefault("amf.implicitDeregistration", 240)
+	v.SetDefault("amf.tmsiReuseDelay", 7200)
+	v.SetDefault("amf.registrationAreaSize", 16)
+
+	// SMF defaults
+	v.SetDefault("smf.callbackURI", "http://smf:8080")
+	v.SetDefault("smf.peers.amf", "http://amf:8080")
+	v.SetDefault("smf.peers.udm", "http://udm:8080")
+	v.SetDefault("smf.peers.pcf", "http://pcf:8080")
+	v.SetDefault("smf.addressQuarantine", 300)
+	v.SetDefault("smf.upfSelectionMode", "proximity")
+	v.SetDefault("smf.upfHeartbeatInterval", 10)
+	v.SetDefault("smf.ssc3AddressLifetime", 60)
+	v.SetDefault("smf.pfcp.address", "0.0.0.0:8805")
+	v.SetDefault("smf.pfcp.t1", 3)
+	v.SetDefault("smf.pfcp.n1", 3)
+	v.SetDefault("smf.charging.sink", "none")
+	v.SetDefault("smf.charging.file", "cdr.jsonl")
+	v.SetDefault("smf.charging.ratingGroup", 1)
+	v.SetDefault("smf.charging.volumeThreshold", 104857600)
+	v.SetDefault("smf.charging.timeThreshold", 3600)
+
+	// UPF defaults
+	v.SetDefault("upf.bufferedPackets", 64)
+
+	// Health defaults
+	v.SetDefault("health.timeout", 2)
+	v.SetDefault("health.drainDelay", 5)
 }
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+)
+
+// Flag is a check whose state is set by the component it watches, e.g.
+// the NRF registration or a PFCP association
+type Flag struct {
+	mu     sync.RWMutex
+	up     bool
+	reason string
+}
+
+// NewFlag creates a flag that starts down with the given reason
+func NewFlag(reason string) *Flag {
+	return &Flag{reason: reason}
+}
+
+// SetUp marks the flag healthy
+func (f *Flag) SetUp() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = true
+	f.reason = ""
+}
+
+// SetDown marks the flag unhealthy with the given reason
+func (f *Flag) SetDown(reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = false
+	f.reason = reason
+}
+
+// IsUp reports whether the flag is healthy
+func (f *Flag) IsUp() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.up
+}
+
+// Check implements CheckFunc
+func (f *Flag) Check(context.Context) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.up {
+		return nil
+	}
+	if f.reason == "" {
+		return errors.New("down")
+	}
+	return errors.New(f.reason)
+}
+
+// TCPCheck returns a check that succeeds when a TCP connection to
+// address can be opened, e.g. to verify database connectivity
+func TCPCheck(address string) CheckFunc {
+	return func(ctx context.Context) error {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPCheck returns a check that succeeds when a GET on url answers with
+// a 2xx status, e.g. to verify the metrics server is serving
+func HTTPCheck(url string) CheckFunc {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// Names of the checks shared by the network functions
+const (
+	CheckNRFRegistration = "nrf-registration"
+	CheckDatabase        = "database"
+	CheckPFCPAssociation = "pfcp-association"
+	CheckMetricsServer   = "metrics-server"
+)
+
+// DatabaseCheck returns a check of the connectivity to the configured
+// database
+func DatabaseCheck(cfg *config.Config) CheckFunc {
+	return TCPCheck(net.JoinHostPort(cfg.Database.Host, fmt.Sprintf("%d", cfg.Database.Port)))
+}
+
+// MetricsServerCheck returns a check of the local metrics server
+func MetricsServerCheck(cfg *config.Config) CheckFunc {
+	return HTTPCheck(fmt.Sprintf("http://127.0.0.1:%d/metrics", cfg.Metrics.Port))
+}
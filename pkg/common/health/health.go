@@ -0,0 +1,305 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"go.uber.org/zap"
+)
+
+// Probe selects which probes a check takes part in
+type Probe int
+
+const (
+	// Liveness checks failing mean the process should be restarted
+	Liveness Probe = 1 << iota
+
+	// Readiness checks failing mean the process should not receive traffic
+	Readiness
+
+	// Informational checks are only reported by the detail probe, e.g.
+	// state worth watching that should neither restart the process nor
+	// take it out of rotation
+	Informational
+
+	// Both makes a check part of liveness and readiness
+	Both = Liveness | Readiness
+)
+
+// Status is the outcome of a check or probe
+type Status string
+
+const (
+	// StatusUp means the check passed
+	StatusUp Status = "UP"
+
+	// StatusDown means the check failed
+	StatusDown Status = "DOWN"
+)
+
+// Paths served by Handler
+const (
+	Path          = "/health"
+	LivenessPath  = "/health/live"
+	ReadinessPath = "/health/ready"
+	DetailPath    = "/health/detail"
+)
+
+// CheckFunc reports the health of a component, returning nil when healthy
+type CheckFunc func(ctx context.Context) error
+
+// check is a registered check
+type check struct {
+	probe Probe
+	fn    CheckFunc
+}
+
+// CheckResult is the outcome of a single check
+type CheckResult struct {
+	Status    Status  `json:"status"`
+	Error     string  `json:"error,omitempty"`
+	Duration  float64 `json:"durationSeconds"`
+	Liveness  bool    `json:"liveness"`
+	Readiness bool    `json:"readiness"`
+}
+
+// Report is the outcome of a probe
+type Report struct {
+	Status       Status                 `json:"status"`
+	ShuttingDown bool                   `json:"shuttingDown,omitempty"`
+	Checks       map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Registry holds the health checks of a network function
+type Registry struct {
+	mu           sync.RWMutex
+	checks       map[string]check
+	timeout      time.Duration
+	shuttingDown atomic.Bool
+}
+
+// NewRegistry creates a registry whose checks are each given timeout to
+// complete
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{
+		checks:  make(map[string]check),
+		timeout: timeout,
+	}
+}
+
+// NewRegistryFromConfig creates a registry with the configured timeout
+// and the checks every network function has. Components add their own,
+// such as CheckNRFRegistration or CheckDatabase, as they start.
+func NewRegistryFromConfig(cfg *config.Config) *Registry {
+	r := NewRegistry(time.Duration(cfg.Health.Timeout) * time.Second)
+	if cfg.Metrics.Enabled {
+		r.Register(CheckMetricsServer, Readiness, MetricsServerCheck(cfg))
+	}
+	return r
+}
+
+// Register adds or replaces a check
+func (r *Registry) Register(name string, probe Probe, fn CheckFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check{probe: probe, fn: fn}
+}
+
+// Unregister removes a check
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checks, name)
+}
+
+// SetShuttingDown marks the process as shutting down, which makes the
+// readiness probe fail regardless of the checks
+func (r *Registry) SetShuttingDown() {
+	if !r.shuttingDown.Swap(true) {
+		logger.Info("Readiness disabled for shutdown")
+	}
+}
+
+// ShuttingDown reports whether SetShuttingDown was called
+func (r *Registry) ShuttingDown() bool {
+	return r.shuttingDown.Load()
+}
+
+// Drain marks the process as shutting down and waits for delay, or until
+// ctx is done, so that load balancers observe the failing readiness probe
+// and stop routing before the network function deregisters
+func (r *Registry) Drain(ctx context.Context, delay time.Duration) {
+	r.SetShuttingDown()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Liveness runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Report {
+	return r.run(ctx, Liveness)
+}
+
+// Readiness runs the readiness checks
+func (r *Registry) Readiness(ctx context.Context) Report {
+	report := r.run(ctx, Readiness)
+	if r.ShuttingDown() {
+		report.Status = StatusDown
+		report.ShuttingDown = true
+	}
+	return report
+}
+
+// Detail runs every check
+func (r *Registry) Detail(ctx context.Context) Report {
+	report := r.run(ctx, Both|Informational)
+	if r.ShuttingDown() {
+		report.ShuttingDown = true
+	}
+	return report
+}
+
+// run runs the checks taking part in probe concurrently
+func (r *Registry) run(ctx context.Context, probe Probe) Report {
+	r.mu.RLock()
+	selected := make(map[string]check)
+	for name, c := range r.checks {
+		if c.probe&probe != 0 {
+			selected[name] = c
+		}
+	}
+	r.mu.RUnlock()
+
+	report := Report{
+		Status: StatusUp,
+		Checks: make(map[string]CheckResult, len(selected)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, c := range selected {
+		wg.Add(1)
+		go func(name string, c check) {
+			defer wg.Done()
+			result := r.runCheck(ctx, c)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status == StatusDown {
+				report.Status = StatusDown
+			}
+		}(name, c)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// runCheck runs a single check with the registry timeout
+func (r *Registry) runCheck(ctx context.Context, c check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- c.fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:    StatusUp,
+		Duration:  time.Since(start).Seconds(),
+		Liveness:  c.probe&Liveness != 0,
+		Readiness: c.probe&Readiness != 0,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Handler returns the HTTP handler serving the probes. Path and
+// LivenessPath answer liveness, ReadinessPath readiness and DetailPath
+// every check. Failing probes answer 503.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var report Report
+		switch req.URL.Path {
+		case Path, LivenessPath:
+			report = r.Liveness(req.Context())
+		case ReadinessPath:
+			report = r.Readiness(req.Context())
+		case DetailPath:
+			report = r.Detail(req.Context())
+		default:
+			http.NotFound(w, req)
+			return
+		}
+
+		status := http.StatusOK
+		if report.Status == StatusDown {
+			status = http.StatusServiceUnavailable
+			logger.Debug("Health probe failed",
+				zap.String("path", req.URL.Path),
+				zap.Strings("failing", report.failing()),
+			)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Mount registers Handler on every probe path of mux
+func (r *Registry) Mount(mux interface{ Handle(string, http.Handler) }) {
+	handler := r.Handler()
+	for _, path := range []string{Path, LivenessPath, ReadinessPath, DetailPath} {
+		mux.Handle(path, handler)
+	}
+}
+
+// failing returns the sorted names of the failed checks
+func (r Report) failing() []string {
+	var names []string
+	for name, result := range r.Checks {
+		if result.Status == StatusDown {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,287 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+)
+
+// checkTimeout is the time the checks of the tests are given
+const checkTimeout = 50 * time.Millisecond
+
+// Checks of the tests
+var (
+	up   CheckFunc = func(context.Context) error { return nil }
+	down CheckFunc = func(context.Context) error { return errors.New("unreachable") }
+
+	// hang blocks until the registry timeout
+	hang CheckFunc = func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	crash CheckFunc = func(context.Context) error { panic("nil map") }
+)
+
+// testCheck is a check registered for a test
+type testCheck struct {
+	name  string
+	probe Probe
+	fn    CheckFunc
+}
+
+// newTestRegistry returns a registry holding checks
+func newTestRegistry(checks ...testCheck) *Registry {
+	r := NewRegistry(checkTimeout)
+	for _, c := range checks {
+		r.Register(c.name, c.probe, c.fn)
+	}
+	return r
+}
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks []testCheck
+
+		live, ready, detail Status
+		// failed is the error of the failed check named "failing"
+		failed string
+	}{
+		{
+			name:   "no check",
+			live:   StatusUp,
+			ready:  StatusUp,
+			detail: StatusUp,
+		},
+		{
+			name:   "healthy checks",
+			checks: []testCheck{{"nrf", Readiness, up}, {"loop", Liveness, up}, {"db", Both, up}},
+			live:   StatusUp,
+			ready:  StatusUp,
+			detail: StatusUp,
+		},
+		{
+			name:   "liveness check failing",
+			checks: []testCheck{{"failing", Liveness, down}, {"nrf", Readiness, up}},
+			live:   StatusDown,
+			ready:  StatusUp,
+			detail: StatusDown,
+			failed: "unreachable",
+		},
+		{
+			name:   "readiness check failing",
+			checks: []testCheck{{"failing", Readiness, down}, {"loop", Liveness, up}},
+			live:   StatusUp,
+			ready:  StatusDown,
+			detail: StatusDown,
+			failed: "unreachable",
+		},
+		{
+			name:   "check of both probes failing",
+			checks: []testCheck{{"failing", Both, down}},
+			live:   StatusDown,
+			ready:  StatusDown,
+			detail: StatusDown,
+			failed: "unreachable",
+		},
+		{
+			name:   "informational check failing",
+			checks: []testCheck{{"failing", Informational, down}},
+			live:   StatusUp,
+			ready:  StatusUp,
+			detail: StatusDown,
+			failed: "unreachable",
+		},
+		{
+			name:   "check timing out",
+			checks: []testCheck{{"failing", Readiness, hang}},
+			live:   StatusUp,
+			ready:  StatusDown,
+			detail: StatusDown,
+			failed: context.DeadlineExceeded.Error(),
+		},
+		{
+			name:   "check panicking",
+			checks: []testCheck{{"failing", Liveness, crash}},
+			live:   StatusDown,
+			ready:  StatusUp,
+			detail: StatusDown,
+			failed: "check panicked: nil map",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(tt.checks...)
+			ctx := context.Background()
+
+			probes := []struct {
+				name   string
+				report Report
+				want   Status
+				probe  Probe
+			}{
+				{"liveness", r.Liveness(ctx), tt.live, Liveness},
+				{"readiness", r.Readiness(ctx), tt.ready, Readiness},
+				{"detail", r.Detail(ctx), tt.detail, Both | Informational},
+			}
+			for _, p := range probes {
+				if p.report.Status != p.want {
+					t.Errorf("%s = %s, want %s", p.name, p.report.Status, p.want)
+				}
+				for _, c := range tt.checks {
+					result, ok := p.report.Checks[c.name]
+					if ok != (c.probe&p.probe != 0) {
+						t.Errorf("%s reports %s = %v, want %v", p.name, c.name, ok, c.probe&p.probe != 0)
+						continue
+					}
+					if ok && c.name == "failing" && (result.Status != StatusDown || result.Error != tt.failed) {
+						t.Errorf("%s result of %s = %+v, want down with %q", p.name, c.name, result, tt.failed)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	r := newTestRegistry(testCheck{"loop", Liveness, up}, testCheck{"nrf", Readiness, up})
+	ctx := context.Background()
+	if r.ShuttingDown() || r.Readiness(ctx).ShuttingDown {
+		t.Fatal("registry shutting down before Drain")
+	}
+
+	start := time.Now()
+	r.Drain(ctx, 20*time.Millisecond)
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Drain() returned after %v, want the delay", elapsed)
+	}
+
+	if ready := r.Readiness(ctx); ready.Status != StatusDown || !ready.ShuttingDown {
+		t.Errorf("readiness = %+v, want down while shutting down", ready)
+	}
+	if live := r.Liveness(ctx); live.Status != StatusUp || live.ShuttingDown {
+		t.Errorf("liveness = %+v, want up while shutting down", live)
+	}
+	if detail := r.Detail(ctx); detail.Status != StatusUp || !detail.ShuttingDown {
+		t.Errorf("detail = %+v, want up and shutting down", detail)
+	}
+
+	// A cancelled context ends the drain early
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	r.Drain(cancelled, time.Hour)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Drain() with a cancelled context returned after %v", elapsed)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := newTestRegistry(testCheck{"loop", Liveness, up}, testCheck{"nrf", Readiness, down})
+	mux := http.NewServeMux()
+	r.Mount(mux)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		report Status
+	}{
+		{http.MethodGet, Path, http.StatusOK, StatusUp},
+		{http.MethodGet, LivenessPath, http.StatusOK, StatusUp},
+		{http.MethodHead, LivenessPath, http.StatusOK, ""},
+		{http.MethodGet, ReadinessPath, http.StatusServiceUnavailable, StatusDown},
+		{http.MethodGet, DetailPath, http.StatusServiceUnavailable, StatusDown},
+		{http.MethodPost, ReadinessPath, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, HEAD" {
+				t.Errorf("Allow = %q, want GET, HEAD", w.Header().Get("Allow"))
+			}
+			if tt.report == "" {
+				return
+			}
+			var report Report
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("decoding report: %v", err)
+			}
+			if report.Status != tt.report {
+				t.Errorf("report status = %s, want %s", report.Status, tt.report)
+			}
+		})
+	}
+}
+
+func TestMetricsServerCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" {
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	tests := []struct {
+		name    string
+		enabled bool
+		serving bool
+		ready   Status
+	}{
+		{"metrics disabled", false, false, StatusUp},
+		{"metrics served", true, true, StatusUp},
+		{"metrics server down", true, false, StatusDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Health.Timeout = 1
+			cfg.Metrics.Enabled = tt.enabled
+			cfg.Metrics.Port, _ = strconv.Atoi(port)
+			if !tt.serving {
+				cfg.Metrics.Port = closedPort(t)
+			}
+			r := NewRegistryFromConfig(cfg)
+			ctx := context.Background()
+
+			// A metrics server down takes the process out of rotation
+			// but does not restart it
+			if live := r.Liveness(ctx); live.Status != StatusUp || len(live.Checks) != 0 {
+				t.Errorf("liveness = %+v, want up without checks", live)
+			}
+			ready := r.Readiness(ctx)
+			if ready.Status != tt.ready {
+				t.Errorf("readiness = %+v, want %s", ready, tt.ready)
+			}
+			if _, ok := ready.Checks[CheckMetricsServer]; ok != tt.enabled {
+				t.Errorf("readiness checks %s = %v, want %v", CheckMetricsServer, ok, tt.enabled)
+			}
+		})
+	}
+}
+
+// closedPort returns a local TCP port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
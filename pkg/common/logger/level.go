@@ -0,0 +1,257 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LevelPath is the admin endpoint path served by LevelHandler
+const LevelPath = "/admin/loglevel"
+
+var (
+	// rootLevel is the level of the global logger
+	rootLevel = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+
+	// namedLevels holds per sub-logger level overrides
+	namedLevels   = make(map[string]*namedLevel)
+	namedLevelsMu sync.RWMutex
+)
+
+// namedLevel is the level of a named sub-logger. Until overridden, a
+// sub-logger follows the global level.
+type namedLevel struct {
+	level    zap.AtomicLevel
+	override atomic.Bool
+}
+
+// Enabled implements zapcore.LevelEnabler
+func (n *namedLevel) Enabled(l zapcore.Level) bool {
+	if n.override.Load() {
+		return n.level.Enabled(l)
+	}
+	return rootLevel.Enabled(l)
+}
+
+// current returns the effective level of the sub-logger
+func (n *namedLevel) current() zapcore.Level {
+	if n.override.Load() {
+		return n.level.Level()
+	}
+	return rootLevel.Level()
+}
+
+// levelCore filters entries with a level enabler before handing them to
+// the wrapped core
+type levelCore struct {
+	zapcore.Core
+	enabler zapcore.LevelEnabler
+}
+
+// Enabled implements zapcore.Core
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.enabler.Enabled(l)
+}
+
+// With implements zapcore.Core
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabler: c.enabler}
+}
+
+// Check implements zapcore.Core
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabler.Enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// namedEnabler returns the level holder for a named sub-logger, creating
+// it on first use
+func namedEnabler(name string) *namedLevel {
+	namedLevelsMu.RLock()
+	n, ok := namedLevels[name]
+	namedLevelsMu.RUnlock()
+	if ok {
+		return n
+	}
+
+	namedLevelsMu.Lock()
+	defer namedLevelsMu.Unlock()
+	if n, ok = namedLevels[name]; !ok {
+		n = &namedLevel{level: zap.NewAtomicLevel()}
+		namedLevels[name] = n
+	}
+	return n
+}
+
+// Level returns the atomic level of the global logger
+func Level() zap.AtomicLevel {
+	return rootLevel
+}
+
+// SetLevel changes the level of the global logger, or of the named
+// sub-logger when name is not empty. An empty level on a named
+// sub-logger removes its override so it follows the global level again.
+// source identifies who requested the change and is recorded in the
+// audit entry.
+func SetLevel(name, level, source string) error {
+	var zapLevel zapcore.Level
+	if level != "" || name == "" {
+		if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+			return apperrors.NewBadRequestError(fmt.Sprintf("Invalid log level %q", level), err)
+		}
+	}
+
+	var from, to string
+	if name == "" {
+		from = rootLevel.Level().String()
+		rootLevel.SetLevel(zapLevel)
+		to = zapLevel.String()
+	} else {
+		n := namedEnabler(name)
+		from = n.current().String()
+		if level == "" {
+			n.override.Store(false)
+		} else {
+			n.level.SetLevel(zapLevel)
+			n.override.Store(true)
+		}
+		to = n.current().String()
+	}
+
+	if from != to {
+		Audit("Log level changed",
+			zap.String("target", name),
+			zap.String("from", from),
+			zap.String("to", to),
+			zap.String("source", source),
+		)
+	}
+	return nil
+}
+
+// LevelState describes the current levels of the global logger and of
+// every named sub-logger
+type LevelState struct {
+	Level   string            `json:"level"`
+	Loggers map[string]string `json:"loggers,omitempty"`
+}
+
+// Levels returns the current level state
+func Levels() LevelState {
+	state := LevelState{
+		Level:   rootLevel.Level().String(),
+		Loggers: make(map[string]string),
+	}
+
+	namedLevelsMu.RLock()
+	defer namedLevelsMu.RUnlock()
+	for name, n := range namedLevels {
+		state.Loggers[name] = n.current().String()
+	}
+	return state
+}
+
+// ApplyLevels sets the global level and the given per sub-logger levels.
+// Sub-loggers that are overridden but absent from loggers are reset to
+// follow the global level.
+func ApplyLevels(level string, loggers map[string]string, source string) error {
+	if err := SetLevel("", level, source); err != nil {
+		return err
+	}
+
+	namedLevelsMu.RLock()
+	names := make([]string, 0, len(namedLevels))
+	for name, n := range namedLevels {
+		if _, ok := loggers[name]; !ok && n.override.Load() {
+			names = append(names, name)
+		}
+	}
+	namedLevelsMu.RUnlock()
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := SetLevel(name, loggers[name], source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// levelRequest is the body of a PUT on LevelPath
+type levelRequest struct {
+	Logger string `json:"logger,omitempty"`
+	Level  string `json:"level"`
+}
+
+// LevelHandler returns the admin handler that reports the log levels on
+// GET and changes them on PUT
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req levelRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, apperrors.NewBadRequestError("Invalid request body", err))
+				return
+			}
+			if req.Logger == "" {
+				req.Logger = r.URL.Query().Get("logger")
+			}
+			source := fmt.Sprintf("http %s", r.RemoteAddr)
+			if err := SetLevel(req.Logger, req.Level, source); err != nil {
+				writeError(w, err)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			writeError(w, apperrors.AppError{
+				Type:    apperrors.ErrorTypeBadRequest,
+				Message: fmt.Sprintf("Method %s not allowed", r.Method),
+				Code:    http.StatusMethodNotAllowed,
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Levels())
+	})
+}
+
+// writeError writes an error as a JSON response. Application errors keep
+// their status code, anything else is reported as an internal error.
+func writeError(w http.ResponseWriter, err error) {
+	var appErr apperrors.AppError
+	if !errors.As(err, &appErr) {
+		appErr = apperrors.NewInternalError("Internal error", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(appErr.StatusCode())
+	json.NewEncoder(w).Encode(map[string]string{"message": appErr.Message})
+}
+
+// Audit writes an audit event. Audit events bypass level filtering and
+// sampling and are also written to the audit sink when one is configured.
+func Audit(msg string, fields ...zap.Field) {
+	GetLogger()
+
+	mu.Lock()
+	core := auditCore
+	mu.Unlock()
+
+	zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)).Named("audit").Info(msg, fields...)
+}
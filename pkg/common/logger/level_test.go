@@ -0,0 +1,217 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+// resetLevels restores the global level and drops the sub-logger
+// overrides after a test
+func resetLevels(t *testing.T) {
+	t.Helper()
+
+	// The first audit entry would initialize the logger at info
+	GetLogger()
+	level := rootLevel.Level()
+	t.Cleanup(func() {
+		rootLevel.SetLevel(level)
+		namedLevelsMu.RLock()
+		for _, n := range namedLevels {
+			n.override.Store(false)
+		}
+		namedLevelsMu.RUnlock()
+	})
+}
+
+// serveLevel sends a request to the level endpoint
+func serveLevel(method, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(w, r)
+	return w
+}
+
+func TestLevelHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+
+		status int
+		// level and loggers are the levels answered on success
+		level   string
+		loggers map[string]string
+		// message is the error answered on failure
+		message string
+	}{
+		{
+			name:   "read levels",
+			method: http.MethodGet,
+			target: LevelPath,
+			status: http.StatusOK,
+			level:  "info",
+		},
+		{
+			name:   "set global level",
+			method: http.MethodPut,
+			target: LevelPath,
+			body:   `{"level":"debug"}`,
+			status: http.StatusOK,
+			level:  "debug",
+		},
+		{
+			name:    "set sub-logger level",
+			method:  http.MethodPut,
+			target:  LevelPath,
+			body:    `{"logger":"amf","level":"warn"}`,
+			status:  http.StatusOK,
+			level:   "info",
+			loggers: map[string]string{"amf": "warn"},
+		},
+		{
+			name:    "set sub-logger level from the query",
+			method:  http.MethodPut,
+			target:  LevelPath + "?logger=smf",
+			body:    `{"level":"error"}`,
+			status:  http.StatusOK,
+			level:   "info",
+			loggers: map[string]string{"smf": "error"},
+		},
+		{
+			name:    "reset sub-logger level",
+			method:  http.MethodPut,
+			target:  LevelPath,
+			body:    `{"logger":"upf","level":""}`,
+			status:  http.StatusOK,
+			level:   "info",
+			loggers: map[string]string{"upf": "info"},
+		},
+		{
+			name:    "invalid level",
+			method:  http.MethodPut,
+			target:  LevelPath,
+			body:    `{"level":"verbose"}`,
+			status:  http.StatusBadRequest,
+			message: `Invalid log level "verbose"`,
+		},
+		{
+			name:    "invalid sub-logger level",
+			method:  http.MethodPut,
+			target:  LevelPath,
+			body:    `{"logger":"amf","level":"loud"}`,
+			status:  http.StatusBadRequest,
+			message: `Invalid log level "loud"`,
+		},
+		{
+			name:    "invalid body",
+			method:  http.MethodPut,
+			target:  LevelPath,
+			body:    `debug`,
+			status:  http.StatusBadRequest,
+			message: "Invalid request body",
+		},
+		{
+			name:    "unsupported method",
+			method:  http.MethodPost,
+			target:  LevelPath,
+			body:    `{"level":"debug"}`,
+			status:  http.StatusMethodNotAllowed,
+			message: "Method POST not allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetLevels(t)
+			rootLevel.SetLevel(zapcore.InfoLevel)
+
+			w := serveLevel(tt.method, tt.target, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d %s, want %d", w.Code, w.Body, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("content type = %q, want application/json", ct)
+			}
+
+			if tt.message != "" {
+				var rsp map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+					t.Fatalf("decoding error %q: %v", w.Body, err)
+				}
+				if rsp["message"] != tt.message {
+					t.Errorf("message = %q, want %q", rsp["message"], tt.message)
+				}
+				if tt.status == http.StatusMethodNotAllowed && w.Header().Get("Allow") != "GET, PUT" {
+					t.Errorf("Allow = %q, want GET, PUT", w.Header().Get("Allow"))
+				}
+				if rootLevel.Level() != zapcore.InfoLevel {
+					t.Errorf("global level = %v after a failed request, want info", rootLevel.Level())
+				}
+				return
+			}
+
+			var state LevelState
+			if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+				t.Fatalf("decoding levels %q: %v", w.Body, err)
+			}
+			if state.Level != tt.level || rootLevel.Level().String() != tt.level {
+				t.Errorf("global level = %q answered, %v set, want %q", state.Level, rootLevel.Level(), tt.level)
+			}
+			for name, want := range tt.loggers {
+				if got := state.Loggers[name]; got != want {
+					t.Errorf("level of %s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}{
+		{
+			name:    "application error",
+			err:     apperrors.NewBadRequestError("Invalid log level", nil),
+			status:  http.StatusBadRequest,
+			message: "Invalid log level",
+		},
+		{
+			name:    "wrapped application error",
+			err:     fmt.Errorf("setting level: %w", apperrors.NewNotFoundError("Unknown logger", nil)),
+			status:  http.StatusNotFound,
+			message: "Unknown logger",
+		},
+		{
+			name:    "other error",
+			err:     errors.New("disk full"),
+			status:  http.StatusInternalServerError,
+			message: "Internal error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+
+			var rsp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &rsp); err != nil {
+				t.Fatalf("decoding error %q: %v", w.Body, err)
+			}
+			if w.Code != tt.status || rsp["message"] != tt.message {
+				t.Errorf("writeError() = %d %q, want %d %q", w.Code, rsp["message"], tt.status, tt.message)
+			}
+		})
+	}
+}
@@ -1,57 +1,112 @@
 package logger
 
 import (
+	"io"
 	"os"
+	"sync"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// Global logger instance
-var log *zap.Logger
+var (
+	// Global logger instance
+	log *zap.Logger
 
-// Initialize sets up the logger with the provided log level
+	// base is the unfiltered core shared by the global and named loggers
+	base zapcore.Core
+
+	// auditCore receives audit events, it includes base
+	auditCore zapcore.Core
+
+	// closers holds the files opened by the current configuration
+	closers []io.Closer
+
+	// named caches sub-loggers created by Named
+	named = make(map[string]*zap.Logger)
+
+	// mu guards the variables above, except log
+	mu sync.Mutex
+)
+
+// Initialize sets up the logger with the provided log level, writing
+// JSON to stdout
 func Initialize(level string) {
+	err := InitializeWithOptions(Options{Level: level})
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// InitializeWithOptions sets up the logger with the provided encoding,
+// outputs, sampling and audit sink
+func InitializeWithOptions(opts Options) error {
 	// Parse log level
 	var zapLevel zapcore.Level
-	err := zapLevel.UnmarshalText([]byte(level))
+	err := zapLevel.UnmarshalText([]byte(opts.Level))
 	if err != nil {
 		zapLevel = zapcore.InfoLevel
 	}
 
-	// Logger configuration
-	config := zap.Config{
-		Level:             zap.NewAtomicLevelAt(zapLevel),
-		Development:       false,
-		DisableCaller:     false,
-		DisableStacktrace: false,
-		Sampling:          nil,
-		Encoding:          "json",
-		EncoderConfig: zapcore.EncoderConfig{
-			TimeKey:        "time",
-			LevelKey:       "level",
-			NameKey:        "logger",
-			CallerKey:      "caller",
-			FunctionKey:    zapcore.OmitKey,
-			MessageKey:     "msg",
-			StacktraceKey:  "stacktrace",
-			LineEnding:     zapcore.DefaultLineEnding,
-			EncodeLevel:    zapcore.LowercaseLevelEncoder,
-			EncodeTime:     zapcore.ISO8601TimeEncoder,
-			EncodeDuration: zapcore.SecondsDurationEncoder,
-			EncodeCaller:   zapcore.ShortCallerEncoder,
-		},
-		OutputPaths:      []string{"stdout"},
-		ErrorOutputPaths: []string{"stderr"},
+	if len(opts.Outputs) == 0 {
+		opts.Outputs = []string{"stdout"}
 	}
 
-	// Build the logger
-	log, err = config.Build()
+	encoder, err := newEncoder(opts.Encoding)
 	if err != nil {
-		os.Exit(1)
+		return err
+	}
+
+	// Main outputs. The core level is left wide open because filtering
+	// happens in levelCore, which lets named sub-loggers run more
+	// verbosely than the root logger.
+	sink, opened, err := openOutputs(opts.Outputs, opts.Rotation)
+	if err != nil {
+		return err
+	}
+	core, err := newSampledCore(zapcore.NewCore(encoder, sink, zapcore.DebugLevel), opts.Sampling)
+	if err != nil {
+		closeAll(opened)
+		return err
 	}
 
-	log.Info("Logger initialized", zap.String("level", level))
+	// Audit events are never sampled and go to the main outputs as well
+	audit := zapcore.NewCore(encoder.Clone(), sink, zapcore.DebugLevel)
+	if len(opts.Audit.Outputs) > 0 {
+		auditSink, auditOpened, err := openOutputs(opts.Audit.Outputs, opts.Rotation)
+		if err != nil {
+			closeAll(opened)
+			return err
+		}
+		opened = append(opened, auditOpened...)
+		audit = zapcore.NewTee(audit, zapcore.NewCore(newAuditEncoder(), auditSink, zapcore.DebugLevel))
+	}
+
+	built := zap.New(core,
+		zap.AddCaller(),
+		zap.AddStacktrace(zapcore.ErrorLevel),
+		zap.ErrorOutput(zapcore.Lock(os.Stderr)),
+	)
+
+	mu.Lock()
+	rootLevel.SetLevel(zapLevel)
+	base = core
+	auditCore = audit
+	log = built.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return &levelCore{Core: core, enabler: rootLevel}
+	}))
+	named = make(map[string]*zap.Logger)
+	previous := closers
+	closers = opened
+	mu.Unlock()
+
+	closeAll(previous)
+
+	log.Info("Logger initialized",
+		zap.String("level", zapLevel.String()),
+		zap.Strings("outputs", opts.Outputs),
+	)
+	return nil
 }
 
 // GetLogger returns the global logger instance
@@ -62,6 +117,25 @@ func GetLogger() *zap.Logger {
 	return log
 }
 
+// Named returns a sub-logger whose level can be changed independently
+// of the global logger with SetLevel
+func Named(name string) *zap.Logger {
+	root := GetLogger()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if l, ok := named[name]; ok {
+		return l
+	}
+
+	l := root.Named(name).WithOptions(zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return &levelCore{Core: base, enabler: namedEnabler(name)}
+	}))
+	named[name] = l
+	return l
+}
+
 // With creates a child logger with additional fields
 func With(fields ...zap.Field) *zap.Logger {
 	return GetLogger().With(fields...)
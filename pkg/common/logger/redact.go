@@ -0,0 +1,170 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// PrivacyMode selects how subscriber identifiers and security material
+// are written to the logs
+type PrivacyMode string
+
+const (
+	// PrivacyClear logs values as they are. Only meant for lab setups.
+	PrivacyClear PrivacyMode = "clear"
+
+	// PrivacyMask keeps the identifier type and home network and masks
+	// the subscriber part
+	PrivacyMask PrivacyMode = "mask"
+
+	// PrivacyHash replaces values with a keyed hash, so the same
+	// subscriber can still be followed across log entries
+	PrivacyHash PrivacyMode = "hash"
+)
+
+// redacted replaces security material outside of PrivacyClear
+const redacted = "[REDACTED]"
+
+// privacy is the active redaction policy
+type privacy struct {
+	mode PrivacyMode
+	salt []byte
+}
+
+var currentPrivacy atomic.Pointer[privacy]
+
+// processSalt keys PrivacyHash when no salt is configured. Without a key,
+// the hashes of identifiers as guessable as SUPIs could be reversed by
+// trying them all.
+var processSalt = make([]byte, 32)
+
+func init() {
+	if _, err := rand.Read(processSalt); err != nil {
+		panic(fmt.Sprintf("generating the log privacy salt: %v", err))
+	}
+	currentPrivacy.Store(&privacy{mode: PrivacyHash, salt: processSalt})
+}
+
+// SetPrivacy sets the privacy mode used by the subscriber field
+// constructors. salt keys the hash used by PrivacyHash; it should be kept
+// secret and stable across restarts so hashes stay comparable. A random
+// salt generated at startup is used when it is empty, the hashes then
+// only matching within the process.
+func SetPrivacy(mode string, salt string) error {
+	m := PrivacyMode(strings.ToLower(mode))
+	switch m {
+	case PrivacyClear, PrivacyMask, PrivacyHash:
+	default:
+		return fmt.Errorf("unknown privacy mode %q", mode)
+	}
+
+	key := []byte(salt)
+	if len(key) == 0 {
+		key = processSalt
+	}
+	currentPrivacy.Store(&privacy{mode: m, salt: key})
+	return nil
+}
+
+// Privacy returns the active privacy mode
+func Privacy() PrivacyMode {
+	return currentPrivacy.Load().mode
+}
+
+// SUPI creates a field for a Subscription Permanent Identifier, e.g.
+// "imsi-208930000000001" or "nai-user@realm"
+func SUPI(supi string) zap.Field {
+	return zap.String("supi", redactIdentifier(supi))
+}
+
+// IMSI creates a field for an International Mobile Subscriber Identity
+func IMSI(imsi string) zap.Field {
+	return zap.String("imsi", redactIdentifier(imsi))
+}
+
+// GPSI creates a field for a Generic Public Subscription Identifier, e.g.
+// "msisdn-33612345678"
+func GPSI(gpsi string) zap.Field {
+	return zap.String("gpsi", redactIdentifier(gpsi))
+}
+
+// Key creates a field for key material such as K, OPc, KAUSF or KAMF.
+// Keys are only ever written in PrivacyClear.
+func Key(name string, key []byte) zap.Field {
+	if Privacy() != PrivacyClear {
+		return zap.String(name, redacted)
+	}
+	return zap.String(name, hex.EncodeToString(key))
+}
+
+// RAND creates a field for an authentication challenge
+func RAND(rand []byte) zap.Field {
+	return zap.String("rand", redactBytes(rand))
+}
+
+// AUTN creates a field for an authentication token
+func AUTN(autn []byte) zap.Field {
+	return zap.String("autn", redactBytes(autn))
+}
+
+// redactIdentifier applies the privacy mode to a subscriber identifier
+func redactIdentifier(value string) string {
+	p := currentPrivacy.Load()
+	switch p.mode {
+	case PrivacyClear:
+		return value
+	case PrivacyMask:
+		return maskIdentifier(value)
+	default:
+		return p.hash([]byte(value))
+	}
+}
+
+// redactBytes applies the privacy mode to authentication material
+func redactBytes(value []byte) string {
+	p := currentPrivacy.Load()
+	switch p.mode {
+	case PrivacyClear:
+		return hex.EncodeToString(value)
+	case PrivacyMask:
+		return redacted
+	default:
+		return p.hash(value)
+	}
+}
+
+// hash returns a truncated HMAC-SHA256 of value
+func (p *privacy) hash(value []byte) string {
+	mac := hmac.New(sha256.New, p.salt)
+	mac.Write(value)
+	return "hash-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// maskIdentifier keeps the type prefix of an identifier and, for IMSI
+// based identifiers, the MCC and MNC, masking everything else
+func maskIdentifier(value string) string {
+	prefix, id, found := strings.Cut(value, "-")
+	if !found {
+		prefix, id = "", value
+	} else {
+		prefix += "-"
+	}
+
+	keep := 0
+	if prefix == "" || prefix == "imsi-" {
+		// MCC plus a 2 digit MNC
+		keep = 5
+	}
+	if keep > len(id) {
+		keep = len(id)
+	}
+
+	return prefix + id[:keep] + strings.Repeat("*", len(id)-keep)
+}
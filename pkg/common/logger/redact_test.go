@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+const testSalt = "lab-salt"
+
+var (
+	testKey  = []byte{0x46, 0x5b, 0x5c, 0xe8}
+	testRAND = []byte{0x23, 0x55, 0x3c, 0xbe}
+)
+
+// hmacHash is the expected hash of value under a salt
+func hmacHash(salt string, value []byte) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write(value)
+	return "hash-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// setPrivacy sets a privacy mode for a test, restoring the default after
+func setPrivacy(t *testing.T, mode PrivacyMode, salt string) {
+	t.Helper()
+	if err := SetPrivacy(string(mode), salt); err != nil {
+		t.Fatalf("SetPrivacy(%q) error = %v", mode, err)
+	}
+	t.Cleanup(func() { SetPrivacy(string(PrivacyHash), "") })
+}
+
+func TestRedactionFields(t *testing.T) {
+	supi := func() zap.Field { return SUPI("imsi-208930000000001") }
+	nai := func() zap.Field { return SUPI("nai-user@realm") }
+	imsi := func() zap.Field { return IMSI("208930000000001") }
+	gpsi := func() zap.Field { return GPSI("msisdn-33612345678") }
+	key := func() zap.Field { return Key("kamf", testKey) }
+	rand := func() zap.Field { return RAND(testRAND) }
+	autn := func() zap.Field { return AUTN(testRAND) }
+
+	tests := []struct {
+		name string
+		mode PrivacyMode
+
+		// field is created once the mode is set
+		field func() zap.Field
+		key   string
+		want  string
+	}{
+		{"clear SUPI", PrivacyClear, supi, "supi", "imsi-208930000000001"},
+		{"clear GPSI", PrivacyClear, gpsi, "gpsi", "msisdn-33612345678"},
+		{"clear key", PrivacyClear, key, "kamf", "465b5ce8"},
+		{"clear RAND", PrivacyClear, rand, "rand", "23553cbe"},
+
+		{"masked SUPI", PrivacyMask, supi, "supi", "imsi-20893**********"},
+		{"masked NAI", PrivacyMask, nai, "supi", "nai-**********"},
+		{"masked IMSI", PrivacyMask, imsi, "imsi", "20893**********"},
+		{"masked GPSI", PrivacyMask, gpsi, "gpsi", "msisdn-***********"},
+		{"masked key", PrivacyMask, key, "kamf", redacted},
+		{"masked AUTN", PrivacyMask, autn, "autn", redacted},
+
+		{"hashed SUPI", PrivacyHash, supi, "supi", hmacHash(testSalt, []byte("imsi-208930000000001"))},
+		{"hashed GPSI", PrivacyHash, gpsi, "gpsi", hmacHash(testSalt, []byte("msisdn-33612345678"))},
+		{"hashed key", PrivacyHash, key, "kamf", redacted},
+		{"hashed RAND", PrivacyHash, rand, "rand", hmacHash(testSalt, testRAND)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPrivacy(t, tt.mode, testSalt)
+
+			f := tt.field()
+			if f.Key != tt.key || f.String != tt.want {
+				t.Errorf("field = %s: %q, want %s: %q", f.Key, f.String, tt.key, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashSalt(t *testing.T) {
+	const value = "imsi-208930000000001"
+	hash := func(salt string) string {
+		setPrivacy(t, PrivacyHash, salt)
+		return SUPI(value).String
+	}
+
+	salted := hash(testSalt)
+	if again := hash(testSalt); again != salted {
+		t.Errorf("hashes under the same salt differ: %s and %s", salted, again)
+	}
+	if other := hash("other-salt"); other == salted {
+		t.Errorf("hashes under different salts match: %s", other)
+	}
+
+	// Without a salt the random one of the process keys the hash: it is
+	// stable within the process but is not the unkeyed hash, which could
+	// be reversed by trying every SUPI
+	random := hash("")
+	if again := hash(""); again != random {
+		t.Errorf("hashes without salt differ within the process: %s and %s", random, again)
+	}
+	if random == hmacHash("", []byte(value)) {
+		t.Errorf("hash without salt %s is unkeyed", random)
+	}
+	if random != hmacHash(string(processSalt), []byte(value)) {
+		t.Errorf("hash without salt %s is not keyed by the process salt", random)
+	}
+}
+
+func TestSetPrivacy(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    PrivacyMode
+		wantErr bool
+	}{
+		{mode: "clear", want: PrivacyClear},
+		{mode: "MASK", want: PrivacyMask},
+		{mode: "hash", want: PrivacyHash},
+		{mode: "plain", wantErr: true},
+		{mode: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Cleanup(func() { SetPrivacy(string(PrivacyHash), "") })
+
+			err := SetPrivacy(tt.mode, testSalt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetPrivacy(%q) error = %v, want error %v", tt.mode, err, tt.wantErr)
+			}
+			if err == nil && Privacy() != tt.want {
+				t.Errorf("Privacy() = %q, want %q", Privacy(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp appended to rotated file names. It
+// sorts lexically in chronological order.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotationOptions configures rotation of file outputs
+type RotationOptions struct {
+	MaxSize    int           // megabytes before the file is rotated, 0 disables
+	Interval   time.Duration // age of the file before it is rotated, 0 disables
+	MaxAge     time.Duration // how long rotated files are kept, 0 keeps them
+	MaxBackups int           // how many rotated files are kept, 0 keeps them
+}
+
+// rotatingFile is a zapcore.WriteSyncer that rotates the underlying file
+// by size and age and prunes old backups
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	opts    RotationOptions
+	file    *os.File
+	size    int64
+	created time.Time
+	closed  bool
+}
+
+// newRotatingFile opens path for appending, creating its directory if
+// needed
+func newRotatingFile(path string, opts RotationOptions) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, opts: opts}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write implements io.Writer
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.shouldRotate(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Sync implements zapcore.WriteSyncer
+func (r *rotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close closes the current file. Later writes fail.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.file.Close()
+}
+
+// shouldRotate reports whether writing n more bytes requires a rotation
+func (r *rotatingFile) shouldRotate(n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.opts.MaxSize > 0 && r.size+int64(n) > int64(r.opts.MaxSize)*1024*1024 {
+		return true
+	}
+	return r.opts.Interval > 0 && time.Since(r.created) >= r.opts.Interval
+}
+
+// open opens the log file, picking up the size of an existing one
+func (r *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.created = time.Now()
+	return nil
+}
+
+// rotate moves the current file aside and opens a new one
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	prefix, ext := r.backupParts()
+	backup := fmt.Sprintf("%s%s%s", prefix, time.Now().UTC().Format(backupTimeFormat), ext)
+	if err := os.Rename(r.path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// backupParts returns the prefix and extension shared by backup names,
+// e.g. "/var/log/amf-" and ".log" for "/var/log/amf.log"
+func (r *rotatingFile) backupParts() (string, string) {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-", ext
+}
+
+// prune removes backups beyond MaxBackups or older than MaxAge
+func (r *rotatingFile) prune() {
+	if r.opts.MaxBackups <= 0 && r.opts.MaxAge <= 0 {
+		return
+	}
+
+	prefix, ext := r.backupParts()
+	matches, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, path := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(path, prefix), ext)
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: path, time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	for i, b := range backups {
+		tooMany := r.opts.MaxBackups > 0 && i >= r.opts.MaxBackups
+		tooOld := r.opts.MaxAge > 0 && time.Since(b.time) > r.opts.MaxAge
+		if tooMany || tooOld {
+			os.Remove(b.path)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Options configures the logger encoding and outputs
+type Options struct {
+	Level    string
+	Encoding string   // "json" or "console"
+	Outputs  []string // "stdout", "stderr" or file paths
+	Rotation RotationOptions
+	Sampling map[string]SamplingOptions // keyed by level, e.g. "debug"
+	Audit    AuditOptions
+}
+
+// SamplingOptions configures sampling of a level. Within each second the
+// first Initial entries with a given message are logged, then one in
+// every Thereafter.
+type SamplingOptions struct {
+	Initial    int
+	Thereafter int
+}
+
+// AuditOptions configures the optional audit sink. Audit events are
+// always written to the main outputs as well.
+type AuditOptions struct {
+	Outputs []string
+}
+
+// encoderConfig returns the encoder configuration for the given encoding
+func encoderConfig(encoding string) zapcore.EncoderConfig {
+	config := zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "msg",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	if encoding == "console" {
+		config.EncodeLevel = zapcore.CapitalLevelEncoder
+		config.EncodeDuration = zapcore.StringDurationEncoder
+	}
+	return config
+}
+
+// newEncoder creates the encoder for the given encoding
+func newEncoder(encoding string) (zapcore.Encoder, error) {
+	switch encoding {
+	case "", "json":
+		return zapcore.NewJSONEncoder(encoderConfig("json")), nil
+	case "console":
+		return zapcore.NewConsoleEncoder(encoderConfig("console")), nil
+	default:
+		return nil, fmt.Errorf("unknown log encoding %q", encoding)
+	}
+}
+
+// openOutputs opens the given outputs and combines them into one
+// WriteSyncer. Opened files are returned so they can be closed later.
+func openOutputs(outputs []string, rotation RotationOptions) (zapcore.WriteSyncer, []io.Closer, error) {
+	var syncers []zapcore.WriteSyncer
+	var closers []io.Closer
+
+	for _, output := range outputs {
+		switch output {
+		case "stdout":
+			syncers = append(syncers, zapcore.Lock(os.Stdout))
+		case "stderr":
+			syncers = append(syncers, zapcore.Lock(os.Stderr))
+		default:
+			file, err := newRotatingFile(output, rotation)
+			if err != nil {
+				closeAll(closers)
+				return nil, nil, err
+			}
+			syncers = append(syncers, file)
+			closers = append(closers, file)
+		}
+	}
+
+	return zapcore.NewMultiWriteSyncer(syncers...), closers, nil
+}
+
+// newSampledCore wraps core so that each level configured in sampling is
+// sampled on its own, leaving the other levels untouched
+func newSampledCore(core zapcore.Core, sampling map[string]SamplingOptions) (zapcore.Core, error) {
+	if len(sampling) == 0 {
+		return core, nil
+	}
+
+	sampled := make(map[zapcore.Level]bool)
+	var cores []zapcore.Core
+	for name, opts := range sampling {
+		var level zapcore.Level
+		if err := level.UnmarshalText([]byte(strings.ToLower(name))); err != nil {
+			return nil, fmt.Errorf("invalid sampling level %q: %w", name, err)
+		}
+		sampled[level] = true
+
+		only := &levelCore{Core: core, enabler: zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l == level
+		})}
+		cores = append(cores, zapcore.NewSamplerWithOptions(only, time.Second, opts.Initial, opts.Thereafter))
+	}
+
+	cores = append(cores, &levelCore{Core: core, enabler: zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+		return !sampled[l]
+	})})
+	return zapcore.NewTee(cores...), nil
+}
+
+// closeAll closes every closer, ignoring errors
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		c.Close()
+	}
+}
+
+// newAuditEncoder creates the encoder of the audit sink, which is always
+// JSON so audit trails stay machine readable
+func newAuditEncoder() zapcore.Encoder {
+	return zapcore.NewJSONEncoder(encoderConfig("json"))
+}
@@ -2,81 +2,185 @@ package metrics
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 
+	"github.com/0had0/5G-core/pkg/common/logger"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/0had0/5G-core/pkg/common/logger"
 	"go.uber.org/zap"
 )
 
-var (
-	// RequestCounter counts the number of requests processed
-	RequestCounter = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "requests_total",
-			Help: "Total number of requests processed",
-		},
-		[]string{"service", "method", "status"},
-	)
+// Metrics holds the Prometheus collectors of a network function. Each
+// instance owns its registry, so several can live in one process.
+type Metrics struct {
+	// Registry is the registry all collectors are registered on
+	Registry *prometheus.Registry
 
-	// RequestDuration tracks the duration of requests
-	RequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "request_duration_seconds",
-			Help:    "Duration of requests in seconds",
-			Buckets: prometheus.DefBuckets,
-		},
-		[]string{"service", "method"},
-	)
+	// RequestCounter counts the number of requests processed, by role
+	RequestCounter *prometheus.CounterVec
+
+	// RequestDuration tracks the duration of requests, by role
+	RequestDuration *prometheus.HistogramVec
 
 	// ActiveConnections tracks the number of active connections
-	ActiveConnections = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "active_connections",
-			Help: "Number of active connections",
-		},
-		[]string{"service"},
-	)
+	ActiveConnections *prometheus.GaugeVec
 
 	// ServiceRegistrations tracks the number of service registrations with NRF
-	ServiceRegistrations = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "service_registrations_total",
-			Help: "Total number of service registrations with NRF",
-		},
-		[]string{"service", "status"},
-	)
+	ServiceRegistrations *prometheus.CounterVec
 
 	// ServiceDiscoveries tracks the number of service discoveries from NRF
-	ServiceDiscoveries = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "service_discoveries_total",
-			Help: "Total number of service discoveries from NRF",
-		},
-		[]string{"service", "target_service", "status"},
-	)
+	ServiceDiscoveries *prometheus.CounterVec
+
+	serviceName string
+}
+
+// Values of the role label of the request metrics, telling the requests
+// a network function served from those it sent
+const (
+	RoleClient = "client"
+	RoleServer = "server"
 )
 
-// Initialize sets up the Prometheus metrics and starts the metrics server
-func Initialize(serviceName string, metricsPort int) {
+// New creates the metrics of a network function on a new registry
+func New(serviceName string) *Metrics {
+	m := &Metrics{
+		Registry:    prometheus.NewRegistry(),
+		serviceName: serviceName,
+
+		RequestCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "requests_total",
+				Help: "Total number of requests processed",
+			},
+			[]string{"service", "role", "method", "status"},
+		),
+
+		RequestDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "request_duration_seconds",
+				Help:    "Duration of requests in seconds",
+				Buckets: prometheus.DefBuckets,
+			},
+			[]string{"service", "role", "method"},
+		),
+
+		ActiveConnections: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "active_connections",
+				Help: "Number of active connections",
+			},
+			[]string{"service"},
+		),
+
+		ServiceRegistrations: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "service_registrations_total",
+				Help: "Total number of service registrations with NRF",
+			},
+			[]string{"service", "status"},
+		),
+
+		ServiceDiscoveries: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "service_discoveries_total",
+				Help: "Total number of service discoveries from NRF",
+			},
+			[]string{"service", "target_service", "status"},
+		),
+	}
+
 	// Register the metrics
-	prometheus.MustRegister(RequestCounter)
-	prometheus.MustRegister(RequestDuration)
-	prometheus.MustRegister(ActiveConnections)
-	prometheus.MustRegister(ServiceRegistrations)
-	prometheus.MustRegister(ServiceDiscoveries)
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.RequestCounter,
+		m.RequestDuration,
+		m.ActiveConnections,
+		m.ServiceRegistrations,
+		m.ServiceDiscoveries,
+	)
+
+	return m
+}
+
+// ServiceName returns the name of the network function the metrics
+// belong to
+func (m *Metrics) ServiceName() string {
+	return m.serviceName
+}
+
+// Handler returns the HTTP handler exposing the registry
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// NewServer creates the metrics server. The caller starts it and shuts
+// it down.
+func (m *Metrics) NewServer(metricsPort int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+
+	return &http.Server{
+		Addr:    fmt.Sprintf(":%d", metricsPort),
+		Handler: mux,
+	}
+}
+
+// NewAdminServer creates the server of the admin endpoints, which change
+// the log levels. They are not authenticated: the server listens on its
+// own address, which should be a loopback one, so that what can scrape
+// the metrics cannot turn debug logging on. The caller starts it and
+// shuts it down.
+func NewAdminServer(host string, port int) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(logger.LevelPath, logger.LevelHandler())
+
+	return &http.Server{
+		Addr:    net.JoinHostPort(host, strconv.Itoa(port)),
+		Handler: mux,
+	}
+}
+
+// ServeAdmin starts the admin server in the background and returns it so
+// it can be shut down
+func ServeAdmin(host string, port int) *http.Server {
+	server := NewAdminServer(host, port)
 
-	// Start the metrics server
 	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		address := fmt.Sprintf(":%d", metricsPort)
-		logger.Info("Starting metrics server", zap.String("address", address))
-		err := http.ListenAndServe(address, nil)
-		if err != nil {
+		logger.Info("Starting admin server", zap.String("address", server.Addr))
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("Admin server error", zap.Error(err))
+		}
+	}()
+
+	return server
+}
+
+// Serve starts the metrics server in the background and returns it so
+// it can be shut down
+func (m *Metrics) Serve(metricsPort int) *http.Server {
+	server := m.NewServer(metricsPort)
+
+	go func() {
+		logger.Info("Starting metrics server", zap.String("address", server.Addr))
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			logger.Error("Metrics server error", zap.Error(err))
 		}
 	}()
 
+	return server
+}
+
+// Initialize sets up the Prometheus metrics and starts the metrics server
+func Initialize(serviceName string, metricsPort int) (*Metrics, *http.Server) {
+	m := New(serviceName)
+	server := m.Serve(metricsPort)
+
 	logger.Info("Metrics initialized", zap.String("service", serviceName))
+	return m, server
 }
@@ -0,0 +1,278 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Label names shared by the network function metric families
+const (
+	// LabelInstance identifies the NF instance a series belongs to
+	LabelInstance = "nf_instance"
+
+	// LabelDNN is the Data Network Name
+	LabelDNN = "dnn"
+
+	// LabelSNSSAI is the slice, formatted by SNSSAILabel
+	LabelSNSSAI = "snssai"
+
+	// LabelResult is "success" or "failure"
+	LabelResult = "result"
+
+	// LabelCause is the 3GPP cause of a failure
+	LabelCause = "cause"
+)
+
+// Values of LabelResult
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// SNSSAILabel formats an S-NSSAI as a label value, e.g. "1" or "1-010203"
+func SNSSAILabel(sst uint8, sd string) string {
+	if sd == "" {
+		return fmt.Sprintf("%d", sst)
+	}
+	return fmt.Sprintf("%d-%s", sst, strings.ToLower(sd))
+}
+
+// instanceRegisterer returns a registerer that adds the NF instance
+// label to everything registered through it
+func (m *Metrics) instanceRegisterer(instanceID string) prometheus.Registerer {
+	return prometheus.WrapRegistererWith(prometheus.Labels{LabelInstance: instanceID}, m.Registry)
+}
+
+// AMFMetrics holds the AMF procedure metrics
+type AMFMetrics struct {
+	// RegisteredUEs is the number of UEs in RM-REGISTERED
+	RegisteredUEs prometheus.Gauge
+
+	// ConnectedUEs is the number of UEs in CM-CONNECTED
+	ConnectedUEs prometheus.Gauge
+
+	// RegistrationAttempts counts Registration Requests by registration type
+	RegistrationAttempts *prometheus.CounterVec
+
+	// RegistrationSuccesses counts Registration Accepts by registration type
+	RegistrationSuccesses *prometheus.CounterVec
+
+	// RegistrationFailures counts Registration Rejects by registration type
+	// and 5GMM cause
+	RegistrationFailures *prometheus.CounterVec
+
+	// Handovers counts Xn and N2 handovers by type and result
+	Handovers *prometheus.CounterVec
+
+	// Pagings counts pagings by result: success when the UE answered,
+	// failure when it did not before the last T3513 expiry
+	Pagings *prometheus.CounterVec
+
+	// ImplicitDeregistrations counts UEs deregistered after their implicit
+	// deregistration timer expired
+	ImplicitDeregistrations prometheus.Counter
+}
+
+// NewAMFMetrics creates the AMF metrics and registers them on m
+func NewAMFMetrics(m *Metrics, instanceID string) *AMFMetrics {
+	a := &AMFMetrics{
+		RegisteredUEs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "amf_registered_ues",
+			Help: "Number of UEs in RM-REGISTERED state",
+		}),
+		ConnectedUEs: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "amf_connected_ues",
+			Help: "Number of UEs in CM-CONNECTED state",
+		}),
+		RegistrationAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "amf_registration_attempts_total",
+			Help: "Total number of registration attempts",
+		}, []string{"registration_type"}),
+		RegistrationSuccesses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "amf_registration_success_total",
+			Help: "Total number of successful registrations",
+		}, []string{"registration_type"}),
+		RegistrationFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "amf_registration_failures_total",
+			Help: "Total number of rejected registrations by 5GMM cause",
+		}, []string{"registration_type", LabelCause}),
+		Handovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "amf_handovers_total",
+			Help: "Total number of handovers by type and result",
+		}, []string{"handover_type", LabelResult}),
+		Pagings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "amf_paging_total",
+			Help: "Total number of UE pagings by result",
+		}, []string{LabelResult}),
+		ImplicitDeregistrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "amf_implicit_deregistrations_total",
+			Help: "Total number of UEs implicitly deregistered",
+		}),
+	}
+
+	m.instanceRegisterer(instanceID).MustRegister(
+		a.RegisteredUEs,
+		a.ConnectedUEs,
+		a.RegistrationAttempts,
+		a.RegistrationSuccesses,
+		a.RegistrationFailures,
+		a.Handovers,
+		a.Pagings,
+		a.ImplicitDeregistrations,
+	)
+	return a
+}
+
+// SMFMetrics holds the SMF session and address pool metrics
+type SMFMetrics struct {
+	// ActiveSessions is the number of established PDU sessions by DNN and
+	// S-NSSAI
+	ActiveSessions *prometheus.GaugeVec
+
+	// SessionEstablishments counts PDU session establishments by DNN,
+	// S-NSSAI, result and 5GSM cause
+	SessionEstablishments *prometheus.CounterVec
+
+	// PoolAllocated is the number of allocated addresses or prefixes by
+	// pool
+	PoolAllocated *prometheus.GaugeVec
+
+	// PoolCapacity is the number of allocatable addresses or prefixes by
+	// pool
+	PoolCapacity *prometheus.GaugeVec
+}
+
+// NewSMFMetrics creates the SMF metrics and registers them on m
+func NewSMFMetrics(m *Metrics, instanceID string) *SMFMetrics {
+	s := &SMFMetrics{
+		ActiveSessions: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_pdu_sessions_active",
+			Help: "Number of active PDU sessions",
+		}, []string{LabelDNN, LabelSNSSAI}),
+		SessionEstablishments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smf_pdu_session_establishments_total",
+			Help: "Total number of PDU session establishment attempts",
+		}, []string{LabelDNN, LabelSNSSAI, LabelResult, LabelCause}),
+		PoolAllocated: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_ip_pool_allocated",
+			Help: "Number of allocated addresses or prefixes in the pool",
+		}, []string{LabelDNN, "pool"}),
+		PoolCapacity: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "smf_ip_pool_capacity",
+			Help: "Number of allocatable addresses or prefixes in the pool",
+		}, []string{LabelDNN, "pool"}),
+	}
+
+	m.instanceRegisterer(instanceID).MustRegister(
+		s.ActiveSessions,
+		s.SessionEstablishments,
+		s.PoolAllocated,
+		s.PoolCapacity,
+	)
+	return s
+}
+
+// UPFMetrics holds the UPF user plane metrics
+type UPFMetrics struct {
+	// ActiveSessions is the number of N4 sessions
+	ActiveSessions prometheus.Gauge
+
+	// Bytes counts user plane bytes by direction and QoS flow
+	Bytes *prometheus.CounterVec
+
+	// Packets counts user plane packets by direction and QoS flow
+	Packets *prometheus.CounterVec
+
+	// DroppedBytes counts the bytes of the user plane packets dropped by
+	// direction and reason
+	DroppedBytes *prometheus.CounterVec
+
+	// PolicedBytes counts the bytes of the user plane packets dropped for
+	// exceeding the bit rates of their QoS flow or PDU session, by
+	// direction and QoS flow
+	PolicedBytes *prometheus.CounterVec
+}
+
+// Values of the UPF direction label
+const (
+	DirectionUplink   = "uplink"
+	DirectionDownlink = "downlink"
+)
+
+// NewUPFMetrics creates the UPF metrics and registers them on m
+func NewUPFMetrics(m *Metrics, instanceID string) *UPFMetrics {
+	u := &UPFMetrics{
+		ActiveSessions: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "upf_sessions_active",
+			Help: "Number of N4 sessions",
+		}),
+		Bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upf_qos_flow_bytes_total",
+			Help: "Total number of user plane bytes forwarded per QoS flow",
+		}, []string{"direction", "qfi"}),
+		Packets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upf_qos_flow_packets_total",
+			Help: "Total number of user plane packets forwarded per QoS flow",
+		}, []string{"direction", "qfi"}),
+		DroppedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upf_dropped_bytes_total",
+			Help: "Total number of user plane bytes dropped by reason",
+		}, []string{"direction", "reason"}),
+		PolicedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upf_policed_bytes_total",
+			Help: "Total number of user plane bytes dropped for exceeding the MBR per QoS flow",
+		}, []string{"direction", "qfi"}),
+	}
+
+	m.instanceRegisterer(instanceID).MustRegister(u.ActiveSessions, u.Bytes, u.Packets, u.DroppedBytes,
+		u.PolicedBytes)
+	return u
+}
+
+// AUSFMetrics holds the AUSF authentication metrics
+type AUSFMetrics struct {
+	// Authentications counts authentications by method ("5G_AKA",
+	// "EAP_AKA_PRIME"), result and cause
+	Authentications *prometheus.CounterVec
+}
+
+// NewAUSFMetrics creates the AUSF metrics and registers them on m
+func NewAUSFMetrics(m *Metrics, instanceID string) *AUSFMetrics {
+	a := &AUSFMetrics{
+		Authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ausf_authentications_total",
+			Help: "Total number of UE authentications",
+		}, []string{"auth_type", LabelResult, LabelCause}),
+	}
+
+	m.instanceRegisterer(instanceID).MustRegister(a.Authentications)
+	return a
+}
+
+// NSSFMetrics holds the NSSF slice selection metrics
+type NSSFMetrics struct {
+	// SliceSelections counts slice selection decisions by requested
+	// S-NSSAI and decision ("allowed", "rejected")
+	SliceSelections *prometheus.CounterVec
+}
+
+// Values of the NSSF decision label
+const (
+	DecisionAllowed  = "allowed"
+	DecisionRejected = "rejected"
+)
+
+// NewNSSFMetrics creates the NSSF metrics and registers them on m
+func NewNSSFMetrics(m *Metrics, instanceID string) *NSSFMetrics {
+	n := &NSSFMetrics{
+		SliceSelections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nssf_slice_selections_total",
+			Help: "Total number of slice selection decisions",
+		}, []string{LabelSNSSAI, "decision"}),
+	}
+
+	m.instanceRegisterer(instanceID).MustRegister(n.SliceSelections)
+	return n
+}
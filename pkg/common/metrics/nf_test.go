@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testInstance is the NF instance ID of the metrics of the tests
+const testInstance = "nf-test"
+
+// labelNames returns the sorted label names of the first series of a
+// gathered family
+func labelNames(t *testing.T, m *Metrics, family string) []string {
+	t.Helper()
+
+	families, err := m.Registry.Gather()
+	if err != nil {
+		t.Fatalf("gathering %s: %v", m.ServiceName(), err)
+	}
+	for _, f := range families {
+		if f.GetName() != family || len(f.GetMetric()) == 0 {
+			continue
+		}
+		var names []string
+		for _, l := range f.GetMetric()[0].GetLabel() {
+			names = append(names, l.GetName())
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// TestDashboardMetrics checks the registry of each network function
+// exposes the families the Grafana dashboard queries, with the labels it
+// aggregates by
+func TestDashboardMetrics(t *testing.T) {
+	tests := []struct {
+		nf string
+		// observe creates the NF metrics on m and records one series per
+		// family
+		observe func(m *Metrics)
+		// families are the label names of each family, nf_instance aside
+		families map[string][]string
+	}{
+		{
+			nf: "amf",
+			observe: func(m *Metrics) {
+				a := NewAMFMetrics(m, testInstance)
+				a.RegisteredUEs.Set(1)
+				a.ConnectedUEs.Set(1)
+				a.RegistrationAttempts.WithLabelValues("initial").Inc()
+				a.RegistrationSuccesses.WithLabelValues("initial").Inc()
+				a.RegistrationFailures.WithLabelValues("initial", "ILLEGAL_UE").Inc()
+				a.Handovers.WithLabelValues("n2", ResultSuccess).Inc()
+				a.Pagings.WithLabelValues(ResultFailure).Inc()
+				a.ImplicitDeregistrations.Inc()
+			},
+			families: map[string][]string{
+				"amf_registered_ues":                 nil,
+				"amf_connected_ues":                  nil,
+				"amf_registration_attempts_total":    {"registration_type"},
+				"amf_registration_success_total":     {"registration_type"},
+				"amf_registration_failures_total":    {"cause", "registration_type"},
+				"amf_handovers_total":                {"handover_type", "result"},
+				"amf_paging_total":                   {"result"},
+				"amf_implicit_deregistrations_total": nil,
+			},
+		},
+		{
+			nf: "smf",
+			observe: func(m *Metrics) {
+				s := NewSMFMetrics(m, testInstance)
+				snssai := SNSSAILabel(1, "010203")
+				s.ActiveSessions.WithLabelValues("internet", snssai).Set(1)
+				s.SessionEstablishments.WithLabelValues("internet", snssai, ResultSuccess, "").Inc()
+				s.PoolAllocated.WithLabelValues("internet", "10.60.0.0/16").Set(1)
+				s.PoolCapacity.WithLabelValues("internet", "10.60.0.0/16").Set(65534)
+			},
+			families: map[string][]string{
+				"smf_pdu_sessions_active":              {"dnn", "snssai"},
+				"smf_pdu_session_establishments_total": {"cause", "dnn", "result", "snssai"},
+				"smf_ip_pool_allocated":                {"dnn", "pool"},
+				"smf_ip_pool_capacity":                 {"dnn", "pool"},
+			},
+		},
+		{
+			nf: "upf",
+			observe: func(m *Metrics) {
+				u := NewUPFMetrics(m, testInstance)
+				u.ActiveSessions.Set(1)
+				u.Bytes.WithLabelValues(DirectionUplink, "9").Add(1500)
+				u.Packets.WithLabelValues(DirectionUplink, "9").Inc()
+				u.DroppedBytes.WithLabelValues(DirectionDownlink, "no_pdr").Add(1500)
+				u.PolicedBytes.WithLabelValues(DirectionDownlink, "9").Add(1500)
+			},
+			families: map[string][]string{
+				"upf_sessions_active":        nil,
+				"upf_qos_flow_bytes_total":   {"direction", "qfi"},
+				"upf_qos_flow_packets_total": {"direction", "qfi"},
+				"upf_dropped_bytes_total":    {"direction", "reason"},
+				"upf_policed_bytes_total":    {"direction", "qfi"},
+			},
+		},
+		{
+			nf: "ausf",
+			observe: func(m *Metrics) {
+				NewAUSFMetrics(m, testInstance).Authentications.WithLabelValues("5G_AKA", ResultSuccess, "").Inc()
+			},
+			families: map[string][]string{
+				"ausf_authentications_total": {"auth_type", "cause", "result"},
+			},
+		},
+		{
+			nf: "nssf",
+			observe: func(m *Metrics) {
+				NewNSSFMetrics(m, testInstance).SliceSelections.WithLabelValues(SNSSAILabel(1, ""), DecisionAllowed).Inc()
+			},
+			families: map[string][]string{
+				"nssf_slice_selections_total": {"decision", "snssai"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nf, func(t *testing.T) {
+			m := New(tt.nf)
+			tt.observe(m)
+
+			for family, labels := range tt.families {
+				if n, err := testutil.GatherAndCount(m.Registry, family); err != nil || n != 1 {
+					t.Errorf("%s series = %d (%v), want 1", family, n, err)
+					continue
+				}
+				want := append([]string{LabelInstance}, labels...)
+				sort.Strings(want)
+				if got := labelNames(t, m, family); strings.Join(got, ",") != strings.Join(want, ",") {
+					t.Errorf("%s labels = %v, want %v", family, got, want)
+				}
+			}
+
+			// The registry of a network function holds none of the
+			// families of the others
+			families, err := m.Registry.Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range families {
+				for _, other := range tests {
+					if other.nf != tt.nf && strings.HasPrefix(f.GetName(), other.nf+"_") {
+						t.Errorf("%s registry exposes %s", tt.nf, f.GetName())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRequestMetrics(t *testing.T) {
+	m := New("amf")
+	m.RequestCounter.WithLabelValues(m.ServiceName(), RoleServer, "POST", "201").Inc()
+	m.RequestDuration.WithLabelValues(m.ServiceName(), RoleServer, "POST").Observe(0.01)
+	m.ActiveConnections.WithLabelValues(m.ServiceName()).Set(1)
+	m.ServiceRegistrations.WithLabelValues(m.ServiceName(), "success").Inc()
+	m.ServiceDiscoveries.WithLabelValues(m.ServiceName(), "smf", "success").Inc()
+
+	tests := []struct {
+		family string
+		labels []string
+	}{
+		{"requests_total", []string{"method", "role", "service", "status"}},
+		{"request_duration_seconds", []string{"method", "role", "service"}},
+		{"active_connections", []string{"service"}},
+		{"service_registrations_total", []string{"service", "status"}},
+		{"service_discoveries_total", []string{"service", "status", "target_service"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.family, func(t *testing.T) {
+			if n, err := testutil.GatherAndCount(m.Registry, tt.family); err != nil || n != 1 {
+				t.Fatalf("%s series = %d (%v), want 1", tt.family, n, err)
+			}
+			if got := labelNames(t, m, tt.family); strings.Join(got, ",") != strings.Join(tt.labels, ",") {
+				t.Errorf("%s labels = %v, want %v", tt.family, got, tt.labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Package timer provides a hashed timing wheel for the protocol timers
+// of large numbers of UE contexts. Starting and stopping a timer costs
+// O(1) whatever the number of timers, and a single goroutine serves
+// them all.
+package timer
+
+import (
+	"sync"
+	"time"
+)
+
+// Wheel is a hashed timing wheel. Time is cut in ticks; a timer waits in
+// the slot of the tick it expires at, counting the rounds of the wheel
+// left before that. Timers fire up to one tick late, never early.
+type Wheel struct {
+	clock Clock
+	tick  time.Duration
+
+	mu    sync.Mutex
+	slots []Timer // sentinels of circular lists
+	pos   int
+	last  time.Time // time of the last tick run
+	count int
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Timer is a timer of a wheel
+type Timer struct {
+	w          *Wheel
+	f          func()
+	rounds     int
+	prev, next *Timer
+}
+
+// NewWheel creates a wheel of the given number of slots, each one tick
+// long, running on clock
+func NewWheel(clock Clock, tick time.Duration, slots int) *Wheel {
+	if slots < 1 {
+		slots = 1
+	}
+	w := &Wheel{
+		clock: clock,
+		tick:  tick,
+		slots: make([]Timer, slots),
+		last:  clock.Now(),
+	}
+	for i := range w.slots {
+		s := &w.slots[i]
+		s.prev, s.next = s, s
+	}
+	return w
+}
+
+// AfterFunc starts a timer calling f once d has elapsed. f runs on the
+// goroutine of the wheel, so it must not block.
+func (w *Wheel) AfterFunc(d time.Duration, f func()) *Timer {
+	t := &Timer{w: w, f: f}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.schedule(t, d)
+	return t
+}
+
+// schedule puts a timer in the slot of the first tick at or after its
+// expiry. Ticks are counted from the last one run, up to a tick before
+// now, so the time elapsed since is added to d.
+func (w *Wheel) schedule(t *Timer, d time.Duration) {
+	d += w.clock.Now().Sub(w.last)
+	ticks := int((d + w.tick - 1) / w.tick)
+	if ticks < 1 {
+		ticks = 1
+	}
+	t.rounds = (ticks - 1) / len(w.slots)
+
+	s := &w.slots[(w.pos+ticks)%len(w.slots)]
+	t.prev, t.next = s.prev, s
+	s.prev.next = t
+	s.prev = t
+	w.count++
+}
+
+// unlink removes a scheduled timer from its slot
+func (w *Wheel) unlink(t *Timer) {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+	w.count--
+}
+
+// Stop stops the timer. It reports whether the timer was stopped before
+// it fired.
+func (t *Timer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+
+	if t.next == nil {
+		return false
+	}
+	t.w.unlink(t)
+	return true
+}
+
+// Reset restarts the timer to fire after d, whether it fired or not. It
+// reports whether the timer was still running.
+func (t *Timer) Reset(d time.Duration) bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+
+	active := t.next != nil
+	if active {
+		t.w.unlink(t)
+	}
+	t.w.schedule(t, d)
+	return active
+}
+
+// Len returns the number of running timers
+func (w *Wheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Advance runs the ticks elapsed up to the current time of the clock,
+// firing the timers that expired. The goroutine of the wheel calls it
+// every tick; tests call it after moving a FakeClock.
+func (w *Wheel) Advance() {
+	now := w.clock.Now()
+
+	var expired []func()
+	w.mu.Lock()
+	for !w.last.Add(w.tick).After(now) {
+		w.last = w.last.Add(w.tick)
+		w.pos = (w.pos + 1) % len(w.slots)
+
+		s := &w.slots[w.pos]
+		for t := s.next; t != s; {
+			next := t.next
+			if t.rounds > 0 {
+				t.rounds--
+			} else {
+				w.unlink(t)
+				expired = append(expired, t.f)
+			}
+			t = next
+		}
+	}
+	w.mu.Unlock()
+
+	for _, f := range expired {
+		f()
+	}
+}
+
+// Start runs the wheel on a goroutine ticking with the system time
+func (w *Wheel) Start() {
+	w.done = make(chan struct{})
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		ticker := time.NewTicker(w.tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Advance()
+			case <-w.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the goroutine of the wheel. Running timers no longer fire.
+func (w *Wheel) Stop() {
+	if w.done == nil {
+		return
+	}
+	close(w.done)
+	w.wg.Wait()
+	w.done = nil
+}
@@ -0,0 +1,139 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+const testTick = 10 * time.Millisecond
+
+// step moves the clock by d in steps of a millisecond, advancing the
+// wheel at each one
+func step(c *FakeClock, w *Wheel, d time.Duration) {
+	for ; d > 0; d -= time.Millisecond {
+		c.Add(time.Millisecond)
+		w.Advance()
+	}
+}
+
+func TestWheelFiresOnTime(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration // clock moved since the last tick before the timer starts
+		d      time.Duration
+	}{
+		{"zero", 0, 0},
+		{"under a tick", 0, 3 * time.Millisecond},
+		{"one tick", 0, testTick},
+		{"between ticks", 0, 15 * time.Millisecond},
+		{"several rounds", 0, 250 * time.Millisecond},
+		{"started mid tick", 7 * time.Millisecond, testTick},
+		{"started mid tick, several rounds", 9 * time.Millisecond, 123 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewFakeClock(time.Unix(0, 0))
+			w := NewWheel(c, testTick, 8)
+			c.Add(tt.offset)
+
+			start := c.Now()
+			var fired time.Time
+			w.AfterFunc(tt.d, func() { fired = c.Now() })
+
+			step(c, w, tt.d+2*testTick)
+			if fired.IsZero() {
+				t.Fatalf("timer of %v did not fire", tt.d)
+			}
+			elapsed := fired.Sub(start)
+			if elapsed < tt.d {
+				t.Errorf("timer of %v fired early, after %v", tt.d, elapsed)
+			}
+			if elapsed > tt.d+testTick {
+				t.Errorf("timer of %v fired more than a tick late, after %v", tt.d, elapsed)
+			}
+			if n := w.Len(); n != 0 {
+				t.Errorf("Len() = %d after firing, want 0", n)
+			}
+		})
+	}
+}
+
+func TestTimerStop(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	w := NewWheel(c, testTick, 4)
+
+	fired := false
+	timer := w.AfterFunc(30*time.Millisecond, func() { fired = true })
+	if !timer.Stop() {
+		t.Error("Stop() = false for a running timer")
+	}
+	step(c, w, 100*time.Millisecond)
+	if fired {
+		t.Error("stopped timer fired")
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true for a stopped timer")
+	}
+	if n := w.Len(); n != 0 {
+		t.Errorf("Len() = %d, want 0", n)
+	}
+}
+
+func TestTimerReset(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	w := NewWheel(c, testTick, 4)
+
+	fires := 0
+	timer := w.AfterFunc(50*time.Millisecond, func() { fires++ })
+	step(c, w, 40*time.Millisecond)
+	if !timer.Reset(50 * time.Millisecond) {
+		t.Error("Reset() = false for a running timer")
+	}
+
+	step(c, w, 49*time.Millisecond)
+	if fires != 0 {
+		t.Fatalf("reset timer fired after 49ms of 50ms")
+	}
+	step(c, w, testTick+time.Millisecond)
+	if fires != 1 {
+		t.Fatalf("reset timer fired %d times, want 1", fires)
+	}
+
+	if timer.Reset(testTick) {
+		t.Error("Reset() = true for a fired timer")
+	}
+	step(c, w, 2*testTick)
+	if fires != 2 {
+		t.Errorf("timer reset after firing fired %d times, want 2", fires)
+	}
+}
+
+func TestWheelManyTimers(t *testing.T) {
+	c := NewFakeClock(time.Unix(0, 0))
+	w := NewWheel(c, testTick, 16)
+
+	const n = 1000
+	start := c.Now()
+	early := 0
+	fired := 0
+	for i := 0; i < n; i++ {
+		d := time.Duration(i) * time.Millisecond
+		w.AfterFunc(d, func() {
+			fired++
+			if c.Now().Sub(start) < d {
+				early++
+			}
+		})
+	}
+	if got := w.Len(); got != n {
+		t.Fatalf("Len() = %d, want %d", got, n)
+	}
+
+	step(c, w, n*time.Millisecond+2*testTick)
+	if fired != n {
+		t.Errorf("%d timers fired, want %d", fired, n)
+	}
+	if early != 0 {
+		t.Errorf("%d timers fired early", early)
+	}
+}
@@ -0,0 +1,299 @@
+// Package gtpu implements the GTP-U protocol of TS 29.281 carrying the
+// user plane between the gNBs and the UPFs over N3, and between UPFs over
+// N9, with the PDU session container of TS 38.415.
+package gtpu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// Port is the GTP-U UDP port (TS 29.281 4.4.2)
+const Port = 2152
+
+// Version is the GTP version of GTP-U
+const Version = 1
+
+// MessageType identifies a GTP-U message (TS 29.281 6.1)
+type MessageType uint8
+
+const (
+	MsgEchoRequest     MessageType = 1
+	MsgEchoResponse    MessageType = 2
+	MsgErrorIndication MessageType = 26
+	MsgEndMarker       MessageType = 254
+	MsgGPDU            MessageType = 255
+)
+
+// String implements fmt.Stringer
+func (t MessageType) String() string {
+	switch t {
+	case MsgEchoRequest:
+		return "EchoRequest"
+	case MsgEchoResponse:
+		return "EchoResponse"
+	case MsgErrorIndication:
+		return "ErrorIndication"
+	case MsgEndMarker:
+		return "EndMarker"
+	case MsgGPDU:
+		return "G-PDU"
+	default:
+		return fmt.Sprintf("MessageType(%d)", uint8(t))
+	}
+}
+
+// Errors returned when decoding
+var (
+	// ErrTruncated is returned when a message ends in the middle of a
+	// field
+	ErrTruncated = errors.New("gtpu: message truncated")
+
+	// ErrUnsupportedVersion is returned for GTP' and for messages of
+	// another GTP version
+	ErrUnsupportedVersion = errors.New("gtpu: unsupported version")
+
+	// ErrUnsupportedExtension is returned for extension headers the
+	// receiver must comprehend but this package does not implement
+	ErrUnsupportedExtension = errors.New("gtpu: unsupported extension header")
+)
+
+// Header flags (TS 29.281 5.1)
+const (
+	flagPT = 0x10
+	flagE  = 0x04
+	flagS  = 0x02
+	flagPN = 0x01
+)
+
+// Extension header types (TS 29.281 5.2.1)
+const (
+	extNone                = 0x00
+	extPDUSessionContainer = 0x85
+)
+
+// Header is the header of a GTP-U message (TS 29.281 5.1)
+type Header struct {
+	Type MessageType
+
+	// TEID is the tunnel endpoint of the receiver, 0 for path messages
+	TEID uint32
+
+	// SequenceNumber is sent when HasSequenceNumber is set, as in echo
+	// requests and their responses
+	HasSequenceNumber bool
+	SequenceNumber    uint16
+
+	// PDUSession is the PDU session container extension header of N3 and
+	// N9 G-PDUs, nil when there is none
+	PDUSession *PDUSessionContainer
+}
+
+// PDUSessionContainer tells the QoS flow of a G-PDU (TS 38.415 5.5.2)
+type PDUSessionContainer struct {
+	// Uplink tells an UL PDU SESSION INFORMATION, sent by the access,
+	// rather than a DL one
+	Uplink bool
+
+	// QFI is the QoS flow of the packet
+	QFI uint8
+
+	// RQI asks the UE for reflective QoS, downlink only
+	RQI bool
+}
+
+// Encode encodes a message of the header with its payload: the T-PDU of
+// a G-PDU, the IEs of other messages
+func Encode(h Header, payload []byte) ([]byte, error) {
+	return Append(nil, h, payload)
+}
+
+// Append encodes a message like Encode, appending it to b
+func Append(b []byte, h Header, payload []byte) ([]byte, error) {
+	flags := uint8(Version<<5 | flagPT)
+	if h.HasSequenceNumber {
+		flags |= flagS
+	}
+	if h.PDUSession != nil {
+		flags |= flagE
+	}
+
+	start := len(b)
+	b = append(b, flags, uint8(h.Type), 0, 0)
+	b = binary.BigEndian.AppendUint32(b, h.TEID)
+	if flags&(flagE|flagS|flagPN) != 0 {
+		next := uint8(extNone)
+		if h.PDUSession != nil {
+			next = extPDUSessionContainer
+		}
+		b = binary.BigEndian.AppendUint16(b, h.SequenceNumber)
+		b = append(b, 0, next)
+	}
+	if c := h.PDUSession; c != nil {
+		b = append(b, 1)
+		if c.Uplink {
+			b = append(b, 1<<4, c.QFI&0x3f)
+		} else {
+			var rqi uint8
+			if c.RQI {
+				rqi = 0x40
+			}
+			b = append(b, 0, rqi|c.QFI&0x3f)
+		}
+		b = append(b, extNone)
+	}
+	b = append(b, payload...)
+
+	n := len(b) - start - 8
+	if n > 0xffff {
+		return nil, fmt.Errorf("gtpu: %s of %d octets too long", h.Type, n)
+	}
+	binary.BigEndian.PutUint16(b[start+2:], uint16(n))
+	return b, nil
+}
+
+// Decode decodes a message, returning its header and payload. The
+// payload shares the memory of b.
+func Decode(b []byte) (Header, []byte, error) {
+	var h Header
+	if len(b) < 8 {
+		return h, nil, ErrTruncated
+	}
+	flags := b[0]
+	if flags>>5 != Version || flags&flagPT == 0 {
+		return h, nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, flags>>5)
+	}
+	h.Type = MessageType(b[1])
+	n := 8 + int(binary.BigEndian.Uint16(b[2:]))
+	if n > len(b) {
+		return h, nil, ErrTruncated
+	}
+	h.TEID = binary.BigEndian.Uint32(b[4:])
+	b = b[8:n]
+
+	if flags&(flagE|flagS|flagPN) == 0 {
+		return h, b, nil
+	}
+	if len(b) < 4 {
+		return h, nil, ErrTruncated
+	}
+	h.HasSequenceNumber = flags&flagS != 0
+	if h.HasSequenceNumber {
+		h.SequenceNumber = binary.BigEndian.Uint16(b)
+	}
+	next := uint8(extNone)
+	if flags&flagE != 0 {
+		next = b[3]
+	}
+	b = b[4:]
+
+	// Each extension header is a length in units of 4 octets, its content
+	// and the type of the next one
+	for next != extNone {
+		if len(b) < 1 || b[0] == 0 || len(b) < 4*int(b[0]) {
+			return h, nil, ErrTruncated
+		}
+		ext := b[:4*int(b[0])]
+		switch {
+		case next == extPDUSessionContainer:
+			c := &PDUSessionContainer{Uplink: ext[1]>>4 == 1, QFI: ext[2] & 0x3f}
+			c.RQI = !c.Uplink && ext[2]&0x40 != 0
+			h.PDUSession = c
+		case next&0x80 != 0:
+			// The two high bits tell whether the receiver must comprehend
+			// the extension header
+			return h, nil, fmt.Errorf("%w 0x%02x", ErrUnsupportedExtension, next)
+		}
+		next = ext[len(ext)-1]
+		b = b[len(ext):]
+	}
+	return h, b, nil
+}
+
+// IE types (TS 29.281 8.1)
+const (
+	ieRecovery        = 14
+	ieTEIDDataI       = 16
+	ieGTPUPeerAddress = 133
+)
+
+// EchoRequest encodes an echo request checking that a peer is alive (TS
+// 29.281 7.2.1)
+func EchoRequest(seq uint16) ([]byte, error) {
+	return Encode(Header{Type: MsgEchoRequest, HasSequenceNumber: true, SequenceNumber: seq}, nil)
+}
+
+// EchoResponse encodes the response to an echo request, its Recovery IE
+// being 0 as GTP-U has no restart counter (TS 29.281 7.2.2)
+func EchoResponse(req Header) ([]byte, error) {
+	h := Header{Type: MsgEchoResponse, HasSequenceNumber: true, SequenceNumber: req.SequenceNumber}
+	return Encode(h, []byte{ieRecovery, 0})
+}
+
+// EndMarker encodes an end marker telling the receiver that no G-PDU
+// follows on a tunnel (TS 29.281 7.3.2)
+func EndMarker(teid uint32) ([]byte, error) {
+	return Encode(Header{Type: MsgEndMarker, TEID: teid}, nil)
+}
+
+// ErrorIndication encodes an error indication telling a peer that a
+// G-PDU it sent to a tunnel endpoint of addr was received for no
+// context (TS 29.281 7.3.1)
+func ErrorIndication(teid uint32, addr net.IP) ([]byte, error) {
+	ip := addr.To4()
+	if ip == nil {
+		ip = addr.To16()
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("gtpu: invalid peer address %v", addr)
+	}
+	ies := []byte{ieTEIDDataI}
+	ies = binary.BigEndian.AppendUint32(ies, teid)
+	ies = append(ies, ieGTPUPeerAddress)
+	ies = binary.BigEndian.AppendUint16(ies, uint16(len(ip)))
+	ies = append(ies, ip...)
+	return Encode(Header{Type: MsgErrorIndication, HasSequenceNumber: true}, ies)
+}
+
+// DecodeErrorIndication decodes the tunnel endpoint of an error
+// indication payload
+func DecodeErrorIndication(payload []byte) (teid uint32, addr net.IP, err error) {
+	var hasTEID bool
+	for b := payload; len(b) > 0; {
+		t := b[0]
+		switch {
+		case t == ieTEIDDataI:
+			if len(b) < 5 {
+				return 0, nil, ErrTruncated
+			}
+			teid, hasTEID = binary.BigEndian.Uint32(b[1:]), true
+			b = b[5:]
+		case t == ieRecovery:
+			if len(b) < 2 {
+				return 0, nil, ErrTruncated
+			}
+			b = b[2:]
+		case t&0x80 != 0:
+			// TLV IEs
+			if len(b) < 3 {
+				return 0, nil, ErrTruncated
+			}
+			n := 3 + int(binary.BigEndian.Uint16(b[1:]))
+			if len(b) < n {
+				return 0, nil, ErrTruncated
+			}
+			if t == ieGTPUPeerAddress && (n == 3+net.IPv4len || n == 3+net.IPv6len) {
+				addr = append(net.IP(nil), b[3:n]...)
+			}
+			b = b[n:]
+		default:
+			return 0, nil, fmt.Errorf("gtpu: unexpected IE %d in error indication", t)
+		}
+	}
+	if !hasTEID || addr == nil {
+		return 0, nil, fmt.Errorf("gtpu: error indication without TEID or peer address")
+	}
+	return teid, addr, nil
+}
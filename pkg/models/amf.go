@@ -0,0 +1,387 @@
+package models
+
+import "time"
+
+// N1MessageClass is the protocol of an N1 message (TS 29.518 6.1.6.3.4)
+type N1MessageClass string
+
+const (
+	N1MessageClass5GMM N1MessageClass = "5GMM"
+	N1MessageClassSM   N1MessageClass = "SM"
+	N1MessageClassLPP  N1MessageClass = "LPP"
+	N1MessageClassSMS  N1MessageClass = "SMS"
+	N1MessageClassUPDP N1MessageClass = "UPDP"
+)
+
+// N2InformationClass is the protocol of N2 information (TS 29.518
+// 6.1.6.3.5)
+type N2InformationClass string
+
+const (
+	N2InformationClassSM N2InformationClass = "SM"
+)
+
+// N1MessageContainer refers to an N1 message sent as a binary part
+type N1MessageContainer struct {
+	// Protocol of the message
+	N1MessageClass N1MessageClass `json:"n1MessageClass"`
+
+	// Binary part holding the message
+	N1MessageContent RefToBinaryData `json:"n1MessageContent"`
+}
+
+// N2InfoContainer holds N2 information for the gNB
+type N2InfoContainer struct {
+	// Protocol of the information
+	N2InformationClass N2InformationClass `json:"n2InformationClass"`
+
+	// Session management information
+	SmInfo *N2SmInformation `json:"smInfo,omitempty"`
+}
+
+// N2SmInformation is the N2 information of a PDU session
+type N2SmInformation struct {
+	// PDU session the information belongs to
+	PduSessionID uint8 `json:"pduSessionId"`
+
+	// NGAP transfer of the information
+	N2InfoContent *N2InfoContent `json:"n2InfoContent,omitempty"`
+
+	// Slice of the PDU session
+	SNssai *Snssai `json:"sNssai,omitempty"`
+}
+
+// N2InfoContent refers to an NGAP transfer sent as a binary part
+type N2InfoContent struct {
+	// NGAP IE of the transfer, e.g. "PDU_RES_SETUP_REQ"
+	NgapIeType N2SmInfoType `json:"ngapIeType,omitempty"`
+
+	// Binary part holding the transfer
+	NgapData RefToBinaryData `json:"ngapData"`
+}
+
+// N1N2MessageTransferReqData represents a request of an NF to send N1
+// and N2 messages to a UE (TS 29.518 6.1.6.2.2)
+type N1N2MessageTransferReqData struct {
+	// N1 message for the UE
+	N1MessageContainer *N1MessageContainer `json:"n1MessageContainer,omitempty"`
+
+	// N2 information for the gNB
+	N2InfoContainer *N2InfoContainer `json:"n2InfoContainer,omitempty"`
+
+	// Whether the messages are dropped rather than paging a UE in CM-IDLE
+	SkipInd bool `json:"skipInd,omitempty"`
+
+	// PDU session the messages belong to
+	PduSessionID uint8 `json:"pduSessionId,omitempty"`
+
+	// URI notified when the messages cannot be delivered
+	N1n2FailureTxfNotifURI string `json:"n1n2FailureTxfNotifURI,omitempty"`
+
+	// BinaryDataN1Message is the N1 message sent as the binary part
+	// referred to by N1MessageContainer
+	BinaryDataN1Message []byte `json:"-"`
+
+	// BinaryDataN2Information is the NGAP transfer sent as the binary
+	// part referred to by N2InfoContainer
+	BinaryDataN2Information []byte `json:"-"`
+}
+
+// N1N2MessageTransferCause is the outcome of an N1N2 message transfer
+// (TS 29.518 6.1.6.3.7)
+type N1N2MessageTransferCause string
+
+const (
+	N1N2AttemptingToReachUE   N1N2MessageTransferCause = "ATTEMPTING_TO_REACH_UE"
+	N1N2TransferInitiated     N1N2MessageTransferCause = "N1_N2_TRANSFER_INITIATED"
+	N1N2UENotResponding       N1N2MessageTransferCause = "UE_NOT_RESPONDING"
+	N1N2MsgNotTransferred     N1N2MessageTransferCause = "N1_MSG_NOT_TRANSFERRED"
+	N1N2UENotReachableForSess N1N2MessageTransferCause = "UE_NOT_REACHABLE_FOR_SESSION"
+)
+
+// N1N2MessageTransferRspData represents the answer to an N1N2 message
+// transfer (TS 29.518 6.1.6.2.3)
+type N1N2MessageTransferRspData struct {
+	Cause N1N2MessageTransferCause `json:"cause"`
+}
+
+// N1N2MsgTxfrFailureNotification tells the sender of N1N2 messages that
+// they were not delivered (TS 29.518 6.1.6.2.14)
+type N1N2MsgTxfrFailureNotification struct {
+	Cause N1N2MessageTransferCause `json:"cause"`
+
+	// URI of the transfer that failed
+	N1n2MsgDataURI string `json:"n1n2MsgDataUri"`
+}
+
+// TransferReason is why a new AMF asks for the context of a UE (TS
+// 29.518 6.1.6.3.6)
+type TransferReason string
+
+const (
+	TransferReasonInitReg            TransferReason = "INIT_REG"
+	TransferReasonMobiReg            TransferReason = "MOBI_REG"
+	TransferReasonMobiRegUEValidated TransferReason = "MOBI_REG_UE_VALIDATED"
+)
+
+// UeContextTransferReqData represents a request of a new AMF for the
+// context of a UE (TS 29.518 6.1.6.2.5)
+type UeContextTransferReqData struct {
+	Reason     TransferReason `json:"reason"`
+	AccessType AccessType     `json:"accessType"`
+
+	// PLMN of the new AMF
+	PlmnID *PlmnID `json:"plmnId,omitempty"`
+
+	// Registration Request received by the new AMF, checked against the
+	// security context of the UE
+	RegRequest *N1MessageContainer `json:"regRequest,omitempty"`
+
+	// BinaryDataN1Message is the Registration Request sent as the binary
+	// part referred to by RegRequest
+	BinaryDataN1Message []byte `json:"-"`
+}
+
+// UeContextTransferRspData represents the context of a UE given to a
+// new AMF (TS 29.518 6.1.6.2.6)
+type UeContextTransferRspData struct {
+	UeContext UeContext `json:"ueContext"`
+}
+
+// UeContext represents the context of a UE moved between AMFs (TS 29.518
+// 6.1.6.2.25)
+type UeContext struct {
+	Supi string `json:"supi,omitempty"`
+	Pei  string `json:"pei,omitempty"`
+
+	// Subscribed UE-AMBR
+	SubUeAmbr *Ambr `json:"subUeAmbr,omitempty"`
+
+	// Keys of the UE
+	SeafData *SeafData `json:"seafData,omitempty"`
+
+	// Mobility management context per access
+	MmContextList []MmContext `json:"mmContextList,omitempty"`
+
+	// PDU sessions of the UE
+	SessionContextList []PduSessionContext `json:"sessionContextList,omitempty"`
+}
+
+// SeafData represents the keys of a UE (TS 29.518 6.1.6.2.29)
+type SeafData struct {
+	NgKsi  NgKsi  `json:"ngKsi"`
+	KeyAmf KeyAmf `json:"keyAmf"`
+
+	// Next hop key as hexadecimal digits and its chaining count
+	Nh  string `json:"nh,omitempty"`
+	Ncc int    `json:"ncc,omitempty"`
+}
+
+// NgKsi represents a key set identifier (TS 29.518 6.1.6.2.30)
+type NgKsi struct {
+	// "NATIVE" or "MAPPED"
+	Tsc string `json:"tsc"`
+	Ksi int    `json:"ksi"`
+}
+
+// KeyAmf represents the AMF key (TS 29.518 6.1.6.2.31)
+type KeyAmf struct {
+	// "KAMF" or "KPRIMEAMF"
+	KeyType string `json:"keyType"`
+
+	// Key as hexadecimal digits
+	KeyVal string `json:"keyVal"`
+}
+
+// MmContext represents the mobility management context of a UE on an
+// access (TS 29.518 6.1.6.2.26)
+type MmContext struct {
+	AccessType      AccessType       `json:"accessType"`
+	NasSecurityMode *NasSecurityMode `json:"nasSecurityMode,omitempty"`
+
+	// NAS COUNTs of the security context
+	NasDownlinkCount int `json:"nasDownlinkCount,omitempty"`
+	NasUplinkCount   int `json:"nasUplinkCount,omitempty"`
+
+	// UE security capability IE value
+	UeSecurityCapability []byte `json:"ueSecurityCapability,omitempty"`
+
+	AllowedNssai []Snssai `json:"allowedNssai,omitempty"`
+}
+
+// NasSecurityMode represents the NAS algorithms of a UE, e.g. "NIA2"
+// and "NEA0" (TS 29.518 6.1.6.2.28)
+type NasSecurityMode struct {
+	IntegrityAlgorithm string `json:"integrityAlgorithm"`
+	CipheringAlgorithm string `json:"cipheringAlgorithm"`
+}
+
+// PduSessionContext represents a PDU session of a UE as known to the AMF
+// (TS 29.518 6.1.6.2.27)
+type PduSessionContext struct {
+	PduSessionID uint8      `json:"pduSessionId"`
+	SmContextRef string     `json:"smContextRef"`
+	SNssai       Snssai     `json:"sNssai"`
+	Dnn          string     `json:"dnn"`
+	AccessType   AccessType `json:"accessType"`
+}
+
+// UeContextTransferStatus is the outcome of a UE context transfer
+// reported by the new AMF (TS 29.518 6.1.6.3.8)
+type UeContextTransferStatus string
+
+const (
+	TransferStatusTransferred    UeContextTransferStatus = "TRANSFERRED"
+	TransferStatusNotTransferred UeContextTransferStatus = "NOT_TRANSFERRED"
+)
+
+// UeRegStatusUpdateReqData represents the outcome of a UE context
+// transfer (TS 29.518 6.1.6.2.32)
+type UeRegStatusUpdateReqData struct {
+	TransferStatus UeContextTransferStatus `json:"transferStatus"`
+}
+
+// UeRegStatusUpdateRspData represents the answer of the old AMF to the
+// outcome of a UE context transfer (TS 29.518 6.1.6.2.33)
+type UeRegStatusUpdateRspData struct {
+	RegStatusTransferComplete bool `json:"regStatusTransferComplete"`
+}
+
+// UeN1N2InfoSubscriptionCreateData represents a subscription to the N1
+// messages or N2 information of a UE (TS 29.518 6.1.6.2.7)
+type UeN1N2InfoSubscriptionCreateData struct {
+	N2InformationClass  N2InformationClass `json:"n2InformationClass,omitempty"`
+	N2NotifyCallbackURI string             `json:"n2NotifyCallbackUri,omitempty"`
+	N1MessageClass      N1MessageClass     `json:"n1MessageClass,omitempty"`
+	N1NotifyCallbackURI string             `json:"n1NotifyCallbackUri,omitempty"`
+	NfID                string             `json:"nfId,omitempty"`
+}
+
+// UeN1N2InfoSubscriptionCreatedData represents a created N1/N2
+// subscription (TS 29.518 6.1.6.2.8)
+type UeN1N2InfoSubscriptionCreatedData struct {
+	N1n2NotifySubscriptionID string `json:"n1n2NotifySubscriptionId"`
+}
+
+// N1MessageNotification carries an N1 message of a UE to a subscriber
+// (TS 29.518 6.1.6.2.10)
+type N1MessageNotification struct {
+	N1NotifySubscriptionID string             `json:"n1NotifySubscriptionId,omitempty"`
+	N1MessageContainer     N1MessageContainer `json:"n1MessageContainer"`
+
+	// BinaryDataN1Message is the N1 message sent as the binary part
+	// referred to by N1MessageContainer
+	BinaryDataN1Message []byte `json:"-"`
+}
+
+// AmfEventType is an event reported by Namf_EventExposure (TS 29.518
+// 6.2.6.3.3)
+type AmfEventType string
+
+const (
+	AmfEventLocationReport          AmfEventType = "LOCATION_REPORT"
+	AmfEventReachabilityReport      AmfEventType = "REACHABILITY_REPORT"
+	AmfEventRegistrationStateReport AmfEventType = "REGISTRATION_STATE_REPORT"
+)
+
+// AmfEvent is an event of a subscription (TS 29.518 6.2.6.2.3)
+type AmfEvent struct {
+	Type AmfEventType `json:"type"`
+
+	// Whether the current state is reported on subscription
+	ImmediateFlag bool `json:"immediateFlag,omitempty"`
+}
+
+// AmfEventSubscription represents a subscription to AMF events of a UE
+// or of any UE (TS 29.518 6.2.6.2.3)
+type AmfEventSubscription struct {
+	EventList           []AmfEvent `json:"eventList"`
+	EventNotifyURI      string     `json:"eventNotifyUri"`
+	NotifyCorrelationID string     `json:"notifyCorrelationId"`
+	NfID                string     `json:"nfId"`
+
+	// UE reported on, or AnyUE for all of them
+	Supi  string `json:"supi,omitempty"`
+	AnyUE bool   `json:"anyUE,omitempty"`
+}
+
+// AmfCreateEventSubscription represents a request to subscribe to AMF
+// events (TS 29.518 6.2.6.2.2)
+type AmfCreateEventSubscription struct {
+	Subscription AmfEventSubscription `json:"subscription"`
+}
+
+// AmfCreatedEventSubscription represents a created event subscription,
+// with the reports of the events asking for an immediate one (TS 29.518
+// 6.2.6.2.4)
+type AmfCreatedEventSubscription struct {
+	Subscription   AmfEventSubscription `json:"subscription"`
+	SubscriptionID string               `json:"subscriptionId"`
+	ReportList     []AmfEventReport     `json:"reportList,omitempty"`
+}
+
+// UeReachability is the reachability of a UE (TS 29.518 6.2.6.3.7)
+type UeReachability string
+
+const (
+	UeReachable   UeReachability = "REACHABLE"
+	UeUnreachable UeReachability = "UNREACHABLE"
+)
+
+// RmState is the registration state of a UE (TS 29.518 6.2.6.3.8)
+type RmState string
+
+const (
+	RmStateRegistered   RmState = "REGISTERED"
+	RmStateDeregistered RmState = "DEREGISTERED"
+)
+
+// RmInfo is the registration state of a UE on an access
+type RmInfo struct {
+	RmState    RmState    `json:"rmState"`
+	AccessType AccessType `json:"accessType"`
+}
+
+// AmfEventState tells whether a subscription is still active
+type AmfEventState struct {
+	Active bool `json:"active"`
+}
+
+// AmfEventReport is the report of an event (TS 29.518 6.2.6.2.5)
+type AmfEventReport struct {
+	Type      AmfEventType  `json:"type"`
+	State     AmfEventState `json:"state"`
+	TimeStamp time.Time     `json:"timeStamp"`
+	Supi      string        `json:"supi,omitempty"`
+
+	// Set according to the event type
+	Location     *UserLocation  `json:"location,omitempty"`
+	Reachability UeReachability `json:"reachability,omitempty"`
+	RmInfoList   []RmInfo       `json:"rmInfoList,omitempty"`
+}
+
+// UserLocation is the location of a UE (TS 29.571 5.4.4.7)
+type UserLocation struct {
+	NrLocation *NrLocation `json:"nrLocation,omitempty"`
+}
+
+// NrLocation is the location of a UE on NR (TS 29.571 5.4.4.9)
+type NrLocation struct {
+	Tai  Tai  `json:"tai"`
+	Ncgi Ncgi `json:"ncgi"`
+}
+
+// Ncgi is an NR cell global identity (TS 29.571 5.4.4.6)
+type Ncgi struct {
+	PlmnID PlmnID `json:"plmnId"`
+
+	// NR cell identity as 9 hexadecimal digits
+	NrCellID string `json:"nrCellId"`
+}
+
+// AmfEventNotification carries event reports to a subscriber (TS 29.518
+// 6.2.6.2.7)
+type AmfEventNotification struct {
+	NotifyCorrelationID string           `json:"notifyCorrelationId,omitempty"`
+	ReportList          []AmfEventReport `json:"reportList,omitempty"`
+}
@@ -0,0 +1,96 @@
+package models
+
+// AuthType represents the authentication method chosen by the home network
+type AuthType string
+
+const (
+	// AuthType5GAKA represents 5G AKA
+	AuthType5GAKA AuthType = "5G_AKA"
+
+	// AuthTypeEAPAKAPrime represents EAP-AKA'
+	AuthTypeEAPAKAPrime AuthType = "EAP_AKA_PRIME"
+)
+
+// AuthResult represents the outcome of an authentication
+type AuthResult string
+
+const (
+	// AuthResultSuccess means the UE was authenticated
+	AuthResultSuccess AuthResult = "AUTHENTICATION_SUCCESS"
+
+	// AuthResultFailure means the UE failed authentication
+	AuthResultFailure AuthResult = "AUTHENTICATION_FAILURE"
+)
+
+// AuthenticationInfo represents a request to authenticate a UE
+// (TS 29.509 6.1.6.2.2)
+type AuthenticationInfo struct {
+	// SUCI or SUPI of the UE
+	SupiOrSuci string `json:"supiOrSuci"`
+
+	// Serving network name, e.g. "5G:mnc093.mcc208.3gppnetwork.org"
+	ServingNetworkName string `json:"servingNetworkName"`
+
+	// Resynchronisation data sent after a synch failure
+	ResynchronizationInfo *ResynchronizationInfo `json:"resynchronizationInfo,omitempty"`
+}
+
+// ResynchronizationInfo represents the data returned by a UE on a synch
+// failure
+type ResynchronizationInfo struct {
+	// RAND of the failed challenge, hexadecimal
+	Rand string `json:"rand"`
+
+	// AUTS computed by the UE, hexadecimal
+	Auts string `json:"auts"`
+}
+
+// UEAuthenticationCtx represents an authentication challenge created by
+// the AUSF (TS 29.509 6.1.6.2.3)
+type UEAuthenticationCtx struct {
+	// Authentication method
+	AuthType AuthType `json:"authType"`
+
+	// Serving network authentication vector for 5G AKA
+	Var5gAuthData Av5gAka `json:"5gAuthData"`
+
+	// Links to the confirmation resource, keyed by "5g-aka"
+	Links map[string]Link `json:"_links"`
+}
+
+// Av5gAka represents a 5G AKA serving environment authentication vector
+type Av5gAka struct {
+	// Random challenge, hexadecimal
+	Rand string `json:"rand"`
+
+	// Authentication token, hexadecimal
+	Autn string `json:"autn"`
+
+	// Hash of the expected response, hexadecimal
+	HxresStar string `json:"hxresStar"`
+}
+
+// Link represents a hypermedia link
+type Link struct {
+	// Target URI
+	Href string `json:"href"`
+}
+
+// ConfirmationData represents the response of a UE to a 5G AKA challenge
+type ConfirmationData struct {
+	// RES* computed by the UE, hexadecimal
+	ResStar string `json:"resStar"`
+}
+
+// ConfirmationDataResponse represents the outcome of a 5G AKA
+// confirmation
+type ConfirmationDataResponse struct {
+	// Outcome of the authentication
+	AuthResult AuthResult `json:"authResult"`
+
+	// SUPI of the authenticated UE
+	Supi string `json:"supi,omitempty"`
+
+	// Anchor key for the serving network, hexadecimal
+	Kseaf string `json:"kseaf,omitempty"`
+}
@@ -0,0 +1,132 @@
+package models
+
+import "time"
+
+// NodeFunctionality is the kind of NF sending charging data (TS 32.291
+// 6.1.6.3.5)
+type NodeFunctionality string
+
+const (
+	NodeFunctionalitySMF NodeFunctionality = "SMF"
+)
+
+// TriggerType is why charging data were reported (TS 32.291 6.1.6.3.3)
+type TriggerType string
+
+const (
+	TriggerTypeVolumeLimit            TriggerType = "VOLUME_LIMIT"
+	TriggerTypeTimeLimit              TriggerType = "TIME_LIMIT"
+	TriggerTypeFinal                  TriggerType = "FINAL"
+	TriggerTypeManagementIntervention TriggerType = "MANAGEMENT_INTERVENTION"
+)
+
+// TriggerCategory is whether charging data are reported at once or
+// with the next report (TS 32.291 6.1.6.3.4)
+type TriggerCategory string
+
+const (
+	TriggerCategoryImmediate TriggerCategory = "IMMEDIATE_REPORT"
+	TriggerCategoryDeferred  TriggerCategory = "DEFERRED_REPORT"
+)
+
+// Trigger represents a charging trigger (TS 32.291 6.1.6.2.1.9)
+type Trigger struct {
+	TriggerType     TriggerType     `json:"triggerType"`
+	TriggerCategory TriggerCategory `json:"triggerCategory"`
+}
+
+// NFIdentification identifies the NF sending charging data (TS 32.291
+// 6.1.6.2.1.3)
+type NFIdentification struct {
+	// Name of the NF instance
+	NFName string `json:"nFName,omitempty"`
+
+	// Kind of NF
+	NodeFunctionality NodeFunctionality `json:"nodeFunctionality"`
+}
+
+// UsedUnitContainer represents the usage of a rating group since the
+// previous report (TS 32.291 6.1.6.2.1.7)
+type UsedUnitContainer struct {
+	// Why the usage was reported
+	Triggers         []Trigger `json:"triggers,omitempty"`
+	TriggerTimestamp time.Time `json:"triggerTimestamp,omitempty"`
+
+	// Seconds of usage
+	Time uint32 `json:"time,omitempty"`
+
+	// Octets of usage
+	TotalVolume    uint64 `json:"totalVolume,omitempty"`
+	UplinkVolume   uint64 `json:"uplinkVolume,omitempty"`
+	DownlinkVolume uint64 `json:"downlinkVolume,omitempty"`
+
+	// Order of the container among those of the charging session
+	LocalSequenceNumber uint32 `json:"localSequenceNumber"`
+}
+
+// MultipleUnitUsage represents the usage of a rating group (TS 32.291
+// 6.1.6.2.1.5)
+type MultipleUnitUsage struct {
+	RatingGroup       uint32              `json:"ratingGroup"`
+	UsedUnitContainer []UsedUnitContainer `json:"usedUnitContainer,omitempty"`
+}
+
+// NetworkSlicingInfo represents the slice of a PDU session (TS 32.291
+// 6.1.6.2.2.7)
+type NetworkSlicingInfo struct {
+	SNSSAI Snssai `json:"sNSSAI"`
+}
+
+// PDUAddress represents the addresses of a PDU session (TS 32.291
+// 6.1.6.2.2.8)
+type PDUAddress struct {
+	PduIPv4Address           string `json:"pduIPv4Address,omitempty"`
+	PduIPv6AddresswithPrefix string `json:"pduIPv6AddresswithPrefix,omitempty"`
+}
+
+// PDUSessionInformation represents a PDU session being charged (TS
+// 32.291 6.1.6.2.2.3)
+type PDUSessionInformation struct {
+	NetworkSlicingInfo *NetworkSlicingInfo `json:"networkSlicingInfo,omitempty"`
+	PduSessionID       uint8               `json:"pduSessionID"`
+	PduType            PduSessionType      `json:"pduType,omitempty"`
+	DnnID              string              `json:"dnnId"`
+	RatType            RatType             `json:"ratType,omitempty"`
+	ServingCNPlmnID    *PlmnID             `json:"servingCNPlmnId,omitempty"`
+	PduAddress         *PDUAddress         `json:"pduAddress,omitempty"`
+
+	// When the PDU session started, and stopped in the final request
+	StartTime time.Time  `json:"startTime"`
+	StopTime  *time.Time `json:"stopTime,omitempty"`
+}
+
+// PDUSessionChargingInformation represents the PDU session of charging
+// data (TS 32.291 6.1.6.2.2.1)
+type PDUSessionChargingInformation struct {
+	// Charging ID of the PDU session, given by the SMF
+	ChargingID uint32 `json:"chargingId"`
+
+	PduSessionInformation *PDUSessionInformation `json:"pduSessionInformation,omitempty"`
+}
+
+// ChargingDataRequest represents a request of Nchf_ConvergedCharging to
+// create, update or release charging data (TS 32.291 6.1.6.2.1.1)
+type ChargingDataRequest struct {
+	// SUPI of the UE
+	SubscriberIdentifier string `json:"subscriberIdentifier,omitempty"`
+
+	NfConsumerIdentification NFIdentification `json:"nfConsumerIdentification"`
+
+	// When the request was sent and its order among the requests of the
+	// charging data
+	InvocationTimeStamp      time.Time `json:"invocationTimeStamp"`
+	InvocationSequenceNumber uint32    `json:"invocationSequenceNumber"`
+
+	// Usage by rating group
+	MultipleUnitUsage []MultipleUnitUsage `json:"multipleUnitUsage,omitempty"`
+
+	// Why the request was sent
+	Triggers []Trigger `json:"triggers,omitempty"`
+
+	PDUSessionChargingInformation *PDUSessionChargingInformation `json:"pDUSessionChargingInformation,omitempty"`
+}
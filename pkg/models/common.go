@@ -0,0 +1,191 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// PlmnID represents a Public Land Mobile Network identifier
+type PlmnID struct {
+	// Mobile Country Code, 3 digits
+	Mcc string `json:"mcc"`
+
+	// Mobile Network Code, 2 or 3 digits
+	Mnc string `json:"mnc"`
+}
+
+// String returns the PLMN as MCC followed by MNC, e.g. "20893"
+func (p PlmnID) String() string {
+	return p.Mcc + p.Mnc
+}
+
+// Bytes returns the 3 octet BCD encoding used by NAS and NGAP
+// (TS 24.501 9.11.3.4, TS 38.413 9.3.3.5)
+func (p PlmnID) Bytes() ([3]byte, error) {
+	var b [3]byte
+	if len(p.Mcc) != 3 || (len(p.Mnc) != 2 && len(p.Mnc) != 3) {
+		return b, fmt.Errorf("invalid PLMN %s-%s", p.Mcc, p.Mnc)
+	}
+
+	// MCC digits, then MNC digits with a filler for 2 digit MNCs
+	digits := p.Mcc + p.Mnc
+	if len(p.Mnc) == 2 {
+		digits += "F"
+	}
+
+	var d [6]byte
+	for i := 0; i < len(digits); i++ {
+		switch c := digits[i]; {
+		case c == 'F':
+			d[i] = 0xf
+		case c >= '0' && c <= '9':
+			d[i] = c - '0'
+		default:
+			return b, fmt.Errorf("invalid PLMN digit %q", c)
+		}
+	}
+
+	b[0] = d[1]<<4 | d[0]
+	b[1] = d[5]<<4 | d[2]
+	b[2] = d[4]<<4 | d[3]
+	return b, nil
+}
+
+// PlmnIDFromBytes decodes the 3 octet BCD encoding of a PLMN
+func PlmnIDFromBytes(b []byte) (PlmnID, error) {
+	if len(b) != 3 {
+		return PlmnID{}, fmt.Errorf("invalid PLMN length %d", len(b))
+	}
+
+	mcc := []byte{'0' + b[0]&0xf, '0' + b[0]>>4, '0' + b[1]&0xf}
+	mnc := []byte{'0' + b[2]&0xf, '0' + b[2]>>4}
+	if b[1]>>4 != 0xf {
+		mnc = append(mnc, '0'+b[1]>>4)
+	}
+
+	for _, c := range append(mcc, mnc...) {
+		if c < '0' || c > '9' {
+			return PlmnID{}, fmt.Errorf("invalid PLMN %x", b)
+		}
+	}
+	return PlmnID{Mcc: string(mcc), Mnc: string(mnc)}, nil
+}
+
+// Snssai represents a Single Network Slice Selection Assistance Information
+type Snssai struct {
+	// Slice/Service Type
+	Sst int `json:"sst"`
+
+	// Slice Differentiator, 6 hexadecimal digits
+	Sd string `json:"sd,omitempty"`
+}
+
+// String returns the S-NSSAI as "sst" or "sst-sd"
+func (s Snssai) String() string {
+	if s.Sd == "" {
+		return fmt.Sprintf("%d", s.Sst)
+	}
+	return fmt.Sprintf("%d-%s", s.Sst, strings.ToLower(s.Sd))
+}
+
+// SdBytes returns the 3 octet Slice Differentiator, or nil when absent
+func (s Snssai) SdBytes() ([]byte, error) {
+	if s.Sd == "" {
+		return nil, nil
+	}
+	sd, err := hex.DecodeString(s.Sd)
+	if err != nil || len(sd) != 3 {
+		return nil, fmt.Errorf("invalid slice differentiator %q", s.Sd)
+	}
+	return sd, nil
+}
+
+// Tai represents a Tracking Area Identity
+type Tai struct {
+	// PLMN of the tracking area
+	PlmnID PlmnID `json:"plmnId"`
+
+	// Tracking Area Code, 6 hexadecimal digits
+	Tac string `json:"tac"`
+}
+
+// NewTai creates a TAI from a numeric tracking area code
+func NewTai(plmn PlmnID, tac uint32) Tai {
+	return Tai{PlmnID: plmn, Tac: fmt.Sprintf("%06x", tac&0xffffff)}
+}
+
+// TacValue returns the tracking area code as a number
+func (t Tai) TacValue() (uint32, error) {
+	var tac uint32
+	if _, err := fmt.Sscanf(t.Tac, "%x", &tac); err != nil || tac > 0xffffff {
+		return 0, fmt.Errorf("invalid tracking area code %q", t.Tac)
+	}
+	return tac, nil
+}
+
+// Guami represents a Globally Unique AMF Identifier
+type Guami struct {
+	// PLMN of the AMF
+	PlmnID PlmnID `json:"plmnId"`
+
+	// AMF identifier: region (8 bits), set (10 bits) and pointer (6 bits)
+	// as 6 hexadecimal digits
+	AmfID string `json:"amfId"`
+}
+
+// NewGuami creates a GUAMI from the AMF region, set and pointer
+func NewGuami(plmn PlmnID, regionID uint8, setID uint16, pointer uint8) Guami {
+	amfID := uint32(regionID)<<16 | uint32(setID&0x3ff)<<6 | uint32(pointer&0x3f)
+	return Guami{PlmnID: plmn, AmfID: fmt.Sprintf("%06x", amfID)}
+}
+
+// AmfIdentifiers returns the AMF region, set and pointer of the GUAMI
+func (g Guami) AmfIdentifiers() (regionID uint8, setID uint16, pointer uint8, err error) {
+	var amfID uint32
+	if _, err := fmt.Sscanf(g.AmfID, "%x", &amfID); err != nil || amfID > 0xffffff {
+		return 0, 0, 0, fmt.Errorf("invalid AMF identifier %q", g.AmfID)
+	}
+	return uint8(amfID >> 16), uint16(amfID>>6) & 0x3ff, uint8(amfID) & 0x3f, nil
+}
+
+// bitRateUnits are the multipliers of the BitRate units (TS 29.571
+// 5.5.2)
+var bitRateUnits = map[string]float64{
+	"bps":  1,
+	"Kbps": 1e3,
+	"Mbps": 1e6,
+	"Gbps": 1e9,
+	"Tbps": 1e12,
+}
+
+// ParseBitRate converts a BitRate string, e.g. "100 Mbps", to bits per
+// second
+func ParseBitRate(s string) (uint64, error) {
+	value, unit, ok := strings.Cut(strings.TrimSpace(s), " ")
+	multiplier, known := bitRateUnits[unit]
+	if !ok || !known {
+		return 0, fmt.Errorf("invalid bit rate %q", s)
+	}
+
+	var v float64
+	if _, err := fmt.Sscanf(value, "%g", &v); err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bit rate %q", s)
+	}
+	return uint64(v * multiplier), nil
+}
+
+// ProblemDetails describes why a request failed (TS 29.571 5.2.4.1)
+type ProblemDetails struct {
+	// Short description of the problem
+	Title string `json:"title,omitempty"`
+
+	// HTTP status code
+	Status int `json:"status,omitempty"`
+
+	// Explanation of the problem
+	Detail string `json:"detail,omitempty"`
+
+	// Application cause, e.g. "DNN_NOT_SUPPORTED"
+	Cause string `json:"cause,omitempty"`
+}
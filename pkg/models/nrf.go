@@ -85,6 +85,9 @@ type NfProfile struct {
 	// Locality of the NF instance (e.g., geographic location)
 	Locality string `json:"locality,omitempty"`
 	
+	// UPF specific information, set for UPFs
+	UpfInfo *UpfInfo `json:"upfInfo,omitempty"`
+	
 	// NF services exposed by the NF instance
 	NfServices []NfService `json:"nfServices,omitempty"`
 	
@@ -98,6 +101,49 @@ type NfProfile struct {
 	LastHeartbeatTime time.Time `json:"lastHeartbeatTime,omitempty"`
 }
 
+// UpfInfo represents the DNNs, slices and interfaces of a UPF (TS 29.510
+// 6.1.6.2.13)
+type UpfInfo struct {
+	// Slices and DNNs served by the UPF
+	SNssaiUpfInfoList []SnssaiUpfInfoItem `json:"sNssaiUpfInfoList"`
+	
+	// User plane interfaces of the UPF
+	InterfaceUpfInfoList []InterfaceUpfInfoItem `json:"interfaceUpfInfoList,omitempty"`
+}
+
+// SnssaiUpfInfoItem represents the DNNs a UPF serves in a slice
+type SnssaiUpfInfoItem struct {
+	SNssai         Snssai           `json:"sNssai"`
+	DnnUpfInfoList []DnnUpfInfoItem `json:"dnnUpfInfoList"`
+}
+
+// DnnUpfInfoItem represents a DNN served by a UPF
+type DnnUpfInfoItem struct {
+	Dnn string `json:"dnn"`
+}
+
+// UPInterfaceType represents the type of a user plane interface
+type UPInterfaceType string
+
+const (
+	// UPInterfaceN3 is the interface towards the gNBs
+	UPInterfaceN3 UPInterfaceType = "N3"
+	
+	// UPInterfaceN6 is the interface towards the data networks
+	UPInterfaceN6 UPInterfaceType = "N6"
+	
+	// UPInterfaceN9 is the interface towards other UPFs
+	UPInterfaceN9 UPInterfaceType = "N9"
+)
+
+// InterfaceUpfInfoItem represents a user plane interface of a UPF
+type InterfaceUpfInfoItem struct {
+	InterfaceType         UPInterfaceType `json:"interfaceType"`
+	Ipv4EndpointAddresses []string        `json:"ipv4EndpointAddresses,omitempty"`
+	EndpointFqdn          string          `json:"endpointFqdn,omitempty"`
+	NetworkInstance       string          `json:"networkInstance,omitempty"`
+}
+
 // NfService represents a service exposed by a Network Function
 type NfService struct {
 	// Service instance ID
@@ -0,0 +1,57 @@
+package models
+
+// SliceInfoForRegistration represents the slice information sent to the
+// NSSF during registration (TS 29.531 6.1.6.2.2)
+type SliceInfoForRegistration struct {
+	// Slices of the subscription
+	SubscribedNssai []SubscribedSnssai `json:"subscribedNssai,omitempty"`
+
+	// Slices requested by the UE
+	RequestedNssai []Snssai `json:"requestedNssai,omitempty"`
+}
+
+// SubscribedSnssai represents a subscribed slice
+type SubscribedSnssai struct {
+	// Subscribed slice
+	SubscribedSnssai Snssai `json:"subscribedSnssai"`
+
+	// Whether the slice is a default slice
+	DefaultIndication bool `json:"defaultIndication,omitempty"`
+}
+
+// AuthorizedNetworkSliceInfo represents the slices selected by the NSSF
+// (TS 29.531 6.1.6.2.3)
+type AuthorizedNetworkSliceInfo struct {
+	// Allowed slices by access type
+	AllowedNssaiList []AllowedNssai `json:"allowedNssaiList,omitempty"`
+
+	// Configured slices of the serving PLMN
+	ConfiguredNssai []ConfiguredSnssai `json:"configuredNssai,omitempty"`
+
+	// Requested slices rejected in the PLMN
+	RejectedNssaiInPlmn []Snssai `json:"rejectedNssaiInPlmn,omitempty"`
+
+	// Requested slices rejected in the tracking area
+	RejectedNssaiInTa []Snssai `json:"rejectedNssaiInTa,omitempty"`
+}
+
+// AllowedNssai represents the allowed slices of an access type
+type AllowedNssai struct {
+	// Allowed slices
+	AllowedSnssaiList []AllowedSnssai `json:"allowedSnssaiList"`
+
+	// Access type the slices are allowed on
+	AccessType AccessType `json:"accessType"`
+}
+
+// AllowedSnssai represents an allowed slice
+type AllowedSnssai struct {
+	// Allowed slice
+	AllowedSnssai Snssai `json:"allowedSnssai"`
+}
+
+// ConfiguredSnssai represents a configured slice
+type ConfiguredSnssai struct {
+	// Configured slice
+	ConfiguredSnssai Snssai `json:"configuredSnssai"`
+}
@@ -0,0 +1,240 @@
+package models
+
+// AccessType represents a 3GPP or non-3GPP access
+type AccessType string
+
+const (
+	// AccessType3GPP represents 3GPP access
+	AccessType3GPP AccessType = "3GPP_ACCESS"
+
+	// AccessTypeNon3GPP represents non-3GPP access
+	AccessTypeNon3GPP AccessType = "NON_3GPP_ACCESS"
+)
+
+// PolicyAssociationRequest represents a request to create an AM policy
+// association (TS 29.507 5.6.2.2)
+type PolicyAssociationRequest struct {
+	// URI notified of policy updates
+	NotificationURI string `json:"notificationUri"`
+
+	// SUPI of the UE
+	Supi string `json:"supi"`
+
+	// Permanent Equipment Identifier of the UE
+	Pei string `json:"pei,omitempty"`
+
+	// Access type of the UE
+	AccessType AccessType `json:"accessType"`
+
+	// Radio access technology of the UE
+	RatType RatType `json:"ratType"`
+
+	// Current tracking area of the UE
+	Tai *Tai `json:"tai,omitempty"`
+
+	// Serving PLMN
+	ServingPlmn PlmnID `json:"servingPlmn"`
+
+	// GUAMI of the AMF
+	Guami Guami `json:"guami"`
+
+	// Allowed slices of the UE
+	AllowedSnssais []Snssai `json:"allowedSnssais,omitempty"`
+}
+
+// PolicyAssociation represents an AM policy association
+// (TS 29.507 5.6.2.3)
+type PolicyAssociation struct {
+	// Request that created the association
+	Request *PolicyAssociationRequest `json:"request,omitempty"`
+
+	// Events reported to the PCF, e.g. "LOC_CH"
+	Triggers []string `json:"triggers,omitempty"`
+
+	// Service area restrictions
+	ServAreaRes *ServiceAreaRestriction `json:"servAreaRes,omitempty"`
+
+	// RAT/frequency selection priority
+	Rfsp int `json:"rfsp,omitempty"`
+
+	// Supported features
+	SuppFeat string `json:"suppFeat"`
+}
+
+// ServiceAreaRestriction represents the tracking areas a UE is allowed or
+// not allowed in
+type ServiceAreaRestriction struct {
+	// "ALLOWED_AREAS" or "NOT_ALLOWED_AREAS"
+	RestrictionType string `json:"restrictionType,omitempty"`
+
+	// Tracking area codes of the restriction
+	Areas []Area `json:"areas,omitempty"`
+}
+
+// Area represents a list of tracking area codes
+type Area struct {
+	// Tracking area codes, 6 hexadecimal digits
+	Tacs []string `json:"tacs,omitempty"`
+}
+
+// SmPolicyContextData represents a request to create an SM policy
+// association (TS 29.512 5.6.2.3)
+type SmPolicyContextData struct {
+	// SUPI of the UE
+	Supi string `json:"supi"`
+
+	// PDU session of the association
+	PduSessionID   uint8          `json:"pduSessionId"`
+	PduSessionType PduSessionType `json:"pduSessionType"`
+	Dnn            string         `json:"dnn"`
+	SliceInfo      Snssai         `json:"sliceInfo"`
+
+	// URI notified of policy updates
+	NotificationURI string `json:"notificationUri"`
+
+	// Access of the UE
+	AccessType AccessType `json:"accessType,omitempty"`
+	RatType    RatType    `json:"ratType,omitempty"`
+
+	// Serving PLMN
+	ServingNetwork *PlmnID `json:"servingNetwork,omitempty"`
+
+	// Addresses allocated to the UE
+	Ipv4Address       string `json:"ipv4Address,omitempty"`
+	Ipv6AddressPrefix string `json:"ipv6AddressPrefix,omitempty"`
+
+	// Subscribed session AMBR and default QoS
+	SubsSessAmbr *Ambr                 `json:"subsSessAmbr,omitempty"`
+	SubsDefQos   *SubscribedDefaultQos `json:"subsDefQos,omitempty"`
+}
+
+// AuthorizedDefaultQos represents the QoS authorized for the default QoS
+// flow (TS 29.512 5.6.2.34)
+type AuthorizedDefaultQos struct {
+	// 5G QoS identifier
+	Var5qi int `json:"5qi,omitempty"`
+
+	// Allocation and retention priority
+	Arp *Arp `json:"arp,omitempty"`
+
+	// Priority overriding the one of the 5QI when not 0
+	PriorityLevel int `json:"priorityLevel,omitempty"`
+}
+
+// SessionRule represents the session level policy of a PDU session (TS
+// 29.512 5.6.2.7)
+type SessionRule struct {
+	// Identifier of the rule
+	SessRuleID string `json:"sessRuleId"`
+
+	// Authorized session AMBR
+	AuthSessAmbr *Ambr `json:"authSessAmbr,omitempty"`
+
+	// Authorized default QoS
+	AuthDefQos *AuthorizedDefaultQos `json:"authDefQos,omitempty"`
+}
+
+// FlowDirection is the direction of a service data flow
+type FlowDirection string
+
+const (
+	FlowDirectionDownlink      FlowDirection = "DOWNLINK"
+	FlowDirectionUplink        FlowDirection = "UPLINK"
+	FlowDirectionBidirectional FlowDirection = "BIDIRECTIONAL"
+)
+
+// FlowInformation represents a packet filter of a service data flow (TS
+// 29.512 5.6.2.14)
+type FlowInformation struct {
+	// IPFilterRule of RFC 6733 in the downlink direction, e.g. "permit
+	// out 17 from 10.0.0.1 5060 to assigned"
+	FlowDescription string `json:"flowDescription,omitempty"`
+
+	// Direction of the filter, bidirectional when empty
+	FlowDirection FlowDirection `json:"flowDirection,omitempty"`
+}
+
+// PccRule represents the policy of a service data flow (TS 29.512
+// 5.6.2.6)
+type PccRule struct {
+	// Identifier of the rule
+	PccRuleID string `json:"pccRuleId"`
+
+	// Packet filters of the service data flow
+	FlowInfos []FlowInformation `json:"flowInfos,omitempty"`
+
+	// Precedence among the rules, the lowest first
+	Precedence int `json:"precedence,omitempty"`
+
+	// QoS decisions of the rule, by identifier
+	RefQosData []string `json:"refQosData,omitempty"`
+
+	// Charging decisions of the rule, by identifier
+	RefChgData []string `json:"refChgData,omitempty"`
+}
+
+// QosData represents the QoS of the service data flows referring to it
+// (TS 29.512 5.6.2.8)
+type QosData struct {
+	// Identifier of the decision
+	QosID string `json:"qosId"`
+
+	// 5G QoS identifier
+	Var5qi int `json:"5qi,omitempty"`
+
+	// Maximum and guaranteed bit rates of a GBR flow
+	MaxbrUl string `json:"maxbrUl,omitempty"`
+	MaxbrDl string `json:"maxbrDl,omitempty"`
+	GbrUl   string `json:"gbrUl,omitempty"`
+	GbrDl   string `json:"gbrDl,omitempty"`
+
+	// Allocation and retention priority
+	Arp *Arp `json:"arp,omitempty"`
+
+	// Priority overriding the one of the 5QI when not 0
+	PriorityLevel int `json:"priorityLevel,omitempty"`
+}
+
+// ChargingData represents how the service data flows referring to it are
+// charged (TS 29.512 5.6.2.11)
+type ChargingData struct {
+	// Identifier of the decision
+	ChgID string `json:"chgId"`
+
+	// Rating group the usage is reported under
+	RatingGroup uint32 `json:"ratingGroup,omitempty"`
+
+	// Whether online and offline charging apply
+	Online  bool `json:"online,omitempty"`
+	Offline bool `json:"offline,omitempty"`
+}
+
+// SmPolicyDecision represents the policy decided by the PCF for a PDU
+// session (TS 29.512 5.6.2.4). In an update, a nil rule or decision
+// removes the one of the same identifier.
+type SmPolicyDecision struct {
+	// Session rules by identifier
+	SessRules map[string]*SessionRule `json:"sessRules,omitempty"`
+
+	// PCC rules by identifier
+	PccRules map[string]*PccRule `json:"pccRules,omitempty"`
+
+	// QoS decisions by identifier
+	QosDecs map[string]*QosData `json:"qosDecs,omitempty"`
+
+	// Charging decisions by identifier
+	ChgDecs map[string]*ChargingData `json:"chgDecs,omitempty"`
+
+	// Events reported to the PCF, e.g. "PLMN_CH"
+	PolicyCtrlReqTriggers []string `json:"policyCtrlReqTriggers,omitempty"`
+}
+
+// SmPolicyNotification represents an update of the policy of a PDU
+// session sent by the PCF (TS 29.512 5.6.2.5)
+type SmPolicyNotification struct {
+	// SM policy association updated
+	ResourceURI string `json:"resourceUri,omitempty"`
+
+	// Changes of the policy
+	SmPolicyDecision *SmPolicyDecision `json:"smPolicyDecision,omitempty"`
+}
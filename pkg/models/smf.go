@@ -0,0 +1,226 @@
+package models
+
+// HoState is the handover state of a PDU session (TS 29.502 6.1.6.3.4)
+type HoState string
+
+const (
+	HoStateNone      HoState = "NONE"
+	HoStatePreparing HoState = "PREPARING"
+	HoStatePrepared  HoState = "PREPARED"
+	HoStateCompleted HoState = "COMPLETED"
+	HoStateCancelled HoState = "CANCELLED"
+)
+
+// UpCnxState is the state of the user plane connection of a PDU session
+// (TS 29.502 6.1.6.3.3)
+type UpCnxState string
+
+const (
+	UpCnxStateActivated   UpCnxState = "ACTIVATED"
+	UpCnxStateDeactivated UpCnxState = "DEACTIVATED"
+	UpCnxStateActivating  UpCnxState = "ACTIVATING"
+)
+
+// N2SmInfoType is the NGAP transfer carried as N2 SM information (TS
+// 29.502 6.1.6.3.7)
+type N2SmInfoType string
+
+const (
+	N2SmInfoPduResSetupReq       N2SmInfoType = "PDU_RES_SETUP_REQ"
+	N2SmInfoPduResSetupRsp       N2SmInfoType = "PDU_RES_SETUP_RSP"
+	N2SmInfoPduResSetupFail      N2SmInfoType = "PDU_RES_SETUP_FAIL"
+	N2SmInfoPduResModReq         N2SmInfoType = "PDU_RES_MOD_REQ"
+	N2SmInfoPduResModRsp         N2SmInfoType = "PDU_RES_MOD_RSP"
+	N2SmInfoPduResModFail        N2SmInfoType = "PDU_RES_MOD_FAIL"
+	N2SmInfoPathSwitchReq        N2SmInfoType = "PATH_SWITCH_REQ"
+	N2SmInfoPathSwitchSetupFail  N2SmInfoType = "PATH_SWITCH_SETUP_FAIL"
+	N2SmInfoPathSwitchReqAck     N2SmInfoType = "PATH_SWITCH_REQ_ACK"
+	N2SmInfoHandoverRequired     N2SmInfoType = "HANDOVER_REQUIRED"
+	N2SmInfoHandoverReqAck       N2SmInfoType = "HANDOVER_REQ_ACK"
+	N2SmInfoHandoverResAllocFail N2SmInfoType = "HANDOVER_RES_ALLOC_FAIL"
+	N2SmInfoHandoverCmd          N2SmInfoType = "HANDOVER_CMD"
+	N2SmInfoHandoverRequest      N2SmInfoType = "HANDOVER_REQUEST"
+)
+
+// RefToBinaryData refers to a binary part of a multipart message by its
+// Content-ID
+type RefToBinaryData struct {
+	ContentID string `json:"contentId"`
+}
+
+// NgRanTargetID identifies the target gNB of a handover
+type NgRanTargetID struct {
+	// RanNodeID of the target gNB
+	RanNodeID GlobalRanNodeID `json:"ranNodeId"`
+
+	// Tracking area selected in the target gNB
+	Tai Tai `json:"tai"`
+}
+
+// GlobalRanNodeID identifies a gNB
+type GlobalRanNodeID struct {
+	// PLMN of the gNB
+	PlmnID PlmnID `json:"plmnId"`
+
+	// gNB identifier
+	GNbID *GNbID `json:"gNbId,omitempty"`
+}
+
+// GNbID is a gNB identifier of 22 to 32 bits
+type GNbID struct {
+	// Number of bits of the identifier
+	BitLength int `json:"bitLength"`
+
+	// Identifier as hexadecimal digits
+	GNBValue string `json:"gNBValue"`
+}
+
+// SmContextUpdateData represents an update of an SM context sent by the
+// AMF (TS 29.502 6.1.6.2.3)
+type SmContextUpdateData struct {
+	// Access type and RAT of the UE, set when they change
+	AnType  AccessType `json:"anType,omitempty"`
+	RatType RatType    `json:"ratType,omitempty"`
+
+	// Current tracking area of the UE
+	Tai *Tai `json:"tai,omitempty"`
+
+	// User plane connection state requested by the AMF
+	UpCnxState UpCnxState `json:"upCnxState,omitempty"`
+
+	// Handover state requested by the AMF
+	HoState HoState `json:"hoState,omitempty"`
+
+	// Whether the user plane moves to the target of an Xn handover
+	ToBeSwitched bool `json:"toBeSwitched,omitempty"`
+
+	// Whether the target of an Xn handover failed to set up the session
+	FailedToBeSwitched bool `json:"failedToBeSwitched,omitempty"`
+
+	// Target of an N2 handover
+	TargetID *NgRanTargetID `json:"targetId,omitempty"`
+
+	// N2 SM information and the NGAP transfer it holds
+	N2SmInfo     *RefToBinaryData `json:"n2SmInfo,omitempty"`
+	N2SmInfoType N2SmInfoType     `json:"n2SmInfoType,omitempty"`
+
+	// BinaryDataN2SmInformation is the NGAP transfer sent as the binary
+	// part referred to by N2SmInfo
+	BinaryDataN2SmInformation []byte `json:"-"`
+}
+
+// SmContextReleaseData represents the release of an SM context asked by
+// the AMF (TS 29.502 6.1.6.2.5)
+type SmContextReleaseData struct {
+	// Why the PDU session is released, e.g. "REL_DUE_TO_REACTIVATION"
+	Cause string `json:"cause,omitempty"`
+}
+
+// SmContextUpdatedData represents the answer of the SMF to an SM context
+// update (TS 29.502 6.1.6.2.4)
+type SmContextUpdatedData struct {
+	// User plane connection state of the PDU session
+	UpCnxState UpCnxState `json:"upCnxState,omitempty"`
+
+	// Handover state of the PDU session
+	HoState HoState `json:"hoState,omitempty"`
+
+	// N2 SM information for the gNB and the NGAP transfer it holds
+	N2SmInfo     *RefToBinaryData `json:"n2SmInfo,omitempty"`
+	N2SmInfoType N2SmInfoType     `json:"n2SmInfoType,omitempty"`
+
+	// BinaryDataN2SmInformation is the NGAP transfer received as the
+	// binary part referred to by N2SmInfo
+	BinaryDataN2SmInformation []byte `json:"-"`
+}
+
+// RequestType is why a PDU session is requested (TS 29.502 6.1.6.3.6)
+type RequestType string
+
+const (
+	RequestTypeInitialRequest     RequestType = "INITIAL_REQUEST"
+	RequestTypeExistingPduSession RequestType = "EXISTING_PDU_SESSION"
+)
+
+// SmContextCreateData represents the creation of an SM context asked by
+// the AMF (TS 29.502 6.1.6.2.2)
+type SmContextCreateData struct {
+	// Identifiers of the UE
+	Supi string `json:"supi"`
+	Pei  string `json:"pei,omitempty"`
+
+	// PDU session requested by the UE
+	PduSessionID uint8       `json:"pduSessionId"`
+	Dnn          string      `json:"dnn"`
+	SNssai       Snssai      `json:"sNssai"`
+	RequestType  RequestType `json:"requestType,omitempty"`
+
+	// NF instance ID and GUAMI of the serving AMF
+	ServingNfID string `json:"servingNfId"`
+	Guami       *Guami `json:"guami,omitempty"`
+
+	// Serving PLMN
+	ServingNetwork PlmnID `json:"servingNetwork"`
+
+	// Access and location of the UE
+	AnType     AccessType    `json:"anType"`
+	RatType    RatType       `json:"ratType,omitempty"`
+	UeLocation *UserLocation `json:"ueLocation,omitempty"`
+
+	// URI notified when the SM context is released
+	SmContextStatusURI string `json:"smContextStatusUri"`
+
+	// N1 SM message of the UE
+	N1SmMsg *RefToBinaryData `json:"n1SmMsg,omitempty"`
+
+	// BinaryDataN1SmMessage is the 5GSM message sent as the binary part
+	// referred to by N1SmMsg
+	BinaryDataN1SmMessage []byte `json:"-"`
+}
+
+// SmContextCreatedData represents the answer of the SMF to an SM context
+// creation (TS 29.502 6.1.6.2.3)
+type SmContextCreatedData struct {
+	// PDU session of the SM context
+	PduSessionID uint8   `json:"pduSessionId,omitempty"`
+	SNssai       *Snssai `json:"sNssai,omitempty"`
+
+	// User plane connection state of the PDU session
+	UpCnxState UpCnxState `json:"upCnxState,omitempty"`
+}
+
+// SmContextCreateError represents the rejection of an SM context
+// creation (TS 29.502 6.1.6.2.6)
+type SmContextCreateError struct {
+	// Why the SM context was not created
+	Error ProblemDetails `json:"error"`
+
+	// N1 SM message for the UE
+	N1SmMsg *RefToBinaryData `json:"n1SmMsg,omitempty"`
+
+	// BinaryDataN1SmMessage is the 5GSM message sent as the binary part
+	// referred to by N1SmMsg
+	BinaryDataN1SmMessage []byte `json:"-"`
+}
+
+// ResourceStatus is the state of an SM context notified to the AMF (TS
+// 29.502 6.1.6.3.5)
+type ResourceStatus string
+
+const (
+	ResourceStatusReleased ResourceStatus = "RELEASED"
+)
+
+// StatusInfo is the state of an SM context and why it changed
+type StatusInfo struct {
+	ResourceStatus ResourceStatus `json:"resourceStatus"`
+
+	// Why the state changed, e.g. "REL_DUE_TO_DUPLICATE_SESSION_ID"
+	Cause string `json:"cause,omitempty"`
+}
+
+// SmContextStatusNotification tells the AMF that the state of an SM
+// context changed (TS 29.502 6.1.6.2.8)
+type SmContextStatusNotification struct {
+	StatusInfo StatusInfo `json:"statusInfo"`
+}
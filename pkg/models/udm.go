@@ -0,0 +1,203 @@
+package models
+
+// RatType represents a radio access technology
+type RatType string
+
+const (
+	// RatTypeNR represents New Radio
+	RatTypeNR RatType = "NR"
+)
+
+// Amf3GppAccessRegistration represents the registration of the serving
+// AMF of a UE with the UDM (TS 29.503 6.2.6.2.2)
+type Amf3GppAccessRegistration struct {
+	// NF instance ID of the AMF
+	AmfInstanceID string `json:"amfInstanceId"`
+
+	// URI notified when the UE is deregistered by the UDM
+	DeregCallbackURI string `json:"deregCallbackUri"`
+
+	// GUAMI of the AMF
+	Guami Guami `json:"guami"`
+
+	// Radio access technology of the UE
+	RatType RatType `json:"ratType"`
+
+	// Whether the registration is an initial registration
+	InitialRegistrationInd bool `json:"initialRegistrationInd,omitempty"`
+
+	// Permanent Equipment Identifier of the UE
+	Pei string `json:"pei,omitempty"`
+}
+
+// Amf3GppAccessRegistrationModification represents a change of the
+// registration of the serving AMF, such as its deregistration (TS 29.503
+// 6.2.6.2.7)
+type Amf3GppAccessRegistrationModification struct {
+	// GUAMI of the AMF
+	Guami Guami `json:"guami"`
+
+	// Whether the UDM removes the registration of the AMF
+	PurgeFlag bool `json:"purgeFlag,omitempty"`
+}
+
+// AccessAndMobilitySubscriptionData represents the access and mobility
+// subscription of a UE (TS 29.503 6.1.6.2.4)
+type AccessAndMobilitySubscriptionData struct {
+	// GPSIs of the UE, e.g. "msisdn-33600000001"
+	Gpsis []string `json:"gpsis,omitempty"`
+
+	// Subscribed UE aggregate maximum bit rate
+	SubscribedUeAmbr *Ambr `json:"subscribedUeAmbr,omitempty"`
+
+	// Subscribed network slices
+	Nssai *Nssai `json:"nssai,omitempty"`
+
+	// Radio access technologies the UE may not use
+	RatRestrictions []RatType `json:"ratRestrictions,omitempty"`
+
+	// Subscribed periodic registration timer in seconds
+	SubsRegTimer int `json:"subsRegTimer,omitempty"`
+
+	// Whether MICO mode is allowed
+	MicoAllowed bool `json:"micoAllowed,omitempty"`
+}
+
+// Ambr represents an aggregate maximum bit rate, e.g. "100 Mbps"
+type Ambr struct {
+	// Uplink bit rate
+	Uplink string `json:"uplink"`
+
+	// Downlink bit rate
+	Downlink string `json:"downlink"`
+}
+
+// Nssai represents the network slices subscribed by a UE
+type Nssai struct {
+	// Slices used when the UE requests none
+	DefaultSingleNssais []Snssai `json:"defaultSingleNssais"`
+
+	// Other subscribed slices
+	SingleNssais []Snssai `json:"singleNssais,omitempty"`
+}
+
+// SdmSubscription represents a subscription to changes of the
+// subscription data of a UE (TS 29.503 6.1.6.2.3)
+type SdmSubscription struct {
+	// NF instance ID of the subscriber
+	NfInstanceID string `json:"nfInstanceId"`
+
+	// URI notified of data changes
+	CallbackReference string `json:"callbackReference"`
+
+	// Subscribed resources, e.g. "/{supi}/am-data"
+	MonitoredResourceURIs []string `json:"monitoredResourceUris"`
+
+	// Identifier assigned by the UDM
+	SubscriptionID string `json:"subscriptionId,omitempty"`
+}
+
+// PduSessionType is the type of a PDU session (TS 29.571 5.4.3.3)
+type PduSessionType string
+
+const (
+	PduSessionTypeIPv4         PduSessionType = "IPV4"
+	PduSessionTypeIPv6         PduSessionType = "IPV6"
+	PduSessionTypeIPv4v6       PduSessionType = "IPV4V6"
+	PduSessionTypeUnstructured PduSessionType = "UNSTRUCTURED"
+	PduSessionTypeEthernet     PduSessionType = "ETHERNET"
+)
+
+// SscMode is a session and service continuity mode (TS 29.571 5.4.3.6)
+type SscMode string
+
+const (
+	SscMode1 SscMode = "SSC_MODE_1"
+	SscMode2 SscMode = "SSC_MODE_2"
+	SscMode3 SscMode = "SSC_MODE_3"
+)
+
+// PreemptionCapability tells whether a flow may pre-empt others
+type PreemptionCapability string
+
+const (
+	NotPreempt PreemptionCapability = "NOT_PREEMPT"
+	MayPreempt PreemptionCapability = "MAY_PREEMPT"
+)
+
+// PreemptionVulnerability tells whether a flow may be pre-empted
+type PreemptionVulnerability string
+
+const (
+	NotPreemptable PreemptionVulnerability = "NOT_PREEMPTABLE"
+	Preemptable    PreemptionVulnerability = "PREEMPTABLE"
+)
+
+// Arp represents an allocation and retention priority (TS 29.571
+// 5.5.4.1)
+type Arp struct {
+	// Priority from 1, the highest, to 15
+	PriorityLevel int `json:"priorityLevel"`
+
+	PreemptCap  PreemptionCapability    `json:"preemptCap"`
+	PreemptVuln PreemptionVulnerability `json:"preemptVuln"`
+}
+
+// SubscribedDefaultQos represents the QoS of the default QoS flow of a
+// subscribed DNN (TS 29.571 5.5.4.3)
+type SubscribedDefaultQos struct {
+	// 5G QoS identifier
+	Var5qi int `json:"5qi"`
+
+	// Allocation and retention priority
+	Arp Arp `json:"arp"`
+
+	// Priority overriding the one of the 5QI when not 0
+	PriorityLevel int `json:"priorityLevel,omitempty"`
+}
+
+// PduSessionTypes represents the PDU session types allowed for a DNN
+type PduSessionTypes struct {
+	DefaultSessionType  PduSessionType   `json:"defaultSessionType"`
+	AllowedSessionTypes []PduSessionType `json:"allowedSessionTypes,omitempty"`
+}
+
+// SscModes represents the SSC modes allowed for a DNN
+type SscModes struct {
+	DefaultSscMode  SscMode   `json:"defaultSscMode"`
+	AllowedSscModes []SscMode `json:"allowedSscModes,omitempty"`
+}
+
+// IPAddress represents an IPv4 address, an IPv6 address or an IPv6
+// prefix (TS 29.503 6.1.6.2.39)
+type IPAddress struct {
+	Ipv4Addr   string `json:"ipv4Addr,omitempty"`
+	Ipv6Addr   string `json:"ipv6Addr,omitempty"`
+	Ipv6Prefix string `json:"ipv6Prefix,omitempty"`
+}
+
+// DnnConfiguration represents the subscription of a UE to a DNN (TS
+// 29.503 6.1.6.2.9)
+type DnnConfiguration struct {
+	PduSessionTypes PduSessionTypes `json:"pduSessionTypes"`
+	SscModes        SscModes        `json:"sscModes"`
+
+	// QoS of the default QoS flow
+	FiveGQosProfile *SubscribedDefaultQos `json:"5gQosProfile,omitempty"`
+
+	// Subscribed session AMBR
+	SessionAmbr *Ambr `json:"sessionAmbr,omitempty"`
+
+	// Addresses always given to the UE on this DNN
+	StaticIPAddress []IPAddress `json:"staticIpAddress,omitempty"`
+}
+
+// SessionManagementSubscriptionData represents the session management
+// subscription of a UE in a slice (TS 29.503 6.1.6.2.8)
+type SessionManagementSubscriptionData struct {
+	// Slice of the subscription
+	SingleNssai Snssai `json:"singleNssai"`
+
+	// Subscribed DNNs
+	DnnConfigurations map[string]DnnConfiguration `json:"dnnConfigurations,omitempty"`
+}
@@ -0,0 +1,206 @@
+package nas
+
+import (
+	"fmt"
+)
+
+// Cause5GMM is a 5GMM cause (TS 24.501 9.11.3.2)
+type Cause5GMM uint8
+
+const (
+	Cause5GMMIllegalUE                           Cause5GMM = 3
+	Cause5GMMPEINotAccepted                      Cause5GMM = 5
+	Cause5GMMIllegalME                           Cause5GMM = 6
+	Cause5GMM5GSServicesNotAllowed               Cause5GMM = 7
+	Cause5GMMUEIdentityCannotBeDerived           Cause5GMM = 9
+	Cause5GMMImplicitlyDeregistered              Cause5GMM = 10
+	Cause5GMMPLMNNotAllowed                      Cause5GMM = 11
+	Cause5GMMTrackingAreaNotAllowed              Cause5GMM = 12
+	Cause5GMMRoamingNotAllowedInTrackingArea     Cause5GMM = 13
+	Cause5GMMNoSuitableCellsInTrackingArea       Cause5GMM = 15
+	Cause5GMMMACFailure                          Cause5GMM = 20
+	Cause5GMMSynchFailure                        Cause5GMM = 21
+	Cause5GMMCongestion                          Cause5GMM = 22
+	Cause5GMMUESecurityCapabilitiesMismatch      Cause5GMM = 23
+	Cause5GMMSecurityModeRejectedUnspecified     Cause5GMM = 24
+	Cause5GMMNon5GAuthenticationUnacceptable     Cause5GMM = 26
+	Cause5GMMN1ModeNotAllowed                    Cause5GMM = 27
+	Cause5GMMRestrictedServiceArea               Cause5GMM = 28
+	Cause5GMMLADNNotAvailable                    Cause5GMM = 43
+	Cause5GMMNoNetworkSlicesAvailable            Cause5GMM = 62
+	Cause5GMMMaximumPDUSessionsReached           Cause5GMM = 65
+	Cause5GMMInsufficientResourcesForSliceAndDNN Cause5GMM = 67
+	Cause5GMMInsufficientResourcesForSlice       Cause5GMM = 69
+	Cause5GMMNgKSIAlreadyInUse                   Cause5GMM = 71
+	Cause5GMMNon3GPPAccessNotAllowed             Cause5GMM = 72
+	Cause5GMMServingNetworkNotAuthorized         Cause5GMM = 73
+	Cause5GMMPayloadWasNotForwarded              Cause5GMM = 90
+	Cause5GMMDNNNotSupportedInSlice              Cause5GMM = 91
+	Cause5GMMInsufficientUserPlaneResources      Cause5GMM = 92
+	Cause5GMMSemanticallyIncorrectMessage        Cause5GMM = 95
+	Cause5GMMInvalidMandatoryInformation         Cause5GMM = 96
+	Cause5GMMMessageTypeNonExistent              Cause5GMM = 97
+	Cause5GMMMessageTypeNotCompatibleWithState   Cause5GMM = 98
+	Cause5GMMIENonExistent                       Cause5GMM = 99
+	Cause5GMMConditionalIEError                  Cause5GMM = 100
+	Cause5GMMMessageNotCompatibleWithState       Cause5GMM = 101
+	Cause5GMMProtocolErrorUnspecified            Cause5GMM = 111
+)
+
+// cause5GMMNames holds the names of the 5GMM causes
+var cause5GMMNames = map[Cause5GMM]string{
+	Cause5GMMIllegalUE:                           "illegal UE",
+	Cause5GMMPEINotAccepted:                      "PEI not accepted",
+	Cause5GMMIllegalME:                           "illegal ME",
+	Cause5GMM5GSServicesNotAllowed:               "5GS services not allowed",
+	Cause5GMMUEIdentityCannotBeDerived:           "UE identity cannot be derived by the network",
+	Cause5GMMImplicitlyDeregistered:              "implicitly de-registered",
+	Cause5GMMPLMNNotAllowed:                      "PLMN not allowed",
+	Cause5GMMTrackingAreaNotAllowed:              "tracking area not allowed",
+	Cause5GMMRoamingNotAllowedInTrackingArea:     "roaming not allowed in this tracking area",
+	Cause5GMMNoSuitableCellsInTrackingArea:       "no suitable cells in tracking area",
+	Cause5GMMMACFailure:                          "MAC failure",
+	Cause5GMMSynchFailure:                        "synch failure",
+	Cause5GMMCongestion:                          "congestion",
+	Cause5GMMUESecurityCapabilitiesMismatch:      "UE security capabilities mismatch",
+	Cause5GMMSecurityModeRejectedUnspecified:     "security mode rejected, unspecified",
+	Cause5GMMNon5GAuthenticationUnacceptable:     "non-5G authentication unacceptable",
+	Cause5GMMN1ModeNotAllowed:                    "N1 mode not allowed",
+	Cause5GMMRestrictedServiceArea:               "restricted service area",
+	Cause5GMMLADNNotAvailable:                    "LADN not available",
+	Cause5GMMNoNetworkSlicesAvailable:            "no network slices available",
+	Cause5GMMMaximumPDUSessionsReached:           "maximum number of PDU sessions reached",
+	Cause5GMMInsufficientResourcesForSliceAndDNN: "insufficient resources for specific slice and DNN",
+	Cause5GMMInsufficientResourcesForSlice:       "insufficient resources for specific slice",
+	Cause5GMMNgKSIAlreadyInUse:                   "ngKSI already in use",
+	Cause5GMMNon3GPPAccessNotAllowed:             "non-3GPP access to 5GCN not allowed",
+	Cause5GMMServingNetworkNotAuthorized:         "serving network not authorized",
+	Cause5GMMPayloadWasNotForwarded:              "payload was not forwarded",
+	Cause5GMMDNNNotSupportedInSlice:              "DNN not supported or not subscribed in the slice",
+	Cause5GMMInsufficientUserPlaneResources:      "insufficient user-plane resources for the PDU session",
+	Cause5GMMSemanticallyIncorrectMessage:        "semantically incorrect message",
+	Cause5GMMInvalidMandatoryInformation:         "invalid mandatory information",
+	Cause5GMMMessageTypeNonExistent:              "message type non-existent or not implemented",
+	Cause5GMMMessageTypeNotCompatibleWithState:   "message type not compatible with the protocol state",
+	Cause5GMMIENonExistent:                       "information element non-existent or not implemented",
+	Cause5GMMConditionalIEError:                  "conditional IE error",
+	Cause5GMMMessageNotCompatibleWithState:       "message not compatible with the protocol state",
+	Cause5GMMProtocolErrorUnspecified:            "protocol error, unspecified",
+}
+
+// String implements fmt.Stringer
+func (c Cause5GMM) String() string {
+	if name, ok := cause5GMMNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("5GMM cause %d", uint8(c))
+}
+
+// Cause5GSM is a 5GSM cause (TS 24.501 9.11.4.2)
+type Cause5GSM uint8
+
+const (
+	Cause5GSMOperatorDeterminedBarring             Cause5GSM = 8
+	Cause5GSMInsufficientResources                 Cause5GSM = 26
+	Cause5GSMMissingOrUnknownDNN                   Cause5GSM = 27
+	Cause5GSMUnknownPDUSessionType                 Cause5GSM = 28
+	Cause5GSMUserAuthenticationFailed              Cause5GSM = 29
+	Cause5GSMRequestRejectedUnspecified            Cause5GSM = 31
+	Cause5GSMServiceOptionNotSupported             Cause5GSM = 32
+	Cause5GSMRequestedServiceOptionNotSubscribed   Cause5GSM = 33
+	Cause5GSMPTIAlreadyInUse                       Cause5GSM = 35
+	Cause5GSMRegularDeactivation                   Cause5GSM = 36
+	Cause5GSMNetworkFailure                        Cause5GSM = 38
+	Cause5GSMReactivationRequested                 Cause5GSM = 39
+	Cause5GSMInvalidPDUSessionIdentity             Cause5GSM = 43
+	Cause5GSMOutOfLADNServiceArea                  Cause5GSM = 46
+	Cause5GSMPTIMismatch                           Cause5GSM = 47
+	Cause5GSMPDUSessionTypeIPv4OnlyAllowed         Cause5GSM = 50
+	Cause5GSMPDUSessionTypeIPv6OnlyAllowed         Cause5GSM = 51
+	Cause5GSMPDUSessionDoesNotExist                Cause5GSM = 54
+	Cause5GSMPDUSessionTypeIPv4v6OnlyAllowed       Cause5GSM = 57
+	Cause5GSMPDUSessionTypeUnstructuredOnlyAllowed Cause5GSM = 58
+	Cause5GSMUnsupported5QIValue                   Cause5GSM = 59
+	Cause5GSMPDUSessionTypeEthernetOnlyAllowed     Cause5GSM = 61
+	Cause5GSMInsufficientResourcesForSliceAndDNN   Cause5GSM = 67
+	Cause5GSMNotSupportedSSCMode                   Cause5GSM = 68
+	Cause5GSMInsufficientResourcesForSlice         Cause5GSM = 69
+	Cause5GSMMissingOrUnknownDNNInSlice            Cause5GSM = 70
+	Cause5GSMInvalidPTIValue                       Cause5GSM = 81
+	Cause5GSMSemanticErrorInQoSOperation           Cause5GSM = 83
+	Cause5GSMSyntacticalErrorInQoSOperation        Cause5GSM = 84
+	Cause5GSMSemanticallyIncorrectMessage          Cause5GSM = 95
+	Cause5GSMInvalidMandatoryInformation           Cause5GSM = 96
+	Cause5GSMMessageTypeNonExistent                Cause5GSM = 97
+	Cause5GSMMessageTypeNotCompatibleWithState     Cause5GSM = 98
+	Cause5GSMIENonExistent                         Cause5GSM = 99
+	Cause5GSMConditionalIEError                    Cause5GSM = 100
+	Cause5GSMMessageNotCompatibleWithState         Cause5GSM = 101
+	Cause5GSMProtocolErrorUnspecified              Cause5GSM = 111
+)
+
+// cause5GSMNames holds the names of the 5GSM causes
+var cause5GSMNames = map[Cause5GSM]string{
+	Cause5GSMOperatorDeterminedBarring:             "operator determined barring",
+	Cause5GSMInsufficientResources:                 "insufficient resources",
+	Cause5GSMMissingOrUnknownDNN:                   "missing or unknown DNN",
+	Cause5GSMUnknownPDUSessionType:                 "unknown PDU session type",
+	Cause5GSMUserAuthenticationFailed:              "user authentication or authorization failed",
+	Cause5GSMRequestRejectedUnspecified:            "request rejected, unspecified",
+	Cause5GSMServiceOptionNotSupported:             "service option not supported",
+	Cause5GSMRequestedServiceOptionNotSubscribed:   "requested service option not subscribed",
+	Cause5GSMPTIAlreadyInUse:                       "PTI already in use",
+	Cause5GSMRegularDeactivation:                   "regular deactivation",
+	Cause5GSMNetworkFailure:                        "network failure",
+	Cause5GSMReactivationRequested:                 "reactivation requested",
+	Cause5GSMInvalidPDUSessionIdentity:             "invalid PDU session identity",
+	Cause5GSMOutOfLADNServiceArea:                  "out of LADN service area",
+	Cause5GSMPTIMismatch:                           "PTI mismatch",
+	Cause5GSMPDUSessionTypeIPv4OnlyAllowed:         "PDU session type IPv4 only allowed",
+	Cause5GSMPDUSessionTypeIPv6OnlyAllowed:         "PDU session type IPv6 only allowed",
+	Cause5GSMPDUSessionDoesNotExist:                "PDU session does not exist",
+	Cause5GSMPDUSessionTypeIPv4v6OnlyAllowed:       "PDU session type IPv4v6 only allowed",
+	Cause5GSMPDUSessionTypeUnstructuredOnlyAllowed: "PDU session type Unstructured only allowed",
+	Cause5GSMUnsupported5QIValue:                   "unsupported 5QI value",
+	Cause5GSMPDUSessionTypeEthernetOnlyAllowed:     "PDU session type Ethernet only allowed",
+	Cause5GSMInsufficientResourcesForSliceAndDNN:   "insufficient resources for specific slice and DNN",
+	Cause5GSMNotSupportedSSCMode:                   "not supported SSC mode",
+	Cause5GSMInsufficientResourcesForSlice:         "insufficient resources for specific slice",
+	Cause5GSMMissingOrUnknownDNNInSlice:            "missing or unknown DNN in a slice",
+	Cause5GSMInvalidPTIValue:                       "invalid PTI value",
+	Cause5GSMSemanticErrorInQoSOperation:           "semantic error in the QoS operation",
+	Cause5GSMSyntacticalErrorInQoSOperation:        "syntactical error in the QoS operation",
+	Cause5GSMSemanticallyIncorrectMessage:          "semantically incorrect message",
+	Cause5GSMInvalidMandatoryInformation:           "invalid mandatory information",
+	Cause5GSMMessageTypeNonExistent:                "message type non-existent or not implemented",
+	Cause5GSMMessageTypeNotCompatibleWithState:     "message type not compatible with the protocol state",
+	Cause5GSMIENonExistent:                         "information element non-existent or not implemented",
+	Cause5GSMConditionalIEError:                    "conditional IE error",
+	Cause5GSMMessageNotCompatibleWithState:         "message not compatible with the protocol state",
+	Cause5GSMProtocolErrorUnspecified:              "protocol error, unspecified",
+}
+
+// String implements fmt.Stringer
+func (c Cause5GSM) String() string {
+	if name, ok := cause5GSMNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("5GSM cause %d", uint8(c))
+}
+
+// optCause returns the value of an optional cause IE
+func optCause[T ~uint8](c *T) []byte {
+	if c == nil {
+		return nil
+	}
+	return []byte{uint8(*c)}
+}
+
+// decodeOptCause decodes an optional cause IE
+func decodeOptCause[T ~uint8](b []byte) *T {
+	if len(b) < 1 {
+		return nil
+	}
+	c := T(b[0])
+	return &c
+}
@@ -0,0 +1,208 @@
+package nas
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// writer builds a NAS message, keeping the first encoding error
+type writer struct {
+	b   []byte
+	err error
+}
+
+// fail records an encoding error
+func (w *writer) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf(format, args...)
+	}
+}
+
+// must returns v, recording err as the encoding error
+func (w *writer) must(v []byte, err error) []byte {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return v
+}
+
+// uint8 writes an octet
+func (w *writer) uint8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+// uint16 writes two octets in network order
+func (w *writer) uint16(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+// bytes writes raw octets
+func (w *writer) bytes(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+// lv writes a value preceded by a one octet length
+func (w *writer) lv(v []byte) {
+	if len(v) > 0xff {
+		w.fail("value of %d octets too long for LV", len(v))
+		return
+	}
+	w.uint8(uint8(len(v)))
+	w.bytes(v)
+}
+
+// lve writes a value preceded by a two octet length
+func (w *writer) lve(v []byte) {
+	if len(v) > 0xffff {
+		w.fail("value of %d octets too long for LV-E", len(v))
+		return
+	}
+	w.uint16(uint16(len(v)))
+	w.bytes(v)
+}
+
+// tv writes a type 3 IE with a fixed length value
+func (w *writer) tv(iei uint8, v []byte) {
+	w.uint8(iei)
+	w.bytes(v)
+}
+
+// tlv writes a type 4 IE
+func (w *writer) tlv(iei uint8, v []byte) {
+	w.uint8(iei)
+	w.lv(v)
+}
+
+// tlve writes a type 6 IE
+func (w *writer) tlve(iei uint8, v []byte) {
+	w.uint8(iei)
+	w.lve(v)
+}
+
+// half writes a type 1 IE: a half octet IEI, given as 0xN0, and a half
+// octet value
+func (w *writer) half(iei, v uint8) {
+	w.uint8(iei&0xf0 | v&0x0f)
+}
+
+// optTLV writes a type 4 IE when v is not nil
+func (w *writer) optTLV(iei uint8, v []byte) {
+	if v != nil {
+		w.tlv(iei, v)
+	}
+}
+
+// optTLVE writes a type 6 IE when v is not nil
+func (w *writer) optTLVE(iei uint8, v []byte) {
+	if v != nil {
+		w.tlve(iei, v)
+	}
+}
+
+// optHalf writes a type 1 IE when v is not nil
+func (w *writer) optHalf(iei uint8, v *uint8) {
+	if v != nil {
+		w.half(iei, *v)
+	}
+}
+
+// reader walks a NAS message, keeping the first decoding error
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+// take returns the next n octets
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = ErrTruncated
+		return nil
+	}
+	v := r.b[r.off : r.off+n : r.off+n]
+	r.off += n
+	return v
+}
+
+// uint8 reads an octet
+func (r *reader) uint8() uint8 {
+	v := r.take(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+// uint16 reads two octets in network order
+func (r *reader) uint16() uint16 {
+	v := r.take(2)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(v)
+}
+
+// lv reads a value preceded by a one octet length
+func (r *reader) lv() []byte {
+	return r.take(int(r.uint8()))
+}
+
+// lve reads a value preceded by a two octet length
+func (r *reader) lve() []byte {
+	return r.take(int(r.uint16()))
+}
+
+// optionals reads the optional IEs ending a message. tv gives the value
+// length of the type 3 IEs of the message; other IEs are recognised by
+// their IEI: type 1 from 0x80, type 6 from 0x70 to 0x7f and type 4
+// otherwise (TS 24.007 11.2.4).
+func (r *reader) optionals(tv map[uint8]int) optionals {
+	opts := optionals{}
+	for r.err == nil && r.off < len(r.b) {
+		iei := r.uint8()
+		switch n, fixed := tv[iei]; {
+		case fixed:
+			opts[iei] = r.take(n)
+		case iei >= 0x80:
+			opts[iei&0xf0] = []byte{iei & 0x0f}
+		case iei&0xf0 == 0x70:
+			opts[iei] = r.lve()
+		default:
+			opts[iei] = r.lv()
+		}
+	}
+	return opts
+}
+
+// optionals holds the optional IEs of a message by IEI, type 1 IEs by
+// their half octet IEI
+type optionals map[uint8][]byte
+
+// bytes returns the value of an IE, nil when absent
+func (o optionals) bytes(iei uint8) []byte {
+	return o[iei]
+}
+
+// half returns the value of a type 1 IE, nil when absent
+func (o optionals) half(iei uint8) *uint8 {
+	v, ok := o[iei]
+	if !ok {
+		return nil
+	}
+	return &v[0]
+}
+
+// uint8 returns the one octet value of an IE, nil when absent
+func (o optionals) uint8(iei uint8) (*uint8, error) {
+	v, ok := o[iei]
+	if !ok {
+		return nil, nil
+	}
+	if len(v) != 1 {
+		return nil, fmt.Errorf("IE %#x: invalid length %d", iei, len(v))
+	}
+	return &v[0], nil
+}
@@ -0,0 +1,1351 @@
+package nas
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+// NgKSI is a NAS key set identifier (TS 24.501 9.11.3.32)
+type NgKSI struct {
+	// TSC is the type of security context, 1 for a mapped context
+	TSC uint8
+
+	// KSI is the key set identifier, NoKeyAvailable when there is none
+	KSI uint8
+}
+
+// NoKeyAvailable is the KSI sent when there is no security context
+const NoKeyAvailable = 7
+
+// value returns the half octet encoding of the ngKSI
+func (k NgKSI) value() uint8 {
+	return k.TSC&1<<3 | k.KSI&7
+}
+
+// ngKSIFrom decodes the half octet encoding of an ngKSI
+func ngKSIFrom(v uint8) NgKSI {
+	return NgKSI{TSC: v >> 3 & 1, KSI: v & 7}
+}
+
+// RegistrationType is the 5GS registration type (TS 24.501 9.11.3.7)
+type RegistrationType uint8
+
+const (
+	RegistrationTypeInitial          RegistrationType = 1
+	RegistrationTypeMobilityUpdating RegistrationType = 2
+	RegistrationTypePeriodicUpdating RegistrationType = 3
+	RegistrationTypeEmergency        RegistrationType = 4
+	RegistrationTypeSNPNOnboarding   RegistrationType = 5
+)
+
+// String implements fmt.Stringer
+func (t RegistrationType) String() string {
+	switch t {
+	case RegistrationTypeInitial:
+		return "initial"
+	case RegistrationTypeMobilityUpdating:
+		return "mobility-updating"
+	case RegistrationTypePeriodicUpdating:
+		return "periodic-updating"
+	case RegistrationTypeEmergency:
+		return "emergency"
+	case RegistrationTypeSNPNOnboarding:
+		return "snpn-onboarding"
+	default:
+		return fmt.Sprintf("RegistrationType(%d)", uint8(t))
+	}
+}
+
+// AccessType is a 3GPP or non-3GPP access
+type AccessType uint8
+
+const (
+	Access3GPP    AccessType = 1
+	AccessNon3GPP AccessType = 2
+	AccessBoth    AccessType = 3
+)
+
+// RegistrationResult is the 5GS registration result (TS 24.501 9.11.3.6)
+type RegistrationResult struct {
+	Access              AccessType
+	SMSAllowed          bool
+	NSSAAPerformed      bool
+	EmergencyRegistered bool
+}
+
+// encode returns the value of the registration result
+func (r RegistrationResult) encode() []byte {
+	v := uint8(r.Access) & 7
+	if r.SMSAllowed {
+		v |= 1 << 3
+	}
+	if r.NSSAAPerformed {
+		v |= 1 << 4
+	}
+	if r.EmergencyRegistered {
+		v |= 1 << 5
+	}
+	return []byte{v}
+}
+
+// decodeRegistrationResult decodes a registration result
+func decodeRegistrationResult(b []byte) (RegistrationResult, error) {
+	if len(b) < 1 {
+		return RegistrationResult{}, ErrTruncated
+	}
+	return RegistrationResult{
+		Access:              AccessType(b[0] & 7),
+		SMSAllowed:          b[0]&(1<<3) != 0,
+		NSSAAPerformed:      b[0]&(1<<4) != 0,
+		EmergencyRegistered: b[0]&(1<<5) != 0,
+	}, nil
+}
+
+// DeregistrationType is the de-registration type (TS 24.501 9.11.3.20)
+type DeregistrationType struct {
+	SwitchOff              bool
+	ReregistrationRequired bool
+	Access                 AccessType
+}
+
+// value returns the half octet encoding of the de-registration type
+func (t DeregistrationType) value() uint8 {
+	v := uint8(t.Access) & 3
+	if t.ReregistrationRequired {
+		v |= 1 << 2
+	}
+	if t.SwitchOff {
+		v |= 1 << 3
+	}
+	return v
+}
+
+// deregistrationTypeFrom decodes a de-registration type
+func deregistrationTypeFrom(v uint8) DeregistrationType {
+	return DeregistrationType{
+		SwitchOff:              v&(1<<3) != 0,
+		ReregistrationRequired: v&(1<<2) != 0,
+		Access:                 AccessType(v & 3),
+	}
+}
+
+// ServiceType is the service type of a Service Request (TS 24.501 9.11.3.50)
+type ServiceType uint8
+
+const (
+	ServiceTypeSignalling         ServiceType = 0
+	ServiceTypeData               ServiceType = 1
+	ServiceTypeMobileTerminated   ServiceType = 2
+	ServiceTypeEmergency          ServiceType = 3
+	ServiceTypeEmergencyFallback  ServiceType = 4
+	ServiceTypeHighPriorityAccess ServiceType = 5
+	ServiceTypeElevatedSignalling ServiceType = 6
+)
+
+// IdentityType is the type of a 5GS mobile identity (TS 24.501 9.11.3.4)
+type IdentityType uint8
+
+const (
+	IdentityNone   IdentityType = 0
+	IdentitySUCI   IdentityType = 1
+	IdentityGUTI   IdentityType = 2
+	IdentityIMEI   IdentityType = 3
+	IdentitySTMSI  IdentityType = 4
+	IdentityIMEISV IdentityType = 5
+)
+
+// String implements fmt.Stringer
+func (t IdentityType) String() string {
+	switch t {
+	case IdentityNone:
+		return "none"
+	case IdentitySUCI:
+		return "suci"
+	case IdentityGUTI:
+		return "5g-guti"
+	case IdentityIMEI:
+		return "imei"
+	case IdentitySTMSI:
+		return "5g-s-tmsi"
+	case IdentityIMEISV:
+		return "imeisv"
+	default:
+		return fmt.Sprintf("IdentityType(%d)", uint8(t))
+	}
+}
+
+// SUPI formats of a SUCI
+const (
+	SUPIFormatIMSI = 0
+	SUPIFormatNAI  = 1
+)
+
+// ProtectionSchemeNull is the SUCI protection scheme leaving the MSIN
+// in clear
+const ProtectionSchemeNull = 0
+
+// SUCI is a Subscription Concealed Identifier (TS 23.003 2.2B)
+type SUCI struct {
+	SUPIFormat uint8
+
+	// For the IMSI format
+	PlmnID                 models.PlmnID
+	RoutingIndicator       string
+	ProtectionScheme       uint8
+	HomeNetworkPublicKeyID uint8
+	SchemeOutput           []byte
+
+	// For the NAI format
+	NAI string
+}
+
+// MSIN returns the MSIN of a null scheme SUCI
+func (s SUCI) MSIN() (string, bool) {
+	if s.SUPIFormat != SUPIFormatIMSI || s.ProtectionScheme != ProtectionSchemeNull {
+		return "", false
+	}
+	return decodeBCD(s.SchemeOutput), true
+}
+
+// SUPI returns the SUPI of a null scheme SUCI. Concealed SUCIs have to be
+// resolved by the UDM.
+func (s SUCI) SUPI() (string, bool) {
+	if s.SUPIFormat == SUPIFormatNAI {
+		return "nai-" + s.NAI, true
+	}
+	msin, ok := s.MSIN()
+	if !ok {
+		return "", false
+	}
+	return "imsi-" + s.PlmnID.String() + msin, true
+}
+
+// String returns the SUCI as
+// suci-<format>-<mcc>-<mnc>-<routing indicator>-<scheme>-<key id>-<output>
+func (s SUCI) String() string {
+	if s.SUPIFormat == SUPIFormatNAI {
+		return "suci-1-" + s.NAI
+	}
+
+	output, ok := s.MSIN()
+	if !ok {
+		output = fmt.Sprintf("%x", s.SchemeOutput)
+	}
+	return fmt.Sprintf("suci-0-%s-%s-%s-%d-%d-%s", s.PlmnID.Mcc, s.PlmnID.Mnc,
+		s.RoutingIndicator, s.ProtectionScheme, s.HomeNetworkPublicKeyID, output)
+}
+
+// GUTI is a 5G Globally Unique Temporary Identifier (TS 23.003 2.10)
+type GUTI struct {
+	PlmnID      models.PlmnID
+	AMFRegionID uint8
+	AMFSetID    uint16 // 10 bits
+	AMFPointer  uint8  // 6 bits
+	TMSI        uint32
+}
+
+// String returns the GUTI as PLMN, AMF identifier and 5G-TMSI, e.g.
+// "20893-010040-00000001"
+func (g GUTI) String() string {
+	return fmt.Sprintf("%s-%s-%08x", g.PlmnID, g.Guami().AmfID, g.TMSI)
+}
+
+// Guami returns the GUAMI part of the GUTI
+func (g GUTI) Guami() models.Guami {
+	return models.NewGuami(g.PlmnID, g.AMFRegionID, g.AMFSetID, g.AMFPointer)
+}
+
+// STMSI returns the 5G-S-TMSI of the GUTI
+func (g GUTI) STMSI() STMSI {
+	return STMSI{AMFSetID: g.AMFSetID, AMFPointer: g.AMFPointer, TMSI: g.TMSI}
+}
+
+// STMSI is a 5G S-Temporary Mobile Subscription Identifier
+type STMSI struct {
+	AMFSetID   uint16 // 10 bits
+	AMFPointer uint8  // 6 bits
+	TMSI       uint32
+}
+
+// MobileIdentity is a 5GS mobile identity (TS 24.501 9.11.3.4). Type
+// selects the field holding the identity.
+type MobileIdentity struct {
+	Type  IdentityType
+	SUCI  *SUCI
+	GUTI  *GUTI
+	STMSI *STMSI
+
+	// IMEI or IMEISV digits
+	PEI string
+}
+
+// String returns a printable form of the identity
+func (m MobileIdentity) String() string {
+	switch {
+	case m.Type == IdentitySUCI && m.SUCI != nil:
+		return m.SUCI.String()
+	case m.Type == IdentityGUTI && m.GUTI != nil:
+		return "5g-guti-" + m.GUTI.String()
+	case m.Type == IdentitySTMSI && m.STMSI != nil:
+		return fmt.Sprintf("5g-s-tmsi-%03x%02x%08x", m.STMSI.AMFSetID, m.STMSI.AMFPointer, m.STMSI.TMSI)
+	case m.Type == IdentityIMEI:
+		return "imei-" + m.PEI
+	case m.Type == IdentityIMEISV:
+		return "imeisv-" + m.PEI
+	default:
+		return m.Type.String()
+	}
+}
+
+// encode returns the value of the mobile identity
+func (m MobileIdentity) encode() ([]byte, error) {
+	switch m.Type {
+	case IdentityNone:
+		return []byte{uint8(IdentityNone)}, nil
+	case IdentitySUCI:
+		if m.SUCI == nil {
+			return nil, fmt.Errorf("missing SUCI")
+		}
+		return m.SUCI.encode()
+	case IdentityGUTI:
+		if m.GUTI == nil {
+			return nil, fmt.Errorf("missing 5G-GUTI")
+		}
+		plmn, err := m.GUTI.PlmnID.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		b := []byte{0xf0 | uint8(IdentityGUTI)}
+		b = append(b, plmn[:]...)
+		b = append(b, m.GUTI.AMFRegionID)
+		return append(b, encodeSTMSI(m.GUTI.STMSI())...), nil
+	case IdentitySTMSI:
+		if m.STMSI == nil {
+			return nil, fmt.Errorf("missing 5G-S-TMSI")
+		}
+		return append([]byte{0xf0 | uint8(IdentitySTMSI)}, encodeSTMSI(*m.STMSI)...), nil
+	case IdentityIMEI, IdentityIMEISV:
+		return encodePEI(m.Type, m.PEI)
+	default:
+		return nil, fmt.Errorf("unsupported identity type %d", m.Type)
+	}
+}
+
+// encode returns the value of a SUCI mobile identity
+func (s SUCI) encode() ([]byte, error) {
+	if s.SUPIFormat == SUPIFormatNAI {
+		return append([]byte{SUPIFormatNAI<<4 | uint8(IdentitySUCI)}, s.NAI...), nil
+	}
+
+	plmn, err := s.PlmnID.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(s.RoutingIndicator) == 0 || len(s.RoutingIndicator) > 4 {
+		return nil, fmt.Errorf("invalid routing indicator %q", s.RoutingIndicator)
+	}
+	routing, err := encodeBCD(s.RoutingIndicator + strings.Repeat("F", 4-len(s.RoutingIndicator)))
+	if err != nil {
+		return nil, err
+	}
+
+	b := []byte{SUPIFormatIMSI<<4 | uint8(IdentitySUCI)}
+	b = append(b, plmn[:]...)
+	b = append(b, routing...)
+	b = append(b, s.ProtectionScheme&0x0f, s.HomeNetworkPublicKeyID)
+	return append(b, s.SchemeOutput...), nil
+}
+
+// encodeSTMSI returns the AMF set, AMF pointer and 5G-TMSI octets
+func encodeSTMSI(s STMSI) []byte {
+	setPointer := s.AMFSetID&0x3ff<<6 | uint16(s.AMFPointer&0x3f)
+	return []byte{
+		uint8(setPointer >> 8), uint8(setPointer),
+		uint8(s.TMSI >> 24), uint8(s.TMSI >> 16), uint8(s.TMSI >> 8), uint8(s.TMSI),
+	}
+}
+
+// decodeSTMSI decodes the AMF set, AMF pointer and 5G-TMSI octets
+func decodeSTMSI(b []byte) STMSI {
+	setPointer := uint16(b[0])<<8 | uint16(b[1])
+	return STMSI{
+		AMFSetID:   setPointer >> 6,
+		AMFPointer: uint8(setPointer & 0x3f),
+		TMSI:       uint32(b[2])<<24 | uint32(b[3])<<16 | uint32(b[4])<<8 | uint32(b[5]),
+	}
+}
+
+// encodePEI returns the value of an IMEI or IMEISV mobile identity: the
+// first digit shares the octet of the type, the others follow in pairs
+func encodePEI(t IdentityType, digits string) ([]byte, error) {
+	if len(digits) == 0 {
+		return nil, fmt.Errorf("missing %s", t)
+	}
+	odd := uint8(len(digits) % 2)
+	rest := digits[1:]
+	if odd == 0 {
+		rest += "F"
+	}
+	tail, err := encodeBCD(rest)
+	if err != nil {
+		return nil, err
+	}
+	first, err := encodeBCD(digits[:1] + "0")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{first[0]<<4 | odd<<3 | uint8(t)}, tail...), nil
+}
+
+// decodeMobileIdentity decodes the value of a mobile identity
+func decodeMobileIdentity(b []byte) (MobileIdentity, error) {
+	if len(b) < 1 {
+		return MobileIdentity{}, ErrTruncated
+	}
+
+	m := MobileIdentity{Type: IdentityType(b[0] & 7)}
+	switch m.Type {
+	case IdentityNone:
+	case IdentitySUCI:
+		suci, err := decodeSUCI(b)
+		if err != nil {
+			return m, err
+		}
+		m.SUCI = &suci
+	case IdentityGUTI:
+		if len(b) != 11 {
+			return m, fmt.Errorf("invalid 5G-GUTI length %d", len(b))
+		}
+		plmn, err := models.PlmnIDFromBytes(b[1:4])
+		if err != nil {
+			return m, err
+		}
+		s := decodeSTMSI(b[5:11])
+		m.GUTI = &GUTI{PlmnID: plmn, AMFRegionID: b[4], AMFSetID: s.AMFSetID, AMFPointer: s.AMFPointer, TMSI: s.TMSI}
+	case IdentitySTMSI:
+		if len(b) != 7 {
+			return m, fmt.Errorf("invalid 5G-S-TMSI length %d", len(b))
+		}
+		s := decodeSTMSI(b[1:7])
+		m.STMSI = &s
+	case IdentityIMEI, IdentityIMEISV:
+		m.PEI = string([]byte{'0' + b[0]>>4}) + decodeBCD(b[1:])
+	default:
+		return m, fmt.Errorf("unsupported identity type %d", m.Type)
+	}
+	return m, nil
+}
+
+// decodeSUCI decodes the value of a SUCI mobile identity
+func decodeSUCI(b []byte) (SUCI, error) {
+	s := SUCI{SUPIFormat: b[0] >> 4 & 7}
+	if s.SUPIFormat == SUPIFormatNAI {
+		s.NAI = string(b[1:])
+		return s, nil
+	}
+	if s.SUPIFormat != SUPIFormatIMSI {
+		return s, fmt.Errorf("unsupported SUPI format %d", s.SUPIFormat)
+	}
+	if len(b) < 8 {
+		return s, ErrTruncated
+	}
+
+	plmn, err := models.PlmnIDFromBytes(b[1:4])
+	if err != nil {
+		return s, err
+	}
+	s.PlmnID = plmn
+	s.RoutingIndicator = decodeBCD(b[4:6])
+	s.ProtectionScheme = b[6] & 0x0f
+	s.HomeNetworkPublicKeyID = b[7]
+	s.SchemeOutput = b[8:]
+	return s, nil
+}
+
+// encodeBCD packs decimal digits two per octet, low nibble first. 'F'
+// encodes a filler.
+func encodeBCD(digits string) ([]byte, error) {
+	if len(digits)%2 != 0 {
+		digits += "F"
+	}
+
+	b := make([]byte, len(digits)/2)
+	for i := 0; i < len(digits); i++ {
+		var d uint8
+		switch c := digits[i]; {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c == 'F' || c == 'f':
+			d = 0xf
+		default:
+			return nil, fmt.Errorf("invalid digit %q", c)
+		}
+		b[i/2] |= d << (4 * uint(i%2))
+	}
+	return b, nil
+}
+
+// decodeBCD unpacks decimal digits, stopping at the first filler
+func decodeBCD(b []byte) string {
+	digits := make([]byte, 0, 2*len(b))
+	for _, o := range b {
+		for _, d := range []uint8{o & 0x0f, o >> 4} {
+			if d > 9 {
+				return string(digits)
+			}
+			digits = append(digits, '0'+d)
+		}
+	}
+	return string(digits)
+}
+
+// UESecurityCapability lists the algorithms supported by a UE (TS 24.501
+// 9.11.3.54), one bit per algorithm with algorithm 0 in the most
+// significant bit
+type UESecurityCapability struct {
+	EA  uint8
+	IA  uint8
+	EEA uint8
+	EIA uint8
+
+	// EPS tells whether the EPS algorithms are present
+	EPS bool
+}
+
+// Supports5GEA reports whether the UE supports ciphering algorithm n
+func (c UESecurityCapability) Supports5GEA(n uint8) bool {
+	return n < 8 && c.EA&(0x80>>n) != 0
+}
+
+// Supports5GIA reports whether the UE supports integrity algorithm n
+func (c UESecurityCapability) Supports5GIA(n uint8) bool {
+	return n < 8 && c.IA&(0x80>>n) != 0
+}
+
+// encode returns the value of the security capability
+func (c UESecurityCapability) encode() []byte {
+	if c.EPS {
+		return []byte{c.EA, c.IA, c.EEA, c.EIA}
+	}
+	return []byte{c.EA, c.IA}
+}
+
+// Bytes returns the value of the security capability IE, as given to
+// other NFs
+func (c UESecurityCapability) Bytes() []byte {
+	return c.encode()
+}
+
+// decodeUESecurityCapability decodes a UE security capability
+func decodeUESecurityCapability(b []byte) (UESecurityCapability, error) {
+	if len(b) < 2 {
+		return UESecurityCapability{}, ErrTruncated
+	}
+	c := UESecurityCapability{EA: b[0], IA: b[1]}
+	if len(b) >= 4 {
+		c.EEA, c.EIA, c.EPS = b[2], b[3], true
+	}
+	return c, nil
+}
+
+// SecurityAlgorithms are the NAS security algorithms selected by the
+// AMF (TS 24.501 9.11.3.34)
+type SecurityAlgorithms struct {
+	Ciphering uint8
+	Integrity uint8
+}
+
+// encodeSNSSAI returns the value of an S-NSSAI (TS 24.501 9.11.2.8)
+func encodeSNSSAI(s models.Snssai) ([]byte, error) {
+	if s.Sst < 0 || s.Sst > 0xff {
+		return nil, fmt.Errorf("invalid SST %d", s.Sst)
+	}
+	sd, err := s.SdBytes()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{uint8(s.Sst)}, sd...), nil
+}
+
+// decodeSNSSAI decodes the value of an S-NSSAI. The mapped HPLMN S-NSSAI
+// of roaming UEs is ignored.
+func decodeSNSSAI(b []byte) (models.Snssai, error) {
+	switch len(b) {
+	case 1, 2:
+		return models.Snssai{Sst: int(b[0])}, nil
+	case 4, 5, 8:
+		return models.Snssai{Sst: int(b[0]), Sd: fmt.Sprintf("%x", b[1:4])}, nil
+	default:
+		return models.Snssai{}, fmt.Errorf("invalid S-NSSAI length %d", len(b))
+	}
+}
+
+// encodeNSSAI returns the value of an NSSAI (TS 24.501 9.11.3.37)
+func encodeNSSAI(slices []models.Snssai) ([]byte, error) {
+	var b []byte
+	for _, s := range slices {
+		v, err := encodeSNSSAI(s)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, uint8(len(v)))
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+// decodeNSSAI decodes the value of an NSSAI
+func decodeNSSAI(b []byte) ([]models.Snssai, error) {
+	slices := []models.Snssai{}
+	for len(b) > 0 {
+		n := int(b[0])
+		if len(b) < 1+n {
+			return nil, ErrTruncated
+		}
+		s, err := decodeSNSSAI(b[1 : 1+n])
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, s)
+		b = b[1+n:]
+	}
+	return slices, nil
+}
+
+// PDUSessionStatus is a bitmap of PDU session identities, bit i set for
+// PSI i (TS 24.501 9.11.3.44). It also encodes the uplink data status and
+// the allowed PDU session status.
+type PDUSessionStatus uint16
+
+// Active reports whether the PDU session is set in the bitmap
+func (s PDUSessionStatus) Active(psi uint8) bool {
+	return psi < 16 && s&(1<<psi) != 0
+}
+
+// Set sets a PDU session in the bitmap
+func (s *PDUSessionStatus) Set(psi uint8) {
+	if psi < 16 {
+		*s |= 1 << psi
+	}
+}
+
+// IDs returns the PDU sessions set in the bitmap
+func (s PDUSessionStatus) IDs() []uint8 {
+	var ids []uint8
+	for psi := uint8(1); psi < 16; psi++ {
+		if s.Active(psi) {
+			ids = append(ids, psi)
+		}
+	}
+	return ids
+}
+
+// encode returns the value of the bitmap
+func (s PDUSessionStatus) encode() []byte {
+	return []byte{uint8(s), uint8(s >> 8)}
+}
+
+// decodePDUSessionStatus decodes a PDU session bitmap, ignoring the
+// spare octets
+func decodePDUSessionStatus(b []byte) (*PDUSessionStatus, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if len(b) < 2 {
+		return nil, ErrTruncated
+	}
+	s := PDUSessionStatus(uint16(b[0]) | uint16(b[1])<<8)
+	return &s, nil
+}
+
+// encodeTAI returns the PLMN and TAC octets of a TAI
+func encodeTAI(tai models.Tai) ([]byte, error) {
+	plmn, err := tai.PlmnID.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	tac, err := tai.TacValue()
+	if err != nil {
+		return nil, err
+	}
+	return append(plmn[:], uint8(tac>>16), uint8(tac>>8), uint8(tac)), nil
+}
+
+// decodeTAI decodes the PLMN and TAC octets of a TAI
+func decodeTAI(b []byte) (models.Tai, error) {
+	if len(b) < 6 {
+		return models.Tai{}, ErrTruncated
+	}
+	plmn, err := models.PlmnIDFromBytes(b[:3])
+	if err != nil {
+		return models.Tai{}, err
+	}
+	return models.NewTai(plmn, decodeTAC(b[3:6])), nil
+}
+
+// decodeTAC decodes a 3 octet tracking area code
+func decodeTAC(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// Partial tracking area list types (TS 24.501 9.11.3.9)
+const (
+	taiListTACsOfOnePLMN            = 0
+	taiListConsecutiveTACsOfOnePLMN = 1
+	taiListTAIsOfSeveralPLMNs       = 2
+)
+
+// maxTAIList bounds the number of TAIs of a TAI list
+const maxTAIList = 16
+
+// encodeTAIList returns the value of a 5GS tracking area identity list,
+// one partial list per PLMN
+func encodeTAIList(tais []models.Tai) ([]byte, error) {
+	if len(tais) == 0 || len(tais) > maxTAIList {
+		return nil, fmt.Errorf("invalid TAI list of %d elements", len(tais))
+	}
+
+	var plmns []models.PlmnID
+	tacs := make(map[models.PlmnID][]uint32)
+	for _, tai := range tais {
+		tac, err := tai.TacValue()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := tacs[tai.PlmnID]; !ok {
+			plmns = append(plmns, tai.PlmnID)
+		}
+		tacs[tai.PlmnID] = append(tacs[tai.PlmnID], tac)
+	}
+
+	var b []byte
+	for _, plmn := range plmns {
+		p, err := plmn.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		list := tacs[plmn]
+		b = append(b, taiListTACsOfOnePLMN<<5|uint8(len(list)-1))
+		b = append(b, p[:]...)
+		for _, tac := range list {
+			b = append(b, uint8(tac>>16), uint8(tac>>8), uint8(tac))
+		}
+	}
+	return b, nil
+}
+
+// decodeTAIList decodes a 5GS tracking area identity list
+func decodeTAIList(b []byte) ([]models.Tai, error) {
+	var tais []models.Tai
+	for len(b) > 0 {
+		listType, n := b[0]>>5&3, int(b[0]&0x1f)+1
+		b = b[1:]
+
+		switch listType {
+		case taiListTACsOfOnePLMN, taiListConsecutiveTACsOfOnePLMN:
+			size := 3 + 3*n
+			if listType == taiListConsecutiveTACsOfOnePLMN {
+				size = 6
+			}
+			if len(b) < size {
+				return nil, ErrTruncated
+			}
+			plmn, err := models.PlmnIDFromBytes(b[:3])
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < n; i++ {
+				var tac uint32
+				if listType == taiListConsecutiveTACsOfOnePLMN {
+					tac = decodeTAC(b[3:6]) + uint32(i)
+				} else {
+					tac = decodeTAC(b[3+3*i : 6+3*i])
+				}
+				tais = append(tais, models.NewTai(plmn, tac))
+			}
+			b = b[size:]
+		case taiListTAIsOfSeveralPLMNs:
+			if len(b) < 6*n {
+				return nil, ErrTruncated
+			}
+			for i := 0; i < n; i++ {
+				tai, err := decodeTAI(b[6*i : 6*i+6])
+				if err != nil {
+					return nil, err
+				}
+				tais = append(tais, tai)
+			}
+			b = b[6*n:]
+		default:
+			return nil, fmt.Errorf("invalid partial TAI list type %d", listType)
+		}
+	}
+	return tais, nil
+}
+
+// encodePLMNList returns the value of a PLMN list (TS 24.008 10.5.1.13)
+func encodePLMNList(plmns []models.PlmnID) ([]byte, error) {
+	var b []byte
+	for _, plmn := range plmns {
+		p, err := plmn.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, p[:]...)
+	}
+	return b, nil
+}
+
+// decodePLMNList decodes a PLMN list
+func decodePLMNList(b []byte) ([]models.PlmnID, error) {
+	if len(b)%3 != 0 {
+		return nil, fmt.Errorf("invalid PLMN list length %d", len(b))
+	}
+	var plmns []models.PlmnID
+	for i := 0; i < len(b); i += 3 {
+		plmn, err := models.PlmnIDFromBytes(b[i : i+3])
+		if err != nil {
+			return nil, err
+		}
+		plmns = append(plmns, plmn)
+	}
+	return plmns, nil
+}
+
+// TimerDeactivated is the value of a deactivated GPRS timer
+const TimerDeactivated time.Duration = -1
+
+// timerUnit is a GPRS timer unit with its encoding
+type timerUnit struct {
+	code uint8
+	unit time.Duration
+}
+
+// GPRS timer 3 units by increasing size (TS 24.008 10.5.7.4a)
+var timer3Units = []timerUnit{
+	{3, 2 * time.Second},
+	{4, 30 * time.Second},
+	{5, time.Minute},
+	{0, 10 * time.Minute},
+	{1, time.Hour},
+	{2, 10 * time.Hour},
+	{6, 320 * time.Hour},
+}
+
+// GPRS timer and GPRS timer 2 units by increasing size (TS 24.008
+// 10.5.7.3)
+var timer2Units = []timerUnit{
+	{0, 2 * time.Second},
+	{1, time.Minute},
+	{2, 6 * time.Minute},
+}
+
+// encodeTimer encodes a duration as a 5 bit value and a 3 bit unit,
+// preferring the smallest unit holding the duration exactly
+func encodeTimer(d time.Duration, units []timerUnit) uint8 {
+	if d < 0 {
+		return 7 << 5
+	}
+	for _, u := range units {
+		if d%u.unit == 0 && d/u.unit <= 31 {
+			return u.code<<5 | uint8(d/u.unit)
+		}
+	}
+	for _, u := range units {
+		if d/u.unit <= 31 {
+			return u.code<<5 | uint8(d/u.unit)
+		}
+	}
+	last := units[len(units)-1]
+	return last.code<<5 | 31
+}
+
+// decodeTimer decodes a 5 bit value and a 3 bit unit
+func decodeTimer(v uint8, units []timerUnit) time.Duration {
+	code := v >> 5
+	for _, u := range units {
+		if u.code == code {
+			return time.Duration(v&0x1f) * u.unit
+		}
+	}
+	return TimerDeactivated
+}
+
+// optTimer returns the value of an optional GPRS timer IE
+func optTimer(d *time.Duration, units []timerUnit) []byte {
+	if d == nil {
+		return nil
+	}
+	return []byte{encodeTimer(*d, units)}
+}
+
+// decodeOptTimer decodes an optional GPRS timer IE
+func decodeOptTimer(b []byte, units []timerUnit) (*time.Duration, error) {
+	if b == nil {
+		return nil, nil
+	}
+	if len(b) != 1 {
+		return nil, fmt.Errorf("invalid GPRS timer length %d", len(b))
+	}
+	d := decodeTimer(b[0], units)
+	return &d, nil
+}
+
+// encodeDNN returns the value of a DNN: dot separated labels each
+// preceded by its length (TS 23.003 9.1)
+func encodeDNN(dnn string) ([]byte, error) {
+	var b []byte
+	for _, label := range strings.Split(dnn, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid DNN %q", dnn)
+		}
+		b = append(b, uint8(len(label)))
+		b = append(b, label...)
+	}
+	return b, nil
+}
+
+// decodeDNN decodes the value of a DNN
+func decodeDNN(b []byte) (string, error) {
+	var labels []string
+	for len(b) > 0 {
+		n := int(b[0])
+		if len(b) < 1+n {
+			return "", ErrTruncated
+		}
+		labels = append(labels, string(b[1:1+n]))
+		b = b[1+n:]
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// PDUSessionType is the type of a PDU session (TS 24.501 9.11.4.11)
+type PDUSessionType uint8
+
+const (
+	PDUSessionTypeIPv4         PDUSessionType = 1
+	PDUSessionTypeIPv6         PDUSessionType = 2
+	PDUSessionTypeIPv4v6       PDUSessionType = 3
+	PDUSessionTypeUnstructured PDUSessionType = 4
+	PDUSessionTypeEthernet     PDUSessionType = 5
+)
+
+// String implements fmt.Stringer
+func (t PDUSessionType) String() string {
+	switch t {
+	case PDUSessionTypeIPv4:
+		return "IPv4"
+	case PDUSessionTypeIPv6:
+		return "IPv6"
+	case PDUSessionTypeIPv4v6:
+		return "IPv4v6"
+	case PDUSessionTypeUnstructured:
+		return "Unstructured"
+	case PDUSessionTypeEthernet:
+		return "Ethernet"
+	default:
+		return fmt.Sprintf("PDUSessionType(%d)", uint8(t))
+	}
+}
+
+// SSCMode is a session and service continuity mode (TS 24.501 9.11.4.16)
+type SSCMode uint8
+
+const (
+	SSCMode1 SSCMode = 1
+	SSCMode2 SSCMode = 2
+	SSCMode3 SSCMode = 3
+)
+
+// PDUAddress is the address assigned to a PDU session (TS 24.501
+// 9.11.4.10)
+type PDUAddress struct {
+	Type PDUSessionType
+	IPv4 net.IP
+
+	// IPv6InterfaceID is the 8 octet interface identifier of the
+	// link-local address
+	IPv6InterfaceID []byte
+}
+
+// encode returns the value of the PDU address
+func (a PDUAddress) encode() ([]byte, error) {
+	b := []byte{uint8(a.Type) & 7}
+	if a.Type == PDUSessionTypeIPv6 || a.Type == PDUSessionTypeIPv4v6 {
+		if len(a.IPv6InterfaceID) != 8 {
+			return nil, fmt.Errorf("invalid IPv6 interface identifier length %d", len(a.IPv6InterfaceID))
+		}
+		b = append(b, a.IPv6InterfaceID...)
+	}
+	if a.Type == PDUSessionTypeIPv4 || a.Type == PDUSessionTypeIPv4v6 {
+		ip := a.IPv4.To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %v", a.IPv4)
+		}
+		b = append(b, ip...)
+	}
+	return b, nil
+}
+
+// decodePDUAddress decodes a PDU address
+func decodePDUAddress(b []byte) (PDUAddress, error) {
+	if len(b) < 1 {
+		return PDUAddress{}, ErrTruncated
+	}
+
+	a := PDUAddress{Type: PDUSessionType(b[0] & 7)}
+	b = b[1:]
+	if a.Type == PDUSessionTypeIPv6 || a.Type == PDUSessionTypeIPv4v6 {
+		if len(b) < 8 {
+			return a, ErrTruncated
+		}
+		a.IPv6InterfaceID, b = b[:8], b[8:]
+	}
+	if a.Type == PDUSessionTypeIPv4 || a.Type == PDUSessionTypeIPv4v6 {
+		if len(b) < 4 {
+			return a, ErrTruncated
+		}
+		a.IPv4 = net.IP(b[:4]).To16()
+	}
+	return a, nil
+}
+
+// SessionAMBR is the aggregate maximum bit rate of a PDU session, in bits
+// per second (TS 24.501 9.11.4.14)
+type SessionAMBR struct {
+	Downlink uint64
+	Uplink   uint64
+}
+
+// encodeBitRate encodes a bit rate as a unit, 1 kbps times a power of 4,
+// and a 16 bit multiple, using the smallest unit the rate fits in
+func encodeBitRate(bps uint64) []byte {
+	kbps := bps / 1000
+	unit, step := uint8(1), uint64(1)
+	for kbps/step > 0xffff && unit < 25 {
+		unit++
+		step *= 4
+	}
+	v := kbps / step
+	if v > 0xffff {
+		v = 0xffff
+	}
+	return []byte{unit, uint8(v >> 8), uint8(v)}
+}
+
+// decodeBitRate decodes a unit and a 16 bit multiple
+func decodeBitRate(b []byte) uint64 {
+	if b[0] == 0 {
+		return 0
+	}
+	step := uint64(1000)
+	for i := uint8(1); i < b[0]; i++ {
+		step *= 4
+	}
+	return (uint64(b[1])<<8 | uint64(b[2])) * step
+}
+
+// encode returns the value of the session AMBR
+func (a SessionAMBR) encode() []byte {
+	return append(encodeBitRate(a.Downlink), encodeBitRate(a.Uplink)...)
+}
+
+// decodeSessionAMBR decodes a session AMBR
+func decodeSessionAMBR(b []byte) (SessionAMBR, error) {
+	if len(b) < 6 {
+		return SessionAMBR{}, ErrTruncated
+	}
+	return SessionAMBR{Downlink: decodeBitRate(b[:3]), Uplink: decodeBitRate(b[3:6])}, nil
+}
+
+// IntegrityProtectionMaxDataRate is the maximum data rate per UE for
+// user plane integrity protection (TS 24.501 9.11.4.7)
+type IntegrityProtectionMaxDataRate struct {
+	Uplink   uint8
+	Downlink uint8
+}
+
+// Integrity protection maximum data rates
+const (
+	IntegrityProtectionMaxDataRate64Kbps = 0x00
+	IntegrityProtectionMaxDataRateFull   = 0xff
+)
+
+// PacketFilterDirection is the traffic a packet filter applies to
+type PacketFilterDirection uint8
+
+const (
+	PacketFilterDownlink      PacketFilterDirection = 1
+	PacketFilterUplink        PacketFilterDirection = 2
+	PacketFilterBidirectional PacketFilterDirection = 3
+)
+
+// PacketFilterMatchAll is the packet filter component matching all
+// packets
+var PacketFilterMatchAll = []byte{PacketFilterComponentMatchAll}
+
+// Packet filter component types (TS 24.501 9.11.4.13). The local side is
+// the UE, the remote one its peer in the data network.
+const (
+	PacketFilterComponentMatchAll        = 0x01
+	PacketFilterComponentIPv4Remote      = 0x10
+	PacketFilterComponentIPv4Local       = 0x11
+	PacketFilterComponentProtocol        = 0x30
+	PacketFilterComponentLocalPort       = 0x40
+	PacketFilterComponentLocalPortRange  = 0x41
+	PacketFilterComponentRemotePort      = 0x50
+	PacketFilterComponentRemotePortRange = 0x51
+)
+
+// PacketFilter is a packet filter of a QoS rule
+type PacketFilter struct {
+	ID        uint8
+	Direction PacketFilterDirection
+
+	// Components holds the encoded packet filter components
+	Components []byte
+}
+
+// QoSRuleOperation is what a QoS rule does to the rule of the same ID
+// known by the UE
+type QoSRuleOperation uint8
+
+const (
+	QoSRuleCreate                 QoSRuleOperation = 1
+	QoSRuleDelete                 QoSRuleOperation = 2
+	QoSRuleModifyAndReplaceFilter QoSRuleOperation = 4
+)
+
+// QoSRule is a QoS rule of a PDU session (TS 24.501 9.11.4.13)
+type QoSRule struct {
+	ID uint8
+
+	// Operation is QoSRuleCreate when 0. A deleted rule carries nothing
+	// but its ID.
+	Operation QoSRuleOperation
+
+	// Default tells that the rule is the default QoS rule of the session
+	Default bool
+
+	PacketFilters []PacketFilter
+	Precedence    uint8
+	QFI           uint8
+}
+
+// QoSRules is the value of the QoS rules IE
+type QoSRules []QoSRule
+
+// Bytes returns the encoded QoS rules
+func (rules QoSRules) Bytes() ([]byte, error) {
+	var b []byte
+	for _, rule := range rules {
+		op := rule.Operation
+		if op == 0 {
+			op = QoSRuleCreate
+		}
+		if len(rule.PacketFilters) > 15 {
+			return nil, fmt.Errorf("too many packet filters in QoS rule %d", rule.ID)
+		}
+		if rule.QFI > 0x3f {
+			return nil, fmt.Errorf("invalid QFI %d", rule.QFI)
+		}
+
+		v := []byte{uint8(op) << 5}
+		if rule.Default {
+			v[0] |= 0x10
+		}
+		if op != QoSRuleDelete {
+			v[0] |= uint8(len(rule.PacketFilters))
+			for _, f := range rule.PacketFilters {
+				if len(f.Components) > 0xff {
+					return nil, fmt.Errorf("packet filter %d too long", f.ID)
+				}
+				v = append(v, uint8(f.Direction)&3<<4|f.ID&0x0f, uint8(len(f.Components)))
+				v = append(v, f.Components...)
+			}
+			v = append(v, rule.Precedence, rule.QFI)
+		}
+
+		b = append(b, rule.ID, uint8(len(v)>>8), uint8(len(v)))
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+// DecodeQoSRules decodes the value of the QoS rules IE. Only rules being
+// created, deleted or having their packet filters replaced are
+// supported.
+func DecodeQoSRules(b []byte) (QoSRules, error) {
+	var rules QoSRules
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrTruncated
+		}
+		rule := QoSRule{ID: b[0]}
+		n := int(b[1])<<8 | int(b[2])
+		if len(b) < 3+n || n < 1 {
+			return nil, ErrTruncated
+		}
+		v := b[3 : 3+n]
+		b = b[3+n:]
+
+		rule.Operation = QoSRuleOperation(v[0] >> 5)
+		rule.Default = v[0]&0x10 != 0
+		switch rule.Operation {
+		case QoSRuleDelete:
+			rules = append(rules, rule)
+			continue
+		case QoSRuleCreate, QoSRuleModifyAndReplaceFilter:
+		default:
+			return nil, fmt.Errorf("unsupported QoS rule operation %d", rule.Operation)
+		}
+
+		filters := int(v[0] & 0x0f)
+		v = v[1:]
+		for i := 0; i < filters; i++ {
+			if len(v) < 2 || len(v) < 2+int(v[1]) {
+				return nil, ErrTruncated
+			}
+			rule.PacketFilters = append(rule.PacketFilters, PacketFilter{
+				ID:         v[0] & 0x0f,
+				Direction:  PacketFilterDirection(v[0] >> 4 & 3),
+				Components: v[2 : 2+int(v[1])],
+			})
+			v = v[2+int(v[1]):]
+		}
+		if len(v) < 2 {
+			return nil, ErrTruncated
+		}
+		rule.Precedence, rule.QFI = v[0], v[1]&0x3f
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// QoSFlowOperation is what a QoS flow description does to the flow of
+// the same QFI known by the UE
+type QoSFlowOperation uint8
+
+const (
+	QoSFlowCreate QoSFlowOperation = 1
+	QoSFlowDelete QoSFlowOperation = 2
+	QoSFlowModify QoSFlowOperation = 3
+)
+
+// Parameter identifiers of the QoS flow descriptions
+const (
+	qosFlowParam5QI        = 0x01
+	qosFlowParamGFBRUplink = 0x02
+	qosFlowParamGFBRDown   = 0x03
+	qosFlowParamMFBRUplink = 0x04
+	qosFlowParamMFBRDown   = 0x05
+)
+
+// QoSFlowDescription describes a QoS flow of a PDU session to the UE (TS
+// 24.501 9.11.4.12). Bit rates are in bits per second, left out when 0.
+type QoSFlowDescription struct {
+	QFI uint8
+
+	// Operation is QoSFlowCreate when 0. A deleted flow carries nothing
+	// but its QFI.
+	Operation QoSFlowOperation
+
+	FiveQI uint8
+
+	GFBRUplink   uint64
+	GFBRDownlink uint64
+	MFBRUplink   uint64
+	MFBRDownlink uint64
+}
+
+// QoSFlowDescriptions is the value of the QoS flow descriptions IE
+type QoSFlowDescriptions []QoSFlowDescription
+
+// Bytes returns the encoded QoS flow descriptions. Created and modified
+// flows replace all their parameters.
+func (flows QoSFlowDescriptions) Bytes() ([]byte, error) {
+	var b []byte
+	for _, f := range flows {
+		op := f.Operation
+		if op == 0 {
+			op = QoSFlowCreate
+		}
+		if f.QFI > 0x3f {
+			return nil, fmt.Errorf("invalid QFI %d", f.QFI)
+		}
+		if op == QoSFlowDelete {
+			b = append(b, f.QFI, uint8(op)<<5, 0)
+			continue
+		}
+
+		var params [][]byte
+		if f.FiveQI != 0 {
+			params = append(params, []byte{qosFlowParam5QI, 1, f.FiveQI})
+		}
+		for _, p := range []struct {
+			id  uint8
+			bps uint64
+		}{
+			{qosFlowParamGFBRUplink, f.GFBRUplink},
+			{qosFlowParamGFBRDown, f.GFBRDownlink},
+			{qosFlowParamMFBRUplink, f.MFBRUplink},
+			{qosFlowParamMFBRDown, f.MFBRDownlink},
+		} {
+			if p.bps != 0 {
+				params = append(params, append([]byte{p.id, 3}, encodeBitRate(p.bps)...))
+			}
+		}
+
+		// The E bit tells that the parameters are given, replacing those
+		// of a modified flow
+		b = append(b, f.QFI, uint8(op)<<5, 0x40|uint8(len(params)))
+		for _, p := range params {
+			b = append(b, p...)
+		}
+	}
+	return b, nil
+}
+
+// DecodeQoSFlowDescriptions decodes the value of the QoS flow
+// descriptions IE. Unknown parameters are skipped.
+func DecodeQoSFlowDescriptions(b []byte) (QoSFlowDescriptions, error) {
+	var flows QoSFlowDescriptions
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, ErrTruncated
+		}
+		f := QoSFlowDescription{QFI: b[0] & 0x3f, Operation: QoSFlowOperation(b[1] >> 5)}
+		n := int(b[2] & 0x3f)
+		b = b[3:]
+		for i := 0; i < n; i++ {
+			if len(b) < 2 || len(b) < 2+int(b[1]) {
+				return nil, ErrTruncated
+			}
+			id, v := b[0], b[2:2+int(b[1])]
+			b = b[2+int(b[1]):]
+
+			switch {
+			case id == qosFlowParam5QI && len(v) >= 1:
+				f.FiveQI = v[0]
+			case id >= qosFlowParamGFBRUplink && id <= qosFlowParamMFBRDown && len(v) >= 3:
+				bps := decodeBitRate(v)
+				switch id {
+				case qosFlowParamGFBRUplink:
+					f.GFBRUplink = bps
+				case qosFlowParamGFBRDown:
+					f.GFBRDownlink = bps
+				case qosFlowParamMFBRUplink:
+					f.MFBRUplink = bps
+				default:
+					f.MFBRDownlink = bps
+				}
+			}
+		}
+		flows = append(flows, f)
+	}
+	return flows, nil
+}
@@ -0,0 +1,1104 @@
+package nas
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+func init() {
+	register(func() Message { return &RegistrationRequest{} })
+	register(func() Message { return &RegistrationAccept{} })
+	register(func() Message { return &RegistrationComplete{} })
+	register(func() Message { return &RegistrationReject{} })
+	register(func() Message { return &DeregistrationRequestUEOriginating{} })
+	register(func() Message { return &DeregistrationAcceptUEOriginating{} })
+	register(func() Message { return &DeregistrationRequestUETerminated{} })
+	register(func() Message { return &DeregistrationAcceptUETerminated{} })
+	register(func() Message { return &ServiceRequest{} })
+	register(func() Message { return &ServiceAccept{} })
+	register(func() Message { return &ServiceReject{} })
+	register(func() Message { return &ConfigurationUpdateCommand{} })
+	register(func() Message { return &ConfigurationUpdateComplete{} })
+	register(func() Message { return &AuthenticationRequest{} })
+	register(func() Message { return &AuthenticationResponse{} })
+	register(func() Message { return &AuthenticationReject{} })
+	register(func() Message { return &AuthenticationFailure{} })
+	register(func() Message { return &IdentityRequest{} })
+	register(func() Message { return &IdentityResponse{} })
+	register(func() Message { return &SecurityModeCommand{} })
+	register(func() Message { return &SecurityModeComplete{} })
+	register(func() Message { return &SecurityModeReject{} })
+	register(func() Message { return &Status5GMM{} })
+	register(func() Message { return &ULNASTransport{} })
+	register(func() Message { return &DLNASTransport{} })
+}
+
+// 5GMM IEIs
+const (
+	ieiAdditionalGUTI              = 0x77
+	ieiAllowedNSSAI                = 0x15
+	ieiAllowedPDUSessionStatus     = 0x25
+	ieiAuthenticationParameterRAND = 0x21
+	ieiAuthenticationParameterAUTN = 0x20
+	ieiAuthenticationResponse      = 0x2d
+	ieiAuthenticationFailure       = 0x30
+	ieiBackoffTimer                = 0x37
+	ieiCapability5GMM              = 0x10
+	ieiCause5GMM                   = 0x58
+	ieiConfigurationUpdate         = 0xd0
+	ieiConfiguredNSSAI             = 0x31
+	ieiDNN                         = 0x25
+	ieiEAPMessage                  = 0x78
+	ieiEquivalentPLMNs             = 0x4a
+	ieiFullNetworkName             = 0x43
+	ieiGUTI                        = 0x77
+	ieiIMEISV                      = 0x77
+	ieiIMEISVRequest               = 0xe0
+	ieiLastVisitedTAI              = 0x52
+	ieiLocalTimeZone               = 0x46
+	ieiMICOIndication              = 0xb0
+	ieiNASMessageContainer         = 0x71
+	ieiNegotiatedDRX               = 0x51
+	ieiNetworkDaylightSavingTime   = 0x49
+	ieiNetworkFeatureSupport       = 0x21
+	ieiNetworkSlicingIndication    = 0x90
+	ieiNonCurrentNgKSI             = 0xc0
+	ieiOldPDUSessionID             = 0x59
+	ieiPDUSessionID                = 0x12
+	ieiPDUSessionReactivation      = 0x26
+	ieiPDUSessionReactivationError = 0x72
+	ieiPDUSessionStatus            = 0x50
+	ieiRejectedNSSAI               = 0x11
+	ieiRejectedNSSAIReject         = 0x69
+	ieiRequestType                 = 0x80
+	ieiRequestedDRX                = 0x51
+	ieiRequestedNSSAI              = 0x2f
+	ieiSelectedEPSAlgorithms       = 0x57
+	ieiShortNetworkName            = 0x45
+	ieiSNSSAI                      = 0x22
+	ieiSecurityABBA                = 0x38
+	ieiAdditional5GSecurityInfo    = 0x36
+	ieiServiceAreaList             = 0x27
+	ieiSMSIndication               = 0xf0
+	ieiT3346                       = 0x5f
+	ieiT3502                       = 0x16
+	ieiT3512                       = 0x5e
+	ieiTAIList                     = 0x54
+	ieiUESecurityCapability        = 0x2e
+	ieiUniversalTimeAndTimeZone    = 0x47
+	ieiUpdateType5GS               = 0x53
+	ieiUplinkDataStatus            = 0x40
+	ieiAdditionalInformation       = 0x24
+)
+
+// RegistrationRequest is sent by the UE to register (TS 24.501 8.2.6)
+type RegistrationRequest struct {
+	RegistrationType RegistrationType
+	FollowOnRequest  bool
+	NgKSI            NgKSI
+	MobileIdentity   MobileIdentity
+
+	NonCurrentNgKSI         *NgKSI
+	Capability5GMM          []byte
+	UESecurityCapability    *UESecurityCapability
+	RequestedNSSAI          []models.Snssai
+	LastVisitedTAI          *models.Tai
+	UplinkDataStatus        *PDUSessionStatus
+	PDUSessionStatus        *PDUSessionStatus
+	MICOIndication          *uint8
+	AdditionalGUTI          *GUTI
+	AllowedPDUSessionStatus *PDUSessionStatus
+	RequestedDRX            *uint8
+	UpdateType5GS           []byte
+	NASMessageContainer     []byte
+}
+
+// MessageType implements Message
+func (m *RegistrationRequest) MessageType() MessageType { return MsgRegistrationRequest }
+
+func (m *RegistrationRequest) encodeBody(w *writer) {
+	v := uint8(m.RegistrationType) & 7
+	if m.FollowOnRequest {
+		v |= 1 << 3
+	}
+	w.uint8(m.NgKSI.value()<<4 | v)
+	w.lve(w.must(m.MobileIdentity.encode()))
+
+	if m.NonCurrentNgKSI != nil {
+		w.half(ieiNonCurrentNgKSI, m.NonCurrentNgKSI.value())
+	}
+	w.optTLV(ieiCapability5GMM, m.Capability5GMM)
+	if m.UESecurityCapability != nil {
+		w.tlv(ieiUESecurityCapability, m.UESecurityCapability.encode())
+	}
+	if m.RequestedNSSAI != nil {
+		w.tlv(ieiRequestedNSSAI, w.must(encodeNSSAI(m.RequestedNSSAI)))
+	}
+	if m.LastVisitedTAI != nil {
+		w.tv(ieiLastVisitedTAI, w.must(encodeTAI(*m.LastVisitedTAI)))
+	}
+	if m.UplinkDataStatus != nil {
+		w.tlv(ieiUplinkDataStatus, m.UplinkDataStatus.encode())
+	}
+	if m.PDUSessionStatus != nil {
+		w.tlv(ieiPDUSessionStatus, m.PDUSessionStatus.encode())
+	}
+	w.optHalf(ieiMICOIndication, m.MICOIndication)
+	if m.AdditionalGUTI != nil {
+		id := MobileIdentity{Type: IdentityGUTI, GUTI: m.AdditionalGUTI}
+		w.tlve(ieiAdditionalGUTI, w.must(id.encode()))
+	}
+	if m.AllowedPDUSessionStatus != nil {
+		w.tlv(ieiAllowedPDUSessionStatus, m.AllowedPDUSessionStatus.encode())
+	}
+	if m.RequestedDRX != nil {
+		w.tlv(ieiRequestedDRX, []byte{*m.RequestedDRX})
+	}
+	w.optTLV(ieiUpdateType5GS, m.UpdateType5GS)
+	w.optTLVE(ieiNASMessageContainer, m.NASMessageContainer)
+}
+
+func (m *RegistrationRequest) decodeBody(r *reader) (err error) {
+	v := r.uint8()
+	m.RegistrationType = RegistrationType(v & 7)
+	m.FollowOnRequest = v&(1<<3) != 0
+	m.NgKSI = ngKSIFrom(v >> 4)
+	if m.MobileIdentity, err = decodeMobileIdentity(r.lve()); err != nil {
+		return err
+	}
+
+	opts := r.optionals(map[uint8]int{ieiLastVisitedTAI: 6})
+	if v := opts.half(ieiNonCurrentNgKSI); v != nil {
+		k := ngKSIFrom(*v)
+		m.NonCurrentNgKSI = &k
+	}
+	m.Capability5GMM = opts.bytes(ieiCapability5GMM)
+	if b := opts.bytes(ieiUESecurityCapability); b != nil {
+		c, err := decodeUESecurityCapability(b)
+		if err != nil {
+			return err
+		}
+		m.UESecurityCapability = &c
+	}
+	if b := opts.bytes(ieiRequestedNSSAI); b != nil {
+		if m.RequestedNSSAI, err = decodeNSSAI(b); err != nil {
+			return err
+		}
+	}
+	if b := opts.bytes(ieiLastVisitedTAI); b != nil {
+		tai, err := decodeTAI(b)
+		if err != nil {
+			return err
+		}
+		m.LastVisitedTAI = &tai
+	}
+	if m.UplinkDataStatus, err = decodePDUSessionStatus(opts.bytes(ieiUplinkDataStatus)); err != nil {
+		return err
+	}
+	if m.PDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionStatus)); err != nil {
+		return err
+	}
+	m.MICOIndication = opts.half(ieiMICOIndication)
+	if b := opts.bytes(ieiAdditionalGUTI); b != nil {
+		id, err := decodeMobileIdentity(b)
+		if err != nil {
+			return err
+		}
+		m.AdditionalGUTI = id.GUTI
+	}
+	if m.AllowedPDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiAllowedPDUSessionStatus)); err != nil {
+		return err
+	}
+	if m.RequestedDRX, err = opts.uint8(ieiRequestedDRX); err != nil {
+		return err
+	}
+	m.UpdateType5GS = opts.bytes(ieiUpdateType5GS)
+	m.NASMessageContainer = opts.bytes(ieiNASMessageContainer)
+	return nil
+}
+
+// RegistrationAccept completes a registration (TS 24.501 8.2.7)
+type RegistrationAccept struct {
+	Result RegistrationResult
+
+	GUTI                         *GUTI
+	EquivalentPLMNs              []models.PlmnID
+	TAIList                      []models.Tai
+	AllowedNSSAI                 []models.Snssai
+	RejectedNSSAI                []byte
+	ConfiguredNSSAI              []models.Snssai
+	NetworkFeatureSupport        []byte
+	PDUSessionStatus             *PDUSessionStatus
+	PDUSessionReactivationResult *PDUSessionStatus
+	MICOIndication               *uint8
+	NetworkSlicingIndication     *uint8
+	T3512                        *time.Duration
+	T3502                        *time.Duration
+	EAPMessage                   []byte
+	NegotiatedDRX                *uint8
+}
+
+// MessageType implements Message
+func (m *RegistrationAccept) MessageType() MessageType { return MsgRegistrationAccept }
+
+func (m *RegistrationAccept) encodeBody(w *writer) {
+	w.lv(m.Result.encode())
+
+	if m.GUTI != nil {
+		id := MobileIdentity{Type: IdentityGUTI, GUTI: m.GUTI}
+		w.tlve(ieiGUTI, w.must(id.encode()))
+	}
+	if m.EquivalentPLMNs != nil {
+		w.tlv(ieiEquivalentPLMNs, w.must(encodePLMNList(m.EquivalentPLMNs)))
+	}
+	if m.TAIList != nil {
+		w.tlv(ieiTAIList, w.must(encodeTAIList(m.TAIList)))
+	}
+	if m.AllowedNSSAI != nil {
+		w.tlv(ieiAllowedNSSAI, w.must(encodeNSSAI(m.AllowedNSSAI)))
+	}
+	w.optTLV(ieiRejectedNSSAI, m.RejectedNSSAI)
+	if m.ConfiguredNSSAI != nil {
+		w.tlv(ieiConfiguredNSSAI, w.must(encodeNSSAI(m.ConfiguredNSSAI)))
+	}
+	w.optTLV(ieiNetworkFeatureSupport, m.NetworkFeatureSupport)
+	if m.PDUSessionStatus != nil {
+		w.tlv(ieiPDUSessionStatus, m.PDUSessionStatus.encode())
+	}
+	if m.PDUSessionReactivationResult != nil {
+		w.tlv(ieiPDUSessionReactivation, m.PDUSessionReactivationResult.encode())
+	}
+	w.optHalf(ieiMICOIndication, m.MICOIndication)
+	w.optHalf(ieiNetworkSlicingIndication, m.NetworkSlicingIndication)
+	w.optTLV(ieiT3512, optTimer(m.T3512, timer3Units))
+	w.optTLV(ieiT3502, optTimer(m.T3502, timer2Units))
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	if m.NegotiatedDRX != nil {
+		w.tlv(ieiNegotiatedDRX, []byte{*m.NegotiatedDRX})
+	}
+}
+
+func (m *RegistrationAccept) decodeBody(r *reader) (err error) {
+	if m.Result, err = decodeRegistrationResult(r.lv()); err != nil {
+		return err
+	}
+
+	opts := r.optionals(nil)
+	if b := opts.bytes(ieiGUTI); b != nil {
+		id, err := decodeMobileIdentity(b)
+		if err != nil {
+			return err
+		}
+		m.GUTI = id.GUTI
+	}
+	if b := opts.bytes(ieiEquivalentPLMNs); b != nil {
+		if m.EquivalentPLMNs, err = decodePLMNList(b); err != nil {
+			return err
+		}
+	}
+	if b := opts.bytes(ieiTAIList); b != nil {
+		if m.TAIList, err = decodeTAIList(b); err != nil {
+			return err
+		}
+	}
+	if b := opts.bytes(ieiAllowedNSSAI); b != nil {
+		if m.AllowedNSSAI, err = decodeNSSAI(b); err != nil {
+			return err
+		}
+	}
+	m.RejectedNSSAI = opts.bytes(ieiRejectedNSSAI)
+	if b := opts.bytes(ieiConfiguredNSSAI); b != nil {
+		if m.ConfiguredNSSAI, err = decodeNSSAI(b); err != nil {
+			return err
+		}
+	}
+	m.NetworkFeatureSupport = opts.bytes(ieiNetworkFeatureSupport)
+	if m.PDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionStatus)); err != nil {
+		return err
+	}
+	if m.PDUSessionReactivationResult, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionReactivation)); err != nil {
+		return err
+	}
+	m.MICOIndication = opts.half(ieiMICOIndication)
+	m.NetworkSlicingIndication = opts.half(ieiNetworkSlicingIndication)
+	if m.T3512, err = decodeOptTimer(opts.bytes(ieiT3512), timer3Units); err != nil {
+		return err
+	}
+	if m.T3502, err = decodeOptTimer(opts.bytes(ieiT3502), timer2Units); err != nil {
+		return err
+	}
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.NegotiatedDRX, err = opts.uint8(ieiNegotiatedDRX)
+	return err
+}
+
+// RegistrationComplete acknowledges a Registration Accept (TS 24.501 8.2.8)
+type RegistrationComplete struct {
+	SORTransparentContainer []byte
+}
+
+// MessageType implements Message
+func (m *RegistrationComplete) MessageType() MessageType { return MsgRegistrationComplete }
+
+func (m *RegistrationComplete) encodeBody(w *writer) {
+	w.optTLVE(0x73, m.SORTransparentContainer)
+}
+
+func (m *RegistrationComplete) decodeBody(r *reader) error {
+	m.SORTransparentContainer = r.optionals(nil).bytes(0x73)
+	return nil
+}
+
+// RegistrationReject rejects a registration (TS 24.501 8.2.9)
+type RegistrationReject struct {
+	Cause Cause5GMM
+
+	T3346         *time.Duration
+	T3502         *time.Duration
+	EAPMessage    []byte
+	RejectedNSSAI []byte
+}
+
+// MessageType implements Message
+func (m *RegistrationReject) MessageType() MessageType { return MsgRegistrationReject }
+
+func (m *RegistrationReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLV(ieiT3346, optTimer(m.T3346, timer2Units))
+	w.optTLV(ieiT3502, optTimer(m.T3502, timer2Units))
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	w.optTLV(ieiRejectedNSSAIReject, m.RejectedNSSAI)
+}
+
+func (m *RegistrationReject) decodeBody(r *reader) (err error) {
+	m.Cause = Cause5GMM(r.uint8())
+
+	opts := r.optionals(nil)
+	if m.T3346, err = decodeOptTimer(opts.bytes(ieiT3346), timer2Units); err != nil {
+		return err
+	}
+	if m.T3502, err = decodeOptTimer(opts.bytes(ieiT3502), timer2Units); err != nil {
+		return err
+	}
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.RejectedNSSAI = opts.bytes(ieiRejectedNSSAIReject)
+	return nil
+}
+
+// DeregistrationRequestUEOriginating is sent by the UE to de-register
+// (TS 24.501 8.2.12)
+type DeregistrationRequestUEOriginating struct {
+	DeregistrationType DeregistrationType
+	NgKSI              NgKSI
+	MobileIdentity     MobileIdentity
+}
+
+// MessageType implements Message
+func (m *DeregistrationRequestUEOriginating) MessageType() MessageType {
+	return MsgDeregistrationRequestUEOrig
+}
+
+func (m *DeregistrationRequestUEOriginating) encodeBody(w *writer) {
+	w.uint8(m.NgKSI.value()<<4 | m.DeregistrationType.value())
+	w.lve(w.must(m.MobileIdentity.encode()))
+}
+
+func (m *DeregistrationRequestUEOriginating) decodeBody(r *reader) (err error) {
+	v := r.uint8()
+	m.DeregistrationType = deregistrationTypeFrom(v & 0x0f)
+	m.NgKSI = ngKSIFrom(v >> 4)
+	m.MobileIdentity, err = decodeMobileIdentity(r.lve())
+	return err
+}
+
+// DeregistrationAcceptUEOriginating acknowledges a UE originating
+// de-registration (TS 24.501 8.2.13)
+type DeregistrationAcceptUEOriginating struct{}
+
+// MessageType implements Message
+func (m *DeregistrationAcceptUEOriginating) MessageType() MessageType {
+	return MsgDeregistrationAcceptUEOrig
+}
+
+func (m *DeregistrationAcceptUEOriginating) encodeBody(w *writer) {}
+
+func (m *DeregistrationAcceptUEOriginating) decodeBody(r *reader) error { return nil }
+
+// DeregistrationRequestUETerminated is sent by the network to de-register
+// a UE (TS 24.501 8.2.14)
+type DeregistrationRequestUETerminated struct {
+	DeregistrationType DeregistrationType
+
+	Cause *Cause5GMM
+	T3346 *time.Duration
+}
+
+// MessageType implements Message
+func (m *DeregistrationRequestUETerminated) MessageType() MessageType {
+	return MsgDeregistrationRequestUETerm
+}
+
+func (m *DeregistrationRequestUETerminated) encodeBody(w *writer) {
+	w.uint8(m.DeregistrationType.value())
+	if m.Cause != nil {
+		w.tv(ieiCause5GMM, optCause(m.Cause))
+	}
+	w.optTLV(ieiT3346, optTimer(m.T3346, timer2Units))
+}
+
+func (m *DeregistrationRequestUETerminated) decodeBody(r *reader) (err error) {
+	m.DeregistrationType = deregistrationTypeFrom(r.uint8() & 0x0f)
+
+	opts := r.optionals(map[uint8]int{ieiCause5GMM: 1})
+	m.Cause = decodeOptCause[Cause5GMM](opts.bytes(ieiCause5GMM))
+	m.T3346, err = decodeOptTimer(opts.bytes(ieiT3346), timer2Units)
+	return err
+}
+
+// DeregistrationAcceptUETerminated acknowledges a network initiated
+// de-registration (TS 24.501 8.2.15)
+type DeregistrationAcceptUETerminated struct{}
+
+// MessageType implements Message
+func (m *DeregistrationAcceptUETerminated) MessageType() MessageType {
+	return MsgDeregistrationAcceptUETerm
+}
+
+func (m *DeregistrationAcceptUETerminated) encodeBody(w *writer) {}
+
+func (m *DeregistrationAcceptUETerminated) decodeBody(r *reader) error { return nil }
+
+// ServiceRequest is sent by a UE in CM-IDLE to reconnect (TS 24.501 8.2.16)
+type ServiceRequest struct {
+	NgKSI       NgKSI
+	ServiceType ServiceType
+	STMSI       STMSI
+
+	UplinkDataStatus        *PDUSessionStatus
+	PDUSessionStatus        *PDUSessionStatus
+	AllowedPDUSessionStatus *PDUSessionStatus
+	NASMessageContainer     []byte
+}
+
+// MessageType implements Message
+func (m *ServiceRequest) MessageType() MessageType { return MsgServiceRequest }
+
+func (m *ServiceRequest) encodeBody(w *writer) {
+	w.uint8(uint8(m.ServiceType)<<4 | m.NgKSI.value())
+	id := MobileIdentity{Type: IdentitySTMSI, STMSI: &m.STMSI}
+	w.lve(w.must(id.encode()))
+
+	if m.UplinkDataStatus != nil {
+		w.tlv(ieiUplinkDataStatus, m.UplinkDataStatus.encode())
+	}
+	if m.PDUSessionStatus != nil {
+		w.tlv(ieiPDUSessionStatus, m.PDUSessionStatus.encode())
+	}
+	if m.AllowedPDUSessionStatus != nil {
+		w.tlv(ieiAllowedPDUSessionStatus, m.AllowedPDUSessionStatus.encode())
+	}
+	w.optTLVE(ieiNASMessageContainer, m.NASMessageContainer)
+}
+
+func (m *ServiceRequest) decodeBody(r *reader) (err error) {
+	v := r.uint8()
+	m.NgKSI = ngKSIFrom(v & 0x0f)
+	m.ServiceType = ServiceType(v >> 4)
+	id, err := decodeMobileIdentity(r.lve())
+	if err != nil {
+		return err
+	}
+	if id.STMSI == nil {
+		return fmt.Errorf("unexpected identity type %s", id.Type)
+	}
+	m.STMSI = *id.STMSI
+
+	opts := r.optionals(nil)
+	if m.UplinkDataStatus, err = decodePDUSessionStatus(opts.bytes(ieiUplinkDataStatus)); err != nil {
+		return err
+	}
+	if m.PDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionStatus)); err != nil {
+		return err
+	}
+	if m.AllowedPDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiAllowedPDUSessionStatus)); err != nil {
+		return err
+	}
+	m.NASMessageContainer = opts.bytes(ieiNASMessageContainer)
+	return nil
+}
+
+// ServiceAccept accepts a Service Request (TS 24.501 8.2.17)
+type ServiceAccept struct {
+	PDUSessionStatus                       *PDUSessionStatus
+	PDUSessionReactivationResult           *PDUSessionStatus
+	PDUSessionReactivationResultErrorCause []byte
+	EAPMessage                             []byte
+}
+
+// MessageType implements Message
+func (m *ServiceAccept) MessageType() MessageType { return MsgServiceAccept }
+
+func (m *ServiceAccept) encodeBody(w *writer) {
+	if m.PDUSessionStatus != nil {
+		w.tlv(ieiPDUSessionStatus, m.PDUSessionStatus.encode())
+	}
+	if m.PDUSessionReactivationResult != nil {
+		w.tlv(ieiPDUSessionReactivation, m.PDUSessionReactivationResult.encode())
+	}
+	w.optTLVE(ieiPDUSessionReactivationError, m.PDUSessionReactivationResultErrorCause)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+}
+
+func (m *ServiceAccept) decodeBody(r *reader) (err error) {
+	opts := r.optionals(nil)
+	if m.PDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionStatus)); err != nil {
+		return err
+	}
+	if m.PDUSessionReactivationResult, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionReactivation)); err != nil {
+		return err
+	}
+	m.PDUSessionReactivationResultErrorCause = opts.bytes(ieiPDUSessionReactivationError)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	return nil
+}
+
+// ServiceReject rejects a Service Request (TS 24.501 8.2.18)
+type ServiceReject struct {
+	Cause Cause5GMM
+
+	PDUSessionStatus *PDUSessionStatus
+	T3346            *time.Duration
+	EAPMessage       []byte
+}
+
+// MessageType implements Message
+func (m *ServiceReject) MessageType() MessageType { return MsgServiceReject }
+
+func (m *ServiceReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	if m.PDUSessionStatus != nil {
+		w.tlv(ieiPDUSessionStatus, m.PDUSessionStatus.encode())
+	}
+	w.optTLV(ieiT3346, optTimer(m.T3346, timer2Units))
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+}
+
+func (m *ServiceReject) decodeBody(r *reader) (err error) {
+	m.Cause = Cause5GMM(r.uint8())
+
+	opts := r.optionals(nil)
+	if m.PDUSessionStatus, err = decodePDUSessionStatus(opts.bytes(ieiPDUSessionStatus)); err != nil {
+		return err
+	}
+	if m.T3346, err = decodeOptTimer(opts.bytes(ieiT3346), timer2Units); err != nil {
+		return err
+	}
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	return nil
+}
+
+// Configuration update indication bits
+const (
+	ConfigurationUpdateAcknowledgementRequested = 1 << 0
+	ConfigurationUpdateRegistrationRequested    = 1 << 1
+)
+
+// ConfigurationUpdateCommand updates the configuration of a registered
+// UE (TS 24.501 8.2.19)
+type ConfigurationUpdateCommand struct {
+	Indication                    *uint8
+	GUTI                          *GUTI
+	TAIList                       []models.Tai
+	AllowedNSSAI                  []models.Snssai
+	ServiceAreaList               []byte
+	FullNetworkName               []byte
+	ShortNetworkName              []byte
+	LocalTimeZone                 *uint8
+	UniversalTimeAndLocalTimeZone []byte
+	NetworkDaylightSavingTime     []byte
+	MICOIndication                *uint8
+	NetworkSlicingIndication      *uint8
+	ConfiguredNSSAI               []models.Snssai
+	RejectedNSSAI                 []byte
+	SMSIndication                 *uint8
+}
+
+// MessageType implements Message
+func (m *ConfigurationUpdateCommand) MessageType() MessageType {
+	return MsgConfigurationUpdateCommand
+}
+
+func (m *ConfigurationUpdateCommand) encodeBody(w *writer) {
+	w.optHalf(ieiConfigurationUpdate, m.Indication)
+	if m.GUTI != nil {
+		id := MobileIdentity{Type: IdentityGUTI, GUTI: m.GUTI}
+		w.tlve(ieiGUTI, w.must(id.encode()))
+	}
+	if m.TAIList != nil {
+		w.tlv(ieiTAIList, w.must(encodeTAIList(m.TAIList)))
+	}
+	if m.AllowedNSSAI != nil {
+		w.tlv(ieiAllowedNSSAI, w.must(encodeNSSAI(m.AllowedNSSAI)))
+	}
+	w.optTLV(ieiServiceAreaList, m.ServiceAreaList)
+	w.optTLV(ieiFullNetworkName, m.FullNetworkName)
+	w.optTLV(ieiShortNetworkName, m.ShortNetworkName)
+	if m.LocalTimeZone != nil {
+		w.tv(ieiLocalTimeZone, []byte{*m.LocalTimeZone})
+	}
+	if m.UniversalTimeAndLocalTimeZone != nil {
+		if len(m.UniversalTimeAndLocalTimeZone) != 7 {
+			w.fail("invalid universal time and local time zone length %d", len(m.UniversalTimeAndLocalTimeZone))
+		}
+		w.tv(ieiUniversalTimeAndTimeZone, m.UniversalTimeAndLocalTimeZone)
+	}
+	w.optTLV(ieiNetworkDaylightSavingTime, m.NetworkDaylightSavingTime)
+	w.optHalf(ieiMICOIndication, m.MICOIndication)
+	w.optHalf(ieiNetworkSlicingIndication, m.NetworkSlicingIndication)
+	if m.ConfiguredNSSAI != nil {
+		w.tlv(ieiConfiguredNSSAI, w.must(encodeNSSAI(m.ConfiguredNSSAI)))
+	}
+	w.optTLV(ieiRejectedNSSAI, m.RejectedNSSAI)
+	w.optHalf(ieiSMSIndication, m.SMSIndication)
+}
+
+func (m *ConfigurationUpdateCommand) decodeBody(r *reader) (err error) {
+	opts := r.optionals(map[uint8]int{ieiLocalTimeZone: 1, ieiUniversalTimeAndTimeZone: 7})
+	m.Indication = opts.half(ieiConfigurationUpdate)
+	if b := opts.bytes(ieiGUTI); b != nil {
+		id, err := decodeMobileIdentity(b)
+		if err != nil {
+			return err
+		}
+		m.GUTI = id.GUTI
+	}
+	if b := opts.bytes(ieiTAIList); b != nil {
+		if m.TAIList, err = decodeTAIList(b); err != nil {
+			return err
+		}
+	}
+	if b := opts.bytes(ieiAllowedNSSAI); b != nil {
+		if m.AllowedNSSAI, err = decodeNSSAI(b); err != nil {
+			return err
+		}
+	}
+	m.ServiceAreaList = opts.bytes(ieiServiceAreaList)
+	m.FullNetworkName = opts.bytes(ieiFullNetworkName)
+	m.ShortNetworkName = opts.bytes(ieiShortNetworkName)
+	if m.LocalTimeZone, err = opts.uint8(ieiLocalTimeZone); err != nil {
+		return err
+	}
+	m.UniversalTimeAndLocalTimeZone = opts.bytes(ieiUniversalTimeAndTimeZone)
+	m.NetworkDaylightSavingTime = opts.bytes(ieiNetworkDaylightSavingTime)
+	m.MICOIndication = opts.half(ieiMICOIndication)
+	m.NetworkSlicingIndication = opts.half(ieiNetworkSlicingIndication)
+	if b := opts.bytes(ieiConfiguredNSSAI); b != nil {
+		if m.ConfiguredNSSAI, err = decodeNSSAI(b); err != nil {
+			return err
+		}
+	}
+	m.RejectedNSSAI = opts.bytes(ieiRejectedNSSAI)
+	m.SMSIndication = opts.half(ieiSMSIndication)
+	return nil
+}
+
+// ConfigurationUpdateComplete acknowledges a Configuration Update Command
+// (TS 24.501 8.2.20)
+type ConfigurationUpdateComplete struct{}
+
+// MessageType implements Message
+func (m *ConfigurationUpdateComplete) MessageType() MessageType {
+	return MsgConfigurationUpdateComplete
+}
+
+func (m *ConfigurationUpdateComplete) encodeBody(w *writer) {}
+
+func (m *ConfigurationUpdateComplete) decodeBody(r *reader) error { return nil }
+
+// AuthenticationRequest starts a 5G AKA or EAP-AKA' authentication
+// (TS 24.501 8.2.1)
+type AuthenticationRequest struct {
+	NgKSI NgKSI
+	ABBA  []byte
+
+	RAND       []byte // 16 octets
+	AUTN       []byte // 16 octets
+	EAPMessage []byte
+}
+
+// MessageType implements Message
+func (m *AuthenticationRequest) MessageType() MessageType { return MsgAuthenticationRequest }
+
+func (m *AuthenticationRequest) encodeBody(w *writer) {
+	w.uint8(m.NgKSI.value())
+	w.lv(m.ABBA)
+	if m.RAND != nil {
+		if len(m.RAND) != 16 {
+			w.fail("invalid RAND length %d", len(m.RAND))
+		}
+		w.tv(ieiAuthenticationParameterRAND, m.RAND)
+	}
+	w.optTLV(ieiAuthenticationParameterAUTN, m.AUTN)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+}
+
+func (m *AuthenticationRequest) decodeBody(r *reader) error {
+	m.NgKSI = ngKSIFrom(r.uint8() & 0x0f)
+	m.ABBA = r.lv()
+
+	opts := r.optionals(map[uint8]int{ieiAuthenticationParameterRAND: 16})
+	m.RAND = opts.bytes(ieiAuthenticationParameterRAND)
+	m.AUTN = opts.bytes(ieiAuthenticationParameterAUTN)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	return nil
+}
+
+// AuthenticationResponse answers an Authentication Request (TS 24.501 8.2.2)
+type AuthenticationResponse struct {
+	RESStar    []byte // 16 octets
+	EAPMessage []byte
+}
+
+// MessageType implements Message
+func (m *AuthenticationResponse) MessageType() MessageType { return MsgAuthenticationResponse }
+
+func (m *AuthenticationResponse) encodeBody(w *writer) {
+	w.optTLV(ieiAuthenticationResponse, m.RESStar)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+}
+
+func (m *AuthenticationResponse) decodeBody(r *reader) error {
+	opts := r.optionals(nil)
+	m.RESStar = opts.bytes(ieiAuthenticationResponse)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	return nil
+}
+
+// AuthenticationReject ends a failed authentication (TS 24.501 8.2.5)
+type AuthenticationReject struct {
+	EAPMessage []byte
+}
+
+// MessageType implements Message
+func (m *AuthenticationReject) MessageType() MessageType { return MsgAuthenticationReject }
+
+func (m *AuthenticationReject) encodeBody(w *writer) {
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+}
+
+func (m *AuthenticationReject) decodeBody(r *reader) error {
+	m.EAPMessage = r.optionals(nil).bytes(ieiEAPMessage)
+	return nil
+}
+
+// AuthenticationFailure reports a network authentication failure from
+// the UE (TS 24.501 8.2.4)
+type AuthenticationFailure struct {
+	Cause Cause5GMM
+
+	// AUTS is the resynchronisation token sent with a synch failure
+	AUTS []byte
+}
+
+// MessageType implements Message
+func (m *AuthenticationFailure) MessageType() MessageType { return MsgAuthenticationFailure }
+
+func (m *AuthenticationFailure) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLV(ieiAuthenticationFailure, m.AUTS)
+}
+
+func (m *AuthenticationFailure) decodeBody(r *reader) error {
+	m.Cause = Cause5GMM(r.uint8())
+	m.AUTS = r.optionals(nil).bytes(ieiAuthenticationFailure)
+	return nil
+}
+
+// IdentityRequest asks the UE for an identity (TS 24.501 8.2.21)
+type IdentityRequest struct {
+	IdentityType IdentityType
+}
+
+// MessageType implements Message
+func (m *IdentityRequest) MessageType() MessageType { return MsgIdentityRequest }
+
+func (m *IdentityRequest) encodeBody(w *writer) {
+	w.uint8(uint8(m.IdentityType) & 7)
+}
+
+func (m *IdentityRequest) decodeBody(r *reader) error {
+	m.IdentityType = IdentityType(r.uint8() & 7)
+	return nil
+}
+
+// IdentityResponse answers an Identity Request (TS 24.501 8.2.22)
+type IdentityResponse struct {
+	MobileIdentity MobileIdentity
+}
+
+// MessageType implements Message
+func (m *IdentityResponse) MessageType() MessageType { return MsgIdentityResponse }
+
+func (m *IdentityResponse) encodeBody(w *writer) {
+	w.lve(w.must(m.MobileIdentity.encode()))
+}
+
+func (m *IdentityResponse) decodeBody(r *reader) (err error) {
+	m.MobileIdentity, err = decodeMobileIdentity(r.lve())
+	return err
+}
+
+// SecurityModeCommand activates NAS security (TS 24.501 8.2.25)
+type SecurityModeCommand struct {
+	SelectedAlgorithms           SecurityAlgorithms
+	NgKSI                        NgKSI
+	ReplayedUESecurityCapability UESecurityCapability
+
+	IMEISVRequest            bool
+	SelectedEPSAlgorithms    *uint8
+	Additional5GSecurityInfo []byte
+	EAPMessage               []byte
+	ABBA                     []byte
+}
+
+// MessageType implements Message
+func (m *SecurityModeCommand) MessageType() MessageType { return MsgSecurityModeCommand }
+
+func (m *SecurityModeCommand) encodeBody(w *writer) {
+	w.uint8(m.SelectedAlgorithms.Ciphering<<4 | m.SelectedAlgorithms.Integrity&0x0f)
+	w.uint8(m.NgKSI.value())
+	w.lv(m.ReplayedUESecurityCapability.encode())
+
+	if m.IMEISVRequest {
+		w.half(ieiIMEISVRequest, 1)
+	}
+	if m.SelectedEPSAlgorithms != nil {
+		w.tv(ieiSelectedEPSAlgorithms, []byte{*m.SelectedEPSAlgorithms})
+	}
+	w.optTLV(ieiAdditional5GSecurityInfo, m.Additional5GSecurityInfo)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	w.optTLV(ieiSecurityABBA, m.ABBA)
+}
+
+func (m *SecurityModeCommand) decodeBody(r *reader) (err error) {
+	v := r.uint8()
+	m.SelectedAlgorithms = SecurityAlgorithms{Ciphering: v >> 4, Integrity: v & 0x0f}
+	m.NgKSI = ngKSIFrom(r.uint8() & 0x0f)
+	if m.ReplayedUESecurityCapability, err = decodeUESecurityCapability(r.lv()); err != nil {
+		return err
+	}
+
+	opts := r.optionals(map[uint8]int{ieiSelectedEPSAlgorithms: 1})
+	if v := opts.half(ieiIMEISVRequest); v != nil {
+		m.IMEISVRequest = *v&1 != 0
+	}
+	if m.SelectedEPSAlgorithms, err = opts.uint8(ieiSelectedEPSAlgorithms); err != nil {
+		return err
+	}
+	m.Additional5GSecurityInfo = opts.bytes(ieiAdditional5GSecurityInfo)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.ABBA = opts.bytes(ieiSecurityABBA)
+	return nil
+}
+
+// SecurityModeComplete acknowledges a Security Mode Command (TS 24.501
+// 8.2.26)
+type SecurityModeComplete struct {
+	IMEISV *MobileIdentity
+
+	// NASMessageContainer holds the complete initial message when the UE
+	// first sent only its cleartext IEs
+	NASMessageContainer []byte
+}
+
+// MessageType implements Message
+func (m *SecurityModeComplete) MessageType() MessageType { return MsgSecurityModeComplete }
+
+func (m *SecurityModeComplete) encodeBody(w *writer) {
+	if m.IMEISV != nil {
+		w.tlve(ieiIMEISV, w.must(m.IMEISV.encode()))
+	}
+	w.optTLVE(ieiNASMessageContainer, m.NASMessageContainer)
+}
+
+func (m *SecurityModeComplete) decodeBody(r *reader) error {
+	opts := r.optionals(nil)
+	if b := opts.bytes(ieiIMEISV); b != nil {
+		id, err := decodeMobileIdentity(b)
+		if err != nil {
+			return err
+		}
+		m.IMEISV = &id
+	}
+	m.NASMessageContainer = opts.bytes(ieiNASMessageContainer)
+	return nil
+}
+
+// SecurityModeReject rejects a Security Mode Command (TS 24.501 8.2.27)
+type SecurityModeReject struct {
+	Cause Cause5GMM
+}
+
+// MessageType implements Message
+func (m *SecurityModeReject) MessageType() MessageType { return MsgSecurityModeReject }
+
+func (m *SecurityModeReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+}
+
+func (m *SecurityModeReject) decodeBody(r *reader) error {
+	m.Cause = Cause5GMM(r.uint8())
+	return nil
+}
+
+// Status5GMM reports an error in a received 5GMM message (TS 24.501
+// 8.2.29)
+type Status5GMM struct {
+	Cause Cause5GMM
+}
+
+// MessageType implements Message
+func (m *Status5GMM) MessageType() MessageType { return MsgStatus5GMM }
+
+func (m *Status5GMM) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+}
+
+func (m *Status5GMM) decodeBody(r *reader) error {
+	m.Cause = Cause5GMM(r.uint8())
+	return nil
+}
+
+// PayloadContainerType is the type of a NAS transport payload (TS 24.501
+// 9.11.3.40)
+type PayloadContainerType uint8
+
+const (
+	PayloadContainerN1SMInformation PayloadContainerType = 1
+	PayloadContainerSMS             PayloadContainerType = 2
+	PayloadContainerLPP             PayloadContainerType = 3
+	PayloadContainerSORTransparent  PayloadContainerType = 4
+	PayloadContainerUEPolicy        PayloadContainerType = 5
+	PayloadContainerUEParameters    PayloadContainerType = 6
+	PayloadContainerMultiple        PayloadContainerType = 15
+)
+
+// Request types of a UL NAS Transport (TS 24.501 9.11.3.47)
+const (
+	RequestTypeInitialRequest          = 1
+	RequestTypeExistingPDUSession      = 2
+	RequestTypeInitialEmergencyRequest = 3
+	RequestTypeExistingEmergency       = 4
+	RequestTypeModificationRequest     = 5
+	RequestTypeMAPDURequest            = 6
+)
+
+// ULNASTransport carries a payload, such as a 5GSM message, from the UE
+// (TS 24.501 8.2.10)
+type ULNASTransport struct {
+	PayloadContainerType PayloadContainerType
+	PayloadContainer     []byte
+
+	PDUSessionID          *uint8
+	OldPDUSessionID       *uint8
+	RequestType           *uint8
+	SNSSAI                *models.Snssai
+	DNN                   string
+	AdditionalInformation []byte
+}
+
+// MessageType implements Message
+func (m *ULNASTransport) MessageType() MessageType { return MsgULNASTransport }
+
+func (m *ULNASTransport) encodeBody(w *writer) {
+	w.uint8(uint8(m.PayloadContainerType) & 0x0f)
+	w.lve(m.PayloadContainer)
+
+	if m.PDUSessionID != nil {
+		w.tv(ieiPDUSessionID, []byte{*m.PDUSessionID})
+	}
+	if m.OldPDUSessionID != nil {
+		w.tv(ieiOldPDUSessionID, []byte{*m.OldPDUSessionID})
+	}
+	w.optHalf(ieiRequestType, m.RequestType)
+	if m.SNSSAI != nil {
+		w.tlv(ieiSNSSAI, w.must(encodeSNSSAI(*m.SNSSAI)))
+	}
+	if m.DNN != "" {
+		w.tlv(ieiDNN, w.must(encodeDNN(m.DNN)))
+	}
+	w.optTLV(ieiAdditionalInformation, m.AdditionalInformation)
+}
+
+func (m *ULNASTransport) decodeBody(r *reader) (err error) {
+	m.PayloadContainerType = PayloadContainerType(r.uint8() & 0x0f)
+	m.PayloadContainer = r.lve()
+
+	opts := r.optionals(map[uint8]int{ieiPDUSessionID: 1, ieiOldPDUSessionID: 1})
+	if m.PDUSessionID, err = opts.uint8(ieiPDUSessionID); err != nil {
+		return err
+	}
+	if m.OldPDUSessionID, err = opts.uint8(ieiOldPDUSessionID); err != nil {
+		return err
+	}
+	m.RequestType = opts.half(ieiRequestType)
+	if b := opts.bytes(ieiSNSSAI); b != nil {
+		s, err := decodeSNSSAI(b)
+		if err != nil {
+			return err
+		}
+		m.SNSSAI = &s
+	}
+	if b := opts.bytes(ieiDNN); b != nil {
+		if m.DNN, err = decodeDNN(b); err != nil {
+			return err
+		}
+	}
+	m.AdditionalInformation = opts.bytes(ieiAdditionalInformation)
+	return nil
+}
+
+// DLNASTransport carries a payload, such as a 5GSM message, to the UE
+// (TS 24.501 8.2.11)
+type DLNASTransport struct {
+	PayloadContainerType PayloadContainerType
+	PayloadContainer     []byte
+
+	PDUSessionID          *uint8
+	AdditionalInformation []byte
+	Cause                 *Cause5GMM
+	BackoffTimer          *time.Duration
+}
+
+// MessageType implements Message
+func (m *DLNASTransport) MessageType() MessageType { return MsgDLNASTransport }
+
+func (m *DLNASTransport) encodeBody(w *writer) {
+	w.uint8(uint8(m.PayloadContainerType) & 0x0f)
+	w.lve(m.PayloadContainer)
+
+	if m.PDUSessionID != nil {
+		w.tv(ieiPDUSessionID, []byte{*m.PDUSessionID})
+	}
+	w.optTLV(ieiAdditionalInformation, m.AdditionalInformation)
+	if m.Cause != nil {
+		w.tv(ieiCause5GMM, optCause(m.Cause))
+	}
+	w.optTLV(ieiBackoffTimer, optTimer(m.BackoffTimer, timer3Units))
+}
+
+func (m *DLNASTransport) decodeBody(r *reader) (err error) {
+	m.PayloadContainerType = PayloadContainerType(r.uint8() & 0x0f)
+	m.PayloadContainer = r.lve()
+
+	opts := r.optionals(map[uint8]int{ieiPDUSessionID: 1, ieiCause5GMM: 1})
+	if m.PDUSessionID, err = opts.uint8(ieiPDUSessionID); err != nil {
+		return err
+	}
+	m.AdditionalInformation = opts.bytes(ieiAdditionalInformation)
+	m.Cause = decodeOptCause[Cause5GMM](opts.bytes(ieiCause5GMM))
+	m.BackoffTimer, err = decodeOptTimer(opts.bytes(ieiBackoffTimer), timer3Units)
+	return err
+}
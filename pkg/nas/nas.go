@@ -0,0 +1,245 @@
+// Package nas implements the 5GS Non-Access-Stratum messages of TS 24.501
+// exchanged between the UE and the AMF (5GMM) or the SMF (5GSM).
+package nas
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExtendedProtocolDiscriminator identifies the NAS protocol of a message
+type ExtendedProtocolDiscriminator uint8
+
+const (
+	EPD5GSM ExtendedProtocolDiscriminator = 0x2e
+	EPD5GMM ExtendedProtocolDiscriminator = 0x7e
+)
+
+// SecurityHeaderType tells how a 5GMM message is protected
+type SecurityHeaderType uint8
+
+const (
+	SecurityHeaderPlain                                       SecurityHeaderType = 0
+	SecurityHeaderIntegrityProtected                          SecurityHeaderType = 1
+	SecurityHeaderIntegrityProtectedAndCiphered               SecurityHeaderType = 2
+	SecurityHeaderIntegrityProtectedWithNewContext            SecurityHeaderType = 3
+	SecurityHeaderIntegrityProtectedAndCipheredWithNewContext SecurityHeaderType = 4
+)
+
+// MessageType identifies a 5GMM or 5GSM message
+type MessageType uint8
+
+// 5GMM message types (TS 24.501 9.7)
+const (
+	MsgRegistrationRequest         MessageType = 0x41
+	MsgRegistrationAccept          MessageType = 0x42
+	MsgRegistrationComplete        MessageType = 0x43
+	MsgRegistrationReject          MessageType = 0x44
+	MsgDeregistrationRequestUEOrig MessageType = 0x45
+	MsgDeregistrationAcceptUEOrig  MessageType = 0x46
+	MsgDeregistrationRequestUETerm MessageType = 0x47
+	MsgDeregistrationAcceptUETerm  MessageType = 0x48
+	MsgServiceRequest              MessageType = 0x4c
+	MsgServiceReject               MessageType = 0x4d
+	MsgServiceAccept               MessageType = 0x4e
+	MsgConfigurationUpdateCommand  MessageType = 0x54
+	MsgConfigurationUpdateComplete MessageType = 0x55
+	MsgAuthenticationRequest       MessageType = 0x56
+	MsgAuthenticationResponse      MessageType = 0x57
+	MsgAuthenticationReject        MessageType = 0x58
+	MsgAuthenticationFailure       MessageType = 0x59
+	MsgIdentityRequest             MessageType = 0x5b
+	MsgIdentityResponse            MessageType = 0x5c
+	MsgSecurityModeCommand         MessageType = 0x5d
+	MsgSecurityModeComplete        MessageType = 0x5e
+	MsgSecurityModeReject          MessageType = 0x5f
+	MsgStatus5GMM                  MessageType = 0x64
+	MsgULNASTransport              MessageType = 0x67
+	MsgDLNASTransport              MessageType = 0x68
+)
+
+// 5GSM message types (TS 24.501 9.7)
+const (
+	MsgPDUSessionEstablishmentRequest      MessageType = 0xc1
+	MsgPDUSessionEstablishmentAccept       MessageType = 0xc2
+	MsgPDUSessionEstablishmentReject       MessageType = 0xc3
+	MsgPDUSessionModificationRequest       MessageType = 0xc9
+	MsgPDUSessionModificationReject        MessageType = 0xca
+	MsgPDUSessionModificationCommand       MessageType = 0xcb
+	MsgPDUSessionModificationComplete      MessageType = 0xcc
+	MsgPDUSessionModificationCommandReject MessageType = 0xcd
+	MsgPDUSessionReleaseRequest            MessageType = 0xd1
+	MsgPDUSessionReleaseReject             MessageType = 0xd2
+	MsgPDUSessionReleaseCommand            MessageType = 0xd3
+	MsgPDUSessionReleaseComplete           MessageType = 0xd4
+	MsgStatus5GSM                          MessageType = 0xd6
+)
+
+// Errors returned when decoding
+var (
+	// ErrTruncated is returned when a message ends in the middle of an IE
+	ErrTruncated = errors.New("nas: message truncated")
+
+	// ErrUnsupportedMessage is returned for message types this package
+	// does not implement
+	ErrUnsupportedMessage = errors.New("nas: unsupported message")
+
+	// ErrSecurityProtected is returned by Decode for a security protected
+	// 5GMM message, which must be unwrapped first
+	ErrSecurityProtected = errors.New("nas: message is security protected")
+)
+
+// Message is a plain 5GMM or 5GSM message
+type Message interface {
+	// MessageType returns the message type
+	MessageType() MessageType
+
+	encodeBody(*writer)
+	decodeBody(*reader) error
+}
+
+// SMMessage is a 5GSM message, carrying the header of its PDU session
+type SMMessage interface {
+	Message
+
+	// Header returns the PDU session header of the message
+	Header() *SMHeader
+}
+
+// SMHeader is the header of a 5GSM message
+type SMHeader struct {
+	PDUSessionID uint8
+
+	// ProcedureTransactionID matches UE requested procedures with the
+	// network answers, 0 when unassigned
+	ProcedureTransactionID uint8
+}
+
+// Header implements SMMessage
+func (h *SMHeader) Header() *SMHeader {
+	return h
+}
+
+// messageFactories creates empty messages for decoding
+var messageFactories = map[MessageType]func() Message{}
+
+// register makes a message type decodable
+func register(factory func() Message) {
+	messageFactories[factory().MessageType()] = factory
+}
+
+// Encode encodes a plain NAS message
+func Encode(msg Message) ([]byte, error) {
+	w := &writer{}
+	if sm, ok := msg.(SMMessage); ok {
+		h := sm.Header()
+		w.uint8(uint8(EPD5GSM))
+		w.uint8(h.PDUSessionID)
+		w.uint8(h.ProcedureTransactionID)
+	} else {
+		w.uint8(uint8(EPD5GMM))
+		w.uint8(uint8(SecurityHeaderPlain))
+	}
+	w.uint8(uint8(msg.MessageType()))
+
+	msg.encodeBody(w)
+	if w.err != nil {
+		return nil, fmt.Errorf("nas: encoding %T: %w", msg, w.err)
+	}
+	return w.b, nil
+}
+
+// Decode decodes a plain NAS message
+func Decode(b []byte) (Message, error) {
+	if len(b) < 3 {
+		return nil, ErrTruncated
+	}
+
+	var h SMHeader
+	var messageType MessageType
+	var body []byte
+	switch ExtendedProtocolDiscriminator(b[0]) {
+	case EPD5GMM:
+		if SecurityHeaderType(b[1]&0x0f) != SecurityHeaderPlain {
+			return nil, ErrSecurityProtected
+		}
+		messageType, body = MessageType(b[2]), b[3:]
+	case EPD5GSM:
+		if len(b) < 4 {
+			return nil, ErrTruncated
+		}
+		h = SMHeader{PDUSessionID: b[1], ProcedureTransactionID: b[2]}
+		messageType, body = MessageType(b[3]), b[4:]
+	default:
+		return nil, fmt.Errorf("%w: protocol discriminator %#x", ErrUnsupportedMessage, b[0])
+	}
+
+	factory, ok := messageFactories[messageType]
+	if !ok {
+		return nil, fmt.Errorf("%w: message type %#x", ErrUnsupportedMessage, uint8(messageType))
+	}
+	msg := factory()
+	if sm, ok := msg.(SMMessage); ok != (ExtendedProtocolDiscriminator(b[0]) == EPD5GSM) {
+		return nil, fmt.Errorf("%w: message type %#x with protocol discriminator %#x",
+			ErrUnsupportedMessage, uint8(messageType), b[0])
+	} else if ok {
+		*sm.Header() = h
+	}
+
+	r := &reader{b: body}
+	if err := msg.decodeBody(r); err != nil {
+		return nil, fmt.Errorf("nas: decoding %T: %w", msg, err)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("nas: decoding %T: %w", msg, r.err)
+	}
+	return msg, nil
+}
+
+// SecurityProtectedMessage is a 5GMM message wrapped in a security header
+type SecurityProtectedMessage struct {
+	HeaderType SecurityHeaderType
+
+	// MAC is the message authentication code
+	MAC [4]byte
+
+	// SequenceNumber is the low octet of the NAS COUNT
+	SequenceNumber uint8
+
+	// Payload is the plain NAS message, ciphered depending on HeaderType
+	Payload []byte
+}
+
+// IsSecurityProtected reports whether b is a security protected 5GMM
+// message
+func IsSecurityProtected(b []byte) bool {
+	return len(b) >= 2 && ExtendedProtocolDiscriminator(b[0]) == EPD5GMM &&
+		SecurityHeaderType(b[1]&0x0f) != SecurityHeaderPlain
+}
+
+// DecodeSecurityProtected splits a security protected 5GMM message
+func DecodeSecurityProtected(b []byte) (*SecurityProtectedMessage, error) {
+	if !IsSecurityProtected(b) {
+		return nil, fmt.Errorf("nas: message is not security protected")
+	}
+	if len(b) < 7 {
+		return nil, ErrTruncated
+	}
+
+	m := &SecurityProtectedMessage{
+		HeaderType:     SecurityHeaderType(b[1] & 0x0f),
+		SequenceNumber: b[6],
+		Payload:        b[7:],
+	}
+	copy(m.MAC[:], b[2:6])
+	return m, nil
+}
+
+// Encode encodes the security protected message
+func (m *SecurityProtectedMessage) Encode() []byte {
+	b := make([]byte, 0, 7+len(m.Payload))
+	b = append(b, uint8(EPD5GMM), uint8(m.HeaderType))
+	b = append(b, m.MAC[:]...)
+	b = append(b, m.SequenceNumber)
+	return append(b, m.Payload...)
+}
@@ -0,0 +1,373 @@
+package nas
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+var (
+	testPLMN   = models.PlmnID{Mcc: "208", Mnc: "93"}
+	testSNSSAI = models.Snssai{Sst: 1, Sd: "010203"}
+	testGUTI   = GUTI{PlmnID: testPLMN, AMFRegionID: 2, AMFSetID: 1, TMSI: 1}
+)
+
+// Encodings of the test values used by several messages
+const (
+	plmnHex     = "02f839"
+	gutiHex     = "f2 " + plmnHex + " 02 0040 00000001" // region 2, set 1, pointer 0, 5G-TMSI 1
+	snssaiHex   = "04 01 010203"
+	internetHex = "08 696e7465726e6574"
+	capHex      = "04 f0f0f0f0" // 5G-EA0-3, 5G-IA0-3, EEA0-3, EIA0-3
+
+	// randHex is the RAND of TS 35.208 test set 1
+	randHex = "23553cbe9637a89d218ae64dae47bf35"
+	autnHex = "55f328b43577b9b94a9ffac354dfafb3"
+	resHex  = "a54211d5e3ba50bf4a0e4c5bf2b0a2f4"
+
+	// pduSessionEstablishmentRequestHex asks for IPv4 PDU session 1 in
+	// SSC mode 1, PTI 1
+	pduSessionEstablishmentRequestHex = "2e 01 01 c1 ffff 91 a1"
+)
+
+// unhex decodes an encoding written as hex parts with optional spaces
+func unhex(t *testing.T, parts ...string) []byte {
+	t.Helper()
+
+	s := strings.ReplaceAll(strings.Join(parts, ""), " ", "")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad encoding %q: %v", s, err)
+	}
+	return b
+}
+
+// mustUnhex decodes a hex string of the test values
+func mustUnhex(s string) []byte {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// The encodings are laid out header first, then IE by IE: IEI, length,
+// then the value
+var codecTests = []struct {
+	name string
+	pdu  []string
+	msg  Message
+}{
+	{
+		name: "registration request, initial with SUCI",
+		pdu: []string{
+			"7e 00 41",
+			"79", // ngKSI no key, follow-on request, initial
+			"000d 01 " + plmnHex + " 0000 00 00 0000000010", // null scheme SUCI, routing indicator 0, MSIN 0000000001
+			"2e " + capHex,       // UE security capability
+			"2f 05 " + snssaiHex, // requested NSSAI
+		},
+		msg: &RegistrationRequest{
+			RegistrationType: RegistrationTypeInitial,
+			FollowOnRequest:  true,
+			NgKSI:            NgKSI{KSI: NoKeyAvailable},
+			MobileIdentity: MobileIdentity{Type: IdentitySUCI, SUCI: &SUCI{
+				SUPIFormat:       SUPIFormatIMSI,
+				PlmnID:           testPLMN,
+				RoutingIndicator: "0000",
+				ProtectionScheme: ProtectionSchemeNull,
+				SchemeOutput:     []byte{0x00, 0x00, 0x00, 0x00, 0x10},
+			}},
+			UESecurityCapability: &UESecurityCapability{EA: 0xf0, IA: 0xf0, EEA: 0xf0, EIA: 0xf0, EPS: true},
+			RequestedNSSAI:       []models.Snssai{testSNSSAI},
+		},
+	},
+	{
+		name: "registration request, mobility updating with 5G-GUTI",
+		pdu: []string{
+			"7e 00 41",
+			"02",              // ngKSI 0, mobility updating
+			"000b " + gutiHex, // 5G-GUTI
+			"40 02 2000",      // uplink data status, PSI 5
+			"50 02 2000",      // PDU session status, PSI 5
+		},
+		msg: &RegistrationRequest{
+			RegistrationType: RegistrationTypeMobilityUpdating,
+			MobileIdentity:   MobileIdentity{Type: IdentityGUTI, GUTI: &testGUTI},
+			UplinkDataStatus: ptr(PDUSessionStatus(1 << 5)),
+			PDUSessionStatus: ptr(PDUSessionStatus(1 << 5)),
+		},
+	},
+	{
+		name: "registration accept",
+		pdu: []string{
+			"7e 00 42",
+			"01 09",                           // 3GPP access, SMS allowed
+			"77 000b " + gutiHex,              // 5G-GUTI
+			"54 07 00 " + plmnHex + " 000001", // TAI list, TAC 1
+			"15 05 " + snssaiHex,              // allowed NSSAI
+			"5e 01 06",                        // T3512 6 x 10 minutes
+		},
+		msg: &RegistrationAccept{
+			Result:       RegistrationResult{Access: Access3GPP, SMSAllowed: true},
+			GUTI:         &testGUTI,
+			TAIList:      []models.Tai{models.NewTai(testPLMN, 1)},
+			AllowedNSSAI: []models.Snssai{testSNSSAI},
+			T3512:        ptr(time.Hour),
+		},
+	},
+	{
+		name: "authentication request",
+		pdu: []string{
+			"7e 00 56",
+			"00",           // ngKSI 0
+			"02 0000",      // ABBA
+			"21" + randHex, // RAND
+			"20 10" + autnHex,
+		},
+		msg: &AuthenticationRequest{
+			ABBA: []byte{0x00, 0x00},
+			RAND: mustUnhex(randHex),
+			AUTN: mustUnhex(autnHex),
+		},
+	},
+	{
+		name: "authentication response",
+		pdu: []string{
+			"7e 00 57",
+			"2d 10" + resHex, // RES*
+		},
+		msg: &AuthenticationResponse{RESStar: mustUnhex(resHex)},
+	},
+	{
+		name: "security mode command",
+		pdu: []string{
+			"7e 00 5d",
+			"02",       // 5G-EA0, 128-5G-IA2
+			"00",       // ngKSI 0
+			capHex,     // replayed UE security capability
+			"e1",       // IMEISV requested
+			"36 01 01", // additional 5G security information, RINMR
+			"38 02 0000",
+		},
+		msg: &SecurityModeCommand{
+			SelectedAlgorithms:           SecurityAlgorithms{Integrity: 2},
+			ReplayedUESecurityCapability: UESecurityCapability{EA: 0xf0, IA: 0xf0, EEA: 0xf0, EIA: 0xf0, EPS: true},
+			IMEISVRequest:                true,
+			Additional5GSecurityInfo:     []byte{0x01},
+			ABBA:                         []byte{0x00, 0x00},
+		},
+	},
+	{
+		name: "security mode complete",
+		pdu: []string{
+			"7e 00 5e",
+			"77 0009 45 73 80 61 21 85 61 51 f1", // IMEISV 4370816125816151
+			"71 0003 7e 00 43",                   // NAS message container
+		},
+		msg: &SecurityModeComplete{
+			IMEISV:              &MobileIdentity{Type: IdentityIMEISV, PEI: "4370816125816151"},
+			NASMessageContainer: []byte{0x7e, 0x00, 0x43},
+		},
+	},
+	{
+		name: "UL NAS transport of a PDU session establishment request",
+		pdu: []string{
+			"7e 00 67",
+			"01", // N1 SM information
+			"0008 " + pduSessionEstablishmentRequestHex,
+			"12 01",                // PDU session ID 1
+			"81",                   // initial request
+			"22 " + snssaiHex,      // S-NSSAI
+			"25 09 " + internetHex, // DNN
+		},
+		msg: &ULNASTransport{
+			PayloadContainerType: PayloadContainerN1SMInformation,
+			PayloadContainer:     mustUnhex(pduSessionEstablishmentRequestHex),
+			PDUSessionID:         ptr[uint8](1),
+			RequestType:          ptr[uint8](1),
+			SNSSAI:               &testSNSSAI,
+			DNN:                  "internet",
+		},
+	},
+	{
+		name: "PDU session establishment request",
+		pdu:  []string{pduSessionEstablishmentRequestHex},
+		msg: &PDUSessionEstablishmentRequest{
+			SMHeader: SMHeader{PDUSessionID: 1, ProcedureTransactionID: 1},
+			IntegrityProtectionMaxDataRate: IntegrityProtectionMaxDataRate{
+				Uplink:   IntegrityProtectionMaxDataRateFull,
+				Downlink: IntegrityProtectionMaxDataRateFull,
+			},
+			PDUSessionType: ptr(PDUSessionTypeIPv4),
+			SSCMode:        ptr(SSCMode1),
+		},
+	},
+	{
+		name: "PDU session establishment accept",
+		pdu: []string{
+			"2e 01 01 c2",
+			"11",                             // SSC mode 1, IPv4
+			"0009 01 0006 31 31 01 01 ff 09", // default QoS rule 1, match all, QFI 9
+			"06 03 f424 03 7a12",             // session AMBR 1 Gbps DL, 500 Mbps UL
+			"29 05 01 0a3c0001",              // PDU address 10.60.0.1
+			"22 " + snssaiHex,                // S-NSSAI
+			"79 0006 09 20 41 01 01 09",      // QoS flow 9, 5QI 9
+			"25 09 " + internetHex,           // DNN
+		},
+		msg: &PDUSessionEstablishmentAccept{
+			SMHeader:                      SMHeader{PDUSessionID: 1, ProcedureTransactionID: 1},
+			PDUSessionType:                PDUSessionTypeIPv4,
+			SSCMode:                       SSCMode1,
+			AuthorizedQoSRules:            mustUnhex("01 0006 31 31 01 01 ff 09"),
+			SessionAMBR:                   SessionAMBR{Downlink: 1000000000, Uplink: 500000000},
+			PDUAddress:                    &PDUAddress{Type: PDUSessionTypeIPv4, IPv4: net.IPv4(10, 60, 0, 1)},
+			SNSSAI:                        &testSNSSAI,
+			AuthorizedQoSFlowDescriptions: mustUnhex("09 20 41 01 01 09"),
+			DNN:                           "internet",
+		},
+	},
+}
+
+func TestCodec(t *testing.T) {
+	for _, tt := range codecTests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := unhex(t, tt.pdu...)
+
+			msg, err := Decode(pdu)
+			if err != nil {
+				t.Fatalf("Decode() error: %v", err)
+			}
+			if !reflect.DeepEqual(msg, tt.msg) {
+				t.Errorf("Decode() = %+v, want %+v", msg, tt.msg)
+			}
+
+			b, err := Encode(msg)
+			if err != nil {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			if !bytes.Equal(b, pdu) {
+				t.Errorf("Encode() = %x, want %x", b, pdu)
+			}
+		})
+	}
+}
+
+func TestQoSRules(t *testing.T) {
+	b := unhex(t,
+		"01 0006 31 31 01 01 ff 09", // default QoS rule 1, match all, QFI 9
+		"02 0001 40",                // delete QoS rule 2
+	)
+	want := QoSRules{
+		{
+			ID:            1,
+			Operation:     QoSRuleCreate,
+			Default:       true,
+			PacketFilters: []PacketFilter{{ID: 1, Direction: PacketFilterBidirectional, Components: PacketFilterMatchAll}},
+			Precedence:    0xff,
+			QFI:           9,
+		},
+		{ID: 2, Operation: QoSRuleDelete},
+	}
+
+	rules, err := DecodeQoSRules(b)
+	if err != nil {
+		t.Fatalf("DecodeQoSRules() error: %v", err)
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("DecodeQoSRules() = %+v, want %+v", rules, want)
+	}
+	if got, err := want.Bytes(); err != nil || !bytes.Equal(got, b) {
+		t.Errorf("Bytes() = %x, %v, want %x", got, err, b)
+	}
+}
+
+func TestQoSFlowDescriptions(t *testing.T) {
+	b := unhex(t,
+		"09 20 41 01 01 09",           // create QoS flow 9, 5QI 9
+		"05 20 45 01 01 01",           // create QoS flow 5, 5QI 1
+		"02 03 01 0064 03 03 01 00c8", // GFBR 100 kbps UL, 200 kbps DL
+		"04 03 01 00c8 05 03 01 0190", // MFBR 200 kbps UL, 400 kbps DL
+		"06 40 00",                    // delete QoS flow 6
+	)
+	want := QoSFlowDescriptions{
+		{QFI: 9, Operation: QoSFlowCreate, FiveQI: 9},
+		{
+			QFI: 5, Operation: QoSFlowCreate, FiveQI: 1,
+			GFBRUplink: 100000, GFBRDownlink: 200000,
+			MFBRUplink: 200000, MFBRDownlink: 400000,
+		},
+		{QFI: 6, Operation: QoSFlowDelete},
+	}
+
+	flows, err := DecodeQoSFlowDescriptions(b)
+	if err != nil {
+		t.Fatalf("DecodeQoSFlowDescriptions() error: %v", err)
+	}
+	if !reflect.DeepEqual(flows, want) {
+		t.Errorf("DecodeQoSFlowDescriptions() = %+v, want %+v", flows, want)
+	}
+	if got, err := want.Bytes(); err != nil || !bytes.Equal(got, b) {
+		t.Errorf("Bytes() = %x, %v, want %x", got, err, b)
+	}
+}
+
+func TestSecurityProtected(t *testing.T) {
+	b := unhex(t, "7e 02 01020304 05", "7e 00 43")
+
+	if !IsSecurityProtected(b) {
+		t.Fatal("IsSecurityProtected() = false")
+	}
+	if _, err := Decode(b); !errors.Is(err, ErrSecurityProtected) {
+		t.Errorf("Decode() error = %v, want %v", err, ErrSecurityProtected)
+	}
+	m, err := DecodeSecurityProtected(b)
+	if err != nil {
+		t.Fatalf("DecodeSecurityProtected() error: %v", err)
+	}
+	want := &SecurityProtectedMessage{
+		HeaderType:     SecurityHeaderIntegrityProtectedAndCiphered,
+		MAC:            [4]byte{1, 2, 3, 4},
+		SequenceNumber: 5,
+		Payload:        []byte{0x7e, 0x00, 0x43},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("DecodeSecurityProtected() = %+v, want %+v", m, want)
+	}
+	if got := m.Encode(); !bytes.Equal(got, b) {
+		t.Errorf("Encode() = %x, want %x", got, b)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pdu  string
+	}{
+		{name: "truncated header", pdu: "7e 00"},
+		{name: "unknown protocol", pdu: "0f 00 41"},
+		{name: "unknown message", pdu: "7e 00 ff"},
+		{name: "5GSM message in a 5GMM header", pdu: "7e 00 c1 ffff"},
+		{name: "truncated mobile identity", pdu: "7e 00 41 79 000d 01" + plmnHex},
+		{name: "truncated optional IE", pdu: "7e 00 57 2d 10 a542"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg, err := Decode(unhex(t, tt.pdu)); err == nil {
+				t.Errorf("Decode() = %+v, want an error", msg)
+			}
+		})
+	}
+}
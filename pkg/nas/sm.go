@@ -0,0 +1,602 @@
+package nas
+
+import (
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+func init() {
+	register(func() Message { return &PDUSessionEstablishmentRequest{} })
+	register(func() Message { return &PDUSessionEstablishmentAccept{} })
+	register(func() Message { return &PDUSessionEstablishmentReject{} })
+	register(func() Message { return &PDUSessionModificationRequest{} })
+	register(func() Message { return &PDUSessionModificationReject{} })
+	register(func() Message { return &PDUSessionModificationCommand{} })
+	register(func() Message { return &PDUSessionModificationComplete{} })
+	register(func() Message { return &PDUSessionModificationCommandReject{} })
+	register(func() Message { return &PDUSessionReleaseRequest{} })
+	register(func() Message { return &PDUSessionReleaseReject{} })
+	register(func() Message { return &PDUSessionReleaseCommand{} })
+	register(func() Message { return &PDUSessionReleaseComplete{} })
+	register(func() Message { return &Status5GSM{} })
+}
+
+// 5GSM IEIs
+const (
+	ieiPDUSessionType             = 0x90
+	ieiSSCMode                    = 0xa0
+	ieiCapability5GSM             = 0x28
+	ieiMaxPacketFilters           = 0x55
+	ieiAlwaysOnRequested          = 0xb0
+	ieiAlwaysOnIndication         = 0x80
+	ieiSMPDUDNRequestContainer    = 0x39
+	ieiExtendedPCO                = 0x7b
+	ieiCause5GSM                  = 0x59
+	ieiPDUAddress                 = 0x29
+	ieiRQTimer                    = 0x56
+	ieiMappedEPSBearerContexts    = 0x75
+	ieiAuthorizedQoSFlows         = 0x79
+	ieiAuthorizedQoSRules         = 0x7a
+	ieiSessionAMBR                = 0x2a
+	ieiIntegrityProtectionMaxRate = 0x13
+	ieiAllowedSSCMode             = 0xf0
+	ieiAccessType                 = 0xd0
+)
+
+// PDUSessionEstablishmentRequest asks for a new PDU session (TS 24.501
+// 8.3.1)
+type PDUSessionEstablishmentRequest struct {
+	SMHeader
+	IntegrityProtectionMaxDataRate IntegrityProtectionMaxDataRate
+
+	PDUSessionType                *PDUSessionType
+	SSCMode                       *SSCMode
+	Capability5GSM                []byte
+	MaxNumberOfPacketFilters      *uint16
+	AlwaysOnRequested             bool
+	SMPDUDNRequestContainer       []byte
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionEstablishmentRequest) MessageType() MessageType {
+	return MsgPDUSessionEstablishmentRequest
+}
+
+func (m *PDUSessionEstablishmentRequest) encodeBody(w *writer) {
+	w.uint8(m.IntegrityProtectionMaxDataRate.Uplink)
+	w.uint8(m.IntegrityProtectionMaxDataRate.Downlink)
+
+	if m.PDUSessionType != nil {
+		w.half(ieiPDUSessionType, uint8(*m.PDUSessionType))
+	}
+	if m.SSCMode != nil {
+		w.half(ieiSSCMode, uint8(*m.SSCMode))
+	}
+	w.optTLV(ieiCapability5GSM, m.Capability5GSM)
+	if m.MaxNumberOfPacketFilters != nil {
+		n := *m.MaxNumberOfPacketFilters
+		if n > 0x7ff {
+			w.fail("invalid maximum number of packet filters %d", n)
+		}
+		w.tv(ieiMaxPacketFilters, []byte{uint8(n >> 3), uint8(n << 5)})
+	}
+	if m.AlwaysOnRequested {
+		w.half(ieiAlwaysOnRequested, 1)
+	}
+	w.optTLV(ieiSMPDUDNRequestContainer, m.SMPDUDNRequestContainer)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionEstablishmentRequest) decodeBody(r *reader) error {
+	m.IntegrityProtectionMaxDataRate = IntegrityProtectionMaxDataRate{Uplink: r.uint8(), Downlink: r.uint8()}
+
+	opts := r.optionals(map[uint8]int{ieiMaxPacketFilters: 2})
+	if v := opts.half(ieiPDUSessionType); v != nil {
+		t := PDUSessionType(*v & 7)
+		m.PDUSessionType = &t
+	}
+	if v := opts.half(ieiSSCMode); v != nil {
+		mode := SSCMode(*v & 7)
+		m.SSCMode = &mode
+	}
+	m.Capability5GSM = opts.bytes(ieiCapability5GSM)
+	if b := opts.bytes(ieiMaxPacketFilters); b != nil {
+		n := uint16(b[0])<<3 | uint16(b[1])>>5
+		m.MaxNumberOfPacketFilters = &n
+	}
+	if v := opts.half(ieiAlwaysOnRequested); v != nil {
+		m.AlwaysOnRequested = *v&1 != 0
+	}
+	m.SMPDUDNRequestContainer = opts.bytes(ieiSMPDUDNRequestContainer)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionEstablishmentAccept accepts a PDU session (TS 24.501 8.3.2)
+type PDUSessionEstablishmentAccept struct {
+	SMHeader
+	PDUSessionType PDUSessionType
+	SSCMode        SSCMode
+
+	// AuthorizedQoSRules is the encoded QoS rules IE (TS 24.501 9.11.4.13)
+	AuthorizedQoSRules []byte
+	SessionAMBR        SessionAMBR
+
+	Cause                         *Cause5GSM
+	PDUAddress                    *PDUAddress
+	RQTimer                       *time.Duration
+	SNSSAI                        *models.Snssai
+	AlwaysOnGranted               bool
+	MappedEPSBearerContexts       []byte
+	EAPMessage                    []byte
+	AuthorizedQoSFlowDescriptions []byte
+	ExtendedProtocolConfiguration []byte
+	DNN                           string
+}
+
+// MessageType implements Message
+func (m *PDUSessionEstablishmentAccept) MessageType() MessageType {
+	return MsgPDUSessionEstablishmentAccept
+}
+
+func (m *PDUSessionEstablishmentAccept) encodeBody(w *writer) {
+	w.uint8(uint8(m.SSCMode)&7<<4 | uint8(m.PDUSessionType)&7)
+	w.lve(m.AuthorizedQoSRules)
+	w.lv(m.SessionAMBR.encode())
+
+	if m.Cause != nil {
+		w.tv(ieiCause5GSM, optCause(m.Cause))
+	}
+	if m.PDUAddress != nil {
+		w.tlv(ieiPDUAddress, w.must(m.PDUAddress.encode()))
+	}
+	if m.RQTimer != nil {
+		w.tv(ieiRQTimer, optTimer(m.RQTimer, timer2Units))
+	}
+	if m.SNSSAI != nil {
+		w.tlv(ieiSNSSAI, w.must(encodeSNSSAI(*m.SNSSAI)))
+	}
+	if m.AlwaysOnGranted {
+		w.half(ieiAlwaysOnIndication, 1)
+	}
+	w.optTLVE(ieiMappedEPSBearerContexts, m.MappedEPSBearerContexts)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	w.optTLVE(ieiAuthorizedQoSFlows, m.AuthorizedQoSFlowDescriptions)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+	if m.DNN != "" {
+		w.tlv(ieiDNN, w.must(encodeDNN(m.DNN)))
+	}
+}
+
+func (m *PDUSessionEstablishmentAccept) decodeBody(r *reader) (err error) {
+	v := r.uint8()
+	m.PDUSessionType = PDUSessionType(v & 7)
+	m.SSCMode = SSCMode(v >> 4 & 7)
+	m.AuthorizedQoSRules = r.lve()
+	if m.SessionAMBR, err = decodeSessionAMBR(r.lv()); err != nil {
+		return err
+	}
+
+	opts := r.optionals(map[uint8]int{ieiCause5GSM: 1, ieiRQTimer: 1})
+	m.Cause = decodeOptCause[Cause5GSM](opts.bytes(ieiCause5GSM))
+	if b := opts.bytes(ieiPDUAddress); b != nil {
+		a, err := decodePDUAddress(b)
+		if err != nil {
+			return err
+		}
+		m.PDUAddress = &a
+	}
+	if m.RQTimer, err = decodeOptTimer(opts.bytes(ieiRQTimer), timer2Units); err != nil {
+		return err
+	}
+	if b := opts.bytes(ieiSNSSAI); b != nil {
+		s, err := decodeSNSSAI(b)
+		if err != nil {
+			return err
+		}
+		m.SNSSAI = &s
+	}
+	if v := opts.half(ieiAlwaysOnIndication); v != nil {
+		m.AlwaysOnGranted = *v&1 != 0
+	}
+	m.MappedEPSBearerContexts = opts.bytes(ieiMappedEPSBearerContexts)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.AuthorizedQoSFlowDescriptions = opts.bytes(ieiAuthorizedQoSFlows)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	if b := opts.bytes(ieiDNN); b != nil {
+		if m.DNN, err = decodeDNN(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PDUSessionEstablishmentReject rejects a PDU session (TS 24.501 8.3.3)
+type PDUSessionEstablishmentReject struct {
+	SMHeader
+	Cause Cause5GSM
+
+	BackoffTimer                  *time.Duration
+	AllowedSSCMode                *uint8
+	EAPMessage                    []byte
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionEstablishmentReject) MessageType() MessageType {
+	return MsgPDUSessionEstablishmentReject
+}
+
+func (m *PDUSessionEstablishmentReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLV(ieiBackoffTimer, optTimer(m.BackoffTimer, timer3Units))
+	w.optHalf(ieiAllowedSSCMode, m.AllowedSSCMode)
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionEstablishmentReject) decodeBody(r *reader) (err error) {
+	m.Cause = Cause5GSM(r.uint8())
+
+	opts := r.optionals(nil)
+	if m.BackoffTimer, err = decodeOptTimer(opts.bytes(ieiBackoffTimer), timer3Units); err != nil {
+		return err
+	}
+	m.AllowedSSCMode = opts.half(ieiAllowedSSCMode)
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionModificationRequest asks to modify a PDU session (TS 24.501
+// 8.3.7)
+type PDUSessionModificationRequest struct {
+	SMHeader
+
+	Capability5GSM                 []byte
+	Cause                          *Cause5GSM
+	MaxNumberOfPacketFilters       *uint16
+	AlwaysOnRequested              bool
+	IntegrityProtectionMaxDataRate *IntegrityProtectionMaxDataRate
+	RequestedQoSRules              []byte
+	RequestedQoSFlowDescriptions   []byte
+	MappedEPSBearerContexts        []byte
+	ExtendedProtocolConfiguration  []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionModificationRequest) MessageType() MessageType {
+	return MsgPDUSessionModificationRequest
+}
+
+func (m *PDUSessionModificationRequest) encodeBody(w *writer) {
+	w.optTLV(ieiCapability5GSM, m.Capability5GSM)
+	if m.Cause != nil {
+		w.tv(ieiCause5GSM, optCause(m.Cause))
+	}
+	if m.MaxNumberOfPacketFilters != nil {
+		n := *m.MaxNumberOfPacketFilters
+		if n > 0x7ff {
+			w.fail("invalid maximum number of packet filters %d", n)
+		}
+		w.tv(ieiMaxPacketFilters, []byte{uint8(n >> 3), uint8(n << 5)})
+	}
+	if m.AlwaysOnRequested {
+		w.half(ieiAlwaysOnRequested, 1)
+	}
+	if m.IntegrityProtectionMaxDataRate != nil {
+		w.tv(ieiIntegrityProtectionMaxRate, []byte{
+			m.IntegrityProtectionMaxDataRate.Uplink, m.IntegrityProtectionMaxDataRate.Downlink,
+		})
+	}
+	w.optTLVE(ieiAuthorizedQoSRules, m.RequestedQoSRules)
+	w.optTLVE(ieiAuthorizedQoSFlows, m.RequestedQoSFlowDescriptions)
+	w.optTLVE(ieiMappedEPSBearerContexts, m.MappedEPSBearerContexts)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionModificationRequest) decodeBody(r *reader) error {
+	opts := r.optionals(map[uint8]int{ieiCause5GSM: 1, ieiMaxPacketFilters: 2, ieiIntegrityProtectionMaxRate: 2})
+	m.Capability5GSM = opts.bytes(ieiCapability5GSM)
+	m.Cause = decodeOptCause[Cause5GSM](opts.bytes(ieiCause5GSM))
+	if b := opts.bytes(ieiMaxPacketFilters); b != nil {
+		n := uint16(b[0])<<3 | uint16(b[1])>>5
+		m.MaxNumberOfPacketFilters = &n
+	}
+	if v := opts.half(ieiAlwaysOnRequested); v != nil {
+		m.AlwaysOnRequested = *v&1 != 0
+	}
+	if b := opts.bytes(ieiIntegrityProtectionMaxRate); b != nil {
+		m.IntegrityProtectionMaxDataRate = &IntegrityProtectionMaxDataRate{Uplink: b[0], Downlink: b[1]}
+	}
+	m.RequestedQoSRules = opts.bytes(ieiAuthorizedQoSRules)
+	m.RequestedQoSFlowDescriptions = opts.bytes(ieiAuthorizedQoSFlows)
+	m.MappedEPSBearerContexts = opts.bytes(ieiMappedEPSBearerContexts)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionModificationReject rejects a PDU session modification
+// (TS 24.501 8.3.8)
+type PDUSessionModificationReject struct {
+	SMHeader
+	Cause Cause5GSM
+
+	BackoffTimer                  *time.Duration
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionModificationReject) MessageType() MessageType {
+	return MsgPDUSessionModificationReject
+}
+
+func (m *PDUSessionModificationReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLV(ieiBackoffTimer, optTimer(m.BackoffTimer, timer3Units))
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionModificationReject) decodeBody(r *reader) (err error) {
+	m.Cause = Cause5GSM(r.uint8())
+
+	opts := r.optionals(nil)
+	if m.BackoffTimer, err = decodeOptTimer(opts.bytes(ieiBackoffTimer), timer3Units); err != nil {
+		return err
+	}
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionModificationCommand modifies a PDU session from the network
+// (TS 24.501 8.3.9)
+type PDUSessionModificationCommand struct {
+	SMHeader
+
+	Cause                         *Cause5GSM
+	SessionAMBR                   *SessionAMBR
+	RQTimer                       *time.Duration
+	AlwaysOnGranted               bool
+	AuthorizedQoSRules            []byte
+	MappedEPSBearerContexts       []byte
+	AuthorizedQoSFlowDescriptions []byte
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionModificationCommand) MessageType() MessageType {
+	return MsgPDUSessionModificationCommand
+}
+
+func (m *PDUSessionModificationCommand) encodeBody(w *writer) {
+	if m.Cause != nil {
+		w.tv(ieiCause5GSM, optCause(m.Cause))
+	}
+	if m.SessionAMBR != nil {
+		w.tlv(ieiSessionAMBR, m.SessionAMBR.encode())
+	}
+	if m.RQTimer != nil {
+		w.tv(ieiRQTimer, optTimer(m.RQTimer, timer2Units))
+	}
+	if m.AlwaysOnGranted {
+		w.half(ieiAlwaysOnIndication, 1)
+	}
+	w.optTLVE(ieiAuthorizedQoSRules, m.AuthorizedQoSRules)
+	w.optTLVE(ieiMappedEPSBearerContexts, m.MappedEPSBearerContexts)
+	w.optTLVE(ieiAuthorizedQoSFlows, m.AuthorizedQoSFlowDescriptions)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionModificationCommand) decodeBody(r *reader) (err error) {
+	opts := r.optionals(map[uint8]int{ieiCause5GSM: 1, ieiRQTimer: 1})
+	m.Cause = decodeOptCause[Cause5GSM](opts.bytes(ieiCause5GSM))
+	if b := opts.bytes(ieiSessionAMBR); b != nil {
+		a, err := decodeSessionAMBR(b)
+		if err != nil {
+			return err
+		}
+		m.SessionAMBR = &a
+	}
+	if m.RQTimer, err = decodeOptTimer(opts.bytes(ieiRQTimer), timer2Units); err != nil {
+		return err
+	}
+	if v := opts.half(ieiAlwaysOnIndication); v != nil {
+		m.AlwaysOnGranted = *v&1 != 0
+	}
+	m.AuthorizedQoSRules = opts.bytes(ieiAuthorizedQoSRules)
+	m.MappedEPSBearerContexts = opts.bytes(ieiMappedEPSBearerContexts)
+	m.AuthorizedQoSFlowDescriptions = opts.bytes(ieiAuthorizedQoSFlows)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionModificationComplete acknowledges a PDU Session Modification
+// Command (TS 24.501 8.3.10)
+type PDUSessionModificationComplete struct {
+	SMHeader
+
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionModificationComplete) MessageType() MessageType {
+	return MsgPDUSessionModificationComplete
+}
+
+func (m *PDUSessionModificationComplete) encodeBody(w *writer) {
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionModificationComplete) decodeBody(r *reader) error {
+	m.ExtendedProtocolConfiguration = r.optionals(nil).bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionModificationCommandReject rejects a PDU Session Modification
+// Command (TS 24.501 8.3.11)
+type PDUSessionModificationCommandReject struct {
+	SMHeader
+	Cause Cause5GSM
+
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionModificationCommandReject) MessageType() MessageType {
+	return MsgPDUSessionModificationCommandReject
+}
+
+func (m *PDUSessionModificationCommandReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionModificationCommandReject) decodeBody(r *reader) error {
+	m.Cause = Cause5GSM(r.uint8())
+	m.ExtendedProtocolConfiguration = r.optionals(nil).bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionReleaseRequest asks to release a PDU session (TS 24.501
+// 8.3.12)
+type PDUSessionReleaseRequest struct {
+	SMHeader
+
+	Cause                         *Cause5GSM
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionReleaseRequest) MessageType() MessageType {
+	return MsgPDUSessionReleaseRequest
+}
+
+func (m *PDUSessionReleaseRequest) encodeBody(w *writer) {
+	if m.Cause != nil {
+		w.tv(ieiCause5GSM, optCause(m.Cause))
+	}
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionReleaseRequest) decodeBody(r *reader) error {
+	opts := r.optionals(map[uint8]int{ieiCause5GSM: 1})
+	m.Cause = decodeOptCause[Cause5GSM](opts.bytes(ieiCause5GSM))
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionReleaseReject rejects a PDU session release (TS 24.501 8.3.13)
+type PDUSessionReleaseReject struct {
+	SMHeader
+	Cause Cause5GSM
+
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionReleaseReject) MessageType() MessageType {
+	return MsgPDUSessionReleaseReject
+}
+
+func (m *PDUSessionReleaseReject) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionReleaseReject) decodeBody(r *reader) error {
+	m.Cause = Cause5GSM(r.uint8())
+	m.ExtendedProtocolConfiguration = r.optionals(nil).bytes(ieiExtendedPCO)
+	return nil
+}
+
+// PDUSessionReleaseCommand releases a PDU session from the network
+// (TS 24.501 8.3.14)
+type PDUSessionReleaseCommand struct {
+	SMHeader
+	Cause Cause5GSM
+
+	BackoffTimer                  *time.Duration
+	EAPMessage                    []byte
+	ExtendedProtocolConfiguration []byte
+	AccessType                    *AccessType
+}
+
+// MessageType implements Message
+func (m *PDUSessionReleaseCommand) MessageType() MessageType {
+	return MsgPDUSessionReleaseCommand
+}
+
+func (m *PDUSessionReleaseCommand) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+	w.optTLV(ieiBackoffTimer, optTimer(m.BackoffTimer, timer3Units))
+	w.optTLVE(ieiEAPMessage, m.EAPMessage)
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+	if m.AccessType != nil {
+		w.half(ieiAccessType, uint8(*m.AccessType))
+	}
+}
+
+func (m *PDUSessionReleaseCommand) decodeBody(r *reader) (err error) {
+	m.Cause = Cause5GSM(r.uint8())
+
+	opts := r.optionals(nil)
+	if m.BackoffTimer, err = decodeOptTimer(opts.bytes(ieiBackoffTimer), timer3Units); err != nil {
+		return err
+	}
+	m.EAPMessage = opts.bytes(ieiEAPMessage)
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	if v := opts.half(ieiAccessType); v != nil {
+		a := AccessType(*v & 3)
+		m.AccessType = &a
+	}
+	return nil
+}
+
+// PDUSessionReleaseComplete acknowledges a PDU Session Release Command
+// (TS 24.501 8.3.15)
+type PDUSessionReleaseComplete struct {
+	SMHeader
+
+	Cause                         *Cause5GSM
+	ExtendedProtocolConfiguration []byte
+}
+
+// MessageType implements Message
+func (m *PDUSessionReleaseComplete) MessageType() MessageType {
+	return MsgPDUSessionReleaseComplete
+}
+
+func (m *PDUSessionReleaseComplete) encodeBody(w *writer) {
+	if m.Cause != nil {
+		w.tv(ieiCause5GSM, optCause(m.Cause))
+	}
+	w.optTLVE(ieiExtendedPCO, m.ExtendedProtocolConfiguration)
+}
+
+func (m *PDUSessionReleaseComplete) decodeBody(r *reader) error {
+	opts := r.optionals(map[uint8]int{ieiCause5GSM: 1})
+	m.Cause = decodeOptCause[Cause5GSM](opts.bytes(ieiCause5GSM))
+	m.ExtendedProtocolConfiguration = opts.bytes(ieiExtendedPCO)
+	return nil
+}
+
+// Status5GSM reports an error in a received 5GSM message (TS 24.501
+// 8.3.16)
+type Status5GSM struct {
+	SMHeader
+	Cause Cause5GSM
+}
+
+// MessageType implements Message
+func (m *Status5GSM) MessageType() MessageType { return MsgStatus5GSM }
+
+func (m *Status5GSM) encodeBody(w *writer) {
+	w.uint8(uint8(m.Cause))
+}
+
+func (m *Status5GSM) decodeBody(r *reader) error {
+	m.Cause = Cause5GSM(r.uint8())
+	return nil
+}
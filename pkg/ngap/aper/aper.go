@@ -0,0 +1,44 @@
+// Package aper implements the ASN.1 Packed Encoding Rules, ALIGNED
+// variant (ITU-T X.691), as needed by the NGAP codec. It provides the
+// primitive building blocks; the message layouts live in the callers.
+package aper
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTruncated is returned when the input ends before a value does
+	ErrTruncated = errors.New("aper: truncated input")
+
+	// ErrRange is returned when a value is outside its constraint
+	ErrRange = errors.New("aper: value out of range")
+
+	// ErrFragmented is returned for lengths of 16K or more, which
+	// require fragmentation and are not supported
+	ErrFragmented = errors.New("aper: fragmented lengths are not supported")
+)
+
+// bitsFor returns the number of bits needed to encode values 0..n-1
+func bitsFor(n uint64) int {
+	bits := 0
+	for n > 1<<uint(bits) {
+		bits++
+	}
+	return bits
+}
+
+// octetsFor returns the number of octets needed to encode v, at least one
+func octetsFor(v uint64) int {
+	n := 1
+	for v >= 1<<(8*uint(n)) && n < 8 {
+		n++
+	}
+	return n
+}
+
+// rangeError formats a constraint violation
+func rangeError(what string, v, lb, ub int64) error {
+	return fmt.Errorf("%w: %s %d not in %d..%d", ErrRange, what, v, lb, ub)
+}
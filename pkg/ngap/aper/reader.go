@@ -0,0 +1,310 @@
+package aper
+
+import "fmt"
+
+// Reader decodes values from a bit buffer
+type Reader struct {
+	buf []byte
+	pos uint // position in bits
+}
+
+// NewReader creates a reader over b
+func NewReader(b []byte) *Reader {
+	return &Reader{buf: b}
+}
+
+// Remaining returns the number of unread bits
+func (r *Reader) Remaining() int {
+	return len(r.buf)*8 - int(r.pos)
+}
+
+// ReadBits reads n bits, most significant first
+func (r *Reader) ReadBits(n int) (uint64, error) {
+	if n > 64 || r.Remaining() < n {
+		return 0, ErrTruncated
+	}
+
+	var v uint64
+	for i := 0; i < n; i++ {
+		bit := r.buf[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+	return v, nil
+}
+
+// ReadBool reads a single bit
+func (r *Reader) ReadBool() (bool, error) {
+	v, err := r.ReadBits(1)
+	return v == 1, err
+}
+
+// Align skips to the next octet boundary
+func (r *Reader) Align() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
+
+// ReadOctets aligns and reads n raw octets
+func (r *Reader) ReadOctets(n int) ([]byte, error) {
+	r.Align()
+	if n < 0 || r.Remaining() < 8*n {
+		return nil, ErrTruncated
+	}
+
+	start := r.pos / 8
+	b := make([]byte, n)
+	copy(b, r.buf[start:start+uint(n)])
+	r.pos += uint(8 * n)
+	return b, nil
+}
+
+// ReadConstrainedWholeNumber reads a value in lb..ub
+func (r *Reader) ReadConstrainedWholeNumber(lb, ub int64) (int64, error) {
+	rng := uint64(ub-lb) + 1
+
+	var value uint64
+	var err error
+	switch {
+	case rng == 1:
+	case rng <= 255:
+		value, err = r.ReadBits(bitsFor(rng))
+	case rng == 256:
+		r.Align()
+		value, err = r.ReadBits(8)
+	case rng <= 65536:
+		r.Align()
+		value, err = r.ReadBits(16)
+	default:
+		var n int64
+		n, err = r.ReadConstrainedWholeNumber(1, int64(octetsFor(rng-1)))
+		if err != nil {
+			return 0, err
+		}
+		r.Align()
+		value, err = r.ReadBits(8 * int(n))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	v := lb + int64(value)
+	if v > ub {
+		return 0, rangeError("value", v, lb, ub)
+	}
+	return v, nil
+}
+
+// ReadInteger reads a constrained INTEGER. Values in the extension of an
+// extensible constraint are rejected.
+func (r *Reader) ReadInteger(lb, ub int64, extensible bool) (int64, error) {
+	if extensible {
+		ext, err := r.ReadBool()
+		if err != nil {
+			return 0, err
+		}
+		if ext {
+			return 0, fmt.Errorf("%w: integer outside of extension root", ErrRange)
+		}
+	}
+	return r.ReadConstrainedWholeNumber(lb, ub)
+}
+
+// ReadEnumerated reads an enumeration index. Extension values are
+// returned as count plus their extension index.
+func (r *Reader) ReadEnumerated(count uint64, extensible bool) (uint64, error) {
+	if extensible {
+		ext, err := r.ReadBool()
+		if err != nil {
+			return 0, err
+		}
+		if ext {
+			v, err := r.readNormallySmall()
+			return count + v, err
+		}
+	}
+
+	v, err := r.ReadConstrainedWholeNumber(0, int64(count)-1)
+	return uint64(v), err
+}
+
+// ReadChoice reads the index of a CHOICE alternative. Extension
+// alternatives are returned as count plus their extension index.
+func (r *Reader) ReadChoice(count uint64, extensible bool) (uint64, error) {
+	return r.ReadEnumerated(count, extensible)
+}
+
+// readNormallySmall reads a normally small non-negative whole number
+func (r *Reader) readNormallySmall() (uint64, error) {
+	large, err := r.ReadBool()
+	if err != nil {
+		return 0, err
+	}
+	if !large {
+		return r.ReadBits(6)
+	}
+
+	n, err := r.ReadLength(0, -1)
+	if err != nil {
+		return 0, err
+	}
+	r.Align()
+	return r.ReadBits(8 * int(n))
+}
+
+// ReadLength reads a length determinant. ub < 0 means unconstrained.
+func (r *Reader) ReadLength(lb, ub int64) (int64, error) {
+	if ub >= 0 && ub < 65536 {
+		return r.ReadConstrainedWholeNumber(lb, ub)
+	}
+
+	r.Align()
+	first, err := r.ReadBits(8)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case first&0x80 == 0:
+		return int64(first), nil
+	case first&0xc0 == 0x80:
+		second, err := r.ReadBits(8)
+		if err != nil {
+			return 0, err
+		}
+		return int64(first&0x3f)<<8 | int64(second), nil
+	default:
+		return 0, ErrFragmented
+	}
+}
+
+// readSizeExtension reads the extension bit of an extensible size
+// constraint and rejects sizes outside of the root
+func (r *Reader) readSizeExtension(extensible bool) error {
+	if !extensible {
+		return nil
+	}
+	ext, err := r.ReadBool()
+	if err != nil {
+		return err
+	}
+	if ext {
+		return fmt.Errorf("%w: size outside of extension root", ErrRange)
+	}
+	return nil
+}
+
+// ReadOctetString reads an OCTET STRING with size lb..ub. ub < 0 means
+// unconstrained.
+func (r *Reader) ReadOctetString(lb, ub int64, extensible bool) ([]byte, error) {
+	if err := r.readSizeExtension(extensible); err != nil {
+		return nil, err
+	}
+
+	if lb == ub {
+		if lb > 2 {
+			return r.ReadOctets(int(lb))
+		}
+		b := make([]byte, lb)
+		for i := range b {
+			v, err := r.ReadBits(8)
+			if err != nil {
+				return nil, err
+			}
+			b[i] = byte(v)
+		}
+		return b, nil
+	}
+
+	n, err := r.ReadLength(lb, ub)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadOctets(int(n))
+}
+
+// ReadBitString reads a BIT STRING with size lb..ub, returning the bits
+// packed from the start of the slice and their number
+func (r *Reader) ReadBitString(lb, ub int64, extensible bool) ([]byte, int64, error) {
+	if err := r.readSizeExtension(extensible); err != nil {
+		return nil, 0, err
+	}
+
+	n := lb
+	if lb != ub {
+		var err error
+		if n, err = r.ReadLength(lb, ub); err != nil {
+			return nil, 0, err
+		}
+	}
+	if lb != ub || n > 16 {
+		r.Align()
+	}
+
+	b := make([]byte, (n+7)/8)
+	for i := int64(0); i < n; i++ {
+		bit, err := r.ReadBits(1)
+		if err != nil {
+			return nil, 0, err
+		}
+		b[i/8] |= byte(bit) << (7 - uint(i%8))
+	}
+	return b, n, nil
+}
+
+// ReadPrintableString reads a PrintableString with size lb..ub
+func (r *Reader) ReadPrintableString(lb, ub int64, extensible bool) (string, error) {
+	if err := r.readSizeExtension(extensible); err != nil {
+		return "", err
+	}
+
+	n := lb
+	if lb != ub {
+		var err error
+		if n, err = r.ReadLength(lb, ub); err != nil {
+			return "", err
+		}
+	}
+	if ub < 0 || ub*8 > 16 {
+		r.Align()
+	}
+
+	b := make([]byte, n)
+	for i := range b {
+		v, err := r.ReadBits(8)
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte(v)
+	}
+	return string(b), nil
+}
+
+// ReadOpenType reads the octets of an open type value
+func (r *Reader) ReadOpenType() ([]byte, error) {
+	n, err := r.ReadLength(0, -1)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadOctets(int(n))
+}
+
+// SkipExtensionAdditions skips the extension additions of a SEQUENCE
+// whose extension bit was set
+func (r *Reader) SkipExtensionAdditions() error {
+	n, err := r.readNormallySmall()
+	if err != nil {
+		return err
+	}
+
+	present, err := r.ReadBits(int(n) + 1)
+	if err != nil {
+		return err
+	}
+	for i := int(n); i >= 0; i-- {
+		if present>>uint(i)&1 == 1 {
+			if _, err := r.ReadOpenType(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package aper
+
+// Writer encodes values into a bit buffer
+type Writer struct {
+	buf []byte
+	off uint // bits used in the last octet, 0 when aligned
+}
+
+// NewWriter creates an empty writer
+func NewWriter() *Writer {
+	return &Writer{}
+}
+
+// Bytes returns the encoding, padding the last octet with zero bits
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
+
+// WriteBits writes the n low order bits of v, most significant first
+func (w *Writer) WriteBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.off == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(i)&1 == 1 {
+			w.buf[len(w.buf)-1] |= 0x80 >> w.off
+		}
+		w.off = (w.off + 1) % 8
+	}
+}
+
+// WriteBool writes a single bit
+func (w *Writer) WriteBool(b bool) {
+	if b {
+		w.WriteBits(1, 1)
+	} else {
+		w.WriteBits(0, 1)
+	}
+}
+
+// Align pads with zero bits up to the next octet boundary
+func (w *Writer) Align() {
+	w.off = 0
+}
+
+// WriteOctets aligns and appends raw octets
+func (w *Writer) WriteOctets(b []byte) {
+	w.Align()
+	w.buf = append(w.buf, b...)
+}
+
+// WriteConstrainedWholeNumber writes v in lb..ub (X.691 10.5.7.2 to
+// 10.5.7.4, aligned variant)
+func (w *Writer) WriteConstrainedWholeNumber(v, lb, ub int64) error {
+	if v < lb || v > ub {
+		return rangeError("value", v, lb, ub)
+	}
+
+	rng := uint64(ub-lb) + 1
+	value := uint64(v - lb)
+	switch {
+	case rng == 1:
+	case rng <= 255:
+		w.WriteBits(value, bitsFor(rng))
+	case rng == 256:
+		w.Align()
+		w.WriteBits(value, 8)
+	case rng <= 65536:
+		w.Align()
+		w.WriteBits(value, 16)
+	default:
+		// Indefinite length case: the octet count, then the octets
+		n := octetsFor(value)
+		max := octetsFor(rng - 1)
+		if err := w.WriteConstrainedWholeNumber(int64(n), 1, int64(max)); err != nil {
+			return err
+		}
+		w.Align()
+		w.WriteBits(value, 8*n)
+	}
+	return nil
+}
+
+// WriteInteger writes a constrained INTEGER, with an extension bit when
+// the constraint is extensible
+func (w *Writer) WriteInteger(v, lb, ub int64, extensible bool) error {
+	if extensible {
+		w.WriteBool(false)
+	}
+	return w.WriteConstrainedWholeNumber(v, lb, ub)
+}
+
+// WriteEnumerated writes the index of a root enumeration value among
+// count root values
+func (w *Writer) WriteEnumerated(v uint64, count uint64, extensible bool) error {
+	if v >= count {
+		return rangeError("enumerated", int64(v), 0, int64(count)-1)
+	}
+	if extensible {
+		w.WriteBool(false)
+	}
+	return w.WriteConstrainedWholeNumber(int64(v), 0, int64(count)-1)
+}
+
+// WriteChoice writes the index of a root alternative among count
+// alternatives
+func (w *Writer) WriteChoice(index uint64, count uint64, extensible bool) error {
+	return w.WriteEnumerated(index, count, extensible)
+}
+
+// WriteLength writes a length determinant. ub < 0 means unconstrained.
+func (w *Writer) WriteLength(n, lb, ub int64) error {
+	if ub >= 0 && ub < 65536 {
+		return w.WriteConstrainedWholeNumber(n, lb, ub)
+	}
+
+	w.Align()
+	switch {
+	case n < 128:
+		w.WriteBits(uint64(n), 8)
+	case n < 16384:
+		w.WriteBits(uint64(n)|0x8000, 16)
+	default:
+		return ErrFragmented
+	}
+	return nil
+}
+
+// WriteOctetString writes an OCTET STRING with size lb..ub. ub < 0 means
+// unconstrained.
+func (w *Writer) WriteOctetString(b []byte, lb, ub int64, extensible bool) error {
+	n := int64(len(b))
+	if n < lb || (ub >= 0 && n > ub) {
+		return rangeError("octet string size", n, lb, ub)
+	}
+	if extensible {
+		w.WriteBool(false)
+	}
+
+	if lb == ub {
+		// Fixed size: no length, octet aligned above two octets
+		if n > 2 {
+			w.Align()
+		}
+		for _, c := range b {
+			w.WriteBits(uint64(c), 8)
+		}
+		return nil
+	}
+
+	if err := w.WriteLength(n, lb, ub); err != nil {
+		return err
+	}
+	w.WriteOctets(b)
+	return nil
+}
+
+// WriteBitString writes a BIT STRING of n bits with size lb..ub, taking
+// the bits from the start of b. ub < 0 means unconstrained.
+func (w *Writer) WriteBitString(b []byte, n, lb, ub int64, extensible bool) error {
+	if n < lb || (ub >= 0 && n > ub) || int64(len(b))*8 < n {
+		return rangeError("bit string size", n, lb, ub)
+	}
+	if extensible {
+		w.WriteBool(false)
+	}
+
+	if lb != ub {
+		if err := w.WriteLength(n, lb, ub); err != nil {
+			return err
+		}
+	}
+	if lb != ub || n > 16 {
+		w.Align()
+	}
+
+	for i := int64(0); i < n; i++ {
+		w.WriteBits(uint64(b[i/8]>>(7-uint(i%8))&1), 1)
+	}
+	return nil
+}
+
+// WritePrintableString writes a PrintableString with size lb..ub. Each
+// character takes one octet in the aligned variant.
+func (w *Writer) WritePrintableString(s string, lb, ub int64, extensible bool) error {
+	n := int64(len(s))
+	if n < lb || (ub >= 0 && n > ub) {
+		return rangeError("string size", n, lb, ub)
+	}
+	if extensible {
+		w.WriteBool(false)
+	}
+
+	if lb != ub {
+		if err := w.WriteLength(n, lb, ub); err != nil {
+			return err
+		}
+	}
+	if ub < 0 || ub*8 > 16 {
+		w.Align()
+	}
+	for i := 0; i < len(s); i++ {
+		w.WriteBits(uint64(s[i]), 8)
+	}
+	return nil
+}
+
+// WriteOpenType writes the encoding produced by fn as an open type
+func (w *Writer) WriteOpenType(fn func(*Writer) error) error {
+	inner := NewWriter()
+	if err := fn(inner); err != nil {
+		return err
+	}
+	return w.WriteOpenTypeBytes(inner.Bytes())
+}
+
+// WriteOpenTypeBytes writes an already encoded open type value
+func (w *Writer) WriteOpenTypeBytes(b []byte) error {
+	if len(b) == 0 {
+		// An empty encoding is represented by a single zero octet
+		b = []byte{0}
+	}
+	if err := w.WriteLength(int64(len(b)), 0, -1); err != nil {
+		return err
+	}
+	w.WriteOctets(b)
+	return nil
+}
@@ -0,0 +1,167 @@
+package ngap
+
+import (
+	"fmt"
+
+	"github.com/0had0/5G-core/pkg/ngap/aper"
+)
+
+// ieEncoder collects the encoded IEs of a message
+type ieEncoder struct {
+	ies []ProtocolIE
+	err error
+}
+
+// add encodes an IE value with fn and appends it
+func (e *ieEncoder) add(id ProtocolIEID, criticality Criticality, fn func(*aper.Writer) error) {
+	if e.err != nil {
+		return
+	}
+
+	w := aper.NewWriter()
+	if err := fn(w); err != nil {
+		e.err = fmt.Errorf("IE %d: %w", id, err)
+		return
+	}
+	e.ies = append(e.ies, ProtocolIE{ID: id, Criticality: criticality, Value: w.Bytes()})
+}
+
+// ieDecoder decodes the IEs of a message, ignoring unknown ones
+type ieDecoder struct {
+	ies []ProtocolIE
+	err error
+}
+
+// optional decodes the IE with fn when present and reports whether it was
+func (d *ieDecoder) optional(id ProtocolIEID, fn func(*aper.Reader) error) bool {
+	if d.err != nil {
+		return false
+	}
+
+	for _, ie := range d.ies {
+		if ie.ID != id {
+			continue
+		}
+		if err := fn(aper.NewReader(ie.Value)); err != nil {
+			d.err = fmt.Errorf("IE %d: %w", id, err)
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// mandatory decodes the IE with fn, failing when it is absent
+func (d *ieDecoder) mandatory(id ProtocolIEID, fn func(*aper.Reader) error) {
+	if !d.optional(id, fn) && d.err == nil {
+		d.err = fmt.Errorf("missing mandatory IE %d", id)
+	}
+}
+
+// finish returns the first decoding error
+func (d *ieDecoder) finish() error {
+	return d.err
+}
+
+// writeSequence writes the preamble of a SEQUENCE: the extension bit
+// when extensible, then one presence bit per optional component
+func writeSequence(w *aper.Writer, extensible bool, optional ...bool) {
+	if extensible {
+		w.WriteBool(false)
+	}
+	for _, present := range optional {
+		w.WriteBool(present)
+	}
+}
+
+// sequence is the decoded preamble of a SEQUENCE
+type sequence struct {
+	extended bool
+	present  []bool
+}
+
+// readSequence reads the preamble of a SEQUENCE with n optional
+// components
+func readSequence(r *aper.Reader, extensible bool, n int) (sequence, error) {
+	var s sequence
+	var err error
+
+	if extensible {
+		if s.extended, err = r.ReadBool(); err != nil {
+			return s, err
+		}
+	}
+	s.present = make([]bool, n)
+	for i := range s.present {
+		if s.present[i], err = r.ReadBool(); err != nil {
+			return s, err
+		}
+	}
+	return s, nil
+}
+
+// end skips what follows the root components of a SEQUENCE: the
+// iE-Extensions when present at index ieExtensions, and the extension
+// additions. ieExtensions is -1 when the type has none.
+func (s sequence) end(r *aper.Reader, ieExtensions int) error {
+	if ieExtensions >= 0 && s.present[ieExtensions] {
+		if err := skipProtocolExtensions(r); err != nil {
+			return err
+		}
+	}
+	if s.extended {
+		return r.SkipExtensionAdditions()
+	}
+	return nil
+}
+
+// skipProtocolExtensions skips a ProtocolExtensionContainer
+func skipProtocolExtensions(r *aper.Reader) error {
+	n, err := r.ReadLength(1, 65535)
+	if err != nil {
+		return err
+	}
+	for i := int64(0); i < n; i++ {
+		if _, err := r.ReadConstrainedWholeNumber(0, 65535); err != nil {
+			return err
+		}
+		if _, err := r.ReadEnumerated(3, false); err != nil {
+			return err
+		}
+		if _, err := r.ReadOpenType(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeList writes a SEQUENCE OF with size lb..ub
+func writeList[T any](w *aper.Writer, items []T, lb, ub int64, fn func(*aper.Writer, T) error) error {
+	if err := w.WriteLength(int64(len(items)), lb, ub); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(w, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readList reads a SEQUENCE OF with size lb..ub
+func readList[T any](r *aper.Reader, lb, ub int64, fn func(*aper.Reader) (T, error)) ([]T, error) {
+	n, err := r.ReadLength(lb, ub)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]T, 0, n)
+	for i := int64(0); i < n; i++ {
+		item, err := fn(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
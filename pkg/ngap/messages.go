@@ -0,0 +1,1486 @@
+package ngap
+
+import (
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap/aper"
+)
+
+func init() {
+	register(func() Message { return &NGSetupRequest{} })
+	register(func() Message { return &NGSetupResponse{} })
+	register(func() Message { return &NGSetupFailure{} })
+	register(func() Message { return &InitialUEMessage{} })
+	register(func() Message { return &DownlinkNASTransport{} })
+	register(func() Message { return &UplinkNASTransport{} })
+	register(func() Message { return &InitialContextSetupRequest{} })
+	register(func() Message { return &InitialContextSetupResponse{} })
+	register(func() Message { return &InitialContextSetupFailure{} })
+	register(func() Message { return &PDUSessionResourceSetupRequest{} })
+	register(func() Message { return &PDUSessionResourceSetupResponse{} })
+	register(func() Message { return &PDUSessionResourceModifyRequest{} })
+	register(func() Message { return &PDUSessionResourceModifyResponse{} })
+	register(func() Message { return &PDUSessionResourceReleaseCommand{} })
+	register(func() Message { return &PDUSessionResourceReleaseResponse{} })
+	register(func() Message { return &UEContextReleaseRequest{} })
+	register(func() Message { return &UEContextReleaseCommand{} })
+	register(func() Message { return &UEContextReleaseComplete{} })
+	register(func() Message { return &Paging{} })
+	register(func() Message { return &PathSwitchRequest{} })
+	register(func() Message { return &PathSwitchRequestAcknowledge{} })
+	register(func() Message { return &PathSwitchRequestFailure{} })
+	register(func() Message { return &HandoverRequired{} })
+	register(func() Message { return &HandoverCommand{} })
+	register(func() Message { return &HandoverPreparationFailure{} })
+	register(func() Message { return &HandoverRequest{} })
+	register(func() Message { return &HandoverRequestAcknowledge{} })
+	register(func() Message { return &HandoverFailure{} })
+	register(func() Message { return &HandoverNotify{} })
+	register(func() Message { return &HandoverCancel{} })
+	register(func() Message { return &HandoverCancelAcknowledge{} })
+}
+
+// NGSetupRequest is sent by a gNB to set up the N2 interface
+type NGSetupRequest struct {
+	GlobalRANNodeID  GlobalRANNodeID
+	RANNodeName      string
+	SupportedTAList  []SupportedTAItem
+	DefaultPagingDRX PagingDRX
+}
+
+// ProcedureCode implements Message
+func (m *NGSetupRequest) ProcedureCode() ProcedureCode { return ProcedureNGSetup }
+
+// MessageType implements Message
+func (m *NGSetupRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *NGSetupRequest) encodeIEs(e *ieEncoder) {
+	e.add(IDGlobalRANNodeID, CriticalityReject, func(w *aper.Writer) error {
+		return writeGlobalRANNodeID(w, m.GlobalRANNodeID)
+	})
+	if m.RANNodeName != "" {
+		e.add(IDRANNodeName, CriticalityIgnore, func(w *aper.Writer) error {
+			return writeAMFName(w, m.RANNodeName)
+		})
+	}
+	e.add(IDSupportedTAList, CriticalityReject, func(w *aper.Writer) error {
+		return writeSupportedTAList(w, m.SupportedTAList)
+	})
+	e.add(IDDefaultPagingDRX, CriticalityIgnore, func(w *aper.Writer) error {
+		return writePagingDRX(w, m.DefaultPagingDRX)
+	})
+}
+
+func (m *NGSetupRequest) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDGlobalRANNodeID, func(r *aper.Reader) (err error) {
+		m.GlobalRANNodeID, err = readGlobalRANNodeID(r)
+		return err
+	})
+	d.optional(IDRANNodeName, func(r *aper.Reader) (err error) {
+		m.RANNodeName, err = readAMFName(r)
+		return err
+	})
+	d.mandatory(IDSupportedTAList, func(r *aper.Reader) (err error) {
+		m.SupportedTAList, err = readSupportedTAList(r)
+		return err
+	})
+	d.mandatory(IDDefaultPagingDRX, func(r *aper.Reader) (err error) {
+		m.DefaultPagingDRX, err = readPagingDRX(r)
+		return err
+	})
+}
+
+// NGSetupResponse accepts an NG Setup
+type NGSetupResponse struct {
+	AMFName             string
+	ServedGUAMIList     []ServedGUAMIItem
+	RelativeAMFCapacity uint8
+	PLMNSupportList     []PLMNSupportItem
+}
+
+// ProcedureCode implements Message
+func (m *NGSetupResponse) ProcedureCode() ProcedureCode { return ProcedureNGSetup }
+
+// MessageType implements Message
+func (m *NGSetupResponse) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *NGSetupResponse) encodeIEs(e *ieEncoder) {
+	e.add(IDAMFName, CriticalityReject, func(w *aper.Writer) error {
+		return writeAMFName(w, m.AMFName)
+	})
+	e.add(IDServedGUAMIList, CriticalityReject, func(w *aper.Writer) error {
+		return writeServedGUAMIList(w, m.ServedGUAMIList)
+	})
+	e.add(IDRelativeAMFCapacity, CriticalityIgnore, func(w *aper.Writer) error {
+		return w.WriteConstrainedWholeNumber(int64(m.RelativeAMFCapacity), 0, 255)
+	})
+	e.add(IDPLMNSupportList, CriticalityReject, func(w *aper.Writer) error {
+		return writePLMNSupportList(w, m.PLMNSupportList)
+	})
+}
+
+func (m *NGSetupResponse) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDAMFName, func(r *aper.Reader) (err error) {
+		m.AMFName, err = readAMFName(r)
+		return err
+	})
+	d.mandatory(IDServedGUAMIList, func(r *aper.Reader) (err error) {
+		m.ServedGUAMIList, err = readServedGUAMIList(r)
+		return err
+	})
+	d.mandatory(IDRelativeAMFCapacity, func(r *aper.Reader) error {
+		v, err := r.ReadConstrainedWholeNumber(0, 255)
+		m.RelativeAMFCapacity = uint8(v)
+		return err
+	})
+	d.mandatory(IDPLMNSupportList, func(r *aper.Reader) (err error) {
+		m.PLMNSupportList, err = readPLMNSupportList(r)
+		return err
+	})
+}
+
+// NGSetupFailure rejects an NG Setup
+type NGSetupFailure struct {
+	Cause      Cause
+	TimeToWait *TimeToWait
+}
+
+// ProcedureCode implements Message
+func (m *NGSetupFailure) ProcedureCode() ProcedureCode { return ProcedureNGSetup }
+
+// MessageType implements Message
+func (m *NGSetupFailure) MessageType() MessageType { return UnsuccessfulOutcome }
+
+func (m *NGSetupFailure) encodeIEs(e *ieEncoder) {
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+	if m.TimeToWait != nil {
+		e.add(IDTimeToWait, CriticalityIgnore, func(w *aper.Writer) error {
+			// TimeToWait ::= ENUMERATED {v1s, v2s, v5s, v10s, v20s, v60s, ...}
+			return w.WriteEnumerated(uint64(*m.TimeToWait), 6, true)
+		})
+	}
+}
+
+func (m *NGSetupFailure) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+	d.optional(IDTimeToWait, func(r *aper.Reader) error {
+		v, err := r.ReadEnumerated(6, true)
+		t := TimeToWait(v)
+		m.TimeToWait = &t
+		return err
+	})
+}
+
+// InitialUEMessage carries the first NAS message of a UE
+type InitialUEMessage struct {
+	RANUENGAPID             int64
+	NASPDU                  []byte
+	UserLocationInformation UserLocationInformation
+	RRCEstablishmentCause   RRCEstablishmentCause
+	FiveGSTMSI              *FiveGSTMSI
+	UEContextRequested      bool
+}
+
+// ProcedureCode implements Message
+func (m *InitialUEMessage) ProcedureCode() ProcedureCode { return ProcedureInitialUEMessage }
+
+// MessageType implements Message
+func (m *InitialUEMessage) MessageType() MessageType { return InitiatingMessage }
+
+func (m *InitialUEMessage) encodeIEs(e *ieEncoder) {
+	e.add(IDRANUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeRANUENGAPID(w, m.RANUENGAPID)
+	})
+	e.add(IDNASPDU, CriticalityReject, func(w *aper.Writer) error {
+		return writeNASPDU(w, m.NASPDU)
+	})
+	e.add(IDUserLocationInformation, CriticalityReject, func(w *aper.Writer) error {
+		return writeUserLocationInformation(w, m.UserLocationInformation)
+	})
+	e.add(IDRRCEstablishmentCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return w.WriteEnumerated(uint64(m.RRCEstablishmentCause), rrcEstablishmentCauseCount, true)
+	})
+	if m.FiveGSTMSI != nil {
+		e.add(IDFiveGSTMSI, CriticalityReject, func(w *aper.Writer) error {
+			return writeFiveGSTMSI(w, *m.FiveGSTMSI)
+		})
+	}
+	if m.UEContextRequested {
+		e.add(IDUEContextRequest, CriticalityIgnore, func(w *aper.Writer) error {
+			// UEContextRequest ::= ENUMERATED {requested, ...}
+			return w.WriteEnumerated(0, 1, true)
+		})
+	}
+}
+
+func (m *InitialUEMessage) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDRANUENGAPID, func(r *aper.Reader) (err error) {
+		m.RANUENGAPID, err = readRANUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+	d.mandatory(IDUserLocationInformation, func(r *aper.Reader) (err error) {
+		m.UserLocationInformation, err = readUserLocationInformation(r)
+		return err
+	})
+	d.mandatory(IDRRCEstablishmentCause, func(r *aper.Reader) error {
+		v, err := r.ReadEnumerated(rrcEstablishmentCauseCount, true)
+		m.RRCEstablishmentCause = RRCEstablishmentCause(v)
+		return err
+	})
+	d.optional(IDFiveGSTMSI, func(r *aper.Reader) error {
+		s, err := readFiveGSTMSI(r)
+		m.FiveGSTMSI = &s
+		return err
+	})
+	m.UEContextRequested = d.optional(IDUEContextRequest, func(r *aper.Reader) error {
+		_, err := r.ReadEnumerated(1, true)
+		return err
+	})
+}
+
+// DownlinkNASTransport carries a NAS message from the AMF to a UE
+type DownlinkNASTransport struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	NASPDU      []byte
+}
+
+// ProcedureCode implements Message
+func (m *DownlinkNASTransport) ProcedureCode() ProcedureCode { return ProcedureDownlinkNASTransport }
+
+// MessageType implements Message
+func (m *DownlinkNASTransport) MessageType() MessageType { return InitiatingMessage }
+
+func (m *DownlinkNASTransport) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDNASPDU, CriticalityReject, func(w *aper.Writer) error {
+		return writeNASPDU(w, m.NASPDU)
+	})
+}
+
+func (m *DownlinkNASTransport) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+}
+
+// UplinkNASTransport carries a NAS message from a UE to the AMF
+type UplinkNASTransport struct {
+	AMFUENGAPID             int64
+	RANUENGAPID             int64
+	NASPDU                  []byte
+	UserLocationInformation UserLocationInformation
+}
+
+// ProcedureCode implements Message
+func (m *UplinkNASTransport) ProcedureCode() ProcedureCode { return ProcedureUplinkNASTransport }
+
+// MessageType implements Message
+func (m *UplinkNASTransport) MessageType() MessageType { return InitiatingMessage }
+
+func (m *UplinkNASTransport) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDNASPDU, CriticalityReject, func(w *aper.Writer) error {
+		return writeNASPDU(w, m.NASPDU)
+	})
+	e.add(IDUserLocationInformation, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeUserLocationInformation(w, m.UserLocationInformation)
+	})
+}
+
+func (m *UplinkNASTransport) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+	d.mandatory(IDUserLocationInformation, func(r *aper.Reader) (err error) {
+		m.UserLocationInformation, err = readUserLocationInformation(r)
+		return err
+	})
+}
+
+// InitialContextSetupRequest establishes the UE context in the gNB
+type InitialContextSetupRequest struct {
+	AMFUENGAPID               int64
+	RANUENGAPID               int64
+	UEAggregateMaximumBitRate *UEAggregateMaximumBitRate
+	GUAMI                     GUAMI
+	PDUSessionResourceSetup   []PDUSessionResourceSetupItem
+	AllowedNSSAI              []models.Snssai
+	UESecurityCapabilities    UESecurityCapabilities
+	SecurityKey               []byte
+	NASPDU                    []byte
+}
+
+// ProcedureCode implements Message
+func (m *InitialContextSetupRequest) ProcedureCode() ProcedureCode {
+	return ProcedureInitialContextSetup
+}
+
+// MessageType implements Message
+func (m *InitialContextSetupRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *InitialContextSetupRequest) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if m.UEAggregateMaximumBitRate != nil {
+		e.add(IDUEAggregateMaximumBitRate, CriticalityReject, func(w *aper.Writer) error {
+			return writeUEAggregateMaximumBitRate(w, *m.UEAggregateMaximumBitRate)
+		})
+	}
+	e.add(IDGUAMI, CriticalityReject, func(w *aper.Writer) error {
+		return writeGUAMI(w, m.GUAMI)
+	})
+	if len(m.PDUSessionResourceSetup) > 0 {
+		e.add(IDPDUSessionResourceSetupListCxtReq, CriticalityReject, func(w *aper.Writer) error {
+			return writePDUSessionResourceSetupList(w, m.PDUSessionResourceSetup)
+		})
+	}
+	e.add(IDAllowedNSSAI, CriticalityReject, func(w *aper.Writer) error {
+		return writeAllowedNSSAI(w, m.AllowedNSSAI)
+	})
+	e.add(IDUESecurityCapabilities, CriticalityReject, func(w *aper.Writer) error {
+		return writeUESecurityCapabilities(w, m.UESecurityCapabilities)
+	})
+	e.add(IDSecurityKey, CriticalityReject, func(w *aper.Writer) error {
+		return writeSecurityKey(w, m.SecurityKey)
+	})
+	if m.NASPDU != nil {
+		e.add(IDNASPDU, CriticalityIgnore, func(w *aper.Writer) error {
+			return writeNASPDU(w, m.NASPDU)
+		})
+	}
+}
+
+func (m *InitialContextSetupRequest) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDUEAggregateMaximumBitRate, func(r *aper.Reader) error {
+		ambr, err := readUEAggregateMaximumBitRate(r)
+		m.UEAggregateMaximumBitRate = &ambr
+		return err
+	})
+	d.mandatory(IDGUAMI, func(r *aper.Reader) (err error) {
+		m.GUAMI, err = readGUAMI(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceSetupListCxtReq, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceSetup, err = readPDUSessionResourceSetupList(r)
+		return err
+	})
+	d.mandatory(IDAllowedNSSAI, func(r *aper.Reader) (err error) {
+		m.AllowedNSSAI, err = readAllowedNSSAI(r)
+		return err
+	})
+	d.mandatory(IDUESecurityCapabilities, func(r *aper.Reader) (err error) {
+		m.UESecurityCapabilities, err = readUESecurityCapabilities(r)
+		return err
+	})
+	d.mandatory(IDSecurityKey, func(r *aper.Reader) (err error) {
+		m.SecurityKey, err = readSecurityKey(r)
+		return err
+	})
+	d.optional(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+}
+
+// InitialContextSetupResponse acknowledges the UE context setup
+type InitialContextSetupResponse struct {
+	AMFUENGAPID                   int64
+	RANUENGAPID                   int64
+	PDUSessionResourceSetup       []PDUSessionResourceItem
+	PDUSessionResourceFailedSetup []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *InitialContextSetupResponse) ProcedureCode() ProcedureCode {
+	return ProcedureInitialContextSetup
+}
+
+// MessageType implements Message
+func (m *InitialContextSetupResponse) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *InitialContextSetupResponse) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if len(m.PDUSessionResourceSetup) > 0 {
+		e.add(IDPDUSessionResourceSetupListCxtRes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceSetup)
+		})
+	}
+	if len(m.PDUSessionResourceFailedSetup) > 0 {
+		e.add(IDPDUSessionResourceFailedToSetupListCxtRes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceFailedSetup)
+		})
+	}
+}
+
+func (m *InitialContextSetupResponse) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDPDUSessionResourceSetupListCxtRes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceFailedToSetupListCxtRes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceFailedSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// InitialContextSetupFailure reports that the UE context could not be set up
+type InitialContextSetupFailure struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	Cause       Cause
+}
+
+// ProcedureCode implements Message
+func (m *InitialContextSetupFailure) ProcedureCode() ProcedureCode {
+	return ProcedureInitialContextSetup
+}
+
+// MessageType implements Message
+func (m *InitialContextSetupFailure) MessageType() MessageType { return UnsuccessfulOutcome }
+
+func (m *InitialContextSetupFailure) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *InitialContextSetupFailure) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// PDUSessionResourceSetupRequest sets up PDU sessions of a connected UE
+type PDUSessionResourceSetupRequest struct {
+	AMFUENGAPID             int64
+	RANUENGAPID             int64
+	NASPDU                  []byte
+	PDUSessionResourceSetup []PDUSessionResourceSetupItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceSetupRequest) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceSetup
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceSetupRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *PDUSessionResourceSetupRequest) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if m.NASPDU != nil {
+		e.add(IDNASPDU, CriticalityReject, func(w *aper.Writer) error {
+			return writeNASPDU(w, m.NASPDU)
+		})
+	}
+	e.add(IDPDUSessionResourceSetupListSUReq, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceSetupList(w, m.PDUSessionResourceSetup)
+	})
+}
+
+func (m *PDUSessionResourceSetupRequest) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceSetupListSUReq, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceSetup, err = readPDUSessionResourceSetupList(r)
+		return err
+	})
+}
+
+// PDUSessionResourceSetupResponse reports the PDU sessions set up by the gNB
+type PDUSessionResourceSetupResponse struct {
+	AMFUENGAPID                   int64
+	RANUENGAPID                   int64
+	PDUSessionResourceSetup       []PDUSessionResourceItem
+	PDUSessionResourceFailedSetup []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceSetupResponse) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceSetup
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceSetupResponse) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *PDUSessionResourceSetupResponse) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if len(m.PDUSessionResourceSetup) > 0 {
+		e.add(IDPDUSessionResourceSetupListSURes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceSetup)
+		})
+	}
+	if len(m.PDUSessionResourceFailedSetup) > 0 {
+		e.add(IDPDUSessionResourceFailedToSetupListSURes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceFailedSetup)
+		})
+	}
+}
+
+func (m *PDUSessionResourceSetupResponse) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDPDUSessionResourceSetupListSURes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceFailedToSetupListSURes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceFailedSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// PDUSessionResourceModifyRequest changes the QoS flows of PDU sessions
+// of a connected UE
+type PDUSessionResourceModifyRequest struct {
+	AMFUENGAPID              int64
+	RANUENGAPID              int64
+	PDUSessionResourceModify []PDUSessionResourceModifyItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceModifyRequest) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceModify
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceModifyRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *PDUSessionResourceModifyRequest) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDPDUSessionResourceModifyListModReq, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceModifyList(w, m.PDUSessionResourceModify)
+	})
+}
+
+func (m *PDUSessionResourceModifyRequest) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDPDUSessionResourceModifyListModReq, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceModify, err = readPDUSessionResourceModifyList(r)
+		return err
+	})
+}
+
+// PDUSessionResourceModifyResponse reports the PDU sessions modified by
+// the gNB
+type PDUSessionResourceModifyResponse struct {
+	AMFUENGAPID                    int64
+	RANUENGAPID                    int64
+	PDUSessionResourceModify       []PDUSessionResourceItem
+	PDUSessionResourceFailedModify []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceModifyResponse) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceModify
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceModifyResponse) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *PDUSessionResourceModifyResponse) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if len(m.PDUSessionResourceModify) > 0 {
+		e.add(IDPDUSessionResourceModifyListModRes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceModify)
+		})
+	}
+	if len(m.PDUSessionResourceFailedModify) > 0 {
+		e.add(IDPDUSessionResourceFailedToModifyListModRes, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResourceFailedModify)
+		})
+	}
+}
+
+func (m *PDUSessionResourceModifyResponse) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDPDUSessionResourceModifyListModRes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceModify, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceFailedToModifyListModRes, func(r *aper.Reader) (err error) {
+		m.PDUSessionResourceFailedModify, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// PDUSessionResourceReleaseCommand releases PDU sessions of a UE
+type PDUSessionResourceReleaseCommand struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	NASPDU      []byte
+	ToRelease   []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceReleaseCommand) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceRelease
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceReleaseCommand) MessageType() MessageType { return InitiatingMessage }
+
+func (m *PDUSessionResourceReleaseCommand) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if m.NASPDU != nil {
+		e.add(IDNASPDU, CriticalityIgnore, func(w *aper.Writer) error {
+			return writeNASPDU(w, m.NASPDU)
+		})
+	}
+	e.add(IDPDUSessionResourceToReleaseListRelCmd, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.ToRelease)
+	})
+}
+
+func (m *PDUSessionResourceReleaseCommand) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDNASPDU, func(r *aper.Reader) (err error) {
+		m.NASPDU, err = readNASPDU(r)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceToReleaseListRelCmd, func(r *aper.Reader) (err error) {
+		m.ToRelease, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// PDUSessionResourceReleaseResponse reports the PDU sessions released by
+// the gNB
+type PDUSessionResourceReleaseResponse struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	Released    []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PDUSessionResourceReleaseResponse) ProcedureCode() ProcedureCode {
+	return ProcedurePDUSessionResourceRelease
+}
+
+// MessageType implements Message
+func (m *PDUSessionResourceReleaseResponse) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *PDUSessionResourceReleaseResponse) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDPDUSessionResourceReleasedListRelRes, CriticalityIgnore, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.Released)
+	})
+}
+
+func (m *PDUSessionResourceReleaseResponse) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDPDUSessionResourceReleasedListRelRes, func(r *aper.Reader) (err error) {
+		m.Released, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// UEContextReleaseRequest is sent by a gNB to have the AMF release the
+// N2 connection of a UE, e.g. after user inactivity
+type UEContextReleaseRequest struct {
+	AMFUENGAPID         int64
+	RANUENGAPID         int64
+	PDUSessionResources []uint8
+	Cause               Cause
+}
+
+// ProcedureCode implements Message
+func (m *UEContextReleaseRequest) ProcedureCode() ProcedureCode {
+	return ProcedureUEContextReleaseRequest
+}
+
+// MessageType implements Message
+func (m *UEContextReleaseRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *UEContextReleaseRequest) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if len(m.PDUSessionResources) > 0 {
+		e.add(IDPDUSessionResourceListCxtRelReq, CriticalityReject, func(w *aper.Writer) error {
+			return writePDUSessionIDList(w, m.PDUSessionResources)
+		})
+	}
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *UEContextReleaseRequest) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDPDUSessionResourceListCxtRelReq, func(r *aper.Reader) (err error) {
+		m.PDUSessionResources, err = readPDUSessionIDList(r)
+		return err
+	})
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// UEContextReleaseCommand releases the UE context in the gNB
+type UEContextReleaseCommand struct {
+	UENGAPIDs UENGAPIDs
+	Cause     Cause
+}
+
+// ProcedureCode implements Message
+func (m *UEContextReleaseCommand) ProcedureCode() ProcedureCode { return ProcedureUEContextRelease }
+
+// MessageType implements Message
+func (m *UEContextReleaseCommand) MessageType() MessageType { return InitiatingMessage }
+
+func (m *UEContextReleaseCommand) encodeIEs(e *ieEncoder) {
+	e.add(IDUENGAPIDs, CriticalityReject, func(w *aper.Writer) error {
+		return writeUENGAPIDs(w, m.UENGAPIDs)
+	})
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *UEContextReleaseCommand) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDUENGAPIDs, func(r *aper.Reader) (err error) {
+		m.UENGAPIDs, err = readUENGAPIDs(r)
+		return err
+	})
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// UEContextReleaseComplete acknowledges the UE context release
+type UEContextReleaseComplete struct {
+	AMFUENGAPID             int64
+	RANUENGAPID             int64
+	UserLocationInformation *UserLocationInformation
+	PDUSessionResources     []uint8
+}
+
+// ProcedureCode implements Message
+func (m *UEContextReleaseComplete) ProcedureCode() ProcedureCode { return ProcedureUEContextRelease }
+
+// MessageType implements Message
+func (m *UEContextReleaseComplete) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *UEContextReleaseComplete) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if m.UserLocationInformation != nil {
+		e.add(IDUserLocationInformation, CriticalityIgnore, func(w *aper.Writer) error {
+			return writeUserLocationInformation(w, *m.UserLocationInformation)
+		})
+	}
+	if len(m.PDUSessionResources) > 0 {
+		e.add(IDPDUSessionResourceListCxtRelCpl, CriticalityReject, func(w *aper.Writer) error {
+			return writePDUSessionIDList(w, m.PDUSessionResources)
+		})
+	}
+}
+
+func (m *UEContextReleaseComplete) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDUserLocationInformation, func(r *aper.Reader) error {
+		uli, err := readUserLocationInformation(r)
+		m.UserLocationInformation = &uli
+		return err
+	})
+	d.optional(IDPDUSessionResourceListCxtRelCpl, func(r *aper.Reader) (err error) {
+		m.PDUSessionResources, err = readPDUSessionIDList(r)
+		return err
+	})
+}
+
+// Paging asks the gNBs of the listed tracking areas to page a UE
+type Paging struct {
+	UEPagingIdentity FiveGSTMSI
+	PagingDRX        *PagingDRX
+	TAIListForPaging []TAI
+	PagingPriority   *uint8 // 0 to 7, for priority level 1 to 8
+}
+
+// ProcedureCode implements Message
+func (m *Paging) ProcedureCode() ProcedureCode { return ProcedurePaging }
+
+// MessageType implements Message
+func (m *Paging) MessageType() MessageType { return InitiatingMessage }
+
+func (m *Paging) encodeIEs(e *ieEncoder) {
+	e.add(IDUEPagingIdentity, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeUEPagingIdentity(w, m.UEPagingIdentity)
+	})
+	if m.PagingDRX != nil {
+		e.add(IDPagingDRX, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePagingDRX(w, *m.PagingDRX)
+		})
+	}
+	e.add(IDTAIListForPaging, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeTAIListForPaging(w, m.TAIListForPaging)
+	})
+	if m.PagingPriority != nil {
+		e.add(IDPagingPriority, CriticalityIgnore, func(w *aper.Writer) error {
+			// PagingPriority ::= ENUMERATED {priolevel1, ..., priolevel8, ...}
+			return w.WriteEnumerated(uint64(*m.PagingPriority), 8, true)
+		})
+	}
+}
+
+func (m *Paging) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDUEPagingIdentity, func(r *aper.Reader) (err error) {
+		m.UEPagingIdentity, err = readUEPagingIdentity(r)
+		return err
+	})
+	d.optional(IDPagingDRX, func(r *aper.Reader) error {
+		drx, err := readPagingDRX(r)
+		m.PagingDRX = &drx
+		return err
+	})
+	d.mandatory(IDTAIListForPaging, func(r *aper.Reader) (err error) {
+		m.TAIListForPaging, err = readTAIListForPaging(r)
+		return err
+	})
+	d.optional(IDPagingPriority, func(r *aper.Reader) error {
+		v, err := r.ReadEnumerated(8, true)
+		p := uint8(v)
+		m.PagingPriority = &p
+		return err
+	})
+}
+
+// PathSwitchRequest is sent by the target gNB of an Xn handover to move
+// the N2 connection and user plane of a UE to it
+type PathSwitchRequest struct {
+	RANUENGAPID             int64
+	SourceAMFUENGAPID       int64
+	UserLocationInformation UserLocationInformation
+	UESecurityCapabilities  UESecurityCapabilities
+	ToBeSwitched            []PDUSessionResourceItem
+	FailedToSetup           []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PathSwitchRequest) ProcedureCode() ProcedureCode { return ProcedurePathSwitchRequest }
+
+// MessageType implements Message
+func (m *PathSwitchRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *PathSwitchRequest) encodeIEs(e *ieEncoder) {
+	e.add(IDRANUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeRANUENGAPID(w, m.RANUENGAPID)
+	})
+	e.add(IDSourceAMFUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeAMFUENGAPID(w, m.SourceAMFUENGAPID)
+	})
+	e.add(IDUserLocationInformation, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeUserLocationInformation(w, m.UserLocationInformation)
+	})
+	e.add(IDUESecurityCapabilities, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeUESecurityCapabilities(w, m.UESecurityCapabilities)
+	})
+	e.add(IDPDUSessionResourceToBeSwitchedDLList, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.ToBeSwitched)
+	})
+	if len(m.FailedToSetup) > 0 {
+		e.add(IDPDUSessionResourceFailedToSetupListPSReq, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.FailedToSetup)
+		})
+	}
+}
+
+func (m *PathSwitchRequest) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDRANUENGAPID, func(r *aper.Reader) (err error) {
+		m.RANUENGAPID, err = readRANUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDSourceAMFUENGAPID, func(r *aper.Reader) (err error) {
+		m.SourceAMFUENGAPID, err = readAMFUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDUserLocationInformation, func(r *aper.Reader) (err error) {
+		m.UserLocationInformation, err = readUserLocationInformation(r)
+		return err
+	})
+	d.mandatory(IDUESecurityCapabilities, func(r *aper.Reader) (err error) {
+		m.UESecurityCapabilities, err = readUESecurityCapabilities(r)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceToBeSwitchedDLList, func(r *aper.Reader) (err error) {
+		m.ToBeSwitched, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceFailedToSetupListPSReq, func(r *aper.Reader) (err error) {
+		m.FailedToSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// PathSwitchRequestAcknowledge accepts a path switch and gives the target
+// gNB its next hop key
+type PathSwitchRequestAcknowledge struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+
+	// UESecurityCapabilities is set when the capabilities reported by the
+	// gNB differ from the ones the AMF holds
+	UESecurityCapabilities *UESecurityCapabilities
+
+	SecurityContext SecurityContext
+	Switched        []PDUSessionResourceItem
+	Released        []PDUSessionResourceItem
+	AllowedNSSAI    []models.Snssai
+}
+
+// ProcedureCode implements Message
+func (m *PathSwitchRequestAcknowledge) ProcedureCode() ProcedureCode {
+	return ProcedurePathSwitchRequest
+}
+
+// MessageType implements Message
+func (m *PathSwitchRequestAcknowledge) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *PathSwitchRequestAcknowledge) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	if m.UESecurityCapabilities != nil {
+		e.add(IDUESecurityCapabilities, CriticalityReject, func(w *aper.Writer) error {
+			return writeUESecurityCapabilities(w, *m.UESecurityCapabilities)
+		})
+	}
+	e.add(IDSecurityContext, CriticalityReject, func(w *aper.Writer) error {
+		return writeSecurityContext(w, m.SecurityContext)
+	})
+	e.add(IDPDUSessionResourceSwitchedList, CriticalityIgnore, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.Switched)
+	})
+	if len(m.Released) > 0 {
+		e.add(IDPDUSessionResourceReleasedListPSAck, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.Released)
+		})
+	}
+	e.add(IDAllowedNSSAI, CriticalityReject, func(w *aper.Writer) error {
+		return writeAllowedNSSAI(w, m.AllowedNSSAI)
+	})
+}
+
+func (m *PathSwitchRequestAcknowledge) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.optional(IDUESecurityCapabilities, func(r *aper.Reader) error {
+		c, err := readUESecurityCapabilities(r)
+		m.UESecurityCapabilities = &c
+		return err
+	})
+	d.mandatory(IDSecurityContext, func(r *aper.Reader) (err error) {
+		m.SecurityContext, err = readSecurityContext(r)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceSwitchedList, func(r *aper.Reader) (err error) {
+		m.Switched, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceReleasedListPSAck, func(r *aper.Reader) (err error) {
+		m.Released, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.mandatory(IDAllowedNSSAI, func(r *aper.Reader) (err error) {
+		m.AllowedNSSAI, err = readAllowedNSSAI(r)
+		return err
+	})
+}
+
+// PathSwitchRequestFailure rejects a path switch. The PDU sessions are
+// released.
+type PathSwitchRequestFailure struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	Released    []PDUSessionResourceItem
+}
+
+// ProcedureCode implements Message
+func (m *PathSwitchRequestFailure) ProcedureCode() ProcedureCode { return ProcedurePathSwitchRequest }
+
+// MessageType implements Message
+func (m *PathSwitchRequestFailure) MessageType() MessageType { return UnsuccessfulOutcome }
+
+func (m *PathSwitchRequestFailure) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDPDUSessionResourceReleasedListPSFail, CriticalityIgnore, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.Released)
+	})
+}
+
+func (m *PathSwitchRequestFailure) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDPDUSessionResourceReleasedListPSFail, func(r *aper.Reader) (err error) {
+		m.Released, err = readPDUSessionResourceList(r)
+		return err
+	})
+}
+
+// HandoverRequired is sent by the source gNB to start an N2 handover
+type HandoverRequired struct {
+	AMFUENGAPID  int64
+	RANUENGAPID  int64
+	HandoverType HandoverType
+	Cause        Cause
+	TargetID     TargetID
+
+	// DirectForwardingPathAvailable tells that the gNBs can forward data
+	// over Xn
+	DirectForwardingPathAvailable bool
+
+	PDUSessionResources                []PDUSessionResourceItem
+	SourceToTargetTransparentContainer []byte
+}
+
+// ProcedureCode implements Message
+func (m *HandoverRequired) ProcedureCode() ProcedureCode { return ProcedureHandoverPreparation }
+
+// MessageType implements Message
+func (m *HandoverRequired) MessageType() MessageType { return InitiatingMessage }
+
+func (m *HandoverRequired) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDHandoverType, CriticalityReject, func(w *aper.Writer) error {
+		return writeHandoverType(w, m.HandoverType)
+	})
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+	e.add(IDTargetID, CriticalityReject, func(w *aper.Writer) error {
+		return writeTargetID(w, m.TargetID)
+	})
+	if m.DirectForwardingPathAvailable {
+		e.add(IDDirectForwardingPathAvailability, CriticalityIgnore, func(w *aper.Writer) error {
+			// DirectForwardingPathAvailability ::= ENUMERATED
+			// {direct-path-available, ...}
+			return w.WriteEnumerated(0, 1, true)
+		})
+	}
+	e.add(IDPDUSessionResourceListHORqd, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.PDUSessionResources)
+	})
+	e.add(IDSourceToTargetTransparentContainer, CriticalityReject, func(w *aper.Writer) error {
+		return writeTransparentContainer(w, m.SourceToTargetTransparentContainer)
+	})
+}
+
+func (m *HandoverRequired) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDHandoverType, func(r *aper.Reader) (err error) {
+		m.HandoverType, err = readHandoverType(r)
+		return err
+	})
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+	d.mandatory(IDTargetID, func(r *aper.Reader) (err error) {
+		m.TargetID, err = readTargetID(r)
+		return err
+	})
+	m.DirectForwardingPathAvailable = d.optional(IDDirectForwardingPathAvailability, func(r *aper.Reader) error {
+		_, err := r.ReadEnumerated(1, true)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceListHORqd, func(r *aper.Reader) (err error) {
+		m.PDUSessionResources, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.mandatory(IDSourceToTargetTransparentContainer, func(r *aper.Reader) (err error) {
+		m.SourceToTargetTransparentContainer, err = readTransparentContainer(r)
+		return err
+	})
+}
+
+// HandoverCommand tells the source gNB that the target gNB is prepared
+type HandoverCommand struct {
+	AMFUENGAPID                        int64
+	RANUENGAPID                        int64
+	HandoverType                       HandoverType
+	PDUSessionResources                []PDUSessionResourceItem
+	ToRelease                          []PDUSessionResourceItem
+	TargetToSourceTransparentContainer []byte
+}
+
+// ProcedureCode implements Message
+func (m *HandoverCommand) ProcedureCode() ProcedureCode { return ProcedureHandoverPreparation }
+
+// MessageType implements Message
+func (m *HandoverCommand) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *HandoverCommand) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDHandoverType, CriticalityReject, func(w *aper.Writer) error {
+		return writeHandoverType(w, m.HandoverType)
+	})
+	if len(m.PDUSessionResources) > 0 {
+		e.add(IDPDUSessionResourceHandoverList, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.PDUSessionResources)
+		})
+	}
+	if len(m.ToRelease) > 0 {
+		e.add(IDPDUSessionResourceToReleaseListHOCmd, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.ToRelease)
+		})
+	}
+	e.add(IDTargetToSourceTransparentContainer, CriticalityReject, func(w *aper.Writer) error {
+		return writeTransparentContainer(w, m.TargetToSourceTransparentContainer)
+	})
+}
+
+func (m *HandoverCommand) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDHandoverType, func(r *aper.Reader) (err error) {
+		m.HandoverType, err = readHandoverType(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceHandoverList, func(r *aper.Reader) (err error) {
+		m.PDUSessionResources, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceToReleaseListHOCmd, func(r *aper.Reader) (err error) {
+		m.ToRelease, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.mandatory(IDTargetToSourceTransparentContainer, func(r *aper.Reader) (err error) {
+		m.TargetToSourceTransparentContainer, err = readTransparentContainer(r)
+		return err
+	})
+}
+
+// HandoverPreparationFailure tells the source gNB that the handover
+// cannot take place
+type HandoverPreparationFailure struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	Cause       Cause
+}
+
+// ProcedureCode implements Message
+func (m *HandoverPreparationFailure) ProcedureCode() ProcedureCode {
+	return ProcedureHandoverPreparation
+}
+
+// MessageType implements Message
+func (m *HandoverPreparationFailure) MessageType() MessageType { return UnsuccessfulOutcome }
+
+func (m *HandoverPreparationFailure) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *HandoverPreparationFailure) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// HandoverRequest asks the target gNB of an N2 handover to allocate
+// resources for the UE
+type HandoverRequest struct {
+	AMFUENGAPID                        int64
+	HandoverType                       HandoverType
+	Cause                              Cause
+	UEAggregateMaximumBitRate          UEAggregateMaximumBitRate
+	UESecurityCapabilities             UESecurityCapabilities
+	SecurityContext                    SecurityContext
+	PDUSessionResources                []PDUSessionResourceSetupItemHOReq
+	AllowedNSSAI                       []models.Snssai
+	SourceToTargetTransparentContainer []byte
+	GUAMI                              GUAMI
+}
+
+// ProcedureCode implements Message
+func (m *HandoverRequest) ProcedureCode() ProcedureCode { return ProcedureHandoverResourceAllocation }
+
+// MessageType implements Message
+func (m *HandoverRequest) MessageType() MessageType { return InitiatingMessage }
+
+func (m *HandoverRequest) encodeIEs(e *ieEncoder) {
+	e.add(IDAMFUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeAMFUENGAPID(w, m.AMFUENGAPID)
+	})
+	e.add(IDHandoverType, CriticalityReject, func(w *aper.Writer) error {
+		return writeHandoverType(w, m.HandoverType)
+	})
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+	e.add(IDUEAggregateMaximumBitRate, CriticalityReject, func(w *aper.Writer) error {
+		return writeUEAggregateMaximumBitRate(w, m.UEAggregateMaximumBitRate)
+	})
+	e.add(IDUESecurityCapabilities, CriticalityReject, func(w *aper.Writer) error {
+		return writeUESecurityCapabilities(w, m.UESecurityCapabilities)
+	})
+	e.add(IDSecurityContext, CriticalityReject, func(w *aper.Writer) error {
+		return writeSecurityContext(w, m.SecurityContext)
+	})
+	e.add(IDPDUSessionResourceSetupListHOReq, CriticalityReject, func(w *aper.Writer) error {
+		return writePDUSessionResourceSetupListHOReq(w, m.PDUSessionResources)
+	})
+	e.add(IDAllowedNSSAI, CriticalityReject, func(w *aper.Writer) error {
+		return writeAllowedNSSAI(w, m.AllowedNSSAI)
+	})
+	e.add(IDSourceToTargetTransparentContainer, CriticalityReject, func(w *aper.Writer) error {
+		return writeTransparentContainer(w, m.SourceToTargetTransparentContainer)
+	})
+	e.add(IDGUAMI, CriticalityReject, func(w *aper.Writer) error {
+		return writeGUAMI(w, m.GUAMI)
+	})
+}
+
+func (m *HandoverRequest) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDAMFUENGAPID, func(r *aper.Reader) (err error) {
+		m.AMFUENGAPID, err = readAMFUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDHandoverType, func(r *aper.Reader) (err error) {
+		m.HandoverType, err = readHandoverType(r)
+		return err
+	})
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+	d.mandatory(IDUEAggregateMaximumBitRate, func(r *aper.Reader) (err error) {
+		m.UEAggregateMaximumBitRate, err = readUEAggregateMaximumBitRate(r)
+		return err
+	})
+	d.mandatory(IDUESecurityCapabilities, func(r *aper.Reader) (err error) {
+		m.UESecurityCapabilities, err = readUESecurityCapabilities(r)
+		return err
+	})
+	d.mandatory(IDSecurityContext, func(r *aper.Reader) (err error) {
+		m.SecurityContext, err = readSecurityContext(r)
+		return err
+	})
+	d.mandatory(IDPDUSessionResourceSetupListHOReq, func(r *aper.Reader) (err error) {
+		m.PDUSessionResources, err = readPDUSessionResourceSetupListHOReq(r)
+		return err
+	})
+	d.mandatory(IDAllowedNSSAI, func(r *aper.Reader) (err error) {
+		m.AllowedNSSAI, err = readAllowedNSSAI(r)
+		return err
+	})
+	d.mandatory(IDSourceToTargetTransparentContainer, func(r *aper.Reader) (err error) {
+		m.SourceToTargetTransparentContainer, err = readTransparentContainer(r)
+		return err
+	})
+	d.mandatory(IDGUAMI, func(r *aper.Reader) (err error) {
+		m.GUAMI, err = readGUAMI(r)
+		return err
+	})
+}
+
+// HandoverRequestAcknowledge reports the resources the target gNB
+// allocated
+type HandoverRequestAcknowledge struct {
+	AMFUENGAPID                        int64
+	RANUENGAPID                        int64
+	Admitted                           []PDUSessionResourceItem
+	FailedToSetup                      []PDUSessionResourceItem
+	TargetToSourceTransparentContainer []byte
+}
+
+// ProcedureCode implements Message
+func (m *HandoverRequestAcknowledge) ProcedureCode() ProcedureCode {
+	return ProcedureHandoverResourceAllocation
+}
+
+// MessageType implements Message
+func (m *HandoverRequestAcknowledge) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *HandoverRequestAcknowledge) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDPDUSessionResourceAdmittedList, CriticalityIgnore, func(w *aper.Writer) error {
+		return writePDUSessionResourceList(w, m.Admitted)
+	})
+	if len(m.FailedToSetup) > 0 {
+		e.add(IDPDUSessionResourceFailedToSetupListHOAck, CriticalityIgnore, func(w *aper.Writer) error {
+			return writePDUSessionResourceList(w, m.FailedToSetup)
+		})
+	}
+	e.add(IDTargetToSourceTransparentContainer, CriticalityReject, func(w *aper.Writer) error {
+		return writeTransparentContainer(w, m.TargetToSourceTransparentContainer)
+	})
+}
+
+func (m *HandoverRequestAcknowledge) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDPDUSessionResourceAdmittedList, func(r *aper.Reader) (err error) {
+		m.Admitted, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.optional(IDPDUSessionResourceFailedToSetupListHOAck, func(r *aper.Reader) (err error) {
+		m.FailedToSetup, err = readPDUSessionResourceList(r)
+		return err
+	})
+	d.mandatory(IDTargetToSourceTransparentContainer, func(r *aper.Reader) (err error) {
+		m.TargetToSourceTransparentContainer, err = readTransparentContainer(r)
+		return err
+	})
+}
+
+// HandoverFailure tells that the target gNB could not allocate the
+// resources of a handover
+type HandoverFailure struct {
+	AMFUENGAPID int64
+	Cause       Cause
+}
+
+// ProcedureCode implements Message
+func (m *HandoverFailure) ProcedureCode() ProcedureCode { return ProcedureHandoverResourceAllocation }
+
+// MessageType implements Message
+func (m *HandoverFailure) MessageType() MessageType { return UnsuccessfulOutcome }
+
+func (m *HandoverFailure) encodeIEs(e *ieEncoder) {
+	e.add(IDAMFUENGAPID, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeAMFUENGAPID(w, m.AMFUENGAPID)
+	})
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *HandoverFailure) decodeIEs(d *ieDecoder) {
+	d.mandatory(IDAMFUENGAPID, func(r *aper.Reader) (err error) {
+		m.AMFUENGAPID, err = readAMFUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// HandoverNotify tells that the UE arrived in the target gNB
+type HandoverNotify struct {
+	AMFUENGAPID             int64
+	RANUENGAPID             int64
+	UserLocationInformation UserLocationInformation
+}
+
+// ProcedureCode implements Message
+func (m *HandoverNotify) ProcedureCode() ProcedureCode { return ProcedureHandoverNotification }
+
+// MessageType implements Message
+func (m *HandoverNotify) MessageType() MessageType { return InitiatingMessage }
+
+func (m *HandoverNotify) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDUserLocationInformation, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeUserLocationInformation(w, m.UserLocationInformation)
+	})
+}
+
+func (m *HandoverNotify) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDUserLocationInformation, func(r *aper.Reader) (err error) {
+		m.UserLocationInformation, err = readUserLocationInformation(r)
+		return err
+	})
+}
+
+// HandoverCancel is sent by the source gNB to abandon a handover
+type HandoverCancel struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	Cause       Cause
+}
+
+// ProcedureCode implements Message
+func (m *HandoverCancel) ProcedureCode() ProcedureCode { return ProcedureHandoverCancel }
+
+// MessageType implements Message
+func (m *HandoverCancel) MessageType() MessageType { return InitiatingMessage }
+
+func (m *HandoverCancel) encodeIEs(e *ieEncoder) {
+	encodeUEIDs(e, m.AMFUENGAPID, m.RANUENGAPID)
+	e.add(IDCause, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeCause(w, m.Cause)
+	})
+}
+
+func (m *HandoverCancel) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+	d.mandatory(IDCause, func(r *aper.Reader) (err error) {
+		m.Cause, err = readCause(r)
+		return err
+	})
+}
+
+// HandoverCancelAcknowledge confirms a handover cancellation
+type HandoverCancelAcknowledge struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+}
+
+// ProcedureCode implements Message
+func (m *HandoverCancelAcknowledge) ProcedureCode() ProcedureCode { return ProcedureHandoverCancel }
+
+// MessageType implements Message
+func (m *HandoverCancelAcknowledge) MessageType() MessageType { return SuccessfulOutcome }
+
+func (m *HandoverCancelAcknowledge) encodeIEs(e *ieEncoder) {
+	encodeUEIDsIgnore(e, m.AMFUENGAPID, m.RANUENGAPID)
+}
+
+func (m *HandoverCancelAcknowledge) decodeIEs(d *ieDecoder) {
+	decodeUEIDs(d, &m.AMFUENGAPID, &m.RANUENGAPID)
+}
+
+// encodeUEIDs adds the AMF and RAN UE NGAP IDs of an AMF initiated UE
+// associated message
+func encodeUEIDs(e *ieEncoder, amfID, ranID int64) {
+	e.add(IDAMFUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeAMFUENGAPID(w, amfID)
+	})
+	e.add(IDRANUENGAPID, CriticalityReject, func(w *aper.Writer) error {
+		return writeRANUENGAPID(w, ranID)
+	})
+}
+
+// encodeUEIDsIgnore adds the AMF and RAN UE NGAP IDs of a response, which
+// are sent with criticality ignore
+func encodeUEIDsIgnore(e *ieEncoder, amfID, ranID int64) {
+	e.add(IDAMFUENGAPID, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeAMFUENGAPID(w, amfID)
+	})
+	e.add(IDRANUENGAPID, CriticalityIgnore, func(w *aper.Writer) error {
+		return writeRANUENGAPID(w, ranID)
+	})
+}
+
+// decodeUEIDs decodes the AMF and RAN UE NGAP IDs of a UE associated
+// message
+func decodeUEIDs(d *ieDecoder, amfID, ranID *int64) {
+	d.mandatory(IDAMFUENGAPID, func(r *aper.Reader) (err error) {
+		*amfID, err = readAMFUENGAPID(r)
+		return err
+	})
+	d.mandatory(IDRANUENGAPID, func(r *aper.Reader) (err error) {
+		*ranID, err = readRANUENGAPID(r)
+		return err
+	})
+}
@@ -0,0 +1,347 @@
+// Package ngap implements the NG Application Protocol (TS 38.413) used on
+// the N2 interface between the gNB and the AMF. Messages are encoded with
+// the ASN.1 aligned PER rules implemented by the aper package.
+package ngap
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/0had0/5G-core/pkg/ngap/aper"
+)
+
+// ProcedureCode identifies an elementary procedure
+type ProcedureCode uint8
+
+const (
+	ProcedureAMFConfigurationUpdate     ProcedureCode = 0
+	ProcedureDownlinkNASTransport       ProcedureCode = 4
+	ProcedureErrorIndication            ProcedureCode = 9
+	ProcedureHandoverCancel             ProcedureCode = 10
+	ProcedureHandoverNotification       ProcedureCode = 11
+	ProcedureHandoverPreparation        ProcedureCode = 12
+	ProcedureHandoverResourceAllocation ProcedureCode = 13
+	ProcedureInitialContextSetup        ProcedureCode = 14
+	ProcedureInitialUEMessage           ProcedureCode = 15
+	ProcedureNGReset                    ProcedureCode = 20
+	ProcedureNGSetup                    ProcedureCode = 21
+	ProcedurePaging                     ProcedureCode = 24
+	ProcedurePathSwitchRequest          ProcedureCode = 25
+	ProcedurePDUSessionResourceModify   ProcedureCode = 26
+	ProcedurePDUSessionResourceRelease  ProcedureCode = 28
+	ProcedurePDUSessionResourceSetup    ProcedureCode = 29
+	ProcedureRANConfigurationUpdate     ProcedureCode = 35
+	ProcedureUEContextModification      ProcedureCode = 40
+	ProcedureUEContextRelease           ProcedureCode = 41
+	ProcedureUEContextReleaseRequest    ProcedureCode = 42
+	ProcedureUplinkNASTransport         ProcedureCode = 46
+)
+
+// Criticality tells the receiver how to react to an unknown element
+type Criticality uint8
+
+const (
+	CriticalityReject Criticality = iota
+	CriticalityIgnore
+	CriticalityNotify
+)
+
+// MessageType is the NGAP-PDU alternative carrying a message
+type MessageType uint8
+
+const (
+	InitiatingMessage MessageType = iota
+	SuccessfulOutcome
+	UnsuccessfulOutcome
+)
+
+// String implements fmt.Stringer
+func (t MessageType) String() string {
+	switch t {
+	case InitiatingMessage:
+		return "initiatingMessage"
+	case SuccessfulOutcome:
+		return "successfulOutcome"
+	case UnsuccessfulOutcome:
+		return "unsuccessfulOutcome"
+	default:
+		return fmt.Sprintf("MessageType(%d)", uint8(t))
+	}
+}
+
+// ProtocolIEID identifies an information element
+type ProtocolIEID uint16
+
+const (
+	IDAllowedNSSAI                               ProtocolIEID = 0
+	IDAMFName                                    ProtocolIEID = 1
+	IDAMFSetID                                   ProtocolIEID = 3
+	IDAMFUENGAPID                                ProtocolIEID = 10
+	IDCause                                      ProtocolIEID = 15
+	IDCriticalityDiagnostics                     ProtocolIEID = 19
+	IDDefaultPagingDRX                           ProtocolIEID = 21
+	IDDirectForwardingPathAvailability           ProtocolIEID = 22
+	IDFiveGSTMSI                                 ProtocolIEID = 26
+	IDGlobalRANNodeID                            ProtocolIEID = 27
+	IDGUAMI                                      ProtocolIEID = 28
+	IDHandoverType                               ProtocolIEID = 29
+	IDMobilityRestrictionList                    ProtocolIEID = 36
+	IDNASPDU                                     ProtocolIEID = 38
+	IDPagingDRX                                  ProtocolIEID = 50
+	IDPagingOrigin                               ProtocolIEID = 51
+	IDPagingPriority                             ProtocolIEID = 52
+	IDPDUSessionResourceAdmittedList             ProtocolIEID = 53
+	IDPDUSessionResourceFailedToModifyListModRes ProtocolIEID = 54
+	IDPDUSessionResourceFailedToSetupListCxtRes  ProtocolIEID = 55
+	IDPDUSessionResourceFailedToSetupListHOAck   ProtocolIEID = 56
+	IDPDUSessionResourceFailedToSetupListPSReq   ProtocolIEID = 57
+	IDPDUSessionResourceFailedToSetupListSURes   ProtocolIEID = 58
+	IDPDUSessionResourceHandoverList             ProtocolIEID = 59
+	IDPDUSessionResourceListCxtRelCpl            ProtocolIEID = 60
+	IDPDUSessionResourceListHORqd                ProtocolIEID = 61
+	IDPDUSessionResourceModifyListModReq         ProtocolIEID = 64
+	IDPDUSessionResourceModifyListModRes         ProtocolIEID = 65
+	IDPDUSessionResourceReleasedListPSAck        ProtocolIEID = 68
+	IDPDUSessionResourceReleasedListPSFail       ProtocolIEID = 69
+	IDPDUSessionResourceReleasedListRelRes       ProtocolIEID = 70
+	IDPDUSessionResourceSetupListCxtReq          ProtocolIEID = 71
+	IDPDUSessionResourceSetupListCxtRes          ProtocolIEID = 72
+	IDPDUSessionResourceSetupListHOReq           ProtocolIEID = 73
+	IDPDUSessionResourceSetupListSUReq           ProtocolIEID = 74
+	IDPDUSessionResourceSetupListSURes           ProtocolIEID = 75
+	IDPDUSessionResourceToBeSwitchedDLList       ProtocolIEID = 76
+	IDPDUSessionResourceSwitchedList             ProtocolIEID = 77
+	IDPDUSessionResourceToReleaseListHOCmd       ProtocolIEID = 78
+	IDPDUSessionResourceToReleaseListRelCmd      ProtocolIEID = 79
+	IDPLMNSupportList                            ProtocolIEID = 80
+	IDRANNodeName                                ProtocolIEID = 82
+	IDRANPagingPriority                          ProtocolIEID = 83
+	IDRANUENGAPID                                ProtocolIEID = 85
+	IDRelativeAMFCapacity                        ProtocolIEID = 86
+	IDRRCEstablishmentCause                      ProtocolIEID = 90
+	IDSecurityContext                            ProtocolIEID = 93
+	IDSecurityKey                                ProtocolIEID = 94
+	IDServedGUAMIList                            ProtocolIEID = 96
+	IDSourceAMFUENGAPID                          ProtocolIEID = 100
+	IDSourceToTargetTransparentContainer         ProtocolIEID = 101
+	IDSupportedTAList                            ProtocolIEID = 102
+	IDTAIListForPaging                           ProtocolIEID = 103
+	IDTargetID                                   ProtocolIEID = 105
+	IDTargetToSourceTransparentContainer         ProtocolIEID = 106
+	IDTimeToWait                                 ProtocolIEID = 107
+	IDUEAggregateMaximumBitRate                  ProtocolIEID = 110
+	IDUEContextRequest                           ProtocolIEID = 112
+	IDUENGAPIDs                                  ProtocolIEID = 114
+	IDUEPagingIdentity                           ProtocolIEID = 115
+	IDUESecurityCapabilities                     ProtocolIEID = 119
+	IDUserLocationInformation                    ProtocolIEID = 121
+	IDPDUSessionAggregateMaximumBitRate          ProtocolIEID = 130
+	IDPDUSessionResourceListCxtRelReq            ProtocolIEID = 133
+	IDPDUSessionType                             ProtocolIEID = 134
+	IDQosFlowAddOrModifyRequestList              ProtocolIEID = 135
+	IDQosFlowSetupRequestList                    ProtocolIEID = 136
+	IDQosFlowToReleaseList                       ProtocolIEID = 137
+	IDULNGUUPTNLInformation                      ProtocolIEID = 139
+)
+
+// maxProtocolIEs bounds the number of IEs in a message
+const maxProtocolIEs = 65535
+
+// ErrUnsupportedMessage is returned when decoding a procedure or message
+// type this package does not implement
+var ErrUnsupportedMessage = errors.New("ngap: unsupported message")
+
+// ProtocolIE is an information element as carried in a ProtocolIE-Container,
+// with its value still encoded
+type ProtocolIE struct {
+	ID          ProtocolIEID
+	Criticality Criticality
+	Value       []byte
+}
+
+// Message is an NGAP message
+type Message interface {
+	// ProcedureCode returns the elementary procedure of the message
+	ProcedureCode() ProcedureCode
+
+	// MessageType returns the NGAP-PDU alternative of the message
+	MessageType() MessageType
+
+	encodeIEs(*ieEncoder)
+	decodeIEs(*ieDecoder)
+}
+
+// procedureCriticality holds the criticality of each procedure
+var procedureCriticality = map[ProcedureCode]Criticality{
+	ProcedureDownlinkNASTransport:       CriticalityIgnore,
+	ProcedureErrorIndication:            CriticalityIgnore,
+	ProcedureHandoverCancel:             CriticalityReject,
+	ProcedureHandoverNotification:       CriticalityIgnore,
+	ProcedureHandoverPreparation:        CriticalityReject,
+	ProcedureHandoverResourceAllocation: CriticalityReject,
+	ProcedureInitialContextSetup:        CriticalityReject,
+	ProcedureInitialUEMessage:           CriticalityIgnore,
+	ProcedureNGReset:                    CriticalityReject,
+	ProcedureNGSetup:                    CriticalityReject,
+	ProcedurePaging:                     CriticalityIgnore,
+	ProcedurePathSwitchRequest:          CriticalityReject,
+	ProcedurePDUSessionResourceModify:   CriticalityReject,
+	ProcedurePDUSessionResourceRelease:  CriticalityReject,
+	ProcedurePDUSessionResourceSetup:    CriticalityReject,
+	ProcedureUEContextModification:      CriticalityReject,
+	ProcedureUEContextRelease:           CriticalityReject,
+	ProcedureUEContextReleaseRequest:    CriticalityIgnore,
+	ProcedureUplinkNASTransport:         CriticalityIgnore,
+}
+
+// messageKey identifies a message by procedure and PDU alternative
+type messageKey struct {
+	procedure   ProcedureCode
+	messageType MessageType
+}
+
+// messageFactories creates empty messages for decoding
+var messageFactories = map[messageKey]func() Message{}
+
+// register makes a message type decodable
+func register(factory func() Message) {
+	m := factory()
+	messageFactories[messageKey{m.ProcedureCode(), m.MessageType()}] = factory
+}
+
+// Encode encodes a message as an NGAP-PDU
+func Encode(msg Message) ([]byte, error) {
+	enc := &ieEncoder{}
+	msg.encodeIEs(enc)
+	if enc.err != nil {
+		return nil, fmt.Errorf("ngap: encoding %T: %w", msg, enc.err)
+	}
+
+	w := aper.NewWriter()
+	err := encodePDU(w, msg, enc.ies)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: encoding %T: %w", msg, err)
+	}
+	return w.Bytes(), nil
+}
+
+// encodePDU writes the NGAP-PDU around the IEs of msg
+func encodePDU(w *aper.Writer, msg Message, ies []ProtocolIE) error {
+	// NGAP-PDU ::= CHOICE { initiatingMessage, successfulOutcome,
+	// unsuccessfulOutcome, ... }
+	if err := w.WriteChoice(uint64(msg.MessageType()), 3, true); err != nil {
+		return err
+	}
+	if err := w.WriteConstrainedWholeNumber(int64(msg.ProcedureCode()), 0, 255); err != nil {
+		return err
+	}
+	if err := w.WriteEnumerated(uint64(procedureCriticality[msg.ProcedureCode()]), 3, false); err != nil {
+		return err
+	}
+
+	return w.WriteOpenType(func(w *aper.Writer) error {
+		return writeIEContainer(w, ies)
+	})
+}
+
+// writeIEContainer writes SEQUENCE { protocolIEs ProtocolIE-Container,
+// ... }, the shape of every message and of some transfers
+func writeIEContainer(w *aper.Writer, ies []ProtocolIE) error {
+	w.WriteBool(false)
+	if err := w.WriteLength(int64(len(ies)), 0, maxProtocolIEs); err != nil {
+		return err
+	}
+	for _, ie := range ies {
+		if err := w.WriteConstrainedWholeNumber(int64(ie.ID), 0, 65535); err != nil {
+			return err
+		}
+		if err := w.WriteEnumerated(uint64(ie.Criticality), 3, false); err != nil {
+			return err
+		}
+		if err := w.WriteOpenTypeBytes(ie.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decode decodes an NGAP-PDU
+func Decode(b []byte) (Message, error) {
+	r := aper.NewReader(b)
+
+	messageType, err := r.ReadChoice(3, true)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding PDU: %w", err)
+	}
+	if messageType >= 3 {
+		return nil, fmt.Errorf("%w: PDU alternative %d", ErrUnsupportedMessage, messageType)
+	}
+	procedure, err := r.ReadConstrainedWholeNumber(0, 255)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding PDU: %w", err)
+	}
+	if _, err := r.ReadEnumerated(3, false); err != nil {
+		return nil, fmt.Errorf("ngap: decoding PDU: %w", err)
+	}
+	value, err := r.ReadOpenType()
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding PDU: %w", err)
+	}
+
+	key := messageKey{ProcedureCode(procedure), MessageType(messageType)}
+	factory, ok := messageFactories[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: procedure %d %s", ErrUnsupportedMessage, procedure, key.messageType)
+	}
+
+	ies, err := decodeIEContainer(value)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding procedure %d: %w", procedure, err)
+	}
+
+	msg := factory()
+	dec := &ieDecoder{ies: ies}
+	msg.decodeIEs(dec)
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("ngap: decoding %T: %w", msg, err)
+	}
+	return msg, nil
+}
+
+// decodeIEContainer splits a message value into its IEs
+func decodeIEContainer(b []byte) ([]ProtocolIE, error) {
+	r := aper.NewReader(b)
+
+	ext, err := r.ReadBool()
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.ReadLength(0, maxProtocolIEs)
+	if err != nil {
+		return nil, err
+	}
+
+	ies := make([]ProtocolIE, 0, n)
+	for i := int64(0); i < n; i++ {
+		id, err := r.ReadConstrainedWholeNumber(0, 65535)
+		if err != nil {
+			return nil, err
+		}
+		criticality, err := r.ReadEnumerated(3, false)
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.ReadOpenType()
+		if err != nil {
+			return nil, err
+		}
+		ies = append(ies, ProtocolIE{ID: ProtocolIEID(id), Criticality: Criticality(criticality), Value: value})
+	}
+
+	if ext {
+		if err := r.SkipExtensionAdditions(); err != nil {
+			return nil, err
+		}
+	}
+	return ies, nil
+}
@@ -0,0 +1,368 @@
+package ngap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+var (
+	testPLMN = models.PlmnID{Mcc: "208", Mnc: "93"}
+	testTAI  = TAI{PLMNIdentity: testPLMN, TAC: 1}
+	testULI  = UserLocationInformation{NRCGI: NRCGI{PLMNIdentity: testPLMN, NRCellIdentity: 0x10}, TAI: testTAI}
+	testKey  = bytes.Repeat([]byte{0xab}, 32)
+)
+
+// Encodings of the test values used by several messages
+const (
+	plmnHex = "02f839"
+	uliHex  = "40" + plmnHex + "0000000100" + plmnHex + "000001" // NR, cell 0x10, TAC 1
+)
+
+// unhex decodes an encoding written as hex parts with optional spaces
+func unhex(t *testing.T, parts []string) []byte {
+	t.Helper()
+
+	s := strings.ReplaceAll(strings.Join(parts, ""), " ", "")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad encoding %q: %v", s, err)
+	}
+	return b
+}
+
+// The encodings are laid out IE by IE: id, criticality, open type
+// length, then the value
+var codecTests = []struct {
+	name string
+	pdu  []string
+	msg  Message
+}{
+	{
+		name: "NG setup request",
+		pdu: []string{
+			"00 15 00 32", // initiatingMessage, NGSetup, reject
+			"00 0004",
+			"001b 00 09", "00 " + plmnHex + " 50 00000001", // GlobalRANNodeID, 32 bit gNB ID 1
+			"0052 40 05", "0100 676e62", // RANNodeName "gnb"
+			"0066 00 10", "00 00 000001 00 " + plmnHex + " 0000 1008 010203", // SupportedTAList
+			"0015 40 01", "40", // DefaultPagingDRX v128
+		},
+		msg: &NGSetupRequest{
+			GlobalRANNodeID: GlobalRANNodeID{PLMNIdentity: testPLMN, GNBID: 1, GNBIDLength: 32},
+			RANNodeName:     "gnb",
+			SupportedTAList: []SupportedTAItem{{
+				TAC: 1,
+				BroadcastPLMNList: []BroadcastPLMNItem{{
+					PLMNIdentity:     testPLMN,
+					SliceSupportList: []models.Snssai{{Sst: 1, Sd: "010203"}},
+				}},
+			}},
+			DefaultPagingDRX: PagingDRX128,
+		},
+	},
+	{
+		name: "NG setup response",
+		pdu: []string{
+			"20 15 00 2c", // successfulOutcome, NGSetup, reject
+			"00 0004",
+			"0001 00 05", "0100 616d66", // AMFName "amf"
+			"0060 00 08", "00 00 " + plmnHex + " cafe00", // ServedGUAMIList, region 0xca, set 1016, pointer 0
+			"0056 40 01", "ff", // RelativeAMFCapacity 255
+			"0050 00 0b", "00 " + plmnHex + " 0000 1008 010203", // PLMNSupportList
+		},
+		msg: &NGSetupResponse{
+			AMFName: "amf",
+			ServedGUAMIList: []ServedGUAMIItem{{
+				GUAMI: GUAMI{PLMNIdentity: testPLMN, AMFRegionID: 0xca, AMFSetID: 1016},
+			}},
+			RelativeAMFCapacity: 255,
+			PLMNSupportList: []PLMNSupportItem{{
+				PLMNIdentity:     testPLMN,
+				SliceSupportList: []models.Snssai{{Sst: 1, Sd: "010203"}},
+			}},
+		},
+	},
+	{
+		name: "initial UE message",
+		pdu: []string{
+			"00 0f 40 43", // initiatingMessage, InitialUEMessage, ignore
+			"00 0006",
+			"0055 00 02", "00 01", // RAN-UE-NGAP-ID 1
+			"0026 00 0e", "0d 7e004c100007f4004100000001", // NAS-PDU, Service Request
+			"0079 00 0f", uliHex,
+			"005a 40 01", "20", // RRCEstablishmentCause mo-Data
+			"001a 00 07", "00 10 40 00000001", // 5G-S-TMSI, set 1, pointer 1, TMSI 1
+			"0070 40 01", "00", // UEContextRequest
+		},
+		msg: &InitialUEMessage{
+			RANUENGAPID:             1,
+			NASPDU:                  []byte{0x7e, 0x00, 0x4c, 0x10, 0x00, 0x07, 0xf4, 0x00, 0x41, 0x00, 0x00, 0x00, 0x01},
+			UserLocationInformation: testULI,
+			RRCEstablishmentCause:   RRCEstablishmentCauseMoData,
+			FiveGSTMSI:              &FiveGSTMSI{AMFSetID: 1, AMFPointer: 1, FiveGTMSI: 1},
+			UEContextRequested:      true,
+		},
+	},
+	{
+		name: "downlink NAS transport",
+		pdu: []string{
+			"00 04 40 1b", // initiatingMessage, DownlinkNASTransport, ignore
+			"00 0003",
+			"000a 00 03", "20 1234", // AMF-UE-NGAP-ID 0x1234, two octets
+			"0055 00 04", "80 010000", // RAN-UE-NGAP-ID 0x10000, three octets
+			"0026 00 05", "04 7e005b01", // NAS-PDU, Identity Request
+		},
+		msg: &DownlinkNASTransport{
+			AMFUENGAPID: 0x1234,
+			RANUENGAPID: 0x10000,
+			NASPDU:      []byte{0x7e, 0x00, 0x5b, 0x01},
+		},
+	},
+	{
+		name: "uplink NAS transport",
+		pdu: []string{
+			"00 2e 40 2d", // initiatingMessage, UplinkNASTransport, ignore
+			"00 0004",
+			"000a 00 03", "20 1234",
+			"0055 00 04", "80 010000",
+			"0026 00 04", "03 7e005e", // NAS-PDU, Security Mode Complete
+			"0079 40 0f", uliHex,
+		},
+		msg: &UplinkNASTransport{
+			AMFUENGAPID:             0x1234,
+			RANUENGAPID:             0x10000,
+			NASPDU:                  []byte{0x7e, 0x00, 0x5e},
+			UserLocationInformation: testULI,
+		},
+	},
+	{
+		name: "initial context setup request",
+		pdu: []string{
+			"00 0e 00 6b", // initiatingMessage, InitialContextSetup, reject
+			"00 0008",
+			"000a 00 02", "00 01",
+			"0055 00 02", "00 01",
+			"006e 00 0a", "0c 3b9aca00 30 3b9aca00", // UEAggregateMaximumBitRate, 1 Gbps each way
+			"001c 00 07", "00 " + plmnHex + " cafe00", // GUAMI
+			"0000 00 05", "02 01 010203", // AllowedNSSAI
+			"0077 00 09", "1c 000e 0007 0003 8000", // UESecurityCapabilities, NEA1-3 and NIA1-3
+			"005e 00 20", strings.Repeat("ab", 32), // SecurityKey
+			"0026 40 05", "04 7e004201", // NAS-PDU, Registration Accept
+		},
+		msg: &InitialContextSetupRequest{
+			AMFUENGAPID:               1,
+			RANUENGAPID:               1,
+			UEAggregateMaximumBitRate: &UEAggregateMaximumBitRate{Downlink: 1e9, Uplink: 1e9},
+			GUAMI:                     GUAMI{PLMNIdentity: testPLMN, AMFRegionID: 0xca, AMFSetID: 1016},
+			AllowedNSSAI:              []models.Snssai{{Sst: 1, Sd: "010203"}},
+			UESecurityCapabilities: UESecurityCapabilities{
+				NREncryptionAlgorithms:             0xe000,
+				NRIntegrityProtectionAlgorithms:    0xe000,
+				EUTRAEncryptionAlgorithms:          0xe000,
+				EUTRAIntegrityProtectionAlgorithms: 0xe000,
+			},
+			SecurityKey: testKey,
+			NASPDU:      []byte{0x7e, 0x00, 0x42, 0x01},
+		},
+	},
+	{
+		name: "initial context setup response",
+		pdu: []string{
+			"20 0e 00 22", // successfulOutcome, InitialContextSetup, reject
+			"00 0003",
+			"000a 40 02", "00 01",
+			"0055 40 02", "00 01",
+			"0048 40 0f", "00 00 01 0b 0003e00a000002 00000001", // PDU session 1 and its transfer
+		},
+		msg: &InitialContextSetupResponse{
+			AMFUENGAPID: 1,
+			RANUENGAPID: 1,
+			PDUSessionResourceSetup: []PDUSessionResourceItem{{
+				PDUSessionID: 1,
+				Transfer:     []byte{0x00, 0x03, 0xe0, 0x0a, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01},
+			}},
+		},
+	},
+	{
+		name: "PDU session resource setup request",
+		pdu: []string{
+			"00 1d 00 3c", // initiatingMessage, PDUSessionResourceSetup, reject
+			"00 0004",
+			"000a 00 02", "00 01",
+			"0055 00 02", "00 01",
+			"0026 00 0b", "0a 7e00680100042e0101c2", // NAS-PDU, DL NAS Transport
+			"004a 00 1a", "00 0001 4020 010203 11 0000010082000a01f00a00000100000001", // PDU session 1, S-NSSAI, transfer
+		},
+		msg: &PDUSessionResourceSetupRequest{
+			AMFUENGAPID: 1,
+			RANUENGAPID: 1,
+			NASPDU:      []byte{0x7e, 0x00, 0x68, 0x01, 0x00, 0x04, 0x2e, 0x01, 0x01, 0xc2},
+			PDUSessionResourceSetup: []PDUSessionResourceSetupItem{{
+				PDUSessionID: 1,
+				SNSSAI:       models.Snssai{Sst: 1, Sd: "010203"},
+				Transfer: []byte{0x00, 0x00, 0x01, 0x00, 0x82, 0x00, 0x0a, 0x01, 0xf0,
+					0x0a, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01},
+			}},
+		},
+	},
+	{
+		name: "PDU session resource setup response",
+		pdu: []string{
+			"20 1d 00 22", // successfulOutcome, PDUSessionResourceSetup, reject
+			"00 0003",
+			"000a 40 02", "00 01",
+			"0055 40 02", "00 01",
+			"004b 40 0f", "00 00 01 0b 0003e00a000002 00000001",
+		},
+		msg: &PDUSessionResourceSetupResponse{
+			AMFUENGAPID: 1,
+			RANUENGAPID: 1,
+			PDUSessionResourceSetup: []PDUSessionResourceItem{{
+				PDUSessionID: 1,
+				Transfer:     []byte{0x00, 0x03, 0xe0, 0x0a, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x01},
+			}},
+		},
+	},
+	{
+		name: "PDU session resource release command",
+		pdu: []string{
+			"00 1c 00 28", // initiatingMessage, PDUSessionResourceRelease, reject
+			"00 0004",
+			"000a 00 02", "00 01",
+			"0055 00 02", "00 01",
+			"0026 40 0c", "0b 7e00680100052e0101d324", // NAS-PDU, DL NAS Transport
+			"004f 00 05", "00 00 01 01 10", // PDU session 1, transfer with cause nas normal-release
+		},
+		msg: &PDUSessionResourceReleaseCommand{
+			AMFUENGAPID: 1,
+			RANUENGAPID: 1,
+			NASPDU:      []byte{0x7e, 0x00, 0x68, 0x01, 0x00, 0x05, 0x2e, 0x01, 0x01, 0xd3, 0x24},
+			ToRelease:   []PDUSessionResourceItem{{PDUSessionID: 1, Transfer: []byte{0x10}}},
+		},
+	},
+	{
+		name: "PDU session resource release response",
+		pdu: []string{
+			"20 1c 00 18", // successfulOutcome, PDUSessionResourceRelease, reject
+			"00 0003",
+			"000a 40 02", "00 01",
+			"0055 40 02", "00 01",
+			"0046 40 05", "00 00 01 01 00", // PDU session 1, empty transfer
+		},
+		msg: &PDUSessionResourceReleaseResponse{
+			AMFUENGAPID: 1,
+			RANUENGAPID: 1,
+			Released:    []PDUSessionResourceItem{{PDUSessionID: 1, Transfer: []byte{0x00}}},
+		},
+	},
+	{
+		name: "UE context release request",
+		pdu: []string{
+			"00 2a 40 1c", // initiatingMessage, UEContextReleaseRequest, ignore
+			"00 0004",
+			"000a 00 02", "00 01",
+			"0055 00 02", "00 01",
+			"0085 00 03", "00 00 01", // PDU session 1
+			"000f 40 02", "05 00", // Cause radioNetwork user-inactivity
+		},
+		msg: &UEContextReleaseRequest{
+			AMFUENGAPID:         1,
+			RANUENGAPID:         1,
+			PDUSessionResources: []uint8{1},
+			Cause:               CauseRadioNetworkUserInactivity,
+		},
+	},
+	{
+		name: "UE context release command",
+		pdu: []string{
+			"00 29 00 10", // initiatingMessage, UEContextRelease, reject
+			"00 0002",
+			"0072 00 04", "00 01 00 01", // UE-NGAP-ID pair
+			"000f 40 01", "40", // Cause nas normal-release
+		},
+		msg: &UEContextReleaseCommand{
+			UENGAPIDs: UENGAPIDs{AMFUENGAPID: 1, RANUENGAPID: 1},
+			Cause:     CauseNASNormalRelease,
+		},
+	},
+	{
+		name: "UE context release command with the AMF UE NGAP ID",
+		pdu: []string{
+			"00 29 00 0e",
+			"00 0002",
+			"0072 00 02", "40 01", // aMF-UE-NGAP-ID alternative
+			"000f 40 01", "40",
+		},
+		msg: &UEContextReleaseCommand{
+			UENGAPIDs: UENGAPIDs{AMFUENGAPID: 1, AMFOnly: true},
+			Cause:     CauseNASNormalRelease,
+		},
+	},
+	{
+		name: "UE context release complete",
+		pdu: []string{
+			"20 29 00 29", // successfulOutcome, UEContextRelease, reject
+			"00 0004",
+			"000a 40 02", "00 01",
+			"0055 40 02", "00 01",
+			"0079 40 0f", uliHex,
+			"003c 00 03", "00 00 01",
+		},
+		msg: &UEContextReleaseComplete{
+			AMFUENGAPID:             1,
+			RANUENGAPID:             1,
+			UserLocationInformation: &testULI,
+			PDUSessionResources:     []uint8{1},
+		},
+	},
+	{
+		name: "paging",
+		pdu: []string{
+			"00 18 40 23", // initiatingMessage, Paging, ignore
+			"00 0004",
+			"0073 40 07", "00 08 20 00000001", // UEPagingIdentity, set 1, pointer 1, TMSI 1
+			"0032 40 01", "40", // PagingDRX v128
+			"0067 40 07", "00 " + plmnHex + " 000001", // TAIListForPaging
+			"0034 40 01", "20", // PagingPriority priolevel3
+		},
+		msg: &Paging{
+			UEPagingIdentity: FiveGSTMSI{AMFSetID: 1, AMFPointer: 1, FiveGTMSI: 1},
+			PagingDRX:        ptr(PagingDRX128),
+			TAIListForPaging: []TAI{testTAI},
+			PagingPriority:   ptr(uint8(2)),
+		},
+	},
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestCodec(t *testing.T) {
+	for _, tt := range codecTests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := unhex(t, tt.pdu)
+
+			msg, err := Decode(pdu)
+			if err != nil {
+				t.Fatalf("Decode() error: %v", err)
+			}
+			if !reflect.DeepEqual(msg, tt.msg) {
+				t.Errorf("Decode() = %+v, want %+v", msg, tt.msg)
+			}
+
+			b, err := Encode(msg)
+			if err != nil {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			if !bytes.Equal(b, pdu) {
+				t.Errorf("Encode() = %x, want %x", b, pdu)
+			}
+		})
+	}
+}
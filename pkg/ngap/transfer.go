@@ -0,0 +1,884 @@
+package ngap
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/0had0/5G-core/pkg/ngap/aper"
+)
+
+// Size bounds of the transfer lists (TS 38.413 9.5)
+const (
+	maxnoofQosFlows                  = 64
+	maxnoofMultiConnectivityMinusOne = 3
+)
+
+// PDUSessionType is the type of a PDU session as told to the gNB
+type PDUSessionType uint8
+
+const (
+	PDUSessionTypeIPv4 PDUSessionType = iota
+	PDUSessionTypeIPv6
+	PDUSessionTypeIPv4v6
+	PDUSessionTypeEthernet
+	PDUSessionTypeUnstructured
+)
+
+// GTPTunnel is the endpoint of a GTP-U tunnel of the user plane
+type GTPTunnel struct {
+	// Address is an IPv4 or IPv6 transport layer address
+	Address net.IP
+	TEID    uint32
+}
+
+// writeUPTransportLayerInformation writes an UPTransportLayerInformation
+// holding a GTP tunnel
+func writeUPTransportLayerInformation(w *aper.Writer, t GTPTunnel) error {
+	// UPTransportLayerInformation ::= CHOICE { gTPTunnel, choice-Extensions }
+	if err := w.WriteChoice(0, 2, false); err != nil {
+		return err
+	}
+
+	// GTPTunnel ::= SEQUENCE { transportLayerAddress, gTP-TEID,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	addr := t.Address.To4()
+	if addr == nil {
+		addr = t.Address.To16()
+	}
+	if addr == nil {
+		return fmt.Errorf("invalid transport layer address %v", t.Address)
+	}
+	// TransportLayerAddress ::= BIT STRING (SIZE(1..160, ...))
+	if err := w.WriteBitString(addr, int64(len(addr)*8), 1, 160, true); err != nil {
+		return err
+	}
+	teid := []byte{uint8(t.TEID >> 24), uint8(t.TEID >> 16), uint8(t.TEID >> 8), uint8(t.TEID)}
+	return w.WriteOctetString(teid, 4, 4, false)
+}
+
+// readUPTransportLayerInformation reads an UPTransportLayerInformation
+func readUPTransportLayerInformation(r *aper.Reader) (GTPTunnel, error) {
+	var t GTPTunnel
+
+	choice, err := r.ReadChoice(2, false)
+	if err != nil {
+		return t, err
+	}
+	if choice != 0 {
+		return t, fmt.Errorf("%w: UPTransportLayerInformation alternative %d", ErrUnsupportedMessage, choice)
+	}
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return t, err
+	}
+	addr, n, err := r.ReadBitString(1, 160, true)
+	if err != nil {
+		return t, err
+	}
+	switch n {
+	case 32, 128:
+		t.Address = net.IP(addr[:n/8])
+	case 160:
+		// An IPv4 and an IPv6 address; the IPv4 one is kept
+		t.Address = net.IP(addr[:4])
+	default:
+		return t, fmt.Errorf("invalid transport layer address length %d", n)
+	}
+	teid, err := r.ReadOctetString(4, 4, false)
+	if err != nil {
+		return t, err
+	}
+	t.TEID = uint32(teid[0])<<24 | uint32(teid[1])<<16 | uint32(teid[2])<<8 | uint32(teid[3])
+	return t, seq.end(r, 0)
+}
+
+// writeQosFlowIdentifier writes a QosFlowIdentifier, INTEGER (0..63, ...)
+func writeQosFlowIdentifier(w *aper.Writer, qfi uint8) error {
+	return w.WriteInteger(int64(qfi), 0, 63, true)
+}
+
+// readQosFlowIdentifier reads a QosFlowIdentifier
+func readQosFlowIdentifier(r *aper.Reader) (uint8, error) {
+	v, err := r.ReadInteger(0, 63, true)
+	return uint8(v), err
+}
+
+// writeBitRate writes a BitRate, INTEGER (0..4000000000000, ...)
+func writeBitRate(w *aper.Writer, bps uint64) error {
+	return w.WriteInteger(int64(bps), 0, maxBitRate, true)
+}
+
+// readBitRate reads a BitRate
+func readBitRate(r *aper.Reader) (uint64, error) {
+	v, err := r.ReadInteger(0, maxBitRate, true)
+	return uint64(v), err
+}
+
+// AllocationAndRetentionPriority is the ARP of a QoS flow
+type AllocationAndRetentionPriority struct {
+	// PriorityLevel ranges from 1, the highest, to 15
+	PriorityLevel uint8
+
+	// MayTriggerPreemption tells that the flow may pre-empt others
+	MayTriggerPreemption bool
+
+	// Preemptable tells that the flow may be pre-empted by others
+	Preemptable bool
+}
+
+// writeAllocationAndRetentionPriority writes an
+// AllocationAndRetentionPriority
+func writeAllocationAndRetentionPriority(w *aper.Writer, arp AllocationAndRetentionPriority) error {
+	// SEQUENCE { priorityLevelARP INTEGER (1..15), pre-emptionCapability,
+	// pre-emptionVulnerability, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := w.WriteConstrainedWholeNumber(int64(arp.PriorityLevel), 1, 15); err != nil {
+		return err
+	}
+	if err := w.WriteEnumerated(boolIndex(arp.MayTriggerPreemption), 2, true); err != nil {
+		return err
+	}
+	return w.WriteEnumerated(boolIndex(arp.Preemptable), 2, true)
+}
+
+// readAllocationAndRetentionPriority reads an
+// AllocationAndRetentionPriority
+func readAllocationAndRetentionPriority(r *aper.Reader) (AllocationAndRetentionPriority, error) {
+	var arp AllocationAndRetentionPriority
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return arp, err
+	}
+	level, err := r.ReadConstrainedWholeNumber(1, 15)
+	if err != nil {
+		return arp, err
+	}
+	capability, err := r.ReadEnumerated(2, true)
+	if err != nil {
+		return arp, err
+	}
+	vulnerability, err := r.ReadEnumerated(2, true)
+	if err != nil {
+		return arp, err
+	}
+	arp.PriorityLevel = uint8(level)
+	arp.MayTriggerPreemption, arp.Preemptable = capability == 1, vulnerability == 1
+	return arp, seq.end(r, 0)
+}
+
+// boolIndex returns the index of the second value of a two valued
+// ENUMERATED when b is set
+func boolIndex(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GBRQosInformation holds the bit rates of a GBR QoS flow, in bits per
+// second
+type GBRQosInformation struct {
+	MaximumFlowBitRateDL    uint64
+	MaximumFlowBitRateUL    uint64
+	GuaranteedFlowBitRateDL uint64
+	GuaranteedFlowBitRateUL uint64
+}
+
+// writeGBRQosInformation writes a GBR-QosInformation
+func writeGBRQosInformation(w *aper.Writer, gbr GBRQosInformation) error {
+	// SEQUENCE { maximumFlowBitRateDL, maximumFlowBitRateUL,
+	// guaranteedFlowBitRateDL, guaranteedFlowBitRateUL,
+	// notificationControl OPTIONAL, maximumPacketLossRateDL OPTIONAL,
+	// maximumPacketLossRateUL OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false, false, false, false)
+	for _, bps := range []uint64{gbr.MaximumFlowBitRateDL, gbr.MaximumFlowBitRateUL,
+		gbr.GuaranteedFlowBitRateDL, gbr.GuaranteedFlowBitRateUL} {
+		if err := writeBitRate(w, bps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGBRQosInformation reads a GBR-QosInformation
+func readGBRQosInformation(r *aper.Reader) (GBRQosInformation, error) {
+	var gbr GBRQosInformation
+
+	seq, err := readSequence(r, true, 4)
+	if err != nil {
+		return gbr, err
+	}
+	for _, bps := range []*uint64{&gbr.MaximumFlowBitRateDL, &gbr.MaximumFlowBitRateUL,
+		&gbr.GuaranteedFlowBitRateDL, &gbr.GuaranteedFlowBitRateUL} {
+		if *bps, err = readBitRate(r); err != nil {
+			return gbr, err
+		}
+	}
+	if seq.present[0] {
+		if _, err := r.ReadEnumerated(1, true); err != nil {
+			return gbr, err
+		}
+	}
+	for _, present := range seq.present[1:3] {
+		// PacketLossRate ::= INTEGER (0..1000, ...)
+		if present {
+			if _, err := r.ReadInteger(0, 1000, true); err != nil {
+				return gbr, err
+			}
+		}
+	}
+	return gbr, seq.end(r, 3)
+}
+
+// QosFlowSetupRequestItem is a QoS flow the gNB is asked to set up, with
+// standardized 5QI characteristics
+type QosFlowSetupRequestItem struct {
+	QFI    uint8
+	FiveQI uint8
+
+	// PriorityLevel overrides the priority of the 5QI when not 0
+	PriorityLevel uint8
+
+	ARP AllocationAndRetentionPriority
+
+	// GBR holds the bit rates of a GBR flow, nil for a non-GBR one
+	GBR *GBRQosInformation
+}
+
+// writeQosFlowSetupRequestList writes a QosFlowSetupRequestList
+func writeQosFlowSetupRequestList(w *aper.Writer, items []QosFlowSetupRequestItem) error {
+	return writeList(w, items, 1, maxnoofQosFlows, func(w *aper.Writer, item QosFlowSetupRequestItem) error {
+		// SEQUENCE { qosFlowIdentifier, qosFlowLevelQosParameters,
+		// e-RAB-ID OPTIONAL, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false, false)
+		if err := writeQosFlowIdentifier(w, item.QFI); err != nil {
+			return err
+		}
+		return writeQosFlowLevelQosParameters(w, item)
+	})
+}
+
+// writeQosFlowLevelQosParameters writes the QosFlowLevelQosParameters of
+// a flow
+func writeQosFlowLevelQosParameters(w *aper.Writer, item QosFlowSetupRequestItem) error {
+	// QosFlowLevelQosParameters ::= SEQUENCE { qosCharacteristics,
+	// allocationAndRetentionPriority, gBR-QosInformation OPTIONAL,
+	// reflectiveQosAttribute OPTIONAL, additionalQosFlowInformation
+	// OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, item.GBR != nil, false, false, false)
+
+	// QosCharacteristics ::= CHOICE { nonDynamic5QI, dynamic5QI,
+	// choice-Extensions }
+	if err := w.WriteChoice(0, 3, false); err != nil {
+		return err
+	}
+	// NonDynamic5QIDescriptor ::= SEQUENCE { fiveQI, priorityLevelQos
+	// OPTIONAL, averagingWindow OPTIONAL, maximumDataBurstVolume
+	// OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, item.PriorityLevel != 0, false, false, false)
+	if err := w.WriteInteger(int64(item.FiveQI), 0, 255, true); err != nil {
+		return err
+	}
+	if item.PriorityLevel != 0 {
+		if err := w.WriteInteger(int64(item.PriorityLevel), 1, 127, true); err != nil {
+			return err
+		}
+	}
+
+	if err := writeAllocationAndRetentionPriority(w, item.ARP); err != nil {
+		return err
+	}
+	if item.GBR != nil {
+		return writeGBRQosInformation(w, *item.GBR)
+	}
+	return nil
+}
+
+// readQosFlowSetupRequestList reads a QosFlowSetupRequestList
+func readQosFlowSetupRequestList(r *aper.Reader) ([]QosFlowSetupRequestItem, error) {
+	return readList(r, 1, maxnoofQosFlows, func(r *aper.Reader) (QosFlowSetupRequestItem, error) {
+		var item QosFlowSetupRequestItem
+
+		seq, err := readSequence(r, true, 2)
+		if err != nil {
+			return item, err
+		}
+		if item.QFI, err = readQosFlowIdentifier(r); err != nil {
+			return item, err
+		}
+		if err := readQosFlowLevelQosParameters(r, &item); err != nil {
+			return item, err
+		}
+		if seq.present[0] {
+			// E-RAB-ID ::= INTEGER (0..15, ...)
+			if _, err := r.ReadInteger(0, 15, true); err != nil {
+				return item, err
+			}
+		}
+		return item, seq.end(r, 1)
+	})
+}
+
+// readQosFlowLevelQosParameters reads a QosFlowLevelQosParameters into
+// item
+func readQosFlowLevelQosParameters(r *aper.Reader, item *QosFlowSetupRequestItem) error {
+	params, err := readSequence(r, true, 4)
+	if err != nil {
+		return err
+	}
+	choice, err := r.ReadChoice(3, false)
+	if err != nil {
+		return err
+	}
+	if choice != 0 {
+		return fmt.Errorf("%w: QosCharacteristics alternative %d", ErrUnsupportedMessage, choice)
+	}
+	descriptor, err := readSequence(r, true, 4)
+	if err != nil {
+		return err
+	}
+	fiveQI, err := r.ReadInteger(0, 255, true)
+	if err != nil {
+		return err
+	}
+	item.FiveQI = uint8(fiveQI)
+	if descriptor.present[0] {
+		level, err := r.ReadInteger(1, 127, true)
+		if err != nil {
+			return err
+		}
+		item.PriorityLevel = uint8(level)
+	}
+	for _, present := range descriptor.present[1:3] {
+		// AveragingWindow and MaximumDataBurstVolume are INTEGER
+		// (0..4095, ...)
+		if present {
+			if _, err := r.ReadInteger(0, 4095, true); err != nil {
+				return err
+			}
+		}
+	}
+	if err := descriptor.end(r, 3); err != nil {
+		return err
+	}
+
+	if item.ARP, err = readAllocationAndRetentionPriority(r); err != nil {
+		return err
+	}
+	if params.present[0] {
+		gbr, err := readGBRQosInformation(r)
+		if err != nil {
+			return err
+		}
+		item.GBR = &gbr
+	}
+	for _, present := range params.present[1:3] {
+		// ReflectiveQosAttribute and AdditionalQosFlowInformation are
+		// ENUMERATED with one root value
+		if present {
+			if _, err := r.ReadEnumerated(1, true); err != nil {
+				return err
+			}
+		}
+	}
+	return params.end(r, 3)
+}
+
+// PDUSessionResourceSetupRequestTransfer is the part of a PDU session
+// resource setup built by the SMF for the gNB (TS 38.413 9.3.4.1)
+type PDUSessionResourceSetupRequestTransfer struct {
+	// AMBR is the session AMBR, required with non-GBR flows
+	AMBR *UEAggregateMaximumBitRate
+
+	// ULTunnel is the endpoint of the UPF receiving the uplink traffic
+	ULTunnel GTPTunnel
+
+	PDUSessionType PDUSessionType
+	QosFlows       []QosFlowSetupRequestItem
+}
+
+// EncodeSetupRequestTransfer encodes a
+// PDUSessionResourceSetupRequestTransfer
+func EncodeSetupRequestTransfer(t *PDUSessionResourceSetupRequestTransfer) ([]byte, error) {
+	enc := &ieEncoder{}
+	if t.AMBR != nil {
+		enc.add(IDPDUSessionAggregateMaximumBitRate, CriticalityReject, func(w *aper.Writer) error {
+			// PDUSessionAggregateMaximumBitRate has the shape of
+			// UEAggregateMaximumBitRate
+			return writeUEAggregateMaximumBitRate(w, *t.AMBR)
+		})
+	}
+	enc.add(IDULNGUUPTNLInformation, CriticalityReject, func(w *aper.Writer) error {
+		return writeUPTransportLayerInformation(w, t.ULTunnel)
+	})
+	enc.add(IDPDUSessionType, CriticalityReject, func(w *aper.Writer) error {
+		return w.WriteEnumerated(uint64(t.PDUSessionType), 5, true)
+	})
+	enc.add(IDQosFlowSetupRequestList, CriticalityReject, func(w *aper.Writer) error {
+		return writeQosFlowSetupRequestList(w, t.QosFlows)
+	})
+	if enc.err != nil {
+		return nil, fmt.Errorf("ngap: encoding setup request transfer: %w", enc.err)
+	}
+
+	w := aper.NewWriter()
+	if err := writeIEContainer(w, enc.ies); err != nil {
+		return nil, fmt.Errorf("ngap: encoding setup request transfer: %w", err)
+	}
+	return w.Bytes(), nil
+}
+
+// DecodeSetupRequestTransfer decodes a
+// PDUSessionResourceSetupRequestTransfer
+func DecodeSetupRequestTransfer(b []byte) (*PDUSessionResourceSetupRequestTransfer, error) {
+	ies, err := decodeIEContainer(b)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding setup request transfer: %w", err)
+	}
+
+	t := &PDUSessionResourceSetupRequestTransfer{}
+	dec := &ieDecoder{ies: ies}
+	dec.optional(IDPDUSessionAggregateMaximumBitRate, func(r *aper.Reader) error {
+		ambr, err := readUEAggregateMaximumBitRate(r)
+		t.AMBR = &ambr
+		return err
+	})
+	dec.mandatory(IDULNGUUPTNLInformation, func(r *aper.Reader) (err error) {
+		t.ULTunnel, err = readUPTransportLayerInformation(r)
+		return err
+	})
+	dec.mandatory(IDPDUSessionType, func(r *aper.Reader) error {
+		v, err := r.ReadEnumerated(5, true)
+		t.PDUSessionType = PDUSessionType(v)
+		return err
+	})
+	dec.mandatory(IDQosFlowSetupRequestList, func(r *aper.Reader) (err error) {
+		t.QosFlows, err = readQosFlowSetupRequestList(r)
+		return err
+	})
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("ngap: decoding setup request transfer: %w", err)
+	}
+	return t, nil
+}
+
+// QosFlowWithCause is a QoS flow that could not be handled and why
+type QosFlowWithCause struct {
+	QFI   uint8
+	Cause Cause
+}
+
+// PDUSessionResourceSetupResponseTransfer is the part of a PDU session
+// resource setup response for the SMF (TS 38.413 9.3.4.2)
+type PDUSessionResourceSetupResponseTransfer struct {
+	// DLTunnel is the endpoint of the gNB receiving the downlink traffic
+	DLTunnel GTPTunnel
+
+	// QosFlows are the QoS flows carried by the tunnel
+	QosFlows []uint8
+
+	// FailedQosFlows are the QoS flows the gNB could not set up
+	FailedQosFlows []QosFlowWithCause
+}
+
+// writeQosFlowPerTNLInformation writes a QosFlowPerTNLInformation
+func writeQosFlowPerTNLInformation(w *aper.Writer, tunnel GTPTunnel, qfis []uint8) error {
+	// SEQUENCE { uPTransportLayerInformation, associatedQosFlowList,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writeUPTransportLayerInformation(w, tunnel); err != nil {
+		return err
+	}
+	return writeList(w, qfis, 1, maxnoofQosFlows, func(w *aper.Writer, qfi uint8) error {
+		// AssociatedQosFlowItem ::= SEQUENCE { qosFlowIdentifier,
+		// qosFlowMappingIndication OPTIONAL, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false, false)
+		return writeQosFlowIdentifier(w, qfi)
+	})
+}
+
+// readQosFlowPerTNLInformation reads a QosFlowPerTNLInformation
+func readQosFlowPerTNLInformation(r *aper.Reader) (GTPTunnel, []uint8, error) {
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return GTPTunnel{}, nil, err
+	}
+	tunnel, err := readUPTransportLayerInformation(r)
+	if err != nil {
+		return tunnel, nil, err
+	}
+	qfis, err := readList(r, 1, maxnoofQosFlows, func(r *aper.Reader) (uint8, error) {
+		item, err := readSequence(r, true, 2)
+		if err != nil {
+			return 0, err
+		}
+		qfi, err := readQosFlowIdentifier(r)
+		if err != nil {
+			return 0, err
+		}
+		if item.present[0] {
+			if _, err := r.ReadEnumerated(2, true); err != nil {
+				return 0, err
+			}
+		}
+		return qfi, item.end(r, 1)
+	})
+	if err != nil {
+		return tunnel, nil, err
+	}
+	return tunnel, qfis, seq.end(r, 0)
+}
+
+// writeQosFlowListWithCause writes a QosFlowListWithCause
+func writeQosFlowListWithCause(w *aper.Writer, items []QosFlowWithCause) error {
+	return writeList(w, items, 1, maxnoofQosFlows, func(w *aper.Writer, item QosFlowWithCause) error {
+		// SEQUENCE { qosFlowIdentifier, cause, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		if err := writeQosFlowIdentifier(w, item.QFI); err != nil {
+			return err
+		}
+		return writeCause(w, item.Cause)
+	})
+}
+
+// readQosFlowListWithCause reads a QosFlowListWithCause
+func readQosFlowListWithCause(r *aper.Reader) ([]QosFlowWithCause, error) {
+	return readList(r, 1, maxnoofQosFlows, func(r *aper.Reader) (QosFlowWithCause, error) {
+		var item QosFlowWithCause
+
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return item, err
+		}
+		if item.QFI, err = readQosFlowIdentifier(r); err != nil {
+			return item, err
+		}
+		if item.Cause, err = readCause(r); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 0)
+	})
+}
+
+// EncodeSetupResponseTransfer encodes a
+// PDUSessionResourceSetupResponseTransfer
+func EncodeSetupResponseTransfer(t *PDUSessionResourceSetupResponseTransfer) ([]byte, error) {
+	w := aper.NewWriter()
+
+	// SEQUENCE { dLQosFlowPerTNLInformation,
+	// additionalDLQosFlowPerTNLInformation OPTIONAL, securityResult
+	// OPTIONAL, qosFlowFailedToSetupList OPTIONAL, iE-Extensions OPTIONAL,
+	// ... }
+	writeSequence(w, true, false, false, len(t.FailedQosFlows) > 0, false)
+	if err := writeQosFlowPerTNLInformation(w, t.DLTunnel, t.QosFlows); err != nil {
+		return nil, fmt.Errorf("ngap: encoding setup response transfer: %w", err)
+	}
+	if len(t.FailedQosFlows) > 0 {
+		if err := writeQosFlowListWithCause(w, t.FailedQosFlows); err != nil {
+			return nil, fmt.Errorf("ngap: encoding setup response transfer: %w", err)
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// DecodeSetupResponseTransfer decodes a
+// PDUSessionResourceSetupResponseTransfer. Additional tunnels of dual
+// connectivity are skipped.
+func DecodeSetupResponseTransfer(b []byte) (*PDUSessionResourceSetupResponseTransfer, error) {
+	t := &PDUSessionResourceSetupResponseTransfer{}
+	if err := decodeSetupResponseTransfer(aper.NewReader(b), t); err != nil {
+		return nil, fmt.Errorf("ngap: decoding setup response transfer: %w", err)
+	}
+	return t, nil
+}
+
+// decodeSetupResponseTransfer reads the components of a
+// PDUSessionResourceSetupResponseTransfer into t
+func decodeSetupResponseTransfer(r *aper.Reader, t *PDUSessionResourceSetupResponseTransfer) (err error) {
+	seq, err := readSequence(r, true, 4)
+	if err != nil {
+		return err
+	}
+	if t.DLTunnel, t.QosFlows, err = readQosFlowPerTNLInformation(r); err != nil {
+		return err
+	}
+	if seq.present[0] {
+		_, err := readList(r, 1, maxnoofMultiConnectivityMinusOne, func(r *aper.Reader) (struct{}, error) {
+			// QosFlowPerTNLInformationItem ::= SEQUENCE {
+			// qosFlowPerTNLInformation, iE-Extensions OPTIONAL, ... }
+			item, err := readSequence(r, true, 1)
+			if err != nil {
+				return struct{}{}, err
+			}
+			if _, _, err := readQosFlowPerTNLInformation(r); err != nil {
+				return struct{}{}, err
+			}
+			return struct{}{}, item.end(r, 0)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if seq.present[1] {
+		// SecurityResult ::= SEQUENCE { integrityProtectionResult,
+		// confidentialityProtectionResult, iE-Extensions OPTIONAL, ... }
+		result, err := readSequence(r, true, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := r.ReadEnumerated(2, true); err != nil {
+				return err
+			}
+		}
+		if err := result.end(r, 0); err != nil {
+			return err
+		}
+	}
+	if seq.present[2] {
+		if t.FailedQosFlows, err = readQosFlowListWithCause(r); err != nil {
+			return err
+		}
+	}
+	return seq.end(r, 3)
+}
+
+// DecodeSetupUnsuccessfulTransfer decodes the cause of a
+// PDUSessionResourceSetupUnsuccessfulTransfer, SEQUENCE { cause,
+// criticalityDiagnostics OPTIONAL, iE-Extensions OPTIONAL, ... }. What
+// follows the cause is ignored.
+func DecodeSetupUnsuccessfulTransfer(b []byte) (Cause, error) {
+	cause, err := decodeUnsuccessfulTransferCause(b)
+	if err != nil {
+		return Cause{}, fmt.Errorf("ngap: decoding setup unsuccessful transfer: %w", err)
+	}
+	return cause, nil
+}
+
+// decodeUnsuccessfulTransferCause reads the cause leading an unsuccessful
+// transfer
+func decodeUnsuccessfulTransferCause(b []byte) (Cause, error) {
+	r := aper.NewReader(b)
+	if _, err := readSequence(r, true, 2); err != nil {
+		return Cause{}, err
+	}
+	return readCause(r)
+}
+
+// PDUSessionResourceModifyRequestTransfer is the part of a PDU session
+// resource modification built by the SMF for the gNB (TS 38.413 9.3.4.3)
+type PDUSessionResourceModifyRequestTransfer struct {
+	// AMBR is the new session AMBR, nil when unchanged
+	AMBR *UEAggregateMaximumBitRate
+
+	// AddOrModify are the QoS flows to set up or change, their QoS
+	// parameters always given
+	AddOrModify []QosFlowSetupRequestItem
+
+	// Release are the QoS flows to release
+	Release []QosFlowWithCause
+}
+
+// EncodeModifyRequestTransfer encodes a
+// PDUSessionResourceModifyRequestTransfer
+func EncodeModifyRequestTransfer(t *PDUSessionResourceModifyRequestTransfer) ([]byte, error) {
+	enc := &ieEncoder{}
+	if t.AMBR != nil {
+		enc.add(IDPDUSessionAggregateMaximumBitRate, CriticalityReject, func(w *aper.Writer) error {
+			return writeUEAggregateMaximumBitRate(w, *t.AMBR)
+		})
+	}
+	if len(t.AddOrModify) > 0 {
+		enc.add(IDQosFlowAddOrModifyRequestList, CriticalityReject, func(w *aper.Writer) error {
+			return writeList(w, t.AddOrModify, 1, maxnoofQosFlows, func(w *aper.Writer, item QosFlowSetupRequestItem) error {
+				// QosFlowAddOrModifyRequestItem ::= SEQUENCE {
+				// qosFlowIdentifier, qosFlowLevelQosParameters OPTIONAL,
+				// e-RAB-ID OPTIONAL, iE-Extensions OPTIONAL, ... }
+				writeSequence(w, true, true, false, false)
+				if err := writeQosFlowIdentifier(w, item.QFI); err != nil {
+					return err
+				}
+				return writeQosFlowLevelQosParameters(w, item)
+			})
+		})
+	}
+	if len(t.Release) > 0 {
+		enc.add(IDQosFlowToReleaseList, CriticalityReject, func(w *aper.Writer) error {
+			return writeQosFlowListWithCause(w, t.Release)
+		})
+	}
+	if enc.err != nil {
+		return nil, fmt.Errorf("ngap: encoding modify request transfer: %w", enc.err)
+	}
+
+	w := aper.NewWriter()
+	if err := writeIEContainer(w, enc.ies); err != nil {
+		return nil, fmt.Errorf("ngap: encoding modify request transfer: %w", err)
+	}
+	return w.Bytes(), nil
+}
+
+// DecodeModifyRequestTransfer decodes a
+// PDUSessionResourceModifyRequestTransfer. Flows added or modified
+// without QoS parameters have them zero.
+func DecodeModifyRequestTransfer(b []byte) (*PDUSessionResourceModifyRequestTransfer, error) {
+	ies, err := decodeIEContainer(b)
+	if err != nil {
+		return nil, fmt.Errorf("ngap: decoding modify request transfer: %w", err)
+	}
+
+	t := &PDUSessionResourceModifyRequestTransfer{}
+	dec := &ieDecoder{ies: ies}
+	dec.optional(IDPDUSessionAggregateMaximumBitRate, func(r *aper.Reader) error {
+		ambr, err := readUEAggregateMaximumBitRate(r)
+		t.AMBR = &ambr
+		return err
+	})
+	dec.optional(IDQosFlowAddOrModifyRequestList, func(r *aper.Reader) (err error) {
+		t.AddOrModify, err = readList(r, 1, maxnoofQosFlows, func(r *aper.Reader) (QosFlowSetupRequestItem, error) {
+			var item QosFlowSetupRequestItem
+
+			seq, err := readSequence(r, true, 3)
+			if err != nil {
+				return item, err
+			}
+			if item.QFI, err = readQosFlowIdentifier(r); err != nil {
+				return item, err
+			}
+			if seq.present[0] {
+				if err := readQosFlowLevelQosParameters(r, &item); err != nil {
+					return item, err
+				}
+			}
+			if seq.present[1] {
+				if _, err := r.ReadInteger(0, 15, true); err != nil {
+					return item, err
+				}
+			}
+			return item, seq.end(r, 2)
+		})
+		return err
+	})
+	dec.optional(IDQosFlowToReleaseList, func(r *aper.Reader) (err error) {
+		t.Release, err = readQosFlowListWithCause(r)
+		return err
+	})
+	if err := dec.finish(); err != nil {
+		return nil, fmt.Errorf("ngap: decoding modify request transfer: %w", err)
+	}
+	return t, nil
+}
+
+// PDUSessionResourceModifyResponseTransfer is the part of a PDU session
+// resource modification response for the SMF (TS 38.413 9.3.4.4)
+type PDUSessionResourceModifyResponseTransfer struct {
+	// DLTunnel is the new endpoint of the gNB receiving the downlink
+	// traffic, nil when unchanged
+	DLTunnel *GTPTunnel
+
+	// QosFlows are the QoS flows added or modified
+	QosFlows []uint8
+
+	// FailedQosFlows are the QoS flows the gNB could not add or modify
+	FailedQosFlows []QosFlowWithCause
+}
+
+// EncodeModifyResponseTransfer encodes a
+// PDUSessionResourceModifyResponseTransfer
+func EncodeModifyResponseTransfer(t *PDUSessionResourceModifyResponseTransfer) ([]byte, error) {
+	w := aper.NewWriter()
+
+	// SEQUENCE { dL-NGU-UP-TNLInformation OPTIONAL, uL-NGU-UP-TNLInformation
+	// OPTIONAL, qosFlowAddOrModifyResponseList OPTIONAL,
+	// additionalDLQosFlowPerTNLInformation OPTIONAL,
+	// qosFlowFailedToAddOrModifyList OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, t.DLTunnel != nil, false, len(t.QosFlows) > 0, false, len(t.FailedQosFlows) > 0, false)
+	if t.DLTunnel != nil {
+		if err := writeUPTransportLayerInformation(w, *t.DLTunnel); err != nil {
+			return nil, fmt.Errorf("ngap: encoding modify response transfer: %w", err)
+		}
+	}
+	if len(t.QosFlows) > 0 {
+		err := writeList(w, t.QosFlows, 1, maxnoofQosFlows, func(w *aper.Writer, qfi uint8) error {
+			// QosFlowAddOrModifyResponseItem ::= SEQUENCE {
+			// qosFlowIdentifier, iE-Extensions OPTIONAL, ... }
+			writeSequence(w, true, false)
+			return writeQosFlowIdentifier(w, qfi)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("ngap: encoding modify response transfer: %w", err)
+		}
+	}
+	if len(t.FailedQosFlows) > 0 {
+		if err := writeQosFlowListWithCause(w, t.FailedQosFlows); err != nil {
+			return nil, fmt.Errorf("ngap: encoding modify response transfer: %w", err)
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// DecodeModifyResponseTransfer decodes a
+// PDUSessionResourceModifyResponseTransfer. The uplink tunnel and the
+// additional tunnels of dual connectivity are not supported.
+func DecodeModifyResponseTransfer(b []byte) (*PDUSessionResourceModifyResponseTransfer, error) {
+	t := &PDUSessionResourceModifyResponseTransfer{}
+	if err := decodeModifyResponseTransfer(aper.NewReader(b), t); err != nil {
+		return nil, fmt.Errorf("ngap: decoding modify response transfer: %w", err)
+	}
+	return t, nil
+}
+
+// decodeModifyResponseTransfer reads the components of a
+// PDUSessionResourceModifyResponseTransfer into t
+func decodeModifyResponseTransfer(r *aper.Reader, t *PDUSessionResourceModifyResponseTransfer) error {
+	seq, err := readSequence(r, true, 6)
+	if err != nil {
+		return err
+	}
+	if seq.present[0] {
+		tunnel, err := readUPTransportLayerInformation(r)
+		if err != nil {
+			return err
+		}
+		t.DLTunnel = &tunnel
+	}
+	if seq.present[1] || seq.present[3] {
+		return fmt.Errorf("%w: uplink or additional tunnels", ErrUnsupportedMessage)
+	}
+	if seq.present[2] {
+		t.QosFlows, err = readList(r, 1, maxnoofQosFlows, func(r *aper.Reader) (uint8, error) {
+			item, err := readSequence(r, true, 1)
+			if err != nil {
+				return 0, err
+			}
+			qfi, err := readQosFlowIdentifier(r)
+			if err != nil {
+				return 0, err
+			}
+			return qfi, item.end(r, 0)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if seq.present[4] {
+		if t.FailedQosFlows, err = readQosFlowListWithCause(r); err != nil {
+			return err
+		}
+	}
+	return seq.end(r, 5)
+}
+
+// DecodeModifyUnsuccessfulTransfer decodes the cause of a
+// PDUSessionResourceModifyUnsuccessfulTransfer, which has the shape of
+// the setup one
+func DecodeModifyUnsuccessfulTransfer(b []byte) (Cause, error) {
+	cause, err := decodeUnsuccessfulTransferCause(b)
+	if err != nil {
+		return Cause{}, fmt.Errorf("ngap: decoding modify unsuccessful transfer: %w", err)
+	}
+	return cause, nil
+}
@@ -0,0 +1,311 @@
+//go:build linux
+
+package transport
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// SCTP socket options (linux/sctp.h)
+const (
+	solSCTP                 = 132
+	sctpNoDelay             = 3
+	sctpDefaultSendParam    = 10
+	sctpSndRcvInfoSize      = 32
+	sctpSndRcvInfoPPIDStart = 8
+	msgNotification         = 0x8000
+)
+
+// sctpAddr is the address of an SCTP endpoint
+type sctpAddr struct {
+	IP   net.IP
+	Port int
+}
+
+// Network implements net.Addr
+func (a *sctpAddr) Network() string { return SCTP }
+
+// String implements net.Addr
+func (a *sctpAddr) String() string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+// sockaddr converts a host:port address to a socket address
+func sockaddr(address string) (int, unix.Sockaddr, error) {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if ip4 := addr.IP.To4(); ip4 != nil || addr.IP == nil {
+		sa := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa.Addr[:], ip4)
+		return unix.AF_INET, sa, nil
+	}
+	sa := &unix.SockaddrInet6{Port: addr.Port}
+	copy(sa.Addr[:], addr.IP.To16())
+	return unix.AF_INET6, sa, nil
+}
+
+// fromSockaddr converts a socket address to an sctpAddr
+func fromSockaddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &sctpAddr{IP: net.IP(sa.Addr[:]).To16(), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		return &sctpAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+	default:
+		return &sctpAddr{}
+	}
+}
+
+// socket creates a one-to-one style SCTP socket
+func socket(family int) (int, error) {
+	fd, err := unix.Socket(family, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	return fd, nil
+}
+
+// setNGAPOptions disables Nagle and sets NGAP as the default payload
+// protocol of the socket
+func setNGAPOptions(fd int) error {
+	if err := unix.SetsockoptInt(fd, solSCTP, sctpNoDelay, 1); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+
+	// struct sctp_sndrcvinfo with sinfo_ppid in network byte order
+	info := make([]byte, sctpSndRcvInfoSize)
+	binary.BigEndian.PutUint32(info[sctpSndRcvInfoPPIDStart:], PPID)
+	if err := unix.SetsockoptString(fd, solSCTP, sctpDefaultSendParam, string(info)); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+	return nil
+}
+
+// listenSCTP listens for SCTP associations
+func listenSCTP(address string) (Listener, error) {
+	family, sa, err := sockaddr(address)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := socket(family)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	local, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+
+	// Non-blocking sockets are driven by the runtime poller
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	return &sctpListener{file: os.NewFile(uintptr(fd), "sctp-listener"), addr: fromSockaddr(local)}, nil
+}
+
+// dialSCTP establishes an SCTP association
+func dialSCTP(address string) (Conn, error) {
+	family, sa, err := sockaddr(address)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := socket(family)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setNGAPOptions(fd); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if err := unix.Connect(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+	return newSCTPConn(fd)
+}
+
+// sctpListener accepts SCTP associations
+type sctpListener struct {
+	file *os.File
+	addr net.Addr
+}
+
+// Accept implements Listener
+func (l *sctpListener) Accept() (Conn, error) {
+	rc, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	var acceptErr error
+	err = rc.Read(func(s uintptr) bool {
+		fd, _, acceptErr = unix.Accept4(int(s), unix.SOCK_CLOEXEC)
+		return acceptErr != unix.EAGAIN
+	})
+	if err != nil {
+		return nil, err
+	}
+	if acceptErr != nil {
+		return nil, os.NewSyscallError("accept", acceptErr)
+	}
+
+	if err := setNGAPOptions(fd); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return newSCTPConn(fd)
+}
+
+// Addr implements Listener
+func (l *sctpListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Close implements Listener
+func (l *sctpListener) Close() error {
+	return l.file.Close()
+}
+
+// sctpConn is an SCTP association, each message is one PDU
+type sctpConn struct {
+	file    *os.File
+	local   net.Addr
+	remote  net.Addr
+	writeMu sync.Mutex
+}
+
+// newSCTPConn wraps a connected SCTP socket
+func newSCTPConn(fd int) (*sctpConn, error) {
+	local, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	remote, err := unix.Getpeername(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("getpeername", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+
+	return &sctpConn{
+		file:   os.NewFile(uintptr(fd), "sctp"),
+		local:  fromSockaddr(local),
+		remote: fromSockaddr(remote),
+	}, nil
+}
+
+// ReadMsg implements Conn
+func (c *sctpConn) ReadMsg() ([]byte, error) {
+	rc, err := c.file.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, MaxMessageSize)
+	var msg []byte
+	for {
+		var n, flags int
+		var readErr error
+		err := rc.Read(func(s uintptr) bool {
+			n, _, flags, _, readErr = unix.Recvmsg(int(s), buf, nil, 0)
+			return readErr != unix.EAGAIN
+		})
+		if err != nil {
+			return nil, err
+		}
+		if readErr != nil {
+			return nil, os.NewSyscallError("recvmsg", readErr)
+		}
+		if n == 0 && flags&unix.MSG_EOR == 0 {
+			return nil, io.EOF
+		}
+
+		// Skip SCTP event notifications
+		if flags&msgNotification != 0 {
+			continue
+		}
+
+		// A message larger than the buffer arrives in several reads,
+		// the last one flagged with MSG_EOR
+		msg = append(msg, buf[:n]...)
+		if len(msg) > MaxMessageSize {
+			return nil, ErrMessageTooLarge
+		}
+		if flags&unix.MSG_EOR != 0 {
+			return msg, nil
+		}
+	}
+}
+
+// WriteMsg implements Conn
+func (c *sctpConn) WriteMsg(b []byte) error {
+	if len(b) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	rc, err := c.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	var writeErr error
+	err = rc.Write(func(s uintptr) bool {
+		_, writeErr = unix.SendmsgN(int(s), b, nil, nil, 0)
+		return writeErr != unix.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return os.NewSyscallError("sendmsg", writeErr)
+	}
+	return nil
+}
+
+// LocalAddr implements Conn
+func (c *sctpConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr implements Conn
+func (c *sctpConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// Close implements Conn
+func (c *sctpConn) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+)
+
+// errSCTPUnsupported is returned on platforms without SCTP support
+var errSCTPUnsupported = errors.New("transport: SCTP is only supported on linux, use the tcp transport")
+
+// listenSCTP listens for SCTP associations
+func listenSCTP(address string) (Listener, error) {
+	return nil, errSCTPUnsupported
+}
+
+// dialSCTP establishes an SCTP association
+func dialSCTP(address string) (Conn, error) {
+	return nil, errSCTPUnsupported
+}
@@ -0,0 +1,157 @@
+// Package transport carries NGAP PDUs between a gNB and the AMF. SCTP is
+// the N2 transport of TS 38.412; a length framed TCP transport with the
+// same interface is provided for environments without SCTP support, such
+// as CI containers.
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// Supported transports
+const (
+	SCTP = "sctp"
+	TCP  = "tcp"
+)
+
+// DefaultPort is the NGAP SCTP port (TS 38.412)
+const DefaultPort = 38412
+
+// PPID is the SCTP payload protocol identifier of NGAP
+const PPID = 60
+
+// MaxMessageSize bounds the size of a PDU
+const MaxMessageSize = 65535
+
+// ErrMessageTooLarge is returned for PDUs larger than MaxMessageSize
+var ErrMessageTooLarge = errors.New("transport: message too large")
+
+// Conn is an association carrying NGAP PDUs, one per read or write
+type Conn interface {
+	// ReadMsg reads the next PDU
+	ReadMsg() ([]byte, error)
+
+	// WriteMsg writes a PDU
+	WriteMsg(b []byte) error
+
+	// LocalAddr returns the local address
+	LocalAddr() net.Addr
+
+	// RemoteAddr returns the address of the peer
+	RemoteAddr() net.Addr
+
+	// Close closes the association
+	Close() error
+}
+
+// Listener accepts associations
+type Listener interface {
+	// Accept waits for the next association
+	Accept() (Conn, error)
+
+	// Addr returns the listening address
+	Addr() net.Addr
+
+	// Close stops listening
+	Close() error
+}
+
+// Listen listens on address with the given transport, SCTP or TCP
+func Listen(transport, address string) (Listener, error) {
+	switch transport {
+	case SCTP:
+		return listenSCTP(address)
+	case TCP:
+		l, err := net.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return &tcpListener{l}, nil
+	default:
+		return nil, fmt.Errorf("transport: unknown transport %q", transport)
+	}
+}
+
+// Dial connects to address with the given transport, SCTP or TCP
+func Dial(transport, address string) (Conn, error) {
+	switch transport {
+	case SCTP:
+		return dialSCTP(address)
+	case TCP:
+		c, err := net.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		return newTCPConn(c), nil
+	default:
+		return nil, fmt.Errorf("transport: unknown transport %q", transport)
+	}
+}
+
+// tcpListener accepts length framed TCP connections
+type tcpListener struct {
+	net.Listener
+}
+
+// Accept implements Listener
+func (l *tcpListener) Accept() (Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return newTCPConn(c), nil
+}
+
+// tcpConn frames each PDU with a 4 octet big endian length
+type tcpConn struct {
+	net.Conn
+	writeMu sync.Mutex
+}
+
+// newTCPConn wraps a TCP connection
+func newTCPConn(c net.Conn) *tcpConn {
+	if tc, ok := c.(*net.TCPConn); ok {
+		_ = tc.SetNoDelay(true)
+	}
+	return &tcpConn{Conn: c}
+}
+
+// ReadMsg implements Conn
+func (c *tcpConn) ReadMsg() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.Conn, header[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(header[:])
+	if n > MaxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.Conn, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// WriteMsg implements Conn
+func (c *tcpConn) WriteMsg(b []byte) error {
+	if len(b) > MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	// Write the header and PDU at once so concurrent writers don't interleave
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.Conn.Write(frame)
+	return err
+}
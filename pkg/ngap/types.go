@@ -0,0 +1,1418 @@
+package ngap
+
+import (
+	"fmt"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/ngap/aper"
+)
+
+// Size bounds of the NGAP lists (TS 38.413 9.5)
+const (
+	maxnoofAllowedSNSSAIs = 8
+	maxnoofBPLMNs         = 12
+	maxnoofPDUSessions    = 256
+	maxnoofPLMNs          = 12
+	maxnoofServedGUAMIs   = 256
+	maxnoofSliceItems     = 1024
+	maxnoofTACs           = 256
+	maxnoofTAIforPaging   = 16
+)
+
+// Value ranges of the UE NGAP identifiers
+const (
+	maxAMFUENGAPID = 1<<40 - 1
+	maxRANUENGAPID = 1<<32 - 1
+)
+
+// writePLMNIdentity writes a PLMNIdentity, OCTET STRING (SIZE(3))
+func writePLMNIdentity(w *aper.Writer, plmn models.PlmnID) error {
+	b, err := plmn.Bytes()
+	if err != nil {
+		return err
+	}
+	return w.WriteOctetString(b[:], 3, 3, false)
+}
+
+// readPLMNIdentity reads a PLMNIdentity
+func readPLMNIdentity(r *aper.Reader) (models.PlmnID, error) {
+	b, err := r.ReadOctetString(3, 3, false)
+	if err != nil {
+		return models.PlmnID{}, err
+	}
+	return models.PlmnIDFromBytes(b)
+}
+
+// writeTAC writes a TAC, OCTET STRING (SIZE(3))
+func writeTAC(w *aper.Writer, tac uint32) error {
+	if tac > 0xffffff {
+		return fmt.Errorf("invalid TAC %d", tac)
+	}
+	return w.WriteOctetString([]byte{byte(tac >> 16), byte(tac >> 8), byte(tac)}, 3, 3, false)
+}
+
+// readTAC reads a TAC
+func readTAC(r *aper.Reader) (uint32, error) {
+	b, err := r.ReadOctetString(3, 3, false)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2]), nil
+}
+
+// writeAMFUENGAPID writes an AMF-UE-NGAP-ID, INTEGER (0..1099511627775)
+func writeAMFUENGAPID(w *aper.Writer, id int64) error {
+	return w.WriteConstrainedWholeNumber(id, 0, maxAMFUENGAPID)
+}
+
+// readAMFUENGAPID reads an AMF-UE-NGAP-ID
+func readAMFUENGAPID(r *aper.Reader) (int64, error) {
+	return r.ReadConstrainedWholeNumber(0, maxAMFUENGAPID)
+}
+
+// writeRANUENGAPID writes a RAN-UE-NGAP-ID, INTEGER (0..4294967295)
+func writeRANUENGAPID(w *aper.Writer, id int64) error {
+	return w.WriteConstrainedWholeNumber(id, 0, maxRANUENGAPID)
+}
+
+// readRANUENGAPID reads a RAN-UE-NGAP-ID
+func readRANUENGAPID(r *aper.Reader) (int64, error) {
+	return r.ReadConstrainedWholeNumber(0, maxRANUENGAPID)
+}
+
+// writeNASPDU writes a NAS-PDU, OCTET STRING
+func writeNASPDU(w *aper.Writer, pdu []byte) error {
+	return w.WriteOctetString(pdu, 0, -1, false)
+}
+
+// readNASPDU reads a NAS-PDU
+func readNASPDU(r *aper.Reader) ([]byte, error) {
+	return r.ReadOctetString(0, -1, false)
+}
+
+// writeAMFName writes an AMFName or RANNodeName, PrintableString
+// (SIZE(1..150, ...))
+func writeAMFName(w *aper.Writer, name string) error {
+	return w.WritePrintableString(name, 1, 150, true)
+}
+
+// readAMFName reads an AMFName or RANNodeName
+func readAMFName(r *aper.Reader) (string, error) {
+	return r.ReadPrintableString(1, 150, true)
+}
+
+// writeSNSSAI writes an S-NSSAI
+func writeSNSSAI(w *aper.Writer, s models.Snssai) error {
+	if s.Sst < 0 || s.Sst > 255 {
+		return fmt.Errorf("invalid SST %d", s.Sst)
+	}
+	sd, err := s.SdBytes()
+	if err != nil {
+		return err
+	}
+
+	// S-NSSAI ::= SEQUENCE { sST, sD OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, sd != nil, false)
+	if err := w.WriteOctetString([]byte{byte(s.Sst)}, 1, 1, false); err != nil {
+		return err
+	}
+	if sd != nil {
+		return w.WriteOctetString(sd, 3, 3, false)
+	}
+	return nil
+}
+
+// readSNSSAI reads an S-NSSAI
+func readSNSSAI(r *aper.Reader) (models.Snssai, error) {
+	var s models.Snssai
+
+	seq, err := readSequence(r, true, 2)
+	if err != nil {
+		return s, err
+	}
+	sst, err := r.ReadOctetString(1, 1, false)
+	if err != nil {
+		return s, err
+	}
+	s.Sst = int(sst[0])
+	if seq.present[0] {
+		sd, err := r.ReadOctetString(3, 3, false)
+		if err != nil {
+			return s, err
+		}
+		s.Sd = fmt.Sprintf("%x", sd)
+	}
+	return s, seq.end(r, 1)
+}
+
+// writeSliceSupportList writes a SliceSupportList
+func writeSliceSupportList(w *aper.Writer, slices []models.Snssai) error {
+	return writeList(w, slices, 1, maxnoofSliceItems, func(w *aper.Writer, s models.Snssai) error {
+		// SliceSupportItem ::= SEQUENCE { s-NSSAI, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		return writeSNSSAI(w, s)
+	})
+}
+
+// readSliceSupportList reads a SliceSupportList
+func readSliceSupportList(r *aper.Reader) ([]models.Snssai, error) {
+	return readList(r, 1, maxnoofSliceItems, func(r *aper.Reader) (models.Snssai, error) {
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return models.Snssai{}, err
+		}
+		s, err := readSNSSAI(r)
+		if err != nil {
+			return s, err
+		}
+		return s, seq.end(r, 0)
+	})
+}
+
+// writeAllowedNSSAI writes an AllowedNSSAI
+func writeAllowedNSSAI(w *aper.Writer, slices []models.Snssai) error {
+	return writeList(w, slices, 1, maxnoofAllowedSNSSAIs, func(w *aper.Writer, s models.Snssai) error {
+		// AllowedNSSAI-Item ::= SEQUENCE { s-NSSAI, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		return writeSNSSAI(w, s)
+	})
+}
+
+// readAllowedNSSAI reads an AllowedNSSAI
+func readAllowedNSSAI(r *aper.Reader) ([]models.Snssai, error) {
+	return readList(r, 1, maxnoofAllowedSNSSAIs, func(r *aper.Reader) (models.Snssai, error) {
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return models.Snssai{}, err
+		}
+		s, err := readSNSSAI(r)
+		if err != nil {
+			return s, err
+		}
+		return s, seq.end(r, 0)
+	})
+}
+
+// GlobalRANNodeID identifies a gNB. Only the globalGNB-ID alternative is
+// supported.
+type GlobalRANNodeID struct {
+	PLMNIdentity models.PlmnID
+	GNBID        uint32
+	GNBIDLength  int // 22 to 32 bits
+}
+
+// writeGlobalRANNodeID writes a GlobalRANNodeID
+func writeGlobalRANNodeID(w *aper.Writer, id GlobalRANNodeID) error {
+	if id.GNBIDLength < 22 || id.GNBIDLength > 32 {
+		return fmt.Errorf("invalid gNB ID length %d", id.GNBIDLength)
+	}
+
+	// GlobalRANNodeID ::= CHOICE { globalGNB-ID, globalNgENB-ID,
+	// globalN3IWF-ID, choice-Extensions }
+	if err := w.WriteChoice(0, 4, false); err != nil {
+		return err
+	}
+	// GlobalGNB-ID ::= SEQUENCE { pLMNIdentity, gNB-ID, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writePLMNIdentity(w, id.PLMNIdentity); err != nil {
+		return err
+	}
+	// GNB-ID ::= CHOICE { gNB-ID BIT STRING (SIZE(22..32)), choice-Extensions }
+	if err := w.WriteChoice(0, 2, false); err != nil {
+		return err
+	}
+	value := uint64(id.GNBID) << (64 - uint(id.GNBIDLength))
+	bits := []byte{byte(value >> 56), byte(value >> 48), byte(value >> 40), byte(value >> 32)}
+	return w.WriteBitString(bits, int64(id.GNBIDLength), 22, 32, false)
+}
+
+// readGlobalRANNodeID reads a GlobalRANNodeID
+func readGlobalRANNodeID(r *aper.Reader) (GlobalRANNodeID, error) {
+	var id GlobalRANNodeID
+
+	choice, err := r.ReadChoice(4, false)
+	if err != nil {
+		return id, err
+	}
+	if choice != 0 {
+		return id, fmt.Errorf("%w: GlobalRANNodeID alternative %d", ErrUnsupportedMessage, choice)
+	}
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return id, err
+	}
+	if id.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+		return id, err
+	}
+	choice, err = r.ReadChoice(2, false)
+	if err != nil {
+		return id, err
+	}
+	if choice != 0 {
+		return id, fmt.Errorf("%w: GNB-ID alternative %d", ErrUnsupportedMessage, choice)
+	}
+	bits, n, err := r.ReadBitString(22, 32, false)
+	if err != nil {
+		return id, err
+	}
+
+	var value uint64
+	for i, b := range bits {
+		value |= uint64(b) << (56 - 8*uint(i))
+	}
+	id.GNBID = uint32(value >> (64 - uint(n)))
+	id.GNBIDLength = int(n)
+	return id, seq.end(r, 0)
+}
+
+// BroadcastPLMNItem is a PLMN broadcast in a tracking area with the
+// slices it supports
+type BroadcastPLMNItem struct {
+	PLMNIdentity     models.PlmnID
+	SliceSupportList []models.Snssai
+}
+
+// SupportedTAItem is a tracking area supported by a gNB
+type SupportedTAItem struct {
+	TAC               uint32
+	BroadcastPLMNList []BroadcastPLMNItem
+}
+
+// writeSupportedTAList writes a SupportedTAList
+func writeSupportedTAList(w *aper.Writer, items []SupportedTAItem) error {
+	return writeList(w, items, 1, maxnoofTACs, func(w *aper.Writer, item SupportedTAItem) error {
+		// SupportedTAItem ::= SEQUENCE { tAC, broadcastPLMNList,
+		// iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		if err := writeTAC(w, item.TAC); err != nil {
+			return err
+		}
+		return writeList(w, item.BroadcastPLMNList, 1, maxnoofBPLMNs, func(w *aper.Writer, p BroadcastPLMNItem) error {
+			// BroadcastPLMNItem ::= SEQUENCE { pLMNIdentity,
+			// tAISliceSupportList, iE-Extensions OPTIONAL, ... }
+			writeSequence(w, true, false)
+			if err := writePLMNIdentity(w, p.PLMNIdentity); err != nil {
+				return err
+			}
+			return writeSliceSupportList(w, p.SliceSupportList)
+		})
+	})
+}
+
+// readSupportedTAList reads a SupportedTAList
+func readSupportedTAList(r *aper.Reader) ([]SupportedTAItem, error) {
+	return readList(r, 1, maxnoofTACs, func(r *aper.Reader) (SupportedTAItem, error) {
+		var item SupportedTAItem
+
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return item, err
+		}
+		if item.TAC, err = readTAC(r); err != nil {
+			return item, err
+		}
+		item.BroadcastPLMNList, err = readList(r, 1, maxnoofBPLMNs, func(r *aper.Reader) (BroadcastPLMNItem, error) {
+			var p BroadcastPLMNItem
+
+			seq, err := readSequence(r, true, 1)
+			if err != nil {
+				return p, err
+			}
+			if p.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+				return p, err
+			}
+			if p.SliceSupportList, err = readSliceSupportList(r); err != nil {
+				return p, err
+			}
+			return p, seq.end(r, 0)
+		})
+		if err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 0)
+	})
+}
+
+// PLMNSupportItem is a PLMN served by an AMF with the slices it supports
+type PLMNSupportItem struct {
+	PLMNIdentity     models.PlmnID
+	SliceSupportList []models.Snssai
+}
+
+// writePLMNSupportList writes a PLMNSupportList
+func writePLMNSupportList(w *aper.Writer, items []PLMNSupportItem) error {
+	return writeList(w, items, 1, maxnoofPLMNs, func(w *aper.Writer, item PLMNSupportItem) error {
+		// PLMNSupportItem ::= SEQUENCE { pLMNIdentity, sliceSupportList,
+		// iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		if err := writePLMNIdentity(w, item.PLMNIdentity); err != nil {
+			return err
+		}
+		return writeSliceSupportList(w, item.SliceSupportList)
+	})
+}
+
+// readPLMNSupportList reads a PLMNSupportList
+func readPLMNSupportList(r *aper.Reader) ([]PLMNSupportItem, error) {
+	return readList(r, 1, maxnoofPLMNs, func(r *aper.Reader) (PLMNSupportItem, error) {
+		var item PLMNSupportItem
+
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return item, err
+		}
+		if item.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+			return item, err
+		}
+		if item.SliceSupportList, err = readSliceSupportList(r); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 0)
+	})
+}
+
+// GUAMI is a Globally Unique AMF Identifier
+type GUAMI struct {
+	PLMNIdentity models.PlmnID
+	AMFRegionID  uint8
+	AMFSetID     uint16 // 10 bits
+	AMFPointer   uint8  // 6 bits
+}
+
+// Model returns the GUAMI as an SBI model
+func (g GUAMI) Model() models.Guami {
+	return models.NewGuami(g.PLMNIdentity, g.AMFRegionID, g.AMFSetID, g.AMFPointer)
+}
+
+// writeAMFSetID writes an AMFSetID, BIT STRING (SIZE(10))
+func writeAMFSetID(w *aper.Writer, setID uint16) error {
+	if setID > 0x3ff {
+		return fmt.Errorf("invalid AMF set ID %d", setID)
+	}
+	return w.WriteBitString([]byte{byte(setID >> 2), byte(setID << 6)}, 10, 10, 10, false)
+}
+
+// readAMFSetID reads an AMFSetID
+func readAMFSetID(r *aper.Reader) (uint16, error) {
+	b, _, err := r.ReadBitString(10, 10, false)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(b[0])<<2 | uint16(b[1]>>6), nil
+}
+
+// writeAMFPointer writes an AMFPointer, BIT STRING (SIZE(6))
+func writeAMFPointer(w *aper.Writer, pointer uint8) error {
+	if pointer > 0x3f {
+		return fmt.Errorf("invalid AMF pointer %d", pointer)
+	}
+	return w.WriteBitString([]byte{pointer << 2}, 6, 6, 6, false)
+}
+
+// readAMFPointer reads an AMFPointer
+func readAMFPointer(r *aper.Reader) (uint8, error) {
+	b, _, err := r.ReadBitString(6, 6, false)
+	if err != nil {
+		return 0, err
+	}
+	return b[0] >> 2, nil
+}
+
+// writeGUAMI writes a GUAMI
+func writeGUAMI(w *aper.Writer, g GUAMI) error {
+	// GUAMI ::= SEQUENCE { pLMNIdentity, aMFRegionID, aMFSetID, aMFPointer,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writePLMNIdentity(w, g.PLMNIdentity); err != nil {
+		return err
+	}
+	if err := w.WriteBitString([]byte{g.AMFRegionID}, 8, 8, 8, false); err != nil {
+		return err
+	}
+	if err := writeAMFSetID(w, g.AMFSetID); err != nil {
+		return err
+	}
+	return writeAMFPointer(w, g.AMFPointer)
+}
+
+// readGUAMI reads a GUAMI
+func readGUAMI(r *aper.Reader) (GUAMI, error) {
+	var g GUAMI
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return g, err
+	}
+	if g.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+		return g, err
+	}
+	region, _, err := r.ReadBitString(8, 8, false)
+	if err != nil {
+		return g, err
+	}
+	g.AMFRegionID = region[0]
+	if g.AMFSetID, err = readAMFSetID(r); err != nil {
+		return g, err
+	}
+	if g.AMFPointer, err = readAMFPointer(r); err != nil {
+		return g, err
+	}
+	return g, seq.end(r, 0)
+}
+
+// ServedGUAMIItem is a GUAMI served by an AMF
+type ServedGUAMIItem struct {
+	GUAMI         GUAMI
+	BackupAMFName string
+}
+
+// writeServedGUAMIList writes a ServedGUAMIList
+func writeServedGUAMIList(w *aper.Writer, items []ServedGUAMIItem) error {
+	return writeList(w, items, 1, maxnoofServedGUAMIs, func(w *aper.Writer, item ServedGUAMIItem) error {
+		// ServedGUAMIItem ::= SEQUENCE { gUAMI, backupAMFName OPTIONAL,
+		// iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, item.BackupAMFName != "", false)
+		if err := writeGUAMI(w, item.GUAMI); err != nil {
+			return err
+		}
+		if item.BackupAMFName != "" {
+			return writeAMFName(w, item.BackupAMFName)
+		}
+		return nil
+	})
+}
+
+// readServedGUAMIList reads a ServedGUAMIList
+func readServedGUAMIList(r *aper.Reader) ([]ServedGUAMIItem, error) {
+	return readList(r, 1, maxnoofServedGUAMIs, func(r *aper.Reader) (ServedGUAMIItem, error) {
+		var item ServedGUAMIItem
+
+		seq, err := readSequence(r, true, 2)
+		if err != nil {
+			return item, err
+		}
+		if item.GUAMI, err = readGUAMI(r); err != nil {
+			return item, err
+		}
+		if seq.present[0] {
+			if item.BackupAMFName, err = readAMFName(r); err != nil {
+				return item, err
+			}
+		}
+		return item, seq.end(r, 1)
+	})
+}
+
+// PagingDRX is a paging DRX cycle
+type PagingDRX uint8
+
+const (
+	PagingDRX32 PagingDRX = iota
+	PagingDRX64
+	PagingDRX128
+	PagingDRX256
+)
+
+// writePagingDRX writes a PagingDRX, ENUMERATED {v32, v64, v128, v256, ...}
+func writePagingDRX(w *aper.Writer, drx PagingDRX) error {
+	return w.WriteEnumerated(uint64(drx), 4, true)
+}
+
+// readPagingDRX reads a PagingDRX
+func readPagingDRX(r *aper.Reader) (PagingDRX, error) {
+	v, err := r.ReadEnumerated(4, true)
+	return PagingDRX(v), err
+}
+
+// TimeToWait is the time a gNB waits before retrying NG Setup
+type TimeToWait uint8
+
+const (
+	TimeToWait1s TimeToWait = iota
+	TimeToWait2s
+	TimeToWait5s
+	TimeToWait10s
+	TimeToWait20s
+	TimeToWait60s
+)
+
+// CauseGroup selects the Cause alternative
+type CauseGroup uint8
+
+const (
+	CauseGroupRadioNetwork CauseGroup = iota
+	CauseGroupTransport
+	CauseGroupNAS
+	CauseGroupProtocol
+	CauseGroupMisc
+)
+
+// causeRootCounts holds the number of root values of each cause group
+var causeRootCounts = [...]uint64{
+	CauseGroupRadioNetwork: 45,
+	CauseGroupTransport:    2,
+	CauseGroupNAS:          4,
+	CauseGroupProtocol:     7,
+	CauseGroupMisc:         6,
+}
+
+// Cause is the reason of a failure or release (TS 38.413 9.3.1.2)
+type Cause struct {
+	Group CauseGroup
+	Value uint64
+}
+
+// Common causes
+var (
+	CauseRadioNetworkUnspecified         = Cause{CauseGroupRadioNetwork, 0}
+	CauseRadioNetworkSuccessfulHandover  = Cause{CauseGroupRadioNetwork, 2}
+	CauseRadioNetworkHandoverCancelled   = Cause{CauseGroupRadioNetwork, 5}
+	CauseRadioNetworkHOFailureInTarget   = Cause{CauseGroupRadioNetwork, 7}
+	CauseRadioNetworkHOTargetNotAllowed  = Cause{CauseGroupRadioNetwork, 8}
+	CauseRadioNetworkUnknownTargetID     = Cause{CauseGroupRadioNetwork, 12}
+	CauseRadioNetworkUnknownLocalUEID    = Cause{CauseGroupRadioNetwork, 14}
+	CauseRadioNetworkInconsistentUEID    = Cause{CauseGroupRadioNetwork, 15}
+	CauseRadioNetworkUserInactivity      = Cause{CauseGroupRadioNetwork, 20}
+	CauseRadioNetworkRadioConnectionLost = Cause{CauseGroupRadioNetwork, 21}
+	CauseRadioNetworkUnknownPDUSessionID = Cause{CauseGroupRadioNetwork, 26}
+	CauseRadioNetworkSliceNotSupported   = Cause{CauseGroupRadioNetwork, 39}
+	CauseTransportUnspecified            = Cause{CauseGroupTransport, 1}
+	CauseNASNormalRelease                = Cause{CauseGroupNAS, 0}
+	CauseNASAuthenticationFailure        = Cause{CauseGroupNAS, 1}
+	CauseNASDeregister                   = Cause{CauseGroupNAS, 2}
+	CauseNASUnspecified                  = Cause{CauseGroupNAS, 3}
+	CauseProtocolSemanticError           = Cause{CauseGroupProtocol, 4}
+	CauseProtocolUnspecified             = Cause{CauseGroupProtocol, 6}
+	CauseMiscControlProcessingOverload   = Cause{CauseGroupMisc, 0}
+	CauseMiscOMIntervention              = Cause{CauseGroupMisc, 3}
+	CauseMiscUnknownPLMN                 = Cause{CauseGroupMisc, 4}
+	CauseMiscUnspecified                 = Cause{CauseGroupMisc, 5}
+)
+
+// String implements fmt.Stringer
+func (c Cause) String() string {
+	groups := [...]string{"radioNetwork", "transport", "nas", "protocol", "misc"}
+	if int(c.Group) < len(groups) {
+		return fmt.Sprintf("%s(%d)", groups[c.Group], c.Value)
+	}
+	return fmt.Sprintf("cause(%d,%d)", c.Group, c.Value)
+}
+
+// writeCause writes a Cause
+func writeCause(w *aper.Writer, c Cause) error {
+	if int(c.Group) >= len(causeRootCounts) {
+		return fmt.Errorf("invalid cause group %d", c.Group)
+	}
+
+	// Cause ::= CHOICE { radioNetwork, transport, nas, protocol, misc,
+	// choice-Extensions }
+	if err := w.WriteChoice(uint64(c.Group), 6, false); err != nil {
+		return err
+	}
+	return w.WriteEnumerated(c.Value, causeRootCounts[c.Group], true)
+}
+
+// readCause reads a Cause
+func readCause(r *aper.Reader) (Cause, error) {
+	group, err := r.ReadChoice(6, false)
+	if err != nil {
+		return Cause{}, err
+	}
+	if int(group) >= len(causeRootCounts) {
+		return Cause{}, fmt.Errorf("%w: Cause alternative %d", ErrUnsupportedMessage, group)
+	}
+	value, err := r.ReadEnumerated(causeRootCounts[group], true)
+	return Cause{Group: CauseGroup(group), Value: value}, err
+}
+
+// TAI is a Tracking Area Identity
+type TAI struct {
+	PLMNIdentity models.PlmnID
+	TAC          uint32
+}
+
+// Model returns the TAI as an SBI model
+func (t TAI) Model() models.Tai {
+	return models.NewTai(t.PLMNIdentity, t.TAC)
+}
+
+// writeTAI writes a TAI
+func writeTAI(w *aper.Writer, tai TAI) error {
+	// TAI ::= SEQUENCE { pLMNIdentity, tAC, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writePLMNIdentity(w, tai.PLMNIdentity); err != nil {
+		return err
+	}
+	return writeTAC(w, tai.TAC)
+}
+
+// readTAI reads a TAI
+func readTAI(r *aper.Reader) (TAI, error) {
+	var tai TAI
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return tai, err
+	}
+	if tai.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+		return tai, err
+	}
+	if tai.TAC, err = readTAC(r); err != nil {
+		return tai, err
+	}
+	return tai, seq.end(r, 0)
+}
+
+// NRCGI is an NR Cell Global Identity
+type NRCGI struct {
+	PLMNIdentity   models.PlmnID
+	NRCellIdentity uint64 // 36 bits
+}
+
+// writeNRCGI writes an NR-CGI
+func writeNRCGI(w *aper.Writer, cgi NRCGI) error {
+	if cgi.NRCellIdentity >= 1<<36 {
+		return fmt.Errorf("invalid NR cell identity %d", cgi.NRCellIdentity)
+	}
+
+	// NR-CGI ::= SEQUENCE { pLMNIdentity, nRCellIdentity BIT STRING
+	// (SIZE(36)), iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writePLMNIdentity(w, cgi.PLMNIdentity); err != nil {
+		return err
+	}
+	v := cgi.NRCellIdentity << 4
+	bits := []byte{byte(v >> 32), byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+	return w.WriteBitString(bits, 36, 36, 36, false)
+}
+
+// readNRCGI reads an NR-CGI
+func readNRCGI(r *aper.Reader) (NRCGI, error) {
+	var cgi NRCGI
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return cgi, err
+	}
+	if cgi.PLMNIdentity, err = readPLMNIdentity(r); err != nil {
+		return cgi, err
+	}
+	bits, _, err := r.ReadBitString(36, 36, false)
+	if err != nil {
+		return cgi, err
+	}
+	var v uint64
+	for _, b := range bits {
+		v = v<<8 | uint64(b)
+	}
+	cgi.NRCellIdentity = v >> 4
+	return cgi, seq.end(r, 0)
+}
+
+// UserLocationInformation is the location of a UE. Only the NR
+// alternative is supported.
+type UserLocationInformation struct {
+	NRCGI     NRCGI
+	TAI       TAI
+	TimeStamp []byte // 4 octets NTP seconds, optional
+}
+
+// writeUserLocationInformation writes a UserLocationInformation
+func writeUserLocationInformation(w *aper.Writer, uli UserLocationInformation) error {
+	// UserLocationInformation ::= CHOICE { userLocationInformationEUTRA,
+	// userLocationInformationNR, userLocationInformationN3IWF,
+	// choice-Extensions }
+	if err := w.WriteChoice(1, 4, false); err != nil {
+		return err
+	}
+	// UserLocationInformationNR ::= SEQUENCE { nR-CGI, tAI,
+	// timeStamp OPTIONAL, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, uli.TimeStamp != nil, false)
+	if err := writeNRCGI(w, uli.NRCGI); err != nil {
+		return err
+	}
+	if err := writeTAI(w, uli.TAI); err != nil {
+		return err
+	}
+	if uli.TimeStamp != nil {
+		return w.WriteOctetString(uli.TimeStamp, 4, 4, false)
+	}
+	return nil
+}
+
+// readUserLocationInformation reads a UserLocationInformation
+func readUserLocationInformation(r *aper.Reader) (UserLocationInformation, error) {
+	var uli UserLocationInformation
+
+	choice, err := r.ReadChoice(4, false)
+	if err != nil {
+		return uli, err
+	}
+	if choice != 1 {
+		return uli, fmt.Errorf("%w: UserLocationInformation alternative %d", ErrUnsupportedMessage, choice)
+	}
+
+	seq, err := readSequence(r, true, 2)
+	if err != nil {
+		return uli, err
+	}
+	if uli.NRCGI, err = readNRCGI(r); err != nil {
+		return uli, err
+	}
+	if uli.TAI, err = readTAI(r); err != nil {
+		return uli, err
+	}
+	if seq.present[0] {
+		if uli.TimeStamp, err = r.ReadOctetString(4, 4, false); err != nil {
+			return uli, err
+		}
+	}
+	return uli, seq.end(r, 1)
+}
+
+// RRCEstablishmentCause is the reason a UE established its RRC connection
+type RRCEstablishmentCause uint8
+
+const (
+	RRCEstablishmentCauseEmergency RRCEstablishmentCause = iota
+	RRCEstablishmentCauseHighPriorityAccess
+	RRCEstablishmentCauseMtAccess
+	RRCEstablishmentCauseMoSignalling
+	RRCEstablishmentCauseMoData
+	RRCEstablishmentCauseMoVoiceCall
+	RRCEstablishmentCauseMoVideoCall
+	RRCEstablishmentCauseMoSMS
+	RRCEstablishmentCauseMpsPriorityAccess
+	RRCEstablishmentCauseMcsPriorityAccess
+)
+
+// rrcEstablishmentCauseCount is the number of root values of
+// RRCEstablishmentCause
+const rrcEstablishmentCauseCount = 10
+
+// FiveGSTMSI is a 5G S-Temporary Mobile Subscriber Identity
+type FiveGSTMSI struct {
+	AMFSetID   uint16
+	AMFPointer uint8
+	FiveGTMSI  uint32
+}
+
+// writeFiveGSTMSI writes a FiveG-S-TMSI
+func writeFiveGSTMSI(w *aper.Writer, s FiveGSTMSI) error {
+	// FiveG-S-TMSI ::= SEQUENCE { aMFSetID, aMFPointer, fiveG-TMSI,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writeAMFSetID(w, s.AMFSetID); err != nil {
+		return err
+	}
+	if err := writeAMFPointer(w, s.AMFPointer); err != nil {
+		return err
+	}
+	tmsi := []byte{byte(s.FiveGTMSI >> 24), byte(s.FiveGTMSI >> 16), byte(s.FiveGTMSI >> 8), byte(s.FiveGTMSI)}
+	return w.WriteOctetString(tmsi, 4, 4, false)
+}
+
+// readFiveGSTMSI reads a FiveG-S-TMSI
+func readFiveGSTMSI(r *aper.Reader) (FiveGSTMSI, error) {
+	var s FiveGSTMSI
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return s, err
+	}
+	if s.AMFSetID, err = readAMFSetID(r); err != nil {
+		return s, err
+	}
+	if s.AMFPointer, err = readAMFPointer(r); err != nil {
+		return s, err
+	}
+	tmsi, err := r.ReadOctetString(4, 4, false)
+	if err != nil {
+		return s, err
+	}
+	s.FiveGTMSI = uint32(tmsi[0])<<24 | uint32(tmsi[1])<<16 | uint32(tmsi[2])<<8 | uint32(tmsi[3])
+	return s, seq.end(r, 0)
+}
+
+// UESecurityCapabilities are the algorithms supported by a UE, as 16 bit
+// masks with the first algorithm in the most significant bit
+type UESecurityCapabilities struct {
+	NREncryptionAlgorithms             uint16
+	NRIntegrityProtectionAlgorithms    uint16
+	EUTRAEncryptionAlgorithms          uint16
+	EUTRAIntegrityProtectionAlgorithms uint16
+}
+
+// writeUESecurityCapabilities writes a UESecurityCapabilities
+func writeUESecurityCapabilities(w *aper.Writer, c UESecurityCapabilities) error {
+	writeSequence(w, true, false)
+	for _, mask := range []uint16{
+		c.NREncryptionAlgorithms,
+		c.NRIntegrityProtectionAlgorithms,
+		c.EUTRAEncryptionAlgorithms,
+		c.EUTRAIntegrityProtectionAlgorithms,
+	} {
+		// BIT STRING (SIZE(16, ...))
+		if err := w.WriteBitString([]byte{byte(mask >> 8), byte(mask)}, 16, 16, 16, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUESecurityCapabilities reads a UESecurityCapabilities
+func readUESecurityCapabilities(r *aper.Reader) (UESecurityCapabilities, error) {
+	var c UESecurityCapabilities
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return c, err
+	}
+	for _, mask := range []*uint16{
+		&c.NREncryptionAlgorithms,
+		&c.NRIntegrityProtectionAlgorithms,
+		&c.EUTRAEncryptionAlgorithms,
+		&c.EUTRAIntegrityProtectionAlgorithms,
+	} {
+		b, _, err := r.ReadBitString(16, 16, true)
+		if err != nil {
+			return c, err
+		}
+		*mask = uint16(b[0])<<8 | uint16(b[1])
+	}
+	return c, seq.end(r, 0)
+}
+
+// writeSecurityKey writes a SecurityKey, BIT STRING (SIZE(256))
+func writeSecurityKey(w *aper.Writer, key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("invalid security key length %d", len(key))
+	}
+	return w.WriteBitString(key, 256, 256, 256, false)
+}
+
+// readSecurityKey reads a SecurityKey
+func readSecurityKey(r *aper.Reader) ([]byte, error) {
+	key, _, err := r.ReadBitString(256, 256, false)
+	return key, err
+}
+
+// UEAggregateMaximumBitRate is the maximum bit rate of all non-GBR QoS
+// flows of a UE, in bits per second
+type UEAggregateMaximumBitRate struct {
+	Downlink uint64
+	Uplink   uint64
+}
+
+// maxBitRate is the upper bound of BitRate
+const maxBitRate = 4000000000000
+
+// writeUEAggregateMaximumBitRate writes a UEAggregateMaximumBitRate
+func writeUEAggregateMaximumBitRate(w *aper.Writer, ambr UEAggregateMaximumBitRate) error {
+	writeSequence(w, true, false)
+	// BitRate ::= INTEGER (0..4000000000000, ...)
+	if err := w.WriteInteger(int64(ambr.Downlink), 0, maxBitRate, true); err != nil {
+		return err
+	}
+	return w.WriteInteger(int64(ambr.Uplink), 0, maxBitRate, true)
+}
+
+// readUEAggregateMaximumBitRate reads a UEAggregateMaximumBitRate
+func readUEAggregateMaximumBitRate(r *aper.Reader) (UEAggregateMaximumBitRate, error) {
+	var ambr UEAggregateMaximumBitRate
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return ambr, err
+	}
+	dl, err := r.ReadInteger(0, maxBitRate, true)
+	if err != nil {
+		return ambr, err
+	}
+	ul, err := r.ReadInteger(0, maxBitRate, true)
+	if err != nil {
+		return ambr, err
+	}
+	ambr.Downlink, ambr.Uplink = uint64(dl), uint64(ul)
+	return ambr, seq.end(r, 0)
+}
+
+// writePDUSessionID writes a PDUSessionID, INTEGER (0..255)
+func writePDUSessionID(w *aper.Writer, id uint8) error {
+	return w.WriteConstrainedWholeNumber(int64(id), 0, 255)
+}
+
+// readPDUSessionID reads a PDUSessionID
+func readPDUSessionID(r *aper.Reader) (uint8, error) {
+	v, err := r.ReadConstrainedWholeNumber(0, 255)
+	return uint8(v), err
+}
+
+// PDUSessionResourceSetupItem is a PDU session to set up, as carried in
+// the PDUSessionResourceSetupListCxtReq and PDUSessionResourceSetupListSUReq
+type PDUSessionResourceSetupItem struct {
+	PDUSessionID uint8
+	NASPDU       []byte
+	SNSSAI       models.Snssai
+
+	// Transfer is the encoded PDUSessionResourceSetupRequestTransfer
+	// built by the SMF
+	Transfer []byte
+}
+
+// writePDUSessionResourceSetupList writes a list of
+// PDUSessionResourceSetupItem
+func writePDUSessionResourceSetupList(w *aper.Writer, items []PDUSessionResourceSetupItem) error {
+	return writeList(w, items, 1, maxnoofPDUSessions, func(w *aper.Writer, item PDUSessionResourceSetupItem) error {
+		// SEQUENCE { pDUSessionID, nAS-PDU OPTIONAL, s-NSSAI,
+		// pDUSessionResourceSetupRequestTransfer, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, item.NASPDU != nil, false)
+		if err := writePDUSessionID(w, item.PDUSessionID); err != nil {
+			return err
+		}
+		if item.NASPDU != nil {
+			if err := writeNASPDU(w, item.NASPDU); err != nil {
+				return err
+			}
+		}
+		if err := writeSNSSAI(w, item.SNSSAI); err != nil {
+			return err
+		}
+		return w.WriteOctetString(item.Transfer, 0, -1, false)
+	})
+}
+
+// readPDUSessionResourceSetupList reads a list of
+// PDUSessionResourceSetupItem
+func readPDUSessionResourceSetupList(r *aper.Reader) ([]PDUSessionResourceSetupItem, error) {
+	return readList(r, 1, maxnoofPDUSessions, func(r *aper.Reader) (PDUSessionResourceSetupItem, error) {
+		var item PDUSessionResourceSetupItem
+
+		seq, err := readSequence(r, true, 2)
+		if err != nil {
+			return item, err
+		}
+		if item.PDUSessionID, err = readPDUSessionID(r); err != nil {
+			return item, err
+		}
+		if seq.present[0] {
+			if item.NASPDU, err = readNASPDU(r); err != nil {
+				return item, err
+			}
+		}
+		if item.SNSSAI, err = readSNSSAI(r); err != nil {
+			return item, err
+		}
+		if item.Transfer, err = r.ReadOctetString(0, -1, false); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 1)
+	})
+}
+
+// PDUSessionResourceModifyItem is a PDU session to modify in a
+// PDUSessionResourceModifyRequest
+type PDUSessionResourceModifyItem struct {
+	PDUSessionID uint8
+	NASPDU       []byte
+
+	// Transfer is the encoded PDUSessionResourceModifyRequestTransfer
+	// built by the SMF
+	Transfer []byte
+}
+
+// writePDUSessionResourceModifyList writes a list of
+// PDUSessionResourceModifyItem
+func writePDUSessionResourceModifyList(w *aper.Writer, items []PDUSessionResourceModifyItem) error {
+	return writeList(w, items, 1, maxnoofPDUSessions, func(w *aper.Writer, item PDUSessionResourceModifyItem) error {
+		// SEQUENCE { pDUSessionID, nAS-PDU OPTIONAL,
+		// pDUSessionResourceModifyRequestTransfer, iE-Extensions OPTIONAL,
+		// ... }
+		writeSequence(w, true, item.NASPDU != nil, false)
+		if err := writePDUSessionID(w, item.PDUSessionID); err != nil {
+			return err
+		}
+		if item.NASPDU != nil {
+			if err := writeNASPDU(w, item.NASPDU); err != nil {
+				return err
+			}
+		}
+		return w.WriteOctetString(item.Transfer, 0, -1, false)
+	})
+}
+
+// readPDUSessionResourceModifyList reads a list of
+// PDUSessionResourceModifyItem
+func readPDUSessionResourceModifyList(r *aper.Reader) ([]PDUSessionResourceModifyItem, error) {
+	return readList(r, 1, maxnoofPDUSessions, func(r *aper.Reader) (PDUSessionResourceModifyItem, error) {
+		var item PDUSessionResourceModifyItem
+
+		seq, err := readSequence(r, true, 2)
+		if err != nil {
+			return item, err
+		}
+		if item.PDUSessionID, err = readPDUSessionID(r); err != nil {
+			return item, err
+		}
+		if seq.present[0] {
+			if item.NASPDU, err = readNASPDU(r); err != nil {
+				return item, err
+			}
+		}
+		if item.Transfer, err = r.ReadOctetString(0, -1, false); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 1)
+	})
+}
+
+// PDUSessionResourceItem is a PDU session with an encoded transfer
+// container, the shape shared by the setup response, modify response,
+// failure and release lists
+type PDUSessionResourceItem struct {
+	PDUSessionID uint8
+	Transfer     []byte
+}
+
+// writePDUSessionResourceList writes a list of PDUSessionResourceItem
+func writePDUSessionResourceList(w *aper.Writer, items []PDUSessionResourceItem) error {
+	return writeList(w, items, 1, maxnoofPDUSessions, func(w *aper.Writer, item PDUSessionResourceItem) error {
+		// SEQUENCE { pDUSessionID, transfer OCTET STRING,
+		// iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		if err := writePDUSessionID(w, item.PDUSessionID); err != nil {
+			return err
+		}
+		return w.WriteOctetString(item.Transfer, 0, -1, false)
+	})
+}
+
+// readPDUSessionResourceList reads a list of PDUSessionResourceItem
+func readPDUSessionResourceList(r *aper.Reader) ([]PDUSessionResourceItem, error) {
+	return readList(r, 1, maxnoofPDUSessions, func(r *aper.Reader) (PDUSessionResourceItem, error) {
+		var item PDUSessionResourceItem
+
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return item, err
+		}
+		if item.PDUSessionID, err = readPDUSessionID(r); err != nil {
+			return item, err
+		}
+		if item.Transfer, err = r.ReadOctetString(0, -1, false); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 0)
+	})
+}
+
+// writePDUSessionIDList writes a list of SEQUENCE { pDUSessionID,
+// iE-Extensions OPTIONAL, ... }
+func writePDUSessionIDList(w *aper.Writer, ids []uint8) error {
+	return writeList(w, ids, 1, maxnoofPDUSessions, func(w *aper.Writer, id uint8) error {
+		writeSequence(w, true, false)
+		return writePDUSessionID(w, id)
+	})
+}
+
+// readPDUSessionIDList reads a list of PDU session identifiers
+func readPDUSessionIDList(r *aper.Reader) ([]uint8, error) {
+	return readList(r, 1, maxnoofPDUSessions, func(r *aper.Reader) (uint8, error) {
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return 0, err
+		}
+		id, err := readPDUSessionID(r)
+		if err != nil {
+			return 0, err
+		}
+		return id, seq.end(r, 0)
+	})
+}
+
+// UENGAPIDs identifies the UE of a UE Context Release Command, either by
+// both NGAP identifiers or by the AMF one only
+type UENGAPIDs struct {
+	AMFUENGAPID int64
+	RANUENGAPID int64
+	AMFOnly     bool
+}
+
+// writeUENGAPIDs writes a UE-NGAP-IDs
+func writeUENGAPIDs(w *aper.Writer, ids UENGAPIDs) error {
+	// UE-NGAP-IDs ::= CHOICE { uE-NGAP-ID-pair, aMF-UE-NGAP-ID,
+	// choice-Extensions }
+	if ids.AMFOnly {
+		if err := w.WriteChoice(1, 3, false); err != nil {
+			return err
+		}
+		return writeAMFUENGAPID(w, ids.AMFUENGAPID)
+	}
+
+	if err := w.WriteChoice(0, 3, false); err != nil {
+		return err
+	}
+	// UE-NGAP-ID-pair ::= SEQUENCE { aMF-UE-NGAP-ID, rAN-UE-NGAP-ID,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writeAMFUENGAPID(w, ids.AMFUENGAPID); err != nil {
+		return err
+	}
+	return writeRANUENGAPID(w, ids.RANUENGAPID)
+}
+
+// readUENGAPIDs reads a UE-NGAP-IDs
+func readUENGAPIDs(r *aper.Reader) (UENGAPIDs, error) {
+	var ids UENGAPIDs
+
+	choice, err := r.ReadChoice(3, false)
+	if err != nil {
+		return ids, err
+	}
+	switch choice {
+	case 0:
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return ids, err
+		}
+		if ids.AMFUENGAPID, err = readAMFUENGAPID(r); err != nil {
+			return ids, err
+		}
+		if ids.RANUENGAPID, err = readRANUENGAPID(r); err != nil {
+			return ids, err
+		}
+		return ids, seq.end(r, 0)
+	case 1:
+		ids.AMFOnly = true
+		ids.AMFUENGAPID, err = readAMFUENGAPID(r)
+		return ids, err
+	default:
+		return ids, fmt.Errorf("%w: UE-NGAP-IDs alternative %d", ErrUnsupportedMessage, choice)
+	}
+}
+
+// writeUEPagingIdentity writes a UEPagingIdentity
+func writeUEPagingIdentity(w *aper.Writer, s FiveGSTMSI) error {
+	// UEPagingIdentity ::= CHOICE { fiveG-S-TMSI, choice-Extensions }
+	if err := w.WriteChoice(0, 2, false); err != nil {
+		return err
+	}
+	return writeFiveGSTMSI(w, s)
+}
+
+// readUEPagingIdentity reads a UEPagingIdentity
+func readUEPagingIdentity(r *aper.Reader) (FiveGSTMSI, error) {
+	choice, err := r.ReadChoice(2, false)
+	if err != nil {
+		return FiveGSTMSI{}, err
+	}
+	if choice != 0 {
+		return FiveGSTMSI{}, fmt.Errorf("%w: UEPagingIdentity alternative %d", ErrUnsupportedMessage, choice)
+	}
+	return readFiveGSTMSI(r)
+}
+
+// writeTAIListForPaging writes a TAIListForPaging
+func writeTAIListForPaging(w *aper.Writer, tais []TAI) error {
+	return writeList(w, tais, 1, maxnoofTAIforPaging, func(w *aper.Writer, tai TAI) error {
+		// TAIListForPagingItem ::= SEQUENCE { tAI, iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		return writeTAI(w, tai)
+	})
+}
+
+// readTAIListForPaging reads a TAIListForPaging
+func readTAIListForPaging(r *aper.Reader) ([]TAI, error) {
+	return readList(r, 1, maxnoofTAIforPaging, func(r *aper.Reader) (TAI, error) {
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return TAI{}, err
+		}
+		tai, err := readTAI(r)
+		if err != nil {
+			return tai, err
+		}
+		return tai, seq.end(r, 0)
+	})
+}
+
+// HandoverType is the kind of a handover
+type HandoverType uint8
+
+const (
+	HandoverTypeIntra5GS HandoverType = iota
+	HandoverType5GSToEPS
+	HandoverTypeEPSTo5GS
+)
+
+// writeHandoverType writes a HandoverType, ENUMERATED {intra5gs,
+// fivegs-to-eps, eps-to-5gs, ...}
+func writeHandoverType(w *aper.Writer, t HandoverType) error {
+	return w.WriteEnumerated(uint64(t), 3, true)
+}
+
+// readHandoverType reads a HandoverType
+func readHandoverType(r *aper.Reader) (HandoverType, error) {
+	v, err := r.ReadEnumerated(3, true)
+	return HandoverType(v), err
+}
+
+// TargetID is the target of a handover. Only the targetRANNodeID
+// alternative, a gNB, is supported.
+type TargetID struct {
+	GlobalRANNodeID GlobalRANNodeID
+	SelectedTAI     TAI
+}
+
+// writeTargetID writes a TargetID
+func writeTargetID(w *aper.Writer, id TargetID) error {
+	// TargetID ::= CHOICE { targetRANNodeID, targeteNB-ID,
+	// choice-Extensions }
+	if err := w.WriteChoice(0, 3, false); err != nil {
+		return err
+	}
+	// TargetRANNodeID ::= SEQUENCE { globalRANNodeID, selectedTAI,
+	// iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := writeGlobalRANNodeID(w, id.GlobalRANNodeID); err != nil {
+		return err
+	}
+	return writeTAI(w, id.SelectedTAI)
+}
+
+// readTargetID reads a TargetID
+func readTargetID(r *aper.Reader) (TargetID, error) {
+	var id TargetID
+
+	choice, err := r.ReadChoice(3, false)
+	if err != nil {
+		return id, err
+	}
+	if choice != 0 {
+		return id, fmt.Errorf("%w: TargetID alternative %d", ErrUnsupportedMessage, choice)
+	}
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return id, err
+	}
+	if id.GlobalRANNodeID, err = readGlobalRANNodeID(r); err != nil {
+		return id, err
+	}
+	if id.SelectedTAI, err = readTAI(r); err != nil {
+		return id, err
+	}
+	return id, seq.end(r, 0)
+}
+
+// SecurityContext is the next hop key given to a gNB taking over a UE,
+// with its chaining count (TS 33.501 6.9.2)
+type SecurityContext struct {
+	NextHopChainingCount uint8 // 0 to 7
+	NextHopNH            []byte
+}
+
+// writeSecurityContext writes a SecurityContext
+func writeSecurityContext(w *aper.Writer, sc SecurityContext) error {
+	// SecurityContext ::= SEQUENCE { nextHopChainingCount INTEGER (0..7),
+	// nextHopNH SecurityKey, iE-Extensions OPTIONAL, ... }
+	writeSequence(w, true, false)
+	if err := w.WriteConstrainedWholeNumber(int64(sc.NextHopChainingCount), 0, 7); err != nil {
+		return err
+	}
+	return writeSecurityKey(w, sc.NextHopNH)
+}
+
+// readSecurityContext reads a SecurityContext
+func readSecurityContext(r *aper.Reader) (SecurityContext, error) {
+	var sc SecurityContext
+
+	seq, err := readSequence(r, true, 1)
+	if err != nil {
+		return sc, err
+	}
+	ncc, err := r.ReadConstrainedWholeNumber(0, 7)
+	if err != nil {
+		return sc, err
+	}
+	sc.NextHopChainingCount = uint8(ncc)
+	if sc.NextHopNH, err = readSecurityKey(r); err != nil {
+		return sc, err
+	}
+	return sc, seq.end(r, 0)
+}
+
+// writeTransparentContainer writes a source to target or target to
+// source transparent container, OCTET STRING
+func writeTransparentContainer(w *aper.Writer, container []byte) error {
+	return w.WriteOctetString(container, 0, -1, false)
+}
+
+// readTransparentContainer reads a transparent container
+func readTransparentContainer(r *aper.Reader) ([]byte, error) {
+	return r.ReadOctetString(0, -1, false)
+}
+
+// PDUSessionResourceSetupItemHOReq is a PDU session to set up in the
+// target gNB of a handover
+type PDUSessionResourceSetupItemHOReq struct {
+	PDUSessionID uint8
+	SNSSAI       models.Snssai
+
+	// Transfer is the encoded HandoverRequestTransfer built by the SMF
+	Transfer []byte
+}
+
+// writePDUSessionResourceSetupListHOReq writes a list of
+// PDUSessionResourceSetupItemHOReq
+func writePDUSessionResourceSetupListHOReq(w *aper.Writer, items []PDUSessionResourceSetupItemHOReq) error {
+	return writeList(w, items, 1, maxnoofPDUSessions, func(w *aper.Writer, item PDUSessionResourceSetupItemHOReq) error {
+		// SEQUENCE { pDUSessionID, s-NSSAI, handoverRequestTransfer,
+		// iE-Extensions OPTIONAL, ... }
+		writeSequence(w, true, false)
+		if err := writePDUSessionID(w, item.PDUSessionID); err != nil {
+			return err
+		}
+		if err := writeSNSSAI(w, item.SNSSAI); err != nil {
+			return err
+		}
+		return w.WriteOctetString(item.Transfer, 0, -1, false)
+	})
+}
+
+// readPDUSessionResourceSetupListHOReq reads a list of
+// PDUSessionResourceSetupItemHOReq
+func readPDUSessionResourceSetupListHOReq(r *aper.Reader) ([]PDUSessionResourceSetupItemHOReq, error) {
+	return readList(r, 1, maxnoofPDUSessions, func(r *aper.Reader) (PDUSessionResourceSetupItemHOReq, error) {
+		var item PDUSessionResourceSetupItemHOReq
+
+		seq, err := readSequence(r, true, 1)
+		if err != nil {
+			return item, err
+		}
+		if item.PDUSessionID, err = readPDUSessionID(r); err != nil {
+			return item, err
+		}
+		if item.SNSSAI, err = readSNSSAI(r); err != nil {
+			return item, err
+		}
+		if item.Transfer, err = r.ReadOctetString(0, -1, false); err != nil {
+			return item, err
+		}
+		return item, seq.end(r, 0)
+	})
+}
+
+// EncodeUnsuccessfulTransfer encodes the transfer container of a PDU
+// session the AMF could not switch or hand over. Path switch and handover
+// preparation share its shape: SEQUENCE { cause, iE-Extensions OPTIONAL,
+// ... }.
+func EncodeUnsuccessfulTransfer(cause Cause) ([]byte, error) {
+	w := aper.NewWriter()
+	writeSequence(w, true, false)
+	if err := writeCause(w, cause); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
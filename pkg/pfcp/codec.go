@@ -0,0 +1,349 @@
+package pfcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// writer builds a PFCP message, keeping the first encoding error
+type writer struct {
+	b   []byte
+	err error
+}
+
+// fail records an encoding error
+func (w *writer) fail(format string, args ...interface{}) {
+	if w.err == nil {
+		w.err = fmt.Errorf(format, args...)
+	}
+}
+
+// uint8 writes an octet
+func (w *writer) uint8(v uint8) {
+	w.b = append(w.b, v)
+}
+
+// uint16 writes two octets in network order
+func (w *writer) uint16(v uint16) {
+	w.b = binary.BigEndian.AppendUint16(w.b, v)
+}
+
+// uint24 writes the three low octets of v in network order
+func (w *writer) uint24(v uint32) {
+	w.b = append(w.b, uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+// uint32 writes four octets in network order
+func (w *writer) uint32(v uint32) {
+	w.b = binary.BigEndian.AppendUint32(w.b, v)
+}
+
+// uint40 writes the five low octets of v in network order
+func (w *writer) uint40(v uint64) {
+	w.b = append(w.b, uint8(v>>32), uint8(v>>24), uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+// uint64 writes eight octets in network order
+func (w *writer) uint64(v uint64) {
+	w.b = binary.BigEndian.AppendUint64(w.b, v)
+}
+
+// bytes writes raw octets
+func (w *writer) bytes(v []byte) {
+	w.b = append(w.b, v...)
+}
+
+// ipv4 writes an IPv4 address
+func (w *writer) ipv4(ip net.IP) {
+	v4 := ip.To4()
+	if v4 == nil {
+		w.fail("invalid IPv4 address %v", ip)
+		v4 = net.IPv4zero.To4()
+	}
+	w.bytes(v4)
+}
+
+// ipv6 writes an IPv6 address
+func (w *writer) ipv6(ip net.IP) {
+	v6 := ip.To16()
+	if v6 == nil {
+		w.fail("invalid IPv6 address %v", ip)
+		v6 = net.IPv6zero
+	}
+	w.bytes(v6)
+}
+
+// ie writes an IE whose value is written by value (TS 29.244 8.1.1)
+func (w *writer) ie(t ieType, value func(*writer)) {
+	w.uint16(uint16(t))
+	at := len(w.b)
+	w.uint16(0)
+	value(w)
+	n := len(w.b) - at - 2
+	if n > 0xffff {
+		w.fail("IE %d of %d octets too long", t, n)
+		return
+	}
+	binary.BigEndian.PutUint16(w.b[at:], uint16(n))
+}
+
+// uint8IE writes an IE of one octet
+func (w *writer) uint8IE(t ieType, v uint8) {
+	w.ie(t, func(w *writer) { w.uint8(v) })
+}
+
+// uint16IE writes an IE of two octets
+func (w *writer) uint16IE(t ieType, v uint16) {
+	w.ie(t, func(w *writer) { w.uint16(v) })
+}
+
+// uint32IE writes an IE of four octets
+func (w *writer) uint32IE(t ieType, v uint32) {
+	w.ie(t, func(w *writer) { w.uint32(v) })
+}
+
+// encoder is an IE value
+type encoder interface {
+	encode(*writer)
+}
+
+// valueIE writes an IE holding v
+func (w *writer) valueIE(t ieType, v encoder) {
+	w.ie(t, v.encode)
+}
+
+// optIE writes an IE holding v when it is not nil
+func optIE[T any, P interface {
+	*T
+	encoder
+}](w *writer, t ieType, v P) {
+	if v != nil {
+		w.valueIE(t, v)
+	}
+}
+
+// ie is an undecoded IE
+type ie struct {
+	typ   ieType
+	value []byte
+}
+
+// ies holds the IEs of a message or of a grouped IE, in order
+type ies []ie
+
+// parseIEs splits a sequence of IEs. Vendor specific IEs are skipped.
+func parseIEs(b []byte) (ies, error) {
+	var list ies
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, ErrTruncated
+		}
+		t := ieType(binary.BigEndian.Uint16(b))
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		if len(b) < 4+n {
+			return nil, ErrTruncated
+		}
+		if t&0x8000 == 0 {
+			list = append(list, ie{typ: t, value: b[4 : 4+n : 4+n]})
+		}
+		b = b[4+n:]
+	}
+	return list, nil
+}
+
+// find returns the value of the first IE of a type, nil when absent
+func (l ies) find(t ieType) []byte {
+	for _, e := range l {
+		if e.typ == t {
+			return e.value
+		}
+	}
+	return nil
+}
+
+// has reports whether an IE of a type is present
+func (l ies) has(t ieType) bool {
+	for _, e := range l {
+		if e.typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// all returns the values of the IEs of a type
+func (l ies) all(t ieType) [][]byte {
+	var values [][]byte
+	for _, e := range l {
+		if e.typ == t {
+			values = append(values, e.value)
+		}
+	}
+	return values
+}
+
+// decoder is an IE value that can be decoded
+type decoder interface {
+	decode([]byte) error
+}
+
+// decodeIE decodes the first IE of a type into v, reporting whether it
+// was present
+func (l ies) decodeIE(t ieType, v decoder) (bool, error) {
+	if !l.has(t) {
+		return false, nil
+	}
+	if err := v.decode(l.find(t)); err != nil {
+		return true, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return true, nil
+}
+
+// mandatory decodes the first IE of a type into v, failing when absent
+func (l ies) mandatory(t ieType, v decoder) error {
+	ok, err := l.decodeIE(t, v)
+	if err == nil && !ok {
+		err = fmt.Errorf("missing mandatory IE %d", t)
+	}
+	return err
+}
+
+// optional decodes the first IE of a type, nil when absent
+func optional[T any, P interface {
+	*T
+	decoder
+}](l ies, t ieType) (P, error) {
+	if !l.has(t) {
+		return nil, nil
+	}
+	v := P(new(T))
+	if _, err := l.decodeIE(t, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// every decodes all the IEs of a type
+func every[T any, P interface {
+	*T
+	decoder
+}](l ies, t ieType) ([]T, error) {
+	var list []T
+	for _, b := range l.all(t) {
+		v := P(new(T))
+		if err := v.decode(b); err != nil {
+			return nil, fmt.Errorf("IE %d: %w", t, err)
+		}
+		list = append(list, *v)
+	}
+	return list, nil
+}
+
+// reader walks an IE value, keeping the first decoding error
+type reader struct {
+	b   []byte
+	off int
+	err error
+}
+
+// take returns the next n octets
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.off+n > len(r.b) {
+		r.err = ErrTruncated
+		return nil
+	}
+	v := r.b[r.off : r.off+n : r.off+n]
+	r.off += n
+	return v
+}
+
+// rest returns the octets left
+func (r *reader) rest() []byte {
+	return r.take(len(r.b) - r.off)
+}
+
+// uint8 reads an octet
+func (r *reader) uint8() uint8 {
+	v := r.take(1)
+	if v == nil {
+		return 0
+	}
+	return v[0]
+}
+
+// uint16 reads two octets in network order
+func (r *reader) uint16() uint16 {
+	v := r.take(2)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(v)
+}
+
+// uint24 reads three octets in network order
+func (r *reader) uint24() uint32 {
+	v := r.take(3)
+	if v == nil {
+		return 0
+	}
+	return uint32(v[0])<<16 | uint32(v[1])<<8 | uint32(v[2])
+}
+
+// uint32 reads four octets in network order
+func (r *reader) uint32() uint32 {
+	v := r.take(4)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v)
+}
+
+// uint40 reads five octets in network order
+func (r *reader) uint40() uint64 {
+	v := r.take(5)
+	if v == nil {
+		return 0
+	}
+	return uint64(v[0])<<32 | uint64(binary.BigEndian.Uint32(v[1:]))
+}
+
+// uint64 reads eight octets in network order
+func (r *reader) uint64() uint64 {
+	v := r.take(8)
+	if v == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// ipv4 reads an IPv4 address
+func (r *reader) ipv4() net.IP {
+	v := r.take(net.IPv4len)
+	if v == nil {
+		return nil
+	}
+	return net.IPv4(v[0], v[1], v[2], v[3]).To4()
+}
+
+// ipv6 reads an IPv6 address
+func (r *reader) ipv6() net.IP {
+	v := r.take(net.IPv6len)
+	if v == nil {
+		return nil
+	}
+	return append(net.IP(nil), v...)
+}
+
+// fixed decodes a value of a fixed length with read
+func fixed(b []byte, n int, read func(*reader)) error {
+	if len(b) < n {
+		return ErrTruncated
+	}
+	r := &reader{b: b}
+	read(r)
+	return r.err
+}
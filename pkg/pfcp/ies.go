@@ -0,0 +1,890 @@
+package pfcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// ieType identifies an IE (TS 29.244 8.1.2)
+type ieType uint16
+
+const (
+	ieCreatePDR                     ieType = 1
+	iePDI                           ieType = 2
+	ieCreateFAR                     ieType = 3
+	ieForwardingParameters          ieType = 4
+	ieDuplicatingParameters         ieType = 5
+	ieCreateURR                     ieType = 6
+	ieCreateQER                     ieType = 7
+	ieCreatedPDR                    ieType = 8
+	ieUpdatePDR                     ieType = 9
+	ieUpdateFAR                     ieType = 10
+	ieUpdateForwardingParameters    ieType = 11
+	ieUpdateBARReport               ieType = 12
+	ieUpdateURR                     ieType = 13
+	ieUpdateQER                     ieType = 14
+	ieRemovePDR                     ieType = 15
+	ieRemoveFAR                     ieType = 16
+	ieRemoveURR                     ieType = 17
+	ieRemoveQER                     ieType = 18
+	ieCause                         ieType = 19
+	ieSourceInterface               ieType = 20
+	ieFTEID                         ieType = 21
+	ieNetworkInstance               ieType = 22
+	ieSDFFilter                     ieType = 23
+	ieGateStatus                    ieType = 25
+	ieMBR                           ieType = 26
+	ieGBR                           ieType = 27
+	iePrecedence                    ieType = 29
+	ieVolumeThreshold               ieType = 31
+	ieTimeThreshold                 ieType = 32
+	ieReportingTriggers             ieType = 37
+	ieReportType                    ieType = 39
+	ieOffendingIE                   ieType = 40
+	ieDestinationInterface          ieType = 42
+	ieUPFunctionFeatures            ieType = 43
+	ieApplyAction                   ieType = 44
+	ieDownlinkDataNotificationDelay ieType = 46
+	ieSMReqFlags                    ieType = 49
+	ieSRRspFlags                    ieType = 50
+	iePDRID                         ieType = 56
+	ieFSEID                         ieType = 57
+	ieNodeID                        ieType = 60
+	ieMeasurementMethod             ieType = 62
+	ieUsageReportTrigger            ieType = 63
+	ieMeasurementPeriod             ieType = 64
+	ieVolumeMeasurement             ieType = 66
+	ieDurationMeasurement           ieType = 67
+	ieTimeOfFirstPacket             ieType = 69
+	ieTimeOfLastPacket              ieType = 70
+	ieVolumeQuota                   ieType = 73
+	ieTimeQuota                     ieType = 74
+	ieStartTime                     ieType = 75
+	ieEndTime                       ieType = 76
+	ieQueryURR                      ieType = 77
+	ieUsageReportModification       ieType = 78
+	ieUsageReportDeletion           ieType = 79
+	ieUsageReportReport             ieType = 80
+	ieURRID                         ieType = 81
+	ieDownlinkDataReport            ieType = 83
+	ieOuterHeaderCreation           ieType = 84
+	ieCreateBAR                     ieType = 85
+	ieUpdateBAR                     ieType = 86
+	ieRemoveBAR                     ieType = 87
+	ieBARID                         ieType = 88
+	ieCPFunctionFeatures            ieType = 89
+	ieUEIPAddress                   ieType = 93
+	ieOuterHeaderRemoval            ieType = 95
+	ieRecoveryTimeStamp             ieType = 96
+	ieErrorIndicationReport         ieType = 99
+	ieURSEQN                        ieType = 104
+	ieUpdateDuplicatingParameters   ieType = 105
+	ieFARID                         ieType = 108
+	ieQERID                         ieType = 109
+	ieAssociationReleaseRequest     ieType = 111
+	ieGracefulReleasePeriod         ieType = 112
+	iePDNType                       ieType = 113
+	ieFailedRuleID                  ieType = 114
+	ieQFI                           ieType = 124
+	ieSuggestedBufferingPackets     ieType = 140
+	ieEthernetPDUSessionInformation ieType = 142
+)
+
+// Cause is the outcome of a request (TS 29.244 8.2.1)
+type Cause uint8
+
+const (
+	CauseRequestAccepted          Cause = 1
+	CauseRequestRejected          Cause = 64
+	CauseSessionContextNotFound   Cause = 65
+	CauseMandatoryIEMissing       Cause = 66
+	CauseConditionalIEMissing     Cause = 67
+	CauseInvalidLength            Cause = 68
+	CauseMandatoryIEIncorrect     Cause = 69
+	CauseInvalidForwardingPolicy  Cause = 70
+	CauseInvalidFTEIDAllocation   Cause = 71
+	CauseNoEstablishedAssociation Cause = 72
+	CauseRuleCreationFailure      Cause = 73
+	CauseEntityInCongestion       Cause = 74
+	CauseNoResourcesAvailable     Cause = 75
+	CauseServiceNotSupported      Cause = 76
+	CauseSystemFailure            Cause = 77
+	CauseRedirectionRequested     Cause = 78
+)
+
+// String implements fmt.Stringer
+func (c Cause) String() string {
+	switch c {
+	case CauseRequestAccepted:
+		return "request accepted"
+	case CauseRequestRejected:
+		return "request rejected"
+	case CauseSessionContextNotFound:
+		return "session context not found"
+	case CauseMandatoryIEMissing:
+		return "mandatory IE missing"
+	case CauseConditionalIEMissing:
+		return "conditional IE missing"
+	case CauseInvalidLength:
+		return "invalid length"
+	case CauseMandatoryIEIncorrect:
+		return "mandatory IE incorrect"
+	case CauseInvalidForwardingPolicy:
+		return "invalid forwarding policy"
+	case CauseInvalidFTEIDAllocation:
+		return "invalid F-TEID allocation option"
+	case CauseNoEstablishedAssociation:
+		return "no established PFCP association"
+	case CauseRuleCreationFailure:
+		return "rule creation/modification failure"
+	case CauseEntityInCongestion:
+		return "PFCP entity in congestion"
+	case CauseNoResourcesAvailable:
+		return "no resources available"
+	case CauseServiceNotSupported:
+		return "service not supported"
+	case CauseSystemFailure:
+		return "system failure"
+	case CauseRedirectionRequested:
+		return "redirection requested"
+	default:
+		return fmt.Sprintf("Cause(%d)", uint8(c))
+	}
+}
+
+// Accepted reports whether the cause tells a success
+func (c Cause) Accepted() bool {
+	return c >= 1 && c < 64
+}
+
+// Interface is a source or destination interface (TS 29.244 8.2.2)
+type Interface uint8
+
+const (
+	InterfaceAccess     Interface = 0
+	InterfaceCore       Interface = 1
+	InterfaceSGiLAN     Interface = 2
+	InterfaceCPFunction Interface = 3
+)
+
+// NodeIDType is the kind of a node ID
+type NodeIDType uint8
+
+const (
+	NodeIDIPv4 NodeIDType = 0
+	NodeIDIPv6 NodeIDType = 1
+	NodeIDFQDN NodeIDType = 2
+)
+
+// NodeID identifies a PFCP entity by address or FQDN (TS 29.244 8.2.38)
+type NodeID struct {
+	Type NodeIDType
+	IP   net.IP
+	FQDN string
+}
+
+// NewNodeID returns the node ID of an IP address, or of an FQDN when s
+// is not an address
+func NewNodeID(s string) NodeID {
+	ip := net.ParseIP(s)
+	switch {
+	case ip == nil:
+		return NodeID{Type: NodeIDFQDN, FQDN: s}
+	case ip.To4() != nil:
+		return NodeID{Type: NodeIDIPv4, IP: ip.To4()}
+	default:
+		return NodeID{Type: NodeIDIPv6, IP: ip}
+	}
+}
+
+// String implements fmt.Stringer
+func (n NodeID) String() string {
+	if n.Type == NodeIDFQDN {
+		return n.FQDN
+	}
+	return n.IP.String()
+}
+
+func (n *NodeID) encode(w *writer) {
+	w.uint8(uint8(n.Type))
+	switch n.Type {
+	case NodeIDIPv4:
+		w.ipv4(n.IP)
+	case NodeIDIPv6:
+		w.ipv6(n.IP)
+	case NodeIDFQDN:
+		w.bytes(encodeFQDN(n.FQDN))
+	default:
+		w.fail("invalid node ID type %d", n.Type)
+	}
+}
+
+func (n *NodeID) decode(b []byte) error {
+	r := &reader{b: b}
+	n.Type = NodeIDType(r.uint8() & 0x0f)
+	switch n.Type {
+	case NodeIDIPv4:
+		n.IP = r.ipv4()
+	case NodeIDIPv6:
+		n.IP = r.ipv6()
+	case NodeIDFQDN:
+		n.FQDN = decodeFQDN(r.rest())
+	default:
+		return fmt.Errorf("invalid node ID type %d", n.Type)
+	}
+	return r.err
+}
+
+// encodeFQDN encodes an FQDN as DNS labels (TS 29.244 8.2.38)
+func encodeFQDN(fqdn string) []byte {
+	var b []byte
+	start := 0
+	for i := 0; i <= len(fqdn); i++ {
+		if i == len(fqdn) || fqdn[i] == '.' {
+			b = append(b, uint8(i-start))
+			b = append(b, fqdn[start:i]...)
+			start = i + 1
+		}
+	}
+	return b
+}
+
+// decodeFQDN decodes DNS labels
+func decodeFQDN(b []byte) string {
+	var s []byte
+	for len(b) > 0 {
+		n := int(b[0])
+		if n+1 > len(b) {
+			n = len(b) - 1
+		}
+		if len(s) > 0 {
+			s = append(s, '.')
+		}
+		s = append(s, b[1:1+n]...)
+		b = b[1+n:]
+	}
+	return string(s)
+}
+
+// FSEID is a session endpoint: a SEID and the address of its node (TS
+// 29.244 8.2.37)
+type FSEID struct {
+	SEID uint64
+	IPv4 net.IP
+	IPv6 net.IP
+}
+
+func (f *FSEID) encode(w *writer) {
+	var flags uint8
+	if f.IPv4 != nil {
+		flags |= 0x02
+	}
+	if f.IPv6 != nil {
+		flags |= 0x01
+	}
+	w.uint8(flags)
+	w.uint64(f.SEID)
+	if f.IPv4 != nil {
+		w.ipv4(f.IPv4)
+	}
+	if f.IPv6 != nil {
+		w.ipv6(f.IPv6)
+	}
+}
+
+func (f *FSEID) decode(b []byte) error {
+	r := &reader{b: b}
+	flags := r.uint8()
+	f.SEID = r.uint64()
+	if flags&0x02 != 0 {
+		f.IPv4 = r.ipv4()
+	}
+	if flags&0x01 != 0 {
+		f.IPv6 = r.ipv6()
+	}
+	return r.err
+}
+
+// FTEID is a GTP-U tunnel endpoint (TS 29.244 8.2.3). With Choose set
+// the UP function allocates the TEID and address, returning them in the
+// Created PDR, in the family of the unspecified address set.
+type FTEID struct {
+	TEID uint32
+	IPv4 net.IP
+	IPv6 net.IP
+
+	Choose bool
+
+	// ChooseID makes PDRs with the same ID share the allocated F-TEID,
+	// when not 0
+	ChooseID uint8
+}
+
+func (f *FTEID) encode(w *writer) {
+	var flags uint8
+	if f.IPv4 != nil {
+		flags |= 0x01
+	}
+	if f.IPv6 != nil {
+		flags |= 0x02
+	}
+	if f.Choose {
+		flags |= 0x04
+		if f.ChooseID != 0 {
+			flags |= 0x08
+		}
+	}
+	w.uint8(flags)
+	if f.Choose {
+		if f.ChooseID != 0 {
+			w.uint8(f.ChooseID)
+		}
+		return
+	}
+	w.uint32(f.TEID)
+	if f.IPv4 != nil {
+		w.ipv4(f.IPv4)
+	}
+	if f.IPv6 != nil {
+		w.ipv6(f.IPv6)
+	}
+}
+
+func (f *FTEID) decode(b []byte) error {
+	r := &reader{b: b}
+	flags := r.uint8()
+	if flags&0x04 != 0 {
+		// The address flags tell the family to allocate the address in
+		f.Choose = true
+		if flags&0x01 != 0 {
+			f.IPv4 = net.IPv4zero.To4()
+		}
+		if flags&0x02 != 0 {
+			f.IPv6 = net.IPv6zero
+		}
+		if flags&0x08 != 0 {
+			f.ChooseID = r.uint8()
+		}
+		return r.err
+	}
+	f.TEID = r.uint32()
+	if flags&0x01 != 0 {
+		f.IPv4 = r.ipv4()
+	}
+	if flags&0x02 != 0 {
+		f.IPv6 = r.ipv6()
+	}
+	return r.err
+}
+
+// UEIPAddress is the address of a UE a PDR matches (TS 29.244 8.2.62)
+type UEIPAddress struct {
+	IPv4 net.IP
+	IPv6 net.IP
+
+	// Destination tells that the address is the destination of the
+	// packets, for downlink PDRs
+	Destination bool
+
+	// IPv6PrefixLength is the length of a delegated IPv6 prefix, 0 for
+	// the default /64
+	IPv6PrefixLength uint8
+}
+
+func (u *UEIPAddress) encode(w *writer) {
+	var flags uint8
+	if u.IPv6 != nil {
+		flags |= 0x01
+	}
+	if u.IPv4 != nil {
+		flags |= 0x02
+	}
+	if u.Destination {
+		flags |= 0x04
+	}
+	if u.IPv6 != nil && u.IPv6PrefixLength != 0 {
+		flags |= 0x08
+	}
+	w.uint8(flags)
+	if u.IPv4 != nil {
+		w.ipv4(u.IPv4)
+	}
+	if u.IPv6 != nil {
+		w.ipv6(u.IPv6)
+		if u.IPv6PrefixLength != 0 {
+			w.uint8(u.IPv6PrefixLength)
+		}
+	}
+}
+
+func (u *UEIPAddress) decode(b []byte) error {
+	r := &reader{b: b}
+	flags := r.uint8()
+	u.Destination = flags&0x04 != 0
+	if flags&0x02 != 0 {
+		u.IPv4 = r.ipv4()
+	}
+	if flags&0x01 != 0 {
+		u.IPv6 = r.ipv6()
+	}
+	if flags&0x08 != 0 {
+		u.IPv6PrefixLength = r.uint8()
+	}
+	return r.err
+}
+
+// OuterHeaderCreation descriptions (TS 29.244 8.2.56)
+const (
+	OuterHeaderGTPUIPv4 uint16 = 0x0100
+	OuterHeaderGTPUIPv6 uint16 = 0x0200
+	OuterHeaderUDPIPv4  uint16 = 0x0400
+	OuterHeaderUDPIPv6  uint16 = 0x0800
+)
+
+// OuterHeaderCreation is the tunnel header a FAR adds to the packets it
+// forwards (TS 29.244 8.2.56)
+type OuterHeaderCreation struct {
+	Description uint16
+	TEID        uint32
+	IPv4        net.IP
+	IPv6        net.IP
+	Port        uint16
+}
+
+// GTPUTunnel returns the creation of a GTP-U header towards a tunnel
+// endpoint
+func GTPUTunnel(teid uint32, ip net.IP) *OuterHeaderCreation {
+	if ip.To4() != nil {
+		return &OuterHeaderCreation{Description: OuterHeaderGTPUIPv4, TEID: teid, IPv4: ip.To4()}
+	}
+	return &OuterHeaderCreation{Description: OuterHeaderGTPUIPv6, TEID: teid, IPv6: ip}
+}
+
+func (o *OuterHeaderCreation) encode(w *writer) {
+	w.uint16(o.Description)
+	if o.Description&(OuterHeaderGTPUIPv4|OuterHeaderGTPUIPv6) != 0 {
+		w.uint32(o.TEID)
+	}
+	if o.Description&(OuterHeaderGTPUIPv4|OuterHeaderUDPIPv4) != 0 {
+		w.ipv4(o.IPv4)
+	}
+	if o.Description&(OuterHeaderGTPUIPv6|OuterHeaderUDPIPv6) != 0 {
+		w.ipv6(o.IPv6)
+	}
+	if o.Description&(OuterHeaderUDPIPv4|OuterHeaderUDPIPv6) != 0 {
+		w.uint16(o.Port)
+	}
+}
+
+func (o *OuterHeaderCreation) decode(b []byte) error {
+	r := &reader{b: b}
+	o.Description = r.uint16()
+	if o.Description&(OuterHeaderGTPUIPv4|OuterHeaderGTPUIPv6) != 0 {
+		o.TEID = r.uint32()
+	}
+	if o.Description&(OuterHeaderGTPUIPv4|OuterHeaderUDPIPv4) != 0 {
+		o.IPv4 = r.ipv4()
+	}
+	if o.Description&(OuterHeaderGTPUIPv6|OuterHeaderUDPIPv6) != 0 {
+		o.IPv6 = r.ipv6()
+	}
+	if o.Description&(OuterHeaderUDPIPv4|OuterHeaderUDPIPv6) != 0 {
+		o.Port = r.uint16()
+	}
+	return r.err
+}
+
+// OuterHeaderRemoval is the tunnel header a PDR removes from the packets
+// it matches (TS 29.244 8.2.64)
+type OuterHeaderRemoval uint8
+
+const (
+	OuterHeaderRemovalGTPUIPv4 OuterHeaderRemoval = 0
+	OuterHeaderRemovalGTPUIPv6 OuterHeaderRemoval = 1
+	OuterHeaderRemovalUDPIPv4  OuterHeaderRemoval = 2
+	OuterHeaderRemovalUDPIPv6  OuterHeaderRemoval = 3
+	OuterHeaderRemovalGTPUIP   OuterHeaderRemoval = 6
+)
+
+// ApplyAction flags of a FAR (TS 29.244 8.2.26)
+type ApplyAction uint8
+
+const (
+	ApplyActionDrop      ApplyAction = 0x01
+	ApplyActionForward   ApplyAction = 0x02
+	ApplyActionBuffer    ApplyAction = 0x04
+	ApplyActionNotifyCP  ApplyAction = 0x08
+	ApplyActionDuplicate ApplyAction = 0x10
+)
+
+// GateStatus opens or closes the uplink and downlink of a QER (TS 29.244
+// 8.2.7)
+type GateStatus struct {
+	ULClosed bool
+	DLClosed bool
+}
+
+func (g *GateStatus) encode(w *writer) {
+	var v uint8
+	if g.ULClosed {
+		v |= 1 << 2
+	}
+	if g.DLClosed {
+		v |= 1
+	}
+	w.uint8(v)
+}
+
+func (g *GateStatus) decode(b []byte) error {
+	return fixed(b, 1, func(r *reader) {
+		v := r.uint8()
+		g.ULClosed, g.DLClosed = v>>2&3 != 0, v&3 != 0
+	})
+}
+
+// BitRate is an uplink and downlink bit rate in kbps, for MBRs and GBRs
+// (TS 29.244 8.2.8)
+type BitRate struct {
+	UL uint64
+	DL uint64
+}
+
+func (b *BitRate) encode(w *writer) {
+	w.uint40(b.UL)
+	w.uint40(b.DL)
+}
+
+func (b *BitRate) decode(v []byte) error {
+	return fixed(v, 10, func(r *reader) {
+		b.UL, b.DL = r.uint40(), r.uint40()
+	})
+}
+
+// Volume is an amount of traffic in octets, for thresholds, quotas and
+// measurements (TS 29.244 8.2.13)
+type Volume struct {
+	Total    *uint64
+	Uplink   *uint64
+	Downlink *uint64
+}
+
+func (v *Volume) encode(w *writer) {
+	var flags uint8
+	for i, f := range []*uint64{v.Total, v.Uplink, v.Downlink} {
+		if f != nil {
+			flags |= 1 << i
+		}
+	}
+	w.uint8(flags)
+	for _, f := range []*uint64{v.Total, v.Uplink, v.Downlink} {
+		if f != nil {
+			w.uint64(*f)
+		}
+	}
+}
+
+func (v *Volume) decode(b []byte) error {
+	r := &reader{b: b}
+	flags := r.uint8()
+	for i, f := range []**uint64{&v.Total, &v.Uplink, &v.Downlink} {
+		if flags&(1<<i) != 0 {
+			n := r.uint64()
+			*f = &n
+		}
+	}
+	return r.err
+}
+
+// ReportingTriggers tell when a URR reports (TS 29.244 8.2.19), the
+// first octet in the low bits
+type ReportingTriggers uint16
+
+const (
+	TriggerPeriodic        ReportingTriggers = 0x0001
+	TriggerVolumeThreshold ReportingTriggers = 0x0002
+	TriggerTimeThreshold   ReportingTriggers = 0x0004
+	TriggerQuotaHolding    ReportingTriggers = 0x0008
+	TriggerStartOfTraffic  ReportingTriggers = 0x0010
+	TriggerStopOfTraffic   ReportingTriggers = 0x0020
+	TriggerDroppedDL       ReportingTriggers = 0x0040
+	TriggerLinkedUsage     ReportingTriggers = 0x0080
+	TriggerVolumeQuota     ReportingTriggers = 0x0100
+	TriggerTimeQuota       ReportingTriggers = 0x0200
+)
+
+// UsageReportTrigger tells why a usage report was sent (TS 29.244
+// 8.2.41), the first octet in the low bits
+type UsageReportTrigger uint32
+
+const (
+	UsageTriggerPeriodic        UsageReportTrigger = 0x0001
+	UsageTriggerVolumeThreshold UsageReportTrigger = 0x0002
+	UsageTriggerTimeThreshold   UsageReportTrigger = 0x0004
+	UsageTriggerQuotaHolding    UsageReportTrigger = 0x0008
+	UsageTriggerStartOfTraffic  UsageReportTrigger = 0x0010
+	UsageTriggerStopOfTraffic   UsageReportTrigger = 0x0020
+	UsageTriggerDroppedDL       UsageReportTrigger = 0x0040
+	UsageTriggerImmediate       UsageReportTrigger = 0x0080
+	UsageTriggerVolumeQuota     UsageReportTrigger = 0x0100
+	UsageTriggerTimeQuota       UsageReportTrigger = 0x0200
+	UsageTriggerLinkedUsage     UsageReportTrigger = 0x0400
+	UsageTriggerTermination     UsageReportTrigger = 0x0800
+)
+
+// MeasurementMethod flags of a URR (TS 29.244 8.2.40)
+type MeasurementMethod uint8
+
+const (
+	MeasureDuration MeasurementMethod = 0x01
+	MeasureVolume   MeasurementMethod = 0x02
+	MeasureEvent    MeasurementMethod = 0x04
+)
+
+// ReportType flags of a Session Report Request (TS 29.244 8.2.21)
+type ReportType uint8
+
+const (
+	ReportDownlinkData    ReportType = 0x01
+	ReportUsage           ReportType = 0x02
+	ReportErrorIndication ReportType = 0x04
+	ReportInactivity      ReportType = 0x08
+)
+
+// PDNType is the type of a PDU session (TS 29.244 8.2.79)
+type PDNType uint8
+
+const (
+	PDNTypeIPv4     PDNType = 1
+	PDNTypeIPv6     PDNType = 2
+	PDNTypeIPv4v6   PDNType = 3
+	PDNTypeNonIP    PDNType = 4
+	PDNTypeEthernet PDNType = 5
+)
+
+// RuleType is the kind of a rule that failed (TS 29.244 8.2.80)
+type RuleType uint8
+
+const (
+	RulePDR RuleType = 0
+	RuleFAR RuleType = 1
+	RuleQER RuleType = 2
+	RuleURR RuleType = 3
+	RuleBAR RuleType = 4
+)
+
+// FailedRuleID is a rule the UP function failed to apply
+type FailedRuleID struct {
+	Type RuleType
+	ID   uint32
+}
+
+func (f *FailedRuleID) encode(w *writer) {
+	w.uint8(uint8(f.Type))
+	switch f.Type {
+	case RulePDR:
+		w.uint16(uint16(f.ID))
+	case RuleBAR:
+		w.uint8(uint8(f.ID))
+	default:
+		w.uint32(f.ID)
+	}
+}
+
+func (f *FailedRuleID) decode(b []byte) error {
+	r := &reader{b: b}
+	f.Type = RuleType(r.uint8() & 0x1f)
+	switch f.Type {
+	case RulePDR:
+		f.ID = uint32(r.uint16())
+	case RuleBAR:
+		f.ID = uint32(r.uint8())
+	default:
+		f.ID = r.uint32()
+	}
+	return r.err
+}
+
+// SDFFilter matches the packets of a service data flow (TS 29.244
+// 8.2.5). Only flow descriptions are supported.
+type SDFFilter struct {
+	// FlowDescription is an IPFilterRule of RFC 6733, e.g. "permit out
+	// ip from any to assigned"
+	FlowDescription string
+}
+
+func (s *SDFFilter) encode(w *writer) {
+	w.uint8(0x01)
+	w.uint8(0)
+	w.uint16(uint16(len(s.FlowDescription)))
+	w.bytes([]byte(s.FlowDescription))
+}
+
+func (s *SDFFilter) decode(b []byte) error {
+	r := &reader{b: b}
+	flags := r.uint8()
+	r.uint8()
+	if flags&0x01 != 0 {
+		s.FlowDescription = string(r.take(int(r.uint16())))
+	}
+	return r.err
+}
+
+// ntpEpoch is the NTP time of the Unix epoch, in seconds since 1900
+const ntpEpoch = 2208988800
+
+// timestamp is a time in NTP seconds, as in Recovery Time Stamp and
+// Start Time IEs (TS 29.244 8.2.65)
+type timestamp time.Time
+
+func (t *timestamp) encode(w *writer) {
+	w.uint32(uint32(time.Time(*t).Unix() + ntpEpoch))
+}
+
+func (t *timestamp) decode(b []byte) error {
+	return fixed(b, 4, func(r *reader) {
+		*t = timestamp(time.Unix(int64(r.uint32())-ntpEpoch, 0))
+	})
+}
+
+// optTime returns the time of a timestamp IE, zero when absent
+func optTime(l ies, t ieType) (time.Time, error) {
+	var ts timestamp
+	ok, err := l.decodeIE(t, &ts)
+	if !ok || err != nil {
+		return time.Time{}, err
+	}
+	return time.Time(ts), nil
+}
+
+// timeIE writes a timestamp IE when t is not zero
+func (w *writer) timeIE(typ ieType, t time.Time) {
+	if !t.IsZero() {
+		ts := timestamp(t)
+		w.valueIE(typ, &ts)
+	}
+}
+
+// uint8Of decodes the value of a one octet IE
+func uint8Of(b []byte) (uint8, error) {
+	var v uint8
+	err := fixed(b, 1, func(r *reader) { v = r.uint8() })
+	return v, err
+}
+
+// uint16Of decodes the value of a two octet IE
+func uint16Of(b []byte) (uint16, error) {
+	var v uint16
+	err := fixed(b, 2, func(r *reader) { v = r.uint16() })
+	return v, err
+}
+
+// uint32Of decodes the value of a four octet IE
+func uint32Of(b []byte) (uint32, error) {
+	var v uint32
+	err := fixed(b, 4, func(r *reader) { v = r.uint32() })
+	return v, err
+}
+
+// mandatoryUint8 returns the value of a one octet IE, failing when
+// absent
+func (l ies) mandatoryUint8(t ieType) (uint8, error) {
+	if !l.has(t) {
+		return 0, fmt.Errorf("missing mandatory IE %d", t)
+	}
+	v, err := uint8Of(l.find(t))
+	if err != nil {
+		return 0, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return v, nil
+}
+
+// mandatoryUint16 returns the value of a two octet IE, failing when
+// absent
+func (l ies) mandatoryUint16(t ieType) (uint16, error) {
+	if !l.has(t) {
+		return 0, fmt.Errorf("missing mandatory IE %d", t)
+	}
+	v, err := uint16Of(l.find(t))
+	if err != nil {
+		return 0, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return v, nil
+}
+
+// mandatoryUint32 returns the value of a four octet IE, failing when
+// absent
+func (l ies) mandatoryUint32(t ieType) (uint32, error) {
+	if !l.has(t) {
+		return 0, fmt.Errorf("missing mandatory IE %d", t)
+	}
+	v, err := uint32Of(l.find(t))
+	if err != nil {
+		return 0, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return v, nil
+}
+
+// optUint8 returns the value of a one octet IE, nil when absent
+func (l ies) optUint8(t ieType) (*uint8, error) {
+	if !l.has(t) {
+		return nil, nil
+	}
+	v, err := uint8Of(l.find(t))
+	if err != nil {
+		return nil, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return &v, nil
+}
+
+// optUint32 returns the value of a four octet IE, nil when absent
+func (l ies) optUint32(t ieType) (*uint32, error) {
+	if !l.has(t) {
+		return nil, nil
+	}
+	v, err := uint32Of(l.find(t))
+	if err != nil {
+		return nil, fmt.Errorf("IE %d: %w", t, err)
+	}
+	return &v, nil
+}
+
+// allUint32 returns the values of the four octet IEs of a type
+func (l ies) allUint32(t ieType) ([]uint32, error) {
+	var list []uint32
+	for _, b := range l.all(t) {
+		v, err := uint32Of(b)
+		if err != nil {
+			return nil, fmt.Errorf("IE %d: %w", t, err)
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// allUint8 returns the values of the one octet IEs of a type
+func (l ies) allUint8(t ieType) ([]uint8, error) {
+	var list []uint8
+	for _, b := range l.all(t) {
+		v, err := uint8Of(b)
+		if err != nil {
+			return nil, fmt.Errorf("IE %d: %w", t, err)
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+// optUint8IE writes a one octet IE when v is not nil
+func (w *writer) optUint8IE(t ieType, v *uint8) {
+	if v != nil {
+		w.uint8IE(t, *v)
+	}
+}
+
+// optUint32IE writes a four octet IE when v is not nil
+func (w *writer) optUint32IE(t ieType, v *uint32) {
+	if v != nil {
+		w.uint32IE(t, *v)
+	}
+}
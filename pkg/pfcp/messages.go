@@ -0,0 +1,735 @@
+package pfcp
+
+import (
+	"time"
+)
+
+func init() {
+	register(func() Message { return &HeartbeatRequest{} })
+	register(func() Message { return &HeartbeatResponse{} })
+	register(func() Message { return &AssociationSetupRequest{} })
+	register(func() Message { return &AssociationSetupResponse{} })
+	register(func() Message { return &AssociationUpdateRequest{} })
+	register(func() Message { return &AssociationUpdateResponse{} })
+	register(func() Message { return &AssociationReleaseRequest{} })
+	register(func() Message { return &AssociationReleaseResponse{} })
+	register(func() Message { return &SessionEstablishmentRequest{} })
+	register(func() Message { return &SessionEstablishmentResponse{} })
+	register(func() Message { return &SessionModificationRequest{} })
+	register(func() Message { return &SessionModificationResponse{} })
+	register(func() Message { return &SessionDeletionRequest{} })
+	register(func() Message { return &SessionDeletionResponse{} })
+	register(func() Message { return &SessionReportRequest{} })
+	register(func() Message { return &SessionReportResponse{} })
+}
+
+// HeartbeatRequest checks that the peer is alive (TS 29.244 7.4.2.1)
+type HeartbeatRequest struct {
+	RecoveryTimeStamp time.Time
+}
+
+// MessageType implements Message
+func (*HeartbeatRequest) MessageType() MessageType { return MsgHeartbeatRequest }
+
+func (m *HeartbeatRequest) encodeBody(w *writer) {
+	w.timeIE(ieRecoveryTimeStamp, m.RecoveryTimeStamp)
+}
+
+func (m *HeartbeatRequest) decodeBody(l ies) (err error) {
+	m.RecoveryTimeStamp, err = optTime(l, ieRecoveryTimeStamp)
+	return err
+}
+
+// HeartbeatResponse answers a HeartbeatRequest (TS 29.244 7.4.2.2). A
+// new recovery time stamp tells that the peer restarted.
+type HeartbeatResponse struct {
+	RecoveryTimeStamp time.Time
+}
+
+// MessageType implements Message
+func (*HeartbeatResponse) MessageType() MessageType { return MsgHeartbeatResponse }
+
+func (m *HeartbeatResponse) encodeBody(w *writer) {
+	w.timeIE(ieRecoveryTimeStamp, m.RecoveryTimeStamp)
+}
+
+func (m *HeartbeatResponse) decodeBody(l ies) (err error) {
+	m.RecoveryTimeStamp, err = optTime(l, ieRecoveryTimeStamp)
+	return err
+}
+
+// AssociationSetupRequest sets up the association between a CP and a
+// UP function (TS 29.244 7.4.4.1). The features are left undecoded.
+type AssociationSetupRequest struct {
+	NodeID             NodeID
+	RecoveryTimeStamp  time.Time
+	UPFunctionFeatures []byte
+	CPFunctionFeatures []byte
+}
+
+// MessageType implements Message
+func (*AssociationSetupRequest) MessageType() MessageType { return MsgAssociationSetupRequest }
+
+func (m *AssociationSetupRequest) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.timeIE(ieRecoveryTimeStamp, m.RecoveryTimeStamp)
+	w.rawIE(ieUPFunctionFeatures, m.UPFunctionFeatures)
+	w.rawIE(ieCPFunctionFeatures, m.CPFunctionFeatures)
+}
+
+func (m *AssociationSetupRequest) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	m.UPFunctionFeatures = l.find(ieUPFunctionFeatures)
+	m.CPFunctionFeatures = l.find(ieCPFunctionFeatures)
+	m.RecoveryTimeStamp, err = optTime(l, ieRecoveryTimeStamp)
+	return err
+}
+
+// AssociationSetupResponse answers an AssociationSetupRequest (TS 29.244
+// 7.4.4.2)
+type AssociationSetupResponse struct {
+	NodeID             NodeID
+	Cause              Cause
+	RecoveryTimeStamp  time.Time
+	UPFunctionFeatures []byte
+	CPFunctionFeatures []byte
+}
+
+// MessageType implements Message
+func (*AssociationSetupResponse) MessageType() MessageType { return MsgAssociationSetupResponse }
+
+func (m *AssociationSetupResponse) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.uint8IE(ieCause, uint8(m.Cause))
+	w.timeIE(ieRecoveryTimeStamp, m.RecoveryTimeStamp)
+	w.rawIE(ieUPFunctionFeatures, m.UPFunctionFeatures)
+	w.rawIE(ieCPFunctionFeatures, m.CPFunctionFeatures)
+}
+
+func (m *AssociationSetupResponse) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	if m.Cause, err = l.cause(); err != nil {
+		return err
+	}
+	m.UPFunctionFeatures = l.find(ieUPFunctionFeatures)
+	m.CPFunctionFeatures = l.find(ieCPFunctionFeatures)
+	m.RecoveryTimeStamp, err = optTime(l, ieRecoveryTimeStamp)
+	return err
+}
+
+// AssociationUpdateRequest changes the features of an association or
+// asks the peer to release it (TS 29.244 7.4.4.3)
+type AssociationUpdateRequest struct {
+	NodeID             NodeID
+	UPFunctionFeatures []byte
+	CPFunctionFeatures []byte
+
+	// ReleaseRequest asks the CP function to release the association,
+	// from a UP function only
+	ReleaseRequest bool
+
+	// GracefulReleasePeriod is how long the sessions are kept before
+	// the release, rounded to the second
+	GracefulReleasePeriod time.Duration
+}
+
+// MessageType implements Message
+func (*AssociationUpdateRequest) MessageType() MessageType { return MsgAssociationUpdateRequest }
+
+func (m *AssociationUpdateRequest) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.rawIE(ieUPFunctionFeatures, m.UPFunctionFeatures)
+	w.rawIE(ieCPFunctionFeatures, m.CPFunctionFeatures)
+	if m.ReleaseRequest {
+		w.uint8IE(ieAssociationReleaseRequest, 0x01)
+	}
+	if m.GracefulReleasePeriod > 0 {
+		w.uint8IE(ieGracefulReleasePeriod, timerValue(m.GracefulReleasePeriod))
+	}
+}
+
+func (m *AssociationUpdateRequest) decodeBody(l ies) error {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	m.UPFunctionFeatures = l.find(ieUPFunctionFeatures)
+	m.CPFunctionFeatures = l.find(ieCPFunctionFeatures)
+	flags, err := l.optUint8(ieAssociationReleaseRequest)
+	if err != nil {
+		return err
+	}
+	m.ReleaseRequest = flags != nil && *flags&0x01 != 0
+	period, err := l.optUint8(ieGracefulReleasePeriod)
+	if period != nil {
+		m.GracefulReleasePeriod = timerDuration(*period)
+	}
+	return err
+}
+
+// AssociationUpdateResponse answers an AssociationUpdateRequest (TS
+// 29.244 7.4.4.4)
+type AssociationUpdateResponse struct {
+	NodeID             NodeID
+	Cause              Cause
+	UPFunctionFeatures []byte
+	CPFunctionFeatures []byte
+}
+
+// MessageType implements Message
+func (*AssociationUpdateResponse) MessageType() MessageType { return MsgAssociationUpdateResponse }
+
+func (m *AssociationUpdateResponse) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.uint8IE(ieCause, uint8(m.Cause))
+	w.rawIE(ieUPFunctionFeatures, m.UPFunctionFeatures)
+	w.rawIE(ieCPFunctionFeatures, m.CPFunctionFeatures)
+}
+
+func (m *AssociationUpdateResponse) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	m.UPFunctionFeatures = l.find(ieUPFunctionFeatures)
+	m.CPFunctionFeatures = l.find(ieCPFunctionFeatures)
+	m.Cause, err = l.cause()
+	return err
+}
+
+// AssociationReleaseRequest releases an association, with all its
+// sessions (TS 29.244 7.4.4.5)
+type AssociationReleaseRequest struct {
+	NodeID NodeID
+}
+
+// MessageType implements Message
+func (*AssociationReleaseRequest) MessageType() MessageType { return MsgAssociationReleaseRequest }
+
+func (m *AssociationReleaseRequest) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+}
+
+func (m *AssociationReleaseRequest) decodeBody(l ies) error {
+	return l.mandatory(ieNodeID, &m.NodeID)
+}
+
+// AssociationReleaseResponse answers an AssociationReleaseRequest (TS
+// 29.244 7.4.4.6)
+type AssociationReleaseResponse struct {
+	NodeID NodeID
+	Cause  Cause
+}
+
+// MessageType implements Message
+func (*AssociationReleaseResponse) MessageType() MessageType { return MsgAssociationReleaseResponse }
+
+func (m *AssociationReleaseResponse) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.uint8IE(ieCause, uint8(m.Cause))
+}
+
+func (m *AssociationReleaseResponse) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	m.Cause, err = l.cause()
+	return err
+}
+
+// SessionEstablishmentRequest creates a session in a UP function, with
+// its rules (TS 29.244 7.5.2). Its header SEID is 0.
+type SessionEstablishmentRequest struct {
+	NodeID NodeID
+
+	// CPFSEID is the session in the CP function, the SEID the UP
+	// function sends its session messages to
+	CPFSEID FSEID
+
+	CreatePDRs []CreatePDR
+	CreateFARs []CreateFAR
+	CreateURRs []URR
+	CreateQERs []QER
+	CreateBAR  *BAR
+	PDNType    PDNType
+}
+
+// MessageType implements Message
+func (*SessionEstablishmentRequest) MessageType() MessageType {
+	return MsgSessionEstablishmentRequest
+}
+
+func (m *SessionEstablishmentRequest) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.valueIE(ieFSEID, &m.CPFSEID)
+	for i := range m.CreatePDRs {
+		w.valueIE(ieCreatePDR, &m.CreatePDRs[i])
+	}
+	for i := range m.CreateFARs {
+		w.valueIE(ieCreateFAR, &m.CreateFARs[i])
+	}
+	for i := range m.CreateURRs {
+		w.valueIE(ieCreateURR, &m.CreateURRs[i])
+	}
+	for i := range m.CreateQERs {
+		w.valueIE(ieCreateQER, &m.CreateQERs[i])
+	}
+	optIE(w, ieCreateBAR, m.CreateBAR)
+	if m.PDNType != 0 {
+		w.uint8IE(iePDNType, uint8(m.PDNType))
+	}
+}
+
+func (m *SessionEstablishmentRequest) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	if err := l.mandatory(ieFSEID, &m.CPFSEID); err != nil {
+		return err
+	}
+	if m.CreatePDRs, err = every[CreatePDR](l, ieCreatePDR); err != nil {
+		return err
+	}
+	if m.CreateFARs, err = every[CreateFAR](l, ieCreateFAR); err != nil {
+		return err
+	}
+	if m.CreateURRs, err = every[URR](l, ieCreateURR); err != nil {
+		return err
+	}
+	if m.CreateQERs, err = every[QER](l, ieCreateQER); err != nil {
+		return err
+	}
+	if m.CreateBAR, err = optional[BAR](l, ieCreateBAR); err != nil {
+		return err
+	}
+	t, err := l.optUint8(iePDNType)
+	if t != nil {
+		m.PDNType = PDNType(*t & 0x0f)
+	}
+	return err
+}
+
+// SessionEstablishmentResponse answers a SessionEstablishmentRequest
+// (TS 29.244 7.5.3)
+type SessionEstablishmentResponse struct {
+	NodeID NodeID
+	Cause  Cause
+
+	// OffendingIE is the type of the IE that made the request fail
+	OffendingIE uint16
+
+	// UPFSEID is the session in the UP function, when accepted
+	UPFSEID *FSEID
+
+	CreatedPDRs  []CreatedPDR
+	FailedRuleID *FailedRuleID
+}
+
+// MessageType implements Message
+func (*SessionEstablishmentResponse) MessageType() MessageType {
+	return MsgSessionEstablishmentResponse
+}
+
+func (m *SessionEstablishmentResponse) encodeBody(w *writer) {
+	w.valueIE(ieNodeID, &m.NodeID)
+	w.uint8IE(ieCause, uint8(m.Cause))
+	if m.OffendingIE != 0 {
+		w.uint16IE(ieOffendingIE, m.OffendingIE)
+	}
+	optIE(w, ieFSEID, m.UPFSEID)
+	for i := range m.CreatedPDRs {
+		w.valueIE(ieCreatedPDR, &m.CreatedPDRs[i])
+	}
+	optIE(w, ieFailedRuleID, m.FailedRuleID)
+}
+
+func (m *SessionEstablishmentResponse) decodeBody(l ies) (err error) {
+	if err := l.mandatory(ieNodeID, &m.NodeID); err != nil {
+		return err
+	}
+	if m.Cause, err = l.cause(); err != nil {
+		return err
+	}
+	if m.OffendingIE, err = l.offendingIE(); err != nil {
+		return err
+	}
+	if m.UPFSEID, err = optional[FSEID](l, ieFSEID); err != nil {
+		return err
+	}
+	if m.CreatedPDRs, err = every[CreatedPDR](l, ieCreatedPDR); err != nil {
+		return err
+	}
+	m.FailedRuleID, err = optional[FailedRuleID](l, ieFailedRuleID)
+	return err
+}
+
+// SessionModificationRequest changes the rules of a session (TS 29.244
+// 7.5.4). Rules are removed by ID.
+type SessionModificationRequest struct {
+	// CPFSEID changes the session in the CP function, when not nil
+	CPFSEID *FSEID
+
+	RemovePDRs []uint16
+	RemoveFARs []uint32
+	RemoveURRs []uint32
+	RemoveQERs []uint32
+	RemoveBAR  *uint8
+
+	CreatePDRs []CreatePDR
+	CreateFARs []CreateFAR
+	CreateURRs []URR
+	CreateQERs []QER
+	CreateBAR  *BAR
+
+	UpdatePDRs []UpdatePDR
+	UpdateFARs []UpdateFAR
+	UpdateURRs []URR
+	UpdateQERs []QER
+	UpdateBAR  *BAR
+
+	// QueryURRs asks for an immediate usage report of the URRs
+	QueryURRs []uint32
+}
+
+// MessageType implements Message
+func (*SessionModificationRequest) MessageType() MessageType {
+	return MsgSessionModificationRequest
+}
+
+func (m *SessionModificationRequest) encodeBody(w *writer) {
+	optIE(w, ieFSEID, m.CPFSEID)
+	pdrs := make([]uint32, len(m.RemovePDRs))
+	for i, id := range m.RemovePDRs {
+		pdrs[i] = uint32(id)
+	}
+	w.idsIE(ieRemovePDR, iePDRID, pdrs)
+	w.idsIE(ieRemoveFAR, ieFARID, m.RemoveFARs)
+	w.idsIE(ieRemoveURR, ieURRID, m.RemoveURRs)
+	w.idsIE(ieRemoveQER, ieQERID, m.RemoveQERs)
+	if m.RemoveBAR != nil {
+		w.idsIE(ieRemoveBAR, ieBARID, []uint32{uint32(*m.RemoveBAR)})
+	}
+
+	for i := range m.CreatePDRs {
+		w.valueIE(ieCreatePDR, &m.CreatePDRs[i])
+	}
+	for i := range m.CreateFARs {
+		w.valueIE(ieCreateFAR, &m.CreateFARs[i])
+	}
+	for i := range m.CreateURRs {
+		w.valueIE(ieCreateURR, &m.CreateURRs[i])
+	}
+	for i := range m.CreateQERs {
+		w.valueIE(ieCreateQER, &m.CreateQERs[i])
+	}
+	optIE(w, ieCreateBAR, m.CreateBAR)
+
+	for i := range m.UpdatePDRs {
+		w.valueIE(ieUpdatePDR, &m.UpdatePDRs[i])
+	}
+	for i := range m.UpdateFARs {
+		w.valueIE(ieUpdateFAR, &m.UpdateFARs[i])
+	}
+	for i := range m.UpdateURRs {
+		w.valueIE(ieUpdateURR, &m.UpdateURRs[i])
+	}
+	for i := range m.UpdateQERs {
+		w.valueIE(ieUpdateQER, &m.UpdateQERs[i])
+	}
+	optIE(w, ieUpdateBAR, m.UpdateBAR)
+	w.idsIE(ieQueryURR, ieURRID, m.QueryURRs)
+}
+
+func (m *SessionModificationRequest) decodeBody(l ies) (err error) {
+	if m.CPFSEID, err = optional[FSEID](l, ieFSEID); err != nil {
+		return err
+	}
+	pdrs, err := l.ids(ieRemovePDR, iePDRID)
+	if err != nil {
+		return err
+	}
+	for _, id := range pdrs {
+		m.RemovePDRs = append(m.RemovePDRs, uint16(id))
+	}
+	if m.RemoveFARs, err = l.ids(ieRemoveFAR, ieFARID); err != nil {
+		return err
+	}
+	if m.RemoveURRs, err = l.ids(ieRemoveURR, ieURRID); err != nil {
+		return err
+	}
+	if m.RemoveQERs, err = l.ids(ieRemoveQER, ieQERID); err != nil {
+		return err
+	}
+	bars, err := l.ids(ieRemoveBAR, ieBARID)
+	if err != nil {
+		return err
+	}
+	if len(bars) > 0 {
+		id := uint8(bars[0])
+		m.RemoveBAR = &id
+	}
+
+	if m.CreatePDRs, err = every[CreatePDR](l, ieCreatePDR); err != nil {
+		return err
+	}
+	if m.CreateFARs, err = every[CreateFAR](l, ieCreateFAR); err != nil {
+		return err
+	}
+	if m.CreateURRs, err = every[URR](l, ieCreateURR); err != nil {
+		return err
+	}
+	if m.CreateQERs, err = every[QER](l, ieCreateQER); err != nil {
+		return err
+	}
+	if m.CreateBAR, err = optional[BAR](l, ieCreateBAR); err != nil {
+		return err
+	}
+
+	if m.UpdatePDRs, err = every[UpdatePDR](l, ieUpdatePDR); err != nil {
+		return err
+	}
+	if m.UpdateFARs, err = every[UpdateFAR](l, ieUpdateFAR); err != nil {
+		return err
+	}
+	if m.UpdateURRs, err = every[URR](l, ieUpdateURR); err != nil {
+		return err
+	}
+	if m.UpdateQERs, err = every[QER](l, ieUpdateQER); err != nil {
+		return err
+	}
+	if m.UpdateBAR, err = optional[BAR](l, ieUpdateBAR); err != nil {
+		return err
+	}
+	m.QueryURRs, err = l.ids(ieQueryURR, ieURRID)
+	return err
+}
+
+// SessionModificationResponse answers a SessionModificationRequest (TS
+// 29.244 7.5.5)
+type SessionModificationResponse struct {
+	Cause        Cause
+	OffendingIE  uint16
+	CreatedPDRs  []CreatedPDR
+	UsageReports []UsageReport
+	FailedRuleID *FailedRuleID
+}
+
+// MessageType implements Message
+func (*SessionModificationResponse) MessageType() MessageType {
+	return MsgSessionModificationResponse
+}
+
+func (m *SessionModificationResponse) encodeBody(w *writer) {
+	w.uint8IE(ieCause, uint8(m.Cause))
+	if m.OffendingIE != 0 {
+		w.uint16IE(ieOffendingIE, m.OffendingIE)
+	}
+	for i := range m.CreatedPDRs {
+		w.valueIE(ieCreatedPDR, &m.CreatedPDRs[i])
+	}
+	for i := range m.UsageReports {
+		w.valueIE(ieUsageReportModification, &m.UsageReports[i])
+	}
+	optIE(w, ieFailedRuleID, m.FailedRuleID)
+}
+
+func (m *SessionModificationResponse) decodeBody(l ies) (err error) {
+	if m.Cause, err = l.cause(); err != nil {
+		return err
+	}
+	if m.OffendingIE, err = l.offendingIE(); err != nil {
+		return err
+	}
+	if m.CreatedPDRs, err = every[CreatedPDR](l, ieCreatedPDR); err != nil {
+		return err
+	}
+	if m.UsageReports, err = every[UsageReport](l, ieUsageReportModification); err != nil {
+		return err
+	}
+	m.FailedRuleID, err = optional[FailedRuleID](l, ieFailedRuleID)
+	return err
+}
+
+// SessionDeletionRequest deletes a session (TS 29.244 7.5.6)
+type SessionDeletionRequest struct{}
+
+// MessageType implements Message
+func (*SessionDeletionRequest) MessageType() MessageType { return MsgSessionDeletionRequest }
+
+func (*SessionDeletionRequest) encodeBody(*writer) {}
+
+func (*SessionDeletionRequest) decodeBody(ies) error { return nil }
+
+// SessionDeletionResponse answers a SessionDeletionRequest with the
+// final usage of the session (TS 29.244 7.5.7)
+type SessionDeletionResponse struct {
+	Cause        Cause
+	OffendingIE  uint16
+	UsageReports []UsageReport
+}
+
+// MessageType implements Message
+func (*SessionDeletionResponse) MessageType() MessageType { return MsgSessionDeletionResponse }
+
+func (m *SessionDeletionResponse) encodeBody(w *writer) {
+	w.uint8IE(ieCause, uint8(m.Cause))
+	if m.OffendingIE != 0 {
+		w.uint16IE(ieOffendingIE, m.OffendingIE)
+	}
+	for i := range m.UsageReports {
+		w.valueIE(ieUsageReportDeletion, &m.UsageReports[i])
+	}
+}
+
+func (m *SessionDeletionResponse) decodeBody(l ies) (err error) {
+	if m.Cause, err = l.cause(); err != nil {
+		return err
+	}
+	if m.OffendingIE, err = l.offendingIE(); err != nil {
+		return err
+	}
+	m.UsageReports, err = every[UsageReport](l, ieUsageReportDeletion)
+	return err
+}
+
+// SessionReportRequest is sent by a UP function to report buffered
+// downlink data, usage or error indications (TS 29.244 7.5.8)
+type SessionReportRequest struct {
+	ReportType            ReportType
+	DownlinkDataReport    *DownlinkDataReport
+	UsageReports          []UsageReport
+	ErrorIndicationReport *ErrorIndicationReport
+}
+
+// MessageType implements Message
+func (*SessionReportRequest) MessageType() MessageType { return MsgSessionReportRequest }
+
+func (m *SessionReportRequest) encodeBody(w *writer) {
+	w.uint8IE(ieReportType, uint8(m.ReportType))
+	optIE(w, ieDownlinkDataReport, m.DownlinkDataReport)
+	for i := range m.UsageReports {
+		w.valueIE(ieUsageReportReport, &m.UsageReports[i])
+	}
+	optIE(w, ieErrorIndicationReport, m.ErrorIndicationReport)
+}
+
+func (m *SessionReportRequest) decodeBody(l ies) (err error) {
+	t, err := l.mandatoryUint8(ieReportType)
+	if err != nil {
+		return err
+	}
+	m.ReportType = ReportType(t)
+	if m.DownlinkDataReport, err = optional[DownlinkDataReport](l, ieDownlinkDataReport); err != nil {
+		return err
+	}
+	if m.UsageReports, err = every[UsageReport](l, ieUsageReportReport); err != nil {
+		return err
+	}
+	m.ErrorIndicationReport, err = optional[ErrorIndicationReport](l, ieErrorIndicationReport)
+	return err
+}
+
+// SessionReportResponse answers a SessionReportRequest (TS 29.244
+// 7.5.9)
+type SessionReportResponse struct {
+	Cause       Cause
+	OffendingIE uint16
+	UpdateBAR   *BAR
+
+	// DropBufferedPackets asks the UP function to drop the packets it
+	// buffered for the session
+	DropBufferedPackets bool
+}
+
+// MessageType implements Message
+func (*SessionReportResponse) MessageType() MessageType { return MsgSessionReportResponse }
+
+func (m *SessionReportResponse) encodeBody(w *writer) {
+	w.uint8IE(ieCause, uint8(m.Cause))
+	if m.OffendingIE != 0 {
+		w.uint16IE(ieOffendingIE, m.OffendingIE)
+	}
+	optIE(w, ieUpdateBARReport, m.UpdateBAR)
+	if m.DropBufferedPackets {
+		w.uint8IE(ieSRRspFlags, 0x01)
+	}
+}
+
+func (m *SessionReportResponse) decodeBody(l ies) (err error) {
+	if m.Cause, err = l.cause(); err != nil {
+		return err
+	}
+	if m.OffendingIE, err = l.offendingIE(); err != nil {
+		return err
+	}
+	if m.UpdateBAR, err = optional[BAR](l, ieUpdateBARReport); err != nil {
+		return err
+	}
+	flags, err := l.optUint8(ieSRRspFlags)
+	m.DropBufferedPackets = flags != nil && *flags&0x01 != 0
+	return err
+}
+
+// cause decodes the mandatory Cause IE of a response
+func (l ies) cause() (Cause, error) {
+	c, err := l.mandatoryUint8(ieCause)
+	return Cause(c), err
+}
+
+// offendingIE decodes the Offending IE IE of a response, 0 when absent
+func (l ies) offendingIE() (uint16, error) {
+	b := l.find(ieOffendingIE)
+	if b == nil {
+		return 0, nil
+	}
+	return uint16Of(b)
+}
+
+// rawIE writes an IE of undecoded octets when there are some
+func (w *writer) rawIE(t ieType, v []byte) {
+	if len(v) > 0 {
+		w.ie(t, func(w *writer) { w.bytes(v) })
+	}
+}
+
+// timerValue encodes a duration as a GPRS timer (TS 29.244 8.2.82)
+func timerValue(d time.Duration) uint8 {
+	s := int64((d + time.Second - 1) / time.Second)
+	switch {
+	case s <= 31*2:
+		return uint8((s + 1) / 2)
+	case s <= 31*60:
+		return 0x20 | uint8((s+59)/60)
+	case s <= 31*600:
+		return 0x40 | uint8((s+599)/600)
+	case s <= 31*3600:
+		return 0x60 | uint8((s+3599)/3600)
+	default:
+		h := (s + 35999) / 36000
+		if h > 31 {
+			h = 31
+		}
+		return 0x80 | uint8(h)
+	}
+}
+
+// timerDuration decodes a GPRS timer (TS 29.244 8.2.82)
+func timerDuration(v uint8) time.Duration {
+	n := time.Duration(v & 0x1f)
+	switch v >> 5 {
+	case 0:
+		return n * 2 * time.Second
+	case 1:
+		return n * time.Minute
+	case 2:
+		return n * 10 * time.Minute
+	case 3:
+		return n * time.Hour
+	case 4:
+		return n * 10 * time.Hour
+	default:
+		return 0
+	}
+}
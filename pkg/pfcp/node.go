@@ -0,0 +1,353 @@
+package pfcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default request timers (TS 29.244 6.4)
+const (
+	// DefaultT1 is how long a response is waited for before the request
+	// is sent again
+	DefaultT1 = 3 * time.Second
+
+	// DefaultN1 is how many times a request is sent again before giving
+	// up
+	DefaultN1 = 3
+)
+
+// maxSequenceNumber is the largest 24 bit sequence number
+const maxSequenceNumber = 1<<24 - 1
+
+var (
+	// ErrTimeout is returned when a peer did not answer a request
+	ErrTimeout = errors.New("pfcp: request timed out")
+
+	// ErrClosed is returned for requests of a closed node
+	ErrClosed = errors.New("pfcp: node closed")
+)
+
+// Request is a request received from a peer
+type Request struct {
+	Peer    *net.UDPAddr
+	Header  Header
+	Message Message
+}
+
+// Handler answers the requests of the peers, returning the response and
+// the SEID of its header: the session of the peer. A nil response drops
+// the request. Handlers run concurrently.
+type Handler func(req *Request) (rsp Message, seid uint64)
+
+// NodeConfig configures a Node
+type NodeConfig struct {
+	// Address is the local UDP address, host:port
+	Address string
+
+	// T1 and N1 are the request timers, DefaultT1 and DefaultN1 when 0
+	T1 time.Duration
+	N1 int
+
+	// Handler answers the requests other than heartbeats, which the node
+	// answers itself. Requests are dropped when nil.
+	Handler Handler
+
+	// RecoveryTimeStamp is when the node started, now when zero
+	RecoveryTimeStamp time.Time
+}
+
+// Node is a PFCP entity sending and answering requests over UDP. Lost
+// requests are sent again every T1 up to N1 times. Requests sent again
+// by a peer are answered with the response already sent, without
+// calling the handler again.
+type Node struct {
+	conn     *net.UDPConn
+	t1       time.Duration
+	n1       int
+	handler  Handler
+	recovery time.Time
+
+	mu       sync.Mutex
+	seq      uint32
+	pending  map[uint32]chan *received
+	answered map[answerKey]*answer
+	prunedAt time.Time
+
+	closed chan struct{}
+	done   chan struct{}
+}
+
+// received is a message read from a peer
+type received struct {
+	peer   *net.UDPAddr
+	header Header
+	msg    Message
+}
+
+// answerKey identifies a request of a peer
+type answerKey struct {
+	peer string
+	seq  uint32
+}
+
+// answer is the response to a request of a peer, nil while the handler
+// runs
+type answer struct {
+	b  []byte
+	at time.Time
+}
+
+// Listen creates a node listening on the configured address
+func Listen(cfg NodeConfig) (*Node, error) {
+	addr, err := net.ResolveUDPAddr("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("pfcp: resolving %s: %w", cfg.Address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("pfcp: listening on %s: %w", cfg.Address, err)
+	}
+
+	n := &Node{
+		conn:     conn,
+		t1:       cfg.T1,
+		n1:       cfg.N1,
+		handler:  cfg.Handler,
+		recovery: cfg.RecoveryTimeStamp,
+		pending:  make(map[uint32]chan *received),
+		answered: make(map[answerKey]*answer),
+		closed:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if n.t1 <= 0 {
+		n.t1 = DefaultT1
+	}
+	if n.n1 <= 0 {
+		n.n1 = DefaultN1
+	}
+	if n.recovery.IsZero() {
+		n.recovery = time.Now()
+	}
+	go n.read()
+	return n, nil
+}
+
+// LocalAddr returns the address the node listens on
+func (n *Node) LocalAddr() *net.UDPAddr {
+	return n.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// RecoveryTimeStamp returns when the node started
+func (n *Node) RecoveryTimeStamp() time.Time {
+	return n.recovery
+}
+
+// Close stops the node, failing the requests waiting for a response
+func (n *Node) Close() error {
+	select {
+	case <-n.closed:
+		return nil
+	default:
+	}
+	close(n.closed)
+	err := n.conn.Close()
+	<-n.done
+	return err
+}
+
+// Request sends a request to a peer, host:port, and waits for its
+// response. seid is the session of the peer, for session messages.
+func (n *Node) Request(ctx context.Context, peer string, seid uint64, req Message) (Message, error) {
+	if !req.MessageType().IsRequest() {
+		return nil, fmt.Errorf("pfcp: %s is not a request", req.MessageType())
+	}
+	addr, err := net.ResolveUDPAddr("udp", peer)
+	if err != nil {
+		return nil, fmt.Errorf("pfcp: resolving %s: %w", peer, err)
+	}
+
+	seq, ch := n.register()
+	defer n.unregister(seq)
+	b, err := Encode(Header{SEID: seid, SequenceNumber: seq}, req)
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.NewTimer(n.t1)
+	defer t.Stop()
+	for sent := 0; ; sent++ {
+		if sent > n.n1 {
+			return nil, fmt.Errorf("%w: %s to %s", ErrTimeout, req.MessageType(), peer)
+		}
+		if _, err := n.conn.WriteToUDP(b, addr); err != nil {
+			return nil, fmt.Errorf("pfcp: sending %s to %s: %w", req.MessageType(), peer, err)
+		}
+		t.Reset(n.t1)
+
+		select {
+		case r := <-ch:
+			if r.header.Type != req.MessageType()+1 {
+				return nil, fmt.Errorf("pfcp: %s answered with %s", req.MessageType(), r.header.Type)
+			}
+			return r.msg, nil
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-n.closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// Heartbeat checks that a peer is alive, returning its recovery time
+// stamp
+func (n *Node) Heartbeat(ctx context.Context, peer string) (time.Time, error) {
+	rsp, err := n.Request(ctx, peer, 0, &HeartbeatRequest{RecoveryTimeStamp: n.recovery})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return rsp.(*HeartbeatResponse).RecoveryTimeStamp, nil
+}
+
+// register allocates the sequence number of a request
+func (n *Node) register() (uint32, chan *received) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for {
+		n.seq = (n.seq + 1) & maxSequenceNumber
+		if _, ok := n.pending[n.seq]; !ok {
+			break
+		}
+	}
+	ch := make(chan *received, 1)
+	n.pending[n.seq] = ch
+	return n.seq, ch
+}
+
+// unregister frees the sequence number of a request
+func (n *Node) unregister(seq uint32) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.pending, seq)
+}
+
+// read reads the messages of the peers until the node is closed
+func (n *Node) read() {
+	defer close(n.done)
+
+	buf := make([]byte, 0xffff)
+	for {
+		size, peer, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		// Malformed messages are dropped, the peer sending them again
+		h, msg, err := Decode(buf[:size])
+		if err != nil {
+			continue
+		}
+		if h.Type.IsRequest() {
+			n.serve(&Request{Peer: peer, Header: h, Message: msg})
+			continue
+		}
+
+		n.mu.Lock()
+		ch := n.pending[h.SequenceNumber]
+		delete(n.pending, h.SequenceNumber)
+		n.mu.Unlock()
+		if ch != nil {
+			ch <- &received{peer: peer, header: h, msg: msg}
+		}
+	}
+}
+
+// serve answers a request, sending the cached response again when the
+// request was already answered
+func (n *Node) serve(req *Request) {
+	key := answerKey{peer: req.Peer.String(), seq: req.Header.SequenceNumber}
+	now := time.Now()
+
+	n.mu.Lock()
+	n.prune(now)
+	a, ok := n.answered[key]
+	if !ok {
+		n.answered[key] = &answer{at: now}
+	}
+	n.mu.Unlock()
+	if ok {
+		if a.b != nil {
+			n.conn.WriteToUDP(a.b, req.Peer)
+		}
+		return
+	}
+
+	if _, ok := req.Message.(*HeartbeatRequest); ok {
+		n.respond(key, req, &HeartbeatResponse{RecoveryTimeStamp: n.recovery}, 0)
+		return
+	}
+	if n.handler == nil {
+		n.forget(key)
+		return
+	}
+	go func() {
+		rsp, seid := n.handler(req)
+		if rsp == nil {
+			n.forget(key)
+			return
+		}
+		n.respond(key, req, rsp, seid)
+	}()
+}
+
+// respond sends the response to a request and keeps it for the
+// retransmissions of the request
+func (n *Node) respond(key answerKey, req *Request, rsp Message, seid uint64) {
+	b, err := Encode(Header{SEID: seid, SequenceNumber: req.Header.SequenceNumber}, rsp)
+	if err != nil {
+		n.forget(key)
+		return
+	}
+
+	n.mu.Lock()
+	if a := n.answered[key]; a != nil {
+		a.b = b
+	}
+	n.mu.Unlock()
+	n.conn.WriteToUDP(b, req.Peer)
+}
+
+// forget drops the response to a request
+func (n *Node) forget(key answerKey) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.answered, key)
+}
+
+// prune drops the responses kept past the retransmission window of the
+// peers. It runs holding n.mu.
+func (n *Node) prune(now time.Time) {
+	if now.Sub(n.prunedAt) < n.t1 {
+		return
+	}
+	n.prunedAt = now
+	window := n.t1 * time.Duration(n.n1+1)
+	for key, a := range n.answered {
+		if a.b != nil && now.Sub(a.at) > window {
+			delete(n.answered, key)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package pfcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testT1 is the request timer of the test nodes
+const testT1 = 50 * time.Millisecond
+
+// listen starts a node on a free local port
+func listen(t *testing.T, handler Handler) *Node {
+	t.Helper()
+
+	n, err := Listen(NodeConfig{Address: "127.0.0.1:0", T1: testT1, N1: 2, Handler: handler, RecoveryTimeStamp: testTime})
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+// peer is a bare UDP socket playing the other PFCP entity
+type peer struct {
+	t    *testing.T
+	conn *net.UDPConn
+}
+
+// newPeer opens a peer on a free local port
+func newPeer(t *testing.T) *peer {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &peer{t: t, conn: conn}
+}
+
+// recv returns the next message the peer got, with its sender
+func (p *peer) recv() ([]byte, *net.UDPAddr) {
+	p.t.Helper()
+
+	buf := make([]byte, 0xffff)
+	p.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := p.conn.ReadFromUDP(buf)
+	if err != nil {
+		p.t.Fatalf("no message received: %v", err)
+	}
+	return buf[:n], from
+}
+
+// send encodes and sends a message to addr
+func (p *peer) send(addr *net.UDPAddr, h Header, msg Message) {
+	p.t.Helper()
+
+	b, err := Encode(h, msg)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	if _, err := p.conn.WriteToUDP(b, addr); err != nil {
+		p.t.Fatal(err)
+	}
+}
+
+// TestRequestRetransmission answers the second copy of a request only:
+// the node sends it again after T1 with the same sequence number
+func TestRequestRetransmission(t *testing.T) {
+	n := listen(t, nil)
+	p := newPeer(t)
+
+	done := make(chan error, 1)
+	var rsp Message
+	go func() {
+		var err error
+		rsp, err = n.Request(context.Background(), p.conn.LocalAddr().String(), 0, &AssociationSetupRequest{
+			NodeID:            NewNodeID("10.0.0.1"),
+			RecoveryTimeStamp: testTime,
+		})
+		done <- err
+	}()
+
+	first, _ := p.recv()
+	second, from := p.recv()
+	if !bytes.Equal(first, second) {
+		t.Fatalf("request sent again as %x, first sent as %x", second, first)
+	}
+	h, _, err := Decode(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.send(from, Header{SequenceNumber: h.SequenceNumber}, &AssociationSetupResponse{
+		NodeID: NewNodeID("10.0.0.2"),
+		Cause:  CauseRequestAccepted,
+	})
+
+	if err := <-done; err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	if r, ok := rsp.(*AssociationSetupResponse); !ok || r.Cause != CauseRequestAccepted {
+		t.Errorf("Request() = %+v, want an accepted association setup response", rsp)
+	}
+}
+
+// TestRequestTimeout sends a request N1 times again before giving up
+func TestRequestTimeout(t *testing.T) {
+	n := listen(t, nil)
+	p := newPeer(t)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := n.Request(context.Background(), p.conn.LocalAddr().String(), 0, &HeartbeatRequest{RecoveryTimeStamp: testTime})
+		done <- err
+	}()
+
+	for i := 0; i < 3; i++ {
+		p.recv()
+	}
+	if err := <-done; !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Request() error = %v, want %v", err, ErrTimeout)
+	}
+	p.conn.SetReadDeadline(time.Now().Add(2 * testT1))
+	if _, _, err := p.conn.ReadFromUDP(make([]byte, 0xffff)); err == nil {
+		t.Error("request sent more than N1 times again")
+	}
+}
+
+// TestDuplicateRequest sends a request twice: the handler answers it
+// once and the copy gets the same response
+func TestDuplicateRequest(t *testing.T) {
+	var calls atomic.Int32
+	n := listen(t, func(req *Request) (Message, uint64) {
+		calls.Add(1)
+		return &SessionDeletionResponse{Cause: CauseRequestAccepted}, 7
+	})
+	p := newPeer(t)
+
+	req := Header{SEID: 1, SequenceNumber: 42}
+	p.send(n.LocalAddr(), req, &SessionDeletionRequest{})
+	first, _ := p.recv()
+	p.send(n.LocalAddr(), req, &SessionDeletionRequest{})
+	second, _ := p.recv()
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("request sent again answered with %x, first with %x", second, first)
+	}
+	h, msg, err := Decode(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.SEID != 7 || h.SequenceNumber != 42 {
+		t.Errorf("response header = %+v, want SEID 7 and sequence number 42", h)
+	}
+	if r, ok := msg.(*SessionDeletionResponse); !ok || r.Cause != CauseRequestAccepted {
+		t.Errorf("response = %+v, want an accepted session deletion response", msg)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("handler called %d times, want 1", got)
+	}
+
+	// Another sequence number is a new request
+	p.send(n.LocalAddr(), Header{SEID: 1, SequenceNumber: 43}, &SessionDeletionRequest{})
+	p.recv()
+	if got := calls.Load(); got != 2 {
+		t.Errorf("handler called %d times, want 2", got)
+	}
+}
+
+// TestHeartbeat checks that the node answers heartbeats itself
+func TestHeartbeat(t *testing.T) {
+	a := listen(t, nil)
+	b := listen(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ts, err := a.Heartbeat(ctx, b.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if !ts.Equal(testTime) {
+		t.Errorf("Heartbeat() = %v, want %v", ts, testTime)
+	}
+}
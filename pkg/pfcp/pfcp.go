@@ -0,0 +1,226 @@
+// Package pfcp implements the Packet Forwarding Control Protocol of TS
+// 29.244 the SMF uses to set up PDU sessions in the UPFs over N4.
+package pfcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Port is the PFCP UDP port (TS 29.244 4.2.2)
+const Port = 8805
+
+// Version is the PFCP version of this package
+const Version = 1
+
+// MessageType identifies a PFCP message
+type MessageType uint8
+
+// Node related messages (TS 29.244 7.3)
+const (
+	MsgHeartbeatRequest           MessageType = 1
+	MsgHeartbeatResponse          MessageType = 2
+	MsgAssociationSetupRequest    MessageType = 5
+	MsgAssociationSetupResponse   MessageType = 6
+	MsgAssociationUpdateRequest   MessageType = 7
+	MsgAssociationUpdateResponse  MessageType = 8
+	MsgAssociationReleaseRequest  MessageType = 9
+	MsgAssociationReleaseResponse MessageType = 10
+)
+
+// Session related messages (TS 29.244 7.3)
+const (
+	MsgSessionEstablishmentRequest  MessageType = 50
+	MsgSessionEstablishmentResponse MessageType = 51
+	MsgSessionModificationRequest   MessageType = 52
+	MsgSessionModificationResponse  MessageType = 53
+	MsgSessionDeletionRequest       MessageType = 54
+	MsgSessionDeletionResponse      MessageType = 55
+	MsgSessionReportRequest         MessageType = 56
+	MsgSessionReportResponse        MessageType = 57
+)
+
+// IsSession reports whether messages of the type belong to a session,
+// their header carrying a SEID
+func (t MessageType) IsSession() bool {
+	return t >= MsgSessionEstablishmentRequest
+}
+
+// IsRequest reports whether the message type is a request
+func (t MessageType) IsRequest() bool {
+	return t%2 == 1 && t < MsgSessionEstablishmentRequest || t%2 == 0 && t >= MsgSessionEstablishmentRequest
+}
+
+// String implements fmt.Stringer
+func (t MessageType) String() string {
+	switch t {
+	case MsgHeartbeatRequest:
+		return "HeartbeatRequest"
+	case MsgHeartbeatResponse:
+		return "HeartbeatResponse"
+	case MsgAssociationSetupRequest:
+		return "AssociationSetupRequest"
+	case MsgAssociationSetupResponse:
+		return "AssociationSetupResponse"
+	case MsgAssociationUpdateRequest:
+		return "AssociationUpdateRequest"
+	case MsgAssociationUpdateResponse:
+		return "AssociationUpdateResponse"
+	case MsgAssociationReleaseRequest:
+		return "AssociationReleaseRequest"
+	case MsgAssociationReleaseResponse:
+		return "AssociationReleaseResponse"
+	case MsgSessionEstablishmentRequest:
+		return "SessionEstablishmentRequest"
+	case MsgSessionEstablishmentResponse:
+		return "SessionEstablishmentResponse"
+	case MsgSessionModificationRequest:
+		return "SessionModificationRequest"
+	case MsgSessionModificationResponse:
+		return "SessionModificationResponse"
+	case MsgSessionDeletionRequest:
+		return "SessionDeletionRequest"
+	case MsgSessionDeletionResponse:
+		return "SessionDeletionResponse"
+	case MsgSessionReportRequest:
+		return "SessionReportRequest"
+	case MsgSessionReportResponse:
+		return "SessionReportResponse"
+	default:
+		return fmt.Sprintf("MessageType(%d)", uint8(t))
+	}
+}
+
+// Errors returned when decoding
+var (
+	// ErrTruncated is returned when a message ends in the middle of a
+	// field
+	ErrTruncated = errors.New("pfcp: message truncated")
+
+	// ErrUnsupportedVersion is returned for messages of another PFCP
+	// version
+	ErrUnsupportedVersion = errors.New("pfcp: unsupported version")
+
+	// ErrUnsupportedMessage is returned for message types this package
+	// does not implement
+	ErrUnsupportedMessage = errors.New("pfcp: unsupported message")
+)
+
+// Header is the header of a PFCP message (TS 29.244 7.2.2)
+type Header struct {
+	Type MessageType
+
+	// SEID is the session of the receiver, session messages only
+	SEID uint64
+
+	// SequenceNumber matches a response with its request, 24 bits
+	SequenceNumber uint32
+}
+
+// Message is a PFCP message body
+type Message interface {
+	// MessageType returns the message type
+	MessageType() MessageType
+
+	encodeBody(*writer)
+	decodeBody(ies) error
+}
+
+// messageFactories creates empty messages for decoding
+var messageFactories = map[MessageType]func() Message{}
+
+// register makes a message type decodable
+func register(factory func() Message) {
+	messageFactories[factory().MessageType()] = factory
+}
+
+// flagS tells that the header carries a SEID
+const flagS = 0x01
+
+// Encode encodes a message with the SEID and sequence number of h, its
+// type being the one of the message
+func Encode(h Header, msg Message) ([]byte, error) {
+	w := &writer{}
+	flags := uint8(Version << 5)
+	if msg.MessageType().IsSession() {
+		flags |= flagS
+	}
+	w.uint8(flags)
+	w.uint8(uint8(msg.MessageType()))
+	w.uint16(0) // length, set below
+	if flags&flagS != 0 {
+		w.uint64(h.SEID)
+	}
+	w.uint32(h.SequenceNumber << 8)
+
+	msg.encodeBody(w)
+	if w.err != nil {
+		return nil, fmt.Errorf("pfcp: encoding %T: %w", msg, w.err)
+	}
+	if len(w.b) > 0xffff+4 {
+		return nil, fmt.Errorf("pfcp: encoding %T: message of %d octets too long", msg, len(w.b))
+	}
+	binary.BigEndian.PutUint16(w.b[2:], uint16(len(w.b)-4))
+	return w.b, nil
+}
+
+// DecodeHeader decodes the header of a message, returning it with the
+// length of the message. The message may be followed by others.
+func DecodeHeader(b []byte) (Header, int, error) {
+	var h Header
+	if len(b) < 8 {
+		return h, 0, ErrTruncated
+	}
+	if b[0]>>5 != Version {
+		return h, 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, b[0]>>5)
+	}
+	h.Type = MessageType(b[1])
+	n := 4 + int(binary.BigEndian.Uint16(b[2:]))
+	if n > len(b) {
+		return h, 0, ErrTruncated
+	}
+
+	off := 4
+	if b[0]&flagS != 0 {
+		if n < 16 {
+			return h, 0, ErrTruncated
+		}
+		h.SEID = binary.BigEndian.Uint64(b[4:])
+		off = 12
+	} else if n < 8 {
+		return h, 0, ErrTruncated
+	}
+	h.SequenceNumber = binary.BigEndian.Uint32(b[off:]) >> 8
+	return h, n, nil
+}
+
+// Decode decodes the first message of b
+func Decode(b []byte) (Header, Message, error) {
+	h, n, err := DecodeHeader(b)
+	if err != nil {
+		return h, nil, err
+	}
+	body := b[8:n]
+	if b[0]&flagS != 0 {
+		body = b[16:n]
+	}
+
+	factory, ok := messageFactories[h.Type]
+	if !ok {
+		return h, nil, fmt.Errorf("%w: message type %d", ErrUnsupportedMessage, uint8(h.Type))
+	}
+	if (b[0]&flagS != 0) != h.Type.IsSession() {
+		return h, nil, fmt.Errorf("%w: message type %d with S flag %d", ErrUnsupportedMessage, uint8(h.Type), b[0]&flagS)
+	}
+
+	msg := factory()
+	list, err := parseIEs(body)
+	if err == nil {
+		err = msg.decodeBody(list)
+	}
+	if err != nil {
+		return h, nil, fmt.Errorf("pfcp: decoding %T: %w", msg, err)
+	}
+	return h, msg, nil
+}
@@ -0,0 +1,372 @@
+package pfcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testSMF  = net.IPv4(10, 0, 0, 1).To4()
+	testUPF  = net.IPv4(10, 0, 0, 2).To4()
+	testGNB  = net.IPv4(192, 168, 1, 10).To4()
+	testUE   = net.IPv4(10, 60, 0, 1).To4()
+	testTime = time.Unix(1700000000, 0)
+)
+
+// Encodings of the test values used by several messages
+const (
+	smfNodeIDHex  = "003c 0005 00 0a000001" // Node ID 10.0.0.1
+	upfNodeIDHex  = "003c 0005 00 0a000002" // Node ID 10.0.0.2
+	recoveryHex   = "0060 0004 e8fe6f80"    // Recovery Time Stamp testTime
+	acceptedHex   = "0013 0001 01"          // Cause request accepted
+	internetHex   = "0016 0008 696e7465726e6574"
+	farID1Hex     = "006c 0004 00000001"
+	urrID1Hex     = "0051 0004 00000001"
+	qerID1Hex     = "006d 0004 00000001"
+	applyForwHex  = "002c 0001 02"
+	testQFIHex    = "007c 0001 09"
+	pdrID1Hex     = "0038 0002 0001"
+	pdrID2Hex     = "0038 0002 0002"
+	sourceAccHex  = "0014 0001 00"
+	destCoreHex   = "002a 0001 01"
+	destAccessHex = "002a 0001 00"
+)
+
+// unhex decodes an encoding written as hex parts with optional spaces
+func unhex(t *testing.T, parts []string) []byte {
+	t.Helper()
+
+	s := strings.ReplaceAll(strings.Join(parts, ""), " ", "")
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad encoding %q: %v", s, err)
+	}
+	return b
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// The encodings are laid out header first, then IE by IE: type, length,
+// then the value, grouped IEs holding the IEs that follow them
+var codecTests = []struct {
+	name   string
+	header Header
+	pdu    []string
+	msg    Message
+}{
+	{
+		name:   "heartbeat request",
+		header: Header{Type: MsgHeartbeatRequest, SequenceNumber: 1},
+		pdu: []string{
+			"20 01 000c", "000001 00",
+			recoveryHex,
+		},
+		msg: &HeartbeatRequest{RecoveryTimeStamp: testTime},
+	},
+	{
+		name:   "heartbeat response",
+		header: Header{Type: MsgHeartbeatResponse, SequenceNumber: 1},
+		pdu: []string{
+			"20 02 000c", "000001 00",
+			recoveryHex,
+		},
+		msg: &HeartbeatResponse{RecoveryTimeStamp: testTime},
+	},
+	{
+		name:   "association setup request",
+		header: Header{Type: MsgAssociationSetupRequest, SequenceNumber: 2},
+		pdu: []string{
+			"20 05 001a", "000002 00",
+			smfNodeIDHex,
+			recoveryHex,
+			"0059 0001 01", // CP Function Features LOAD
+		},
+		msg: &AssociationSetupRequest{
+			NodeID:             NodeID{Type: NodeIDIPv4, IP: testSMF},
+			RecoveryTimeStamp:  testTime,
+			CPFunctionFeatures: []byte{0x01},
+		},
+	},
+	{
+		name:   "association setup response",
+		header: Header{Type: MsgAssociationSetupResponse, SequenceNumber: 2},
+		pdu: []string{
+			"20 06 0020", "000002 00",
+			upfNodeIDHex,
+			acceptedHex,
+			recoveryHex,
+			"002b 0002 0001", // UP Function Features EMPU
+		},
+		msg: &AssociationSetupResponse{
+			NodeID:             NodeID{Type: NodeIDIPv4, IP: testUPF},
+			Cause:              CauseRequestAccepted,
+			RecoveryTimeStamp:  testTime,
+			UPFunctionFeatures: []byte{0x00, 0x01},
+		},
+	},
+	{
+		name:   "session establishment request",
+		header: Header{Type: MsgSessionEstablishmentRequest, SequenceNumber: 3},
+		pdu: []string{
+			"21 32 00f0", "0000000000000000", "000003 00",
+			smfNodeIDHex,
+			"0039 000d 02 0000000000000001 0a000001", // CP F-SEID 1 at 10.0.0.1
+			"0001 0053",                              // Create PDR
+			pdrID1Hex,
+			"001d 0004 000000ff", // Precedence 255
+			"0002 0024",          // PDI
+			sourceAccHex,
+			"0015 0001 05", // F-TEID CH, v4
+			internetHex,
+			"005d 0005 02 0a3c0001", // UE IP Address 10.60.0.1
+			testQFIHex,
+			"005f 0001 00", // Outer Header Removal GTP-U/UDP/IPv4
+			farID1Hex,
+			urrID1Hex,
+			qerID1Hex,
+			"0003 0022", // Create FAR
+			farID1Hex,
+			applyForwHex,
+			"0004 0011", // Forwarding Parameters
+			destCoreHex,
+			internetHex,
+			"0006 0020", // Create URR
+			urrID1Hex,
+			"003e 0001 02",                  // Measurement Method VOLUM
+			"0025 0002 0200",                // Reporting Triggers VOLTH
+			"001f 0009 01 00000000000f4240", // Volume Threshold total 1000000
+			"0007 0020",                     // Create QER
+			qerID1Hex,
+			"0019 0001 00",                    // Gate Status open
+			"001a 000a 00000186a0 0000030d40", // MBR 100000 kbps UL, 200000 DL
+			testQFIHex,
+			"0071 0001 01", // PDN Type IPv4
+		},
+		msg: &SessionEstablishmentRequest{
+			NodeID:  NodeID{Type: NodeIDIPv4, IP: testSMF},
+			CPFSEID: FSEID{SEID: 1, IPv4: testSMF},
+			CreatePDRs: []CreatePDR{{
+				PDRID:      1,
+				Precedence: 255,
+				PDI: PDI{
+					SourceInterface: InterfaceAccess,
+					FTEID:           &FTEID{IPv4: net.IPv4zero.To4(), Choose: true},
+					NetworkInstance: "internet",
+					UEIPAddress:     &UEIPAddress{IPv4: testUE},
+					QFIs:            []uint8{9},
+				},
+				OuterHeaderRemoval: ptr(OuterHeaderRemovalGTPUIPv4),
+				FARID:              ptr[uint32](1),
+				URRIDs:             []uint32{1},
+				QERIDs:             []uint32{1},
+			}},
+			CreateFARs: []CreateFAR{{
+				FARID:       1,
+				ApplyAction: ApplyActionForward,
+				ForwardingParameters: &ForwardingParameters{
+					DestinationInterface: InterfaceCore,
+					NetworkInstance:      "internet",
+				},
+			}},
+			CreateURRs: []URR{{
+				URRID:             1,
+				MeasurementMethod: MeasureVolume,
+				ReportingTriggers: TriggerVolumeThreshold,
+				VolumeThreshold:   &Volume{Total: ptr[uint64](1000000)},
+			}},
+			CreateQERs: []QER{{
+				QERID: 1,
+				MBR:   &BitRate{UL: 100000, DL: 200000},
+				QFI:   ptr[uint8](9),
+			}},
+			PDNType: PDNTypeIPv4,
+		},
+	},
+	{
+		name:   "session establishment response",
+		header: Header{Type: MsgSessionEstablishmentResponse, SEID: 1, SequenceNumber: 3},
+		pdu: []string{
+			"21 33 0042", "0000000000000001", "000003 00",
+			upfNodeIDHex,
+			acceptedHex,
+			"0039 000d 02 0000000000000002 0a000002", // UP F-SEID 2 at 10.0.0.2
+			"0008 0013",                              // Created PDR
+			pdrID1Hex,
+			"0015 0009 01 00000100 0a000002", // F-TEID 0x100 at 10.0.0.2
+		},
+		msg: &SessionEstablishmentResponse{
+			NodeID:  NodeID{Type: NodeIDIPv4, IP: testUPF},
+			Cause:   CauseRequestAccepted,
+			UPFSEID: &FSEID{SEID: 2, IPv4: testUPF},
+			CreatedPDRs: []CreatedPDR{{
+				PDRID: 1,
+				FTEID: &FTEID{TEID: 0x100, IPv4: testUPF},
+			}},
+		},
+	},
+	{
+		name:   "session modification request",
+		header: Header{Type: MsgSessionModificationRequest, SEID: 2, SequenceNumber: 4},
+		pdu: []string{
+			"21 34 0060", "0000000000000002", "000004 00",
+			"000f 0006", pdrID2Hex, // Remove PDR
+			"000a 0029", // Update FAR
+			farID1Hex,
+			applyForwHex,
+			"000b 0018", // Update Forwarding Parameters
+			destAccessHex,
+			"0054 000a 0100 00000200 c0a8010a", // Outer Header Creation GTP-U/UDP/IPv4 0x200 at 192.168.1.10
+			"0031 0001 02",                     // PFCPSMReq-Flags SNDEM
+			"000e 000d",                        // Update QER
+			qerID1Hex,
+			"0019 0001 04",         // Gate Status UL closed
+			"004d 0008", urrID1Hex, // Query URR
+		},
+		msg: &SessionModificationRequest{
+			RemovePDRs: []uint16{2},
+			UpdateFARs: []UpdateFAR{{
+				FARID:       1,
+				ApplyAction: ptr(ApplyActionForward),
+				ForwardingParameters: &ForwardingParameters{
+					DestinationInterface: InterfaceAccess,
+					OuterHeaderCreation:  GTPUTunnel(0x200, testGNB),
+					SendEndMarker:        true,
+				},
+			}},
+			UpdateQERs: []QER{{QERID: 1, GateStatus: GateStatus{ULClosed: true}}},
+			QueryURRs:  []uint32{1},
+		},
+	},
+	{
+		name:   "session modification response",
+		header: Header{Type: MsgSessionModificationResponse, SEID: 1, SequenceNumber: 4},
+		pdu: []string{
+			"21 35 0051", "0000000000000001", "000004 00",
+			acceptedHex,
+			"004e 003c", // Usage Report
+			urrID1Hex,
+			"0068 0004 00000000", // UR-SEQN 0
+			"003f 0003 800000",   // Usage Report Trigger IMMER
+			"0042 0019 07 00000000000005dc 00000000000003e8 00000000000001f4", // Volume Measurement 1500, 1000 UL, 500 DL
+			"0043 0004 0000003c", // Duration Measurement 60 s
+		},
+		msg: &SessionModificationResponse{
+			Cause: CauseRequestAccepted,
+			UsageReports: []UsageReport{{
+				URRID:    1,
+				Trigger:  UsageTriggerImmediate,
+				Volume:   &Volume{Total: ptr[uint64](1500), Uplink: ptr[uint64](1000), Downlink: ptr[uint64](500)},
+				Duration: ptr[uint32](60),
+			}},
+		},
+	},
+	{
+		name:   "session report request, downlink data",
+		header: Header{Type: MsgSessionReportRequest, SEID: 1, SequenceNumber: 5},
+		pdu: []string{
+			"21 38 001b", "0000000000000001", "000005 00",
+			"0027 0001 01",         // Report Type DLDR
+			"0053 0006", pdrID2Hex, // Downlink Data Report
+		},
+		msg: &SessionReportRequest{
+			ReportType:         ReportDownlinkData,
+			DownlinkDataReport: &DownlinkDataReport{PDRIDs: []uint16{2}},
+		},
+	},
+	{
+		name:   "session report request, usage",
+		header: Header{Type: MsgSessionReportRequest, SEID: 1, SequenceNumber: 6},
+		pdu: []string{
+			"21 38 0049", "0000000000000001", "000006 00",
+			"0027 0001 02", // Report Type USAR
+			"0050 0034",    // Usage Report
+			urrID1Hex,
+			"0068 0004 00000001",            // UR-SEQN 1
+			"003f 0003 020000",              // Usage Report Trigger VOLTH
+			"004b 0004 e8fe6f80",            // Start Time testTime
+			"004c 0004 e8fe6fbc",            // End Time a minute later
+			"0042 0009 01 00000000000f4240", // Volume Measurement 1000000
+		},
+		msg: &SessionReportRequest{
+			ReportType: ReportUsage,
+			UsageReports: []UsageReport{{
+				URRID:     1,
+				URSEQN:    1,
+				Trigger:   UsageTriggerVolumeThreshold,
+				StartTime: testTime,
+				EndTime:   testTime.Add(time.Minute),
+				Volume:    &Volume{Total: ptr[uint64](1000000)},
+			}},
+		},
+	},
+	{
+		name:   "session report response",
+		header: Header{Type: MsgSessionReportResponse, SEID: 2, SequenceNumber: 5},
+		pdu: []string{
+			"21 39 0016", "0000000000000002", "000005 00",
+			acceptedHex,
+			"0032 0001 01", // PFCPSRRsp-Flags DROBU
+		},
+		msg: &SessionReportResponse{
+			Cause:               CauseRequestAccepted,
+			DropBufferedPackets: true,
+		},
+	},
+}
+
+func TestCodec(t *testing.T) {
+	for _, tt := range codecTests {
+		t.Run(tt.name, func(t *testing.T) {
+			pdu := unhex(t, tt.pdu)
+
+			h, msg, err := Decode(pdu)
+			if err != nil {
+				t.Fatalf("Decode() error: %v", err)
+			}
+			if h != tt.header {
+				t.Errorf("Decode() header = %+v, want %+v", h, tt.header)
+			}
+			if !reflect.DeepEqual(msg, tt.msg) {
+				t.Errorf("Decode() = %+v, want %+v", msg, tt.msg)
+			}
+
+			b, err := Encode(tt.header, msg)
+			if err != nil {
+				t.Fatalf("Encode() error: %v", err)
+			}
+			if !bytes.Equal(b, pdu) {
+				t.Errorf("Encode() = %x, want %x", b, pdu)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		pdu  []string
+	}{
+		{name: "truncated header", pdu: []string{"20 01 000c 0000"}},
+		{name: "length past the end", pdu: []string{"20 01 0010", "000001 00", recoveryHex}},
+		{name: "version 2", pdu: []string{"40 01 000c", "000001 00", recoveryHex}},
+		{name: "unknown message", pdu: []string{"20 63 0004", "000001 00"}},
+		{name: "session message without SEID", pdu: []string{"20 38 0009", "000005 00", "0027 0001 01"}},
+		{name: "missing mandatory IE", pdu: []string{"20 05 000c", "000002 00", recoveryHex}},
+		{name: "truncated IE", pdu: []string{"20 06 000b", "000002 00", "003c 0005 00 0a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, msg, err := Decode(unhex(t, tt.pdu)); err == nil {
+				t.Errorf("Decode() = %+v, want an error", msg)
+			}
+		})
+	}
+}
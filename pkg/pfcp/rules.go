@@ -0,0 +1,659 @@
+package pfcp
+
+import (
+	"fmt"
+	"time"
+)
+
+// PDI is what a PDR matches packets on (TS 29.244 7.5.2.2-2)
+type PDI struct {
+	SourceInterface Interface
+	FTEID           *FTEID
+	NetworkInstance string
+	UEIPAddress     *UEIPAddress
+	SDFFilters      []SDFFilter
+	QFIs            []uint8
+
+	// EthernetPDUSession matches every Ethernet frame of the PDU session,
+	// the UP function learning the MAC addresses of the UE (TS 29.244
+	// 5.13.1)
+	EthernetPDUSession bool
+}
+
+func (p *PDI) encode(w *writer) {
+	w.uint8IE(ieSourceInterface, uint8(p.SourceInterface))
+	optIE(w, ieFTEID, p.FTEID)
+	if p.NetworkInstance != "" {
+		w.ie(ieNetworkInstance, func(w *writer) { w.bytes([]byte(p.NetworkInstance)) })
+	}
+	optIE(w, ieUEIPAddress, p.UEIPAddress)
+	for i := range p.SDFFilters {
+		w.valueIE(ieSDFFilter, &p.SDFFilters[i])
+	}
+	for _, q := range p.QFIs {
+		w.uint8IE(ieQFI, q&0x3f)
+	}
+	if p.EthernetPDUSession {
+		w.uint8IE(ieEthernetPDUSessionInformation, 0x01)
+	}
+}
+
+func (p *PDI) decode(b []byte) (err error) {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	v, err := l.mandatoryUint8(ieSourceInterface)
+	if err != nil {
+		return err
+	}
+	p.SourceInterface = Interface(v & 0x0f)
+	if p.FTEID, err = optional[FTEID](l, ieFTEID); err != nil {
+		return err
+	}
+	p.NetworkInstance = string(l.find(ieNetworkInstance))
+	if p.UEIPAddress, err = optional[UEIPAddress](l, ieUEIPAddress); err != nil {
+		return err
+	}
+	if p.SDFFilters, err = every[SDFFilter](l, ieSDFFilter); err != nil {
+		return err
+	}
+	if p.QFIs, err = l.allUint8(ieQFI); err != nil {
+		return err
+	}
+	ethi, err := l.optUint8(ieEthernetPDUSessionInformation)
+	p.EthernetPDUSession = ethi != nil && *ethi&0x01 != 0
+	return err
+}
+
+// CreatePDR is a packet detection rule to create (TS 29.244 7.5.2.2)
+type CreatePDR struct {
+	PDRID      uint16
+	Precedence uint32
+	PDI        PDI
+
+	OuterHeaderRemoval *OuterHeaderRemoval
+	FARID              *uint32
+	URRIDs             []uint32
+	QERIDs             []uint32
+}
+
+func (p *CreatePDR) encode(w *writer) {
+	w.uint16IE(iePDRID, p.PDRID)
+	w.uint32IE(iePrecedence, p.Precedence)
+	w.valueIE(iePDI, &p.PDI)
+	if p.OuterHeaderRemoval != nil {
+		w.uint8IE(ieOuterHeaderRemoval, uint8(*p.OuterHeaderRemoval))
+	}
+	w.optUint32IE(ieFARID, p.FARID)
+	for _, id := range p.URRIDs {
+		w.uint32IE(ieURRID, id)
+	}
+	for _, id := range p.QERIDs {
+		w.uint32IE(ieQERID, id)
+	}
+}
+
+func (p *CreatePDR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if p.PDRID, err = l.mandatoryUint16(iePDRID); err != nil {
+		return err
+	}
+	if p.Precedence, err = l.mandatoryUint32(iePrecedence); err != nil {
+		return err
+	}
+	if err := l.mandatory(iePDI, &p.PDI); err != nil {
+		return err
+	}
+	return decodePDRRules(l, &p.OuterHeaderRemoval, &p.FARID, &p.URRIDs, &p.QERIDs)
+}
+
+// decodePDRRules decodes the IEs a PDR shares with its updates
+func decodePDRRules(l ies, ohr **OuterHeaderRemoval, far **uint32, urrs, qers *[]uint32) error {
+	v, err := l.optUint8(ieOuterHeaderRemoval)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		r := OuterHeaderRemoval(*v)
+		*ohr = &r
+	}
+	if *far, err = l.optUint32(ieFARID); err != nil {
+		return err
+	}
+	if *urrs, err = l.allUint32(ieURRID); err != nil {
+		return err
+	}
+	*qers, err = l.allUint32(ieQERID)
+	return err
+}
+
+// UpdatePDR changes a packet detection rule, the fields left nil being
+// kept (TS 29.244 7.5.4.2)
+type UpdatePDR struct {
+	PDRID uint16
+
+	OuterHeaderRemoval *OuterHeaderRemoval
+	Precedence         *uint32
+	PDI                *PDI
+	FARID              *uint32
+	URRIDs             []uint32
+	QERIDs             []uint32
+}
+
+func (p *UpdatePDR) encode(w *writer) {
+	w.uint16IE(iePDRID, p.PDRID)
+	if p.OuterHeaderRemoval != nil {
+		w.uint8IE(ieOuterHeaderRemoval, uint8(*p.OuterHeaderRemoval))
+	}
+	w.optUint32IE(iePrecedence, p.Precedence)
+	optIE(w, iePDI, p.PDI)
+	w.optUint32IE(ieFARID, p.FARID)
+	for _, id := range p.URRIDs {
+		w.uint32IE(ieURRID, id)
+	}
+	for _, id := range p.QERIDs {
+		w.uint32IE(ieQERID, id)
+	}
+}
+
+func (p *UpdatePDR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if p.PDRID, err = l.mandatoryUint16(iePDRID); err != nil {
+		return err
+	}
+	if p.Precedence, err = l.optUint32(iePrecedence); err != nil {
+		return err
+	}
+	if p.PDI, err = optional[PDI](l, iePDI); err != nil {
+		return err
+	}
+	return decodePDRRules(l, &p.OuterHeaderRemoval, &p.FARID, &p.URRIDs, &p.QERIDs)
+}
+
+// CreatedPDR is the F-TEID the UP function allocated for a PDR (TS
+// 29.244 7.5.3.2)
+type CreatedPDR struct {
+	PDRID       uint16
+	FTEID       *FTEID
+	UEIPAddress *UEIPAddress
+}
+
+func (p *CreatedPDR) encode(w *writer) {
+	w.uint16IE(iePDRID, p.PDRID)
+	optIE(w, ieFTEID, p.FTEID)
+	optIE(w, ieUEIPAddress, p.UEIPAddress)
+}
+
+func (p *CreatedPDR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if p.PDRID, err = l.mandatoryUint16(iePDRID); err != nil {
+		return err
+	}
+	if p.FTEID, err = optional[FTEID](l, ieFTEID); err != nil {
+		return err
+	}
+	p.UEIPAddress, err = optional[UEIPAddress](l, ieUEIPAddress)
+	return err
+}
+
+// ForwardingParameters tell where a FAR forwards packets (TS 29.244
+// 7.5.2.3-2)
+type ForwardingParameters struct {
+	DestinationInterface Interface
+	NetworkInstance      string
+	OuterHeaderCreation  *OuterHeaderCreation
+
+	// SendEndMarker asks the UP function to send an end marker on the
+	// previous tunnel, in updates only
+	SendEndMarker bool
+}
+
+func (f *ForwardingParameters) encode(w *writer) {
+	w.uint8IE(ieDestinationInterface, uint8(f.DestinationInterface))
+	if f.NetworkInstance != "" {
+		w.ie(ieNetworkInstance, func(w *writer) { w.bytes([]byte(f.NetworkInstance)) })
+	}
+	optIE(w, ieOuterHeaderCreation, f.OuterHeaderCreation)
+	if f.SendEndMarker {
+		w.uint8IE(ieSMReqFlags, 0x02)
+	}
+}
+
+func (f *ForwardingParameters) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	v, err := l.mandatoryUint8(ieDestinationInterface)
+	if err != nil {
+		return err
+	}
+	f.DestinationInterface = Interface(v & 0x0f)
+	f.NetworkInstance = string(l.find(ieNetworkInstance))
+	if f.OuterHeaderCreation, err = optional[OuterHeaderCreation](l, ieOuterHeaderCreation); err != nil {
+		return err
+	}
+	flags, err := l.optUint8(ieSMReqFlags)
+	f.SendEndMarker = flags != nil && *flags&0x02 != 0
+	return err
+}
+
+// DuplicatingParameters tell where a FAR sends the copies of the
+// packets it duplicates (TS 29.244 7.5.2.3-3)
+type DuplicatingParameters struct {
+	DestinationInterface Interface
+	OuterHeaderCreation  *OuterHeaderCreation
+}
+
+func (d *DuplicatingParameters) encode(w *writer) {
+	w.uint8IE(ieDestinationInterface, uint8(d.DestinationInterface))
+	optIE(w, ieOuterHeaderCreation, d.OuterHeaderCreation)
+}
+
+func (d *DuplicatingParameters) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	v, err := l.mandatoryUint8(ieDestinationInterface)
+	if err != nil {
+		return err
+	}
+	d.DestinationInterface = Interface(v & 0x0f)
+	d.OuterHeaderCreation, err = optional[OuterHeaderCreation](l, ieOuterHeaderCreation)
+	return err
+}
+
+// CreateFAR is a forwarding action rule to create (TS 29.244 7.5.2.3)
+type CreateFAR struct {
+	FARID                 uint32
+	ApplyAction           ApplyAction
+	ForwardingParameters  *ForwardingParameters
+	DuplicatingParameters []DuplicatingParameters
+	BARID                 *uint8
+}
+
+func (f *CreateFAR) encode(w *writer) {
+	w.uint32IE(ieFARID, f.FARID)
+	w.uint8IE(ieApplyAction, uint8(f.ApplyAction))
+	optIE(w, ieForwardingParameters, f.ForwardingParameters)
+	for i := range f.DuplicatingParameters {
+		w.valueIE(ieDuplicatingParameters, &f.DuplicatingParameters[i])
+	}
+	w.optUint8IE(ieBARID, f.BARID)
+}
+
+func (f *CreateFAR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if f.FARID, err = l.mandatoryUint32(ieFARID); err != nil {
+		return err
+	}
+	a, err := l.mandatoryUint8(ieApplyAction)
+	if err != nil {
+		return err
+	}
+	f.ApplyAction = ApplyAction(a)
+	if f.ForwardingParameters, err = optional[ForwardingParameters](l, ieForwardingParameters); err != nil {
+		return err
+	}
+	if f.DuplicatingParameters, err = every[DuplicatingParameters](l, ieDuplicatingParameters); err != nil {
+		return err
+	}
+	f.BARID, err = l.optUint8(ieBARID)
+	return err
+}
+
+// UpdateFAR changes a forwarding action rule, the fields left nil being
+// kept (TS 29.244 7.5.4.3). Duplicating parameters, when set, replace
+// the ones of the FAR.
+type UpdateFAR struct {
+	FARID                 uint32
+	ApplyAction           *ApplyAction
+	ForwardingParameters  *ForwardingParameters
+	DuplicatingParameters []DuplicatingParameters
+	BARID                 *uint8
+}
+
+func (f *UpdateFAR) encode(w *writer) {
+	w.uint32IE(ieFARID, f.FARID)
+	if f.ApplyAction != nil {
+		w.uint8IE(ieApplyAction, uint8(*f.ApplyAction))
+	}
+	optIE(w, ieUpdateForwardingParameters, f.ForwardingParameters)
+	for i := range f.DuplicatingParameters {
+		w.valueIE(ieUpdateDuplicatingParameters, &f.DuplicatingParameters[i])
+	}
+	w.optUint8IE(ieBARID, f.BARID)
+}
+
+func (f *UpdateFAR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if f.FARID, err = l.mandatoryUint32(ieFARID); err != nil {
+		return err
+	}
+	a, err := l.optUint8(ieApplyAction)
+	if err != nil {
+		return err
+	}
+	if a != nil {
+		action := ApplyAction(*a)
+		f.ApplyAction = &action
+	}
+	if f.ForwardingParameters, err = optional[ForwardingParameters](l, ieUpdateForwardingParameters); err != nil {
+		return err
+	}
+	if f.DuplicatingParameters, err = every[DuplicatingParameters](l, ieUpdateDuplicatingParameters); err != nil {
+		return err
+	}
+	f.BARID, err = l.optUint8(ieBARID)
+	return err
+}
+
+// QER is a QoS enforcement rule, to create or update (TS 29.244 7.5.2.5
+// and 7.5.4.5). Bit rates left nil are not enforced, or kept in updates.
+type QER struct {
+	QERID      uint32
+	GateStatus GateStatus
+	MBR        *BitRate
+	GBR        *BitRate
+	QFI        *uint8
+}
+
+func (q *QER) encode(w *writer) {
+	w.uint32IE(ieQERID, q.QERID)
+	w.valueIE(ieGateStatus, &q.GateStatus)
+	optIE(w, ieMBR, q.MBR)
+	optIE(w, ieGBR, q.GBR)
+	w.optUint8IE(ieQFI, q.QFI)
+}
+
+func (q *QER) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if q.QERID, err = l.mandatoryUint32(ieQERID); err != nil {
+		return err
+	}
+	if _, err := l.decodeIE(ieGateStatus, &q.GateStatus); err != nil {
+		return err
+	}
+	if q.MBR, err = optional[BitRate](l, ieMBR); err != nil {
+		return err
+	}
+	if q.GBR, err = optional[BitRate](l, ieGBR); err != nil {
+		return err
+	}
+	q.QFI, err = l.optUint8(ieQFI)
+	return err
+}
+
+// URR is a usage reporting rule, to create or update (TS 29.244 7.5.2.4
+// and 7.5.4.4). Periods, thresholds and quotas are in seconds and
+// octets.
+type URR struct {
+	URRID             uint32
+	MeasurementMethod MeasurementMethod
+	ReportingTriggers ReportingTriggers
+	MeasurementPeriod *uint32
+	VolumeThreshold   *Volume
+	TimeThreshold     *uint32
+	VolumeQuota       *Volume
+	TimeQuota         *uint32
+}
+
+func (u *URR) encode(w *writer) {
+	w.uint32IE(ieURRID, u.URRID)
+	w.uint8IE(ieMeasurementMethod, uint8(u.MeasurementMethod))
+	w.ie(ieReportingTriggers, func(w *writer) {
+		w.uint8(uint8(u.ReportingTriggers))
+		w.uint8(uint8(u.ReportingTriggers >> 8))
+	})
+	w.optUint32IE(ieMeasurementPeriod, u.MeasurementPeriod)
+	optIE(w, ieVolumeThreshold, u.VolumeThreshold)
+	w.optUint32IE(ieTimeThreshold, u.TimeThreshold)
+	optIE(w, ieVolumeQuota, u.VolumeQuota)
+	w.optUint32IE(ieTimeQuota, u.TimeQuota)
+}
+
+func (u *URR) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if u.URRID, err = l.mandatoryUint32(ieURRID); err != nil {
+		return err
+	}
+	m, err := l.optUint8(ieMeasurementMethod)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		u.MeasurementMethod = MeasurementMethod(*m)
+	}
+	if t := l.find(ieReportingTriggers); len(t) >= 2 {
+		u.ReportingTriggers = ReportingTriggers(t[0]) | ReportingTriggers(t[1])<<8
+	}
+	if u.MeasurementPeriod, err = l.optUint32(ieMeasurementPeriod); err != nil {
+		return err
+	}
+	if u.VolumeThreshold, err = optional[Volume](l, ieVolumeThreshold); err != nil {
+		return err
+	}
+	if u.TimeThreshold, err = l.optUint32(ieTimeThreshold); err != nil {
+		return err
+	}
+	if u.VolumeQuota, err = optional[Volume](l, ieVolumeQuota); err != nil {
+		return err
+	}
+	u.TimeQuota, err = l.optUint32(ieTimeQuota)
+	return err
+}
+
+// BAR is a buffering action rule, to create or update (TS 29.244
+// 7.5.2.6 and 7.5.4.14)
+type BAR struct {
+	BARID uint8
+
+	// DownlinkDataNotificationDelay is in multiples of 50 ms
+	DownlinkDataNotificationDelay *uint8
+
+	// SuggestedBufferingPackets is how many packets to buffer
+	SuggestedBufferingPackets *uint8
+}
+
+func (b *BAR) encode(w *writer) {
+	w.uint8IE(ieBARID, b.BARID)
+	w.optUint8IE(ieDownlinkDataNotificationDelay, b.DownlinkDataNotificationDelay)
+	w.optUint8IE(ieSuggestedBufferingPackets, b.SuggestedBufferingPackets)
+}
+
+func (b *BAR) decode(v []byte) error {
+	l, err := parseIEs(v)
+	if err != nil {
+		return err
+	}
+	if b.BARID, err = l.mandatoryUint8(ieBARID); err != nil {
+		return err
+	}
+	if b.DownlinkDataNotificationDelay, err = l.optUint8(ieDownlinkDataNotificationDelay); err != nil {
+		return err
+	}
+	b.SuggestedBufferingPackets, err = l.optUint8(ieSuggestedBufferingPackets)
+	return err
+}
+
+// UsageReport is the traffic measured by a URR (TS 29.244 7.5.5.2)
+type UsageReport struct {
+	URRID   uint32
+	URSEQN  uint32
+	Trigger UsageReportTrigger
+
+	StartTime time.Time
+	EndTime   time.Time
+	Volume    *Volume
+
+	// Duration is in seconds
+	Duration *uint32
+
+	FirstPacket time.Time
+	LastPacket  time.Time
+}
+
+func (u *UsageReport) encode(w *writer) {
+	w.uint32IE(ieURRID, u.URRID)
+	w.uint32IE(ieURSEQN, u.URSEQN)
+	w.ie(ieUsageReportTrigger, func(w *writer) {
+		w.uint8(uint8(u.Trigger))
+		w.uint8(uint8(u.Trigger >> 8))
+		w.uint8(uint8(u.Trigger >> 16))
+	})
+	w.timeIE(ieStartTime, u.StartTime)
+	w.timeIE(ieEndTime, u.EndTime)
+	optIE(w, ieVolumeMeasurement, u.Volume)
+	w.optUint32IE(ieDurationMeasurement, u.Duration)
+	w.timeIE(ieTimeOfFirstPacket, u.FirstPacket)
+	w.timeIE(ieTimeOfLastPacket, u.LastPacket)
+}
+
+func (u *UsageReport) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	if u.URRID, err = l.mandatoryUint32(ieURRID); err != nil {
+		return err
+	}
+	if u.URSEQN, err = l.mandatoryUint32(ieURSEQN); err != nil {
+		return err
+	}
+	t := l.find(ieUsageReportTrigger)
+	for i := 0; i < len(t) && i < 3; i++ {
+		u.Trigger |= UsageReportTrigger(t[i]) << (8 * i)
+	}
+	if u.StartTime, err = optTime(l, ieStartTime); err != nil {
+		return err
+	}
+	if u.EndTime, err = optTime(l, ieEndTime); err != nil {
+		return err
+	}
+	if u.Volume, err = optional[Volume](l, ieVolumeMeasurement); err != nil {
+		return err
+	}
+	if u.Duration, err = l.optUint32(ieDurationMeasurement); err != nil {
+		return err
+	}
+	if u.FirstPacket, err = optTime(l, ieTimeOfFirstPacket); err != nil {
+		return err
+	}
+	u.LastPacket, err = optTime(l, ieTimeOfLastPacket)
+	return err
+}
+
+// DownlinkDataReport tells the PDRs that buffered downlink packets (TS
+// 29.244 7.5.8.2)
+type DownlinkDataReport struct {
+	PDRIDs []uint16
+}
+
+func (d *DownlinkDataReport) encode(w *writer) {
+	for _, id := range d.PDRIDs {
+		w.uint16IE(iePDRID, id)
+	}
+}
+
+func (d *DownlinkDataReport) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	for _, v := range l.all(iePDRID) {
+		id, err := uint16Of(v)
+		if err != nil {
+			return err
+		}
+		d.PDRIDs = append(d.PDRIDs, id)
+	}
+	return nil
+}
+
+// ErrorIndicationReport tells the remote tunnel endpoints that answered
+// with a GTP-U Error Indication (TS 29.244 7.5.8.4)
+type ErrorIndicationReport struct {
+	RemoteFTEIDs []FTEID
+}
+
+func (e *ErrorIndicationReport) encode(w *writer) {
+	for i := range e.RemoteFTEIDs {
+		w.valueIE(ieFTEID, &e.RemoteFTEIDs[i])
+	}
+}
+
+func (e *ErrorIndicationReport) decode(b []byte) error {
+	l, err := parseIEs(b)
+	if err != nil {
+		return err
+	}
+	e.RemoteFTEIDs, err = every[FTEID](l, ieFTEID)
+	return err
+}
+
+// idsIE writes one grouped IE per ID, as Remove PDR or Query URR, holding the ID in an IE of type
+// idType
+func (w *writer) idsIE(t, idType ieType, ids []uint32) {
+	for _, id := range ids {
+		w.ie(t, func(w *writer) {
+			switch idType {
+			case iePDRID:
+				w.uint16IE(idType, uint16(id))
+			case ieBARID:
+				w.uint8IE(idType, uint8(id))
+			default:
+				w.uint32IE(idType, id)
+			}
+		})
+	}
+}
+
+// ids decodes the IDs of the grouped IEs of a type
+func (l ies) ids(t, idType ieType) ([]uint32, error) {
+	var list []uint32
+	for _, b := range l.all(t) {
+		inner, err := parseIEs(b)
+		if err != nil {
+			return nil, err
+		}
+		var id uint32
+		switch idType {
+		case iePDRID:
+			v, e := inner.mandatoryUint16(idType)
+			id, err = uint32(v), e
+		case ieBARID:
+			v, e := inner.mandatoryUint8(idType)
+			id, err = uint32(v), e
+		default:
+			id, err = inner.mandatoryUint32(idType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("IE %d: %w", t, err)
+		}
+		list = append(list, id)
+	}
+	return list, nil
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/0had0/5G-core/pkg/common/errors"
@@ -19,88 +21,135 @@ import (
 type Client struct {
 	httpClient *http.Client
 	serviceName string
+	metrics *metrics.Metrics
 }
 
-// NewClient creates a new SBI client
-func NewClient(serviceName string, timeout time.Duration) *Client {
+// NewClient creates a new SBI client. Requests are recorded on m when it
+// is not nil.
+func NewClient(serviceName string, timeout time.Duration, m *metrics.Metrics) *Client {
 	return &Client{
 		httpClient: &http.Client{
 			Timeout: timeout,
 		},
 		serviceName: serviceName,
+		metrics: m,
 	}
 }
 
 // Get performs a GET request
 func (c *Client) Get(ctx context.Context, url string, target interface{}) error {
-	return c.doRequest(ctx, http.MethodGet, url, nil, target)
+	_, err := c.doRequest(ctx, http.MethodGet, url, nil, target)
+	return err
 }
 
 // Post performs a POST request
 func (c *Client) Post(ctx context.Context, url string, body interface{}, target interface{}) error {
-	return c.doRequest(ctx, http.MethodPost, url, body, target)
+	_, err := c.doRequest(ctx, http.MethodPost, url, body, target)
+	return err
+}
+
+// Create performs a POST request creating a resource and returns the
+// resource URI from the Location header
+func (c *Client) Create(ctx context.Context, url string, body interface{}, target interface{}) (string, error) {
+	header, err := c.doRequest(ctx, http.MethodPost, url, body, target)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("Location"), nil
 }
 
 // Put performs a PUT request
 func (c *Client) Put(ctx context.Context, url string, body interface{}, target interface{}) error {
-	return c.doRequest(ctx, http.MethodPut, url, body, target)
+	_, err := c.doRequest(ctx, http.MethodPut, url, body, target)
+	return err
+}
+
+// Patch performs a PATCH request
+func (c *Client) Patch(ctx context.Context, url string, body interface{}, target interface{}) error {
+	_, err := c.doRequest(ctx, http.MethodPatch, url, body, target)
+	return err
 }
 
 // Delete performs a DELETE request
 func (c *Client) Delete(ctx context.Context, url string) error {
-	return c.doRequest(ctx, http.MethodDelete, url, nil, nil)
+	_, err := c.doRequest(ctx, http.MethodDelete, url, nil, nil)
+	return err
 }
 
-// doRequest performs the HTTP request
-func (c *Client) doRequest(ctx context.Context, method, url string, body, target interface{}) error {
+// doRequest performs the HTTP request and returns the response headers
+func (c *Client) doRequest(ctx context.Context, method, url string, body, target interface{}) (http.Header, error) {
 	startTime := time.Now()
+	route, subscriber := redactURL(url)
 	
 	// Create request
 	var bodyReader io.Reader
-	if body != nil {
+	contentType := ContentTypeJSON
+	if m, ok := body.(*Multipart); ok {
+		b, ct, err := m.encode()
+		if err != nil {
+			return nil, errors.NewInternalError("Failed to marshal request body", err)
+		}
+		bodyReader, contentType = bytes.NewReader(b), ct
+	} else if body != nil {
 		jsonBody, err := json.Marshal(body)
 		if err != nil {
-			return errors.NewInternalError("Failed to marshal request body", err)
+			return nil, errors.NewInternalError("Failed to marshal request body", err)
 		}
 		bodyReader = bytes.NewBuffer(jsonBody)
 	}
 	
 	req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 	if err != nil {
-		return errors.NewInternalError("Failed to create request", err)
+		return nil, errors.NewInternalError(fmt.Sprintf("Failed to create request to %s %s", method, route), unwrapURLError(err))
 	}
 	
 	// Set headers
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", "application/json")
+	if _, ok := target.(*Multipart); ok {
+		req.Header.Set("Accept", "application/json, multipart/related")
+	}
 	
 	// Execute request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		metrics.RequestCounter.WithLabelValues(c.serviceName, method, "error").Inc()
-		return errors.NewInternalError(fmt.Sprintf("Failed to execute request to %s", url), err)
+		if c.metrics != nil {
+			c.metrics.RequestCounter.WithLabelValues(c.serviceName, metrics.RoleClient, method, "error").Inc()
+		}
+		return nil, errors.NewInternalError(fmt.Sprintf("Failed to execute request to %s %s", method, route), unwrapURLError(err))
 	}
 	defer resp.Body.Close()
 	
 	// Record metrics
 	duration := time.Since(startTime).Seconds()
-	metrics.RequestDuration.WithLabelValues(c.serviceName, method).Observe(duration)
-	metrics.RequestCounter.WithLabelValues(c.serviceName, method, fmt.Sprintf("%d", resp.StatusCode)).Inc()
+	if c.metrics != nil {
+		c.metrics.RequestDuration.WithLabelValues(c.serviceName, metrics.RoleClient, method).Observe(duration)
+		c.metrics.RequestCounter.WithLabelValues(c.serviceName, metrics.RoleClient, method, fmt.Sprintf("%d", resp.StatusCode)).Inc()
+	}
 	
 	// Log the request
-	logger.Debug("SBI request",
+	logger.Named("sbi").Debug("SBI request", append(subscriber,
 		zap.String("method", method),
-		zap.String("url", url),
+		zap.String("route", route),
 		zap.Int("status", resp.StatusCode),
 		zap.Float64("duration", duration),
-	)
+	)...)
 	
 	// Check for error status codes
 	if resp.StatusCode >= 400 {
+		// Errors with binary parts, such as an N1 message for the UE, are
+		// decoded into a multipart target
+		if m, ok := target.(*Multipart); ok && isMultipart(resp.Header.Get("Content-Type")) {
+			if err := m.decode(resp.Header.Get("Content-Type"), resp.Body); err != nil {
+				return nil, c.mapStatusCodeToError(resp.StatusCode, method+" "+route)
+			}
+			return nil, c.mapStatusCodeToError(resp.StatusCode, fmt.Sprintf("Request failed with status %d", resp.StatusCode))
+		}
+
 		var errorResponse map[string]interface{}
 		if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
 			// If we can't decode the error response, just return a generic error
-			return c.mapStatusCodeToError(resp.StatusCode, url)
+			return nil, c.mapStatusCodeToError(resp.StatusCode, method+" "+route)
 		}
 		
 		// Return a more specific error based on the response
@@ -109,17 +158,23 @@ func (c *Client) doRequest(ctx context.Context, method, url string, body, target
 			errorMsg = fmt.Sprintf("Request failed with status %d", resp.StatusCode)
 		}
 		
-		return c.mapStatusCodeToError(resp.StatusCode, errorMsg)
+		return nil, c.mapStatusCodeToError(resp.StatusCode, errorMsg)
 	}
 	
 	// Decode the response if a target was provided
 	if target != nil && resp.StatusCode != http.StatusNoContent {
-		if err := json.NewDecoder(resp.Body).Decode(target); err != nil {
-			return errors.NewInternalError("Failed to decode response", err)
+		var err error
+		if m, ok := target.(*Multipart); ok {
+			err = m.decode(resp.Header.Get("Content-Type"), resp.Body)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(target)
+		}
+		if err != nil {
+			return nil, errors.NewInternalError("Failed to decode response", err)
 		}
 	}
 	
-	return nil
+	return resp.Header, nil
 }
 
 // mapStatusCodeToError maps HTTP status codes to appropriate error types
@@ -141,3 +196,50 @@ func (c *Client) mapStatusCodeToError(statusCode int, message string) error {
 		return errors.NewInternalError(message, nil)
 	}
 }
+
+// subscriberPrefixes are the type prefixes of the subscriber identifiers
+// found in the paths of SBI resources (TS 29.571 5.3.2)
+var subscriberPrefixes = []string{"imsi-", "nai-", "msisdn-", "extid-", "suci-", "gli-", "gci-", "imei-", "imeisv-"}
+
+// redactURL returns the host and route template of a request URL for the
+// logs and errors: its subscriber identifiers are replaced by {ueId} and
+// the first one is returned as a redacted field. The query is left out.
+func redactURL(raw string) (string, []zap.Field) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid URL", nil
+	}
+
+	var fields []zap.Field
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, s := range segments {
+		id, err := url.PathUnescape(s)
+		if err != nil || !isSubscriber(id) {
+			continue
+		}
+		if fields == nil {
+			fields = []zap.Field{logger.SUPI(id)}
+		}
+		segments[i] = "{ueId}"
+	}
+	return u.Scheme + "://" + u.Host + strings.Join(segments, "/"), fields
+}
+
+// isSubscriber reports whether a path segment is a subscriber identifier
+func isSubscriber(s string) bool {
+	for _, prefix := range subscriberPrefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrapURLError returns the cause of an error of the HTTP client without
+// the URL it quotes
+func unwrapURLError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %w", ue.Op, ue.Err)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package sbi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/logger"
+)
+
+const testSUPI = "imsi-208930000000001"
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		route string
+		supi  bool
+	}{
+		{
+			url:   "http://udm:8080/nudm-uecm/v1/" + testSUPI + "/registrations/amf-3gpp-access",
+			route: "http://udm:8080/nudm-uecm/v1/{ueId}/registrations/amf-3gpp-access",
+			supi:  true,
+		},
+		{
+			url:   "http://udm:8080/nudm-sdm/v2/" + testSUPI + "/am-data?plmn-id=20893",
+			route: "http://udm:8080/nudm-sdm/v2/{ueId}/am-data",
+			supi:  true,
+		},
+		{
+			url:   "http://amf:8080/namf-callback/v1/nai-user%40realm/sdm-change",
+			route: "http://amf:8080/namf-callback/v1/{ueId}/sdm-change",
+			supi:  true,
+		},
+		{
+			url:   "http://nrf:8080/nnrf-nfm/v1/nf-instances/6b1c3e5a",
+			route: "http://nrf:8080/nnrf-nfm/v1/nf-instances/6b1c3e5a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.route, func(t *testing.T) {
+			route, fields := redactURL(tt.url)
+			if route != tt.route {
+				t.Errorf("route = %q, want %q", route, tt.route)
+			}
+			if got := len(fields) == 1 && fields[0].Key == "supi"; got != tt.supi {
+				t.Errorf("fields = %v, want a SUPI field %v", fields, tt.supi)
+			}
+		})
+	}
+}
+
+func TestClientErrorsRedactSUPI(t *testing.T) {
+	if err := logger.SetPrivacy(string(logger.PrivacyHash), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	c := NewClient("test", time.Second, nil)
+	for name, root := range map[string]string{"error status": srv.URL, "connection refused": closed.URL} {
+		t.Run(name, func(t *testing.T) {
+			err := c.Get(context.Background(), root+"/nudm-sdm/v2/"+testSUPI+"/am-data", nil)
+			if err == nil {
+				t.Fatal("Get() succeeded")
+			}
+			if strings.Contains(err.Error(), "208930000000001") {
+				t.Errorf("error %q shows the SUPI", err)
+			}
+			if !strings.Contains(err.Error(), "/nudm-sdm/v2/{ueId}/am-data") {
+				t.Errorf("error %q does not name the route", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package sbi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"go.uber.org/zap"
+)
+
+// Content types of SBI message parts (TS 29.500 5.4)
+const (
+	ContentTypeJSON = "application/json"
+	ContentTypeNAS  = "application/vnd.3gpp.5gnas"
+	ContentTypeNGAP = "application/vnd.3gpp.ngap"
+
+	contentTypeMultipart = "multipart/related"
+)
+
+// Part is a binary part of a multipart message, referred to from the
+// JSON part by its Content-ID
+type Part struct {
+	ContentID   string
+	ContentType string
+	Body        []byte
+}
+
+// Multipart is a message made of a JSON part and binary parts, such as
+// NAS messages or NGAP transfers (TS 29.500 6.1.2.2.4). It is sent as
+// multipart/related, or as plain JSON when there is no binary part.
+// Passed as body or target, it makes Client requests use this encoding.
+type Multipart struct {
+	JSON  interface{}
+	Parts []Part
+}
+
+// Part returns the body of the binary part with the given Content-ID
+func (m *Multipart) Part(contentID string) []byte {
+	for _, p := range m.Parts {
+		if p.ContentID == contentID {
+			return p.Body
+		}
+	}
+	return nil
+}
+
+// encode returns the body of the message and its content type
+func (m *Multipart) encode() ([]byte, string, error) {
+	root, err := json.Marshal(m.JSON)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(m.Parts) == 0 {
+		return root, ContentTypeJSON, nil
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {ContentTypeJSON}})
+	if err != nil {
+		return nil, "", err
+	}
+	pw.Write(root)
+	for _, p := range m.Parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {p.ContentType},
+			"Content-Id":   {p.ContentID},
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		pw.Write(p.Body)
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	contentType := mime.FormatMediaType(contentTypeMultipart, map[string]string{
+		"boundary": w.Boundary(),
+		"type":     ContentTypeJSON,
+	})
+	return buf.Bytes(), contentType, nil
+}
+
+// decode reads a JSON or multipart/related body. The first JSON part is
+// decoded into m.JSON, the other parts are kept as binary parts.
+func (m *Multipart) decode(contentType string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != contentTypeMultipart {
+		return json.NewDecoder(body).Decode(m.JSON)
+	}
+
+	r := multipart.NewReader(body, params["boundary"])
+	root := false
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(p)
+		if err != nil {
+			return err
+		}
+
+		partType := p.Header.Get("Content-Type")
+		if !root && strings.HasPrefix(partType, ContentTypeJSON) {
+			root = true
+			if err := json.Unmarshal(b, m.JSON); err != nil {
+				return err
+			}
+			continue
+		}
+		m.Parts = append(m.Parts, Part{
+			ContentID:   strings.Trim(p.Header.Get("Content-Id"), "<>"),
+			ContentType: partType,
+			Body:        b,
+		})
+	}
+	if !root {
+		return fmt.Errorf("multipart message without JSON part")
+	}
+	return nil
+}
+
+// isMultipart reports whether a content type is multipart/related
+func isMultipart(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == contentTypeMultipart
+}
+
+// ReadMultipart decodes a JSON or multipart/related request body into v
+// and returns the message with its binary parts
+func ReadMultipart(r *http.Request, v interface{}) (*Multipart, error) {
+	m := &Multipart{JSON: v}
+	if err := m.decode(r.Header.Get("Content-Type"), r.Body); err != nil {
+		return nil, errors.NewBadRequestError("Invalid request body", err)
+	}
+	return m, nil
+}
+
+// WriteMultipart writes a message as a response with the given status
+func WriteMultipart(w http.ResponseWriter, status int, m *Multipart) {
+	b, contentType, err := m.encode()
+	if err != nil {
+		WriteError(w, errors.NewInternalError("Failed to encode response", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(b); err != nil {
+		logger.Warn("Failed to write response", zap.Error(err))
+	}
+}
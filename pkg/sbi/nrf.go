@@ -0,0 +1,144 @@
+package sbi
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/models"
+	"go.uber.org/zap"
+)
+
+// nfInstancesPath is the NF instances collection of Nnrf_NFManagement
+const nfInstancesPath = "/nnrf-nfm/v1/nf-instances/"
+
+// patchItem is an operation of a JSON patch (RFC 6902)
+type patchItem struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// NRFRegistration keeps a Network Function registered with the NRF (TS
+// 29.510 5.2.2.2): it registers the NF profile until the NRF accepts
+// it, then sends heartbeats, registering again once one fails. Its
+// health flag is up while the NF is registered.
+type NRFRegistration struct {
+	client    *Client
+	uri       string
+	profile   models.NfProfile
+	retry     time.Duration
+	heartbeat time.Duration
+	flag      *health.Flag
+	log       *zap.Logger
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewNRFRegistration creates the registration of profile with the NRF
+// at nrfURI, registering every retry until it succeeds and sending a
+// heartbeat every heartbeat unless the NRF asks for another period
+func NewNRFRegistration(client *Client, nrfURI string, profile models.NfProfile, retry, heartbeat time.Duration) *NRFRegistration {
+	profile.NfStatus = models.NfStatusRegistered
+	profile.HeartbeatTimer = int(heartbeat / time.Second)
+	return &NRFRegistration{
+		client:    client,
+		uri:       nrfURI + nfInstancesPath + url.PathEscape(profile.NfInstanceID),
+		profile:   profile,
+		retry:     retry,
+		heartbeat: heartbeat,
+		flag:      health.NewFlag("not registered with the NRF"),
+		log:       logger.Named("sbi"),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Check implements health.CheckFunc, failing while the NF is not
+// registered
+func (r *NRFRegistration) Check(ctx context.Context) error {
+	return r.flag.Check(ctx)
+}
+
+// Start registers in the background
+func (r *NRFRegistration) Start() {
+	go r.run()
+}
+
+// Deregister stops the heartbeats and removes the NF instance from the
+// NRF when it is registered
+func (r *NRFRegistration) Deregister(ctx context.Context) error {
+	close(r.stop)
+	<-r.done
+
+	registered := r.flag.IsUp()
+	r.flag.SetDown("deregistered from the NRF")
+	if !registered {
+		return nil
+	}
+	return r.client.Delete(ctx, r.uri)
+}
+
+// run registers and sends the heartbeats until Deregister is called
+func (r *NRFRegistration) run() {
+	defer close(r.done)
+
+	registered := false
+	for {
+		wait := r.retry
+		if registered {
+			registered = r.sendHeartbeat()
+		} else {
+			registered = r.register()
+		}
+		if registered {
+			wait = r.heartbeat
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-r.stop:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// register sends the NF profile, reporting whether the NRF accepted it
+func (r *NRFRegistration) register() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.retry)
+	defer cancel()
+
+	var rsp models.NfProfile
+	if err := r.client.Put(ctx, r.uri, r.profile, &rsp); err != nil {
+		r.log.Warn("Failed to register with the NRF", zap.String("uri", r.uri), zap.Error(err))
+		r.flag.SetDown("registration failed: " + err.Error())
+		return false
+	}
+	if rsp.HeartbeatTimer > 0 {
+		r.heartbeat = time.Duration(rsp.HeartbeatTimer) * time.Second
+	}
+	r.log.Info("Registered with the NRF", zap.String("nf_instance_id", r.profile.NfInstanceID),
+		zap.Duration("heartbeat", r.heartbeat))
+	r.flag.SetUp()
+	return true
+}
+
+// sendHeartbeat updates the status of the NF instance, reporting whether
+// the NRF still knows it
+func (r *NRFRegistration) sendHeartbeat() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), r.heartbeat)
+	defer cancel()
+
+	patch := []patchItem{{Op: "replace", Path: "/nfStatus", Value: models.NfStatusRegistered}}
+	if err := r.client.Patch(ctx, r.uri, patch, nil); err != nil {
+		r.log.Warn("NRF heartbeat failed, registering again", zap.Error(err))
+		r.flag.SetDown("heartbeat failed: " + err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package sbi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"go.uber.org/zap"
+)
+
+// Server serves the Service-Based Interface of a Network Function
+type Server struct {
+	httpServer  *http.Server
+	mux         *http.ServeMux
+	serviceName string
+	metrics     *metrics.Metrics
+}
+
+// NewServer creates a new SBI server listening on host:port. Requests
+// and connections are recorded on m when it is not nil.
+func NewServer(serviceName, host string, port int, m *metrics.Metrics) *Server {
+	s := &Server{
+		mux:         http.NewServeMux(),
+		serviceName: serviceName,
+		metrics:     m,
+	}
+
+	s.httpServer = &http.Server{
+		Addr:              net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ConnState:         s.trackConnection,
+	}
+
+	return s
+}
+
+// Handle registers a handler for the given pattern
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.instrument(handler))
+}
+
+// HandleFunc registers a handler function for the given pattern
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.Handle(pattern, http.HandlerFunc(handler))
+}
+
+// MountHealth serves the probes of r, uninstrumented so that polling
+// them does not skew the request metrics
+func (s *Server) MountHealth(r *health.Registry) {
+	r.Mount(s.mux)
+}
+
+// Start starts serving in the background
+func (s *Server) Start() {
+	go func() {
+		logger.Info("Starting SBI server", zap.String("address", s.httpServer.Addr))
+		err := s.httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			logger.Error("SBI server error", zap.Error(err))
+		}
+	}()
+}
+
+// Shutdown gracefully stops the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// instrument wraps a handler to record request metrics
+func (s *Server) instrument(handler http.Handler) http.Handler {
+	if s.metrics == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler.ServeHTTP(recorder, r)
+
+		s.metrics.RequestDuration.WithLabelValues(s.serviceName, metrics.RoleServer, r.Method).Observe(time.Since(startTime).Seconds())
+		s.metrics.RequestCounter.WithLabelValues(s.serviceName, metrics.RoleServer, r.Method, fmt.Sprintf("%d", recorder.status)).Inc()
+	})
+}
+
+// trackConnection keeps the active connections gauge up to date
+func (s *Server) trackConnection(_ net.Conn, state http.ConnState) {
+	if s.metrics == nil {
+		return
+	}
+
+	switch state {
+	case http.StateNew:
+		s.metrics.ActiveConnections.WithLabelValues(s.serviceName).Inc()
+	case http.StateHijacked, http.StateClosed:
+		s.metrics.ActiveConnections.WithLabelValues(s.serviceName).Dec()
+	}
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// WriteJSON writes body as a JSON response with the given status
+func WriteJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			logger.Warn("Failed to encode response", zap.Error(err))
+		}
+	}
+}
+
+// WriteError writes an error as a JSON response. Application errors keep
+// their status code, anything else is reported as an internal error.
+func WriteError(w http.ResponseWriter, err error) {
+	appErr, ok := err.(errors.AppError)
+	if !ok {
+		appErr = errors.NewInternalError("Internal error", err)
+	}
+
+	WriteJSON(w, appErr.StatusCode(), map[string]string{
+		"type":    string(appErr.Type),
+		"message": appErr.Message,
+	})
+}
@@ -0,0 +1,94 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+)
+
+// nea2 is 128-NEA2: AES in counter mode (TS 33.501 D.2.1.3)
+func nea2(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) []byte {
+	block, _ := aes.NewCipher(key) // the key size is checked by NEA
+
+	var iv [aes.BlockSize]byte
+	binary.BigEndian.PutUint32(iv[0:], count)
+	iv[4] = bearer<<3 | direction<<2
+
+	n := byteLen(bits)
+	out := make([]byte, n)
+	cipher.NewCTR(block, iv[:]).XORKeyStream(out, data[:n])
+	maskTail(out, bits)
+	return out
+}
+
+// nia2 is 128-NIA2: AES-CMAC over COUNT, BEARER and DIRECTION followed
+// by the message, truncated to 32 bits (TS 33.501 D.3.1.3)
+func nia2(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) [4]byte {
+	block, _ := aes.NewCipher(key)
+
+	m := make([]byte, 8, 8+byteLen(bits))
+	binary.BigEndian.PutUint32(m[0:], count)
+	m[4] = bearer<<3 | direction<<2
+	m = append(m, data[:byteLen(bits)]...)
+
+	var mac [4]byte
+	copy(mac[:], cmac(block, m, 64+bits))
+	return mac
+}
+
+// cmac computes the AES-CMAC of a bit string (NIST SP 800-38B)
+func cmac(block cipher.Block, msg []byte, bits uint) []byte {
+	const bs = aes.BlockSize
+
+	// Subkeys K1 and K2 from the encrypted zero block
+	k1 := make([]byte, bs)
+	block.Encrypt(k1, k1)
+	shiftSubkey(k1)
+	k2 := append([]byte(nil), k1...)
+	shiftSubkey(k2)
+
+	// The last block is XORed with K1 when complete, or padded with a one
+	// bit and zeros and XORed with K2
+	n := (bits + bs*8 - 1) / (bs * 8)
+	complete := n > 0 && bits%(bs*8) == 0
+	if n == 0 {
+		n = 1
+	}
+	last := make([]byte, bs)
+	start := (n - 1) * bs
+	copy(last, msg[start:byteLen(bits)])
+	if complete {
+		xorBytes(last, k1)
+	} else {
+		rem := bits - start*8
+		maskTail(last, rem)
+		last[rem/8] |= 0x80 >> (rem % 8)
+		xorBytes(last, k2)
+	}
+
+	x := make([]byte, bs)
+	for i := uint(0); i < n-1; i++ {
+		xorBytes(x, msg[i*bs:(i+1)*bs])
+		block.Encrypt(x, x)
+	}
+	xorBytes(x, last)
+	block.Encrypt(x, x)
+	return x
+}
+
+// shiftSubkey derives the next CMAC subkey in place: a left shift by one
+// bit, reduced by the block polynomial
+func shiftSubkey(k []byte) {
+	msb := k[0] >> 7
+	for i := 0; i < len(k)-1; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[len(k)-1] = k[len(k)-1]<<1 ^ 0x87*msb
+}
+
+// xorBytes XORs src into dst
+func xorBytes(dst, src []byte) {
+	for i := range src {
+		dst[i] ^= src[i]
+	}
+}
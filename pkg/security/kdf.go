@@ -0,0 +1,112 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+)
+
+// FC values of the key derivations (TS 33.501 A.1)
+const (
+	fcKAUSF        = 0x6a
+	fcRESStar      = 0x6b
+	fcKSEAF        = 0x6c
+	fcKAMF         = 0x6d
+	fcAlgorithmKey = 0x69
+	fcKgNB         = 0x6e
+	fcNH           = 0x6f
+)
+
+// Algorithm type distinguishers of AlgorithmKey (TS 33.501 A.8)
+const (
+	NASEncAlg uint8 = 0x01
+	NASIntAlg uint8 = 0x02
+	RRCEncAlg uint8 = 0x03
+	RRCIntAlg uint8 = 0x04
+	UPEncAlg  uint8 = 0x05
+	UPIntAlg  uint8 = 0x06
+)
+
+// Access type distinguishers of KgNB (TS 33.501 A.9)
+const (
+	Access3GPP    uint8 = 0x01
+	AccessNon3GPP uint8 = 0x02
+)
+
+// KDF is the generic key derivation function of TS 33.220 B.2.2:
+// HMAC-SHA-256 over FC || P0 || L0 || P1 || L1 ...
+func KDF(key []byte, fc byte, params ...[]byte) []byte {
+	s := []byte{fc}
+	for _, p := range params {
+		s = append(s, p...)
+		s = binary.BigEndian.AppendUint16(s, uint16(len(p)))
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(s)
+	return mac.Sum(nil)
+}
+
+// KAUSF derives the AUSF key from CK and IK after 5G AKA (TS 33.501
+// A.2). sqnXorAK is the first 6 octets of AUTN.
+func KAUSF(ck, ik []byte, servingNetworkName string, sqnXorAK []byte) []byte {
+	return KDF(concat(ck, ik), fcKAUSF, []byte(servingNetworkName), sqnXorAK)
+}
+
+// RESStar derives RES* or XRES* from CK, IK and RES (TS 33.501 A.4)
+func RESStar(ck, ik []byte, servingNetworkName string, rand, res []byte) []byte {
+	return KDF(concat(ck, ik), fcRESStar, []byte(servingNetworkName), rand, res)[16:]
+}
+
+// HXRESStar hashes RES* or XRES* for the serving network (TS 33.501
+// A.5)
+func HXRESStar(rand, resStar []byte) []byte {
+	h := sha256.Sum256(concat(rand, resStar))
+	return h[16:]
+}
+
+// KSEAF derives the anchor key of the serving network (TS 33.501 A.6)
+func KSEAF(kausf []byte, servingNetworkName string) []byte {
+	return KDF(kausf, fcKSEAF, []byte(servingNetworkName))
+}
+
+// KAMF derives the key of the serving AMF (TS 33.501 A.7). The SUPI is
+// given with or without its "imsi-" or "nai-" type prefix; an IMSI enters
+// the derivation as its digits.
+func KAMF(kseaf []byte, supi string, abba []byte) []byte {
+	return KDF(kseaf, fcKAMF, []byte(supiValue(supi)), abba)
+}
+
+// AlgorithmKey derives a NAS, RRC or UP protection key for an algorithm
+// (TS 33.501 A.8). The key is the 128 low bits of the derivation.
+func AlgorithmKey(key []byte, distinguisher, alg uint8) []byte {
+	return KDF(key, fcAlgorithmKey, []byte{distinguisher}, []byte{alg})[16:]
+}
+
+// KgNB derives the gNB key from KAMF and the uplink NAS COUNT of the
+// message starting the AS security (TS 33.501 A.9)
+func KgNB(kamf []byte, ulCount uint32, accessType uint8) []byte {
+	return KDF(kamf, fcKgNB, binary.BigEndian.AppendUint32(nil, ulCount), []byte{accessType})
+}
+
+// NH derives the next hop key from KAMF and the previous KgNB or NH
+// (TS 33.501 A.10)
+func NH(kamf, syncInput []byte) []byte {
+	return KDF(kamf, fcNH, syncInput)
+}
+
+// supiValue strips the type prefix of a SUPI
+func supiValue(supi string) string {
+	for _, prefix := range []string{"imsi-", "nai-"} {
+		if strings.HasPrefix(supi, prefix) {
+			return supi[len(prefix):]
+		}
+	}
+	return supi
+}
+
+// concat returns a || b in a new slice
+func concat(a, b []byte) []byte {
+	return append(append(make([]byte, 0, len(a)+len(b)), a...), b...)
+}
@@ -0,0 +1,126 @@
+package security
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+)
+
+// hmacSHA256 is the reference derivation of the tests over a hand built
+// input string S
+func hmacSHA256(key, s []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(s)
+	return mac.Sum(nil)
+}
+
+// TestKeyHierarchy derives the 5G key hierarchy from CK and IK down to
+// KgNB and NH, checking each key against HMAC-SHA-256 over the input
+// string S laid out octet by octet as in TS 33.501 A.2 to A.10
+func TestKeyHierarchy(t *testing.T) {
+	ck := unhex(t, "b40ba9a3 c58b2a05 bbf0d987 b21bf8cb")
+	ik := unhex(t, "f769bcd7 51044604 12767271 1c6d3441")
+	rand := unhex(t, "23553cbe 9637a89d 218ae64d ae47bf35")
+	sqnXorAK := unhex(t, "55f328b4 3577")
+	res := unhex(t, "a54211d5 e3ba50bf")
+	abba := []byte{0x00, 0x00}
+	const snn = "5G:mnc093.mcc208.3gppnetwork.org"
+	const ulCount = 0x00000102
+
+	// P0 = serving network name, L0 = 32
+	snnP := append([]byte(snn), 0x00, 0x20)
+
+	kausf := KAUSF(ck, ik, snn, sqnXorAK)
+	resStar := RESStar(ck, ik, snn, rand, res)
+	kseaf := KSEAF(kausf, snn)
+	kamf := KAMF(kseaf, "imsi-208930000000001", abba)
+	knasInt := AlgorithmKey(kamf, NASIntAlg, AlgAES)
+	kgnb := KgNB(kamf, ulCount, Access3GPP)
+
+	tests := []struct {
+		name string
+		got  []byte
+		key  []byte
+		s    [][]byte
+		// low keeps the 128 low bits of the derivation
+		low bool
+	}{
+		{
+			name: "KAUSF",
+			got:  kausf,
+			key:  concat(ck, ik),
+			s:    [][]byte{{0x6a}, snnP, sqnXorAK, {0x00, 0x06}},
+		},
+		{
+			name: "RES*",
+			got:  resStar,
+			key:  concat(ck, ik),
+			s:    [][]byte{{0x6b}, snnP, rand, {0x00, 0x10}, res, {0x00, 0x08}},
+			low:  true,
+		},
+		{
+			name: "KSEAF",
+			got:  kseaf,
+			key:  kausf,
+			s:    [][]byte{{0x6c}, snnP},
+		},
+		{
+			name: "KAMF",
+			got:  kamf,
+			key:  kseaf,
+			s:    [][]byte{{0x6d}, []byte("208930000000001"), {0x00, 0x0f}, abba, {0x00, 0x02}},
+		},
+		{
+			name: "KNASenc",
+			got:  AlgorithmKey(kamf, NASEncAlg, AlgZUC),
+			key:  kamf,
+			s:    [][]byte{{0x69, 0x01, 0x00, 0x01, 0x03, 0x00, 0x01}},
+			low:  true,
+		},
+		{
+			name: "KNASint",
+			got:  knasInt,
+			key:  kamf,
+			s:    [][]byte{{0x69, 0x02, 0x00, 0x01, 0x02, 0x00, 0x01}},
+			low:  true,
+		},
+		{
+			name: "KgNB",
+			got:  kgnb,
+			key:  kamf,
+			s:    [][]byte{{0x6e, 0x00, 0x00, 0x01, 0x02, 0x00, 0x04, 0x01, 0x00, 0x01}},
+		},
+		{
+			name: "NH",
+			got:  NH(kamf, kgnb),
+			key:  kamf,
+			s:    [][]byte{{0x6f}, kgnb, {0x00, 0x20}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := hmacSHA256(tt.key, bytes.Join(tt.s, nil))
+			if tt.low {
+				want = want[16:]
+			}
+			if !bytes.Equal(tt.got, want) {
+				t.Errorf("%s = %x, want %x", tt.name, tt.got, want)
+			}
+		})
+	}
+}
+
+func TestKAMFSUPI(t *testing.T) {
+	kseaf := bytes.Repeat([]byte{0x44}, 32)
+	abba := []byte{0x00, 0x00}
+	want := KAMF(kseaf, "208930000000001", abba)
+
+	// The type prefix of an IMSI does not enter the derivation
+	if got := KAMF(kseaf, "imsi-208930000000001", abba); !bytes.Equal(got, want) {
+		t.Errorf("KAMF of the prefixed SUPI = %x, want %x", got, want)
+	}
+	if got := KAMF(kseaf, "imsi-208930000000002", abba); bytes.Equal(got, want) {
+		t.Error("KAMF does not depend on the SUPI")
+	}
+}
@@ -0,0 +1,234 @@
+package security
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// unhex decodes a test vector written with spaces between words
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad test vector %q: %v", s, err)
+	}
+	return b
+}
+
+// Test sets of 128-EEA1 to 3 (TS 33.401 C.1, C.4; EEA3 and EIA3 test
+// data, ETSI/SAGE specification 3)
+var neaTests = []struct {
+	name      string
+	alg       uint8
+	key       string
+	count     uint32
+	bearer    uint8
+	direction uint8
+	bits      uint
+	plain     string
+	cipher    string
+}{
+	{
+		name:      "128-EEA1 test set 1",
+		alg:       AlgSNOW3G,
+		key:       "d3c5d592 327fb11c 4035c668 0af8c6d1",
+		count:     0x398a59b4,
+		bearer:    0x15,
+		direction: 1,
+		bits:      253,
+		plain:     "981ba682 4c1bfb1a b4854720 29b71d80 8ce33e2c c3c0b5fc 1f3de8a6 dc66b1f0",
+		cipher:    "5d5bfe75 eb04f68c e0a12377 ea00b37d 47c6a0ba 06309155 086a859c 4341b378",
+	},
+	{
+		name:      "128-EEA2 test set 1",
+		alg:       AlgAES,
+		key:       "d3c5d592 327fb11c 4035c668 0af8c6d1",
+		count:     0x398a59b4,
+		bearer:    0x15,
+		direction: 1,
+		bits:      253,
+		plain:     "981ba682 4c1bfb1a b4854720 29b71d80 8ce33e2c c3c0b5fc 1f3de8a6 dc66b1f0",
+		cipher:    "e9fed8a6 3d155304 d71df20b f3e82214 b20ed7da d2f233dc 3c22d7bd eeed8e78",
+	},
+	{
+		name:      "128-EEA3 test set 1",
+		alg:       AlgZUC,
+		key:       "173d14ba 5003731d 7a600494 70f00a29",
+		count:     0x66035492,
+		bearer:    0x0f,
+		direction: 0,
+		bits:      193,
+		plain:     "6cf65340 735552ab 0c9752fa 6f9025fe 0bd675d9 005875b2 00000000",
+		cipher:    "a6c85fc6 6afb8533 aafc2518 dfe78494 0ee1e4b0 30238cc8 00000000",
+	},
+}
+
+func TestNEA(t *testing.T) {
+	ciphers := map[uint8]func(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) []byte{
+		AlgSNOW3G: nea1,
+		AlgAES:    nea2,
+		AlgZUC:    nea3,
+	}
+	for _, tt := range neaTests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, plain, want := unhex(t, tt.key), unhex(t, tt.plain), unhex(t, tt.cipher)
+			n := byteLen(tt.bits)
+			nea := ciphers[tt.alg]
+
+			got := nea(key, tt.count, tt.bearer, tt.direction, plain, tt.bits)
+			if !bytes.Equal(got, want[:n]) {
+				t.Errorf("ciphertext = %x, want %x", got, want[:n])
+			}
+			got = nea(key, tt.count, tt.bearer, tt.direction, want, tt.bits)
+			if !bytes.Equal(got, plain[:n]) {
+				t.Errorf("deciphered = %x, want %x", got, plain[:n])
+			}
+		})
+	}
+}
+
+// Test sets of 128-EIA2 and 3 (TS 33.401 C.2; EEA3 and EIA3 test data,
+// ETSI/SAGE specification 3). 128-EIA1 runs on the SNOW 3G keystream
+// checked by the 128-EEA1 and SNOW 3G test sets.
+var niaTests = []struct {
+	name      string
+	alg       uint8
+	key       string
+	count     uint32
+	bearer    uint8
+	direction uint8
+	bits      uint
+	msg       string
+	mac       string
+}{
+	{
+		name:      "128-EIA2 test set 1",
+		alg:       AlgAES,
+		key:       "2bd6459f 82c5b300 952c4910 4881ff48",
+		count:     0x38a6f056,
+		bearer:    0x18,
+		direction: 0,
+		bits:      58,
+		msg:       "33323462 63393840",
+		mac:       "118c6eb8",
+	},
+	{
+		name:      "128-EIA2 test set 2",
+		alg:       AlgAES,
+		key:       "d3c5d592 327fb11c 4035c668 0af8c6d1",
+		count:     0x398a59b4,
+		bearer:    0x1a,
+		direction: 1,
+		bits:      64,
+		msg:       "484583d5 afe082ae",
+		mac:       "b93787e6",
+	},
+	{
+		name:      "128-EIA3 test set 1",
+		alg:       AlgZUC,
+		key:       "00000000 00000000 00000000 00000000",
+		count:     0,
+		bearer:    0,
+		direction: 0,
+		bits:      1,
+		msg:       "00000000",
+		mac:       "c8a9595e",
+	},
+	{
+		name:      "128-EIA3 test set 2",
+		alg:       AlgZUC,
+		key:       "47054125 561eb2dd a94059da 05097850",
+		count:     0x561eb2dd,
+		bearer:    0x14,
+		direction: 0,
+		bits:      90,
+		msg:       "00000000 00000000 00000000",
+		mac:       "6719a088",
+	},
+}
+
+func TestNIA(t *testing.T) {
+	macs := map[uint8]func(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) [4]byte{
+		AlgAES: nia2,
+		AlgZUC: nia3,
+	}
+	for _, tt := range niaTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := macs[tt.alg](unhex(t, tt.key), tt.count, tt.bearer, tt.direction, unhex(t, tt.msg), tt.bits)
+			if want := unhex(t, tt.mac); !bytes.Equal(got[:], want) {
+				t.Errorf("MAC = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+func TestKeystreamGenerators(t *testing.T) {
+	tests := []struct {
+		name string
+		gen  func() (uint32, uint32)
+		want [2]uint32
+	}{
+		{
+			// ZUC specification (ETSI/SAGE specification 4) test set 1
+			name: "ZUC zero key and IV",
+			gen: func() (uint32, uint32) {
+				z := newZUC(make([]byte, 16), make([]byte, 16))
+				return z.word(), z.word()
+			},
+			want: [2]uint32{0x27bede74, 0x018082da},
+		},
+		{
+			// ZUC specification test set 2
+			name: "ZUC all ones key and IV",
+			gen: func() (uint32, uint32) {
+				z := newZUC(bytes.Repeat([]byte{0xff}, 16), bytes.Repeat([]byte{0xff}, 16))
+				return z.word(), z.word()
+			},
+			want: [2]uint32{0x0657cfa0, 0x7096398b},
+		},
+		{
+			// SNOW 3G specification (ETSI/SAGE specification 4) test set 1
+			name: "SNOW 3G",
+			gen: func() (uint32, uint32) {
+				g := newSNOW3G([4]uint32{0x2bd6459f, 0x82c5b300, 0x952c4910, 0x4881ff48},
+					[4]uint32{0xea024714, 0xad5c4d84, 0xdf1f9b25, 0x1c0bf45f})
+				return g.word(), g.word()
+			},
+			want: [2]uint32{0xabee9704, 0x7ac31373},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if z1, z2 := tt.gen(); z1 != tt.want[0] || z2 != tt.want[1] {
+				t.Errorf("keystream = %08x %08x, want %08x %08x", z1, z2, tt.want[0], tt.want[1])
+			}
+		})
+	}
+}
+
+func TestNullAndInvalidAlgorithms(t *testing.T) {
+	data := []byte("NAS message")
+	key := make([]byte, keySize)
+
+	if got, err := NEA(AlgNull, nil, 1, 0, DirectionUplink, data); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("NEA0 = %q, %v, want the data unchanged", got, err)
+	}
+	if got, err := NIA(AlgNull, nil, 1, 0, DirectionUplink, data); err != nil || got != [4]byte{} {
+		t.Errorf("NIA0 = %x, %v, want a zero MAC", got, err)
+	}
+	if _, err := NEA(AlgAES, key[:8], 1, 0, DirectionUplink, data); err == nil {
+		t.Error("NEA2 accepted a 64-bit key")
+	}
+	if _, err := NIA(AlgZUC, key[:8], 1, 0, DirectionUplink, data); err == nil {
+		t.Error("NIA3 accepted a 64-bit key")
+	}
+	if _, err := NEA(4, key, 1, 0, DirectionUplink, data); err == nil {
+		t.Error("NEA4 accepted")
+	}
+	if _, err := NIA(4, key, 1, 0, DirectionUplink, data); err == nil {
+		t.Error("NIA4 accepted")
+	}
+}
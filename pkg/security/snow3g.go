@@ -0,0 +1,212 @@
+package security
+
+import "encoding/binary"
+
+// sr is the Rijndael S-box used by S1 (TS 35.216 3.3.1)
+var sr = [256]byte{
+	0x63, 0x7c, 0x77, 0x7b, 0xf2, 0x6b, 0x6f, 0xc5, 0x30, 0x01, 0x67, 0x2b, 0xfe, 0xd7, 0xab, 0x76,
+	0xca, 0x82, 0xc9, 0x7d, 0xfa, 0x59, 0x47, 0xf0, 0xad, 0xd4, 0xa2, 0xaf, 0x9c, 0xa4, 0x72, 0xc0,
+	0xb7, 0xfd, 0x93, 0x26, 0x36, 0x3f, 0xf7, 0xcc, 0x34, 0xa5, 0xe5, 0xf1, 0x71, 0xd8, 0x31, 0x15,
+	0x04, 0xc7, 0x23, 0xc3, 0x18, 0x96, 0x05, 0x9a, 0x07, 0x12, 0x80, 0xe2, 0xeb, 0x27, 0xb2, 0x75,
+	0x09, 0x83, 0x2c, 0x1a, 0x1b, 0x6e, 0x5a, 0xa0, 0x52, 0x3b, 0xd6, 0xb3, 0x29, 0xe3, 0x2f, 0x84,
+	0x53, 0xd1, 0x00, 0xed, 0x20, 0xfc, 0xb1, 0x5b, 0x6a, 0xcb, 0xbe, 0x39, 0x4a, 0x4c, 0x58, 0xcf,
+	0xd0, 0xef, 0xaa, 0xfb, 0x43, 0x4d, 0x33, 0x85, 0x45, 0xf9, 0x02, 0x7f, 0x50, 0x3c, 0x9f, 0xa8,
+	0x51, 0xa3, 0x40, 0x8f, 0x92, 0x9d, 0x38, 0xf5, 0xbc, 0xb6, 0xda, 0x21, 0x10, 0xff, 0xf3, 0xd2,
+	0xcd, 0x0c, 0x13, 0xec, 0x5f, 0x97, 0x44, 0x17, 0xc4, 0xa7, 0x7e, 0x3d, 0x64, 0x5d, 0x19, 0x73,
+	0x60, 0x81, 0x4f, 0xdc, 0x22, 0x2a, 0x90, 0x88, 0x46, 0xee, 0xb8, 0x14, 0xde, 0x5e, 0x0b, 0xdb,
+	0xe0, 0x32, 0x3a, 0x0a, 0x49, 0x06, 0x24, 0x5c, 0xc2, 0xd3, 0xac, 0x62, 0x91, 0x95, 0xe4, 0x79,
+	0xe7, 0xc8, 0x37, 0x6d, 0x8d, 0xd5, 0x4e, 0xa9, 0x6c, 0x56, 0xf4, 0xea, 0x65, 0x7a, 0xae, 0x08,
+	0xba, 0x78, 0x25, 0x2e, 0x1c, 0xa6, 0xb4, 0xc6, 0xe8, 0xdd, 0x74, 0x1f, 0x4b, 0xbd, 0x8b, 0x8a,
+	0x70, 0x3e, 0xb5, 0x66, 0x48, 0x03, 0xf6, 0x0e, 0x61, 0x35, 0x57, 0xb9, 0x86, 0xc1, 0x1d, 0x9e,
+	0xe1, 0xf8, 0x98, 0x11, 0x69, 0xd9, 0x8e, 0x94, 0x9b, 0x1e, 0x87, 0xe9, 0xce, 0x55, 0x28, 0xdf,
+	0x8c, 0xa1, 0x89, 0x0d, 0xbf, 0xe6, 0x42, 0x68, 0x41, 0x99, 0x2d, 0x0f, 0xb0, 0x54, 0xbb, 0x16,
+}
+
+// sq is the S-box derived from the Dickson polynomial used by S2 (TS
+// 35.216 3.3.2)
+var sq = [256]byte{
+	0x25, 0x24, 0x73, 0x67, 0xd7, 0xae, 0x5c, 0x30, 0xa4, 0xee, 0x6e, 0xcb, 0x7d, 0xb5, 0x82, 0xdb,
+	0xe4, 0x8e, 0x48, 0x49, 0x4f, 0x5d, 0x6a, 0x78, 0x70, 0x88, 0xe8, 0x5f, 0x5e, 0x84, 0x65, 0xe2,
+	0xd8, 0xe9, 0xcc, 0xed, 0x40, 0x2f, 0x11, 0x28, 0x57, 0xd2, 0xac, 0xe3, 0x4a, 0x15, 0x1b, 0xb9,
+	0xb2, 0x80, 0x85, 0xa6, 0x2e, 0x02, 0x47, 0x29, 0x07, 0x4b, 0x0e, 0xc1, 0x51, 0xaa, 0x89, 0xd4,
+	0xca, 0x01, 0x46, 0xb3, 0xef, 0xdd, 0x44, 0x7b, 0xc2, 0x7f, 0xbe, 0xc3, 0x9f, 0x20, 0x4c, 0x64,
+	0x83, 0xa2, 0x68, 0x42, 0x13, 0xb4, 0x41, 0xcd, 0xba, 0xc6, 0xbb, 0x6d, 0x4d, 0x71, 0x21, 0xf4,
+	0x8d, 0xb0, 0xe5, 0x93, 0xfe, 0x8f, 0xe6, 0xcf, 0x43, 0x45, 0x31, 0x22, 0x37, 0x36, 0x96, 0xfa,
+	0xbc, 0x0f, 0x08, 0x52, 0x1d, 0x55, 0x1a, 0xc5, 0x4e, 0x23, 0x69, 0x7a, 0x92, 0xff, 0x5b, 0x5a,
+	0xeb, 0x9a, 0x1c, 0xa9, 0xd1, 0x7e, 0x0d, 0xfc, 0x50, 0x8a, 0xb6, 0x62, 0xf5, 0x0a, 0xf8, 0xdc,
+	0x03, 0x3c, 0x0c, 0x39, 0xf1, 0xb8, 0xf3, 0x3d, 0xf2, 0xd5, 0x97, 0x66, 0x81, 0x32, 0xa0, 0x00,
+	0x06, 0xce, 0xf6, 0xea, 0xb7, 0x17, 0xf7, 0x8c, 0x79, 0xd6, 0xa7, 0xbf, 0x8b, 0x3f, 0x1f, 0x53,
+	0x63, 0x75, 0x35, 0x2c, 0x60, 0xfd, 0x27, 0xd3, 0x94, 0xa5, 0x7c, 0xa1, 0x05, 0x58, 0x2d, 0xbd,
+	0xd9, 0xc7, 0xaf, 0x6b, 0x54, 0x0b, 0xe0, 0x38, 0x04, 0xc8, 0x9d, 0xe7, 0x14, 0xb1, 0x87, 0x9c,
+	0xdf, 0x6f, 0xf9, 0xda, 0x2a, 0xc4, 0x59, 0x16, 0x74, 0x91, 0xab, 0x26, 0x61, 0x76, 0x34, 0x2b,
+	0xad, 0x99, 0xfb, 0x72, 0xec, 0x33, 0x12, 0xde, 0x98, 0x3b, 0xc0, 0x9b, 0x3e, 0x18, 0x10, 0x3a,
+	0x56, 0xe1, 0x77, 0xc9, 0x1e, 0x9e, 0x95, 0xa3, 0x90, 0x19, 0xa8, 0x6c, 0x09, 0xd0, 0xf0, 0x86,
+}
+
+// mulAlpha and divAlpha multiply and divide a byte by alpha in the LFSR
+// field (TS 35.216 3.4.2, 3.4.3)
+var mulAlpha, divAlpha [256]uint32
+
+func init() {
+	for c := 0; c < 256; c++ {
+		b := uint8(c)
+		mulAlpha[c] = uint32(mulxPow(b, 23, 0xa9))<<24 | uint32(mulxPow(b, 245, 0xa9))<<16 |
+			uint32(mulxPow(b, 48, 0xa9))<<8 | uint32(mulxPow(b, 239, 0xa9))
+		divAlpha[c] = uint32(mulxPow(b, 16, 0xa9))<<24 | uint32(mulxPow(b, 39, 0xa9))<<16 |
+			uint32(mulxPow(b, 6, 0xa9))<<8 | uint32(mulxPow(b, 64, 0xa9))
+	}
+}
+
+// mulx multiplies v by x in GF(2^8) with the reduction byte c (TS 35.216
+// 3.1.1)
+func mulx(v, c uint8) uint8 {
+	if v&0x80 != 0 {
+		return v<<1 ^ c
+	}
+	return v << 1
+}
+
+// mulxPow multiplies v by x^i (TS 35.216 3.1.2)
+func mulxPow(v uint8, i int, c uint8) uint8 {
+	for ; i > 0; i-- {
+		v = mulx(v, c)
+	}
+	return v
+}
+
+// snow3G is the SNOW 3G keystream generator (TS 35.216)
+type snow3G struct {
+	s          [16]uint32
+	r1, r2, r3 uint32
+}
+
+// newSNOW3G initialises the generator with a key and IV given as words
+// k0..k3 and iv0..iv3 (TS 35.216 4.1)
+func newSNOW3G(k, iv [4]uint32) *snow3G {
+	const ones = 0xffffffff
+	g := &snow3G{s: [16]uint32{
+		k[0] ^ ones, k[1] ^ ones, k[2] ^ ones, k[3] ^ ones,
+		k[0], k[1], k[2], k[3],
+		k[0] ^ ones, k[1] ^ ones ^ iv[3], k[2] ^ ones ^ iv[2], k[3] ^ ones,
+		k[0] ^ iv[1], k[1], k[2], k[3] ^ iv[0],
+	}}
+
+	for i := 0; i < 32; i++ {
+		g.clockLFSR(g.clockFSM())
+	}
+	g.clockFSM()
+	g.clockLFSR(0)
+	return g
+}
+
+// word returns the next keystream word
+func (g *snow3G) word() uint32 {
+	z := g.clockFSM() ^ g.s[0]
+	g.clockLFSR(0)
+	return z
+}
+
+// clockLFSR shifts the LFSR, mixing f in during initialisation
+func (g *snow3G) clockLFSR(f uint32) {
+	s0, s11 := g.s[0], g.s[11]
+	v := s0<<8 ^ mulAlpha[s0>>24] ^ g.s[2] ^ s11>>8 ^ divAlpha[s11&0xff] ^ f
+	copy(g.s[:], g.s[1:])
+	g.s[15] = v
+}
+
+// clockFSM clocks the finite state machine and returns its output F
+func (g *snow3G) clockFSM() uint32 {
+	f := (g.s[15] + g.r1) ^ g.r2
+	r := g.r2 + (g.r3 ^ g.s[5])
+	g.r3 = sbox32(g.r2, &sq, 0x69)
+	g.r2 = sbox32(g.r1, &sr, 0x1b)
+	g.r1 = r
+	return f
+}
+
+// sbox32 is S1 or S2: the S-box on each byte followed by a MixColumn
+// (TS 35.216 3.3.1, 3.3.2)
+func sbox32(w uint32, box *[256]byte, c uint8) uint32 {
+	w0, w1, w2, w3 := box[w>>24], box[w>>16&0xff], box[w>>8&0xff], box[w&0xff]
+	r0 := mulx(w0, c) ^ w1 ^ w2 ^ mulx(w3, c) ^ w3
+	r1 := mulx(w0, c) ^ w0 ^ mulx(w1, c) ^ w2 ^ w3
+	r2 := w0 ^ mulx(w1, c) ^ w1 ^ mulx(w2, c) ^ w3
+	r3 := w0 ^ w1 ^ mulx(w2, c) ^ w2 ^ mulx(w3, c)
+	return uint32(r0)<<24 | uint32(r1)<<16 | uint32(r2)<<8 | uint32(r3)
+}
+
+// snow3GKey splits a 128-bit key into k0..k3, k3 holding the first
+// octets (TS 35.215 3.4)
+func snow3GKey(key []byte) [4]uint32 {
+	return [4]uint32{
+		binary.BigEndian.Uint32(key[12:]),
+		binary.BigEndian.Uint32(key[8:]),
+		binary.BigEndian.Uint32(key[4:]),
+		binary.BigEndian.Uint32(key[0:]),
+	}
+}
+
+// nea1 is 128-NEA1: the UEA2 keystream over the data (TS 33.501
+// D.2.1.2, TS 35.215 3.4)
+func nea1(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) []byte {
+	bd := uint32(bearer)<<27 | uint32(direction)<<26
+	g := newSNOW3G(snow3GKey(key), [4]uint32{bd, count, bd, count})
+
+	n := byteLen(bits)
+	out := make([]byte, n)
+	var z [4]byte
+	for i := uint(0); i < n; i++ {
+		if i%4 == 0 {
+			binary.BigEndian.PutUint32(z[:], g.word())
+		}
+		out[i] = data[i] ^ z[i%4]
+	}
+	maskTail(out, bits)
+	return out
+}
+
+// nia1 is 128-NIA1: the UIA2 MAC with the bearer as FRESH (TS 33.501
+// D.3.1.2, TS 35.215 4.4)
+func nia1(key []byte, count uint32, bearer, direction uint8, data []byte, bits uint) [4]byte {
+	fresh := uint32(bearer) << 27
+	d := uint32(direction)
+	g := newSNOW3G(snow3GKey(key), [4]uint32{fresh ^ d<<15, count ^ d<<31, fresh, count})
+
+	z1, z2, z3, z4, z5 := g.word(), g.word(), g.word(), g.word(), g.word()
+	p := uint64(z1)<<32 | uint64(z2)
+	q := uint64(z3)<<32 | uint64(z4)
+
+	// The message as 64-bit blocks, the last one zero padded
+	var eval uint64
+	for i := uint(0); i < (bits+63)/64; i++ {
+		var block [8]byte
+		copy(block[:], data[i*8:min(byteLen(bits), i*8+8)])
+		m := binary.BigEndian.Uint64(block[:])
+		if rem := bits - i*64; rem < 64 {
+			m &= ^uint64(0) << (64 - rem)
+		}
+		eval = mul64(eval^m, p)
+	}
+	eval = mul64(eval^uint64(bits), q)
+
+	var mac [4]byte
+	binary.BigEndian.PutUint32(mac[:], uint32(eval>>32)^z5)
+	return mac
+}
+
+// mul64 multiplies in GF(2^64) with the polynomial x^64 + x^4 + x^3 +
+// x + 1 (TS 35.215 4.3)
+func mul64(v, p uint64) uint64 {
+	var r uint64
+	for i := 0; i < 64; i++ {
+		if p>>i&1 != 0 {
+			r ^= v
+		}
+		if v>>63 != 0 {
+			v = v<<1 ^ 0x1b
+		} else {
+			v <<= 1
+		}
+	}
+	return r
+}
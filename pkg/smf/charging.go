@@ -0,0 +1,606 @@
+package smf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// Charging sinks
+const (
+	ChargingSinkNone  = "none"
+	ChargingSinkFile  = "file"
+	ChargingSinkLocal = "local"
+)
+
+// ChargingSink receives the charging data of the PDU sessions through
+// the operations of Nchf_ConvergedCharging (TS 32.291)
+type ChargingSink interface {
+	// Create opens the charging data of a PDU session and returns their
+	// reference
+	Create(ctx context.Context, req *models.ChargingDataRequest) (string, error)
+
+	// Update reports the usage of a PDU session
+	Update(ctx context.Context, ref string, req *models.ChargingDataRequest) error
+
+	// Release reports the final usage of a PDU session and closes its
+	// charging data
+	Release(ctx context.Context, ref string, req *models.ChargingDataRequest) error
+}
+
+// NewChargingSink creates the configured charging sink, nil when the PDU
+// sessions are not charged
+func NewChargingSink(cfg *Config) (ChargingSink, error) {
+	switch cfg.ChargingSink {
+	case "", ChargingSinkNone:
+		return nil, nil
+	case ChargingSinkFile:
+		return &fileSink{path: cfg.CDRFile}, nil
+	case ChargingSinkLocal:
+		return NewLocalCHF(), nil
+	default:
+		return nil, fmt.Errorf("charging sink %s not supported", cfg.ChargingSink)
+	}
+}
+
+// RatingGroupUsage is the traffic charged to a rating group
+type RatingGroupUsage struct {
+	RatingGroup uint32 `json:"ratingGroup"`
+
+	// Octets of traffic
+	TotalVolume    uint64 `json:"totalVolume"`
+	UplinkVolume   uint64 `json:"uplinkVolume"`
+	DownlinkVolume uint64 `json:"downlinkVolume"`
+
+	// Seconds of traffic
+	Time uint32 `json:"time"`
+}
+
+// add adds the usage of a container
+func (u *RatingGroupUsage) add(c models.UsedUnitContainer) {
+	u.TotalVolume += c.TotalVolume
+	u.UplinkVolume += c.UplinkVolume
+	u.DownlinkVolume += c.DownlinkVolume
+	u.Time += c.Time
+}
+
+// sortedUsage returns the usage of rating groups in order
+func sortedUsage(m map[uint32]*RatingGroupUsage) []RatingGroupUsage {
+	usage := make([]RatingGroupUsage, 0, len(m))
+	for _, u := range m {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].RatingGroup < usage[j].RatingGroup })
+	return usage
+}
+
+// chargingSession is the charging of a PDU session: the usage reported
+// by the UPF anchoring it, added up by rating group, and the requests
+// sent to the charging sink
+type chargingSession struct {
+	id   uint32 // charging ID
+	seid uint64 // local SEID of the N4 session reporting usage
+	supi string
+	log  *zap.Logger
+
+	mu   sync.Mutex
+	info models.PDUSessionChargingInformation
+
+	// ref is the reference of the charging data in the sink, empty until
+	// they are created
+	ref string
+
+	// invocations and containers number the requests and the used unit
+	// containers sent
+	invocations uint32
+	containers  uint32
+
+	// ratingGroups holds the rating group of each QoS rule
+	defaultRatingGroup uint32
+	ratingGroups       map[uint8]uint32
+
+	// usage holds the usage of each rating group, and pending the
+	// containers not sent yet
+	usage   map[uint32]*RatingGroupUsage
+	pending map[uint32][]models.UsedUnitContainer
+}
+
+// usageReporting returns the usage reporting of the N4 sessions, nil when
+// PDU sessions are not charged
+func (s *SMF) usageReporting() *UsageReporting {
+	if s.nfs.Charging == nil {
+		return nil
+	}
+	return &UsageReporting{VolumeThreshold: s.config.UsageVolumeThreshold, TimeThreshold: s.config.UsageTimeThreshold}
+}
+
+// startCharging opens the charging data of a PDU session once its user
+// plane is set up. A sink failing to create them is asked again with the
+// next usage. It runs holding c.mu.
+func (s *SMF) startCharging(ctx context.Context, c *SMContext) {
+	if s.nfs.Charging == nil {
+		return
+	}
+	n4 := c.N4
+	if c.PSAN4 != nil {
+		n4 = c.PSAN4
+	}
+
+	cs := &chargingSession{
+		id:   s.allocateChargingID(),
+		seid: n4.LocalSEID,
+		supi: c.SUPI,
+		log:  c.log,
+		info: models.PDUSessionChargingInformation{
+			PduSessionInformation: &models.PDUSessionInformation{
+				NetworkSlicingInfo: &models.NetworkSlicingInfo{SNSSAI: c.SNSSAI},
+				PduSessionID:       c.PDUSessionID,
+				PduType:            modelSessionType(c.PDUSessionType),
+				DnnID:              c.DNN,
+				RatType:            c.RatType,
+				StartTime:          time.Now(),
+			},
+		},
+		defaultRatingGroup: s.config.DefaultRatingGroup,
+		usage:              make(map[uint32]*RatingGroupUsage),
+		pending:            make(map[uint32][]models.UsedUnitContainer),
+	}
+	cs.info.ChargingID = cs.id
+	if c.ServingNetwork != (models.PlmnID{}) {
+		plmn := c.ServingNetwork
+		cs.info.PduSessionInformation.ServingCNPlmnID = &plmn
+	}
+	if c.UEAddress != nil || c.UEPrefix != nil {
+		address := &models.PDUAddress{}
+		if c.UEAddress != nil {
+			address.PduIPv4Address = c.UEAddress.String()
+		}
+		if c.UEPrefix != nil {
+			address.PduIPv6AddresswithPrefix = c.UEPrefix.String()
+		}
+		cs.info.PduSessionInformation.PduAddress = address
+	}
+	cs.setRules(c.Rules)
+	c.charging = cs
+
+	s.mu.Lock()
+	s.reporting[cs.seid] = cs
+	s.mu.Unlock()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if err := cs.open(ctx, s.nfs.Charging); err != nil {
+		c.log.Warn("Failed to create charging data", zap.Error(err))
+	}
+}
+
+// stopCharging reports the final usage of a PDU session, once its N4
+// sessions are deleted, and closes its charging data. It runs holding
+// c.mu.
+func (s *SMF) stopCharging(ctx context.Context, c *SMContext) {
+	cs := c.charging
+	if cs == nil {
+		return
+	}
+	c.charging = nil
+
+	s.mu.Lock()
+	delete(s.reporting, cs.seid)
+	s.mu.Unlock()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	stop := time.Now()
+	cs.info.PduSessionInformation.StopTime = &stop
+	if err := cs.flush(ctx, s.nfs.Charging, true); err != nil {
+		c.log.Warn("Failed to release charging data", zap.Uint32("charging_id", cs.id), zap.Error(err))
+	}
+
+	var total RatingGroupUsage
+	for _, u := range cs.usage {
+		total.UplinkVolume += u.UplinkVolume
+		total.DownlinkVolume += u.DownlinkVolume
+	}
+	c.log.Info("PDU session usage", zap.Uint32("charging_id", cs.id), zap.Int("rating_groups", len(cs.usage)),
+		zap.Uint64("uplink_volume", total.UplinkVolume), zap.Uint64("downlink_volume", total.DownlinkVolume))
+}
+
+// usageReported is the ReportHandler of the SMF. Usage reached at a
+// threshold is sent to the sink at once, the rest with the next request.
+func (s *SMF) usageReported(seid uint64, reports []UsageReport) {
+	s.mu.RLock()
+	cs := s.reporting[seid]
+	s.mu.RUnlock()
+	if cs == nil {
+		s.log.Debug("Usage report of unknown N4 session", zap.Uint64("seid", seid))
+		return
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if !cs.add(reports) {
+		return
+	}
+	if err := cs.flush(context.Background(), s.nfs.Charging, false); err != nil {
+		cs.log.Warn("Failed to update charging data", zap.Uint32("charging_id", cs.id), zap.Error(err))
+	}
+}
+
+// allocateChargingID returns a new charging ID
+func (s *SMF) allocateChargingID() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextChargingID++
+	return s.nextChargingID
+}
+
+// setRules sets the rating groups of the QoS rules, PCC rules without
+// rating group using the default one. Usage not reported yet goes to the
+// new rating group of a rule.
+func (cs *chargingSession) setRules(rules []PCCRule) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.ratingGroups = map[uint8]uint32{defaultRuleID: cs.defaultRatingGroup}
+	for _, r := range rules {
+		rg := r.RatingGroup
+		if rg == 0 {
+			rg = cs.defaultRatingGroup
+		}
+		cs.ratingGroups[r.RuleID] = rg
+	}
+}
+
+// add adds usage reports to the usage of their rating groups, reporting
+// whether one of them asks for an immediate report. It runs holding
+// cs.mu.
+func (cs *chargingSession) add(reports []UsageReport) bool {
+	immediate := false
+	for _, r := range reports {
+		rg, ok := cs.ratingGroups[r.RuleID]
+		if !ok {
+			rg = cs.defaultRatingGroup
+		}
+		triggers := chargingTriggers(r.Trigger)
+		for _, t := range triggers {
+			immediate = immediate || t.TriggerCategory == models.TriggerCategoryImmediate
+		}
+
+		cs.containers++
+		c := models.UsedUnitContainer{
+			Triggers:            triggers,
+			TriggerTimestamp:    r.End,
+			Time:                uint32(r.Duration / time.Second),
+			TotalVolume:         r.Total,
+			UplinkVolume:        r.Uplink,
+			DownlinkVolume:      r.Downlink,
+			LocalSequenceNumber: cs.containers,
+		}
+		if c.TriggerTimestamp.IsZero() {
+			c.TriggerTimestamp = time.Now()
+		}
+		if c.TotalVolume == 0 {
+			c.TotalVolume = c.UplinkVolume + c.DownlinkVolume
+		}
+
+		u := cs.usage[rg]
+		if u == nil {
+			u = &RatingGroupUsage{RatingGroup: rg}
+			cs.usage[rg] = u
+		}
+		u.add(c)
+		cs.pending[rg] = append(cs.pending[rg], c)
+	}
+	return immediate
+}
+
+// chargingTriggers returns the charging triggers of a usage report
+func chargingTriggers(t pfcp.UsageReportTrigger) []models.Trigger {
+	var triggers []models.Trigger
+	for _, m := range []struct {
+		usage    pfcp.UsageReportTrigger
+		trigger  models.TriggerType
+		category models.TriggerCategory
+	}{
+		{pfcp.UsageTriggerVolumeThreshold, models.TriggerTypeVolumeLimit, models.TriggerCategoryImmediate},
+		{pfcp.UsageTriggerTimeThreshold, models.TriggerTypeTimeLimit, models.TriggerCategoryImmediate},
+		{pfcp.UsageTriggerImmediate, models.TriggerTypeManagementIntervention, models.TriggerCategoryImmediate},
+		{pfcp.UsageTriggerTermination, models.TriggerTypeFinal, models.TriggerCategoryDeferred},
+	} {
+		if t&m.usage != 0 {
+			triggers = append(triggers, models.Trigger{TriggerType: m.trigger, TriggerCategory: m.category})
+		}
+	}
+	return triggers
+}
+
+// open creates the charging data in the sink unless they were. It runs
+// holding cs.mu.
+func (cs *chargingSession) open(ctx context.Context, sink ChargingSink) error {
+	if cs.ref != "" {
+		return nil
+	}
+	ref, err := sink.Create(ctx, cs.request(nil))
+	if err != nil {
+		return err
+	}
+	cs.ref = ref
+	return nil
+}
+
+// flush sends the pending usage to the sink, in the final request when
+// final is set. Containers the sink failed to get are sent again with
+// the next request. It runs holding cs.mu.
+func (cs *chargingSession) flush(ctx context.Context, sink ChargingSink, final bool) error {
+	if err := cs.open(ctx, sink); err != nil {
+		return fmt.Errorf("creating charging data: %w", err)
+	}
+
+	var usage []models.MultipleUnitUsage
+	for _, rg := range sortedIDs(cs.pending) {
+		usage = append(usage, models.MultipleUnitUsage{RatingGroup: rg, UsedUnitContainer: cs.pending[rg]})
+	}
+	req := cs.request(usage)
+
+	var err error
+	if final {
+		req.Triggers = []models.Trigger{{TriggerType: models.TriggerTypeFinal, TriggerCategory: models.TriggerCategoryImmediate}}
+		err = sink.Release(ctx, cs.ref, req)
+	} else {
+		err = sink.Update(ctx, cs.ref, req)
+	}
+	if err != nil {
+		return err
+	}
+	cs.pending = make(map[uint32][]models.UsedUnitContainer)
+	return nil
+}
+
+// request returns the next request of the charging data, with usage. It
+// runs holding cs.mu.
+func (cs *chargingSession) request(usage []models.MultipleUnitUsage) *models.ChargingDataRequest {
+	cs.invocations++
+	info := cs.info
+	session := *info.PduSessionInformation
+	info.PduSessionInformation = &session
+	return &models.ChargingDataRequest{
+		SubscriberIdentifier:          cs.supi,
+		NfConsumerIdentification:      models.NFIdentification{NodeFunctionality: models.NodeFunctionalitySMF},
+		InvocationTimeStamp:           time.Now(),
+		InvocationSequenceNumber:      cs.invocations,
+		MultipleUnitUsage:             usage,
+		PDUSessionChargingInformation: &info,
+	}
+}
+
+// fileSink appends the charging data requests to a file, one JSON
+// record per line
+type fileSink struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// fileRecord is a line of the file of a fileSink
+type fileRecord struct {
+	Time            time.Time                   `json:"time"`
+	Operation       string                      `json:"operation"`
+	ChargingDataRef string                      `json:"chargingDataRef"`
+	Request         *models.ChargingDataRequest `json:"request"`
+}
+
+// Create implements ChargingSink. The reference is made of the start of
+// the PDU session and its charging ID.
+func (f *fileSink) Create(ctx context.Context, req *models.ChargingDataRequest) (string, error) {
+	info := req.PDUSessionChargingInformation
+	if info == nil || info.PduSessionInformation == nil {
+		return "", fmt.Errorf("charging data request without PDU session")
+	}
+	ref := fmt.Sprintf("%d-%d", info.PduSessionInformation.StartTime.Unix(), info.ChargingID)
+	return ref, f.write("create", ref, req)
+}
+
+// Update implements ChargingSink
+func (f *fileSink) Update(ctx context.Context, ref string, req *models.ChargingDataRequest) error {
+	return f.write("update", ref, req)
+}
+
+// Release implements ChargingSink
+func (f *fileSink) Release(ctx context.Context, ref string, req *models.ChargingDataRequest) error {
+	return f.write("release", ref, req)
+}
+
+// write appends a record to the file
+func (f *fileSink) write(op, ref string, req *models.ChargingDataRequest) error {
+	b, err := json.Marshal(fileRecord{Time: time.Now(), Operation: op, ChargingDataRef: ref, Request: req})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(b, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// maxChargingRecords bounds the records kept by a LocalCHF
+const maxChargingRecords = 1024
+
+// ChargingRecord is the charging of a PDU session closed by a LocalCHF
+type ChargingRecord struct {
+	ChargingDataRef string `json:"chargingDataRef"`
+	ChargingID      uint32 `json:"chargingId"`
+
+	SUPI         string         `json:"supi"`
+	PDUSessionID uint8          `json:"pduSessionId"`
+	DNN          string         `json:"dnn"`
+	SNSSAI       *models.Snssai `json:"snssai,omitempty"`
+
+	StartTime time.Time `json:"startTime"`
+	StopTime  time.Time `json:"stopTime"`
+
+	// Usage holds the usage of each rating group, in order
+	Usage []RatingGroupUsage `json:"usage"`
+
+	// Requests counts the requests received for the PDU session
+	Requests int `json:"requests"`
+}
+
+// LocalCHF stands in for the CHF of Nchf_ConvergedCharging: it adds up
+// the usage of the charging data it is sent and closes a ChargingRecord
+// when they are released. Requests out of order are rejected.
+type LocalCHF struct {
+	log *zap.Logger
+
+	mu      sync.Mutex
+	next    uint64
+	open    map[string]*localCharging
+	records []ChargingRecord // closed, the oldest first
+}
+
+// localCharging is charging data opened in a LocalCHF
+type localCharging struct {
+	record     ChargingRecord
+	usage      map[uint32]*RatingGroupUsage
+	invocation uint32
+	container  uint32
+}
+
+// NewLocalCHF creates a LocalCHF
+func NewLocalCHF() *LocalCHF {
+	return &LocalCHF{log: logger.Named("chf"), open: make(map[string]*localCharging)}
+}
+
+// Create implements ChargingSink
+func (l *LocalCHF) Create(ctx context.Context, req *models.ChargingDataRequest) (string, error) {
+	info := req.PDUSessionChargingInformation
+	if info == nil || info.PduSessionInformation == nil {
+		return "", fmt.Errorf("charging data request without PDU session")
+	}
+	session := info.PduSessionInformation
+	var snssai *models.Snssai
+	if session.NetworkSlicingInfo != nil {
+		s := session.NetworkSlicingInfo.SNSSAI
+		snssai = &s
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.next++
+	ref := strconv.FormatUint(l.next, 10)
+	ch := &localCharging{
+		record: ChargingRecord{
+			ChargingDataRef: ref,
+			ChargingID:      info.ChargingID,
+			SUPI:            req.SubscriberIdentifier,
+			PDUSessionID:    session.PduSessionID,
+			DNN:             session.DnnID,
+			SNSSAI:          snssai,
+			StartTime:       session.StartTime,
+		},
+		usage: make(map[uint32]*RatingGroupUsage),
+	}
+	if err := ch.add(req); err != nil {
+		return "", err
+	}
+	l.open[ref] = ch
+	return ref, nil
+}
+
+// Update implements ChargingSink
+func (l *LocalCHF) Update(ctx context.Context, ref string, req *models.ChargingDataRequest) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch, ok := l.open[ref]
+	if !ok {
+		return fmt.Errorf("unknown charging data %s", ref)
+	}
+	return ch.add(req)
+}
+
+// Release implements ChargingSink
+func (l *LocalCHF) Release(ctx context.Context, ref string, req *models.ChargingDataRequest) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch, ok := l.open[ref]
+	if !ok {
+		return fmt.Errorf("unknown charging data %s", ref)
+	}
+	if err := ch.add(req); err != nil {
+		return err
+	}
+	delete(l.open, ref)
+
+	r := ch.record
+	r.StopTime = time.Now()
+	if info := req.PDUSessionChargingInformation; info != nil && info.PduSessionInformation != nil &&
+		info.PduSessionInformation.StopTime != nil {
+		r.StopTime = *info.PduSessionInformation.StopTime
+	}
+	r.Usage = sortedUsage(ch.usage)
+	if len(l.records) == maxChargingRecords {
+		l.records = l.records[1:]
+	}
+	l.records = append(l.records, r)
+
+	l.log.Info("Charging data record", logger.SUPI(r.SUPI), zap.String("charging_data", ref),
+		zap.Uint32("charging_id", r.ChargingID), zap.Uint8("pdu_session_id", r.PDUSessionID),
+		zap.Any("usage", r.Usage), zap.Duration("duration", r.StopTime.Sub(r.StartTime)))
+	return nil
+}
+
+// Records returns the charging records closed, the oldest first
+func (l *LocalCHF) Records() []ChargingRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ChargingRecord(nil), l.records...)
+}
+
+// add adds the usage of a request, checking that requests and containers
+// come in order
+func (ch *localCharging) add(req *models.ChargingDataRequest) error {
+	if req.InvocationSequenceNumber <= ch.invocation {
+		return fmt.Errorf("charging data request %d out of order", req.InvocationSequenceNumber)
+	}
+	for _, m := range req.MultipleUnitUsage {
+		for _, c := range m.UsedUnitContainer {
+			if c.LocalSequenceNumber <= ch.container {
+				return fmt.Errorf("used unit container %d out of order", c.LocalSequenceNumber)
+			}
+		}
+	}
+
+	ch.invocation = req.InvocationSequenceNumber
+	ch.record.Requests++
+	for _, m := range req.MultipleUnitUsage {
+		u := ch.usage[m.RatingGroup]
+		if u == nil {
+			u = &RatingGroupUsage{RatingGroup: m.RatingGroup}
+			ch.usage[m.RatingGroup] = u
+		}
+		for _, c := range m.UsedUnitContainer {
+			u.add(c)
+			if c.LocalSequenceNumber > ch.container {
+				ch.container = c.LocalSequenceNumber
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,420 @@
+package smf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/pfcp"
+)
+
+func TestNewChargingSink(t *testing.T) {
+	tests := []struct {
+		sink    string
+		want    interface{}
+		wantErr bool
+	}{
+		{sink: "", want: nil},
+		{sink: ChargingSinkNone, want: nil},
+		{sink: ChargingSinkFile, want: &fileSink{}},
+		{sink: ChargingSinkLocal, want: &LocalCHF{}},
+		{sink: "chf", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sink, func(t *testing.T) {
+			sink, err := NewChargingSink(&Config{ChargingSink: tt.sink, CDRFile: "cdr.json"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if reflect.TypeOf(sink) != reflect.TypeOf(tt.want) {
+				t.Errorf("sink = %T, want %T", sink, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageURR(t *testing.T) {
+	mb := uint64(1 << 20)
+	minute := uint32(60)
+	tests := []struct {
+		name          string
+		reporting     UsageReporting
+		wantTriggers  pfcp.ReportingTriggers
+		wantVolume    *pfcp.Volume
+		wantTimeLimit *uint32
+	}{
+		{name: "no thresholds"},
+		{
+			name:         "volume",
+			reporting:    UsageReporting{VolumeThreshold: mb},
+			wantTriggers: pfcp.TriggerVolumeThreshold,
+			wantVolume:   &pfcp.Volume{Total: &mb},
+		},
+		{
+			name:          "time",
+			reporting:     UsageReporting{TimeThreshold: time.Minute},
+			wantTriggers:  pfcp.TriggerTimeThreshold,
+			wantTimeLimit: &minute,
+		},
+		{
+			name:          "volume and time",
+			reporting:     UsageReporting{VolumeThreshold: mb, TimeThreshold: time.Minute},
+			wantTriggers:  pfcp.TriggerVolumeThreshold | pfcp.TriggerTimeThreshold,
+			wantVolume:    &pfcp.Volume{Total: &mb},
+			wantTimeLimit: &minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := usageURR(2, &tt.reporting)
+			want := pfcp.URR{
+				URRID:             2,
+				MeasurementMethod: pfcp.MeasureVolume | pfcp.MeasureDuration,
+				ReportingTriggers: tt.wantTriggers,
+				VolumeThreshold:   tt.wantVolume,
+				TimeThreshold:     tt.wantTimeLimit,
+			}
+			if !reflect.DeepEqual(u, want) {
+				t.Errorf("URR = %+v, want %+v", u, want)
+			}
+		})
+	}
+}
+
+func TestChargingSessionAdd(t *testing.T) {
+	tests := []struct {
+		name          string
+		reports       []UsageReport
+		wantImmediate bool
+		wantUsage     []RatingGroupUsage
+		wantTriggers  [][]models.Trigger // of each container, by rating group
+	}{
+		{
+			name:          "volume threshold",
+			reports:       []UsageReport{{RuleID: 2, Trigger: pfcp.UsageTriggerVolumeThreshold, Uplink: 600, Downlink: 400, Duration: 30 * time.Second}},
+			wantImmediate: true,
+			wantUsage:     []RatingGroupUsage{{RatingGroup: 200, TotalVolume: 1000, UplinkVolume: 600, DownlinkVolume: 400, Time: 30}},
+			wantTriggers:  [][]models.Trigger{{{TriggerType: models.TriggerTypeVolumeLimit, TriggerCategory: models.TriggerCategoryImmediate}}},
+		},
+		{
+			name: "time threshold and termination",
+			reports: []UsageReport{
+				{RuleID: defaultRuleID, Trigger: pfcp.UsageTriggerTimeThreshold, Total: 10, Uplink: 4, Downlink: 4},
+				{RuleID: defaultRuleID, Trigger: pfcp.UsageTriggerTermination, Uplink: 1, Downlink: 2},
+			},
+			wantImmediate: true,
+			wantUsage:     []RatingGroupUsage{{RatingGroup: 100, TotalVolume: 13, UplinkVolume: 5, DownlinkVolume: 6}},
+			wantTriggers: [][]models.Trigger{
+				{{TriggerType: models.TriggerTypeTimeLimit, TriggerCategory: models.TriggerCategoryImmediate}},
+				{{TriggerType: models.TriggerTypeFinal, TriggerCategory: models.TriggerCategoryDeferred}},
+			},
+		},
+		{
+			name: "deferred",
+			reports: []UsageReport{
+				{RuleID: 2, Trigger: pfcp.UsageTriggerPeriodic, Uplink: 1},
+				{RuleID: 9, Trigger: pfcp.UsageTriggerTermination, Downlink: 1},
+			},
+			wantUsage: []RatingGroupUsage{
+				{RatingGroup: 100, TotalVolume: 1, DownlinkVolume: 1},
+				{RatingGroup: 200, TotalVolume: 1, UplinkVolume: 1},
+			},
+			wantTriggers: [][]models.Trigger{
+				{{TriggerType: models.TriggerTypeFinal, TriggerCategory: models.TriggerCategoryDeferred}},
+				nil,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := &chargingSession{
+				defaultRatingGroup: 100,
+				usage:              make(map[uint32]*RatingGroupUsage),
+				pending:            make(map[uint32][]models.UsedUnitContainer),
+			}
+			cs.setRules([]PCCRule{{ID: "voice", RuleID: 2, RatingGroup: 200}, {ID: "web", RuleID: 3}})
+
+			if immediate := cs.add(tt.reports); immediate != tt.wantImmediate {
+				t.Errorf("immediate = %v, want %v", immediate, tt.wantImmediate)
+			}
+			if usage := sortedUsage(cs.usage); !reflect.DeepEqual(usage, tt.wantUsage) {
+				t.Errorf("usage = %+v, want %+v", usage, tt.wantUsage)
+			}
+
+			var triggers [][]models.Trigger
+			var sequence []uint32
+			for _, rg := range sortedIDs(cs.pending) {
+				for _, c := range cs.pending[rg] {
+					triggers = append(triggers, c.Triggers)
+					sequence = append(sequence, c.LocalSequenceNumber)
+				}
+			}
+			if len(sequence) != len(tt.reports) || cs.containers != uint32(len(tt.reports)) {
+				t.Errorf("containers = %v, want %d", sequence, len(tt.reports))
+			}
+			if !reflect.DeepEqual(triggers, tt.wantTriggers) {
+				t.Errorf("triggers = %+v, want %+v", triggers, tt.wantTriggers)
+			}
+		})
+	}
+}
+
+func TestLocalCHF(t *testing.T) {
+	chf := NewLocalCHF()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	info := &models.PDUSessionChargingInformation{
+		ChargingID: 7,
+		PduSessionInformation: &models.PDUSessionInformation{
+			NetworkSlicingInfo: &models.NetworkSlicingInfo{SNSSAI: testSNSSAI},
+			PduSessionID:       1,
+			DnnID:              "internet",
+			StartTime:          start,
+		},
+	}
+	request := func(invocation, container uint32, rg uint32, volume uint64) *models.ChargingDataRequest {
+		req := &models.ChargingDataRequest{
+			SubscriberIdentifier:          testSUPI,
+			InvocationSequenceNumber:      invocation,
+			PDUSessionChargingInformation: info,
+		}
+		if container > 0 {
+			req.MultipleUnitUsage = []models.MultipleUnitUsage{{
+				RatingGroup:       rg,
+				UsedUnitContainer: []models.UsedUnitContainer{{TotalVolume: volume, LocalSequenceNumber: container}},
+			}}
+		}
+		return req
+	}
+	ctx := context.Background()
+
+	if _, err := chf.Create(ctx, &models.ChargingDataRequest{InvocationSequenceNumber: 1}); err == nil {
+		t.Error("created charging data without PDU session")
+	}
+	ref, err := chf.Create(ctx, request(1, 0, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chf.Update(ctx, ref, request(2, 1, 100, 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, err := range map[string]error{
+		"unknown reference":    chf.Update(ctx, "unknown", request(3, 2, 100, 1)),
+		"request out of order": chf.Update(ctx, ref, request(2, 2, 100, 1)),
+		"container repeated":   chf.Update(ctx, ref, request(3, 1, 100, 1)),
+		"release unknown":      chf.Release(ctx, "unknown", request(3, 2, 100, 1)),
+	} {
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if records := chf.Records(); len(records) != 0 {
+		t.Fatalf("records before release = %+v", records)
+	}
+
+	if err := chf.Release(ctx, ref, request(3, 2, 200, 500)); err != nil {
+		t.Fatal(err)
+	}
+	records := chf.Records()
+	if len(records) != 1 {
+		t.Fatalf("records = %+v, want 1", records)
+	}
+	r := records[0]
+	want := ChargingRecord{
+		ChargingDataRef: ref,
+		ChargingID:      7,
+		SUPI:            testSUPI,
+		PDUSessionID:    1,
+		DNN:             "internet",
+		SNSSAI:          &testSNSSAI,
+		StartTime:       start,
+		StopTime:        r.StopTime,
+		Usage:           []RatingGroupUsage{{RatingGroup: 100, TotalVolume: 1000}, {RatingGroup: 200, TotalVolume: 500}},
+		Requests:        3,
+	}
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("record = %+v, want %+v", r, want)
+	}
+	if err := chf.Update(ctx, ref, request(4, 3, 100, 1)); err == nil {
+		t.Error("updated released charging data")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cdr.json")
+	sink := &fileSink{path: path}
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	req := &models.ChargingDataRequest{
+		SubscriberIdentifier: testSUPI,
+		PDUSessionChargingInformation: &models.PDUSessionChargingInformation{
+			ChargingID:            7,
+			PduSessionInformation: &models.PDUSessionInformation{PduSessionID: 1, DnnID: "internet", StartTime: start},
+		},
+	}
+	ctx := context.Background()
+
+	if _, err := sink.Create(ctx, &models.ChargingDataRequest{}); err == nil {
+		t.Error("created charging data without PDU session")
+	}
+	ref, err := sink.Create(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref != "1709294400-7" {
+		t.Errorf("reference = %q, want 1709294400-7", ref)
+	}
+	if err := sink.Update(ctx, ref, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Release(ctx, ref, req); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ops []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("record %q: %v", scanner.Text(), err)
+		}
+		if r.ChargingDataRef != ref || r.Request == nil || r.Request.SubscriberIdentifier != testSUPI {
+			t.Errorf("record = %+v", r)
+		}
+		ops = append(ops, r.Operation)
+	}
+	if want := []string{"create", "update", "release"}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("operations = %v, want %v", ops, want)
+	}
+
+	missing := &fileSink{path: filepath.Join(t.TempDir(), "missing", "cdr.json")}
+	if err := missing.Update(ctx, ref, req); err == nil {
+		t.Error("wrote to a missing directory")
+	}
+}
+
+// volume returns the traffic of a usage report
+func volume(ul, dl uint64) *pfcp.Volume {
+	return &pfcp.Volume{Uplink: &ul, Downlink: &dl}
+}
+
+// report sends a usage report of an N4 session to the SMF, returning the
+// cause of its answer
+func (f *fakeUPF) report(t *testing.T, h *harness, seid uint64, reports ...pfcp.UsageReport) pfcp.Cause {
+	t.Helper()
+	rsp, err := f.node.Request(context.Background(), h.n4.node.LocalAddr().String(), seid,
+		&pfcp.SessionReportRequest{ReportType: pfcp.ReportUsage, UsageReports: reports})
+	if err != nil {
+		t.Fatalf("session report: %v", err)
+	}
+	return rsp.(*pfcp.SessionReportResponse).Cause
+}
+
+// openUsage returns the usage of the charging data open in a LocalCHF
+func openUsage(chf *LocalCHF) []RatingGroupUsage {
+	chf.mu.Lock()
+	defer chf.mu.Unlock()
+	var usage []RatingGroupUsage
+	for _, ch := range chf.open {
+		usage = append(usage, sortedUsage(ch.usage)...)
+	}
+	return usage
+}
+
+func TestUsageReporting(t *testing.T) {
+	h := newHarness(t, func(cfg *Config) {
+		cfg.UsageVolumeThreshold = 1 << 20
+		cfg.UsageTimeThreshold = time.Minute
+	})
+	h.nfs.mu.Lock()
+	h.nfs.decision = voiceDecision()
+	h.nfs.mu.Unlock()
+	upf := h.upfs[0]
+
+	c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+	seid := c.N4.LocalSEID
+
+	// A URR with the thresholds for each QoS rule
+	est := upf.takeRequests()[0].(*pfcp.SessionEstablishmentRequest)
+	var urrs []uint32
+	for _, u := range est.CreateURRs {
+		urrs = append(urrs, u.URRID)
+		if u.ReportingTriggers != pfcp.TriggerVolumeThreshold|pfcp.TriggerTimeThreshold ||
+			u.VolumeThreshold == nil || *u.VolumeThreshold.Total != 1<<20 || u.TimeThreshold == nil || *u.TimeThreshold != 60 {
+			t.Errorf("URR = %+v", u)
+		}
+	}
+	if !reflect.DeepEqual(urrs, []uint32{1, 2}) {
+		t.Errorf("URRs = %v, want [1 2]", urrs)
+	}
+	for _, pdr := range est.CreatePDRs {
+		if want := []uint32{uint32((pdr.PDRID + 1) / 2)}; !reflect.DeepEqual(pdr.URRIDs, want) {
+			t.Errorf("URRs of PDR %d = %v, want %v", pdr.PDRID, pdr.URRIDs, want)
+		}
+	}
+	if usage := openUsage(h.chf); len(usage) != 0 {
+		t.Errorf("usage at establishment = %+v", usage)
+	}
+
+	// A threshold reached is charged at once
+	duration := uint32(30)
+	cause := upf.report(t, h, seid, pfcp.UsageReport{
+		URRID: 1, URSEQN: 1, Trigger: pfcp.UsageTriggerVolumeThreshold, Volume: volume(600, 400), Duration: &duration,
+	})
+	if cause != pfcp.CauseRequestAccepted {
+		t.Fatalf("session report cause = %s", cause)
+	}
+	want := []RatingGroupUsage{{RatingGroup: 100, TotalVolume: 1000, UplinkVolume: 600, DownlinkVolume: 400, Time: 30}}
+	if usage := openUsage(h.chf); !reflect.DeepEqual(usage, want) {
+		t.Errorf("usage after threshold = %+v, want %+v", usage, want)
+	}
+
+	// The rest waits for the next request
+	upf.report(t, h, seid, pfcp.UsageReport{URRID: 2, URSEQN: 1, Trigger: pfcp.UsageTriggerPeriodic, Volume: volume(100, 200)})
+	if usage := openUsage(h.chf); !reflect.DeepEqual(usage, want) {
+		t.Errorf("usage after periodic report = %+v, want %+v", usage, want)
+	}
+	if cause := upf.report(t, h, seid+100); cause != pfcp.CauseSessionContextNotFound {
+		t.Errorf("report of unknown session cause = %s", cause)
+	}
+
+	// The final usage comes with the deletion of the N4 session
+	upf.mu.Lock()
+	upf.usage = []pfcp.UsageReport{
+		{URRID: 1, URSEQN: 2, Trigger: pfcp.UsageTriggerTermination, Volume: volume(50, 50)},
+		{URRID: 2, URSEQN: 2, Trigger: pfcp.UsageTriggerTermination, Volume: volume(10, 20)},
+	}
+	upf.mu.Unlock()
+	if err := h.release(c.Ref); err != nil {
+		t.Fatal(err)
+	}
+
+	records := h.chf.Records()
+	if len(records) != 1 {
+		t.Fatalf("records = %+v, want 1", records)
+	}
+	r := records[0]
+	if r.SUPI != testSUPI || r.PDUSessionID != 1 || r.DNN != "internet" || r.ChargingID == 0 || r.Requests != 3 {
+		t.Errorf("record = %+v", r)
+	}
+	want = []RatingGroupUsage{
+		{RatingGroup: 100, TotalVolume: 1100, UplinkVolume: 650, DownlinkVolume: 450, Time: 30},
+		{RatingGroup: 200, TotalVolume: 330, UplinkVolume: 110, DownlinkVolume: 220},
+	}
+	if !reflect.DeepEqual(r.Usage, want) {
+		t.Errorf("final usage = %+v, want %+v", r.Usage, want)
+	}
+	if usage := openUsage(h.chf); len(usage) != 0 {
+		t.Errorf("usage open after release = %+v", usage)
+	}
+}
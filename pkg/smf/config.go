@@ -0,0 +1,308 @@
+// Package smf implements the Session Management Function
+package smf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/0had0/5G-core/pkg/models"
+)
+
+// Default QoS and session AMBR of PDU sessions whose subscription and
+// policy set none
+const (
+	defaultFiveQI        = 9
+	defaultARPPriority   = 8
+	defaultSessionAMBRUL = "1 Gbps"
+	defaultSessionAMBRDL = "1 Gbps"
+)
+
+// Config holds the SMF settings derived from the configuration file
+type Config struct {
+	// InstanceID is the NF instance ID of the SMF
+	InstanceID string
+
+	// Name is the NF instance name of the SMF
+	Name string
+
+	// DNNs holds the served DNNs, the first one being used when the UE
+	// asks for none
+	DNNs []string
+
+	// Locality is where the SMF is, the locality of the UEs of TACs
+	// without one
+	Locality string
+
+	// UPFSelectionMode is how a UPF is chosen among the ones serving a
+	// DNN and a slice: UPFSelectionProximity, UPFSelectionLoad or
+	// UPFSelectionPerformance
+	UPFSelectionMode string
+
+	// TACLocalities holds the locality of the gNBs by TAC, in lower case
+	// hexadecimal
+	TACLocalities map[string]string
+
+	// DiscoverUPFs tells whether the UPFs registered in the NRF are used
+	// besides the configured ones
+	DiscoverUPFs bool
+
+	// UPFHeartbeatInterval is the time between PFCP heartbeats, 0 when
+	// none are sent
+	UPFHeartbeatInterval time.Duration
+
+	// SSC3AddressLifetime is how long a PDU session of SSC mode 3 is kept
+	// once the UE was asked to establish a new one anchored closer to it
+	SSC3AddressLifetime time.Duration
+
+	// PFCPAddress is the local PFCP endpoint, host:port
+	PFCPAddress string
+
+	// PFCPNodeID is the address of the SMF given to the UPFs, nil when
+	// the SMF does not use PFCP
+	PFCPNodeID net.IP
+
+	// PFCPT1 and PFCPN1 are the PFCP request timers
+	PFCPT1 time.Duration
+	PFCPN1 int
+
+	// Pools holds the pools UE addresses and prefixes are allocated from
+	Pools []PoolConfig
+
+	// AddressQuarantine is how long a released address or prefix is held
+	// back before reuse
+	AddressQuarantine time.Duration
+
+	// UPFs holds the UPFs PDU sessions are set up in
+	UPFs []*UPF
+
+	// ChargingSink is where the charging data of the PDU sessions go:
+	// ChargingSinkNone, ChargingSinkFile or ChargingSinkLocal
+	ChargingSink string
+
+	// CDRFile is the file of ChargingSinkFile
+	CDRFile string
+
+	// DefaultRatingGroup charges the traffic of the PCC rules without
+	// charging decision and of the default QoS rule
+	DefaultRatingGroup uint32
+
+	// UsageVolumeThreshold and UsageTimeThreshold are the traffic and
+	// time after which the UPFs report usage, 0 for none
+	UsageVolumeThreshold uint64
+	UsageTimeThreshold   time.Duration
+
+	// CallbackURI is the API root given to other NFs for notifications
+	CallbackURI string
+
+	// API roots of the NFs used during PDU session establishment
+	NRFURI string
+	AMFURI string
+	UDMURI string
+	PCFURI string
+}
+
+// NewConfig builds the SMF settings from the application configuration
+func NewConfig(cfg *config.Config) (*Config, error) {
+	smf := cfg.SMF
+
+	if len(smf.DnnList) == 0 {
+		return nil, fmt.Errorf("no DNN configured")
+	}
+	if len(smf.UPFs) == 0 && !smf.DiscoverUPFs {
+		return nil, fmt.Errorf("no UPF configured")
+	}
+	switch smf.UpfSelectionMode {
+	case UPFSelectionProximity, UPFSelectionLoad, UPFSelectionPerformance:
+	default:
+		return nil, fmt.Errorf("invalid UPF selection mode %q", smf.UpfSelectionMode)
+	}
+
+	c := &Config{
+		InstanceID:           cfg.NetworkFunction.InstanceID,
+		Name:                 cfg.NetworkFunction.InstanceName,
+		DNNs:                 smf.DnnList,
+		Locality:             cfg.NetworkFunction.Locality,
+		UPFSelectionMode:     smf.UpfSelectionMode,
+		TACLocalities:        make(map[string]string),
+		DiscoverUPFs:         smf.DiscoverUPFs,
+		UPFHeartbeatInterval: time.Duration(smf.UpfHeartbeatInterval) * time.Second,
+		SSC3AddressLifetime:  time.Duration(smf.Ssc3AddressLifetime) * time.Second,
+		CallbackURI:          smf.CallbackURI,
+		NRFURI:               cfg.NRF.URL,
+		AMFURI:               smf.Peers.AMF,
+		UDMURI:               smf.Peers.UDM,
+		PCFURI:               smf.Peers.PCF,
+	}
+
+	c.AddressQuarantine = time.Duration(smf.AddressQuarantine) * time.Second
+	if smf.Ssc3AddressLifetime < 0 {
+		return nil, fmt.Errorf("negative SSC mode 3 address lifetime")
+	}
+
+	c.PFCPAddress = smf.PFCP.Address
+	c.PFCPT1 = time.Duration(smf.PFCP.T1) * time.Second
+	c.PFCPN1 = smf.PFCP.N1
+	if smf.PFCP.NodeID != "" {
+		if c.PFCPNodeID = net.ParseIP(smf.PFCP.NodeID); c.PFCPNodeID == nil {
+			return nil, fmt.Errorf("invalid PFCP node ID %q", smf.PFCP.NodeID)
+		}
+	}
+
+	charging := smf.Charging
+	switch charging.Sink {
+	case "", ChargingSinkNone, ChargingSinkLocal:
+	case ChargingSinkFile:
+		if charging.File == "" {
+			return nil, fmt.Errorf("no CDR file configured")
+		}
+	default:
+		return nil, fmt.Errorf("invalid charging sink %q", charging.Sink)
+	}
+	if charging.RatingGroup < 0 || charging.VolumeThreshold < 0 || charging.TimeThreshold < 0 {
+		return nil, fmt.Errorf("negative rating group or usage threshold")
+	}
+	c.ChargingSink, c.CDRFile = charging.Sink, charging.File
+	c.DefaultRatingGroup = uint32(charging.RatingGroup)
+	c.UsageVolumeThreshold = uint64(charging.VolumeThreshold)
+	c.UsageTimeThreshold = time.Duration(charging.TimeThreshold) * time.Second
+
+	for _, p := range smf.Pools {
+		pool, err := newPoolConfig(p.Name, p.IPv4.Start, p.IPv4.End, p.IPv6.Prefix, p.IPv6.PrefixLength)
+		if err != nil {
+			return nil, err
+		}
+		pool.DNN, pool.SNSSAI = p.Dnn, p.Snssai
+		c.Pools = append(c.Pools, pool)
+	}
+	v4, v6 := smf.IPv4AddressPool, smf.IPv6AddressPool
+	if v4.Start != "" || v6.Prefix != "" {
+		pool, err := newPoolConfig(defaultPool, v4.Start, v4.End, v6.Prefix, v6.PrefixLength)
+		if err != nil {
+			return nil, err
+		}
+		c.Pools = append(c.Pools, pool)
+	}
+	if err := checkPools(c.Pools); err != nil {
+		return nil, err
+	}
+
+	for _, u := range smf.UPFs {
+		n3 := net.ParseIP(u.N3)
+		if n3 == nil {
+			return nil, fmt.Errorf("invalid N3 address %q of UPF %s", u.N3, u.NodeID)
+		}
+		if _, _, err := net.SplitHostPort(u.N4); err != nil {
+			return nil, fmt.Errorf("invalid N4 address %q of UPF %s", u.N4, u.NodeID)
+		}
+		var n9 net.IP
+		if u.N9 != "" {
+			if n9 = net.ParseIP(u.N9); n9 == nil {
+				return nil, fmt.Errorf("invalid N9 address %q of UPF %s", u.N9, u.NodeID)
+			}
+		}
+		c.UPFs = append(c.UPFs, &UPF{
+			NodeID:    u.NodeID,
+			N4Address: u.N4,
+			N3Address: n3,
+			N9Address: n9,
+			DNNs:      u.DnnList,
+			SNSSAIs:   u.Snssais,
+			Locality:  u.Locality,
+			capacity:  100,
+		})
+	}
+
+	for _, l := range smf.Localities {
+		for _, tac := range l.Tacs {
+			tac = strings.ToLower(tac)
+			if other, ok := c.TACLocalities[tac]; ok && other != l.Name {
+				return nil, fmt.Errorf("TAC %s in localities %s and %s", tac, other, l.Name)
+			}
+			c.TACLocalities[tac] = l.Name
+		}
+	}
+
+	return c, nil
+}
+
+// defaultPool is the name of the pool of every DNN and slice
+const defaultPool = "default"
+
+// PoolConfig is a pool of UE addresses, with an IPv4 range and an IPv6
+// prefix UEs get /64 prefixes of
+type PoolConfig struct {
+	Name string
+
+	// DNN and SNSSAI restrict the pool to a DNN and a slice when set
+	DNN    string
+	SNSSAI *models.Snssai
+
+	// IPv4 range, nil when the pool has none
+	IPv4Start net.IP
+	IPv4End   net.IP
+
+	// IPv6Prefix is nil when the pool has none
+	IPv6Prefix *net.IPNet
+}
+
+// newPoolConfig parses the ranges of a pool. Either may be left empty.
+func newPoolConfig(name, start, end, prefix string, prefixLength int) (PoolConfig, error) {
+	p := PoolConfig{Name: name}
+	if name == "" {
+		return p, fmt.Errorf("address pool without name")
+	}
+
+	if start != "" || end != "" {
+		p.IPv4Start, p.IPv4End = net.ParseIP(start).To4(), net.ParseIP(end).To4()
+		if p.IPv4Start == nil || p.IPv4End == nil || ipv4Uint(p.IPv4Start) > ipv4Uint(p.IPv4End) {
+			return p, fmt.Errorf("invalid IPv4 range %s-%s of address pool %s", start, end, name)
+		}
+	}
+	if prefix != "" {
+		ip := net.ParseIP(prefix)
+		if ip == nil || ip.To4() != nil || prefixLength < 1 || prefixLength > 64 {
+			return p, fmt.Errorf("invalid IPv6 prefix %s/%d of address pool %s", prefix, prefixLength, name)
+		}
+		mask := net.CIDRMask(prefixLength, 128)
+		p.IPv6Prefix = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+	if p.IPv4Start == nil && p.IPv6Prefix == nil {
+		return p, fmt.Errorf("address pool %s without IPv4 range or IPv6 prefix", name)
+	}
+	return p, nil
+}
+
+// checkPools checks that pools have distinct names and do not overlap
+func checkPools(pools []PoolConfig) error {
+	if len(pools) == 0 {
+		return fmt.Errorf("no address pool configured")
+	}
+	for i, p := range pools {
+		for _, q := range pools[:i] {
+			switch {
+			case p.Name == q.Name:
+				return fmt.Errorf("duplicate address pool %s", p.Name)
+			case p.IPv4Start != nil && q.IPv4Start != nil &&
+				ipv4Uint(p.IPv4Start) <= ipv4Uint(q.IPv4End) && ipv4Uint(q.IPv4Start) <= ipv4Uint(p.IPv4End):
+				return fmt.Errorf("IPv4 ranges of address pools %s and %s overlap", q.Name, p.Name)
+			case p.IPv6Prefix != nil && q.IPv6Prefix != nil &&
+				(p.IPv6Prefix.Contains(q.IPv6Prefix.IP) || q.IPv6Prefix.Contains(p.IPv6Prefix.IP)):
+				return fmt.Errorf("IPv6 prefixes of address pools %s and %s overlap", q.Name, p.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// ServesDNN reports whether the SMF serves a DNN
+func (c *Config) ServesDNN(dnn string) bool {
+	for _, d := range c.DNNs {
+		if d == dnn {
+			return true
+		}
+	}
+	return false
+}
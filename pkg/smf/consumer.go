@@ -0,0 +1,204 @@
+package smf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/sbi"
+)
+
+// UDM is the consumer of Nudm_SDM
+type UDM interface {
+	// GetSMData returns the session management subscription of a UE to a
+	// DNN in a slice
+	GetSMData(ctx context.Context, supi string, snssai models.Snssai, dnn string) ([]models.SessionManagementSubscriptionData, error)
+}
+
+// PCF is the consumer of Npcf_SMPolicyControl
+type PCF interface {
+	// CreateSMPolicy creates an SM policy association and returns its URI
+	CreateSMPolicy(ctx context.Context, data models.SmPolicyContextData) (string, *models.SmPolicyDecision, error)
+
+	// DeleteSMPolicy deletes the SM policy association at uri
+	DeleteSMPolicy(ctx context.Context, uri string) error
+}
+
+// AMF is the consumer of Namf_Communication and of the SM context status
+// notifications of the AMF
+type AMF interface {
+	// N1N2MessageTransfer sends an N1 message and N2 information to a UE
+	N1N2MessageTransfer(ctx context.Context, supi string, req models.N1N2MessageTransferReqData) (*models.N1N2MessageTransferRspData, error)
+
+	// NotifySMContextStatus tells the AMF that an SM context changed
+	NotifySMContextStatus(ctx context.Context, uri string, n models.SmContextStatusNotification) error
+
+	// SubscribeEvents subscribes to events of the AMF and returns the URI
+	// of the subscription
+	SubscribeEvents(ctx context.Context, sub models.AmfEventSubscription) (string, error)
+
+	// UnsubscribeEvents deletes the event subscription at uri
+	UnsubscribeEvents(ctx context.Context, uri string) error
+}
+
+// NRF finds the NF instances registered in the NRF
+type NRF interface {
+	// SearchNFInstances returns the instances of an NF type a requester
+	// may use
+	SearchNFInstances(ctx context.Context, target, requester models.NfType) (*models.NfDiscoveryResponse, error)
+}
+
+// NFs holds the consumers of the NFs used by the SMF procedures
+type NFs struct {
+	UDM UDM
+	PCF PCF
+	AMF AMF
+
+	// NRF discovers UPFs, nil when only the configured ones are used
+	NRF NRF
+
+	// N4 sets up the PDU sessions in the UPFs
+	N4 N4
+
+	// Store keeps the UE address leases, nil to keep them in memory only
+	Store AddressStore
+
+	// Charging receives the charging data of the PDU sessions, nil when
+	// they are not charged
+	Charging ChargingSink
+}
+
+// NewNFs creates consumers of the NFs at the configured API roots. The
+// N4 interface, the address store and the charging sink are left to the
+// caller.
+func NewNFs(client *sbi.Client, cfg *Config) NFs {
+	return NFs{
+		UDM: &udmClient{client: client, root: cfg.UDMURI},
+		PCF: &pcfClient{client: client, root: cfg.PCFURI},
+		AMF: &amfClient{client: client, root: cfg.AMFURI},
+		NRF: &nrfClient{client: client, root: cfg.NRFURI},
+	}
+}
+
+// nrfClient calls the NRF over HTTP
+type nrfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// SearchNFInstances implements NRF
+func (c *nrfClient) SearchNFInstances(ctx context.Context, target, requester models.NfType) (*models.NfDiscoveryResponse, error) {
+	query := url.Values{
+		"target-nf-type":    []string{string(target)},
+		"requester-nf-type": []string{string(requester)},
+	}
+	rsp := &models.NfDiscoveryResponse{}
+	if err := c.client.Get(ctx, c.root+"/nnrf-disc/v1/nf-instances?"+query.Encode(), rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// udmClient calls the UDM over HTTP
+type udmClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// GetSMData implements UDM
+func (c *udmClient) GetSMData(ctx context.Context, supi string, snssai models.Snssai, dnn string) ([]models.SessionManagementSubscriptionData, error) {
+	query, err := jsonQuery("single-nssai", snssai)
+	if err != nil {
+		return nil, err
+	}
+	query.Set("dnn", dnn)
+
+	var data []models.SessionManagementSubscriptionData
+	if err := c.client.Get(ctx, c.root+"/nudm-sdm/v2/"+url.PathEscape(supi)+"/sm-data?"+query.Encode(), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// pcfClient calls the PCF over HTTP
+type pcfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// CreateSMPolicy implements PCF
+func (c *pcfClient) CreateSMPolicy(ctx context.Context, data models.SmPolicyContextData) (string, *models.SmPolicyDecision, error) {
+	decision := &models.SmPolicyDecision{}
+	location, err := c.client.Create(ctx, c.root+"/npcf-smpolicycontrol/v1/sm-policies", data, decision)
+	if err != nil {
+		return "", nil, err
+	}
+	return location, decision, nil
+}
+
+// DeleteSMPolicy implements PCF
+func (c *pcfClient) DeleteSMPolicy(ctx context.Context, uri string) error {
+	return c.client.Post(ctx, uri+"/delete", struct{}{}, nil)
+}
+
+// amfClient calls the AMF over HTTP
+type amfClient struct {
+	client *sbi.Client
+	root   string
+}
+
+// N1N2MessageTransfer implements AMF. The N1 message and the NGAP
+// transfer are sent as binary parts referred to by their containers.
+func (c *amfClient) N1N2MessageTransfer(ctx context.Context, supi string, req models.N1N2MessageTransferReqData) (*models.N1N2MessageTransferRspData, error) {
+	body := &sbi.Multipart{JSON: &req}
+	if req.N1MessageContainer != nil {
+		req.N1MessageContainer.N1MessageContent.ContentID = "n1msg"
+		body.Parts = append(body.Parts, sbi.Part{ContentID: "n1msg", ContentType: sbi.ContentTypeNAS, Body: req.BinaryDataN1Message})
+	}
+	if n2 := req.N2InfoContainer; n2 != nil && n2.SmInfo != nil && n2.SmInfo.N2InfoContent != nil {
+		n2.SmInfo.N2InfoContent.NgapData.ContentID = "n2msg"
+		body.Parts = append(body.Parts, sbi.Part{ContentID: "n2msg", ContentType: sbi.ContentTypeNGAP, Body: req.BinaryDataN2Information})
+	}
+
+	rsp := &models.N1N2MessageTransferRspData{}
+	if err := c.client.Post(ctx, c.root+"/namf-comm/v1/ue-contexts/"+url.PathEscape(supi)+"/n1-n2-messages", body, rsp); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// NotifySMContextStatus implements AMF
+func (c *amfClient) NotifySMContextStatus(ctx context.Context, uri string, n models.SmContextStatusNotification) error {
+	return c.client.Post(ctx, uri, n, nil)
+}
+
+// SubscribeEvents implements AMF
+func (c *amfClient) SubscribeEvents(ctx context.Context, sub models.AmfEventSubscription) (string, error) {
+	root := c.root + "/namf-evts/v1/subscriptions"
+	created := &models.AmfCreatedEventSubscription{}
+	location, err := c.client.Create(ctx, root, models.AmfCreateEventSubscription{Subscription: sub}, created)
+	if err != nil {
+		return "", err
+	}
+	if location == "" {
+		location = root + "/" + url.PathEscape(created.SubscriptionID)
+	}
+	return location, nil
+}
+
+// UnsubscribeEvents implements AMF
+func (c *amfClient) UnsubscribeEvents(ctx context.Context, uri string) error {
+	return c.client.Delete(ctx, uri)
+}
+
+// jsonQuery returns a query holding v encoded as JSON, the encoding of
+// structured SBI query parameters
+func jsonQuery(name string, v interface{}) (url.Values, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: %w", name, err)
+	}
+	return url.Values{name: []string{string(b)}}, nil
+}
@@ -0,0 +1,163 @@
+package smf
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// defaultQFI is the QoS flow of the default QoS rule
+const defaultQFI = 1
+
+// SMContext is the context of a PDU session in the SMF. Its procedures
+// run one at a time, holding mu.
+type SMContext struct {
+	// Ref is the SM context reference given to the AMF
+	Ref string
+
+	SUPI         string
+	PDUSessionID uint8
+	DNN          string
+	SNSSAI       models.Snssai
+
+	ServingNetwork models.PlmnID
+	AnType         models.AccessType
+	RatType        models.RatType
+
+	// TAI is where the UE requested the PDU session, nil when unknown
+	TAI *models.Tai
+
+	// StatusURI is notified when the SMF releases the context
+	StatusURI string
+
+	// Granted by the establishment
+	PDUSessionType nas.PDUSessionType
+	SSCMode        nas.SSCMode
+	AMBR           nas.SessionAMBR
+	QoS            DefaultQoS
+
+	// UEAddress is the IPv4 address and UEPrefix the IPv6 prefix of the
+	// UE, nil when the PDU session type has none
+	UEAddress net.IP
+	UEPrefix  *net.IPNet
+
+	// ipv4Lease is the lease of UEAddress and ipv6Lease the one of
+	// UEPrefix
+	ipv4Lease *Lease
+	ipv6Lease *Lease
+
+	// Rules are the PCC rules of the PDU session and Flows its QoS flows
+	// other than the default one
+	Rules []PCCRule
+	Flows []QoSFlow
+
+	// qfis and ruleIDs hold the QFI of each QoS decision and the QoS
+	// rule ID of each PCC rule
+	qfis    map[string]uint8
+	ruleIDs map[string]uint8
+
+	// PolicyURI is the SM policy association in the PCF
+	PolicyURI string
+
+	// policy is the SM policy decision with the updates of the PCF
+	policy models.SmPolicyDecision
+
+	// UPF and N4 session of the user plane facing the gNB: the PSA, or
+	// the I-UPF in front of it
+	UPF *UPF
+	N4  *N4Session
+
+	// PSA and N4 session of the anchor behind the I-UPF, nil when the
+	// UPF anchors the PDU session
+	PSA   *UPF
+	PSAN4 *N4Session
+
+	UpCnxState models.UpCnxState
+
+	// MobilitySubscription is the subscription to the location of the UE
+	// in the AMF, set for the PDU sessions of SSC mode 2 and 3
+	MobilitySubscription string
+
+	// relocation releases a PDU session of SSC mode 3 once the UE had
+	// time to move to a new one, nil until it is asked to
+	relocation *time.Timer
+
+	// charging is the charging of the PDU session, nil when it is not
+	// charged
+	charging *chargingSession
+
+	mu sync.Mutex
+
+	// pti is the procedure transaction of the establishment
+	pti uint8
+
+	// established tells that the PDU session was accepted, released that
+	// the context is gone
+	established bool
+	released    bool
+
+	log *zap.Logger
+}
+
+// DefaultQoS is the QoS of the default QoS flow of a PDU session
+type DefaultQoS struct {
+	FiveQI uint8
+
+	// PriorityLevel overrides the priority of the 5QI when not 0
+	PriorityLevel uint8
+
+	ARP ngap.AllocationAndRetentionPriority
+}
+
+// anchor returns the UPF anchoring the PDU session
+func (c *SMContext) anchor() *UPF {
+	if c.PSA != nil {
+		return c.PSA
+	}
+	return c.UPF
+}
+
+// addresses returns the addresses of the UE, for logs
+func (c *SMContext) addresses() string {
+	var a []string
+	if c.UEAddress != nil {
+		a = append(a, c.UEAddress.String())
+	}
+	if c.UEPrefix != nil {
+		a = append(a, c.UEPrefix.String())
+	}
+	return strings.Join(a, " ")
+}
+
+// setupTransfer returns the NGAP transfer asking the gNB to set up the
+// resources of the PDU session
+func (c *SMContext) setupTransfer() ([]byte, error) {
+	return ngap.EncodeSetupRequestTransfer(&ngap.PDUSessionResourceSetupRequestTransfer{
+		AMBR:           &ngap.UEAggregateMaximumBitRate{Downlink: c.AMBR.Downlink, Uplink: c.AMBR.Uplink},
+		ULTunnel:       c.N4.ULTunnel,
+		PDUSessionType: ngapSessionType(c.PDUSessionType),
+		QosFlows:       c.sessionQoS().setupItems(),
+	})
+}
+
+// ngapSessionType returns the NGAP value of a PDU session type
+func ngapSessionType(t nas.PDUSessionType) ngap.PDUSessionType {
+	switch t {
+	case nas.PDUSessionTypeIPv6:
+		return ngap.PDUSessionTypeIPv6
+	case nas.PDUSessionTypeIPv4v6:
+		return ngap.PDUSessionTypeIPv4v6
+	case nas.PDUSessionTypeEthernet:
+		return ngap.PDUSessionTypeEthernet
+	case nas.PDUSessionTypeUnstructured:
+		return ngap.PDUSessionTypeUnstructured
+	default:
+		return ngap.PDUSessionTypeIPv4
+	}
+}
@@ -0,0 +1,135 @@
+package smf
+
+import (
+	"context"
+	"fmt"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// updatePolicy applies a policy update of the PCF to a PDU session. When
+// its QoS changes, the session is modified in the UPFs, then in the UE
+// and the gNB (TS 23.502 4.3.3.2). It runs holding c.mu.
+func (s *SMF) updatePolicy(ctx context.Context, c *SMContext, update *models.SmPolicyDecision) error {
+	policy := c.policy
+	mergePolicy(&policy, update)
+	next, err := c.decideQoS(&policy)
+	if err != nil {
+		return apperrors.NewBadRequestError("Invalid SM policy decision", err)
+	}
+
+	changes := diffQoS(c.sessionQoS(), next)
+	if changes.empty() {
+		c.policy = policy
+		c.setQoS(next)
+		return nil
+	}
+	if err := s.modifyUserPlane(ctx, c, next); err != nil {
+		c.log.Warn("Failed to modify QoS of the user plane", zap.Error(err))
+		return apperrors.NewInternalError("Failed to update the user plane", err)
+	}
+	c.policy = policy
+	c.setQoS(next)
+
+	if err := s.sendModificationCommand(ctx, c, changes); err != nil {
+		c.log.Warn("Failed to send PDU Session Modification Command", zap.Error(err))
+		return apperrors.NewInternalError("Failed to modify the PDU session in the UE", err)
+	}
+	c.log.Info("PDU session QoS modified", zap.Int("qos_flows", len(next.Flows)+1), zap.Int("qos_rules", len(next.Rules)+1))
+	return nil
+}
+
+// modifyUserPlane applies the QoS of a PDU session to its N4 sessions.
+// When a UPF rejects it, the UPFs already modified get their previous
+// QoS back.
+func (s *SMF) modifyUserPlane(ctx context.Context, c *SMContext, q sessionQoS) error {
+	type n4Session struct {
+		upf *UPF
+		n4  *N4Session
+		old N4Session
+	}
+	var sessions []n4Session
+	for _, n := range []n4Session{{upf: c.UPF, n4: c.N4}, {upf: c.PSA, n4: c.PSAN4}} {
+		if n.n4 != nil {
+			n.old = *n.n4
+			sessions = append(sessions, n)
+		}
+	}
+
+	for i, n := range sessions {
+		n.n4.AMBR = ngap.UEAggregateMaximumBitRate{Downlink: q.AMBR.Downlink, Uplink: q.AMBR.Uplink}
+		n.n4.Rules, n.n4.Flows = q.Rules, q.Flows
+		err := s.nfs.N4.ModifySession(ctx, n.upf, n.n4)
+		if err == nil {
+			continue
+		}
+
+		*n.n4 = n.old
+		for _, done := range sessions[:i] {
+			*done.n4 = done.old
+			if err := s.nfs.N4.ModifySession(ctx, done.upf, done.n4); err != nil {
+				c.log.Warn("Failed to restore N4 session", zap.String("upf", done.upf.NodeID), zap.Error(err))
+			}
+		}
+		return fmt.Errorf("modifying N4 session in UPF %s: %w", n.upf.NodeID, err)
+	}
+	return nil
+}
+
+// sendModificationCommand sends the PDU Session Modification Command
+// telling the UE the QoS changes, with the changes of the QoS flows and
+// AMBR for the gNB when the user plane is activated. Otherwise the gNB
+// gets them when the user plane is next activated.
+func (s *SMF) sendModificationCommand(ctx context.Context, c *SMContext, ch *qosChanges) error {
+	cmd := &nas.PDUSessionModificationCommand{
+		SMHeader:    nas.SMHeader{PDUSessionID: c.PDUSessionID},
+		SessionAMBR: ch.ambr,
+	}
+	var err error
+	if len(ch.rules) > 0 {
+		if cmd.AuthorizedQoSRules, err = ch.rules.Bytes(); err != nil {
+			return err
+		}
+	}
+	if len(ch.flows) > 0 {
+		if cmd.AuthorizedQoSFlowDescriptions, err = ch.flows.Bytes(); err != nil {
+			return err
+		}
+	}
+	n1, err := nas.Encode(cmd)
+	if err != nil {
+		return err
+	}
+
+	req := models.N1N2MessageTransferReqData{
+		N1MessageContainer:  &models.N1MessageContainer{N1MessageClass: models.N1MessageClassSM},
+		PduSessionID:        c.PDUSessionID,
+		BinaryDataN1Message: n1,
+	}
+	gnb := ch.ambr != nil || len(ch.addOrModify) > 0 || len(ch.release) > 0
+	if gnb && c.UpCnxState == models.UpCnxStateActivated {
+		transfer := &ngap.PDUSessionResourceModifyRequestTransfer{AddOrModify: ch.addOrModify, Release: ch.release}
+		if ch.ambr != nil {
+			transfer.AMBR = &ngap.UEAggregateMaximumBitRate{Downlink: ch.ambr.Downlink, Uplink: ch.ambr.Uplink}
+		}
+		if req.BinaryDataN2Information, err = ngap.EncodeModifyRequestTransfer(transfer); err != nil {
+			return err
+		}
+		snssai := c.SNSSAI
+		req.N2InfoContainer = &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClassSM,
+			SmInfo: &models.N2SmInformation{
+				PduSessionID:  c.PDUSessionID,
+				N2InfoContent: &models.N2InfoContent{NgapIeType: models.N2SmInfoPduResModReq},
+				SNssai:        &snssai,
+			},
+		}
+	}
+
+	_, err = s.nfs.AMF.N1N2MessageTransfer(ctx, c.SUPI, req)
+	return err
+}
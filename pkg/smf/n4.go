@@ -0,0 +1,628 @@
+package smf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// Rule IDs of the N4 sessions: one PDR and FAR per direction, the
+// session AMBR QER and the BAR buffering downlink packets while the user
+// plane connection is deactivated. PCC rules add PDRs, see pdrIDs, QoS
+// flows QERs, see flowQERID, and QoS rules URRs, see urrID.
+const (
+	pdrUplink   uint16 = 1
+	pdrDownlink uint16 = 2
+	farUplink   uint32 = 1
+	farDownlink uint32 = 2
+	qerAMBR     uint32 = 1
+	barDownlink uint8  = 1
+
+	// pdrPrecedence is the precedence of the PDRs
+	pdrPrecedence = 255
+)
+
+// N4Client implements N4 over PFCP. An association is set up with each
+// UPF before its first session, and again once it restarted.
+type N4Client struct {
+	node   *pfcp.Node
+	nodeID pfcp.NodeID
+	log    *zap.Logger
+
+	mu         sync.Mutex
+	associated map[string]time.Time // recovery time stamps by N4 address
+	sessions   map[uint64]uint64    // remote SEIDs by local SEID
+	installed  map[uint64]n4Rules   // PDRs, QERs and URRs set up by local SEID
+	reports    ReportHandler
+}
+
+// NewN4Client listens for PFCP on the configured address
+func NewN4Client(cfg *Config) (*N4Client, error) {
+	if cfg.PFCPNodeID == nil {
+		return nil, fmt.Errorf("no PFCP node ID configured")
+	}
+	c := &N4Client{
+		nodeID:     pfcp.NewNodeID(cfg.PFCPNodeID.String()),
+		log:        logger.Named("n4"),
+		associated: make(map[string]time.Time),
+		sessions:   make(map[uint64]uint64),
+		installed:  make(map[uint64]n4Rules),
+	}
+	node, err := pfcp.Listen(pfcp.NodeConfig{
+		Address: cfg.PFCPAddress,
+		T1:      cfg.PFCPT1,
+		N1:      cfg.PFCPN1,
+		Handler: c.handle,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.node = node
+	return c, nil
+}
+
+// Close stops listening for PFCP
+func (c *N4Client) Close() error {
+	return c.node.Close()
+}
+
+// EstablishSession implements N4
+func (c *N4Client) EstablishSession(ctx context.Context, upf *UPF, s *N4Session) error {
+	if err := c.associate(ctx, upf); err != nil {
+		return err
+	}
+
+	rules := sessionRules(s)
+	req := &pfcp.SessionEstablishmentRequest{
+		NodeID:     c.nodeID,
+		CPFSEID:    c.fseid(s.LocalSEID),
+		CreateFARs: []pfcp.CreateFAR{uplinkFAR(s), downlinkFAR(s)},
+		CreateBAR:  &pfcp.BAR{BARID: barDownlink},
+		PDNType:    pdnType(s.Type),
+	}
+	for _, id := range sortedIDs(rules.pdrs) {
+		req.CreatePDRs = append(req.CreatePDRs, rules.pdrs[id])
+	}
+	for _, id := range sortedIDs(rules.qers) {
+		req.CreateQERs = append(req.CreateQERs, rules.qers[id])
+	}
+	for _, id := range sortedIDs(rules.urrs) {
+		req.CreateURRs = append(req.CreateURRs, rules.urrs[id])
+	}
+	rsp, err := c.node.Request(ctx, upf.N4Address, 0, req)
+	if err != nil {
+		return err
+	}
+	r := rsp.(*pfcp.SessionEstablishmentResponse)
+	if !r.Cause.Accepted() {
+		return fmt.Errorf("session establishment rejected: %s", r.Cause)
+	}
+	if r.UPFSEID == nil {
+		return fmt.Errorf("session establishment response without F-SEID")
+	}
+
+	s.RemoteSEID = r.UPFSEID.SEID
+	c.mu.Lock()
+	c.sessions[s.LocalSEID] = s.RemoteSEID
+	c.installed[s.LocalSEID] = rules
+	c.mu.Unlock()
+	return nil
+}
+
+// ModifySession implements N4, updating the downlink FAR and the PDRs,
+// QERs and URRs that changed
+func (c *N4Client) ModifySession(ctx context.Context, upf *UPF, s *N4Session) error {
+	far := downlinkFAR(s)
+	req := &pfcp.SessionModificationRequest{
+		UpdateFARs: []pfcp.UpdateFAR{{
+			FARID:                far.FARID,
+			ApplyAction:          &far.ApplyAction,
+			ForwardingParameters: far.ForwardingParameters,
+		}},
+	}
+	rules := sessionRules(s)
+	c.mu.Lock()
+	installed := c.installed[s.LocalSEID]
+	c.mu.Unlock()
+	diffRules(installed, rules, req)
+
+	rsp, err := c.node.Request(ctx, upf.N4Address, s.RemoteSEID, req)
+	if err != nil {
+		return err
+	}
+	r := rsp.(*pfcp.SessionModificationResponse)
+	if !r.Cause.Accepted() {
+		return fmt.Errorf("session modification rejected: %s", r.Cause)
+	}
+
+	c.mu.Lock()
+	if _, ok := c.sessions[s.LocalSEID]; ok {
+		c.installed[s.LocalSEID] = rules
+	}
+	c.mu.Unlock()
+	c.report(s.LocalSEID, r.UsageReports)
+	return nil
+}
+
+// DeleteSession implements N4
+func (c *N4Client) DeleteSession(ctx context.Context, upf *UPF, s *N4Session) error {
+	c.mu.Lock()
+	delete(c.sessions, s.LocalSEID)
+	delete(c.installed, s.LocalSEID)
+	c.mu.Unlock()
+
+	rsp, err := c.node.Request(ctx, upf.N4Address, s.RemoteSEID, &pfcp.SessionDeletionRequest{})
+	if err != nil {
+		return err
+	}
+	r := rsp.(*pfcp.SessionDeletionResponse)
+	if !r.Cause.Accepted() && r.Cause != pfcp.CauseSessionContextNotFound {
+		return fmt.Errorf("session deletion rejected: %s", r.Cause)
+	}
+	c.report(s.LocalSEID, r.UsageReports)
+	return nil
+}
+
+// SetReportHandler implements N4
+func (c *N4Client) SetReportHandler(h ReportHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reports = h
+}
+
+// report passes the usage reports of a session to the report handler
+func (c *N4Client) report(seid uint64, reports []pfcp.UsageReport) {
+	c.mu.Lock()
+	h := c.reports
+	c.mu.Unlock()
+	if h == nil || len(reports) == 0 {
+		return
+	}
+
+	usage := make([]UsageReport, 0, len(reports))
+	for _, r := range reports {
+		u := UsageReport{RuleID: uint8(r.URRID), Trigger: r.Trigger, Start: r.StartTime, End: r.EndTime}
+		if v := r.Volume; v != nil {
+			for _, f := range []struct {
+				dst *uint64
+				src *uint64
+			}{{&u.Total, v.Total}, {&u.Uplink, v.Uplink}, {&u.Downlink, v.Downlink}} {
+				if f.src != nil {
+					*f.dst = *f.src
+				}
+			}
+		}
+		if r.Duration != nil {
+			u.Duration = time.Duration(*r.Duration) * time.Second
+		}
+		usage = append(usage, u)
+	}
+	h(seid, usage)
+}
+
+// Heartbeat implements N4. A UPF answering with a new recovery time
+// stamp restarted and lost its association and sessions.
+func (c *N4Client) Heartbeat(ctx context.Context, upf *UPF) error {
+	recovery, err := c.node.Heartbeat(ctx, upf.N4Address)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if known, ok := c.associated[upf.N4Address]; ok && !known.Equal(recovery) {
+		c.log.Warn("UPF restarted", zap.String("upf", upf.NodeID), zap.Time("recovery_time", recovery))
+		delete(c.associated, upf.N4Address)
+	}
+	return nil
+}
+
+// associate sets up the association with a UPF unless there is one
+func (c *N4Client) associate(ctx context.Context, upf *UPF) error {
+	c.mu.Lock()
+	_, ok := c.associated[upf.N4Address]
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	req := &pfcp.AssociationSetupRequest{NodeID: c.nodeID, RecoveryTimeStamp: c.node.RecoveryTimeStamp()}
+	rsp, err := c.node.Request(ctx, upf.N4Address, 0, req)
+	if err != nil {
+		return fmt.Errorf("association setup: %w", err)
+	}
+	r := rsp.(*pfcp.AssociationSetupResponse)
+	if !r.Cause.Accepted() {
+		return fmt.Errorf("association setup rejected: %s", r.Cause)
+	}
+
+	c.mu.Lock()
+	c.associated[upf.N4Address] = r.RecoveryTimeStamp
+	c.mu.Unlock()
+	c.log.Info("PFCP association set up", zap.String("upf", upf.NodeID), zap.Stringer("node_id", r.NodeID))
+	return nil
+}
+
+// handle answers the requests of the UPFs
+func (c *N4Client) handle(req *pfcp.Request) (pfcp.Message, uint64) {
+	switch m := req.Message.(type) {
+	case *pfcp.SessionReportRequest:
+		c.mu.Lock()
+		remote, ok := c.sessions[req.Header.SEID]
+		c.mu.Unlock()
+		if !ok {
+			return &pfcp.SessionReportResponse{Cause: pfcp.CauseSessionContextNotFound}, 0
+		}
+		c.log.Debug("Session report", zap.Uint64("seid", req.Header.SEID), zap.Uint8("report_type", uint8(m.ReportType)))
+		if m.ReportType&pfcp.ReportUsage != 0 {
+			c.report(req.Header.SEID, m.UsageReports)
+		}
+		return &pfcp.SessionReportResponse{Cause: pfcp.CauseRequestAccepted}, remote
+
+	case *pfcp.AssociationUpdateRequest:
+		if m.ReleaseRequest {
+			c.forget(req.Peer)
+			c.log.Info("UPF asked to release its PFCP association", zap.Stringer("node_id", m.NodeID))
+		}
+		return &pfcp.AssociationUpdateResponse{NodeID: c.nodeID, Cause: pfcp.CauseRequestAccepted}, 0
+
+	case *pfcp.AssociationReleaseRequest:
+		c.forget(req.Peer)
+		c.log.Info("PFCP association released", zap.Stringer("node_id", m.NodeID))
+		return &pfcp.AssociationReleaseResponse{NodeID: c.nodeID, Cause: pfcp.CauseRequestAccepted}, 0
+
+	case *pfcp.AssociationSetupRequest:
+		return &pfcp.AssociationSetupResponse{NodeID: c.nodeID, Cause: pfcp.CauseServiceNotSupported}, 0
+
+	default:
+		return nil, 0
+	}
+}
+
+// forget drops the association with a UPF, set up again before its next
+// session
+func (c *N4Client) forget(peer *net.UDPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr := range c.associated {
+		if a, err := net.ResolveUDPAddr("udp", addr); err == nil && a.IP.Equal(peer.IP) && a.Port == peer.Port {
+			delete(c.associated, addr)
+		}
+	}
+}
+
+// fseid returns the F-SEID of a session in the SMF
+func (c *N4Client) fseid(seid uint64) pfcp.FSEID {
+	if ip := c.nodeID.IP.To4(); ip != nil {
+		return pfcp.FSEID{SEID: seid, IPv4: ip}
+	}
+	return pfcp.FSEID{SEID: seid, IPv6: c.nodeID.IP}
+}
+
+// uplinkPDR matches the uplink packets of the UL tunnel
+func uplinkPDR(s *N4Session) pfcp.CreatePDR {
+	far := farUplink
+	removal := headerRemoval(s.ULTunnel.Address)
+	return pfcp.CreatePDR{
+		PDRID:      pdrUplink,
+		Precedence: pdrPrecedence,
+		PDI: pfcp.PDI{
+			SourceInterface: pfcp.InterfaceAccess,
+			FTEID:           fteid(s.ULTunnel),
+			UEIPAddress:     ueIPAddress(s, false),
+		},
+		OuterHeaderRemoval: &removal,
+		FARID:              &far,
+		QERIDs:             []uint32{qerAMBR, flowQERID(defaultQFI)},
+		URRIDs:             sessionURRs(s, defaultRuleID),
+	}
+}
+
+// downlinkPDR matches the downlink packets to the UE, received on the
+// N9 tunnel by an I-UPF
+func downlinkPDR(s *N4Session) pfcp.CreatePDR {
+	far := farDownlink
+	pdr := pfcp.CreatePDR{
+		PDRID:      pdrDownlink,
+		Precedence: pdrPrecedence,
+		PDI: pfcp.PDI{
+			SourceInterface:    pfcp.InterfaceCore,
+			NetworkInstance:    s.DNN,
+			UEIPAddress:        ueIPAddress(s, true),
+			EthernetPDUSession: s.Type == nas.PDUSessionTypeEthernet,
+		},
+		FARID:  &far,
+		QERIDs: []uint32{qerAMBR, flowQERID(defaultQFI)},
+		URRIDs: sessionURRs(s, defaultRuleID),
+	}
+	if s.N9Tunnel != nil {
+		removal := headerRemoval(s.N9Tunnel.Address)
+		pdr.PDI.FTEID = fteid(*s.N9Tunnel)
+		pdr.PDI.NetworkInstance = ""
+		pdr.PDI.EthernetPDUSession = false
+		pdr.OuterHeaderRemoval = &removal
+	}
+	return pdr
+}
+
+// ueIPAddress returns the addresses of the UE matched by the PDRs of a
+// session, as destination for downlink ones, nil when it has none. UE
+// prefixes are /64, the default of N4.
+func ueIPAddress(s *N4Session, destination bool) *pfcp.UEIPAddress {
+	if s.UEAddress == nil && s.UEPrefix == nil {
+		return nil
+	}
+	a := &pfcp.UEIPAddress{IPv4: s.UEAddress.To4(), Destination: destination}
+	if s.UEPrefix != nil {
+		a.IPv6 = s.UEPrefix.IP
+	}
+	return a
+}
+
+// pdnType returns the PFCP type of a PDU session type
+func pdnType(t nas.PDUSessionType) pfcp.PDNType {
+	switch t {
+	case nas.PDUSessionTypeIPv6:
+		return pfcp.PDNTypeIPv6
+	case nas.PDUSessionTypeIPv4v6:
+		return pfcp.PDNTypeIPv4v6
+	case nas.PDUSessionTypeEthernet:
+		return pfcp.PDNTypeEthernet
+	case nas.PDUSessionTypeUnstructured:
+		return pfcp.PDNTypeNonIP
+	default:
+		return pfcp.PDNTypeIPv4
+	}
+}
+
+// uplinkFAR forwards uplink packets to the data network, or to the
+// anchor from an I-UPF
+func uplinkFAR(s *N4Session) pfcp.CreateFAR {
+	fp := &pfcp.ForwardingParameters{DestinationInterface: pfcp.InterfaceCore, NetworkInstance: s.DNN}
+	if s.Uplink != nil {
+		fp.NetworkInstance = ""
+		fp.OuterHeaderCreation = pfcp.GTPUTunnel(s.Uplink.TEID, s.Uplink.Address)
+	}
+	return pfcp.CreateFAR{FARID: farUplink, ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: fp}
+}
+
+// downlinkFAR forwards downlink packets to the DL tunnel, or buffers them
+// and notifies the SMF while there is none
+func downlinkFAR(s *N4Session) pfcp.CreateFAR {
+	if s.DLTunnel == nil {
+		bar := barDownlink
+		return pfcp.CreateFAR{FARID: farDownlink, ApplyAction: pfcp.ApplyActionBuffer | pfcp.ApplyActionNotifyCP, BARID: &bar}
+	}
+	return pfcp.CreateFAR{
+		FARID:       farDownlink,
+		ApplyAction: pfcp.ApplyActionForward,
+		ForwardingParameters: &pfcp.ForwardingParameters{
+			DestinationInterface: pfcp.InterfaceAccess,
+			OuterHeaderCreation:  pfcp.GTPUTunnel(s.DLTunnel.TEID, s.DLTunnel.Address),
+		},
+	}
+}
+
+// ambrQER enforces the session AMBR, in kbps on N4
+func ambrQER(s *N4Session) pfcp.QER {
+	return pfcp.QER{
+		QERID: qerAMBR,
+		MBR:   &pfcp.BitRate{UL: s.AMBR.Uplink / 1000, DL: s.AMBR.Downlink / 1000},
+	}
+}
+
+// flowQER marks the packets of a QoS flow with its QFI and enforces the
+// bit rates of a GBR flow, in kbps on N4
+func flowQER(f QoSFlow) pfcp.QER {
+	qfi := f.QFI
+	q := pfcp.QER{QERID: flowQERID(qfi), QFI: &qfi}
+	if f.GBR != nil {
+		q.MBR = &pfcp.BitRate{UL: f.GBR.MaximumFlowBitRateUL / 1000, DL: f.GBR.MaximumFlowBitRateDL / 1000}
+		q.GBR = &pfcp.BitRate{UL: f.GBR.GuaranteedFlowBitRateUL / 1000, DL: f.GBR.GuaranteedFlowBitRateDL / 1000}
+	}
+	return q
+}
+
+// flowQERID returns the QER of a QoS flow
+func flowQERID(qfi uint8) uint32 {
+	return qerAMBR + uint32(qfi)
+}
+
+// usageURR measures the traffic of a QoS rule, reporting it at the
+// thresholds of the session
+func usageURR(ruleID uint8, r *UsageReporting) pfcp.URR {
+	u := pfcp.URR{URRID: urrID(ruleID), MeasurementMethod: pfcp.MeasureVolume | pfcp.MeasureDuration}
+	if r.VolumeThreshold > 0 {
+		total := r.VolumeThreshold
+		u.ReportingTriggers |= pfcp.TriggerVolumeThreshold
+		u.VolumeThreshold = &pfcp.Volume{Total: &total}
+	}
+	if r.TimeThreshold > 0 {
+		seconds := uint32(r.TimeThreshold / time.Second)
+		u.ReportingTriggers |= pfcp.TriggerTimeThreshold
+		u.TimeThreshold = &seconds
+	}
+	return u
+}
+
+// urrID returns the URR of a QoS rule
+func urrID(ruleID uint8) uint32 {
+	return uint32(ruleID)
+}
+
+// sessionURRs returns the URRs of the PDRs of a QoS rule, none when the
+// session reports no usage
+func sessionURRs(s *N4Session, ruleID uint8) []uint32 {
+	if s.Reporting == nil {
+		return nil
+	}
+	return []uint32{urrID(ruleID)}
+}
+
+// pdrIDs returns the uplink and downlink PDRs of a QoS rule, pdrUplink
+// and pdrDownlink for the default one
+func pdrIDs(ruleID uint8) (ul, dl uint16) {
+	return 2*uint16(ruleID) - 1, 2 * uint16(ruleID)
+}
+
+// n4Rules are the PDRs, QERs and URRs of an N4 session by ID
+type n4Rules struct {
+	pdrs map[uint16]pfcp.CreatePDR
+	qers map[uint32]pfcp.QER
+	urrs map[uint32]pfcp.URR
+}
+
+// sessionRules returns the PDRs, QERs and URRs of a session. A PCC rule
+// has a PDR for each direction it has packet filters for, matching the
+// packets of the default PDR of the direction with its filters, and a
+// URR when the session reports usage.
+func sessionRules(s *N4Session) n4Rules {
+	r := n4Rules{
+		pdrs: make(map[uint16]pfcp.CreatePDR),
+		qers: make(map[uint32]pfcp.QER),
+		urrs: make(map[uint32]pfcp.URR),
+	}
+	if s.Reporting != nil {
+		u := usageURR(defaultRuleID, s.Reporting)
+		r.urrs[u.URRID] = u
+	}
+	ambr := ambrQER(s)
+	r.qers[ambr.QERID] = ambr
+	for _, f := range append([]QoSFlow{{QFI: defaultQFI}}, s.Flows...) {
+		q := flowQER(f)
+		r.qers[q.QERID] = q
+	}
+
+	ul, dl := uplinkPDR(s), downlinkPDR(s)
+	r.pdrs[ul.PDRID], r.pdrs[dl.PDRID] = ul, dl
+	for _, rule := range s.Rules {
+		var ulFilters, dlFilters []pfcp.SDFFilter
+		for _, f := range rule.Filters {
+			sdf := pfcp.SDFFilter{FlowDescription: f.Description}
+			if f.Direction != nas.PacketFilterDownlink {
+				ulFilters = append(ulFilters, sdf)
+			}
+			if f.Direction != nas.PacketFilterUplink {
+				dlFilters = append(dlFilters, sdf)
+			}
+		}
+
+		ulID, dlID := pdrIDs(rule.RuleID)
+		for _, p := range []struct {
+			id      uint16
+			pdr     pfcp.CreatePDR
+			filters []pfcp.SDFFilter
+		}{{ulID, uplinkPDR(s), ulFilters}, {dlID, downlinkPDR(s), dlFilters}} {
+			if len(p.filters) == 0 {
+				continue
+			}
+			p.pdr.PDRID, p.pdr.Precedence = p.id, uint32(rule.Precedence)
+			p.pdr.PDI.SDFFilters = p.filters
+			p.pdr.QERIDs = []uint32{qerAMBR, flowQERID(rule.QFI)}
+			p.pdr.URRIDs = sessionURRs(s, rule.RuleID)
+			r.pdrs[p.id] = p.pdr
+		}
+		if s.Reporting != nil {
+			u := usageURR(rule.RuleID, s.Reporting)
+			r.urrs[u.URRID] = u
+		}
+	}
+	return r
+}
+
+// diffRules adds to a modification the PDRs, QERs and URRs to create,
+// update and remove to go from the installed rules to the next ones
+func diffRules(installed, next n4Rules, req *pfcp.SessionModificationRequest) {
+	for _, id := range sortedIDs(next.pdrs) {
+		pdr := next.pdrs[id]
+		old, ok := installed.pdrs[id]
+		switch {
+		case !ok:
+			req.CreatePDRs = append(req.CreatePDRs, pdr)
+		case !reflect.DeepEqual(old, pdr):
+			req.UpdatePDRs = append(req.UpdatePDRs, pfcp.UpdatePDR{
+				PDRID:              id,
+				OuterHeaderRemoval: pdr.OuterHeaderRemoval,
+				Precedence:         &pdr.Precedence,
+				PDI:                &pdr.PDI,
+				FARID:              pdr.FARID,
+				URRIDs:             pdr.URRIDs,
+				QERIDs:             pdr.QERIDs,
+			})
+		}
+	}
+	for _, id := range sortedIDs(installed.pdrs) {
+		if _, ok := next.pdrs[id]; !ok {
+			req.RemovePDRs = append(req.RemovePDRs, id)
+		}
+	}
+
+	for _, id := range sortedIDs(next.qers) {
+		qer := next.qers[id]
+		old, ok := installed.qers[id]
+		switch {
+		case !ok:
+			req.CreateQERs = append(req.CreateQERs, qer)
+		case !reflect.DeepEqual(old, qer):
+			req.UpdateQERs = append(req.UpdateQERs, qer)
+		}
+	}
+	for _, id := range sortedIDs(installed.qers) {
+		if _, ok := next.qers[id]; !ok {
+			req.RemoveQERs = append(req.RemoveQERs, id)
+		}
+	}
+
+	for _, id := range sortedIDs(next.urrs) {
+		urr := next.urrs[id]
+		old, ok := installed.urrs[id]
+		switch {
+		case !ok:
+			req.CreateURRs = append(req.CreateURRs, urr)
+		case !reflect.DeepEqual(old, urr):
+			req.UpdateURRs = append(req.UpdateURRs, urr)
+		}
+	}
+	for _, id := range sortedIDs(installed.urrs) {
+		if _, ok := next.urrs[id]; !ok {
+			req.RemoveURRs = append(req.RemoveURRs, id)
+		}
+	}
+}
+
+// sortedIDs returns the IDs of rules in order
+func sortedIDs[K ~uint16 | ~uint32, V any](m map[K]V) []K {
+	ids := make([]K, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// fteid returns the F-TEID of a tunnel endpoint
+func fteid(t ngap.GTPTunnel) *pfcp.FTEID {
+	if ip := t.Address.To4(); ip != nil {
+		return &pfcp.FTEID{TEID: t.TEID, IPv4: ip}
+	}
+	return &pfcp.FTEID{TEID: t.TEID, IPv6: t.Address}
+}
+
+// headerRemoval returns the removal of the GTP-U header of packets
+// received at an address
+func headerRemoval(ip net.IP) pfcp.OuterHeaderRemoval {
+	if ip.To4() != nil {
+		return pfcp.OuterHeaderRemovalGTPUIPv4
+	}
+	return pfcp.OuterHeaderRemovalGTPUIPv6
+}
@@ -0,0 +1,684 @@
+package smf
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"go.uber.org/zap"
+)
+
+// rejection is a failed establishment step and the 5GSM cause given to
+// the UE for it
+type rejection struct {
+	cause nas.Cause5GSM
+	err   error
+}
+
+func (r *rejection) Error() string { return r.err.Error() }
+
+func (r *rejection) Unwrap() error { return r.err }
+
+// reject returns a rejection of the establishment with the given cause
+func reject(cause nas.Cause5GSM, format string, args ...interface{}) error {
+	return &rejection{cause: cause, err: fmt.Errorf(format, args...)}
+}
+
+// establish runs the PDU session establishment of a new SM context (TS
+// 23.502 4.3.2.2.1): it authorizes the session against the subscription
+// and the policy, sets up the user plane and sends the accept to the UE
+// with the N2 resources to set up in the gNB. The context is released
+// when any step fails.
+func (s *SMF) establish(c *SMContext, req *nas.PDUSessionEstablishmentRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		return
+	}
+
+	ctx := context.Background()
+	accept, err := s.setUp(ctx, c, req)
+	if err == nil {
+		err = s.sendAccept(ctx, c, accept)
+	}
+	if err != nil {
+		cause := nas.Cause5GSMNetworkFailure
+		var r *rejection
+		if errors.As(err, &r) {
+			cause = r.cause
+		}
+		c.log.Warn("PDU session establishment failed", zap.Stringer("cause", cause), zap.Error(err))
+		s.recordEstablishment(c.DNN, c.SNSSAI, cause)
+
+		s.teardown(ctx, c)
+		s.sendReject(ctx, c, cause)
+		s.notifyReleased(ctx, c, "")
+		return
+	}
+
+	c.established = true
+	c.UpCnxState = models.UpCnxStateActivating
+	s.recordEstablishment(c.DNN, c.SNSSAI, 0)
+	if s.metrics != nil {
+		s.metrics.ActiveSessions.WithLabelValues(c.DNN, metrics.SNSSAILabel(uint8(c.SNSSAI.Sst), c.SNSSAI.Sd)).Inc()
+	}
+	c.log.Info("PDU session established", zap.String("dnn", c.DNN), zap.Stringer("type", c.PDUSessionType),
+		zap.Uint8("ssc_mode", uint8(c.SSCMode)), zap.String("ue_address", c.addresses()), zap.String("upf", c.UPF.NodeID))
+	s.subscribeMobility(ctx, c)
+}
+
+// setUpUserPlane sets up a PDU session in the UPFs chosen for it. When a
+// UPF rejects the session the next path is tried.
+func (s *SMF) setUpUserPlane(ctx context.Context, c *SMContext) error {
+	paths := s.upfs.paths(ctx, c.DNN, c.SNSSAI, c.TAI)
+	if len(paths) == 0 {
+		return reject(nas.Cause5GSMInsufficientResourcesForSliceAndDNN, "no UPF serving DNN %s in slice %s", c.DNN, c.SNSSAI)
+	}
+
+	var err error
+	for _, p := range paths {
+		if err = s.establishPath(ctx, c, p); err == nil {
+			return nil
+		}
+		c.log.Warn("Failed to set up PDU session in UPF", zap.String("upf", p.PSA.NodeID), zap.Error(err))
+	}
+	return fmt.Errorf("no UPF accepted the PDU session: %w", err)
+}
+
+// establishPath creates the N4 sessions of a PDU session in the UPFs of
+// a path. Behind an I-UPF, the PSA receives uplink packets on its N9
+// interface and sends downlink packets to the N9 interface of the
+// I-UPF. Only the PSA reports usage.
+func (s *SMF) establishPath(ctx context.Context, c *SMContext, p upfPath) error {
+	ambr := ngap.UEAggregateMaximumBitRate{Downlink: c.AMBR.Downlink, Uplink: c.AMBR.Uplink}
+	psa := &N4Session{
+		LocalSEID: s.allocateSEID(),
+		DNN:       c.DNN,
+		Type:      c.PDUSessionType,
+		UEAddress: c.UEAddress,
+		UEPrefix:  c.UEPrefix,
+		AMBR:      ambr,
+		Rules:     c.Rules,
+		Flows:     c.Flows,
+		Reporting: s.usageReporting(),
+	}
+	if p.IUPF == nil {
+		psa.ULTunnel = ngap.GTPTunnel{Address: p.PSA.N3Address, TEID: p.PSA.allocateTEID()}
+		if err := s.nfs.N4.EstablishSession(ctx, p.PSA, psa); err != nil {
+			return fmt.Errorf("establishing N4 session in UPF %s: %w", p.PSA.NodeID, err)
+		}
+		p.PSA.addSessions(1)
+		c.UPF, c.N4 = p.PSA, psa
+		return nil
+	}
+
+	i := &N4Session{
+		LocalSEID: s.allocateSEID(),
+		DNN:       c.DNN,
+		Type:      c.PDUSessionType,
+		UEAddress: c.UEAddress,
+		UEPrefix:  c.UEPrefix,
+		ULTunnel:  ngap.GTPTunnel{Address: p.IUPF.N3Address, TEID: p.IUPF.allocateTEID()},
+		N9Tunnel:  &ngap.GTPTunnel{Address: p.IUPF.N9Address, TEID: p.IUPF.allocateTEID()},
+		AMBR:      ambr,
+		Rules:     c.Rules,
+		Flows:     c.Flows,
+	}
+	psa.ULTunnel = ngap.GTPTunnel{Address: p.PSA.N9Address, TEID: p.PSA.allocateTEID()}
+	psa.DLTunnel = i.N9Tunnel
+	ul := psa.ULTunnel
+	i.Uplink = &ul
+
+	if err := s.nfs.N4.EstablishSession(ctx, p.PSA, psa); err != nil {
+		return fmt.Errorf("establishing N4 session in PSA %s: %w", p.PSA.NodeID, err)
+	}
+	if err := s.nfs.N4.EstablishSession(ctx, p.IUPF, i); err != nil {
+		if err := s.nfs.N4.DeleteSession(ctx, p.PSA, psa); err != nil {
+			c.log.Warn("Failed to delete N4 session", zap.String("upf", p.PSA.NodeID), zap.Error(err))
+		}
+		return fmt.Errorf("establishing N4 session in I-UPF %s: %w", p.IUPF.NodeID, err)
+	}
+	p.PSA.addSessions(1)
+	p.IUPF.addSessions(1)
+	c.UPF, c.N4 = p.IUPF, i
+	c.PSA, c.PSAN4 = p.PSA, psa
+	return nil
+}
+
+// deleteN4Session removes an N4 session of a PDU session from its UPF
+func (s *SMF) deleteN4Session(ctx context.Context, c *SMContext, upf *UPF, n4 *N4Session) {
+	if err := s.nfs.N4.DeleteSession(ctx, upf, n4); err != nil {
+		c.log.Warn("Failed to delete N4 session", zap.String("upf", upf.NodeID), zap.Error(err))
+	}
+	upf.addSessions(-1)
+}
+
+// setUp authorizes a PDU session and sets it up in a UPF, returning the
+// accept for the UE
+func (s *SMF) setUp(ctx context.Context, c *SMContext, req *nas.PDUSessionEstablishmentRequest) (*nas.PDUSessionEstablishmentAccept, error) {
+	subs, err := s.nfs.UDM.GetSMData(ctx, c.SUPI, c.SNSSAI, c.DNN)
+	if err != nil {
+		return nil, fmt.Errorf("getting SM subscription data: %w", err)
+	}
+	dnn, ok := subscribedDNN(subs, c.SNSSAI, c.DNN)
+	if !ok {
+		return nil, reject(nas.Cause5GSMMissingOrUnknownDNN, "DNN %s not subscribed", c.DNN)
+	}
+
+	var typeCause *nas.Cause5GSM
+	if c.PDUSessionType, typeCause, err = s.sessionType(c, req, dnn); err != nil {
+		return nil, err
+	}
+	if c.SSCMode, err = sscMode(req, dnn.SscModes); err != nil {
+		return nil, err
+	}
+	if c.QoS, c.AMBR, err = subscribedQoS(dnn); err != nil {
+		return nil, err
+	}
+
+	address, err := s.allocateAddresses(ctx, c, dnn)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.createPolicy(ctx, c, dnn); err != nil {
+		return nil, err
+	}
+
+	if err := s.setUpUserPlane(ctx, c); err != nil {
+		return nil, err
+	}
+	s.startCharging(ctx, c)
+
+	qos := c.sessionQoS()
+	rules, err := qos.qosRules().Bytes()
+	if err != nil {
+		return nil, err
+	}
+	flows, err := qos.flowDescriptions().Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	snssai := c.SNSSAI
+	return &nas.PDUSessionEstablishmentAccept{
+		SMHeader:                      nas.SMHeader{PDUSessionID: c.PDUSessionID, ProcedureTransactionID: c.pti},
+		PDUSessionType:                c.PDUSessionType,
+		SSCMode:                       c.SSCMode,
+		AuthorizedQoSRules:            rules,
+		SessionAMBR:                   c.AMBR,
+		Cause:                         typeCause,
+		PDUAddress:                    address,
+		SNSSAI:                        &snssai,
+		AuthorizedQoSFlowDescriptions: flows,
+		DNN:                           c.DNN,
+	}, nil
+}
+
+// allocateAddresses leases the addresses of the PDU session type of a
+// PDU session and returns the PDU address given to the UE, nil for the
+// types without IP. An IPv6 UE gets its /64 prefix in router
+// advertisements and the interface identifier of its link-local address
+// in the PDU address (TS 23.501 5.8.2.2).
+func (s *SMF) allocateAddresses(ctx context.Context, c *SMContext, dnn models.DnnConfiguration) (*nas.PDUAddress, error) {
+	t := c.PDUSessionType
+	if t != nas.PDUSessionTypeIPv4 && t != nas.PDUSessionTypeIPv6 && t != nas.PDUSessionTypeIPv4v6 {
+		return nil, nil
+	}
+
+	key := sessionKey{c.SUPI, c.PDUSessionID}
+	address := &nas.PDUAddress{Type: t}
+	if t != nas.PDUSessionTypeIPv6 {
+		lease, err := s.ipam.allocate(ctx, key, ipv4, c.DNN, c.SNSSAI, staticIPv4(dnn))
+		if err != nil {
+			return nil, reject(nas.Cause5GSMInsufficientResources, "allocating UE address: %w", err)
+		}
+		c.ipv4Lease, c.UEAddress = lease, lease.IP()
+		address.IPv4 = c.UEAddress
+	}
+	if t != nas.PDUSessionTypeIPv4 {
+		lease, err := s.ipam.allocate(ctx, key, ipv6, c.DNN, c.SNSSAI, staticIPv6(dnn))
+		if err != nil {
+			return nil, reject(nas.Cause5GSMInsufficientResources, "allocating UE prefix: %w", err)
+		}
+		c.ipv6Lease, c.UEPrefix = lease, lease.Prefix()
+		address.IPv6InterfaceID = make([]byte, 8)
+		if _, err := rand.Read(address.IPv6InterfaceID); err != nil {
+			return nil, fmt.Errorf("generating interface identifier: %w", err)
+		}
+	}
+	return address, nil
+}
+
+// staticIPv4 returns the static IPv4 address of a subscription, nil when
+// it has none
+func staticIPv4(dnn models.DnnConfiguration) net.IP {
+	for _, a := range dnn.StaticIPAddress {
+		if ip := net.ParseIP(a.Ipv4Addr).To4(); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
+// staticIPv6 returns the static IPv6 prefix of a subscription, or the
+// prefix of its static IPv6 address, nil when it has none
+func staticIPv6(dnn models.DnnConfiguration) net.IP {
+	for _, a := range dnn.StaticIPAddress {
+		if _, prefix, err := net.ParseCIDR(a.Ipv6Prefix); err == nil && prefix.IP.To4() == nil {
+			return prefix.IP
+		}
+		if ip := net.ParseIP(a.Ipv6Addr); ip != nil && ip.To4() == nil {
+			return ip.Mask(net.CIDRMask(ueIPv6PrefixLength, 128))
+		}
+	}
+	return nil
+}
+
+// subscribedDNN returns the subscription of a UE to a DNN in a slice
+func subscribedDNN(subs []models.SessionManagementSubscriptionData, snssai models.Snssai, dnn string) (models.DnnConfiguration, bool) {
+	for _, sub := range subs {
+		if sub.SingleNssai != snssai {
+			continue
+		}
+		if cfg, ok := sub.DnnConfigurations[dnn]; ok {
+			return cfg, true
+		}
+	}
+	return models.DnnConfiguration{}, false
+}
+
+// sessionType returns the PDU session type granted to a request, with
+// the cause telling the UE why it differs from the requested one (TS
+// 23.501 5.8.2.2.1). The UE asking for none gets the default type of its
+// subscription. IP types also need a pool of their family serving the
+// DNN, or a static address of the subscription.
+func (s *SMF) sessionType(c *SMContext, req *nas.PDUSessionEstablishmentRequest, dnn models.DnnConfiguration) (nas.PDUSessionType, *nas.Cause5GSM, error) {
+	sub := dnn.PduSessionTypes
+	requested := nas.PDUSessionTypeIPv4
+	if req.PDUSessionType != nil {
+		requested = *req.PDUSessionType
+	} else if t, ok := nasSessionType(sub.DefaultSessionType); ok {
+		requested = t
+	}
+
+	v4 := allowsSessionType(sub, nas.PDUSessionTypeIPv4) &&
+		(staticIPv4(dnn) != nil || s.ipam.serves(ipv4, c.DNN, c.SNSSAI))
+	v6 := allowsSessionType(sub, nas.PDUSessionTypeIPv6) &&
+		(staticIPv6(dnn) != nil || s.ipam.serves(ipv6, c.DNN, c.SNSSAI))
+	switch requested {
+	case nas.PDUSessionTypeIPv4v6:
+		switch {
+		case v4 && v6:
+			return requested, nil, nil
+		case v4:
+			cause := nas.Cause5GSMPDUSessionTypeIPv4OnlyAllowed
+			return nas.PDUSessionTypeIPv4, &cause, nil
+		case v6:
+			cause := nas.Cause5GSMPDUSessionTypeIPv6OnlyAllowed
+			return nas.PDUSessionTypeIPv6, &cause, nil
+		}
+	case nas.PDUSessionTypeIPv4:
+		if v4 {
+			return requested, nil, nil
+		}
+	case nas.PDUSessionTypeIPv6:
+		if v6 {
+			return requested, nil, nil
+		}
+	case nas.PDUSessionTypeEthernet, nas.PDUSessionTypeUnstructured:
+		if allowsSessionType(sub, requested) {
+			return requested, nil, nil
+		}
+	default:
+		return 0, nil, reject(nas.Cause5GSMUnknownPDUSessionType, "PDU session type %s not supported", requested)
+	}
+
+	// The UE is told the type to ask for when only one is allowed
+	var allowed []nas.Cause5GSM
+	if v4 {
+		allowed = append(allowed, nas.Cause5GSMPDUSessionTypeIPv4OnlyAllowed)
+	}
+	if v6 {
+		allowed = append(allowed, nas.Cause5GSMPDUSessionTypeIPv6OnlyAllowed)
+	}
+	if allowsSessionType(sub, nas.PDUSessionTypeEthernet) {
+		allowed = append(allowed, nas.Cause5GSMPDUSessionTypeEthernetOnlyAllowed)
+	}
+	if allowsSessionType(sub, nas.PDUSessionTypeUnstructured) {
+		allowed = append(allowed, nas.Cause5GSMPDUSessionTypeUnstructuredOnlyAllowed)
+	}
+	cause := nas.Cause5GSMUnknownPDUSessionType
+	if len(allowed) == 1 {
+		cause = allowed[0]
+	}
+	return 0, nil, reject(cause, "PDU session type %s not allowed", requested)
+}
+
+// allowsSessionType reports whether a subscription allows a PDU session
+// type. IPv4v6 allows IPv4 and IPv6, and a subscription without session
+// types allows the IP ones.
+func allowsSessionType(sub models.PduSessionTypes, t nas.PDUSessionType) bool {
+	if sub.DefaultSessionType == "" {
+		return t == nas.PDUSessionTypeIPv4 || t == nas.PDUSessionTypeIPv6 || t == nas.PDUSessionTypeIPv4v6
+	}
+	for _, a := range append([]models.PduSessionType{sub.DefaultSessionType}, sub.AllowedSessionTypes...) {
+		if s, ok := nasSessionType(a); ok &&
+			(s == t || s == nas.PDUSessionTypeIPv4v6 && (t == nas.PDUSessionTypeIPv4 || t == nas.PDUSessionTypeIPv6)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionTypes maps the PDU session types of the SBI to the NAS ones
+var sessionTypes = map[models.PduSessionType]nas.PDUSessionType{
+	models.PduSessionTypeIPv4:         nas.PDUSessionTypeIPv4,
+	models.PduSessionTypeIPv6:         nas.PDUSessionTypeIPv6,
+	models.PduSessionTypeIPv4v6:       nas.PDUSessionTypeIPv4v6,
+	models.PduSessionTypeUnstructured: nas.PDUSessionTypeUnstructured,
+	models.PduSessionTypeEthernet:     nas.PDUSessionTypeEthernet,
+}
+
+// nasSessionType returns the NAS value of a PDU session type
+func nasSessionType(t models.PduSessionType) (nas.PDUSessionType, bool) {
+	n, ok := sessionTypes[t]
+	return n, ok
+}
+
+// modelSessionType returns the SBI value of a PDU session type
+func modelSessionType(t nas.PDUSessionType) models.PduSessionType {
+	for m, n := range sessionTypes {
+		if n == t {
+			return m
+		}
+	}
+	return models.PduSessionTypeIPv4
+}
+
+// sscModes maps the SSC modes of the SBI to the NAS ones
+var sscModes = map[models.SscMode]nas.SSCMode{
+	models.SscMode1: nas.SSCMode1,
+	models.SscMode2: nas.SSCMode2,
+	models.SscMode3: nas.SSCMode3,
+}
+
+// sscMode returns the SSC mode granted to a request: the requested one,
+// else the default one of the subscription, else SSC mode 1 (TS 23.501
+// 5.6.9.3). A mode other than the default one must be allowed by the
+// subscription.
+func sscMode(req *nas.PDUSessionEstablishmentRequest, sub models.SscModes) (nas.SSCMode, error) {
+	mode := models.SscMode1
+	if req.SSCMode != nil {
+		mode = models.SscMode(fmt.Sprintf("SSC_MODE_%d", *req.SSCMode))
+	} else if sub.DefaultSscMode != "" {
+		mode = sub.DefaultSscMode
+	}
+
+	n, ok := sscModes[mode]
+	if !ok {
+		return 0, reject(nas.Cause5GSMNotSupportedSSCMode, "%s not supported", mode)
+	}
+	if sub.DefaultSscMode != "" && sub.DefaultSscMode != mode {
+		allowed := false
+		for _, a := range sub.AllowedSscModes {
+			allowed = allowed || a == mode
+		}
+		if !allowed {
+			return 0, reject(nas.Cause5GSMNotSupportedSSCMode, "%s not subscribed", mode)
+		}
+	}
+	return n, nil
+}
+
+// subscribedQoS returns the default QoS and the session AMBR of a DNN
+// subscription, using the defaults of the SMF for what it sets none
+func subscribedQoS(sub models.DnnConfiguration) (DefaultQoS, nas.SessionAMBR, error) {
+	qos := DefaultQoS{
+		FiveQI: defaultFiveQI,
+		ARP:    ngap.AllocationAndRetentionPriority{PriorityLevel: defaultARPPriority},
+	}
+	if p := sub.FiveGQosProfile; p != nil {
+		qos = DefaultQoS{FiveQI: uint8(p.Var5qi), PriorityLevel: uint8(p.PriorityLevel), ARP: arp(p.Arp)}
+	}
+
+	ambr := models.Ambr{Uplink: defaultSessionAMBRUL, Downlink: defaultSessionAMBRDL}
+	if sub.SessionAmbr != nil {
+		ambr = *sub.SessionAmbr
+	}
+	sessionAMBR, err := parseAMBR(ambr)
+	return qos, sessionAMBR, err
+}
+
+// parseAMBR returns the bit rates of an AMBR
+func parseAMBR(ambr models.Ambr) (nas.SessionAMBR, error) {
+	ul, err := models.ParseBitRate(ambr.Uplink)
+	if err != nil {
+		return nas.SessionAMBR{}, fmt.Errorf("invalid uplink session AMBR: %w", err)
+	}
+	dl, err := models.ParseBitRate(ambr.Downlink)
+	if err != nil {
+		return nas.SessionAMBR{}, fmt.Errorf("invalid downlink session AMBR: %w", err)
+	}
+	return nas.SessionAMBR{Uplink: ul, Downlink: dl}, nil
+}
+
+// arp returns the NGAP allocation and retention priority of an ARP
+func arp(a models.Arp) ngap.AllocationAndRetentionPriority {
+	return ngap.AllocationAndRetentionPriority{
+		PriorityLevel:        uint8(a.PriorityLevel),
+		MayTriggerPreemption: a.PreemptCap == models.MayPreempt,
+		Preemptable:          a.PreemptVuln == models.Preemptable,
+	}
+}
+
+// createPolicy creates the SM policy association of a PDU session and
+// applies its decision
+func (s *SMF) createPolicy(ctx context.Context, c *SMContext, sub models.DnnConfiguration) error {
+	data := models.SmPolicyContextData{
+		Supi:            c.SUPI,
+		PduSessionID:    c.PDUSessionID,
+		PduSessionType:  modelSessionType(c.PDUSessionType),
+		Dnn:             c.DNN,
+		SliceInfo:       c.SNSSAI,
+		NotificationURI: s.apiRoot() + smPolicyCallbackPrefix + "/" + c.Ref,
+		AccessType:      c.AnType,
+		RatType:         c.RatType,
+		ServingNetwork:  &c.ServingNetwork,
+		SubsSessAmbr:    sub.SessionAmbr,
+		SubsDefQos:      sub.FiveGQosProfile,
+	}
+	if c.UEAddress != nil {
+		data.Ipv4Address = c.UEAddress.String()
+	}
+	if c.UEPrefix != nil {
+		data.Ipv6AddressPrefix = c.UEPrefix.String()
+	}
+	uri, decision, err := s.nfs.PCF.CreateSMPolicy(ctx, data)
+	if err != nil {
+		return fmt.Errorf("creating SM policy association: %w", err)
+	}
+	c.PolicyURI = uri
+
+	if decision != nil {
+		mergePolicy(&c.policy, decision)
+	}
+	qos, err := c.decideQoS(&c.policy)
+	if err != nil {
+		return fmt.Errorf("applying SM policy: %w", err)
+	}
+	c.setQoS(qos)
+	return nil
+}
+
+// sendAccept sends the PDU Session Establishment Accept to the UE with
+// the N2 resources to set up in its gNB
+func (s *SMF) sendAccept(ctx context.Context, c *SMContext, accept *nas.PDUSessionEstablishmentAccept) error {
+	n1, err := nas.Encode(accept)
+	if err != nil {
+		return err
+	}
+	n2, err := c.setupTransfer()
+	if err != nil {
+		return err
+	}
+
+	snssai := c.SNSSAI
+	_, err = s.nfs.AMF.N1N2MessageTransfer(ctx, c.SUPI, models.N1N2MessageTransferReqData{
+		N1MessageContainer: &models.N1MessageContainer{N1MessageClass: models.N1MessageClassSM},
+		N2InfoContainer: &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClassSM,
+			SmInfo: &models.N2SmInformation{
+				PduSessionID:  c.PDUSessionID,
+				N2InfoContent: &models.N2InfoContent{NgapIeType: models.N2SmInfoPduResSetupReq},
+				SNssai:        &snssai,
+			},
+		},
+		PduSessionID:            c.PDUSessionID,
+		BinaryDataN1Message:     n1,
+		BinaryDataN2Information: n2,
+	})
+	if err != nil {
+		return fmt.Errorf("sending PDU Session Establishment Accept: %w", err)
+	}
+	return nil
+}
+
+// sendReject sends a PDU Session Establishment Reject to the UE
+func (s *SMF) sendReject(ctx context.Context, c *SMContext, cause nas.Cause5GSM) {
+	n1, err := establishmentReject(c.PDUSessionID, c.pti, cause)
+	if err == nil {
+		_, err = s.nfs.AMF.N1N2MessageTransfer(ctx, c.SUPI, models.N1N2MessageTransferReqData{
+			N1MessageContainer:  &models.N1MessageContainer{N1MessageClass: models.N1MessageClassSM},
+			PduSessionID:        c.PDUSessionID,
+			BinaryDataN1Message: n1,
+		})
+	}
+	if err != nil {
+		c.log.Warn("Failed to send PDU Session Establishment Reject", zap.Error(err))
+	}
+}
+
+// establishmentReject returns an encoded PDU Session Establishment
+// Reject
+func establishmentReject(id, pti uint8, cause nas.Cause5GSM) ([]byte, error) {
+	return nas.Encode(&nas.PDUSessionEstablishmentReject{
+		SMHeader: nas.SMHeader{PDUSessionID: id, ProcedureTransactionID: pti},
+		Cause:    cause,
+	})
+}
+
+// sendN1 sends a 5GSM message of a PDU session to the UE
+func (s *SMF) sendN1(ctx context.Context, c *SMContext, msg nas.Message) error {
+	n1, err := nas.Encode(msg)
+	if err != nil {
+		return err
+	}
+	_, err = s.nfs.AMF.N1N2MessageTransfer(ctx, c.SUPI, models.N1N2MessageTransferReqData{
+		N1MessageContainer:  &models.N1MessageContainer{N1MessageClass: models.N1MessageClassSM},
+		PduSessionID:        c.PDUSessionID,
+		BinaryDataN1Message: n1,
+	})
+	return err
+}
+
+// notifyReleased tells the AMF that the SMF released an SM context
+func (s *SMF) notifyReleased(ctx context.Context, c *SMContext, cause string) {
+	if c.StatusURI == "" {
+		return
+	}
+	err := s.nfs.AMF.NotifySMContextStatus(ctx, c.StatusURI, models.SmContextStatusNotification{
+		StatusInfo: models.StatusInfo{ResourceStatus: models.ResourceStatusReleased, Cause: cause},
+	})
+	if err != nil {
+		c.log.Warn("Failed to notify SM context release", zap.Error(err))
+	}
+}
+
+// releaseByNetwork releases a PDU session at the initiative of the SMF
+// (TS 23.502 4.3.4.2): the UE gets a PDU Session Release Command with
+// the 5GSM cause and the AMF is notified with the status cause. It runs
+// holding c.mu.
+func (s *SMF) releaseByNetwork(ctx context.Context, c *SMContext, cause nas.Cause5GSM, status string) {
+	err := s.sendN1(ctx, c, &nas.PDUSessionReleaseCommand{
+		SMHeader: nas.SMHeader{PDUSessionID: c.PDUSessionID},
+		Cause:    cause,
+	})
+	if err != nil {
+		c.log.Warn("Failed to send PDU Session Release Command", zap.Error(err))
+	}
+
+	s.teardown(ctx, c)
+	c.log.Info("PDU session released by the SMF", zap.Stringer("cause", cause))
+	s.notifyReleased(ctx, c, status)
+}
+
+// release releases an SM context and the resources of its PDU session
+func (s *SMF) release(ctx context.Context, c *SMContext) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.released {
+		s.teardown(ctx, c)
+		c.log.Info("PDU session released")
+	}
+}
+
+// teardown frees the resources of a PDU session in the UPF, the PCF, the
+// AMF and the address pools, closes its charging data with the usage
+// reported by the UPF and drops its SM context. It runs holding c.mu.
+func (s *SMF) teardown(ctx context.Context, c *SMContext) {
+	if c.relocation != nil {
+		c.relocation.Stop()
+		c.relocation = nil
+	}
+	if c.N4 != nil {
+		s.deleteN4Session(ctx, c, c.UPF, c.N4)
+		c.N4 = nil
+	}
+	if c.PSAN4 != nil {
+		s.deleteN4Session(ctx, c, c.PSA, c.PSAN4)
+		c.PSA, c.PSAN4 = nil, nil
+	}
+	s.stopCharging(ctx, c)
+	if c.PolicyURI != "" {
+		if err := s.nfs.PCF.DeleteSMPolicy(ctx, c.PolicyURI); err != nil {
+			c.log.Warn("Failed to delete SM policy association", zap.Error(err))
+		}
+		c.PolicyURI = ""
+	}
+	s.unsubscribeMobility(ctx, c)
+	for _, l := range []**Lease{&c.ipv4Lease, &c.ipv6Lease} {
+		if *l != nil {
+			if err := s.ipam.release(ctx, *l); err != nil {
+				c.log.Warn("Failed to release UE address", zap.Error(err))
+			}
+			*l = nil
+		}
+	}
+	if c.established && s.metrics != nil {
+		s.metrics.ActiveSessions.WithLabelValues(c.DNN, metrics.SNSSAILabel(uint8(c.SNSSAI.Sst), c.SNSSAI.Sd)).Dec()
+	}
+
+	c.established = false
+	c.released = true
+	c.UpCnxState = models.UpCnxStateDeactivated
+	s.forget(c)
+}
+
+// recordEstablishment counts a PDU session establishment, successful
+// when cause is 0
+func (s *SMF) recordEstablishment(dnn string, snssai models.Snssai, cause nas.Cause5GSM) {
+	if s.metrics == nil {
+		return
+	}
+	result, label := metrics.ResultSuccess, ""
+	if cause != 0 {
+		result, label = metrics.ResultFailure, cause.String()
+	}
+	s.metrics.SessionEstablishments.WithLabelValues(dnn, metrics.SNSSAILabel(uint8(snssai.Sst), snssai.Sd), result, label).Inc()
+}
@@ -0,0 +1,626 @@
+package smf
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"go.uber.org/zap"
+)
+
+// errPoolExhausted is returned when every address of the pools serving a
+// PDU session is in use or held back
+var errPoolExhausted = errors.New("address pools exhausted")
+
+// ueIPv6PrefixLength is the length of the IPv6 prefixes given to UEs (TS
+// 23.501 5.8.2.2.3)
+const ueIPv6PrefixLength = 64
+
+// ipFamily is the address family of a range
+type ipFamily int
+
+const (
+	ipv4 ipFamily = iota
+	ipv6
+)
+
+// String returns the name of the family
+func (f ipFamily) String() string {
+	if f == ipv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// Lease is an address or prefix given to a PDU session
+type Lease struct {
+	// Pool is the name of the pool of the address, empty for a static
+	// address outside every pool
+	Pool string `json:"pool"`
+
+	// Address is an IPv4 address or an IPv6 /64 prefix
+	Address string `json:"address"`
+
+	SUPI         string `json:"supi"`
+	PDUSessionID uint8  `json:"pduSessionId"`
+
+	// Static tells that the address comes from the subscription
+	Static bool `json:"static,omitempty"`
+
+	// ReleasedAt is when the PDU session released the address, zero while
+	// it is in use
+	ReleasedAt time.Time `json:"releasedAt,omitempty"`
+
+	r      *addressRange
+	offset uint64
+}
+
+// key identifies the lease in a store
+func (l *Lease) key() string {
+	return l.Pool + "|" + l.Address
+}
+
+// IP returns the leased IPv4 address, or the first address of the leased
+// IPv6 prefix
+func (l *Lease) IP() net.IP {
+	if ip, _, err := net.ParseCIDR(l.Address); err == nil {
+		return ip
+	}
+	return net.ParseIP(l.Address)
+}
+
+// Prefix returns the leased IPv6 prefix, nil for an IPv4 address
+func (l *Lease) Prefix() *net.IPNet {
+	if _, prefix, err := net.ParseCIDR(l.Address); err == nil && prefix.IP.To4() == nil {
+		return prefix
+	}
+	return nil
+}
+
+// slotState is the state of an address of a range
+type slotState uint8
+
+const (
+	slotInUse slotState = iota + 1
+	slotQuarantined
+)
+
+// addressRange allocates the addresses of one family of a pool in O(1).
+// Never used addresses are handed out in order first, then the released
+// ones in the order their quarantine ended, so an address is reused as
+// late as possible. Only addresses in use or held back take memory,
+// which keeps large IPv6 prefixes cheap.
+type addressRange struct {
+	name   string
+	family ipFamily
+	base   net.IP // first IPv4 address, or IPv6 prefix
+	size   uint64
+
+	next       uint64 // offset of the first never used address
+	slots      map[uint64]slotState
+	held       map[uint64]*Lease // lease of each quarantined address
+	free       []uint64          // released past their quarantine, may be stale
+	quarantine []quarantined
+	inUse      int
+}
+
+// quarantined is an address held back until a time
+type quarantined struct {
+	offset uint64
+	until  time.Time
+	lease  *Lease
+}
+
+// newIPv4Range creates the range of the addresses from start to end
+// included
+func newIPv4Range(name string, start, end net.IP) *addressRange {
+	return &addressRange{
+		name:   name,
+		family: ipv4,
+		base:   start.To4(),
+		size:   uint64(ipv4Uint(end)-ipv4Uint(start)) + 1,
+		slots:  make(map[uint64]slotState),
+		held:   make(map[uint64]*Lease),
+	}
+}
+
+// newIPv6Range creates the range of the /64 prefixes of a prefix
+func newIPv6Range(name string, prefix *net.IPNet) *addressRange {
+	ones, _ := prefix.Mask.Size()
+	return &addressRange{
+		name:   name,
+		family: ipv6,
+		base:   prefix.IP.To16(),
+		size:   1 << (ueIPv6PrefixLength - ones),
+		slots:  make(map[uint64]slotState),
+		held:   make(map[uint64]*Lease),
+	}
+}
+
+// address returns the address, or the /64 prefix, at an offset
+func (r *addressRange) address(offset uint64) string {
+	if r.family == ipv4 {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, ipv4Uint(r.base)+uint32(offset))
+		return ip.String()
+	}
+	ip := make(net.IP, net.IPv6len)
+	binary.BigEndian.PutUint64(ip, binary.BigEndian.Uint64(r.base)+offset)
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(ueIPv6PrefixLength, 128)}).String()
+}
+
+// offset returns the offset of an address, or of the /64 prefix holding
+// it, in the range
+func (r *addressRange) offset(ip net.IP) (uint64, bool) {
+	if r.family == ipv4 {
+		v4 := ip.To4()
+		if v4 == nil || ipv4Uint(v4) < ipv4Uint(r.base) {
+			return 0, false
+		}
+		off := uint64(ipv4Uint(v4) - ipv4Uint(r.base))
+		return off, off < r.size
+	}
+	if ip.To4() != nil || len(ip) != net.IPv6len {
+		return 0, false
+	}
+	hi, base := binary.BigEndian.Uint64(ip), binary.BigEndian.Uint64(r.base)
+	if hi < base || hi-base >= r.size {
+		return 0, false
+	}
+	return hi - base, true
+}
+
+// expire ends the quarantines due by now
+func (r *addressRange) expire(now time.Time) {
+	for len(r.quarantine) > 0 && !now.Before(r.quarantine[0].until) {
+		q := r.quarantine[0]
+		r.quarantine = r.quarantine[1:]
+		if r.held[q.offset] != q.lease {
+			// Taken again as a static address meanwhile
+			continue
+		}
+		r.setFree(q.offset)
+	}
+}
+
+// take allocates a free address
+func (r *addressRange) take() (uint64, bool) {
+	for r.next < r.size {
+		off := r.next
+		r.next++
+		if r.slots[off] == 0 {
+			r.setInUse(off)
+			return off, true
+		}
+	}
+	for len(r.free) > 0 {
+		off := r.free[0]
+		r.free = r.free[1:]
+		if r.slots[off] == 0 {
+			r.setInUse(off)
+			return off, true
+		}
+	}
+	return 0, false
+}
+
+// reserve allocates a given address, failing when it is in use
+func (r *addressRange) reserve(offset uint64) bool {
+	if r.slots[offset] == slotInUse {
+		return false
+	}
+	r.setInUse(offset)
+	return true
+}
+
+// unreserve frees an address whose allocation was rolled back
+func (r *addressRange) unreserve(offset uint64) {
+	if r.slots[offset] != slotInUse {
+		return
+	}
+	r.inUse--
+	r.setFree(offset)
+}
+
+// release frees an address in use, holding it back until a time
+func (r *addressRange) release(offset uint64, until time.Time, now time.Time, lease *Lease) {
+	if r.slots[offset] != slotInUse {
+		return
+	}
+	r.inUse--
+	if !now.Before(until) {
+		r.setFree(offset)
+		return
+	}
+	r.hold(offset, until, lease)
+}
+
+// hold puts an address in quarantine. Quarantines end in the order they
+// were started.
+func (r *addressRange) hold(offset uint64, until time.Time, lease *Lease) {
+	r.slots[offset] = slotQuarantined
+	r.held[offset] = lease
+	r.quarantine = append(r.quarantine, quarantined{offset: offset, until: until, lease: lease})
+}
+
+func (r *addressRange) setInUse(offset uint64) {
+	r.slots[offset] = slotInUse
+	delete(r.held, offset)
+	r.inUse++
+}
+
+func (r *addressRange) setFree(offset uint64) {
+	delete(r.slots, offset)
+	delete(r.held, offset)
+	if offset < r.next {
+		r.free = append(r.free, offset)
+	}
+}
+
+// addressPool is a configured pool and its ranges by family
+type addressPool struct {
+	PoolConfig
+	ranges [2]*addressRange
+}
+
+// serves reports whether the pool serves a DNN and a slice
+func (p *addressPool) serves(dnn string, snssai models.Snssai) bool {
+	return (p.DNN == "" || p.DNN == dnn) && (p.SNSSAI == nil || *p.SNSSAI == snssai)
+}
+
+// specificity ranks the pools of a DNN and a slice above the pools of
+// either, above the pools of every DNN and slice
+func (p *addressPool) specificity() int {
+	n := 0
+	if p.DNN != "" {
+		n += 2
+	}
+	if p.SNSSAI != nil {
+		n++
+	}
+	return n
+}
+
+// ipam allocates the UE addresses of the PDU sessions from the pools of
+// the SMF. Released addresses are held back for the quarantine so that
+// packets still in flight to the last holder do not reach the next one.
+// Leases are saved to the store when there is one, so a restarted SMF
+// does not hand out the addresses of PDU sessions it lost. The store is
+// written outside the lock: an address is reserved first, then its lease
+// saved, and the reservation is rolled back when the store fails.
+type ipam struct {
+	pools      []*addressPool // most specific first
+	quarantine time.Duration
+	store      AddressStore
+	metrics    *metrics.SMFMetrics
+	log        *zap.Logger
+
+	mu sync.Mutex
+
+	// restored holds the leases of a previous run by PDU session, given
+	// up when the PDU session is established again
+	restored map[sessionKey][]*Lease
+}
+
+// newIPAM creates the allocator of the configured pools
+func newIPAM(cfg *Config, store AddressStore, m *metrics.SMFMetrics, log *zap.Logger) *ipam {
+	a := &ipam{
+		quarantine: cfg.AddressQuarantine,
+		store:      store,
+		metrics:    m,
+		log:        log,
+		restored:   make(map[sessionKey][]*Lease),
+	}
+	for _, c := range cfg.Pools {
+		p := &addressPool{PoolConfig: c}
+		if c.IPv4Start != nil {
+			p.ranges[ipv4] = newIPv4Range(c.Name+"-"+ipv4.String(), c.IPv4Start, c.IPv4End)
+		}
+		if c.IPv6Prefix != nil {
+			p.ranges[ipv6] = newIPv6Range(c.Name+"-"+ipv6.String(), c.IPv6Prefix)
+		}
+		a.pools = append(a.pools, p)
+	}
+	sort.SliceStable(a.pools, func(i, j int) bool {
+		return a.pools[i].specificity() > a.pools[j].specificity()
+	})
+
+	if m != nil {
+		for _, p := range a.pools {
+			for _, r := range p.ranges {
+				if r != nil {
+					m.PoolCapacity.WithLabelValues(p.DNN, r.name).Set(float64(r.size))
+					m.PoolAllocated.WithLabelValues(p.DNN, r.name).Set(0)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// serves reports whether a pool serving a DNN and a slice has addresses
+// of a family
+func (a *ipam) serves(family ipFamily, dnn string, snssai models.Snssai) bool {
+	for _, p := range a.pools {
+		if p.ranges[family] != nil && p.serves(dnn, snssai) {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate leases an address of a family to a PDU session: its static
+// address when the subscription gives one, else an address of the most
+// specific pool serving its DNN and slice that has one free. Leases
+// restored for the same PDU session are given up first.
+func (a *ipam) allocate(ctx context.Context, key sessionKey, family ipFamily, dnn string, snssai models.Snssai, static net.IP) (*Lease, error) {
+	a.releaseRestored(ctx, key, family)
+
+	l, err := a.reserve(key, family, dnn, snssai, static)
+	if err != nil || l.r == nil {
+		return l, err
+	}
+	if err := a.save(ctx, l); err != nil {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		l.r.unreserve(l.offset)
+		a.record(a.pool(l.Pool), l.r)
+		return nil, fmt.Errorf("saving lease of %s: %w", l.Address, err)
+	}
+	return l, nil
+}
+
+// reserve takes the address of a new lease, not saved yet. Ended
+// quarantines are left in the store until the address is leased again,
+// whose lease replaces them.
+func (a *ipam) reserve(key sessionKey, family ipFamily, dnn string, snssai models.Snssai, static net.IP) (*Lease, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if static != nil {
+		return a.reserveStatic(key, family, static)
+	}
+
+	now := time.Now()
+	served := false
+	for _, p := range a.pools {
+		r := p.ranges[family]
+		if r == nil || !p.serves(dnn, snssai) {
+			continue
+		}
+		served = true
+		r.expire(now)
+		off, ok := r.take()
+		if !ok {
+			continue
+		}
+		a.record(p, r)
+		return &Lease{Pool: p.Name, Address: r.address(off), SUPI: key.supi, PDUSessionID: key.id, r: r, offset: off}, nil
+	}
+	if !served {
+		return nil, fmt.Errorf("no %s address pool for DNN %s", family, dnn)
+	}
+	return nil, errPoolExhausted
+}
+
+// reserveStatic takes the static address of a PDU session. Addresses
+// outside every pool are leased as is.
+func (a *ipam) reserveStatic(key sessionKey, family ipFamily, ip net.IP) (*Lease, error) {
+	for _, p := range a.pools {
+		r := p.ranges[family]
+		if r == nil {
+			continue
+		}
+		off, ok := r.offset(ip)
+		if !ok {
+			continue
+		}
+		if !r.reserve(off) {
+			return nil, fmt.Errorf("static address %s in use", ip)
+		}
+		a.record(p, r)
+		return &Lease{Pool: p.Name, Address: r.address(off), SUPI: key.supi, PDUSessionID: key.id, Static: true, r: r, offset: off}, nil
+	}
+
+	address := ip.String()
+	if family == ipv6 {
+		address = (&net.IPNet{IP: ip, Mask: net.CIDRMask(ueIPv6PrefixLength, 128)}).String()
+	}
+	return &Lease{Address: address, SUPI: key.supi, PDUSessionID: key.id, Static: true}, nil
+}
+
+// release ends a lease. The address is held back for the quarantine once
+// the store has the release; it stays in use, as the store still has it,
+// when the store fails.
+func (a *ipam) release(ctx context.Context, l *Lease) error {
+	if l == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	if !l.ReleasedAt.IsZero() || l.r == nil {
+		a.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	l.ReleasedAt = now
+	released := *l
+	a.mu.Unlock()
+
+	// The address stays in use until the store has the release, so no
+	// new lease of it is written meanwhile
+	var err error
+	if a.quarantine > 0 {
+		err = a.save(ctx, &released)
+	} else {
+		err = a.delete(ctx, &released)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err != nil {
+		l.ReleasedAt = time.Time{}
+		return fmt.Errorf("saving release of %s: %w", l.Address, err)
+	}
+	l.r.release(l.offset, now.Add(a.quarantine), now, l)
+	a.record(a.pool(l.Pool), l.r)
+	return nil
+}
+
+// releaseRestored ends the restored leases of a family of a PDU session.
+// Leases whose release fails stay restored.
+func (a *ipam) releaseRestored(ctx context.Context, key sessionKey, family ipFamily) {
+	a.mu.Lock()
+	var leases []*Lease
+	for _, l := range a.restored[key] {
+		if l.r.family == family {
+			leases = append(leases, l)
+		}
+	}
+	a.mu.Unlock()
+	if len(leases) == 0 {
+		return
+	}
+
+	for _, l := range leases {
+		if err := a.release(ctx, l); err != nil {
+			a.log.Error("Failed to release restored UE address lease", zap.String("address", l.Address), zap.Error(err))
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := a.restored[key][:0]
+	for _, l := range a.restored[key] {
+		if l.ReleasedAt.IsZero() {
+			kept = append(kept, l)
+		}
+	}
+	if len(kept) == 0 {
+		delete(a.restored, key)
+	} else {
+		a.restored[key] = kept
+	}
+}
+
+// load restores the leases saved in the store: addresses in use stay so
+// until their PDU session is established again, released ones finish
+// their quarantine
+func (a *ipam) load(ctx context.Context) error {
+	if a.store == nil {
+		return nil
+	}
+	leases, err := a.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading UE address leases: %w", err)
+	}
+
+	a.mu.Lock()
+
+	// Quarantines are restored in the order they end
+	sort.SliceStable(leases, func(i, j int) bool { return leases[i].ReleasedAt.Before(leases[j].ReleasedAt) })
+
+	now := time.Now()
+	inUse, held := 0, 0
+	var stale []*Lease
+	for i := range leases {
+		l := &leases[i]
+		p := a.pool(l.Pool)
+		ip := l.IP()
+		var r *addressRange
+		if p != nil && ip != nil {
+			r = p.ranges[ipv4]
+			if ip.To4() == nil {
+				r = p.ranges[ipv6]
+			}
+		}
+		off, ok := uint64(0), false
+		if r != nil {
+			off, ok = r.offset(ip)
+		}
+		if !ok {
+			a.log.Warn("Dropping lease of an address outside the pools", zap.String("pool", l.Pool),
+				zap.String("address", l.Address))
+			stale = append(stale, l)
+			continue
+		}
+		l.r, l.offset = r, off
+
+		switch until := l.ReleasedAt.Add(a.quarantine); {
+		case l.ReleasedAt.IsZero():
+			if !r.reserve(off) {
+				continue
+			}
+			key := sessionKey{l.SUPI, l.PDUSessionID}
+			a.restored[key] = append(a.restored[key], l)
+			inUse++
+		case now.Before(until) && r.slots[off] == 0:
+			r.hold(off, until, l)
+			held++
+		default:
+			stale = append(stale, l)
+		}
+	}
+	for _, p := range a.pools {
+		for _, r := range p.ranges {
+			if r != nil {
+				a.record(p, r)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	for _, l := range stale {
+		if err := a.delete(ctx, l); err != nil {
+			a.log.Error("Failed to delete UE address lease", zap.String("address", l.Address), zap.Error(err))
+		}
+	}
+
+	a.log.Info("Restored UE address leases", zap.Int("in_use", inUse), zap.Int("held_back", held))
+	return nil
+}
+
+// pool returns the pool with the given name
+func (a *ipam) pool(name string) *addressPool {
+	for _, p := range a.pools {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// save writes a lease to the store, if any
+func (a *ipam) save(ctx context.Context, l *Lease) error {
+	if a.store == nil {
+		return nil
+	}
+	return a.store.Save(ctx, *l)
+}
+
+// delete removes a lease from the store, if any
+func (a *ipam) delete(ctx context.Context, l *Lease) error {
+	if a.store == nil {
+		return nil
+	}
+	return a.store.Delete(ctx, *l)
+}
+
+// record updates the allocation metric of a range
+func (a *ipam) record(p *addressPool, r *addressRange) {
+	if a.metrics != nil && p != nil {
+		a.metrics.PoolAllocated.WithLabelValues(p.DNN, r.name).Set(float64(r.inUse))
+	}
+}
+
+// ipv4Uint returns an IPv4 address as an integer
+func ipv4Uint(ip net.IP) uint32 {
+	return binary.BigEndian.Uint32(ip.To4())
+}
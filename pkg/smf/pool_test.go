@@ -0,0 +1,491 @@
+package smf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+var (
+	testSNSSAI = models.Snssai{Sst: 1, Sd: "010203"}
+	testKey    = sessionKey{supi: testSUPI, id: 1}
+)
+
+// memoryStore is an AddressStore keeping the leases as JSON by pool and
+// address, as the Redis store does
+type memoryStore struct {
+	mu     sync.Mutex
+	leases map[string][]byte
+	err    error // returned by Save and Delete when set
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{leases: make(map[string][]byte)}
+}
+
+// Load implements AddressStore
+func (s *memoryStore) Load(ctx context.Context) ([]Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var leases []Lease
+	for _, b := range s.leases {
+		var l Lease
+		if err := json.Unmarshal(b, &l); err != nil {
+			return nil, err
+		}
+		leases = append(leases, l)
+	}
+	return leases, nil
+}
+
+// Save implements AddressStore
+func (s *memoryStore) Save(ctx context.Context, l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	s.leases[l.key()] = b
+	return nil
+}
+
+// Delete implements AddressStore
+func (s *memoryStore) Delete(ctx context.Context, l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	delete(s.leases, l.key())
+	return nil
+}
+
+// lease returns the saved lease of an address
+func (s *memoryStore) lease(t *testing.T, pool, address string) (Lease, bool) {
+	t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.leases[pool+"|"+address]
+	if !ok {
+		return Lease{}, false
+	}
+	var l Lease
+	if err := json.Unmarshal(b, &l); err != nil {
+		t.Fatal(err)
+	}
+	return l, true
+}
+
+// testPool returns the configuration of a pool
+func testPool(t *testing.T, name, dnn string, snssai *models.Snssai, start, end, prefix string, prefixLength int) PoolConfig {
+	t.Helper()
+
+	p, err := newPoolConfig(name, start, end, prefix, prefixLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.DNN, p.SNSSAI = dnn, snssai
+	return p
+}
+
+// newTestIPAM returns an allocator of pools, saving its leases to store
+// unless nil
+func newTestIPAM(t *testing.T, quarantine time.Duration, store AddressStore, pools ...PoolConfig) *ipam {
+	t.Helper()
+
+	cfg := &Config{Pools: pools, AddressQuarantine: quarantine}
+	m := metrics.NewSMFMetrics(metrics.New("smf"), "test")
+	a := newIPAM(cfg, store, m, zap.NewNop())
+	if store != nil {
+		if err := a.load(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return a
+}
+
+// mustAllocate leases an IPv4 address of the internet DNN to a PDU session
+func mustAllocate(t *testing.T, a *ipam, key sessionKey) *Lease {
+	t.Helper()
+
+	l, err := a.allocate(context.Background(), key, ipv4, "internet", testSNSSAI, nil)
+	if err != nil {
+		t.Fatalf("allocate() error: %v", err)
+	}
+	return l
+}
+
+// session returns the key of a PDU session of a test UE
+func session(id uint8) sessionKey {
+	return sessionKey{supi: testKey.supi, id: id}
+}
+
+func TestAllocate(t *testing.T) {
+	pools := []PoolConfig{
+		testPool(t, "default", "", nil, "10.60.0.1", "10.60.0.2", "", 0),
+		testPool(t, "internet-ipv6", "internet", nil, "", "", "2001:db8::", 48),
+		testPool(t, "ims", "ims", nil, "10.61.0.1", "10.61.0.2", "", 0),
+		testPool(t, "ims-slice", "ims", &testSNSSAI, "10.62.0.1", "10.62.0.1", "", 0),
+	}
+	other := models.Snssai{Sst: 2}
+
+	tests := []struct {
+		name     string
+		family   ipFamily
+		dnn      string
+		snssai   models.Snssai
+		static   net.IP
+		want     Lease
+		wantErr  bool
+		previous []string // addresses leased before
+	}{
+		{
+			name:   "default pool",
+			family: ipv4, dnn: "internet", snssai: testSNSSAI,
+			want: Lease{Pool: "default", Address: "10.60.0.1"},
+		},
+		{
+			name:   "pool of the DNN and slice first",
+			family: ipv4, dnn: "ims", snssai: testSNSSAI,
+			want: Lease{Pool: "ims-slice", Address: "10.62.0.1"},
+		},
+		{
+			name:   "pool of the DNN",
+			family: ipv4, dnn: "ims", snssai: other,
+			want: Lease{Pool: "ims", Address: "10.61.0.1"},
+		},
+		{
+			name:   "less specific pool when exhausted",
+			family: ipv4, dnn: "ims", snssai: testSNSSAI,
+			previous: []string{"10.62.0.1"},
+			want:     Lease{Pool: "ims", Address: "10.61.0.1"},
+		},
+		{
+			name:   "IPv6 prefix",
+			family: ipv6, dnn: "internet", snssai: testSNSSAI,
+			want: Lease{Pool: "internet-ipv6", Address: "2001:db8::/64"},
+		},
+		{
+			name:   "IPv6 prefixes in order",
+			family: ipv6, dnn: "internet", snssai: testSNSSAI,
+			previous: []string{"2001:db8::"},
+			want:     Lease{Pool: "internet-ipv6", Address: "2001:db8:0:1::/64"},
+		},
+		{
+			name:   "no IPv6 pool",
+			family: ipv6, dnn: "ims", snssai: other,
+			wantErr: true,
+		},
+		{
+			name:   "static address of a pool",
+			family: ipv4, dnn: "internet", snssai: testSNSSAI,
+			static: net.ParseIP("10.60.0.2"),
+			want:   Lease{Pool: "default", Address: "10.60.0.2", Static: true},
+		},
+		{
+			name:   "static address in use",
+			family: ipv4, dnn: "internet", snssai: testSNSSAI,
+			static:   net.ParseIP("10.60.0.1"),
+			previous: []string{"10.60.0.1"},
+			wantErr:  true,
+		},
+		{
+			name:   "static address outside the pools",
+			family: ipv4, dnn: "internet", snssai: testSNSSAI,
+			static: net.ParseIP("192.0.2.1"),
+			want:   Lease{Address: "192.0.2.1", Static: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestIPAM(t, 0, nil, pools...)
+			for i, addr := range tt.previous {
+				ip := net.ParseIP(addr)
+				family := ipv4
+				if ip.To4() == nil {
+					family = ipv6
+				}
+				if _, err := a.allocate(context.Background(), session(uint8(i+2)), family, tt.dnn, tt.snssai, ip); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			l, err := a.allocate(context.Background(), testKey, tt.family, tt.dnn, tt.snssai, tt.static)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("allocate() = %s, want an error", l.Address)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("allocate() error: %v", err)
+			}
+
+			want := tt.want
+			want.SUPI, want.PDUSessionID = testKey.supi, testKey.id
+			l.r, l.offset = nil, 0
+			if *l != want {
+				t.Errorf("allocate() = %+v, want %+v", *l, want)
+			}
+		})
+	}
+}
+
+func TestAllocateMetrics(t *testing.T) {
+	a := newTestIPAM(t, 0, nil, testPool(t, "default", "internet", nil, "10.60.0.1", "10.60.0.4", "", 0))
+	allocated := a.metrics.PoolAllocated.WithLabelValues("internet", "default-ipv4")
+
+	if got := testutil.ToFloat64(a.metrics.PoolCapacity.WithLabelValues("internet", "default-ipv4")); got != 4 {
+		t.Errorf("capacity = %v, want 4", got)
+	}
+	l := mustAllocate(t, a, session(1))
+	mustAllocate(t, a, session(2))
+	if got := testutil.ToFloat64(allocated); got != 2 {
+		t.Errorf("allocated = %v, want 2", got)
+	}
+	if err := a.release(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(allocated); got != 1 {
+		t.Errorf("allocated after release = %v, want 1", got)
+	}
+}
+
+func TestExhaustion(t *testing.T) {
+	a := newTestIPAM(t, 0, nil, testPool(t, "default", "", nil, "10.60.0.1", "10.60.0.2", "", 0))
+
+	l := mustAllocate(t, a, session(1))
+	mustAllocate(t, a, session(2))
+	if _, err := a.allocate(context.Background(), session(3), ipv4, "internet", testSNSSAI, nil); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("allocate() error = %v, want %v", err, errPoolExhausted)
+	}
+
+	if err := a.release(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if got := mustAllocate(t, a, session(3)); got.Address != l.Address {
+		t.Errorf("allocate() = %s, want released %s", got.Address, l.Address)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	pool := testPool(t, "default", "", nil, "10.60.0.1", "10.60.0.3", "", 0)
+
+	t.Run("never used addresses first", func(t *testing.T) {
+		a := newTestIPAM(t, 0, nil, pool)
+		l := mustAllocate(t, a, session(1))
+		if err := a.release(context.Background(), l); err != nil {
+			t.Fatal(err)
+		}
+		if got := mustAllocate(t, a, session(2)); got.Address != "10.60.0.2" {
+			t.Errorf("allocate() = %s, want 10.60.0.2", got.Address)
+		}
+	})
+
+	t.Run("held back", func(t *testing.T) {
+		a := newTestIPAM(t, time.Hour, nil, pool)
+		var leases []*Lease
+		for id := uint8(1); id <= 3; id++ {
+			leases = append(leases, mustAllocate(t, a, session(id)))
+		}
+		if err := a.release(context.Background(), leases[0]); err != nil {
+			t.Fatal(err)
+		}
+		if l, err := a.allocate(context.Background(), session(4), ipv4, "internet", testSNSSAI, nil); !errors.Is(err, errPoolExhausted) {
+			t.Errorf("allocate() = %v, %v, want %v", l, err, errPoolExhausted)
+		}
+
+		// A static address can still be taken out of quarantine
+		l, err := a.allocate(context.Background(), session(4), ipv4, "internet", testSNSSAI, leases[0].IP())
+		if err != nil {
+			t.Fatalf("allocate() of the static address error: %v", err)
+		}
+		if !l.Static || l.Address != leases[0].Address {
+			t.Errorf("allocate() = %+v, want static %s", l, leases[0].Address)
+		}
+	})
+
+	t.Run("reused in release order", func(t *testing.T) {
+		const quarantine = 20 * time.Millisecond
+		a := newTestIPAM(t, quarantine, nil, pool)
+		var leases []*Lease
+		for id := uint8(1); id <= 3; id++ {
+			leases = append(leases, mustAllocate(t, a, session(id)))
+		}
+		for _, i := range []int{2, 0} {
+			if err := a.release(context.Background(), leases[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(2 * quarantine)
+
+		for _, want := range []string{"10.60.0.3", "10.60.0.1"} {
+			if got := mustAllocate(t, a, session(4)); got.Address != want {
+				t.Errorf("allocate() = %s, want %s", got.Address, want)
+			}
+		}
+	})
+
+	t.Run("double release", func(t *testing.T) {
+		a := newTestIPAM(t, time.Hour, nil, pool)
+		l := mustAllocate(t, a, session(1))
+		for i := 0; i < 2; i++ {
+			if err := a.release(context.Background(), l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := a.pools[0].ranges[ipv4].quarantine; len(got) != 1 {
+			t.Errorf("%d quarantined addresses, want 1", len(got))
+		}
+	})
+}
+
+func TestStoreFailure(t *testing.T) {
+	store := newMemoryStore()
+	a := newTestIPAM(t, time.Hour, store, testPool(t, "default", "", nil, "10.60.0.1", "10.60.0.2", "", 0))
+
+	store.err = errors.New("connection refused")
+	if l, err := a.allocate(context.Background(), session(1), ipv4, "internet", testSNSSAI, nil); err == nil {
+		t.Fatalf("allocate() = %s, want an error", l.Address)
+	}
+
+	// The reservation was rolled back
+	if r := a.pools[0].ranges[ipv4]; r.inUse != 0 || len(r.slots) != 0 {
+		t.Errorf("%d addresses in use, %d slots after the failure, want none", r.inUse, len(r.slots))
+	}
+	store.err = nil
+	l := mustAllocate(t, a, session(1))
+	if _, ok := store.lease(t, "default", l.Address); !ok {
+		t.Errorf("lease of %s not saved", l.Address)
+	}
+
+	// The address stays in use while the store has it so
+	store.err = errors.New("connection refused")
+	if err := a.release(context.Background(), l); err == nil {
+		t.Fatal("release() succeeded, want an error")
+	}
+	if !l.ReleasedAt.IsZero() {
+		t.Error("lease released despite the store failure")
+	}
+	store.err = nil
+	if err := a.release(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := store.lease(t, "default", l.Address); saved.ReleasedAt.IsZero() {
+		t.Error("release not saved")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	store := newMemoryStore()
+	pool := testPool(t, "default", "", nil, "10.60.0.1", "10.60.0.4", "", 0)
+
+	a := newTestIPAM(t, time.Hour, store, pool)
+	inUse := mustAllocate(t, a, session(1))
+	released := mustAllocate(t, a, session(2))
+	if err := a.release(context.Background(), released); err != nil {
+		t.Fatal(err)
+	}
+
+	// A lease whose quarantine ended, and one out of the pools
+	if err := store.Save(context.Background(), Lease{Pool: "default", Address: "10.60.0.3", SUPI: testKey.supi, PDUSessionID: 3,
+		ReleasedAt: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(context.Background(), Lease{Pool: "removed", Address: "10.70.0.1", SUPI: testKey.supi, PDUSessionID: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	b := newTestIPAM(t, time.Hour, store, pool)
+	for _, addr := range []string{"10.60.0.3", "10.70.0.1"} {
+		if _, ok := store.lease(t, "default", addr); ok {
+			t.Errorf("stale lease of %s kept", addr)
+		}
+	}
+	if _, ok := store.lease(t, "removed", "10.70.0.1"); ok {
+		t.Error("lease out of the pools kept")
+	}
+
+	// The address in use and the held back one are not handed out
+	for _, want := range []string{"10.60.0.3", "10.60.0.4"} {
+		if got := mustAllocate(t, b, session(5)); got.Address != want {
+			t.Errorf("allocate() = %s, want %s", got.Address, want)
+		}
+	}
+	if _, err := b.allocate(context.Background(), session(6), ipv4, "internet", testSNSSAI, nil); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("allocate() error = %v, want %v", err, errPoolExhausted)
+	}
+
+	// The PDU session established again gives up its restored address,
+	// held back as any released one
+	if _, err := b.allocate(context.Background(), session(1), ipv4, "internet", testSNSSAI, nil); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("allocate() error = %v, want %v", err, errPoolExhausted)
+	}
+	saved, ok := store.lease(t, "default", inUse.Address)
+	if !ok || saved.ReleasedAt.IsZero() {
+		t.Errorf("restored lease of %s = %+v, want released", inUse.Address, saved)
+	}
+	if len(b.restored) != 0 {
+		t.Errorf("%d PDU sessions with restored leases, want none", len(b.restored))
+	}
+}
+
+// TestAllocateSlash16 checks that allocation stays O(1) over a large
+// range: never used addresses are taken in order, released ones from the
+// free list, without scanning the range
+func TestAllocateSlash16(t *testing.T) {
+	a := newTestIPAM(t, 0, nil, testPool(t, "default", "", nil, "10.0.0.0", "10.0.255.255", "", 0))
+	r := a.pools[0].ranges[ipv4]
+	if len(r.slots) != 0 {
+		t.Fatalf("%d slots before allocation, want none", len(r.slots))
+	}
+
+	leases := make([]*Lease, 0, 1<<16)
+	for i := 0; i < 1<<16; i++ {
+		leases = append(leases, mustAllocate(t, a, session(uint8(i))))
+	}
+	if got := leases[len(leases)-1].Address; got != "10.0.255.255" {
+		t.Errorf("last allocate() = %s, want 10.0.255.255", got)
+	}
+	if r.next != r.size || r.inUse != 1<<16 {
+		t.Errorf("next = %d, in use = %d, want %d", r.next, r.inUse, 1<<16)
+	}
+	if _, err := a.allocate(context.Background(), session(0), ipv4, "internet", testSNSSAI, nil); !errors.Is(err, errPoolExhausted) {
+		t.Fatalf("allocate() error = %v, want %v", err, errPoolExhausted)
+	}
+
+	for _, i := range []int{40000, 7} {
+		if err := a.release(context.Background(), leases[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(r.free) != 2 || len(r.slots) != 1<<16-2 {
+		t.Fatalf("%d free, %d slots, want 2 and %d", len(r.free), len(r.slots), 1<<16-2)
+	}
+	for _, want := range []string{"10.0.156.64", "10.0.0.7"} {
+		if got := mustAllocate(t, a, session(0)); got.Address != want {
+			t.Errorf("allocate() = %s, want %s", got.Address, want)
+		}
+	}
+	if len(r.free) != 0 {
+		t.Errorf("%d free after reuse, want none", len(r.free))
+	}
+}
@@ -0,0 +1,621 @@
+package smf
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+)
+
+// Identifiers of the QoS flows and QoS rules of a PDU session. The
+// default QoS flow and rule take the first ones.
+const (
+	maxQFI        = 63
+	defaultRuleID = 1
+	maxRuleID     = 255
+
+	// defaultRulePrecedence is the precedence of the default QoS rule,
+	// evaluated after the rules of the PCF
+	defaultRulePrecedence = 255
+)
+
+// resourceType is the resource type of a 5QI (TS 23.501 5.7.3.2)
+type resourceType uint8
+
+const (
+	nonGBR resourceType = iota
+	gbr
+	delayCriticalGBR
+)
+
+// standardFiveQIs holds the resource type of the standardized 5QIs (TS
+// 23.501 Table 5.7.4-1)
+var standardFiveQIs = map[uint8]resourceType{
+	1: gbr, 2: gbr, 3: gbr, 4: gbr, 65: gbr, 66: gbr, 67: gbr,
+	71: gbr, 72: gbr, 73: gbr, 74: gbr, 76: gbr,
+	5: nonGBR, 6: nonGBR, 7: nonGBR, 8: nonGBR, 9: nonGBR,
+	69: nonGBR, 70: nonGBR, 79: nonGBR, 80: nonGBR,
+	82: delayCriticalGBR, 83: delayCriticalGBR, 84: delayCriticalGBR,
+	85: delayCriticalGBR, 86: delayCriticalGBR, 87: delayCriticalGBR,
+	88: delayCriticalGBR, 89: delayCriticalGBR, 90: delayCriticalGBR,
+}
+
+// fiveQIResourceType returns the resource type of a 5QI. Operator
+// specific 5QIs are GBR when given guaranteed bit rates.
+func fiveQIResourceType(fiveQI uint8, guaranteed bool) (resourceType, error) {
+	if t, ok := standardFiveQIs[fiveQI]; ok {
+		return t, nil
+	}
+	if fiveQI >= 128 && fiveQI <= 254 {
+		if guaranteed {
+			return gbr, nil
+		}
+		return nonGBR, nil
+	}
+	return 0, fmt.Errorf("unknown 5QI %d", fiveQI)
+}
+
+// PCCRule is a PCC rule of the PCF as applied to a PDU session: a QoS
+// rule of the UE and PDRs of the UPF binding a service data flow to a
+// QoS flow
+type PCCRule struct {
+	// ID identifies the rule in the PCF
+	ID string
+
+	// RuleID identifies the QoS rule in the UE and the PDRs in the UPF
+	RuleID uint8
+
+	// Precedence orders the rules, the lowest first, before the default
+	// QoS rule
+	Precedence uint8
+
+	Filters []FlowFilter
+
+	// QFI is the QoS flow of the service data flow
+	QFI uint8
+
+	// RatingGroup charges the service data flow, the default rating
+	// group when 0
+	RatingGroup uint32
+}
+
+// FlowFilter is a packet filter of a service data flow
+type FlowFilter struct {
+	// Description is an IPFilterRule of RFC 6733 in the downlink
+	// direction, e.g. "permit out 17 from 10.0.0.1 5060 to assigned"
+	Description string
+
+	Direction nas.PacketFilterDirection
+
+	// components are the NAS packet filter components of Description
+	components []byte
+}
+
+// QoSFlow is a QoS flow of a PDU session other than the default one
+type QoSFlow struct {
+	QFI    uint8
+	FiveQI uint8
+
+	// PriorityLevel overrides the priority of the 5QI when not 0
+	PriorityLevel uint8
+
+	ARP ngap.AllocationAndRetentionPriority
+
+	// GBR holds the bit rates of a GBR flow, nil for a non-GBR one
+	GBR *ngap.GBRQosInformation
+}
+
+// sessionQoS is the QoS of a PDU session decided from its policy
+type sessionQoS struct {
+	AMBR  nas.SessionAMBR
+	QoS   DefaultQoS
+	Rules []PCCRule
+	Flows []QoSFlow
+
+	// qfis and ruleIDs hold the QFI of each QoS decision and the QoS
+	// rule ID of each PCC rule
+	qfis    map[string]uint8
+	ruleIDs map[string]uint8
+}
+
+// sessionQoS returns the QoS of the PDU session
+func (c *SMContext) sessionQoS() sessionQoS {
+	return sessionQoS{AMBR: c.AMBR, QoS: c.QoS, Rules: c.Rules, Flows: c.Flows, qfis: c.qfis, ruleIDs: c.ruleIDs}
+}
+
+// setQoS applies the QoS decided for the PDU session, and the rating
+// groups of its rules
+func (c *SMContext) setQoS(q sessionQoS) {
+	c.AMBR, c.QoS, c.Rules, c.Flows, c.qfis, c.ruleIDs = q.AMBR, q.QoS, q.Rules, q.Flows, q.qfis, q.ruleIDs
+	if c.charging != nil {
+		c.charging.setRules(q.Rules)
+	}
+}
+
+// decideQoS maps the policy of the PDU session to its QoS. Session rules
+// set the session AMBR and the default QoS. The QoS decisions referred
+// to by PCC rules become QoS flows, while PCC rules referring to none
+// use the default QoS flow. PCC rules are charged to the rating group of
+// their charging decision. QFIs and QoS rule IDs are kept across policy
+// updates.
+func (c *SMContext) decideQoS(policy *models.SmPolicyDecision) (sessionQoS, error) {
+	q := sessionQoS{AMBR: c.AMBR, QoS: c.QoS, qfis: make(map[string]uint8), ruleIDs: make(map[string]uint8)}
+
+	var err error
+	for _, id := range sortedKeys(policy.SessRules) {
+		rule := policy.SessRules[id]
+		if rule == nil {
+			continue
+		}
+		if rule.AuthSessAmbr != nil {
+			if q.AMBR, err = parseAMBR(*rule.AuthSessAmbr); err != nil {
+				return q, fmt.Errorf("session rule %s: %w", id, err)
+			}
+		}
+		if d := rule.AuthDefQos; d != nil {
+			q.QoS.FiveQI, q.QoS.PriorityLevel = uint8(d.Var5qi), uint8(d.PriorityLevel)
+			if d.Arp != nil {
+				q.QoS.ARP = arp(*d.Arp)
+			}
+		}
+	}
+	if t, err := fiveQIResourceType(q.QoS.FiveQI, false); err != nil || t != nonGBR {
+		return q, fmt.Errorf("default QoS flow needs a non-GBR 5QI, not %d", q.QoS.FiveQI)
+	}
+
+	for _, id := range sortedKeys(policy.PccRules) {
+		pcc := policy.PccRules[id]
+		if pcc == nil {
+			continue
+		}
+		rule, err := pccRule(id, pcc)
+		if err != nil {
+			return q, err
+		}
+
+		rule.QFI = defaultQFI
+		if len(pcc.RefQosData) > 0 {
+			ref := pcc.RefQosData[0]
+			qfi, ok := q.qfis[ref]
+			if !ok {
+				data := policy.QosDecs[ref]
+				if data == nil {
+					return q, fmt.Errorf("PCC rule %s refers to unknown QoS decision %s", id, ref)
+				}
+				flow, err := qosFlow(data)
+				if err != nil {
+					return q, fmt.Errorf("QoS decision %s: %w", ref, err)
+				}
+				if qfi, err = allocateID(c.qfis, q.qfis, ref, defaultQFI+1, maxQFI); err != nil {
+					return q, fmt.Errorf("no QFI left for QoS decision %s", ref)
+				}
+				flow.QFI = qfi
+				q.Flows = append(q.Flows, flow)
+			}
+			rule.QFI = qfi
+		}
+		if len(pcc.RefChgData) > 0 {
+			ref := pcc.RefChgData[0]
+			data := policy.ChgDecs[ref]
+			if data == nil {
+				return q, fmt.Errorf("PCC rule %s refers to unknown charging decision %s", id, ref)
+			}
+			rule.RatingGroup = data.RatingGroup
+		}
+		if rule.RuleID, err = allocateID(c.ruleIDs, q.ruleIDs, id, defaultRuleID+1, maxRuleID); err != nil {
+			return q, fmt.Errorf("no QoS rule ID left for PCC rule %s", id)
+		}
+		q.Rules = append(q.Rules, rule)
+	}
+	return q, nil
+}
+
+// allocateID returns the identifier of key: the current one, or the
+// lowest in [first, last] neither in use nor being freed
+func allocateID(current, next map[string]uint8, key string, first, last int) (uint8, error) {
+	if id, ok := current[key]; ok {
+		next[key] = id
+		return id, nil
+	}
+
+	used := make(map[uint8]bool, len(current)+len(next))
+	for _, id := range current {
+		used[id] = true
+	}
+	for _, id := range next {
+		used[id] = true
+	}
+	for id := first; id <= last; id++ {
+		if !used[uint8(id)] {
+			next[key] = uint8(id)
+			return uint8(id), nil
+		}
+	}
+	return 0, fmt.Errorf("no identifier left")
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pccRule returns a PCC rule as applied to the PDU session, without its
+// identifiers
+func pccRule(id string, pcc *models.PccRule) (PCCRule, error) {
+	rule := PCCRule{ID: id, Precedence: uint8(pcc.Precedence)}
+	if pcc.Precedence <= 0 || pcc.Precedence >= defaultRulePrecedence {
+		rule.Precedence = defaultRulePrecedence - 1
+	}
+
+	if len(pcc.FlowInfos) == 0 {
+		return rule, fmt.Errorf("PCC rule %s has no flow information", id)
+	}
+	if len(pcc.FlowInfos) > 15 {
+		return rule, fmt.Errorf("PCC rule %s has more than 15 flows", id)
+	}
+	for _, info := range pcc.FlowInfos {
+		components, err := packetFilterComponents(info.FlowDescription)
+		if err != nil {
+			return rule, fmt.Errorf("PCC rule %s: %w", id, err)
+		}
+		rule.Filters = append(rule.Filters, FlowFilter{
+			Description: info.FlowDescription,
+			Direction:   filterDirection(info.FlowDirection),
+			components:  components,
+		})
+	}
+	return rule, nil
+}
+
+// filterDirection returns the packet filter direction of a flow
+func filterDirection(d models.FlowDirection) nas.PacketFilterDirection {
+	switch d {
+	case models.FlowDirectionDownlink:
+		return nas.PacketFilterDownlink
+	case models.FlowDirectionUplink:
+		return nas.PacketFilterUplink
+	default:
+		return nas.PacketFilterBidirectional
+	}
+}
+
+// qosFlow returns the QoS flow of a QoS decision, without its QFI. GBR
+// flows without maximum bit rates are limited to the guaranteed ones.
+func qosFlow(data *models.QosData) (QoSFlow, error) {
+	if data.Var5qi < 1 || data.Var5qi > 255 {
+		return QoSFlow{}, fmt.Errorf("invalid 5QI %d", data.Var5qi)
+	}
+	flow := QoSFlow{
+		FiveQI:        uint8(data.Var5qi),
+		PriorityLevel: uint8(data.PriorityLevel),
+		ARP:           ngap.AllocationAndRetentionPriority{PriorityLevel: defaultARPPriority},
+	}
+	if data.Arp != nil {
+		flow.ARP = arp(*data.Arp)
+	}
+
+	t, err := fiveQIResourceType(flow.FiveQI, data.GbrUl != "" || data.GbrDl != "")
+	if err != nil || t == nonGBR {
+		return flow, err
+	}
+	if data.GbrUl == "" || data.GbrDl == "" {
+		return flow, fmt.Errorf("GBR 5QI %d without guaranteed bit rates", flow.FiveQI)
+	}
+
+	rates := make([]uint64, 4)
+	for i, s := range []string{data.GbrUl, data.GbrDl, data.MaxbrUl, data.MaxbrDl} {
+		if s == "" {
+			rates[i] = rates[i-2]
+			continue
+		}
+		if rates[i], err = models.ParseBitRate(s); err != nil {
+			return flow, err
+		}
+	}
+	flow.GBR = &ngap.GBRQosInformation{
+		GuaranteedFlowBitRateUL: rates[0],
+		GuaranteedFlowBitRateDL: rates[1],
+		MaximumFlowBitRateUL:    rates[2],
+		MaximumFlowBitRateDL:    rates[3],
+	}
+	return flow, nil
+}
+
+// packetFilterComponents returns the NAS packet filter components of an
+// IPFilterRule in the downlink direction: "permit out <protocol> from
+// <remote> [<ports>] to <UE> [<ports>]", with addresses "any",
+// "assigned" or IPv4 prefixes, and ports single or ranges
+func packetFilterComponents(rule string) ([]byte, error) {
+	fields := strings.Fields(rule)
+	if len(fields) < 6 || fields[0] != "permit" || fields[1] != "out" {
+		return nil, fmt.Errorf("unsupported flow description %q", rule)
+	}
+
+	var protocol []byte
+	switch p := fields[2]; p {
+	case "ip":
+	case "tcp":
+		protocol = []byte{nas.PacketFilterComponentProtocol, 6}
+	case "udp":
+		protocol = []byte{nas.PacketFilterComponentProtocol, 17}
+	default:
+		n, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol in flow description %q", rule)
+		}
+		protocol = []byte{nas.PacketFilterComponentProtocol, uint8(n)}
+	}
+
+	// from <remote> [<ports>] to <UE> [<ports>]
+	sides := []struct {
+		keyword                   string
+		addrType, port, portRange uint8
+		addr, ports               []byte
+	}{
+		{"from", nas.PacketFilterComponentIPv4Remote, nas.PacketFilterComponentRemotePort, nas.PacketFilterComponentRemotePortRange, nil, nil},
+		{"to", nas.PacketFilterComponentIPv4Local, nas.PacketFilterComponentLocalPort, nas.PacketFilterComponentLocalPortRange, nil, nil},
+	}
+	rest := fields[3:]
+	for i := range sides {
+		side := &sides[i]
+		if len(rest) < 2 || rest[0] != side.keyword {
+			return nil, fmt.Errorf("unsupported flow description %q", rule)
+		}
+		var err error
+		if side.addr, err = addressComponent(side.addrType, rest[1]); err != nil {
+			return nil, fmt.Errorf("flow description %q: %w", rule, err)
+		}
+		rest = rest[2:]
+		if len(rest) > 0 && rest[0] != "to" {
+			if side.ports, err = portComponent(side.port, side.portRange, rest[0]); err != nil {
+				return nil, fmt.Errorf("flow description %q: %w", rule, err)
+			}
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("unsupported options in flow description %q", rule)
+	}
+
+	// Components in increasing order of type
+	var b []byte
+	for _, c := range [][]byte{sides[0].addr, sides[1].addr, protocol, sides[1].ports, sides[0].ports} {
+		b = append(b, c...)
+	}
+	if len(b) == 0 {
+		return nas.PacketFilterMatchAll, nil
+	}
+	return b, nil
+}
+
+// addressComponent returns the component matching an address of an
+// IPFilterRule, nil for any address or the one of the UE
+func addressComponent(t uint8, addr string) ([]byte, error) {
+	if addr == "any" || addr == "assigned" {
+		return nil, nil
+	}
+	if !strings.Contains(addr, "/") {
+		addr += "/32"
+	}
+	_, prefix, err := net.ParseCIDR(addr)
+	if err != nil || prefix.IP.To4() == nil {
+		return nil, fmt.Errorf("unsupported address %s", addr)
+	}
+	b := append([]byte{t}, prefix.IP.To4()...)
+	return append(b, prefix.Mask...), nil
+}
+
+// portComponent returns the component matching a port or a port range
+// of an IPFilterRule
+func portComponent(single, ranged uint8, ports string) ([]byte, error) {
+	low, high, isRange := strings.Cut(ports, "-")
+	from, err := strconv.ParseUint(low, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported ports %s", ports)
+	}
+	if !isRange {
+		return []byte{single, uint8(from >> 8), uint8(from)}, nil
+	}
+	to, err := strconv.ParseUint(high, 10, 16)
+	if err != nil || to < from {
+		return nil, fmt.Errorf("unsupported ports %s", ports)
+	}
+	return []byte{ranged, uint8(from >> 8), uint8(from), uint8(to >> 8), uint8(to)}, nil
+}
+
+// qosRule returns the QoS rule of a PCC rule for the UE
+func (r PCCRule) qosRule(op nas.QoSRuleOperation) nas.QoSRule {
+	rule := nas.QoSRule{ID: r.RuleID, Operation: op, Precedence: r.Precedence, QFI: r.QFI}
+	for i, f := range r.Filters {
+		rule.PacketFilters = append(rule.PacketFilters, nas.PacketFilter{
+			ID:         uint8(i + 1),
+			Direction:  f.Direction,
+			Components: f.components,
+		})
+	}
+	return rule
+}
+
+// defaultQoSRule is the default QoS rule of a PDU session, matching all
+// packets the other rules do not
+var defaultQoSRule = nas.QoSRule{
+	ID:      defaultRuleID,
+	Default: true,
+	PacketFilters: []nas.PacketFilter{{
+		ID:         1,
+		Direction:  nas.PacketFilterBidirectional,
+		Components: nas.PacketFilterMatchAll,
+	}},
+	Precedence: defaultRulePrecedence,
+	QFI:        defaultQFI,
+}
+
+// description returns the QoS flow description of a flow for the UE
+func (f QoSFlow) description(op nas.QoSFlowOperation) nas.QoSFlowDescription {
+	d := nas.QoSFlowDescription{QFI: f.QFI, Operation: op, FiveQI: f.FiveQI}
+	if f.GBR != nil {
+		d.GFBRUplink, d.GFBRDownlink = f.GBR.GuaranteedFlowBitRateUL, f.GBR.GuaranteedFlowBitRateDL
+		d.MFBRUplink, d.MFBRDownlink = f.GBR.MaximumFlowBitRateUL, f.GBR.MaximumFlowBitRateDL
+	}
+	return d
+}
+
+// setupItem returns the QoS flow as set up in the gNB
+func (f QoSFlow) setupItem() ngap.QosFlowSetupRequestItem {
+	return ngap.QosFlowSetupRequestItem{
+		QFI:           f.QFI,
+		FiveQI:        f.FiveQI,
+		PriorityLevel: f.PriorityLevel,
+		ARP:           f.ARP,
+		GBR:           f.GBR,
+	}
+}
+
+// defaultFlow returns the default QoS flow
+func (q sessionQoS) defaultFlow() QoSFlow {
+	return QoSFlow{QFI: defaultQFI, FiveQI: q.QoS.FiveQI, PriorityLevel: q.QoS.PriorityLevel, ARP: q.QoS.ARP}
+}
+
+// qosRules returns the QoS rules given to the UE at establishment
+func (q sessionQoS) qosRules() nas.QoSRules {
+	rules := nas.QoSRules{defaultQoSRule}
+	for _, r := range q.Rules {
+		rules = append(rules, r.qosRule(nas.QoSRuleCreate))
+	}
+	return rules
+}
+
+// flowDescriptions returns the QoS flow descriptions given to the UE at
+// establishment
+func (q sessionQoS) flowDescriptions() nas.QoSFlowDescriptions {
+	var flows nas.QoSFlowDescriptions
+	for _, f := range q.Flows {
+		flows = append(flows, f.description(nas.QoSFlowCreate))
+	}
+	return flows
+}
+
+// setupItems returns the QoS flows set up in the gNB
+func (q sessionQoS) setupItems() []ngap.QosFlowSetupRequestItem {
+	items := []ngap.QosFlowSetupRequestItem{q.defaultFlow().setupItem()}
+	for _, f := range q.Flows {
+		items = append(items, f.setupItem())
+	}
+	return items
+}
+
+// qosChanges is what changes between two QoS of a PDU session, for the
+// UE and the gNB
+type qosChanges struct {
+	ambr  *nas.SessionAMBR
+	rules nas.QoSRules
+	flows nas.QoSFlowDescriptions
+
+	addOrModify []ngap.QosFlowSetupRequestItem
+	release     []ngap.QosFlowWithCause
+}
+
+// empty reports whether nothing changed
+func (ch *qosChanges) empty() bool {
+	return ch.ambr == nil && len(ch.rules) == 0 && len(ch.flows) == 0 &&
+		len(ch.addOrModify) == 0 && len(ch.release) == 0
+}
+
+// diffQoS returns the changes from the QoS of a PDU session to the next
+func diffQoS(old, next sessionQoS) *qosChanges {
+	ch := &qosChanges{}
+	if old.AMBR != next.AMBR {
+		ambr := next.AMBR
+		ch.ambr = &ambr
+	}
+	if old.QoS != next.QoS {
+		f := next.defaultFlow()
+		ch.flows = append(ch.flows, f.description(nas.QoSFlowModify))
+		ch.addOrModify = append(ch.addOrModify, f.setupItem())
+	}
+
+	oldFlows := make(map[uint8]QoSFlow, len(old.Flows))
+	for _, f := range old.Flows {
+		oldFlows[f.QFI] = f
+	}
+	for _, f := range next.Flows {
+		o, ok := oldFlows[f.QFI]
+		delete(oldFlows, f.QFI)
+		switch {
+		case !ok:
+			ch.flows = append(ch.flows, f.description(nas.QoSFlowCreate))
+		case !reflect.DeepEqual(o, f):
+			ch.flows = append(ch.flows, f.description(nas.QoSFlowModify))
+		default:
+			continue
+		}
+		ch.addOrModify = append(ch.addOrModify, f.setupItem())
+	}
+	for _, f := range old.Flows {
+		if _, ok := oldFlows[f.QFI]; ok {
+			ch.flows = append(ch.flows, nas.QoSFlowDescription{QFI: f.QFI, Operation: nas.QoSFlowDelete})
+			ch.release = append(ch.release, ngap.QosFlowWithCause{QFI: f.QFI, Cause: ngap.CauseNASNormalRelease})
+		}
+	}
+
+	oldRules := make(map[uint8]PCCRule, len(old.Rules))
+	for _, r := range old.Rules {
+		oldRules[r.RuleID] = r
+	}
+	for _, r := range next.Rules {
+		o, ok := oldRules[r.RuleID]
+		delete(oldRules, r.RuleID)
+		switch {
+		case !ok:
+			ch.rules = append(ch.rules, r.qosRule(nas.QoSRuleCreate))
+		case !reflect.DeepEqual(o.qosRule(0), r.qosRule(0)):
+			ch.rules = append(ch.rules, r.qosRule(nas.QoSRuleModifyAndReplaceFilter))
+		}
+	}
+	for _, r := range old.Rules {
+		if _, ok := oldRules[r.RuleID]; ok {
+			ch.rules = append(ch.rules, nas.QoSRule{ID: r.RuleID, Operation: nas.QoSRuleDelete})
+		}
+	}
+	return ch
+}
+
+// mergePolicy applies an update of the PCF to a policy. Nil rules and
+// decisions of the update remove those of the same identifier.
+func mergePolicy(policy *models.SmPolicyDecision, update *models.SmPolicyDecision) {
+	policy.SessRules = mergeMap(policy.SessRules, update.SessRules)
+	policy.PccRules = mergeMap(policy.PccRules, update.PccRules)
+	policy.QosDecs = mergeMap(policy.QosDecs, update.QosDecs)
+	policy.ChgDecs = mergeMap(policy.ChgDecs, update.ChgDecs)
+	if update.PolicyCtrlReqTriggers != nil {
+		policy.PolicyCtrlReqTriggers = update.PolicyCtrlReqTriggers
+	}
+}
+
+// mergeMap returns a copy of m with the entries of update, nil ones
+// removing those of the same key
+func mergeMap[V any](m map[string]*V, update map[string]*V) map[string]*V {
+	merged := make(map[string]*V, len(m)+len(update))
+	for k, v := range m {
+		merged[k] = v
+	}
+	for k, v := range update {
+		if v == nil {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,694 @@
+package smf
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/pfcp"
+)
+
+func TestPacketFilterComponents(t *testing.T) {
+	tests := []struct {
+		rule    string
+		want    []byte
+		wantErr bool
+	}{
+		{rule: "permit out ip from any to assigned", want: nas.PacketFilterMatchAll},
+		{
+			rule: "permit out 17 from 10.0.0.1 5060 to assigned",
+			want: []byte{
+				nas.PacketFilterComponentIPv4Remote, 10, 0, 0, 1, 255, 255, 255, 255,
+				nas.PacketFilterComponentProtocol, 17,
+				nas.PacketFilterComponentRemotePort, 0x13, 0xc4,
+			},
+		},
+		{
+			rule: "permit out tcp from 192.168.0.0/16 to assigned 8000-8080",
+			want: []byte{
+				nas.PacketFilterComponentIPv4Remote, 192, 168, 0, 0, 255, 255, 0, 0,
+				nas.PacketFilterComponentProtocol, 6,
+				nas.PacketFilterComponentLocalPortRange, 0x1f, 0x40, 0x1f, 0x90,
+			},
+		},
+		{
+			rule: "permit out udp from any 1000-2000 to 10.60.0.1 53",
+			want: []byte{
+				nas.PacketFilterComponentIPv4Local, 10, 60, 0, 1, 255, 255, 255, 255,
+				nas.PacketFilterComponentProtocol, 17,
+				nas.PacketFilterComponentLocalPort, 0, 53,
+				nas.PacketFilterComponentRemotePortRange, 0x03, 0xe8, 0x07, 0xd0,
+			},
+		},
+		{rule: "deny out ip from any to assigned", wantErr: true},
+		{rule: "permit in ip from any to assigned", wantErr: true},
+		{rule: "permit out sctp from any to assigned", wantErr: true},
+		{rule: "permit out ip from 2001:db8::1 to assigned", wantErr: true},
+		{rule: "permit out ip from any 2000-1000 to assigned", wantErr: true},
+		{rule: "permit out ip to assigned from any", wantErr: true},
+		{rule: "permit out ip from any to assigned 80 established", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			got, err := packetFilterComponents(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("components = % x, want % x", got, tt.want)
+			}
+		})
+	}
+}
+
+// Policy decisions of a voice service data flow on a GBR QoS flow and of
+// a video one on a non-GBR QoS flow, charged to their own rating groups
+var (
+	voiceRule = &models.PccRule{
+		PccRuleID:  "voice",
+		FlowInfos:  []models.FlowInformation{{FlowDescription: "permit out 17 from 10.0.0.1 5060 to assigned"}},
+		Precedence: 10,
+		RefQosData: []string{"qos-voice"},
+		RefChgData: []string{"chg-voice"},
+	}
+	voiceQoS = &models.QosData{
+		QosID:   "qos-voice",
+		Var5qi:  1,
+		GbrUl:   "64 Kbps",
+		GbrDl:   "64 Kbps",
+		MaxbrDl: "128 Kbps",
+		Arp:     &models.Arp{PriorityLevel: 2},
+	}
+	voiceCharging = &models.ChargingData{ChgID: "chg-voice", RatingGroup: 200}
+
+	videoRule = &models.PccRule{
+		PccRuleID: "video",
+		FlowInfos: []models.FlowInformation{{
+			FlowDescription: "permit out tcp from 192.168.0.0/16 443 to assigned",
+			FlowDirection:   models.FlowDirectionDownlink,
+		}},
+		Precedence: 20,
+		RefQosData: []string{"qos-video"},
+	}
+	videoQoS = &models.QosData{QosID: "qos-video", Var5qi: 6}
+)
+
+// voiceDecision returns a policy decision of the voice service data flow
+func voiceDecision() models.SmPolicyDecision {
+	return models.SmPolicyDecision{
+		PccRules: map[string]*models.PccRule{"voice": voiceRule},
+		QosDecs:  map[string]*models.QosData{"qos-voice": voiceQoS},
+		ChgDecs:  map[string]*models.ChargingData{"chg-voice": voiceCharging},
+	}
+}
+
+// voiceFlow is the QoS flow of voiceQoS with QFI 2
+var voiceFlow = QoSFlow{
+	QFI:    2,
+	FiveQI: 1,
+	ARP:    ngap.AllocationAndRetentionPriority{PriorityLevel: 2},
+	GBR: &ngap.GBRQosInformation{
+		GuaranteedFlowBitRateUL: 64000,
+		GuaranteedFlowBitRateDL: 64000,
+		MaximumFlowBitRateUL:    64000,
+		MaximumFlowBitRateDL:    128000,
+	},
+}
+
+func TestDecideQoS(t *testing.T) {
+	subscribed := DefaultQoS{FiveQI: 9, ARP: ngap.AllocationAndRetentionPriority{PriorityLevel: 8}}
+	ambr := nas.SessionAMBR{Uplink: 100e6, Downlink: 200e6}
+
+	tests := []struct {
+		name     string
+		decision models.SmPolicyDecision
+
+		// qfis and ruleIDs are those of the session before the decision
+		qfis    map[string]uint8
+		ruleIDs map[string]uint8
+
+		wantAMBR  nas.SessionAMBR
+		wantQoS   DefaultQoS
+		wantRules []PCCRule // without their filters
+		wantFlows []QoSFlow
+		wantErr   bool
+	}{
+		{
+			name:     "subscribed",
+			wantAMBR: ambr,
+			wantQoS:  subscribed,
+		},
+		{
+			name: "session rule",
+			decision: models.SmPolicyDecision{SessRules: map[string]*models.SessionRule{"s": {
+				AuthSessAmbr: &models.Ambr{Uplink: "50 Mbps", Downlink: "80 Mbps"},
+				AuthDefQos:   &models.AuthorizedDefaultQos{Var5qi: 8, PriorityLevel: 20, Arp: &models.Arp{PriorityLevel: 5}},
+			}}},
+			wantAMBR: nas.SessionAMBR{Uplink: 50e6, Downlink: 80e6},
+			wantQoS:  DefaultQoS{FiveQI: 8, PriorityLevel: 20, ARP: ngap.AllocationAndRetentionPriority{PriorityLevel: 5}},
+		},
+		{
+			name:      "GBR flow",
+			decision:  voiceDecision(),
+			wantAMBR:  ambr,
+			wantQoS:   subscribed,
+			wantRules: []PCCRule{{ID: "voice", RuleID: 2, Precedence: 10, QFI: 2, RatingGroup: 200}},
+			wantFlows: []QoSFlow{voiceFlow},
+		},
+		{
+			name: "rules on the default and a shared flow",
+			decision: models.SmPolicyDecision{
+				PccRules: map[string]*models.PccRule{
+					"a":   {FlowInfos: videoRule.FlowInfos, RefQosData: []string{"qos-video"}},
+					"b":   {FlowInfos: videoRule.FlowInfos, Precedence: 300, RefQosData: []string{"qos-video"}},
+					"web": {FlowInfos: []models.FlowInformation{{FlowDescription: "permit out tcp from any 80 to assigned"}}, Precedence: 30},
+				},
+				QosDecs: map[string]*models.QosData{"qos-video": videoQoS},
+			},
+			wantAMBR: ambr,
+			wantQoS:  subscribed,
+			wantRules: []PCCRule{
+				{ID: "a", RuleID: 2, Precedence: defaultRulePrecedence - 1, QFI: 2},
+				{ID: "b", RuleID: 3, Precedence: defaultRulePrecedence - 1, QFI: 2},
+				{ID: "web", RuleID: 4, Precedence: 30, QFI: defaultQFI},
+			},
+			wantFlows: []QoSFlow{{QFI: 2, FiveQI: 6, ARP: ngap.AllocationAndRetentionPriority{PriorityLevel: defaultARPPriority}}},
+		},
+		{
+			name: "identifiers kept",
+			decision: models.SmPolicyDecision{
+				PccRules: map[string]*models.PccRule{"video": videoRule, "voice": voiceRule},
+				QosDecs:  map[string]*models.QosData{"qos-video": videoQoS, "qos-voice": voiceQoS},
+				ChgDecs:  map[string]*models.ChargingData{"chg-voice": voiceCharging},
+			},
+			qfis:     map[string]uint8{"qos-voice": 2},
+			ruleIDs:  map[string]uint8{"voice": 2},
+			wantAMBR: ambr,
+			wantQoS:  subscribed,
+			wantRules: []PCCRule{
+				{ID: "video", RuleID: 3, Precedence: 20, QFI: 3},
+				{ID: "voice", RuleID: 2, Precedence: 10, QFI: 2, RatingGroup: 200},
+			},
+			wantFlows: []QoSFlow{
+				{QFI: 3, FiveQI: 6, ARP: ngap.AllocationAndRetentionPriority{PriorityLevel: defaultARPPriority}},
+				voiceFlow,
+			},
+		},
+		{
+			name: "GBR default QoS",
+			decision: models.SmPolicyDecision{SessRules: map[string]*models.SessionRule{"s": {
+				AuthDefQos: &models.AuthorizedDefaultQos{Var5qi: 1},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "invalid AMBR",
+			decision: models.SmPolicyDecision{SessRules: map[string]*models.SessionRule{"s": {
+				AuthSessAmbr: &models.Ambr{Uplink: "fast", Downlink: "80 Mbps"},
+			}}},
+			wantErr: true,
+		},
+		{
+			name:     "unknown QoS decision",
+			decision: models.SmPolicyDecision{PccRules: map[string]*models.PccRule{"video": videoRule}},
+			wantErr:  true,
+		},
+		{
+			name: "unknown charging decision",
+			decision: models.SmPolicyDecision{
+				PccRules: map[string]*models.PccRule{"voice": voiceRule},
+				QosDecs:  map[string]*models.QosData{"qos-voice": voiceQoS},
+			},
+			wantErr: true,
+		},
+		{
+			name: "GBR flow without bit rates",
+			decision: models.SmPolicyDecision{
+				PccRules: map[string]*models.PccRule{"video": videoRule},
+				QosDecs:  map[string]*models.QosData{"qos-video": {QosID: "qos-video", Var5qi: 2}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "rule without flows",
+			decision: models.SmPolicyDecision{PccRules: map[string]*models.PccRule{"a": {}}},
+			wantErr:  true,
+		},
+		{
+			name: "invalid flow",
+			decision: models.SmPolicyDecision{PccRules: map[string]*models.PccRule{"a": {
+				FlowInfos: []models.FlowInformation{{FlowDescription: "permit out ip from any to any frag"}},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &SMContext{AMBR: ambr, QoS: subscribed, qfis: tt.qfis, ruleIDs: tt.ruleIDs}
+			q, err := c.decideQoS(&tt.decision)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if q.AMBR != tt.wantAMBR || q.QoS != tt.wantQoS {
+				t.Errorf("AMBR, QoS = %+v, %+v, want %+v, %+v", q.AMBR, q.QoS, tt.wantAMBR, tt.wantQoS)
+			}
+			var rules []PCCRule
+			for _, r := range q.Rules {
+				if len(r.Filters) == 0 {
+					t.Errorf("rule %s has no filters", r.ID)
+				}
+				r.Filters = nil
+				rules = append(rules, r)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("rules = %+v, want %+v", rules, tt.wantRules)
+			}
+			if !reflect.DeepEqual(q.Flows, tt.wantFlows) {
+				t.Errorf("flows = %+v, want %+v", q.Flows, tt.wantFlows)
+			}
+		})
+	}
+}
+
+func TestSessionRules(t *testing.T) {
+	voice := PCCRule{
+		ID: "voice", RuleID: 2, Precedence: 10, QFI: 2,
+		Filters: []FlowFilter{{Description: "permit out 17 from 10.0.0.1 5060 to assigned", Direction: nas.PacketFilterBidirectional}},
+	}
+	video := PCCRule{
+		ID: "video", RuleID: 3, Precedence: 20, QFI: 3,
+		Filters: []FlowFilter{{Description: "permit out tcp from 192.168.0.0/16 443 to assigned", Direction: nas.PacketFilterDownlink}},
+	}
+	videoFlow := QoSFlow{QFI: 3, FiveQI: 6}
+	ambrQERs := map[uint32]pfcp.BitRate{qerAMBR: {UL: 100000, DL: 200000}}
+
+	tests := []struct {
+		name      string
+		rules     []PCCRule
+		flows     []QoSFlow
+		reporting *UsageReporting
+
+		// wantPDRs are the QERs of each PDR
+		wantPDRs map[uint16][]uint32
+		// wantQFIs and wantMBRs are the QFIs and MBRs of the QERs, and
+		// wantGBRs their GBRs
+		wantQFIs map[uint32]uint8
+		wantMBRs map[uint32]pfcp.BitRate
+		wantGBRs map[uint32]pfcp.BitRate
+		wantURRs []uint32
+	}{
+		{
+			name:     "default rule",
+			wantPDRs: map[uint16][]uint32{pdrUplink: {qerAMBR, 2}, pdrDownlink: {qerAMBR, 2}},
+			wantQFIs: map[uint32]uint8{2: defaultQFI},
+			wantMBRs: ambrQERs,
+		},
+		{
+			name:  "GBR and downlink flows",
+			rules: []PCCRule{voice, video},
+			flows: []QoSFlow{voiceFlow, videoFlow},
+			wantPDRs: map[uint16][]uint32{
+				pdrUplink: {qerAMBR, 2}, pdrDownlink: {qerAMBR, 2},
+				3: {qerAMBR, 3}, 4: {qerAMBR, 3},
+				6: {qerAMBR, 4},
+			},
+			wantQFIs: map[uint32]uint8{2: defaultQFI, 3: 2, 4: 3},
+			wantMBRs: map[uint32]pfcp.BitRate{qerAMBR: {UL: 100000, DL: 200000}, 3: {UL: 64, DL: 128}},
+			wantGBRs: map[uint32]pfcp.BitRate{3: {UL: 64, DL: 64}},
+		},
+		{
+			name:      "usage reporting",
+			rules:     []PCCRule{voice},
+			flows:     []QoSFlow{voiceFlow},
+			reporting: &UsageReporting{VolumeThreshold: 1 << 20},
+			wantPDRs:  map[uint16][]uint32{pdrUplink: {qerAMBR, 2}, pdrDownlink: {qerAMBR, 2}, 3: {qerAMBR, 3}, 4: {qerAMBR, 3}},
+			wantQFIs:  map[uint32]uint8{2: defaultQFI, 3: 2},
+			wantMBRs:  map[uint32]pfcp.BitRate{qerAMBR: {UL: 100000, DL: 200000}, 3: {UL: 64, DL: 128}},
+			wantGBRs:  map[uint32]pfcp.BitRate{3: {UL: 64, DL: 64}},
+			wantURRs:  []uint32{1, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &N4Session{
+				DNN:       "internet",
+				Type:      nas.PDUSessionTypeIPv4,
+				UEAddress: net.IPv4(10, 60, 0, 1),
+				ULTunnel:  ngap.GTPTunnel{Address: net.IPv4(10, 100, 0, 1), TEID: 1},
+				AMBR:      ngap.UEAggregateMaximumBitRate{Uplink: 100e6, Downlink: 200e6},
+				Rules:     tt.rules,
+				Flows:     tt.flows,
+				Reporting: tt.reporting,
+			}
+			r := sessionRules(s)
+
+			pdrs := make(map[uint16][]uint32, len(r.pdrs))
+			for id, pdr := range r.pdrs {
+				if pdr.PDRID != id {
+					t.Errorf("PDR %d has ID %d", id, pdr.PDRID)
+				}
+				pdrs[id] = pdr.QERIDs
+				wantURRs := []uint32(nil)
+				if tt.reporting != nil {
+					wantURRs = []uint32{uint32((id + 1) / 2)}
+				}
+				if !reflect.DeepEqual(pdr.URRIDs, wantURRs) {
+					t.Errorf("URRs of PDR %d = %v, want %v", id, pdr.URRIDs, wantURRs)
+				}
+			}
+			if !reflect.DeepEqual(pdrs, tt.wantPDRs) {
+				t.Errorf("QERs of the PDRs = %v, want %v", pdrs, tt.wantPDRs)
+			}
+
+			qfis := make(map[uint32]uint8)
+			mbrs := make(map[uint32]pfcp.BitRate)
+			var gbrs map[uint32]pfcp.BitRate
+			for id, q := range r.qers {
+				if q.QFI != nil {
+					qfis[id] = *q.QFI
+				}
+				if q.MBR != nil {
+					mbrs[id] = *q.MBR
+				}
+				if q.GBR != nil {
+					if gbrs == nil {
+						gbrs = make(map[uint32]pfcp.BitRate)
+					}
+					gbrs[id] = *q.GBR
+				}
+			}
+			if !reflect.DeepEqual(qfis, tt.wantQFIs) || !reflect.DeepEqual(mbrs, tt.wantMBRs) || !reflect.DeepEqual(gbrs, tt.wantGBRs) {
+				t.Errorf("QERs: QFIs %v, MBRs %v, GBRs %v, want %v, %v, %v", qfis, mbrs, gbrs, tt.wantQFIs, tt.wantMBRs, tt.wantGBRs)
+			}
+			if urrs := sortedIDs(r.urrs); len(urrs)+len(tt.wantURRs) > 0 && !reflect.DeepEqual(urrs, tt.wantURRs) {
+				t.Errorf("URRs = %v, want %v", urrs, tt.wantURRs)
+			}
+
+			// The filters of a rule go to the PDRs of their directions
+			for _, rule := range tt.rules {
+				ul, dl := pdrIDs(rule.RuleID)
+				for _, id := range []uint16{ul, dl} {
+					pdr, ok := r.pdrs[id]
+					if !ok {
+						continue
+					}
+					if pdr.Precedence != uint32(rule.Precedence) || len(pdr.PDI.SDFFilters) != 1 ||
+						pdr.PDI.SDFFilters[0].FlowDescription != rule.Filters[0].Description {
+						t.Errorf("PDR %d = precedence %d, filters %+v", id, pdr.Precedence, pdr.PDI.SDFFilters)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEstablishmentQoS(t *testing.T) {
+	h := newHarness(t, nil)
+	h.nfs.mu.Lock()
+	h.nfs.decision = voiceDecision()
+	h.nfs.mu.Unlock()
+
+	c, accept, n2 := h.establish(t, "internet", establishmentRequest(1))
+
+	rules, err := nas.DecodeQoSRules(accept.AuthorizedQoSRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || !rules[0].Default || rules[0].QFI != defaultQFI ||
+		rules[1].ID != 2 || rules[1].QFI != 2 || rules[1].Precedence != 10 || len(rules[1].PacketFilters) != 1 {
+		t.Errorf("QoS rules = %+v", rules)
+	}
+	flows, err := nas.DecodeQoSFlowDescriptions(accept.AuthorizedQoSFlowDescriptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 1 || flows[0].QFI != 2 || flows[0].FiveQI != 1 || flows[0].GFBRUplink != 64000 || flows[0].MFBRDownlink != 128000 {
+		t.Errorf("QoS flow descriptions = %+v", flows)
+	}
+	if len(n2.QosFlows) != 2 || n2.QosFlows[1].QFI != 2 || !reflect.DeepEqual(n2.QosFlows[1].GBR, voiceFlow.GBR) {
+		t.Errorf("N2 QoS flows = %+v", n2.QosFlows)
+	}
+	if c.Rules[0].RatingGroup != 200 {
+		t.Errorf("rating group = %d, want 200", c.Rules[0].RatingGroup)
+	}
+
+	requests := h.upfs[0].takeRequests()
+	req, ok := requests[0].(*pfcp.SessionEstablishmentRequest)
+	if !ok {
+		t.Fatalf("request = %T, want establishment", requests[0])
+	}
+	var pdrs []uint16
+	for _, pdr := range req.CreatePDRs {
+		pdrs = append(pdrs, pdr.PDRID)
+	}
+	var qers []uint32
+	for _, q := range req.CreateQERs {
+		qers = append(qers, q.QERID)
+	}
+	if !reflect.DeepEqual(pdrs, []uint16{1, 2, 3, 4}) || !reflect.DeepEqual(qers, []uint32{1, 2, 3}) {
+		t.Errorf("PDRs %v and QERs %v, want [1 2 3 4] and [1 2 3]", pdrs, qers)
+	}
+}
+
+// n4Changes are the rules a session modification creates, updates and
+// removes
+type n4Changes struct {
+	createPDRs, removePDRs             []uint16
+	createQERs, updateQERs, removeQERs []uint32
+	createURRs, removeURRs             []uint32
+}
+
+func modificationChanges(req *pfcp.SessionModificationRequest) n4Changes {
+	var ch n4Changes
+	for _, pdr := range req.CreatePDRs {
+		ch.createPDRs = append(ch.createPDRs, pdr.PDRID)
+	}
+	ch.removePDRs = req.RemovePDRs
+	for _, q := range req.CreateQERs {
+		ch.createQERs = append(ch.createQERs, q.QERID)
+	}
+	for _, q := range req.UpdateQERs {
+		ch.updateQERs = append(ch.updateQERs, q.QERID)
+	}
+	ch.removeQERs = req.RemoveQERs
+	for _, u := range req.CreateURRs {
+		ch.createURRs = append(ch.createURRs, u.URRID)
+	}
+	ch.removeURRs = req.RemoveURRs
+	return ch
+}
+
+// qosOp is the operation of a QoS change for a QoS rule or flow
+type qosOp struct {
+	id uint8
+	op uint8
+}
+
+func TestPolicyUpdate(t *testing.T) {
+	tests := []struct {
+		name      string
+		decision  models.SmPolicyDecision // at establishment
+		activated bool
+		update    models.SmPolicyDecision
+
+		wantStatus int
+		// wantN4 are the changes of the N4 session, nil for no
+		// modification
+		wantN4 *n4Changes
+		// wantRules and wantFlows are the QoS rules and flows of the
+		// PDU Session Modification Command, with wantAMBR
+		wantRules []qosOp
+		wantFlows []qosOp
+		wantAMBR  *nas.SessionAMBR
+		// wantN2 is the N2 transfer of the command, nil for none
+		wantN2 *ngap.PDUSessionResourceModifyRequestTransfer
+	}{
+		{
+			name:       "flow added",
+			activated:  true,
+			update:     voiceDecision(),
+			wantStatus: http.StatusNoContent,
+			wantN4:     &n4Changes{createPDRs: []uint16{3, 4}, createQERs: []uint32{3}, createURRs: []uint32{2}},
+			wantRules:  []qosOp{{2, uint8(nas.QoSRuleCreate)}},
+			wantFlows:  []qosOp{{2, uint8(nas.QoSFlowCreate)}},
+			wantN2: &ngap.PDUSessionResourceModifyRequestTransfer{AddOrModify: []ngap.QosFlowSetupRequestItem{{
+				QFI: 2, FiveQI: 1, ARP: voiceFlow.ARP, GBR: voiceFlow.GBR,
+			}}},
+		},
+		{
+			name:       "flow added while deactivated",
+			update:     voiceDecision(),
+			wantStatus: http.StatusNoContent,
+			wantN4:     &n4Changes{createPDRs: []uint16{3, 4}, createQERs: []uint32{3}, createURRs: []uint32{2}},
+			wantRules:  []qosOp{{2, uint8(nas.QoSRuleCreate)}},
+			wantFlows:  []qosOp{{2, uint8(nas.QoSFlowCreate)}},
+		},
+		{
+			name:      "flow removed",
+			decision:  voiceDecision(),
+			activated: true,
+			update: models.SmPolicyDecision{
+				PccRules: map[string]*models.PccRule{"voice": nil},
+				QosDecs:  map[string]*models.QosData{"qos-voice": nil},
+			},
+			wantStatus: http.StatusNoContent,
+			wantN4:     &n4Changes{removePDRs: []uint16{3, 4}, removeQERs: []uint32{3}, removeURRs: []uint32{2}},
+			wantRules:  []qosOp{{2, uint8(nas.QoSRuleDelete)}},
+			wantFlows:  []qosOp{{2, uint8(nas.QoSFlowDelete)}},
+			wantN2: &ngap.PDUSessionResourceModifyRequestTransfer{Release: []ngap.QosFlowWithCause{{
+				QFI: 2, Cause: ngap.CauseNASNormalRelease,
+			}}},
+		},
+		{
+			name:      "AMBR changed",
+			activated: true,
+			update: models.SmPolicyDecision{SessRules: map[string]*models.SessionRule{"s": {
+				AuthSessAmbr: &models.Ambr{Uplink: "50 Mbps", Downlink: "80 Mbps"},
+			}}},
+			wantStatus: http.StatusNoContent,
+			wantN4:     &n4Changes{updateQERs: []uint32{qerAMBR}},
+			wantAMBR:   &nas.SessionAMBR{Uplink: 50e6, Downlink: 80e6},
+			wantN2: &ngap.PDUSessionResourceModifyRequestTransfer{
+				AMBR: &ngap.UEAggregateMaximumBitRate{Uplink: 50e6, Downlink: 80e6},
+			},
+		},
+		{
+			name:      "unchanged",
+			activated: true,
+			update: models.SmPolicyDecision{SessRules: map[string]*models.SessionRule{"s": {
+				AuthSessAmbr: &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+			}}},
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "invalid decision",
+			activated:  true,
+			update:     models.SmPolicyDecision{PccRules: map[string]*models.PccRule{"video": videoRule}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, nil)
+			h.nfs.mu.Lock()
+			h.nfs.decision = tt.decision
+			h.nfs.mu.Unlock()
+			c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+			if tt.activated {
+				h.activate(t, c.Ref, gnbTunnel)
+			}
+			h.upfs[0].takeRequests()
+
+			err := h.client.Post(context.Background(), h.url+smPolicyCallbackPrefix+"/"+c.Ref+"/update",
+				models.SmPolicyNotification{SmPolicyDecision: &tt.update}, nil)
+			if tt.wantStatus == http.StatusNoContent && err != nil || tt.wantStatus != http.StatusNoContent && statusCode(err) != tt.wantStatus {
+				t.Fatalf("notification error = %v, want status %d", err, tt.wantStatus)
+			}
+
+			requests := h.upfs[0].takeRequests()
+			if tt.wantN4 == nil {
+				if len(requests) != 0 {
+					t.Errorf("N4 requests = %d, want none", len(requests))
+				}
+				select {
+				case tr := <-h.nfs.transfers:
+					t.Errorf("N1N2 message transfer of %T", tr.n1)
+				default:
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("N4 requests = %d, want 1", len(requests))
+			}
+			req, ok := requests[0].(*pfcp.SessionModificationRequest)
+			if !ok {
+				t.Fatalf("N4 request = %T, want modification", requests[0])
+			}
+			if got := modificationChanges(req); !reflect.DeepEqual(got, *tt.wantN4) {
+				t.Errorf("N4 changes = %+v, want %+v", got, *tt.wantN4)
+			}
+
+			tr := h.nfs.recvTransfer(t)
+			cmd, ok := tr.n1.(*nas.PDUSessionModificationCommand)
+			if !ok {
+				t.Fatalf("N1 message = %T, want modification command", tr.n1)
+			}
+			var rules, flows []qosOp
+			if cmd.AuthorizedQoSRules != nil {
+				decoded, err := nas.DecodeQoSRules(cmd.AuthorizedQoSRules)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range decoded {
+					rules = append(rules, qosOp{r.ID, uint8(r.Operation)})
+				}
+			}
+			if cmd.AuthorizedQoSFlowDescriptions != nil {
+				decoded, err := nas.DecodeQoSFlowDescriptions(cmd.AuthorizedQoSFlowDescriptions)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range decoded {
+					flows = append(flows, qosOp{f.QFI, uint8(f.Operation)})
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) || !reflect.DeepEqual(flows, tt.wantFlows) {
+				t.Errorf("QoS rules %v and flows %v, want %v and %v", rules, flows, tt.wantRules, tt.wantFlows)
+			}
+			if !reflect.DeepEqual(cmd.SessionAMBR, tt.wantAMBR) {
+				t.Errorf("session AMBR = %+v, want %+v", cmd.SessionAMBR, tt.wantAMBR)
+			}
+
+			if tt.wantN2 == nil {
+				if tr.n2Type != "" {
+					t.Errorf("N2 SM information %q, want none", tr.n2Type)
+				}
+				return
+			}
+			if tr.n2Type != models.N2SmInfoPduResModReq {
+				t.Fatalf("N2 SM information = %q, want %q", tr.n2Type, models.N2SmInfoPduResModReq)
+			}
+			n2, err := ngap.DecodeModifyRequestTransfer(tr.n2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(n2, tt.wantN2) {
+				t.Errorf("N2 transfer = %+v, want %+v", n2, tt.wantN2)
+			}
+		})
+	}
+}
+
+func TestPolicyUpdateResources(t *testing.T) {
+	h := newHarness(t, nil)
+	c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+	update := models.SmPolicyNotification{SmPolicyDecision: &models.SmPolicyDecision{}}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{name: "unknown context", path: "/unknown/update", body: update, wantStatus: http.StatusNotFound},
+		{name: "unknown action", path: "/" + c.Ref + "/terminate", body: update, wantStatus: http.StatusNotFound},
+		{name: "no decision", path: "/" + c.Ref + "/update", body: models.SmPolicyNotification{}, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := h.client.Post(context.Background(), h.url+smPolicyCallbackPrefix+tt.path, tt.body, nil)
+			if statusCode(err) != tt.wantStatus {
+				t.Errorf("error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
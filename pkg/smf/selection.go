@@ -0,0 +1,454 @@
+package smf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"go.uber.org/zap"
+)
+
+// UPF selection modes
+const (
+	// UPFSelectionProximity prefers the UPFs of the UE locality
+	UPFSelectionProximity = "proximity"
+
+	// UPFSelectionLoad prefers the UPFs with the most spare capacity
+	UPFSelectionLoad = "load"
+
+	// UPFSelectionPerformance prefers the UPFs answering the fastest
+	UPFSelectionPerformance = "performance"
+)
+
+const (
+	// pfcpPort is the PFCP port of the UPFs discovered in the NRF (TS
+	// 29.244 4.2.2)
+	pfcpPort = "8805"
+
+	// discoveryValidity is how long UPFs discovered in the NRF are used
+	// when the NRF sets no validity period
+	discoveryValidity = time.Minute
+
+	// discoveryRetry is how long after a failed discovery the NRF is
+	// asked again, the UPFs already known being used meanwhile
+	discoveryRetry = 10 * time.Second
+)
+
+// upfPath is the UPFs of a PDU session: the PSA anchoring it and, when
+// the PSA is away from the UE, the I-UPF in front of it
+type upfPath struct {
+	PSA  *UPF
+	IUPF *UPF
+}
+
+// upfSelector chooses the UPFs of the PDU sessions among the configured
+// ones and the ones registered in the NRF. The round trip time of each
+// UPF is measured by PFCP heartbeats for the performance mode, a UPF
+// missing its heartbeat being chosen last.
+type upfSelector struct {
+	mode       string
+	locality   string
+	tacs       map[string]string
+	configured []*UPF
+	nrf        NRF // nil when UPFs are not discovered
+	n4         N4
+	log        *zap.Logger
+
+	mu         sync.Mutex
+	discovered map[string]*UPF // by NF instance ID
+	refreshAt  time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newUPFSelector creates the selector of the configured UPFs and starts
+// the heartbeats
+func newUPFSelector(cfg *Config, nfs NFs, log *zap.Logger) *upfSelector {
+	s := &upfSelector{
+		mode:       cfg.UPFSelectionMode,
+		locality:   cfg.Locality,
+		tacs:       cfg.TACLocalities,
+		configured: cfg.UPFs,
+		n4:         nfs.N4,
+		log:        log,
+		discovered: make(map[string]*UPF),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if cfg.DiscoverUPFs {
+		s.nrf = nfs.NRF
+	}
+
+	if s.n4 == nil || cfg.UPFHeartbeatInterval <= 0 {
+		close(s.done)
+		return s
+	}
+	go s.heartbeats(cfg.UPFHeartbeatInterval)
+	return s
+}
+
+// close stops the heartbeats
+func (s *upfSelector) close() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+// paths returns the UPF paths a PDU session may be set up in, best
+// first. Each PSA serving the DNN and slice comes with the I-UPFs of
+// the UE locality when it is in another one, then alone.
+func (s *upfSelector) paths(ctx context.Context, dnn string, snssai models.Snssai, tai *models.Tai) []upfPath {
+	upfs := s.upfs(ctx)
+	local := s.localityOf(tai)
+
+	var psas, iupfs []*UPF
+	for _, u := range upfs {
+		if u.ServesDNN(dnn) && u.ServesSlice(snssai) {
+			psas = append(psas, u)
+		}
+		if local != "" && u.Locality == local && u.N9Address != nil && u.ServesSlice(snssai) {
+			iupfs = append(iupfs, u)
+		}
+	}
+	s.rank(psas, local)
+	s.rank(iupfs, local)
+
+	var paths []upfPath
+	for _, psa := range psas {
+		if local != "" && psa.Locality != local && psa.N9Address != nil {
+			for _, i := range iupfs {
+				if i != psa {
+					paths = append(paths, upfPath{PSA: psa, IUPF: i})
+				}
+			}
+		}
+		paths = append(paths, upfPath{PSA: psa})
+	}
+	return paths
+}
+
+// closerAnchor reports whether a UPF of the locality of a UE can anchor
+// its PDU session in place of an anchor of another locality
+func (s *upfSelector) closerAnchor(ctx context.Context, anchor *UPF, dnn string, snssai models.Snssai, tai *models.Tai) bool {
+	local := s.localityOf(tai)
+	if local == "" || anchor.Locality == local {
+		return false
+	}
+	for _, u := range s.upfs(ctx) {
+		if u == anchor || u.Locality != local || !u.ServesDNN(dnn) || !u.ServesSlice(snssai) {
+			continue
+		}
+		u.mu.Lock()
+		down := u.down
+		u.mu.Unlock()
+		if !down {
+			return true
+		}
+	}
+	return false
+}
+
+// localityOf returns the locality of a UE in a tracking area
+func (s *upfSelector) localityOf(tai *models.Tai) string {
+	if l, ok := s.tacs[taiTAC(tai)]; ok {
+		return l
+	}
+	return s.locality
+}
+
+// upfStats is a snapshot of the figures UPFs are ranked by
+type upfStats struct {
+	local    bool
+	down     bool
+	headroom int // spare capacity, the free share of the capacity
+	rtt      time.Duration
+	sessions int
+}
+
+// rank sorts UPFs best first for the selection mode. UPFs missing their
+// heartbeat come last whatever the mode.
+func (s *upfSelector) rank(upfs []*UPF, local string) {
+	stats := make(map[*UPF]upfStats, len(upfs))
+	for _, u := range upfs {
+		u.mu.Lock()
+		stats[u] = upfStats{
+			local:    local == "" || u.Locality == local,
+			down:     u.down,
+			headroom: (100 - u.load) * u.capacity,
+			rtt:      u.rtt,
+			sessions: u.sessions,
+		}
+		u.mu.Unlock()
+	}
+
+	sort.SliceStable(upfs, func(i, j int) bool {
+		a, b := stats[upfs[i]], stats[upfs[j]]
+		if a.down != b.down {
+			return b.down
+		}
+		switch s.mode {
+		case UPFSelectionLoad:
+			if a.headroom != b.headroom {
+				return a.headroom > b.headroom
+			}
+		case UPFSelectionPerformance:
+			// UPFs not measured yet come after the measured ones
+			if (a.rtt == 0) != (b.rtt == 0) {
+				return b.rtt == 0
+			}
+			if a.rtt != b.rtt {
+				return a.rtt < b.rtt
+			}
+		default:
+			if a.local != b.local {
+				return a.local
+			}
+			if a.headroom != b.headroom {
+				return a.headroom > b.headroom
+			}
+		}
+		return a.sessions < b.sessions
+	})
+}
+
+// upfs returns the configured UPFs and the ones discovered in the NRF,
+// asking the NRF again once the last answer expired
+func (s *upfSelector) upfs(ctx context.Context) []*UPF {
+	upfs := append([]*UPF(nil), s.configured...)
+	if s.nrf == nil {
+		return upfs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); !now.Before(s.refreshAt) {
+		if err := s.discover(ctx, now); err != nil {
+			s.log.Warn("Failed to discover UPFs", zap.Error(err))
+			s.refreshAt = now.Add(discoveryRetry)
+		}
+	}
+
+	configured := make(map[string]bool, len(s.configured))
+	for _, u := range s.configured {
+		configured[u.NodeID] = true
+	}
+	ids := make([]string, 0, len(s.discovered))
+	for id, u := range s.discovered {
+		if !configured[u.NodeID] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		upfs = append(upfs, s.discovered[id])
+	}
+	return upfs
+}
+
+// discover replaces the discovered UPFs with the ones registered in the
+// NRF. UPFs whose addresses did not change are kept with their state.
+// It runs holding s.mu.
+func (s *upfSelector) discover(ctx context.Context, now time.Time) error {
+	rsp, err := s.nrf.SearchNFInstances(ctx, models.NfTypeUPF, models.NfTypeSMF)
+	if err != nil {
+		return err
+	}
+
+	discovered := make(map[string]*UPF, len(rsp.NfInstances))
+	for _, p := range rsp.NfInstances {
+		u, err := upfFromProfile(p)
+		if err != nil {
+			s.log.Warn("Ignoring UPF profile", zap.String("nf_instance_id", p.NfInstanceID), zap.Error(err))
+			continue
+		}
+		if old := s.discovered[p.NfInstanceID]; old != nil && old.sameEndpoints(u) {
+			u = old
+		}
+		u.mu.Lock()
+		u.load, u.capacity = p.Load, p.Capacity
+		if u.capacity == 0 {
+			u.capacity = 100
+		}
+		u.mu.Unlock()
+		discovered[p.NfInstanceID] = u
+	}
+	s.discovered = discovered
+
+	validity := time.Duration(rsp.ValidityPeriod) * time.Second
+	if validity <= 0 {
+		validity = discoveryValidity
+	}
+	s.refreshAt = now.Add(validity)
+	s.log.Debug("UPFs discovered", zap.Int("count", len(discovered)))
+	return nil
+}
+
+// upfFromProfile returns the UPF registered with an NF profile. Its N4
+// address is the first address of the profile, its N3 and N9 addresses
+// the ones of its interfaces, the N3 one defaulting to the N4 one.
+func upfFromProfile(p models.NfProfile) (*UPF, error) {
+	if p.NfStatus != "" && p.NfStatus != models.NfStatusRegistered {
+		return nil, fmt.Errorf("status %s", p.NfStatus)
+	}
+	host := p.FQDN
+	if len(p.IPv4Addresses) > 0 {
+		host = p.IPv4Addresses[0]
+	}
+	if host == "" {
+		return nil, fmt.Errorf("no address")
+	}
+
+	u := &UPF{
+		NodeID:     host,
+		InstanceID: p.NfInstanceID,
+		N4Address:  net.JoinHostPort(host, pfcpPort),
+		N3Address:  net.ParseIP(host),
+		Locality:   p.Locality,
+		capacity:   100,
+	}
+	if info := p.UpfInfo; info != nil {
+		for _, item := range info.SNssaiUpfInfoList {
+			u.SNSSAIs = append(u.SNSSAIs, item.SNssai)
+			for _, d := range item.DnnUpfInfoList {
+				if !contains(u.DNNs, d.Dnn) {
+					u.DNNs = append(u.DNNs, d.Dnn)
+				}
+			}
+		}
+		for _, i := range info.InterfaceUpfInfoList {
+			if len(i.Ipv4EndpointAddresses) == 0 {
+				continue
+			}
+			switch ip := net.ParseIP(i.Ipv4EndpointAddresses[0]); i.InterfaceType {
+			case models.UPInterfaceN3:
+				u.N3Address = ip
+			case models.UPInterfaceN9:
+				u.N9Address = ip
+			}
+		}
+	}
+	if u.N3Address == nil {
+		return nil, fmt.Errorf("no N3 address")
+	}
+	return u, nil
+}
+
+// sameEndpoints reports whether two UPFs are reached at the same
+// addresses
+func (u *UPF) sameEndpoints(v *UPF) bool {
+	return u.N4Address == v.N4Address && u.N3Address.Equal(v.N3Address) && u.N9Address.Equal(v.N9Address)
+}
+
+// heartbeats measures the round trip time of the UPFs every interval
+// until the selector is closed
+func (s *upfSelector) heartbeats(interval time.Duration) {
+	defer close(s.done)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		s.heartbeat(interval)
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// known returns the configured UPFs and the ones last discovered,
+// without asking the NRF
+func (s *upfSelector) known() []*UPF {
+	upfs := append([]*UPF(nil), s.configured...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.discovered {
+		upfs = append(upfs, u)
+	}
+	return upfs
+}
+
+// check implements health.CheckFunc, failing when no UPF answered its
+// last heartbeat
+func (s *upfSelector) check(ctx context.Context) error {
+	upfs := s.upfs(ctx)
+	if len(upfs) == 0 {
+		return fmt.Errorf("no UPF known")
+	}
+	for _, u := range upfs {
+		u.mu.Lock()
+		down := u.down
+		u.mu.Unlock()
+		if !down {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of the %d UPFs answers its heartbeats", len(upfs))
+}
+
+// heartbeat sends a heartbeat to every known UPF at once. RTTs are
+// smoothed as TCP does (RFC 6298), a lost heartbeat marking the UPF
+// down until the next answer.
+func (s *upfSelector) heartbeat(timeout time.Duration) {
+	upfs := s.known()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, u := range upfs {
+		wg.Add(1)
+		go func(u *UPF) {
+			defer wg.Done()
+			start := time.Now()
+			err := s.n4.Heartbeat(ctx, u)
+			rtt := time.Since(start)
+
+			u.mu.Lock()
+			defer u.mu.Unlock()
+			if err != nil {
+				if !u.down {
+					s.log.Warn("UPF missed its heartbeat", zap.String("upf", u.NodeID), zap.Error(err))
+				}
+				u.down = true
+				return
+			}
+			if u.down {
+				s.log.Info("UPF answers again", zap.String("upf", u.NodeID))
+			}
+			u.down = false
+			if u.rtt == 0 {
+				u.rtt = rtt
+			} else {
+				u.rtt = (7*u.rtt + rtt) / 8
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// taiTAC returns the TAC of a TAI, empty when unknown
+func taiTAC(tai *models.Tai) string {
+	if tai == nil {
+		return ""
+	}
+	return tai.Tac
+}
+
+// contains reports whether a list holds a string
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
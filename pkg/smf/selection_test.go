@@ -0,0 +1,583 @@
+package smf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// fakeNRF answers the discoveries of UPFs
+type fakeNRF struct {
+	mu       sync.Mutex
+	rsp      models.NfDiscoveryResponse
+	err      error
+	searches int
+}
+
+func (f *fakeNRF) SearchNFInstances(ctx context.Context, target, requester models.NfType) (*models.NfDiscoveryResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.searches++
+	if target != models.NfTypeUPF || requester != models.NfTypeSMF {
+		return nil, errors.New("unexpected NF types")
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	rsp := f.rsp
+	return &rsp, nil
+}
+
+// newTestSelector creates a selector of the UPFs in a mode, the UEs of
+// TAC 000001 being in paris and those of 000002 in lyon
+func newTestSelector(mode string, nrf NRF, upfs ...*UPF) *upfSelector {
+	cfg := &Config{
+		Locality:         "paris",
+		UPFSelectionMode: mode,
+		TACLocalities:    map[string]string{"000001": "paris", "000002": "lyon"},
+		DiscoverUPFs:     nrf != nil,
+		UPFs:             upfs,
+	}
+	return newUPFSelector(cfg, NFs{NRF: nrf}, zap.NewNop())
+}
+
+// nodeIDs returns the node IDs of UPFs
+func nodeIDs(upfs []*UPF) string {
+	ids := make([]string, len(upfs))
+	for i, u := range upfs {
+		ids[i] = u.NodeID
+	}
+	return strings.Join(ids, " ")
+}
+
+// tai returns a TAI of the test PLMN
+func tai(tac string) *models.Tai {
+	return &models.Tai{PlmnID: testPLMN, Tac: tac}
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		upfs []*UPF
+		want string
+	}{
+		{
+			name: "proximity prefers local UPFs",
+			mode: UPFSelectionProximity,
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", capacity: 100},
+				{NodeID: "paris-busy", Locality: "paris", capacity: 100, load: 50},
+				{NodeID: "paris", Locality: "paris", capacity: 100, load: 10},
+			},
+			want: "paris paris-busy lyon",
+		},
+		{
+			name: "proximity then fewest sessions",
+			mode: UPFSelectionProximity,
+			upfs: []*UPF{
+				{NodeID: "a", Locality: "paris", capacity: 100, sessions: 3},
+				{NodeID: "b", Locality: "paris", capacity: 100, sessions: 1},
+			},
+			want: "b a",
+		},
+		{
+			name: "load prefers spare capacity",
+			mode: UPFSelectionLoad,
+			upfs: []*UPF{
+				{NodeID: "small", Locality: "paris", capacity: 50, load: 10},
+				{NodeID: "busy", Locality: "paris", capacity: 100, load: 50},
+				{NodeID: "remote", Locality: "lyon", capacity: 100, load: 10},
+			},
+			want: "remote busy small",
+		},
+		{
+			name: "performance prefers measured fast UPFs",
+			mode: UPFSelectionPerformance,
+			upfs: []*UPF{
+				{NodeID: "unmeasured", Locality: "paris", capacity: 100},
+				{NodeID: "slow", Locality: "paris", capacity: 100, rtt: 5 * time.Millisecond},
+				{NodeID: "fast", Locality: "lyon", capacity: 100, rtt: 2 * time.Millisecond},
+			},
+			want: "fast slow unmeasured",
+		},
+		{
+			name: "down UPFs last",
+			mode: UPFSelectionProximity,
+			upfs: []*UPF{
+				{NodeID: "paris", Locality: "paris", capacity: 100, down: true},
+				{NodeID: "lyon", Locality: "lyon", capacity: 100},
+			},
+			want: "lyon paris",
+		},
+		{
+			name: "down UPFs last in performance mode",
+			mode: UPFSelectionPerformance,
+			upfs: []*UPF{
+				{NodeID: "fast", Locality: "paris", capacity: 100, rtt: time.Millisecond, down: true},
+				{NodeID: "slow", Locality: "paris", capacity: 100, rtt: time.Second},
+			},
+			want: "slow fast",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSelector(tt.mode, nil, tt.upfs...)
+			s.rank(tt.upfs, "paris")
+			if got := nodeIDs(tt.upfs); got != tt.want {
+				t.Errorf("rank = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaths(t *testing.T) {
+	otherSlice := models.Snssai{Sst: 2}
+	tests := []struct {
+		name string
+		upfs []*UPF
+		tac  string
+		want []string // PSA, or PSA via I-UPF
+	}{
+		{
+			name: "local PSA",
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", capacity: 100},
+				{NodeID: "paris", Locality: "paris", capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"paris", "lyon"},
+		},
+		{
+			name: "locality of the TAC",
+			upfs: []*UPF{
+				{NodeID: "paris", Locality: "paris", capacity: 100},
+				{NodeID: "lyon", Locality: "lyon", capacity: 100},
+			},
+			tac:  "000002",
+			want: []string{"lyon", "paris"},
+		},
+		{
+			name: "locality of the SMF for unknown TACs",
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", capacity: 100},
+				{NodeID: "paris", Locality: "paris", capacity: 100},
+			},
+			tac:  "0000ff",
+			want: []string{"paris", "lyon"},
+		},
+		{
+			name: "DNN constraint",
+			upfs: []*UPF{
+				{NodeID: "paris", Locality: "paris", DNNs: []string{"ims"}, capacity: 100},
+				{NodeID: "lyon", Locality: "lyon", DNNs: []string{"ims", "internet"}, capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"lyon"},
+		},
+		{
+			name: "slice constraint",
+			upfs: []*UPF{
+				{NodeID: "paris", Locality: "paris", SNSSAIs: []models.Snssai{otherSlice}, capacity: 100},
+				{NodeID: "lyon", Locality: "lyon", SNSSAIs: []models.Snssai{testSNSSAI}, capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"lyon"},
+		},
+		{
+			name: "I-UPF in front of a remote PSA",
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", DNNs: []string{"internet"}, N9Address: net.IPv4(10, 102, 0, 1), capacity: 100},
+				{NodeID: "paris", Locality: "paris", DNNs: []string{"ims"}, N9Address: net.IPv4(10, 102, 0, 2), capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"lyon via paris", "lyon"},
+		},
+		{
+			name: "I-UPFs of the slice only",
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", N9Address: net.IPv4(10, 102, 0, 1), capacity: 100},
+				{NodeID: "paris", Locality: "paris", DNNs: []string{"ims"}, SNSSAIs: []models.Snssai{otherSlice},
+					N9Address: net.IPv4(10, 102, 0, 2), capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"lyon"},
+		},
+		{
+			name: "no chaining without N9",
+			upfs: []*UPF{
+				{NodeID: "lyon", Locality: "lyon", DNNs: []string{"internet"}, capacity: 100},
+				{NodeID: "paris", Locality: "paris", DNNs: []string{"ims"}, N9Address: net.IPv4(10, 102, 0, 2), capacity: 100},
+			},
+			tac:  "000001",
+			want: []string{"lyon"},
+		},
+		{
+			name: "no UPF serving the DNN",
+			upfs: []*UPF{{NodeID: "paris", Locality: "paris", DNNs: []string{"ims"}, capacity: 100}},
+			tac:  "000001",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSelector(UPFSelectionProximity, nil, tt.upfs...)
+			var got []string
+			for _, p := range s.paths(context.Background(), "internet", testSNSSAI, tai(tt.tac)) {
+				if p.IUPF != nil {
+					got = append(got, p.PSA.NodeID+" via "+p.IUPF.NodeID)
+				} else {
+					got = append(got, p.PSA.NodeID)
+				}
+			}
+			if strings.Join(got, ", ") != strings.Join(tt.want, ", ") {
+				t.Errorf("paths = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloserAnchor(t *testing.T) {
+	lyon := &UPF{NodeID: "lyon", Locality: "lyon", capacity: 100}
+	paris := &UPF{NodeID: "paris", Locality: "paris", capacity: 100}
+	parisIMS := &UPF{NodeID: "paris-ims", Locality: "paris", DNNs: []string{"ims"}, capacity: 100}
+	parisDown := &UPF{NodeID: "paris-down", Locality: "paris", capacity: 100, down: true}
+
+	tests := []struct {
+		name   string
+		upfs   []*UPF
+		anchor *UPF
+		tac    string
+		want   bool
+	}{
+		{"anchor of the UE locality", []*UPF{lyon, paris}, paris, "000001", false},
+		{"UPF of the UE locality", []*UPF{lyon, paris}, lyon, "000001", true},
+		{"UPF of the UE locality for another DNN", []*UPF{lyon, parisIMS}, lyon, "000001", false},
+		{"UPF of the UE locality down", []*UPF{lyon, parisDown}, lyon, "000001", false},
+		{"no UPF in the UE locality", []*UPF{lyon}, lyon, "000001", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSelector(UPFSelectionProximity, nil, tt.upfs...)
+			if got := s.closerAnchor(context.Background(), tt.anchor, "internet", testSNSSAI, tai(tt.tac)); got != tt.want {
+				t.Errorf("closerAnchor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUPFFromProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile models.NfProfile
+		want    *UPF // nil when the profile is ignored
+	}{
+		{
+			name: "N4 address only",
+			profile: models.NfProfile{
+				NfInstanceID:  "upf-1",
+				NfStatus:      models.NfStatusRegistered,
+				IPv4Addresses: []string{"10.100.0.2"},
+				Locality:      "lyon",
+			},
+			want: &UPF{NodeID: "10.100.0.2", InstanceID: "upf-1", N4Address: "10.100.0.2:8805",
+				N3Address: net.ParseIP("10.100.0.2"), Locality: "lyon"},
+		},
+		{
+			name: "interfaces, DNNs and slices",
+			profile: models.NfProfile{
+				NfInstanceID:  "upf-2",
+				IPv4Addresses: []string{"10.100.0.3"},
+				UpfInfo: &models.UpfInfo{
+					SNssaiUpfInfoList: []models.SnssaiUpfInfoItem{
+						{SNssai: testSNSSAI, DnnUpfInfoList: []models.DnnUpfInfoItem{{Dnn: "internet"}, {Dnn: "ims"}}},
+						{SNssai: models.Snssai{Sst: 2}, DnnUpfInfoList: []models.DnnUpfInfoItem{{Dnn: "internet"}}},
+					},
+					InterfaceUpfInfoList: []models.InterfaceUpfInfoItem{
+						{InterfaceType: models.UPInterfaceN3, Ipv4EndpointAddresses: []string{"10.101.0.3"}},
+						{InterfaceType: models.UPInterfaceN9, Ipv4EndpointAddresses: []string{"10.102.0.3"}},
+					},
+				},
+			},
+			want: &UPF{NodeID: "10.100.0.3", InstanceID: "upf-2", N4Address: "10.100.0.3:8805",
+				N3Address: net.ParseIP("10.101.0.3"), N9Address: net.ParseIP("10.102.0.3"),
+				DNNs: []string{"internet", "ims"}, SNSSAIs: []models.Snssai{testSNSSAI, {Sst: 2}}},
+		},
+		{
+			name: "FQDN with N3 interface",
+			profile: models.NfProfile{
+				NfInstanceID: "upf-3",
+				FQDN:         "upf.example.org",
+				UpfInfo: &models.UpfInfo{InterfaceUpfInfoList: []models.InterfaceUpfInfoItem{
+					{InterfaceType: models.UPInterfaceN3, Ipv4EndpointAddresses: []string{"10.101.0.4"}},
+				}},
+			},
+			want: &UPF{NodeID: "upf.example.org", InstanceID: "upf-3", N4Address: "upf.example.org:8805",
+				N3Address: net.ParseIP("10.101.0.4")},
+		},
+		{
+			name:    "FQDN without N3 interface",
+			profile: models.NfProfile{NfInstanceID: "upf-4", FQDN: "upf.example.org"},
+		},
+		{
+			name:    "no address",
+			profile: models.NfProfile{NfInstanceID: "upf-5"},
+		},
+		{
+			name:    "suspended",
+			profile: models.NfProfile{NfInstanceID: "upf-6", NfStatus: models.NfStatusSuspended, IPv4Addresses: []string{"10.100.0.6"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := upfFromProfile(tt.profile)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("upfFromProfile = %+v, want error", u)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.NodeID != tt.want.NodeID || u.InstanceID != tt.want.InstanceID || u.N4Address != tt.want.N4Address ||
+				!u.sameEndpoints(tt.want) || u.Locality != tt.want.Locality || u.capacity != 100 ||
+				strings.Join(u.DNNs, " ") != strings.Join(tt.want.DNNs, " ") || len(u.SNSSAIs) != len(tt.want.SNSSAIs) {
+				t.Errorf("upfFromProfile = %+v, want %+v", u, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	configured := &UPF{NodeID: "10.100.0.1", N4Address: "10.100.0.1:8805", N3Address: net.IPv4(10, 100, 0, 1), capacity: 100}
+	nrf := &fakeNRF{rsp: models.NfDiscoveryResponse{
+		ValidityPeriod: 3600,
+		NfInstances: []models.NfProfile{
+			{NfInstanceID: "upf-configured", IPv4Addresses: []string{"10.100.0.1"}},
+			{NfInstanceID: "upf-lyon", IPv4Addresses: []string{"10.100.0.2"}, Locality: "lyon", Load: 30, Capacity: 50},
+			{NfInstanceID: "upf-nice", IPv4Addresses: []string{"10.100.0.3"}, Locality: "nice"},
+			{NfInstanceID: "upf-broken"},
+		},
+	}}
+	s := newTestSelector(UPFSelectionLoad, nrf, configured)
+	ctx := context.Background()
+
+	upfs := s.upfs(ctx)
+	if got := nodeIDs(upfs); got != "10.100.0.1 10.100.0.2 10.100.0.3" {
+		t.Fatalf("UPFs = %s, want the configured one then the discovered ones", got)
+	}
+	if upfs[0] != configured {
+		t.Error("configured UPF replaced by its profile")
+	}
+	lyon := upfs[1]
+	if lyon.Locality != "lyon" || lyon.load != 30 || lyon.capacity != 50 {
+		t.Errorf("discovered UPF = %+v", lyon)
+	}
+	if nice := upfs[2]; nice.capacity != 100 {
+		t.Errorf("capacity of a UPF advertising none = %d, want 100", nice.capacity)
+	}
+
+	// The NRF is asked again once the discovery expires only
+	s.upfs(ctx)
+	if nrf.searches != 1 {
+		t.Errorf("%d searches within the validity period, want 1", nrf.searches)
+	}
+
+	// UPFs keeping their endpoints keep their state
+	lyon.addSessions(1)
+	nrf.rsp.NfInstances[1].Load = 60
+	s.refreshAt = time.Time{}
+	upfs = s.upfs(ctx)
+	if upfs[1] != lyon || lyon.sessions != 1 || lyon.load != 60 {
+		t.Errorf("rediscovered UPF = %+v, want the known one with the new load", upfs[1])
+	}
+
+	// Known UPFs are used while the NRF fails
+	nrf.err = errors.New("NRF unreachable")
+	s.refreshAt = time.Time{}
+	before := time.Now()
+	if got := nodeIDs(s.upfs(ctx)); got != "10.100.0.1 10.100.0.2 10.100.0.3" {
+		t.Errorf("UPFs while the NRF fails = %s", got)
+	}
+	if s.refreshAt.Before(before.Add(discoveryRetry)) {
+		t.Errorf("next discovery at %v, want after the retry delay", s.refreshAt)
+	}
+	if nrf.searches != 3 {
+		t.Errorf("%d searches, want 3", nrf.searches)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	alive := newFakeUPF(t, "upf-alive", "paris", "10.100.0.1", "")
+	dead := newFakeUPF(t, "upf-dead", "paris", "10.100.0.2", "")
+	dead.node.Close()
+
+	n4, err := NewN4Client(&Config{
+		PFCPAddress: "127.0.0.1:0",
+		PFCPNodeID:  net.IPv4(127, 0, 0, 1),
+		PFCPT1:      50 * time.Millisecond,
+		PFCPN1:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n4.Close()
+
+	cfg := &Config{Locality: "paris", UPFSelectionMode: UPFSelectionPerformance, UPFs: []*UPF{dead.upf, alive.upf}}
+	s := newUPFSelector(cfg, NFs{N4: n4}, zap.NewNop())
+	ctx := context.Background()
+
+	s.heartbeat(time.Second)
+	if alive.upf.down || alive.upf.rtt <= 0 {
+		t.Errorf("answering UPF: down %v, RTT %v", alive.upf.down, alive.upf.rtt)
+	}
+	if !dead.upf.down {
+		t.Error("silent UPF not down")
+	}
+	if err := s.check(ctx); err != nil {
+		t.Errorf("check with an answering UPF: %v", err)
+	}
+	paths := s.paths(ctx, "internet", testSNSSAI, nil)
+	if len(paths) != 2 || paths[0].PSA != alive.upf {
+		t.Errorf("first path = %+v, want the answering UPF", paths[0])
+	}
+
+	alive.node.Close()
+	s.heartbeat(time.Second)
+	if !alive.upf.down {
+		t.Error("UPF that stopped answering not down")
+	}
+	if err := s.check(ctx); err == nil {
+		t.Error("check passed with no UPF answering")
+	}
+}
+
+func TestUPFFallback(t *testing.T) {
+	first := newFakeUPF(t, "upf-a", "paris", "10.100.0.1", "")
+	second := newFakeUPF(t, "upf-b", "paris", "10.100.0.2", "")
+	first.setReject(true)
+	h := newHarness(t, nil, first, second)
+
+	c, _, n2 := h.establish(t, "internet", establishmentRequest(1))
+	if c.UPF != second.upf || c.PSA != nil {
+		t.Errorf("session set up in %s, want upf-b", c.UPF.NodeID)
+	}
+	if !n2.ULTunnel.Address.Equal(second.upf.N3Address) {
+		t.Errorf("UL tunnel = %+v, want upf-b", n2.ULTunnel)
+	}
+	if n := len(first.takeRequests()); n != 1 {
+		t.Errorf("upf-a got %d requests, want the rejected establishment", n)
+	}
+	if n := len(second.takeRequests()); n != 1 {
+		t.Errorf("upf-b got %d requests, want the establishment", n)
+	}
+	if first.upf.sessions != 0 || second.upf.sessions != 1 {
+		t.Errorf("sessions: upf-a %d, upf-b %d", first.upf.sessions, second.upf.sessions)
+	}
+}
+
+func TestUPFChaining(t *testing.T) {
+	tests := []struct {
+		name       string
+		rejectIUPF bool
+	}{
+		{name: "I-UPF accepts"},
+		{name: "I-UPF rejects", rejectIUPF: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The PSA serving the DNN is in lyon, the UE in paris
+			psa := newFakeUPF(t, "upf-lyon", "lyon", "10.100.0.1", "10.102.0.1")
+			psa.upf.DNNs = []string{"internet"}
+			iupf := newFakeUPF(t, "upf-paris", "paris", "10.100.0.2", "10.102.0.2")
+			iupf.upf.DNNs = []string{"ims"}
+			iupf.setReject(tt.rejectIUPF)
+			h := newHarness(t, nil, psa, iupf)
+
+			c, _, n2 := h.establish(t, "internet", establishmentRequest(1))
+			psaRequests, iupfRequests := psa.takeRequests(), iupf.takeRequests()
+			if len(iupfRequests) != 1 {
+				t.Fatalf("I-UPF got %d requests, want the establishment", len(iupfRequests))
+			}
+
+			if tt.rejectIUPF {
+				// The PSA session is removed and set up again alone
+				var types []string
+				for _, r := range psaRequests {
+					types = append(types, r.MessageType().String())
+				}
+				want := "SessionEstablishmentRequest SessionDeletionRequest SessionEstablishmentRequest"
+				if strings.Join(types, " ") != want {
+					t.Errorf("PSA got %s, want %s", strings.Join(types, " "), want)
+				}
+				if c.UPF != psa.upf || c.PSA != nil || c.PSAN4 != nil {
+					t.Errorf("session set up in %s behind %v, want the PSA alone", c.UPF.NodeID, c.PSA)
+				}
+				if !n2.ULTunnel.Address.Equal(psa.upf.N3Address) {
+					t.Errorf("UL tunnel = %+v, want the N3 address of the PSA", n2.ULTunnel)
+				}
+				if psa.upf.sessions != 1 || iupf.upf.sessions != 0 {
+					t.Errorf("sessions: PSA %d, I-UPF %d", psa.upf.sessions, iupf.upf.sessions)
+				}
+				return
+			}
+
+			if c.UPF != iupf.upf || c.PSA != psa.upf {
+				t.Fatalf("session set up in %s behind %v, want the I-UPF in front of the PSA", c.UPF.NodeID, c.PSA)
+			}
+			if !n2.ULTunnel.Address.Equal(iupf.upf.N3Address) {
+				t.Errorf("UL tunnel = %+v, want the N3 address of the I-UPF", n2.ULTunnel)
+			}
+			if len(psaRequests) != 1 {
+				t.Fatalf("PSA got %d requests, want the establishment", len(psaRequests))
+			}
+			psaEst := psaRequests[0].(*pfcp.SessionEstablishmentRequest)
+			iupfEst := iupfRequests[0].(*pfcp.SessionEstablishmentRequest)
+
+			// The PSA receives uplink packets on N9 and sends downlink ones
+			// to the N9 tunnel of the I-UPF
+			n9 := c.PSAN4.ULTunnel
+			if ul := psaEst.CreatePDRs[0].PDI.FTEID; ul == nil || !ul.IPv4.Equal(psa.upf.N9Address) || ul.TEID != n9.TEID {
+				t.Errorf("uplink F-TEID of the PSA = %+v, want its N9 address", ul)
+			}
+			dl := psaEst.CreateFARs[1]
+			if dl.ApplyAction != pfcp.ApplyActionForward || dl.ForwardingParameters == nil ||
+				!dl.ForwardingParameters.OuterHeaderCreation.IPv4.Equal(iupf.upf.N9Address) {
+				t.Errorf("downlink FAR of the PSA = %+v, want forwarding to the I-UPF", dl)
+			}
+
+			// The I-UPF sends uplink packets to the PSA and receives
+			// downlink ones on its N9 tunnel
+			ul := iupfEst.CreateFARs[0].ForwardingParameters
+			if ul.OuterHeaderCreation == nil || ul.OuterHeaderCreation.TEID != n9.TEID || !ul.OuterHeaderCreation.IPv4.Equal(psa.upf.N9Address) {
+				t.Errorf("uplink FAR of the I-UPF = %+v, want forwarding to the PSA", ul)
+			}
+			if f := iupfEst.CreatePDRs[1].PDI.FTEID; f == nil || !f.IPv4.Equal(iupf.upf.N9Address) {
+				t.Errorf("downlink F-TEID of the I-UPF = %+v, want its N9 address", f)
+			}
+
+			// Only the PSA reports usage
+			if len(psaEst.CreateURRs) == 0 || len(iupfEst.CreateURRs) != 0 {
+				t.Errorf("URRs: PSA %d, I-UPF %d, want the PSA only", len(psaEst.CreateURRs), len(iupfEst.CreateURRs))
+			}
+
+			if err := h.release(c.Ref); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(psa.takeRequests()); n != 1 {
+				t.Errorf("PSA got %d requests on release, want the deletion", n)
+			}
+			if n := len(iupf.takeRequests()); n != 1 {
+				t.Errorf("I-UPF got %d requests on release, want the deletion", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package smf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"go.uber.org/zap"
+)
+
+// smContextsPrefix is the path of the SM contexts of Nsmf_PDUSession
+const smContextsPrefix = "/nsmf-pdusession/v1/sm-contexts"
+
+// smPolicyCallbackPrefix is the path notified by the PCF of the policy
+// updates of an SM context
+const smPolicyCallbackPrefix = "/nsmf-callback/v1/sm-policies"
+
+// amfEventsCallbackPrefix is the path notified by the AMF of the
+// location of the UE of an SM context
+const amfEventsCallbackPrefix = "/nsmf-callback/v1/amf-events"
+
+// RegisterServices serves Nsmf_PDUSession on an SBI server, with the
+// callbacks of the PCF and the AMF
+func (s *SMF) RegisterServices(srv *sbi.Server) {
+	srv.HandleFunc(smContextsPrefix, s.handleSMContexts)
+	srv.HandleFunc(smContextsPrefix+"/", s.handleSMContexts)
+	srv.HandleFunc(smPolicyCallbackPrefix+"/", s.handleSMPolicyNotification)
+	srv.HandleFunc(amfEventsCallbackPrefix+"/", s.handleAMFEventNotification)
+}
+
+// apiRoot returns the API root of the SMF given to other NFs
+func (s *SMF) apiRoot() string {
+	return strings.TrimSuffix(s.config.CallbackURI, "/")
+}
+
+// handleSMContexts serves the SM contexts of Nsmf_PDUSession (TS 29.502
+// 5.2.2)
+func (s *SMF) handleSMContexts(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, smContextsPrefix), "/")
+	if rest == "" {
+		if allow(w, r, http.MethodPost) {
+			s.createSMContext(w, r)
+		}
+		return
+	}
+
+	ref, action, _ := strings.Cut(rest, "/")
+	if action != "modify" && action != "release" {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	c, ok := s.SMContext(ref)
+	if !ok {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+	if action == "modify" {
+		s.updateSMContext(w, r, c)
+	} else {
+		s.releaseSMContext(w, r, c)
+	}
+}
+
+// createSMContext creates the SM context of a PDU session requested by a
+// UE. The context is created at once and the establishment goes on in
+// the background, the SMF sending its outcome to the UE through the AMF.
+func (s *SMF) createSMContext(w http.ResponseWriter, r *http.Request) {
+	var data models.SmContextCreateData
+	m, err := sbi.ReadMultipart(r, &data)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if data.N1SmMsg != nil {
+		data.BinaryDataN1SmMessage = m.Part(data.N1SmMsg.ContentID)
+	}
+	req, err := checkCreateData(data)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+
+	dnn := data.Dnn
+	if dnn == "" {
+		dnn = s.config.DNNs[0]
+	}
+	if !s.config.ServesDNN(dnn) {
+		s.log.Info("PDU session rejected, DNN not served", zap.String("dnn", dnn))
+		s.recordEstablishment(dnn, data.SNssai, nas.Cause5GSMMissingOrUnknownDNN)
+		writeCreateError(w, req, http.StatusForbidden, "DNN_NOT_SUPPORTED", nas.Cause5GSMMissingOrUnknownDNN)
+		return
+	}
+
+	c, old := s.newContext(data, dnn)
+	c.pti = req.ProcedureTransactionID
+	if old != nil {
+		c.log.Info("PDU session ID reused, releasing the previous SM context", zap.String("old_sm_context", old.Ref))
+		go s.release(context.Background(), old)
+	}
+
+	snssai := c.SNSSAI
+	w.Header().Set("Location", s.apiRoot()+smContextsPrefix+"/"+c.Ref)
+	sbi.WriteJSON(w, http.StatusCreated, models.SmContextCreatedData{
+		PduSessionID: c.PDUSessionID,
+		SNssai:       &snssai,
+		UpCnxState:   models.UpCnxStateDeactivated,
+	})
+	go s.establish(c, req)
+}
+
+// checkCreateData checks an SM context creation and returns the PDU
+// Session Establishment Request it carries
+func checkCreateData(data models.SmContextCreateData) (*nas.PDUSessionEstablishmentRequest, error) {
+	if data.Supi == "" {
+		return nil, apperrors.NewBadRequestError("Missing SUPI", nil)
+	}
+	if data.PduSessionID < 1 || data.PduSessionID > 15 {
+		return nil, apperrors.NewBadRequestError(fmt.Sprintf("Invalid PDU session ID %d", data.PduSessionID), nil)
+	}
+	if data.RequestType != "" && data.RequestType != models.RequestTypeInitialRequest {
+		return nil, apperrors.NewBadRequestError(fmt.Sprintf("Unsupported request type %s", data.RequestType), nil)
+	}
+	if len(data.BinaryDataN1SmMessage) == 0 {
+		return nil, apperrors.NewBadRequestError("Missing N1 SM message", nil)
+	}
+
+	msg, err := nas.Decode(data.BinaryDataN1SmMessage)
+	if err != nil {
+		return nil, apperrors.NewBadRequestError("Invalid N1 SM message", err)
+	}
+	req, ok := msg.(*nas.PDUSessionEstablishmentRequest)
+	if !ok {
+		return nil, apperrors.NewBadRequestError("N1 SM message is not a PDU Session Establishment Request", nil)
+	}
+	if req.PDUSessionID != data.PduSessionID {
+		return nil, apperrors.NewBadRequestError("PDU session ID of the N1 SM message does not match", nil)
+	}
+	return req, nil
+}
+
+// writeCreateError answers an SM context creation with an error and the
+// PDU Session Establishment Reject the AMF forwards to the UE
+func writeCreateError(w http.ResponseWriter, req *nas.PDUSessionEstablishmentRequest, status int, cause string, smCause nas.Cause5GSM) {
+	body := &models.SmContextCreateError{
+		Error: models.ProblemDetails{Title: http.StatusText(status), Status: status, Cause: cause},
+	}
+	m := &sbi.Multipart{JSON: body}
+	if n1, err := establishmentReject(req.PDUSessionID, req.ProcedureTransactionID, smCause); err == nil {
+		body.N1SmMsg = &models.RefToBinaryData{ContentID: "n1SmMsg"}
+		m.Parts = []sbi.Part{{ContentID: "n1SmMsg", ContentType: sbi.ContentTypeNAS, Body: n1}}
+	}
+	sbi.WriteMultipart(w, status, m)
+}
+
+// updateSMContext applies an update of the AMF to an SM context: the
+// outcome of the N2 resource setup or modification in the gNB, or a
+// change of the user plane connection state
+func (s *SMF) updateSMContext(w http.ResponseWriter, r *http.Request, c *SMContext) {
+	var data models.SmContextUpdateData
+	m, err := sbi.ReadMultipart(r, &data)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if data.N2SmInfo != nil {
+		data.BinaryDataN2SmInformation = m.Part(data.N2SmInfo.ContentID)
+	}
+	if data.HoState != "" && data.HoState != models.HoStateNone || data.ToBeSwitched {
+		sbi.WriteError(w, apperrors.NewBadRequestError("Handover of PDU sessions not supported", nil))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+	if !c.established {
+		sbi.WriteError(w, apperrors.NewConflictError("PDU session establishment in progress", nil))
+		return
+	}
+
+	ctx := r.Context()
+	switch {
+	case data.N2SmInfoType == models.N2SmInfoPduResSetupRsp:
+		rsp, err := ngap.DecodeSetupResponseTransfer(data.BinaryDataN2SmInformation)
+		if err != nil {
+			sbi.WriteError(w, apperrors.NewBadRequestError("Invalid PDU Session Resource Setup Response Transfer", err))
+			return
+		}
+		dl := rsp.DLTunnel
+		c.N4.DLTunnel = &dl
+		if err := s.nfs.N4.ModifySession(ctx, c.UPF, c.N4); err != nil {
+			c.N4.DLTunnel = nil
+			c.log.Warn("Failed to update N4 session with the DL tunnel", zap.Error(err))
+			sbi.WriteError(w, apperrors.NewInternalError("Failed to update the user plane", err))
+			return
+		}
+		c.UpCnxState = models.UpCnxStateActivated
+		c.log.Info("User plane activated", zap.Stringer("gnb_address", dl.Address), zap.Uint32("gnb_teid", dl.TEID))
+		sbi.WriteJSON(w, http.StatusOK, models.SmContextUpdatedData{UpCnxState: c.UpCnxState})
+
+	case data.N2SmInfoType == models.N2SmInfoPduResSetupFail:
+		cause, err := ngap.DecodeSetupUnsuccessfulTransfer(data.BinaryDataN2SmInformation)
+		if err != nil {
+			sbi.WriteError(w, apperrors.NewBadRequestError("Invalid PDU Session Resource Setup Unsuccessful Transfer", err))
+			return
+		}
+		c.UpCnxState = models.UpCnxStateDeactivated
+		c.log.Info("gNB failed to set up PDU session resources", zap.Stringer("cause", cause))
+		sbi.WriteJSON(w, http.StatusOK, models.SmContextUpdatedData{UpCnxState: c.UpCnxState})
+
+	case data.N2SmInfoType == models.N2SmInfoPduResModRsp:
+		rsp, err := ngap.DecodeModifyResponseTransfer(data.BinaryDataN2SmInformation)
+		if err != nil {
+			sbi.WriteError(w, apperrors.NewBadRequestError("Invalid PDU Session Resource Modify Response Transfer", err))
+			return
+		}
+		for _, f := range rsp.FailedQosFlows {
+			c.log.Warn("gNB failed to set up QoS flow", zap.Uint8("qfi", f.QFI), zap.Stringer("cause", f.Cause))
+		}
+		if rsp.DLTunnel != nil {
+			old := c.N4.DLTunnel
+			c.N4.DLTunnel = rsp.DLTunnel
+			if err := s.nfs.N4.ModifySession(ctx, c.UPF, c.N4); err != nil {
+				c.N4.DLTunnel = old
+				c.log.Warn("Failed to update N4 session with the DL tunnel", zap.Error(err))
+				sbi.WriteError(w, apperrors.NewInternalError("Failed to update the user plane", err))
+				return
+			}
+		}
+		sbi.WriteJSON(w, http.StatusOK, models.SmContextUpdatedData{UpCnxState: c.UpCnxState})
+
+	case data.N2SmInfoType == models.N2SmInfoPduResModFail:
+		cause, err := ngap.DecodeModifyUnsuccessfulTransfer(data.BinaryDataN2SmInformation)
+		if err != nil {
+			sbi.WriteError(w, apperrors.NewBadRequestError("Invalid PDU Session Resource Modify Unsuccessful Transfer", err))
+			return
+		}
+		c.log.Warn("gNB failed to modify PDU session resources", zap.Stringer("cause", cause))
+		sbi.WriteJSON(w, http.StatusOK, models.SmContextUpdatedData{UpCnxState: c.UpCnxState})
+
+	case data.N2SmInfoType != "":
+		sbi.WriteError(w, apperrors.NewBadRequestError(fmt.Sprintf("Unsupported N2 SM information %s", data.N2SmInfoType), nil))
+
+	case data.UpCnxState == models.UpCnxStateDeactivated:
+		if c.N4.DLTunnel != nil {
+			c.N4.DLTunnel = nil
+			if err := s.nfs.N4.ModifySession(ctx, c.UPF, c.N4); err != nil {
+				c.log.Warn("Failed to remove the DL tunnel from the N4 session", zap.Error(err))
+			}
+		}
+		c.UpCnxState = models.UpCnxStateDeactivated
+		sbi.WriteJSON(w, http.StatusOK, models.SmContextUpdatedData{UpCnxState: c.UpCnxState})
+
+	case data.UpCnxState == models.UpCnxStateActivating:
+		n2, err := c.setupTransfer()
+		if err != nil {
+			sbi.WriteError(w, apperrors.NewInternalError("Failed to encode N2 SM information", err))
+			return
+		}
+		c.UpCnxState = models.UpCnxStateActivating
+		sbi.WriteMultipart(w, http.StatusOK, &sbi.Multipart{
+			JSON: models.SmContextUpdatedData{
+				UpCnxState:   c.UpCnxState,
+				N2SmInfo:     &models.RefToBinaryData{ContentID: "n2SmInfo"},
+				N2SmInfoType: models.N2SmInfoPduResSetupReq,
+			},
+			Parts: []sbi.Part{{ContentID: "n2SmInfo", ContentType: sbi.ContentTypeNGAP, Body: n2}},
+		})
+
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleSMPolicyNotification applies the policy updates the PCF notifies
+// for an SM context (TS 29.512 4.2.3)
+func (s *SMF) handleSMPolicyNotification(w http.ResponseWriter, r *http.Request) {
+	ref, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, smPolicyCallbackPrefix+"/"), "/")
+	if action != "update" {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	c, ok := s.SMContext(ref)
+	if !ok {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+
+	var n models.SmPolicyNotification
+	if _, err := sbi.ReadMultipart(r, &n); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	if n.SmPolicyDecision == nil {
+		sbi.WriteError(w, apperrors.NewBadRequestError("Missing SM policy decision", nil))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+	if !c.established {
+		sbi.WriteError(w, apperrors.NewConflictError("PDU session establishment in progress", nil))
+		return
+	}
+	if err := s.updatePolicy(r.Context(), c, n.SmPolicyDecision); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAMFEventNotification applies the location of a UE the AMF
+// notifies for an SM context (TS 29.518 5.3.2.4)
+func (s *SMF) handleAMFEventNotification(w http.ResponseWriter, r *http.Request) {
+	ref := strings.TrimPrefix(r.URL.Path, amfEventsCallbackPrefix+"/")
+	if ref == "" || strings.Contains(ref, "/") {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Resource not found", nil))
+		return
+	}
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+	c, ok := s.SMContext(ref)
+	if !ok {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+
+	var n models.AmfEventNotification
+	if _, err := sbi.ReadMultipart(r, &n); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	var tai *models.Tai
+	for _, report := range n.ReportList {
+		if report.Type == models.AmfEventLocationReport && report.Location != nil && report.Location.NrLocation != nil {
+			t := report.Location.NrLocation.Tai
+			tai = &t
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.released {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+	if tai != nil && c.established {
+		s.moved(r.Context(), c, *tai)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// releaseSMContext releases an SM context at the request of the AMF
+func (s *SMF) releaseSMContext(w http.ResponseWriter, r *http.Request, c *SMContext) {
+	var data models.SmContextReleaseData
+	if r.ContentLength != 0 {
+		if _, err := sbi.ReadMultipart(r, &data); err != nil {
+			sbi.WriteError(w, err)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	released := c.released
+	if !released {
+		s.teardown(r.Context(), c)
+		c.log.Info("PDU session released", zap.String("cause", data.Cause))
+	}
+	c.mu.Unlock()
+
+	if released {
+		sbi.WriteError(w, apperrors.NewNotFoundError("SM context not found", nil))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allow reports whether a request uses the method of its resource,
+// answering it with 405 otherwise
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	sbi.WriteError(w, apperrors.AppError{
+		Type:    apperrors.ErrorTypeBadRequest,
+		Message: fmt.Sprintf("Method %s not allowed", r.Method),
+		Code:    http.StatusMethodNotAllowed,
+	})
+	return false
+}
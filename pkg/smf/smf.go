@@ -0,0 +1,147 @@
+package smf
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"go.uber.org/zap"
+)
+
+// SMF runs the PDU session procedures of the SMF and keeps the SM
+// contexts
+type SMF struct {
+	config  *Config
+	nfs     NFs
+	metrics *metrics.SMFMetrics
+	log     *zap.Logger
+	ipam    *ipam
+	upfs    *upfSelector
+
+	mu        sync.RWMutex
+	contexts  map[string]*SMContext       // by SM context reference
+	sessions  map[sessionKey]*SMContext   // by SUPI and PDU session ID
+	reporting map[uint64]*chargingSession // by local SEID of the N4 session reporting usage
+	nextRef   uint64
+	nextSEID  uint64
+
+	nextChargingID uint32
+}
+
+// sessionKey identifies a PDU session of a UE
+type sessionKey struct {
+	supi string
+	id   uint8
+}
+
+// New creates an SMF using the given NF consumers, restoring the UE
+// address leases of the store, and handles the usage reports of N4.
+// Metrics are recorded on m when it is not nil.
+func New(cfg *Config, nfs NFs, m *metrics.SMFMetrics) (*SMF, error) {
+	log := logger.Named("smf")
+	ipam := newIPAM(cfg, nfs.Store, m, log)
+	if err := ipam.load(context.Background()); err != nil {
+		return nil, err
+	}
+
+	s := &SMF{
+		config:    cfg,
+		nfs:       nfs,
+		metrics:   m,
+		log:       log,
+		ipam:      ipam,
+		upfs:      newUPFSelector(cfg, nfs, log),
+		contexts:  make(map[string]*SMContext),
+		sessions:  make(map[sessionKey]*SMContext),
+		reporting: make(map[uint64]*chargingSession),
+	}
+	if nfs.N4 != nil {
+		nfs.N4.SetReportHandler(s.usageReported)
+	}
+	return s, nil
+}
+
+// Close stops the UPF heartbeats of the SMF
+func (s *SMF) Close() {
+	s.upfs.close()
+}
+
+// RegisterHealth adds the readiness checks of the SMF to r: the PFCP
+// association with the UPFs, failing once none answers its heartbeats,
+// and the address store when it can be pinged
+func (s *SMF) RegisterHealth(r *health.Registry) {
+	if s.nfs.N4 != nil {
+		r.Register(health.CheckPFCPAssociation, health.Readiness, s.upfs.check)
+	}
+	if p, ok := s.nfs.Store.(interface{ Ping(context.Context) error }); ok {
+		r.Register(health.CheckDatabase, health.Readiness, p.Ping)
+	}
+}
+
+// SMContext returns the SM context with the given reference
+func (s *SMF) SMContext(ref string) (*SMContext, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.contexts[ref]
+	return c, ok
+}
+
+// newContext creates the SM context of a PDU session, returning with it
+// the context it replaces when the UE reused a PDU session ID
+func (s *SMF) newContext(data models.SmContextCreateData, dnn string) (*SMContext, *SMContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextRef++
+	c := &SMContext{
+		Ref:            strconv.FormatUint(s.nextRef, 10),
+		SUPI:           data.Supi,
+		PDUSessionID:   data.PduSessionID,
+		DNN:            dnn,
+		SNSSAI:         data.SNssai,
+		ServingNetwork: data.ServingNetwork,
+		AnType:         data.AnType,
+		RatType:        data.RatType,
+		StatusURI:      data.SmContextStatusURI,
+		UpCnxState:     models.UpCnxStateDeactivated,
+	}
+	if l := data.UeLocation; l != nil && l.NrLocation != nil {
+		tai := l.NrLocation.Tai
+		c.TAI = &tai
+	}
+	c.log = s.log.With(logger.SUPI(c.SUPI), zap.Uint8("pdu_session_id", c.PDUSessionID),
+		zap.String("sm_context", c.Ref))
+
+	key := sessionKey{c.SUPI, c.PDUSessionID}
+	old := s.sessions[key]
+	if old != nil {
+		delete(s.contexts, old.Ref)
+	}
+	s.contexts[c.Ref] = c
+	s.sessions[key] = c
+	return c, old
+}
+
+// forget drops an SM context
+func (s *SMF) forget(c *SMContext) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.contexts, c.Ref)
+	key := sessionKey{c.SUPI, c.PDUSessionID}
+	if s.sessions[key] == c {
+		delete(s.sessions, key)
+	}
+}
+
+// allocateSEID returns a new local SEID of an N4 session
+func (s *SMF) allocateSEID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextSEID++
+	return s.nextSEID
+}
@@ -0,0 +1,1045 @@
+package smf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apperrors "github.com/0had0/5G-core/pkg/common/errors"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"github.com/0had0/5G-core/pkg/sbi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const (
+	testSUPI = "imsi-208930000000001"
+
+	// recvTimeout bounds the wait for a message of the SMF
+	recvTimeout = 2 * time.Second
+)
+
+var testPLMN = models.PlmnID{Mcc: "208", Mnc: "93"}
+
+// transfer is an N1N2 message transfer of the SMF to the AMF
+type transfer struct {
+	n1     nas.Message
+	n2     []byte
+	n2Type models.N2SmInfoType
+}
+
+// fakeNFs serves the UDM, PCF and AMF operations used by the SMF,
+// recording them
+type fakeNFs struct {
+	t   *testing.T
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	calls    []string
+	smData   []models.SessionManagementSubscriptionData // 404 when nil
+	decision models.SmPolicyDecision
+	policy   models.SmPolicyContextData // of the last SM policy association
+
+	transfers     chan transfer
+	notifications chan models.SmContextStatusNotification
+}
+
+func newFakeNFs(t *testing.T) *fakeNFs {
+	f := &fakeNFs{
+		t:             t,
+		smData:        subscription("internet"),
+		transfers:     make(chan transfer, 16),
+		notifications: make(chan models.SmContextStatusNotification, 16),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nudm-sdm/v2/", f.getSMData)
+	mux.HandleFunc("/npcf-smpolicycontrol/v1/sm-policies", f.createSMPolicy)
+	mux.HandleFunc("/npcf-smpolicycontrol/v1/sm-policies/", f.deleteSMPolicy)
+	mux.HandleFunc("/namf-comm/v1/ue-contexts/", f.n1n2MessageTransfer)
+	mux.HandleFunc("/namf-evts/v1/subscriptions", f.subscribeEvents)
+	mux.HandleFunc("/namf-evts/v1/subscriptions/", f.unsubscribeEvents)
+	mux.HandleFunc("/status", f.notifySMContextStatus)
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeNFs) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+// takeCalls returns the calls made since the last take
+func (f *fakeNFs) takeCalls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := f.calls
+	f.calls = nil
+	return calls
+}
+
+func (f *fakeNFs) getSMData(w http.ResponseWriter, r *http.Request) {
+	f.record("UDM.GetSMData")
+	if !strings.HasPrefix(r.URL.Path, "/nudm-sdm/v2/"+testSUPI+"/sm-data") {
+		f.t.Errorf("UDM path = %s", r.URL.Path)
+	}
+	f.mu.Lock()
+	data := f.smData
+	f.mu.Unlock()
+	if data == nil {
+		sbi.WriteError(w, apperrors.NewNotFoundError("Subscription not found", nil))
+		return
+	}
+	sbi.WriteJSON(w, http.StatusOK, data)
+}
+
+func (f *fakeNFs) createSMPolicy(w http.ResponseWriter, r *http.Request) {
+	f.record("PCF.CreateSMPolicy")
+	var data models.SmPolicyContextData
+	if _, err := sbi.ReadMultipart(r, &data); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	f.mu.Lock()
+	f.policy = data
+	decision := f.decision
+	f.mu.Unlock()
+	w.Header().Set("Location", f.srv.URL+"/npcf-smpolicycontrol/v1/sm-policies/1")
+	sbi.WriteJSON(w, http.StatusCreated, decision)
+}
+
+func (f *fakeNFs) deleteSMPolicy(w http.ResponseWriter, r *http.Request) {
+	f.record("PCF.DeleteSMPolicy")
+	if r.URL.Path != "/npcf-smpolicycontrol/v1/sm-policies/1/delete" {
+		f.t.Errorf("PCF delete path = %s", r.URL.Path)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeNFs) n1n2MessageTransfer(w http.ResponseWriter, r *http.Request) {
+	f.record("AMF.N1N2MessageTransfer")
+	var req models.N1N2MessageTransferReqData
+	m, err := sbi.ReadMultipart(r, &req)
+	if err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+
+	var tr transfer
+	if n1 := m.Part("n1msg"); n1 != nil {
+		if tr.n1, err = nas.Decode(n1); err != nil {
+			f.t.Errorf("N1 message: %v", err)
+		}
+	}
+	if c := req.N2InfoContainer; c != nil && c.SmInfo != nil && c.SmInfo.N2InfoContent != nil {
+		tr.n2, tr.n2Type = m.Part("n2msg"), c.SmInfo.N2InfoContent.NgapIeType
+	}
+	f.transfers <- tr
+	sbi.WriteJSON(w, http.StatusOK, models.N1N2MessageTransferRspData{Cause: models.N1N2TransferInitiated})
+}
+
+func (f *fakeNFs) notifySMContextStatus(w http.ResponseWriter, r *http.Request) {
+	f.record("AMF.NotifySMContextStatus")
+	var n models.SmContextStatusNotification
+	if _, err := sbi.ReadMultipart(r, &n); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	f.notifications <- n
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeNFs) subscribeEvents(w http.ResponseWriter, r *http.Request) {
+	f.record("AMF.SubscribeEvents")
+	var sub models.AmfCreateEventSubscription
+	if _, err := sbi.ReadMultipart(r, &sub); err != nil {
+		sbi.WriteError(w, err)
+		return
+	}
+	w.Header().Set("Location", f.srv.URL+"/namf-evts/v1/subscriptions/1")
+	sbi.WriteJSON(w, http.StatusCreated, models.AmfCreatedEventSubscription{
+		Subscription:   sub.Subscription,
+		SubscriptionID: "1",
+	})
+}
+
+func (f *fakeNFs) unsubscribeEvents(w http.ResponseWriter, r *http.Request) {
+	f.record("AMF.UnsubscribeEvents")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// recvTransfer waits for the next N1N2 message transfer
+func (f *fakeNFs) recvTransfer(t *testing.T) transfer {
+	t.Helper()
+	select {
+	case tr := <-f.transfers:
+		return tr
+	case <-time.After(recvTimeout):
+		t.Fatal("no N1N2 message transfer")
+		return transfer{}
+	}
+}
+
+// recvNotification waits for the next SM context status notification
+func (f *fakeNFs) recvNotification(t *testing.T) models.SmContextStatusNotification {
+	t.Helper()
+	select {
+	case n := <-f.notifications:
+		return n
+	case <-time.After(recvTimeout):
+		t.Fatal("no SM context status notification")
+		return models.SmContextStatusNotification{}
+	}
+}
+
+// subscription returns a subscription to DNNs in testSNSSAI allowing the
+// IP session types and every SSC mode, SSC mode 1 by default
+func subscription(dnns ...string) []models.SessionManagementSubscriptionData {
+	configs := make(map[string]models.DnnConfiguration, len(dnns))
+	for _, dnn := range dnns {
+		configs[dnn] = models.DnnConfiguration{
+			PduSessionTypes: models.PduSessionTypes{
+				DefaultSessionType:  models.PduSessionTypeIPv4,
+				AllowedSessionTypes: []models.PduSessionType{models.PduSessionTypeIPv4v6},
+			},
+			SscModes: models.SscModes{
+				DefaultSscMode:  models.SscMode1,
+				AllowedSscModes: []models.SscMode{models.SscMode2, models.SscMode3},
+			},
+			FiveGQosProfile: &models.SubscribedDefaultQos{Var5qi: 9, Arp: models.Arp{PriorityLevel: 8}},
+			SessionAmbr:     &models.Ambr{Uplink: "100 Mbps", Downlink: "200 Mbps"},
+		}
+	}
+	return []models.SessionManagementSubscriptionData{{SingleNssai: testSNSSAI, DnnConfigurations: configs}}
+}
+
+// fakeUPF answers the PFCP requests of the SMF as a UPF, recording the
+// session requests
+type fakeUPF struct {
+	node *pfcp.Node
+	upf  *UPF
+
+	mu       sync.Mutex
+	requests []pfcp.Message
+	sessions map[uint64]uint64 // SEIDs of the SMF by SEID of the UPF
+	nextSEID uint64
+	reject   bool               // rejects the session establishments
+	usage    []pfcp.UsageReport // reported when a session is deleted
+}
+
+// newFakeUPF starts a UPF of a locality serving every DNN and slice,
+// without N9 address when n9 is empty
+func newFakeUPF(t *testing.T, nodeID, locality, n3, n9 string) *fakeUPF {
+	t.Helper()
+	f := &fakeUPF{sessions: make(map[uint64]uint64)}
+	node, err := pfcp.Listen(pfcp.NodeConfig{Address: "127.0.0.1:0", Handler: f.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.Close() })
+
+	// Set under the lock, the handler seeing it through the network only
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.node = node
+	f.upf = &UPF{
+		NodeID:    nodeID,
+		N4Address: node.LocalAddr().String(),
+		N3Address: net.ParseIP(n3),
+		Locality:  locality,
+		capacity:  100,
+	}
+	if n9 != "" {
+		f.upf.N9Address = net.ParseIP(n9)
+	}
+	return f
+}
+
+func (f *fakeUPF) handle(req *pfcp.Request) (pfcp.Message, uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	nodeID := pfcp.NewNodeID(f.node.LocalAddr().IP.String())
+	switch m := req.Message.(type) {
+	case *pfcp.AssociationSetupRequest:
+		return &pfcp.AssociationSetupResponse{
+			NodeID:            nodeID,
+			Cause:             pfcp.CauseRequestAccepted,
+			RecoveryTimeStamp: f.node.RecoveryTimeStamp(),
+		}, 0
+
+	case *pfcp.SessionEstablishmentRequest:
+		f.requests = append(f.requests, m)
+		if f.reject {
+			return &pfcp.SessionEstablishmentResponse{NodeID: nodeID, Cause: pfcp.CauseNoResourcesAvailable}, m.CPFSEID.SEID
+		}
+		f.nextSEID++
+		f.sessions[f.nextSEID] = m.CPFSEID.SEID
+		return &pfcp.SessionEstablishmentResponse{
+			NodeID:  nodeID,
+			Cause:   pfcp.CauseRequestAccepted,
+			UPFSEID: &pfcp.FSEID{SEID: f.nextSEID, IPv4: net.IPv4(127, 0, 0, 1)},
+		}, m.CPFSEID.SEID
+
+	case *pfcp.SessionModificationRequest:
+		f.requests = append(f.requests, m)
+		seid, ok := f.sessions[req.Header.SEID]
+		if !ok {
+			return &pfcp.SessionModificationResponse{Cause: pfcp.CauseSessionContextNotFound}, 0
+		}
+		return &pfcp.SessionModificationResponse{Cause: pfcp.CauseRequestAccepted}, seid
+
+	case *pfcp.SessionDeletionRequest:
+		f.requests = append(f.requests, m)
+		seid, ok := f.sessions[req.Header.SEID]
+		if !ok {
+			return &pfcp.SessionDeletionResponse{Cause: pfcp.CauseSessionContextNotFound}, 0
+		}
+		delete(f.sessions, req.Header.SEID)
+		return &pfcp.SessionDeletionResponse{Cause: pfcp.CauseRequestAccepted, UsageReports: f.usage}, seid
+	}
+	return nil, 0
+}
+
+// takeRequests returns the session requests received since the last
+// take
+func (f *fakeUPF) takeRequests() []pfcp.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	requests := f.requests
+	f.requests = nil
+	return requests
+}
+
+// setReject makes the UPF reject the session establishments
+func (f *fakeUPF) setReject(reject bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reject = reject
+}
+
+// harness runs an SMF against fake NFs and UPFs
+type harness struct {
+	smf    *SMF
+	nfs    *fakeNFs
+	upfs   []*fakeUPF
+	n4     *N4Client
+	chf    *LocalCHF
+	client *sbi.Client
+	url    string // API root of the SMF
+}
+
+// newHarness starts an SMF serving the DNNs internet and ims in the
+// locality paris with its UPFs, one of paris when none are given.
+// configure, when not nil, changes the configuration first.
+func newHarness(t *testing.T, configure func(*Config), upfs ...*fakeUPF) *harness {
+	t.Helper()
+	if len(upfs) == 0 {
+		upfs = []*fakeUPF{newFakeUPF(t, "upf-paris", "paris", "10.100.0.1", "")}
+	}
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	nfs := newFakeNFs(t)
+	cfg := &Config{
+		InstanceID:          "smf-test",
+		Name:                "smf",
+		DNNs:                []string{"internet", "ims"},
+		Locality:            "paris",
+		UPFSelectionMode:    UPFSelectionProximity,
+		TACLocalities:       map[string]string{"000001": "paris", "000002": "lyon"},
+		SSC3AddressLifetime: time.Hour,
+		PFCPAddress:         "127.0.0.1:0",
+		PFCPNodeID:          net.IPv4(127, 0, 0, 1),
+		PFCPT1:              100 * time.Millisecond,
+		PFCPN1:              2,
+		Pools:               []PoolConfig{testPool(t, defaultPool, "", nil, "10.60.0.1", "10.60.0.254", "2001:db8:60::", 48)},
+		ChargingSink:        ChargingSinkLocal,
+		DefaultRatingGroup:  100,
+		CallbackURI:         srv.URL,
+		AMFURI:              nfs.srv.URL,
+		UDMURI:              nfs.srv.URL,
+		PCFURI:              nfs.srv.URL,
+	}
+	for _, u := range upfs {
+		cfg.UPFs = append(cfg.UPFs, u.upf)
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	n4, err := NewN4Client(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { n4.Close() })
+
+	h := &harness{
+		nfs:    nfs,
+		upfs:   upfs,
+		n4:     n4,
+		chf:    NewLocalCHF(),
+		client: sbi.NewClient("amf", recvTimeout, nil),
+		url:    srv.URL,
+	}
+	consumers := NewNFs(sbi.NewClient("smf", recvTimeout, nil), cfg)
+	consumers.N4, consumers.Charging = n4, h.chf
+	if h.smf, err = New(cfg, consumers, metrics.NewSMFMetrics(metrics.New("smf"), "test")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.smf.Close)
+
+	// As RegisterServices, whose server keeps its mux to itself
+	mux.HandleFunc(smContextsPrefix, h.smf.handleSMContexts)
+	mux.HandleFunc(smContextsPrefix+"/", h.smf.handleSMContexts)
+	mux.HandleFunc(smPolicyCallbackPrefix+"/", h.smf.handleSMPolicyNotification)
+	mux.HandleFunc(amfEventsCallbackPrefix+"/", h.smf.handleAMFEventNotification)
+	return h
+}
+
+// establishmentRequest returns the PDU Session Establishment Request of
+// a PDU session leaving its type and SSC mode to the subscription
+func establishmentRequest(id uint8) *nas.PDUSessionEstablishmentRequest {
+	return &nas.PDUSessionEstablishmentRequest{
+		SMHeader:                       nas.SMHeader{PDUSessionID: id, ProcedureTransactionID: 1},
+		IntegrityProtectionMaxDataRate: nas.IntegrityProtectionMaxDataRate{Uplink: 0xff, Downlink: 0xff},
+	}
+}
+
+// createData returns the SM context creation of a request in the tracking
+// area 000001
+func (h *harness) createData(dnn string, req *nas.PDUSessionEstablishmentRequest) models.SmContextCreateData {
+	return models.SmContextCreateData{
+		Supi:           testSUPI,
+		PduSessionID:   req.PDUSessionID,
+		Dnn:            dnn,
+		SNssai:         testSNSSAI,
+		RequestType:    models.RequestTypeInitialRequest,
+		ServingNfID:    "amf-test",
+		ServingNetwork: testPLMN,
+		AnType:         models.AccessType3GPP,
+		UeLocation: &models.UserLocation{NrLocation: &models.NrLocation{
+			Tai: models.Tai{PlmnID: testPLMN, Tac: "000001"},
+		}},
+		SmContextStatusURI: h.nfs.srv.URL + "/status",
+		N1SmMsg:            &models.RefToBinaryData{ContentID: "n1SmMsg"},
+	}
+}
+
+// createSMContext posts an SM context creation with an N1 SM message,
+// returning the reference of the context, or the error of the SMF and
+// its N1 SM message
+func (h *harness) createSMContext(t *testing.T, data models.SmContextCreateData, n1 nas.Message) (string, nas.Message, error) {
+	t.Helper()
+	body := &sbi.Multipart{JSON: &data}
+	if n1 != nil {
+		b, err := nas.Encode(n1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body.Parts = []sbi.Part{{ContentID: "n1SmMsg", ContentType: sbi.ContentTypeNAS, Body: b}}
+	}
+
+	var createErr models.SmContextCreateError
+	rsp := &sbi.Multipart{JSON: &createErr}
+	location, err := h.client.Create(context.Background(), h.url+smContextsPrefix, body, rsp)
+	if err != nil {
+		var msg nas.Message
+		if createErr.N1SmMsg != nil {
+			var decodeErr error
+			if msg, decodeErr = nas.Decode(rsp.Part(createErr.N1SmMsg.ContentID)); decodeErr != nil {
+				t.Fatal(decodeErr)
+			}
+		}
+		return "", msg, errorWithCause{err, createErr.Error.Cause}
+	}
+	if !strings.HasPrefix(location, h.url+smContextsPrefix+"/") {
+		t.Fatalf("Location = %q", location)
+	}
+	return path.Base(location), nil, nil
+}
+
+// errorWithCause is the error of an SM context creation with the cause
+// of its problem details
+type errorWithCause struct {
+	error
+	cause string
+}
+
+func (e errorWithCause) Unwrap() error { return e.error }
+
+// establish establishes a PDU session to a DNN, returning its SM context
+// with the accept and the N2 transfer sent to the UE
+func (h *harness) establish(t *testing.T, dnn string, req *nas.PDUSessionEstablishmentRequest) (*SMContext, *nas.PDUSessionEstablishmentAccept, *ngap.PDUSessionResourceSetupRequestTransfer) {
+	t.Helper()
+	ref, _, err := h.createSMContext(t, h.createData(dnn, req), req)
+	if err != nil {
+		t.Fatalf("creating SM context: %v", err)
+	}
+	tr := h.nfs.recvTransfer(t)
+	accept, ok := tr.n1.(*nas.PDUSessionEstablishmentAccept)
+	if !ok {
+		t.Fatalf("N1 message = %#v, want accept", tr.n1)
+	}
+	if tr.n2Type != models.N2SmInfoPduResSetupReq {
+		t.Fatalf("N2 SM information = %q", tr.n2Type)
+	}
+	n2, err := ngap.DecodeSetupRequestTransfer(tr.n2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.context(t, ref), accept, n2
+}
+
+// context returns an SM context once its procedure is over
+func (h *harness) context(t *testing.T, ref string) *SMContext {
+	t.Helper()
+	c, ok := h.smf.SMContext(ref)
+	if !ok {
+		t.Fatalf("no SM context %s", ref)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c
+}
+
+// update posts an SM context update with N2 SM information when n2 is
+// not nil, returning the answer of the SMF with its N2 SM information
+func (h *harness) update(ref string, data models.SmContextUpdateData, n2 []byte) (*models.SmContextUpdatedData, []byte, error) {
+	body := &sbi.Multipart{JSON: &data}
+	if n2 != nil {
+		data.N2SmInfo = &models.RefToBinaryData{ContentID: "n2SmInfo"}
+		body.Parts = []sbi.Part{{ContentID: "n2SmInfo", ContentType: sbi.ContentTypeNGAP, Body: n2}}
+	}
+	var updated models.SmContextUpdatedData
+	rsp := &sbi.Multipart{JSON: &updated}
+	err := h.client.Post(context.Background(), h.url+smContextsPrefix+"/"+ref+"/modify", body, rsp)
+	if err != nil {
+		return nil, nil, err
+	}
+	var info []byte
+	if updated.N2SmInfo != nil {
+		info = rsp.Part(updated.N2SmInfo.ContentID)
+	}
+	return &updated, info, nil
+}
+
+// activate sets up the N3 tunnel of the gNB for a PDU session
+func (h *harness) activate(t *testing.T, ref string, dl ngap.GTPTunnel) {
+	t.Helper()
+	n2, err := ngap.EncodeSetupResponseTransfer(&ngap.PDUSessionResourceSetupResponseTransfer{
+		DLTunnel: dl,
+		QosFlows: []uint8{defaultQFI},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated, _, err := h.update(ref, models.SmContextUpdateData{N2SmInfoType: models.N2SmInfoPduResSetupRsp}, n2)
+	if err != nil {
+		t.Fatalf("activating user plane: %v", err)
+	}
+	if updated.UpCnxState != models.UpCnxStateActivated {
+		t.Fatalf("UpCnxState = %s", updated.UpCnxState)
+	}
+}
+
+// release posts an SM context release
+func (h *harness) release(ref string) error {
+	return h.client.Post(context.Background(), h.url+smContextsPrefix+"/"+ref+"/release",
+		models.SmContextReleaseData{Cause: "REL_DUE_TO_UE_REQUEST"}, nil)
+}
+
+// statusCode returns the status code of an SBI error, 0 for none
+func statusCode(err error) int {
+	var appErr apperrors.AppError
+	if errors.As(err, &appErr) {
+		return appErr.StatusCode()
+	}
+	return 0
+}
+
+// gnbTunnel is the N3 tunnel endpoint of the gNB
+var gnbTunnel = ngap.GTPTunnel{Address: net.IPv4(10, 200, 0, 1), TEID: 0x100}
+
+func TestPDUSessionEstablishment(t *testing.T) {
+	tests := []struct {
+		name      string
+		dnn       string
+		smData    []models.SessionManagementSubscriptionData
+		rejectUPF bool
+
+		// wantStatus is the status of the SM context creation, 201 when
+		// created
+		wantStatus int
+		// wantCause is the cause of the reject, 0 for an accept
+		wantCause nas.Cause5GSM
+		wantDNN   string
+		wantCalls []string
+	}{
+		{
+			name:       "accepted",
+			dnn:        "internet",
+			smData:     subscription("internet"),
+			wantStatus: http.StatusCreated,
+			wantDNN:    "internet",
+			wantCalls:  []string{"UDM.GetSMData", "PCF.CreateSMPolicy", "AMF.N1N2MessageTransfer"},
+		},
+		{
+			name:       "default DNN",
+			smData:     subscription("internet"),
+			wantStatus: http.StatusCreated,
+			wantDNN:    "internet",
+			wantCalls:  []string{"UDM.GetSMData", "PCF.CreateSMPolicy", "AMF.N1N2MessageTransfer"},
+		},
+		{
+			name:       "DNN not served",
+			dnn:        "enterprise",
+			smData:     subscription("enterprise"),
+			wantStatus: http.StatusForbidden,
+			wantCause:  nas.Cause5GSMMissingOrUnknownDNN,
+		},
+		{
+			name:       "DNN not subscribed",
+			dnn:        "ims",
+			smData:     subscription("internet"),
+			wantStatus: http.StatusCreated,
+			wantCause:  nas.Cause5GSMMissingOrUnknownDNN,
+			wantCalls:  []string{"UDM.GetSMData", "AMF.N1N2MessageTransfer", "AMF.NotifySMContextStatus"},
+		},
+		{
+			name:       "no subscription",
+			dnn:        "internet",
+			wantStatus: http.StatusCreated,
+			wantCause:  nas.Cause5GSMNetworkFailure,
+			wantCalls:  []string{"UDM.GetSMData", "AMF.N1N2MessageTransfer", "AMF.NotifySMContextStatus"},
+		},
+		{
+			name:       "UPF rejects",
+			dnn:        "internet",
+			smData:     subscription("internet"),
+			rejectUPF:  true,
+			wantStatus: http.StatusCreated,
+			wantCause:  nas.Cause5GSMNetworkFailure,
+			wantCalls: []string{"UDM.GetSMData", "PCF.CreateSMPolicy", "PCF.DeleteSMPolicy",
+				"AMF.N1N2MessageTransfer", "AMF.NotifySMContextStatus"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, nil)
+			h.nfs.smData = tt.smData
+			h.upfs[0].setReject(tt.rejectUPF)
+
+			req := establishmentRequest(1)
+			ref, n1, err := h.createSMContext(t, h.createData(tt.dnn, req), req)
+			if status := statusCode(err); err != nil && status != tt.wantStatus || err == nil && tt.wantStatus != http.StatusCreated {
+				t.Fatalf("creating SM context: status %d (%v), want %d", status, err, tt.wantStatus)
+			}
+			if err != nil {
+				// Rejected at once, the N1 SM message being the reject
+				var withCause errorWithCause
+				if !errors.As(err, &withCause) || withCause.cause != "DNN_NOT_SUPPORTED" {
+					t.Errorf("error = %v, want cause DNN_NOT_SUPPORTED", err)
+				}
+				rej, ok := n1.(*nas.PDUSessionEstablishmentReject)
+				if !ok || rej.Cause != tt.wantCause || rej.PDUSessionID != 1 || rej.ProcedureTransactionID != 1 {
+					t.Errorf("N1 SM message = %#v, want reject with cause %s", n1, tt.wantCause)
+				}
+				if calls := h.nfs.takeCalls(); len(calls) != 0 {
+					t.Errorf("calls = %v, want none", calls)
+				}
+				return
+			}
+
+			tr := h.nfs.recvTransfer(t)
+			if tt.wantCause == 0 {
+				accept, ok := tr.n1.(*nas.PDUSessionEstablishmentAccept)
+				if !ok {
+					t.Fatalf("N1 message = %#v, want accept", tr.n1)
+				}
+				if accept.DNN != tt.wantDNN || accept.PDUSessionID != 1 || accept.ProcedureTransactionID != 1 {
+					t.Errorf("accept = %+v", accept)
+				}
+				c := h.context(t, ref)
+				if c.DNN != tt.wantDNN || !c.established || c.UpCnxState != models.UpCnxStateActivating {
+					t.Errorf("context: DNN %s, established %v, %s", c.DNN, c.established, c.UpCnxState)
+				}
+			} else {
+				rej, ok := tr.n1.(*nas.PDUSessionEstablishmentReject)
+				if !ok || rej.Cause != tt.wantCause {
+					t.Fatalf("N1 message = %#v, want reject with cause %s", tr.n1, tt.wantCause)
+				}
+				if tr.n2 != nil {
+					t.Error("reject sent with N2 SM information")
+				}
+				n := h.nfs.recvNotification(t)
+				if n.StatusInfo.ResourceStatus != models.ResourceStatusReleased || n.StatusInfo.Cause != "" {
+					t.Errorf("status = %+v", n.StatusInfo)
+				}
+				if _, ok := h.smf.SMContext(ref); ok {
+					t.Error("rejected SM context kept")
+				}
+			}
+			if calls := h.nfs.takeCalls(); strings.Join(calls, " ") != strings.Join(tt.wantCalls, " ") {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPDUSessionEstablishmentUserPlane(t *testing.T) {
+	h := newHarness(t, nil)
+	upf := h.upfs[0]
+
+	c, accept, n2 := h.establish(t, "internet", establishmentRequest(5))
+
+	if accept.PDUSessionType != nas.PDUSessionTypeIPv4 || accept.SSCMode != nas.SSCMode1 {
+		t.Errorf("accept: type %s, SSC mode %d", accept.PDUSessionType, accept.SSCMode)
+	}
+	if a := accept.PDUAddress; a == nil || !a.IPv4.Equal(net.IPv4(10, 60, 0, 1)) {
+		t.Errorf("PDU address = %+v, want 10.60.0.1", a)
+	}
+	if want := (nas.SessionAMBR{Uplink: 100e6, Downlink: 200e6}); accept.SessionAMBR != want {
+		t.Errorf("session AMBR = %+v, want %+v", accept.SessionAMBR, want)
+	}
+	if accept.SNSSAI == nil || *accept.SNSSAI != testSNSSAI {
+		t.Errorf("S-NSSAI = %v", accept.SNSSAI)
+	}
+
+	// The gNB sends uplink packets to the N3 endpoint of the UPF
+	if !n2.ULTunnel.Address.Equal(upf.upf.N3Address) || n2.ULTunnel.TEID != c.N4.ULTunnel.TEID {
+		t.Errorf("UL tunnel = %+v, want %s TEID %d", n2.ULTunnel, upf.upf.N3Address, c.N4.ULTunnel.TEID)
+	}
+	if len(n2.QosFlows) != 1 || n2.QosFlows[0].QFI != defaultQFI || n2.QosFlows[0].FiveQI != 9 {
+		t.Errorf("QoS flows = %+v, want the default one of 5QI 9", n2.QosFlows)
+	}
+
+	requests := upf.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("UPF got %d requests, want the session establishment", len(requests))
+	}
+	est, ok := requests[0].(*pfcp.SessionEstablishmentRequest)
+	if !ok {
+		t.Fatalf("UPF got %T", requests[0])
+	}
+	if est.CPFSEID.SEID != c.N4.LocalSEID || c.N4.RemoteSEID == 0 {
+		t.Errorf("SEIDs: F-SEID %d, local %d, remote %d", est.CPFSEID.SEID, c.N4.LocalSEID, c.N4.RemoteSEID)
+	}
+	if est.PDNType != pfcp.PDNTypeIPv4 || len(est.CreatePDRs) != 2 || len(est.CreateFARs) != 2 {
+		t.Fatalf("establishment: PDN type %d, %d PDRs, %d FARs", est.PDNType, len(est.CreatePDRs), len(est.CreateFARs))
+	}
+	ul, dl := est.CreatePDRs[0], est.CreatePDRs[1]
+	if ul.PDRID != pdrUplink || ul.PDI.FTEID == nil || ul.PDI.FTEID.TEID != n2.ULTunnel.TEID {
+		t.Errorf("uplink PDR = %+v", ul)
+	}
+	if dl.PDRID != pdrDownlink || dl.PDI.UEIPAddress == nil || !dl.PDI.UEIPAddress.IPv4.Equal(net.IPv4(10, 60, 0, 1)) {
+		t.Errorf("downlink PDR = %+v", dl)
+	}
+	if far := est.CreateFARs[1]; far.FARID != farDownlink || far.ApplyAction&pfcp.ApplyActionBuffer == 0 {
+		t.Errorf("downlink FAR = %+v, want buffering until activation", far)
+	}
+
+	h.nfs.mu.Lock()
+	policy := h.nfs.policy
+	h.nfs.mu.Unlock()
+	if policy.Supi != testSUPI || policy.PduSessionID != 5 || policy.Ipv4Address != "10.60.0.1" ||
+		policy.NotificationURI != h.url+smPolicyCallbackPrefix+"/"+c.Ref {
+		t.Errorf("SM policy context = %+v", policy)
+	}
+
+	if got := testutil.ToFloat64(h.smf.metrics.ActiveSessions.WithLabelValues("internet", metrics.SNSSAILabel(1, "010203"))); got != 1 {
+		t.Errorf("active sessions = %v, want 1", got)
+	}
+}
+
+func TestCreateSMContextErrors(t *testing.T) {
+	h := newHarness(t, nil)
+
+	tests := []struct {
+		name   string
+		change func(*models.SmContextCreateData)
+		n1     nas.Message
+	}{
+		{
+			name:   "missing SUPI",
+			change: func(d *models.SmContextCreateData) { d.Supi = "" },
+			n1:     establishmentRequest(1),
+		},
+		{
+			name:   "PDU session ID 0",
+			change: func(d *models.SmContextCreateData) { d.PduSessionID = 0 },
+			n1:     establishmentRequest(0),
+		},
+		{
+			name:   "PDU session ID 16",
+			change: func(d *models.SmContextCreateData) { d.PduSessionID = 16 },
+			n1:     establishmentRequest(16),
+		},
+		{
+			name:   "existing PDU session",
+			change: func(d *models.SmContextCreateData) { d.RequestType = models.RequestTypeExistingPduSession },
+			n1:     establishmentRequest(1),
+		},
+		{
+			name:   "missing N1 SM message",
+			change: func(d *models.SmContextCreateData) {},
+		},
+		{
+			name:   "not an establishment request",
+			change: func(d *models.SmContextCreateData) {},
+			n1:     &nas.PDUSessionReleaseRequest{SMHeader: nas.SMHeader{PDUSessionID: 1, ProcedureTransactionID: 1}},
+		},
+		{
+			name:   "PDU session ID mismatch",
+			change: func(d *models.SmContextCreateData) {},
+			n1:     establishmentRequest(2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := h.createData("internet", establishmentRequest(1))
+			tt.change(&data)
+			_, _, err := h.createSMContext(t, data, tt.n1)
+			if status := statusCode(err); status != http.StatusBadRequest {
+				t.Errorf("status = %d (%v), want 400", status, err)
+			}
+		})
+	}
+	if calls := h.nfs.takeCalls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
+
+func TestSMContextResources(t *testing.T) {
+	h := newHarness(t, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		wantStatus int
+	}{
+		{"list", http.MethodGet, h.url + smContextsPrefix, http.StatusMethodNotAllowed},
+		{"unknown action", http.MethodPost, h.url + smContextsPrefix + "/1/retrieve", http.StatusNotFound},
+		{"unknown context", http.MethodPost, h.url + smContextsPrefix + "/42/modify", http.StatusNotFound},
+		{"release of unknown context", http.MethodPost, h.url + smContextsPrefix + "/42/release", http.StatusNotFound},
+		{"policy of unknown context", http.MethodPost, h.url + smPolicyCallbackPrefix + "/42/update", http.StatusNotFound},
+		{"AMF event of unknown context", http.MethodPost, h.url + amfEventsCallbackPrefix + "/42", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, strings.NewReader("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", sbi.ContentTypeJSON)
+			rsp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsp.Body.Close()
+			if rsp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rsp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestSMContextUpdate(t *testing.T) {
+	setupFailure, err := ngap.EncodeUnsuccessfulTransfer(ngap.Cause{Group: ngap.CauseGroupRadioNetwork, Value: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		activated bool // the gNB set up its tunnel first
+		data      models.SmContextUpdateData
+		n2        []byte
+
+		wantState models.UpCnxState
+		wantN2    bool // the answer carries the N2 setup request
+		// wantDLFAR is the action of the downlink FAR sent to the UPF, 0
+		// when the session is not modified
+		wantDLFAR  pfcp.ApplyAction
+		wantStatus int // of an error
+	}{
+		{
+			name:      "activated",
+			data:      models.SmContextUpdateData{N2SmInfoType: models.N2SmInfoPduResSetupRsp},
+			wantState: models.UpCnxStateActivated,
+			wantDLFAR: pfcp.ApplyActionForward,
+		},
+		{
+			name:      "setup failed",
+			data:      models.SmContextUpdateData{N2SmInfoType: models.N2SmInfoPduResSetupFail},
+			n2:        setupFailure,
+			wantState: models.UpCnxStateDeactivated,
+		},
+		{
+			name:      "deactivated",
+			activated: true,
+			data:      models.SmContextUpdateData{UpCnxState: models.UpCnxStateDeactivated},
+			wantState: models.UpCnxStateDeactivated,
+			wantDLFAR: pfcp.ApplyActionBuffer | pfcp.ApplyActionNotifyCP,
+		},
+		{
+			name:      "activating",
+			activated: true,
+			data:      models.SmContextUpdateData{UpCnxState: models.UpCnxStateActivating},
+			wantState: models.UpCnxStateActivating,
+			wantN2:    true,
+		},
+		{
+			name:       "invalid N2 SM information",
+			data:       models.SmContextUpdateData{N2SmInfoType: models.N2SmInfoPduResSetupRsp},
+			n2:         []byte{0xff},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unsupported N2 SM information",
+			data:       models.SmContextUpdateData{N2SmInfoType: models.N2SmInfoPathSwitchReq},
+			n2:         []byte{0},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "handover",
+			data:       models.SmContextUpdateData{HoState: models.HoStatePreparing},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, nil)
+			c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+			if tt.activated {
+				h.activate(t, c.Ref, gnbTunnel)
+			}
+			h.upfs[0].takeRequests()
+
+			n2 := tt.n2
+			if n2 == nil && tt.data.N2SmInfoType == models.N2SmInfoPduResSetupRsp {
+				if n2, err = ngap.EncodeSetupResponseTransfer(&ngap.PDUSessionResourceSetupResponseTransfer{
+					DLTunnel: gnbTunnel,
+					QosFlows: []uint8{defaultQFI},
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			updated, info, err := h.update(c.Ref, tt.data, n2)
+			if tt.wantStatus != 0 {
+				if status := statusCode(err); status != tt.wantStatus {
+					t.Errorf("status = %d (%v), want %d", status, err, tt.wantStatus)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.UpCnxState != tt.wantState || h.context(t, c.Ref).UpCnxState != tt.wantState {
+				t.Errorf("UpCnxState = %s, want %s", updated.UpCnxState, tt.wantState)
+			}
+
+			if tt.wantN2 {
+				if updated.N2SmInfoType != models.N2SmInfoPduResSetupReq {
+					t.Errorf("N2 SM information = %q", updated.N2SmInfoType)
+				}
+				setup, err := ngap.DecodeSetupRequestTransfer(info)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if setup.ULTunnel.TEID != c.N4.ULTunnel.TEID {
+					t.Errorf("UL tunnel = %+v", setup.ULTunnel)
+				}
+			} else if info != nil {
+				t.Error("answer carries N2 SM information")
+			}
+
+			requests := h.upfs[0].takeRequests()
+			if tt.wantDLFAR == 0 {
+				if len(requests) != 0 {
+					t.Errorf("UPF got %d requests, want none", len(requests))
+				}
+				return
+			}
+			if len(requests) != 1 {
+				t.Fatalf("UPF got %d requests, want the session modification", len(requests))
+			}
+			mod, ok := requests[0].(*pfcp.SessionModificationRequest)
+			if !ok || len(mod.UpdateFARs) != 1 {
+				t.Fatalf("UPF got %#v", requests[0])
+			}
+			far := mod.UpdateFARs[0]
+			if far.FARID != farDownlink || far.ApplyAction == nil || *far.ApplyAction != tt.wantDLFAR {
+				t.Errorf("downlink FAR = %+v", far)
+			}
+			if tt.wantDLFAR == pfcp.ApplyActionForward {
+				ohc := far.ForwardingParameters.OuterHeaderCreation
+				if ohc == nil || ohc.TEID != gnbTunnel.TEID || !ohc.IPv4.Equal(gnbTunnel.Address) {
+					t.Errorf("outer header creation = %+v, want the gNB tunnel", ohc)
+				}
+			}
+		})
+	}
+}
+
+func TestSMContextRelease(t *testing.T) {
+	h := newHarness(t, nil)
+	upf := h.upfs[0]
+	c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+	seid := c.N4.RemoteSEID
+	h.activate(t, c.Ref, gnbTunnel)
+	upf.takeRequests()
+	h.nfs.takeCalls()
+
+	if err := h.release(c.Ref); err != nil {
+		t.Fatalf("releasing SM context: %v", err)
+	}
+
+	requests := upf.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("UPF got %d requests, want the session deletion", len(requests))
+	}
+	if _, ok := requests[0].(*pfcp.SessionDeletionRequest); !ok {
+		t.Errorf("UPF got %T", requests[0])
+	}
+	upf.mu.Lock()
+	_, kept := upf.sessions[seid]
+	upf.mu.Unlock()
+	if kept {
+		t.Error("N4 session kept in the UPF")
+	}
+	if calls := h.nfs.takeCalls(); strings.Join(calls, " ") != "PCF.DeleteSMPolicy" {
+		t.Errorf("calls = %v, want PCF.DeleteSMPolicy", calls)
+	}
+	if _, ok := h.smf.SMContext(c.Ref); ok {
+		t.Error("released SM context kept")
+	}
+	if got := testutil.ToFloat64(h.smf.metrics.ActiveSessions.WithLabelValues("internet", metrics.SNSSAILabel(1, "010203"))); got != 0 {
+		t.Errorf("active sessions = %v, want 0", got)
+	}
+
+	if err := h.release(c.Ref); statusCode(err) != http.StatusNotFound {
+		t.Errorf("second release: %v, want 404", err)
+	}
+	if _, _, err := h.update(c.Ref, models.SmContextUpdateData{UpCnxState: models.UpCnxStateDeactivated}, nil); statusCode(err) != http.StatusNotFound {
+		t.Errorf("update after release: %v, want 404", err)
+	}
+
+	if r := h.smf.ipam.pools[0].ranges[ipv4]; r.inUse != 0 {
+		t.Errorf("%d addresses in use after the release, want none", r.inUse)
+	}
+}
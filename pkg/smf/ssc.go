@@ -0,0 +1,96 @@
+package smf
+
+import (
+	"context"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"go.uber.org/zap"
+)
+
+// statusReactivation is the cause notified to the AMF for a PDU session
+// released for the UE to establish it again
+const statusReactivation = "REL_DUE_TO_REACTIVATION"
+
+// subscribeMobility subscribes to the location of the UE of a PDU
+// session of SSC mode 2 or 3 in the AMF, so that its anchor follows the
+// UE (TS 23.502 4.15.4.2). The PDU session keeps its anchor when the
+// subscription fails. It runs holding c.mu.
+func (s *SMF) subscribeMobility(ctx context.Context, c *SMContext) {
+	if c.SSCMode != nas.SSCMode2 && c.SSCMode != nas.SSCMode3 {
+		return
+	}
+	uri, err := s.nfs.AMF.SubscribeEvents(ctx, models.AmfEventSubscription{
+		EventList:           []models.AmfEvent{{Type: models.AmfEventLocationReport}},
+		EventNotifyURI:      s.apiRoot() + amfEventsCallbackPrefix + "/" + c.Ref,
+		NotifyCorrelationID: c.Ref,
+		NfID:                s.config.InstanceID,
+		Supi:                c.SUPI,
+	})
+	if err != nil {
+		c.log.Warn("Failed to subscribe to the location of the UE", zap.Error(err))
+		return
+	}
+	c.MobilitySubscription = uri
+}
+
+// unsubscribeMobility deletes the subscription to the location of the
+// UE of a PDU session. It runs holding c.mu.
+func (s *SMF) unsubscribeMobility(ctx context.Context, c *SMContext) {
+	if c.MobilitySubscription == "" {
+		return
+	}
+	if err := s.nfs.AMF.UnsubscribeEvents(ctx, c.MobilitySubscription); err != nil {
+		c.log.Warn("Failed to unsubscribe from the location of the UE", zap.Error(err))
+	}
+	c.MobilitySubscription = ""
+}
+
+// moved applies a move of the UE of a PDU session to a tracking area.
+// A PDU session of SSC mode 2 or 3 anchored away from the locality of
+// the UE moves to an anchor of that locality when there is one (TS
+// 23.502 4.3.5): SSC mode 2 releases it for the UE to establish it
+// again, SSC mode 3 asks the UE to establish a new one and keeps it for
+// the address lifetime. It runs holding c.mu.
+func (s *SMF) moved(ctx context.Context, c *SMContext, tai models.Tai) {
+	c.TAI = &tai
+	if c.SSCMode == nas.SSCMode1 || c.relocation != nil {
+		return
+	}
+	anchor := c.anchor()
+	if !s.upfs.closerAnchor(ctx, anchor, c.DNN, c.SNSSAI, c.TAI) {
+		return
+	}
+
+	log := c.log.With(zap.String("upf", anchor.NodeID), zap.String("tac", tai.Tac))
+	switch c.SSCMode {
+	case nas.SSCMode2:
+		log.Info("Releasing PDU session to anchor it closer to the UE")
+		s.releaseByNetwork(ctx, c, nas.Cause5GSMReactivationRequested, statusReactivation)
+
+	case nas.SSCMode3:
+		cause := nas.Cause5GSMReactivationRequested
+		err := s.sendN1(ctx, c, &nas.PDUSessionModificationCommand{
+			SMHeader: nas.SMHeader{PDUSessionID: c.PDUSessionID},
+			Cause:    &cause,
+		})
+		if err != nil {
+			log.Warn("Failed to ask the UE for a PDU session anchored closer to it", zap.Error(err))
+			return
+		}
+		c.relocation = time.AfterFunc(s.config.SSC3AddressLifetime, func() { s.relocated(c) })
+		log.Info("UE asked for a PDU session anchored closer to it",
+			zap.Duration("address_lifetime", s.config.SSC3AddressLifetime))
+	}
+}
+
+// relocated releases a PDU session of SSC mode 3 at the end of its
+// address lifetime
+func (s *SMF) relocated(c *SMContext) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.released {
+		s.releaseByNetwork(context.Background(), c, nas.Cause5GSMRegularDeactivation, "")
+	}
+}
@@ -0,0 +1,417 @@
+package smf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/pfcp"
+)
+
+// rejectionCause returns the 5GSM cause of an establishment step
+// rejected, 0 for none
+func rejectionCause(err error) nas.Cause5GSM {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.cause
+	}
+	return 0
+}
+
+func TestSSCMode(t *testing.T) {
+	mode := func(m nas.SSCMode) *nas.SSCMode { return &m }
+	tests := []struct {
+		name      string
+		requested *nas.SSCMode
+		sub       models.SscModes
+		want      nas.SSCMode
+		wantCause nas.Cause5GSM
+	}{
+		{name: "nothing subscribed", want: nas.SSCMode1},
+		{name: "subscribed default", sub: models.SscModes{DefaultSscMode: models.SscMode3}, want: nas.SSCMode3},
+		{
+			name:      "requested default",
+			requested: mode(nas.SSCMode2),
+			sub:       models.SscModes{DefaultSscMode: models.SscMode2},
+			want:      nas.SSCMode2,
+		},
+		{
+			name:      "requested allowed",
+			requested: mode(nas.SSCMode3),
+			sub:       models.SscModes{DefaultSscMode: models.SscMode1, AllowedSscModes: []models.SscMode{models.SscMode3}},
+			want:      nas.SSCMode3,
+		},
+		{name: "requested without subscription", requested: mode(nas.SSCMode2), want: nas.SSCMode2},
+		{
+			name:      "requested not allowed",
+			requested: mode(nas.SSCMode2),
+			sub:       models.SscModes{DefaultSscMode: models.SscMode1, AllowedSscModes: []models.SscMode{models.SscMode3}},
+			wantCause: nas.Cause5GSMNotSupportedSSCMode,
+		},
+		{name: "requested unknown", requested: mode(4), wantCause: nas.Cause5GSMNotSupportedSSCMode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := establishmentRequest(1)
+			req.SSCMode = tt.requested
+			got, err := sscMode(req, tt.sub)
+			if cause := rejectionCause(err); cause != tt.wantCause || err != nil && cause == 0 {
+				t.Fatalf("error = %v, want cause %s", err, tt.wantCause)
+			}
+			if got != tt.want {
+				t.Errorf("SSC mode = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionType(t *testing.T) {
+	sessionType := func(t nas.PDUSessionType) *nas.PDUSessionType { return &t }
+	types := func(def models.PduSessionType, allowed ...models.PduSessionType) models.PduSessionTypes {
+		return models.PduSessionTypes{DefaultSessionType: def, AllowedSessionTypes: allowed}
+	}
+	dualStack := testPool(t, "dual", "", nil, "10.60.0.1", "10.60.0.254", "2001:db8:60::", 48)
+	v4 := testPool(t, "v4", "", nil, "10.60.0.1", "10.60.0.254", "", 0)
+	v6 := testPool(t, "v6", "", nil, "", "", "2001:db8:60::", 48)
+
+	tests := []struct {
+		name      string
+		pool      PoolConfig
+		requested *nas.PDUSessionType
+		dnn       models.DnnConfiguration
+
+		want nas.PDUSessionType
+		// wantAccepted is the cause given with the accept, wantCause
+		// the one of the reject
+		wantAccepted nas.Cause5GSM
+		wantCause    nas.Cause5GSM
+	}{
+		{name: "IP by default", pool: dualStack, want: nas.PDUSessionTypeIPv4},
+		{
+			name: "subscribed default",
+			pool: dualStack,
+			dnn:  models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv6)},
+			want: nas.PDUSessionTypeIPv6,
+		},
+		{
+			name:      "IPv4v6",
+			pool:      dualStack,
+			requested: sessionType(nas.PDUSessionTypeIPv4v6),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4v6)},
+			want:      nas.PDUSessionTypeIPv4v6,
+		},
+		{
+			name:         "IPv4v6 without IPv6 pool",
+			pool:         v4,
+			requested:    sessionType(nas.PDUSessionTypeIPv4v6),
+			dnn:          models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4v6)},
+			want:         nas.PDUSessionTypeIPv4,
+			wantAccepted: nas.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+		{
+			name:         "IPv4v6 without IPv4 subscribed",
+			pool:         dualStack,
+			requested:    sessionType(nas.PDUSessionTypeIPv4v6),
+			dnn:          models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv6)},
+			want:         nas.PDUSessionTypeIPv6,
+			wantAccepted: nas.Cause5GSMPDUSessionTypeIPv6OnlyAllowed,
+		},
+		{
+			name:      "IPv4 with static address",
+			pool:      v6,
+			requested: sessionType(nas.PDUSessionTypeIPv4),
+			dnn: models.DnnConfiguration{
+				PduSessionTypes: types(models.PduSessionTypeIPv4),
+				StaticIPAddress: []models.IPAddress{{Ipv4Addr: "10.70.0.1"}},
+			},
+			want: nas.PDUSessionTypeIPv4,
+		},
+		{
+			name:      "IPv6 without pool",
+			pool:      v4,
+			requested: sessionType(nas.PDUSessionTypeIPv6),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4v6)},
+			wantCause: nas.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+		{
+			name:      "Ethernet",
+			pool:      v4,
+			requested: sessionType(nas.PDUSessionTypeEthernet),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4, models.PduSessionTypeEthernet)},
+			want:      nas.PDUSessionTypeEthernet,
+		},
+		{
+			name:      "Unstructured",
+			pool:      v4,
+			requested: sessionType(nas.PDUSessionTypeUnstructured),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeUnstructured)},
+			want:      nas.PDUSessionTypeUnstructured,
+		},
+		{
+			name:      "Ethernet not subscribed",
+			pool:      v4,
+			requested: sessionType(nas.PDUSessionTypeEthernet),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4, models.PduSessionTypeIPv6)},
+			wantCause: nas.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
+		},
+		{
+			name:      "Unstructured not subscribed",
+			pool:      dualStack,
+			requested: sessionType(nas.PDUSessionTypeUnstructured),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeIPv4v6)},
+			wantCause: nas.Cause5GSMUnknownPDUSessionType,
+		},
+		{
+			name:      "IPv4 with Ethernet subscribed",
+			pool:      dualStack,
+			requested: sessionType(nas.PDUSessionTypeIPv4),
+			dnn:       models.DnnConfiguration{PduSessionTypes: types(models.PduSessionTypeEthernet)},
+			wantCause: nas.Cause5GSMPDUSessionTypeEthernetOnlyAllowed,
+		},
+		{
+			name:      "unknown",
+			pool:      dualStack,
+			requested: sessionType(7),
+			wantCause: nas.Cause5GSMUnknownPDUSessionType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SMF{ipam: newTestIPAM(t, 0, nil, tt.pool)}
+			c := &SMContext{DNN: "internet", SNSSAI: testSNSSAI}
+			req := establishmentRequest(1)
+			req.PDUSessionType = tt.requested
+
+			got, accepted, err := s.sessionType(c, req, tt.dnn)
+			if cause := rejectionCause(err); cause != tt.wantCause || err != nil && cause == 0 {
+				t.Fatalf("error = %v, want cause %s", err, tt.wantCause)
+			}
+			if got != tt.want {
+				t.Errorf("PDU session type = %s, want %s", got, tt.want)
+			}
+			if accepted == nil && tt.wantAccepted != 0 || accepted != nil && *accepted != tt.wantAccepted {
+				t.Errorf("accept cause = %v, want %s", accepted, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestEstablishmentSSCMode(t *testing.T) {
+	mode := func(m nas.SSCMode) *nas.SSCMode { return &m }
+	tests := []struct {
+		name      string
+		requested *nas.SSCMode
+		allowed   []models.SscMode
+
+		want      nas.SSCMode
+		wantCause nas.Cause5GSM
+		wantCalls []string
+	}{
+		{
+			name:      "subscribed default",
+			want:      nas.SSCMode1,
+			wantCalls: []string{"UDM.GetSMData", "PCF.CreateSMPolicy", "AMF.N1N2MessageTransfer"},
+		},
+		{
+			name:      "SSC mode 3",
+			requested: mode(nas.SSCMode3),
+			want:      nas.SSCMode3,
+			wantCalls: []string{"UDM.GetSMData", "PCF.CreateSMPolicy", "AMF.N1N2MessageTransfer", "AMF.SubscribeEvents"},
+		},
+		{
+			name:      "SSC mode not allowed",
+			requested: mode(nas.SSCMode2),
+			allowed:   []models.SscMode{models.SscMode3},
+			wantCause: nas.Cause5GSMNotSupportedSSCMode,
+			wantCalls: []string{"UDM.GetSMData", "AMF.N1N2MessageTransfer", "AMF.NotifySMContextStatus"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, nil)
+			smData := subscription("internet")
+			if tt.allowed != nil {
+				dnn := smData[0].DnnConfigurations["internet"]
+				dnn.SscModes.AllowedSscModes = tt.allowed
+				smData[0].DnnConfigurations["internet"] = dnn
+			}
+			h.nfs.mu.Lock()
+			h.nfs.smData = smData
+			h.nfs.mu.Unlock()
+
+			req := establishmentRequest(1)
+			req.SSCMode = tt.requested
+			ref, _, err := h.createSMContext(t, h.createData("internet", req), req)
+			if err != nil {
+				t.Fatalf("creating SM context: %v", err)
+			}
+			tr := h.nfs.recvTransfer(t)
+			if tt.wantCause != 0 {
+				rej, ok := tr.n1.(*nas.PDUSessionEstablishmentReject)
+				if !ok || rej.Cause != tt.wantCause {
+					t.Fatalf("N1 message = %#v, want reject with cause %s", tr.n1, tt.wantCause)
+				}
+				h.nfs.recvNotification(t)
+			} else {
+				accept, ok := tr.n1.(*nas.PDUSessionEstablishmentAccept)
+				if !ok || accept.SSCMode != tt.want {
+					t.Fatalf("N1 message = %#v, want accept with SSC mode %d", tr.n1, tt.want)
+				}
+				if c := h.context(t, ref); c.SSCMode != tt.want {
+					t.Errorf("SSC mode = %d, want %d", c.SSCMode, tt.want)
+				}
+			}
+			if calls := h.nfs.takeCalls(); strings.Join(calls, " ") != strings.Join(tt.wantCalls, " ") {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+// notifyLocation posts an AMF location report of a UE in a tracking area
+// for an SM context
+func (h *harness) notifyLocation(ref, tac string) error {
+	return h.client.Post(context.Background(), h.url+amfEventsCallbackPrefix+"/"+ref, models.AmfEventNotification{
+		NotifyCorrelationID: ref,
+		ReportList: []models.AmfEventReport{{
+			Type:     models.AmfEventLocationReport,
+			Location: &models.UserLocation{NrLocation: &models.NrLocation{Tai: models.Tai{PlmnID: testPLMN, Tac: tac}}},
+		}},
+	}, nil)
+}
+
+func TestSSCRelocation(t *testing.T) {
+	tests := []struct {
+		name string
+		mode nas.SSCMode
+		tac  string
+
+		// wantCommand is the cause of the command sent to the UE, 0 for
+		// none, and wantReleased tells that the command is the release
+		// of the session, notified with wantStatus
+		wantCommand  nas.Cause5GSM
+		wantReleased bool
+		wantStatus   string
+	}{
+		{name: "SSC mode 1", mode: nas.SSCMode1, tac: "000002"},
+		{name: "SSC mode 2 in the same locality", mode: nas.SSCMode2, tac: "000001"},
+		{
+			name:         "SSC mode 2",
+			mode:         nas.SSCMode2,
+			tac:          "000002",
+			wantCommand:  nas.Cause5GSMReactivationRequested,
+			wantReleased: true,
+			wantStatus:   statusReactivation,
+		},
+		{
+			name:        "SSC mode 3",
+			mode:        nas.SSCMode3,
+			tac:         "000002",
+			wantCommand: nas.Cause5GSMReactivationRequested,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paris := newFakeUPF(t, "upf-paris", "paris", "10.100.0.1", "")
+			lyon := newFakeUPF(t, "upf-lyon", "lyon", "10.100.0.2", "")
+			h := newHarness(t, func(cfg *Config) { cfg.SSC3AddressLifetime = 200 * time.Millisecond }, paris, lyon)
+
+			req := establishmentRequest(1)
+			req.SSCMode = &tt.mode
+			c, _, _ := h.establish(t, "internet", req)
+			if c.UPF.NodeID != "upf-paris" {
+				t.Fatalf("anchor = %s, want upf-paris", c.UPF.NodeID)
+			}
+			h.nfs.takeCalls()
+			paris.takeRequests()
+
+			if err := h.notifyLocation(c.Ref, tt.tac); err != nil {
+				t.Fatalf("location notification: %v", err)
+			}
+			if tt.wantCommand == 0 {
+				select {
+				case tr := <-h.nfs.transfers:
+					t.Errorf("N1N2 message transfer of %T", tr.n1)
+				default:
+				}
+				if _, ok := h.smf.SMContext(c.Ref); !ok {
+					t.Error("SM context released")
+				}
+				return
+			}
+
+			tr := h.nfs.recvTransfer(t)
+			switch m := tr.n1.(type) {
+			case *nas.PDUSessionReleaseCommand:
+				if !tt.wantReleased || m.Cause != tt.wantCommand {
+					t.Errorf("release command with cause %s", m.Cause)
+				}
+			case *nas.PDUSessionModificationCommand:
+				if tt.wantReleased || m.Cause == nil || *m.Cause != tt.wantCommand {
+					t.Errorf("modification command with cause %v", m.Cause)
+				}
+			default:
+				t.Fatalf("N1 message = %T", tr.n1)
+			}
+
+			if tt.mode == nas.SSCMode3 {
+				// The PDU session is kept for the lifetime of its address,
+				// moves of the UE in the meantime being ignored
+				if _, ok := h.smf.SMContext(c.Ref); !ok {
+					t.Fatal("SM context released before the address lifetime")
+				}
+				if err := h.notifyLocation(c.Ref, tt.tac); err != nil {
+					t.Fatalf("location notification: %v", err)
+				}
+
+				tr := h.nfs.recvTransfer(t)
+				cmd, ok := tr.n1.(*nas.PDUSessionReleaseCommand)
+				if !ok || cmd.Cause != nas.Cause5GSMRegularDeactivation {
+					t.Fatalf("N1 message = %#v, want release command", tr.n1)
+				}
+			}
+
+			n := h.nfs.recvNotification(t)
+			if n.StatusInfo.ResourceStatus != models.ResourceStatusReleased || n.StatusInfo.Cause != tt.wantStatus {
+				t.Errorf("status = %+v, want released with cause %q", n.StatusInfo, tt.wantStatus)
+			}
+			if _, ok := h.smf.SMContext(c.Ref); ok {
+				t.Error("released SM context kept")
+			}
+			requests := paris.takeRequests()
+			if len(requests) != 1 || requests[0].MessageType() != pfcp.MsgSessionDeletionRequest {
+				t.Errorf("requests of upf-paris = %v, want deletion", requests)
+			}
+			if calls := strings.Join(h.nfs.takeCalls(), " "); !strings.Contains(calls, "AMF.UnsubscribeEvents") {
+				t.Errorf("calls = %s, want unsubscription", calls)
+			}
+		})
+	}
+}
+
+func TestAMFEventNotificationResources(t *testing.T) {
+	h := newHarness(t, nil)
+	c, _, _ := h.establish(t, "internet", establishmentRequest(1))
+
+	tests := []struct {
+		name       string
+		ref        string
+		wantStatus int
+	}{
+		{name: "unknown context", ref: "unknown", wantStatus: http.StatusNotFound},
+		{name: "sub-resource", ref: c.Ref + "/location", wantStatus: http.StatusNotFound},
+		{name: "no resource", ref: "", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := h.notifyLocation(tt.ref, "000002"); statusCode(err) != tt.wantStatus {
+				t.Errorf("error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package smf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// AddressStore keeps the UE address leases of the SMF across restarts
+type AddressStore interface {
+	// Load returns every saved lease
+	Load(ctx context.Context) ([]Lease, error)
+
+	// Save writes a lease, replacing the one of the same address
+	Save(ctx context.Context, l Lease) error
+
+	// Delete removes the lease of an address
+	Delete(ctx context.Context, l Lease) error
+}
+
+// NewAddressStore creates the lease store of the configured database,
+// nil when leases are kept in memory only
+func NewAddressStore(cfg *config.Config) (AddressStore, error) {
+	db := cfg.Database
+	switch db.Type {
+	case "", "memory":
+		return nil, nil
+	case "redis":
+		client := redis.NewClient(&redis.Options{
+			Addr:         net.JoinHostPort(db.Host, strconv.Itoa(db.Port)),
+			Username:     db.Username,
+			Password:     db.Password,
+			DialTimeout:  redisTimeout,
+			ReadTimeout:  redisTimeout,
+			WriteTimeout: redisTimeout,
+		})
+		return &redisStore{
+			client: client,
+			key:    fmt.Sprintf("%s:smf:%s:leases", db.Name, cfg.NetworkFunction.InstanceID),
+		}, nil
+	default:
+		return nil, fmt.Errorf("database type %s not supported for address persistence", db.Type)
+	}
+}
+
+// redisTimeout bounds the dial and each read and write of a Redis command
+const redisTimeout = 5 * time.Second
+
+// redisStore keeps the leases in a Redis hash, by pool and address
+type redisStore struct {
+	client *redis.Client
+	key    string
+}
+
+// Load implements AddressStore
+func (s *redisStore) Load(ctx context.Context) ([]Lease, error) {
+	fields, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis HGETALL: %w", err)
+	}
+	leases := make([]Lease, 0, len(fields))
+	for field, v := range fields {
+		var l Lease
+		if err := json.Unmarshal([]byte(v), &l); err != nil {
+			return nil, fmt.Errorf("decoding lease %s: %w", field, err)
+		}
+		leases = append(leases, l)
+	}
+	return leases, nil
+}
+
+// Save implements AddressStore
+func (s *redisStore) Save(ctx context.Context, l Lease) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if err := s.client.HSet(ctx, s.key, l.key(), b).Err(); err != nil {
+		return fmt.Errorf("redis HSET: %w", err)
+	}
+	return nil
+}
+
+// Delete implements AddressStore
+func (s *redisStore) Delete(ctx context.Context, l Lease) error {
+	if err := s.client.HDel(ctx, s.key, l.key()).Err(); err != nil {
+		return fmt.Errorf("redis HDEL: %w", err)
+	}
+	return nil
+}
+
+// Ping checks that Redis answers, for the database health check
+func (s *redisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
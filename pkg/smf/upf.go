@@ -0,0 +1,192 @@
+package smf
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/nas"
+	"github.com/0had0/5G-core/pkg/ngap"
+	"github.com/0had0/5G-core/pkg/pfcp"
+)
+
+// UPF is a UPF the SMF sets up PDU sessions in
+type UPF struct {
+	NodeID string
+
+	// InstanceID is the NF instance ID of a UPF discovered in the NRF
+	InstanceID string
+
+	// N4Address is the PFCP endpoint of the UPF, host:port
+	N4Address string
+
+	// N3Address is the GTP-U address of the UPF reached by gNBs
+	N3Address net.IP
+
+	// N9Address is the GTP-U address of the UPF reached by other UPFs,
+	// nil when it cannot be chained to another UPF
+	N9Address net.IP
+
+	// DNNs and SNSSAIs hold the served DNNs and slices, all when empty
+	DNNs    []string
+	SNSSAIs []models.Snssai
+
+	// Locality is where the UPF is, matched against the UE locality
+	Locality string
+
+	mu       sync.Mutex
+	nextTEID uint32
+	load     int           // advertised in the NRF, 0-100
+	capacity int           // advertised in the NRF, 100 when unknown
+	rtt      time.Duration // smoothed PFCP heartbeat round trip time
+	down     bool          // the last heartbeat failed
+	sessions int           // N4 sessions set up by the SMF
+}
+
+// ServesDNN reports whether the UPF serves a DNN
+func (u *UPF) ServesDNN(dnn string) bool {
+	if len(u.DNNs) == 0 {
+		return true
+	}
+	for _, d := range u.DNNs {
+		if d == dnn {
+			return true
+		}
+	}
+	return false
+}
+
+// ServesSlice reports whether the UPF serves a slice
+func (u *UPF) ServesSlice(snssai models.Snssai) bool {
+	if len(u.SNSSAIs) == 0 {
+		return true
+	}
+	for _, s := range u.SNSSAIs {
+		if s == snssai {
+			return true
+		}
+	}
+	return false
+}
+
+// allocateTEID returns a new TEID of the GTP-U interfaces of the UPF.
+// TEID 0 is reserved for signalling.
+func (u *UPF) allocateTEID() uint32 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.nextTEID++
+	if u.nextTEID == 0 {
+		u.nextTEID++
+	}
+	return u.nextTEID
+}
+
+// addSessions counts N4 sessions set up, or removed when n is negative
+func (u *UPF) addSessions(n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.sessions += n
+}
+
+// N4Session is a PDU session as set up in its UPF. Uplink packets of the
+// UL tunnel go to the data network, downlink packets to the UE address
+// go to the DL tunnel, or are buffered while there is none.
+type N4Session struct {
+	// SEIDs of the session in the SMF and in the UPF
+	LocalSEID  uint64
+	RemoteSEID uint64
+
+	DNN  string
+	Type nas.PDUSessionType
+
+	// UEAddress is the IPv4 address and UEPrefix the IPv6 prefix of the
+	// UE, nil when the session type has none. Downlink packets of
+	// Ethernet sessions are matched by the MAC addresses the UPF learns,
+	// those of Unstructured sessions are all the packets of the DNN.
+	UEAddress net.IP
+	UEPrefix  *net.IPNet
+
+	// ULTunnel is where the UPF receives uplink packets: its N3
+	// endpoint, or its N9 endpoint when it anchors the session behind an
+	// I-UPF
+	ULTunnel ngap.GTPTunnel
+
+	// DLTunnel is where the UPF sends downlink packets: the N3 endpoint
+	// of the gNB, nil while the user plane connection is deactivated, or
+	// the N9Tunnel of the I-UPF in front of the anchor
+	DLTunnel *ngap.GTPTunnel
+
+	// Uplink is the ULTunnel of the anchor an I-UPF sends uplink packets
+	// to, nil when the UPF sends them to the data network
+	Uplink *ngap.GTPTunnel
+
+	// N9Tunnel is where an I-UPF receives the downlink packets of the
+	// anchor, nil for other UPFs
+	N9Tunnel *ngap.GTPTunnel
+
+	// AMBR is the session AMBR enforced by the UPF
+	AMBR ngap.UEAggregateMaximumBitRate
+
+	// Rules classify packets into the Flows, the others going to the
+	// default QoS flow
+	Rules []PCCRule
+	Flows []QoSFlow
+
+	// Reporting has the UPF measure the traffic of each QoS rule, nil
+	// when it reports no usage
+	Reporting *UsageReporting
+}
+
+// UsageReporting is when a UPF reports the usage of a QoS rule: once its
+// traffic or time reaches a threshold, 0 for none, and when the rule or
+// the session is removed
+type UsageReporting struct {
+	VolumeThreshold uint64
+	TimeThreshold   time.Duration
+}
+
+// UsageReport is the traffic of a QoS rule of an N4 session measured by
+// its UPF since the previous report
+type UsageReport struct {
+	RuleID  uint8
+	Trigger pfcp.UsageReportTrigger
+
+	Start time.Time
+	End   time.Time
+
+	// Volumes in octets
+	Total    uint64
+	Uplink   uint64
+	Downlink uint64
+
+	Duration time.Duration
+}
+
+// ReportHandler receives the usage reports of an N4 session, by its
+// local SEID
+type ReportHandler func(seid uint64, reports []UsageReport)
+
+// N4 sets up the PDU sessions in the UPFs (TS 29.244)
+type N4 interface {
+	// EstablishSession creates the session in the UPF and sets its
+	// remote SEID
+	EstablishSession(ctx context.Context, upf *UPF, s *N4Session) error
+
+	// ModifySession updates the session in the UPF, e.g. with a new DL
+	// tunnel or QoS flows
+	ModifySession(ctx context.Context, upf *UPF, s *N4Session) error
+
+	// DeleteSession removes the session from the UPF
+	DeleteSession(ctx context.Context, upf *UPF, s *N4Session) error
+
+	// Heartbeat checks that the UPF answers
+	Heartbeat(ctx context.Context, upf *UPF) error
+
+	// SetReportHandler sets the handler of the usage reports, which gets
+	// those of modified and deleted sessions before ModifySession and
+	// DeleteSession return
+	SetReportHandler(h ReportHandler)
+}
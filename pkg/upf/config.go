@@ -0,0 +1,177 @@
+// Package upf implements the User Plane Function: the N4 sessions set up
+// by the SMFs over PFCP and the userspace GTP-U forwarding between the
+// N3 and N9 tunnels and the data networks of N6.
+package upf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/0had0/5G-core/pkg/common/config"
+)
+
+// Names of the interfaces in the configuration file
+const (
+	interfaceN3 = "n3"
+	interfaceN4 = "n4"
+)
+
+// maxDeviceName is the longest name of a network device on Linux
+const maxDeviceName = 15
+
+// maxQFI is the highest QFI (TS 38.415 5.5.3.3)
+const maxQFI = 63
+
+// Config holds the UPF settings derived from the configuration file
+type Config struct {
+	// InstanceID is the NF instance ID of the UPF
+	InstanceID string
+
+	// NodeID is the IP address or FQDN of the UPF given to the SMFs
+	NodeID string
+
+	// N3Address is the local GTP-U endpoint, host:port, of the tunnels
+	// with the gNBs and with the other UPFs over N9
+	N3Address string
+
+	// N4Address is the local PFCP endpoint, host:port
+	N4Address string
+
+	// DataNetworks holds the data networks reached over N6
+	DataNetworks []DataNetwork
+
+	// BufferedPackets is how many downlink packets are buffered for a
+	// session while its UE is idle, unless its BAR suggests another
+	// number
+	BufferedPackets int
+
+	// QoSProfiles holds the bit rates of the QoS flows by QFI, enforced
+	// when their QERs give none
+	QoSProfiles map[uint8]QoSProfile
+}
+
+// QoSProfile holds the bit rates of a QoS flow in bps, 0 when unset
+type QoSProfile struct {
+	GBR uint64
+	MBR uint64
+}
+
+// DataNetwork is a data network reached over N6
+type DataNetwork struct {
+	// Name is the DNN, the network instance of the PDRs and FARs
+	Name string
+
+	// Device is the TUN device of the data network
+	Device string
+
+	// Pools holds the UE addresses routed to the device
+	Pools []*net.IPNet
+}
+
+// NewConfig builds the UPF settings from the application configuration
+func NewConfig(cfg *config.Config) (*Config, error) {
+	upf := cfg.UPF
+
+	c := &Config{
+		InstanceID:      cfg.NetworkFunction.InstanceID,
+		NodeID:          upf.NodeID,
+		BufferedPackets: upf.BufferedPackets,
+	}
+	if c.NodeID == "" {
+		c.NodeID = c.InstanceID
+	}
+	if c.NodeID == "" {
+		return nil, fmt.Errorf("no node ID configured")
+	}
+	if c.BufferedPackets < 0 {
+		return nil, fmt.Errorf("negative number of buffered packets")
+	}
+
+	for _, i := range upf.Interfaces {
+		if len(i.Endpoints) == 0 {
+			return nil, fmt.Errorf("interface %s without endpoint", i.Name)
+		}
+		if _, _, err := net.SplitHostPort(i.Endpoints[0]); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q of interface %s", i.Endpoints[0], i.Name)
+		}
+		switch strings.ToLower(i.Name) {
+		case interfaceN3:
+			c.N3Address = i.Endpoints[0]
+		case interfaceN4:
+			c.N4Address = i.Endpoints[0]
+		}
+	}
+	if c.N3Address == "" || c.N4Address == "" {
+		return nil, fmt.Errorf("no N3 or N4 endpoint configured")
+	}
+
+	if len(upf.DataNetworks) == 0 {
+		return nil, fmt.Errorf("no data network configured")
+	}
+	for _, d := range upf.DataNetworks {
+		dn := DataNetwork{Name: d.Name, Device: d.Device}
+		if dn.Name == "" {
+			return nil, fmt.Errorf("data network without name")
+		}
+		if dn.Device == "" {
+			dn.Device = dn.Name
+		}
+		if len(dn.Device) > maxDeviceName {
+			return nil, fmt.Errorf("device name %q of data network %s too long", dn.Device, dn.Name)
+		}
+		for _, p := range d.Pools {
+			_, prefix, err := net.ParseCIDR(p.CIDR)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pool %q of data network %s", p.CIDR, dn.Name)
+			}
+			dn.Pools = append(dn.Pools, prefix)
+		}
+		for _, other := range c.DataNetworks {
+			if other.Name == dn.Name || other.Device == dn.Device {
+				return nil, fmt.Errorf("data networks %s and %s share a name or device", other.Name, dn.Name)
+			}
+		}
+		c.DataNetworks = append(c.DataNetworks, dn)
+	}
+
+	c.QoSProfiles = make(map[uint8]QoSProfile)
+	for _, p := range upf.QosProfiles {
+		if p.ID < 1 || p.ID > maxQFI {
+			return nil, fmt.Errorf("QoS profile %d is not a QFI", p.ID)
+		}
+		if _, ok := c.QoSProfiles[uint8(p.ID)]; ok {
+			return nil, fmt.Errorf("QoS profile %d configured twice", p.ID)
+		}
+		if p.MaximumBitrate != 0 && p.MaximumBitrate < p.GuaranteedBitrate {
+			return nil, fmt.Errorf("QoS profile %d guarantees more than its maximum bit rate", p.ID)
+		}
+		c.QoSProfiles[uint8(p.ID)] = QoSProfile{GBR: p.GuaranteedBitrate, MBR: p.MaximumBitrate}
+	}
+
+	return c, nil
+}
+
+// dataNetwork returns the data network of a network instance or, when
+// it is empty, of the pools holding a UE address. The only data network
+// is returned when none matches.
+func (c *Config) dataNetwork(instance string, ue net.IP) (string, bool) {
+	for _, dn := range c.DataNetworks {
+		if instance != "" && dn.Name == instance {
+			return dn.Name, true
+		}
+	}
+	if instance == "" && ue != nil {
+		for _, dn := range c.DataNetworks {
+			for _, p := range dn.Pools {
+				if p.Contains(ue) {
+					return dn.Name, true
+				}
+			}
+		}
+	}
+	if len(c.DataNetworks) == 1 && instance == "" {
+		return c.DataNetworks[0].Name, true
+	}
+	return "", false
+}
@@ -0,0 +1,413 @@
+package upf
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/gtpu"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// Reasons packets are dropped for
+const (
+	dropMalformed  = "malformed"
+	dropNoSession  = "no_session"
+	dropNoRule     = "no_rule"
+	dropAction     = "far_drop"
+	dropBufferFull = "buffer_full"
+	dropDiscarded  = "discarded"
+	dropNoRoute    = "no_route"
+	dropGateClosed = "gate_closed"
+	dropPoliced    = "policed"
+)
+
+// handleGTPU handles a GTP-U message received on N3 or N9
+func (u *UPF) handleGTPU(b []byte, peer *net.UDPAddr) {
+	h, payload, err := gtpu.Decode(b)
+	if err != nil {
+		u.dropped(metrics.DirectionUplink, dropMalformed, len(b))
+		return
+	}
+
+	switch h.Type {
+	case gtpu.MsgEchoRequest:
+		if rsp, err := gtpu.EchoResponse(h); err == nil {
+			u.n3.WriteTo(rsp, peer)
+		}
+	case gtpu.MsgGPDU:
+		u.handleGPDU(h, payload, peer)
+	case gtpu.MsgEndMarker:
+		u.handleEndMarker(h)
+	case gtpu.MsgErrorIndication:
+		u.handleErrorIndication(payload, peer)
+	}
+}
+
+// handleGPDU forwards the T-PDU of a G-PDU as the PDR of its tunnel
+// matching it tells. An error indication answers the G-PDUs of unknown
+// tunnels.
+func (u *UPF) handleGPDU(h gtpu.Header, pkt []byte, peer *net.UDPAddr) {
+	s := u.sessionOfTEID(h.TEID)
+	if s == nil {
+		u.dropped(metrics.DirectionUplink, dropNoSession, len(pkt))
+		if u.n3IP != nil {
+			if ind, err := gtpu.ErrorIndication(h.TEID, u.n3IP); err == nil {
+				u.n3.WriteTo(ind, peer)
+			}
+		}
+		return
+	}
+
+	ip, isIP := parsePacket(pkt)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.order {
+		if p.matchesTunnel(h.TEID, h.PDUSession) && p.matchesPacket(&ip, isIP) {
+			u.apply(s, p, pkt, s.qfi(p, h.PDUSession))
+			return
+		}
+	}
+	u.dropped(metrics.DirectionUplink, dropNoRule, len(pkt))
+}
+
+// handleN6 forwards a packet of a data network as the PDR of the session
+// of its UE matching it tells
+func (u *UPF) handleN6(dn string, pkt []byte) {
+	ip, ok := parsePacket(pkt)
+	if !ok {
+		u.dropped(metrics.DirectionDownlink, dropMalformed, len(pkt))
+		return
+	}
+	s := u.sessionOfUE(ip.dst)
+	if s == nil {
+		u.dropped(metrics.DirectionDownlink, dropNoSession, len(pkt))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.order {
+		if p.matchesN6(dn) && p.matchesPacket(&ip, true) {
+			u.apply(s, p, pkt, s.qfi(p, nil))
+			return
+		}
+	}
+	u.dropped(metrics.DirectionDownlink, dropNoRule, len(pkt))
+}
+
+// handleEndMarker relays an end marker along the tunnel the PDR of its
+// tunnel forwards to, as an intermediate UPF does when the anchor switches
+// the downlink path
+func (u *UPF) handleEndMarker(h gtpu.Header) {
+	s := u.sessionOfTEID(h.TEID)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.order {
+		if !p.matchesTunnel(h.TEID, nil) {
+			continue
+		}
+		far := s.rules.fars[*p.FARID]
+		if far.ApplyAction&pfcp.ApplyActionForward != 0 && far.ForwardingParameters != nil {
+			ohc := far.ForwardingParameters.OuterHeaderCreation
+			if peer := tunnelPeer(ohc); peer != nil {
+				u.sendEndMarker(ohc.TEID, peer)
+			}
+		}
+		return
+	}
+}
+
+// handleErrorIndication reports an error indication to the CP functions
+// of the sessions forwarding to the tunnel it is about
+func (u *UPF) handleErrorIndication(payload []byte, peer *net.UDPAddr) {
+	teid, addr, err := gtpu.DecodeErrorIndication(payload)
+	if err != nil {
+		return
+	}
+	if addr == nil {
+		addr = peer.IP
+	}
+	remote := pfcp.FTEID{TEID: teid}
+	if v4 := addr.To4(); v4 != nil {
+		remote.IPv4 = v4
+	} else {
+		remote.IPv6 = addr
+	}
+
+	for _, s := range u.allSessions() {
+		s.mu.Lock()
+		for _, far := range s.rules.fars {
+			if far.ForwardingParameters == nil {
+				continue
+			}
+			ohc := far.ForwardingParameters.OuterHeaderCreation
+			if to := tunnelPeer(ohc); to != nil && ohc.TEID == teid && to.IP.Equal(addr) {
+				s.log.Warn("Error indication received", zap.Uint32("teid", teid), zap.Stringer("peer", peer))
+				go u.report(s, s.cp.String(), s.cpSEID, &pfcp.SessionReportRequest{
+					ReportType:            pfcp.ReportErrorIndication,
+					ErrorIndicationReport: &pfcp.ErrorIndicationReport{RemoteFTEIDs: []pfcp.FTEID{remote}},
+				})
+				break
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// matchesTunnel reports whether a PDR matches the G-PDUs of a tunnel
+// carrying a PDU session container, nil when there is none
+func (p *pdr) matchesTunnel(teid uint32, pdu *gtpu.PDUSessionContainer) bool {
+	if p.PDI.FTEID == nil || p.PDI.FTEID.TEID != teid {
+		return false
+	}
+	if len(p.PDI.QFIs) == 0 {
+		return true
+	}
+	if pdu == nil {
+		return false
+	}
+	for _, qfi := range p.PDI.QFIs {
+		if qfi == pdu.QFI {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesN6 reports whether a PDR matches the packets of a data network.
+// Ethernet PDRs match none, the devices carrying IP packets only.
+func (p *pdr) matchesN6(dn string) bool {
+	pdi := &p.PDI
+	return pdi.FTEID == nil && !pdi.EthernetPDUSession && (pdi.NetworkInstance == "" || pdi.NetworkInstance == dn)
+}
+
+// matchesPacket reports whether a PDR matches the addresses, protocol and
+// ports of a packet, isIP telling whether it is an IP packet at all
+func (p *pdr) matchesPacket(ip *packet, isIP bool) bool {
+	pdi := &p.PDI
+	if pdi.UEIPAddress != nil {
+		if !isIP {
+			return false
+		}
+		ue := ip.src
+		if pdi.UEIPAddress.Destination {
+			ue = ip.dst
+		}
+		if !(p.ueIPv4.IsValid() && p.ueIPv4 == ue) && !(p.ueIPv6.IsValid() && p.ueIPv6.Contains(ue)) {
+			return false
+		}
+	}
+	if len(p.filters) == 0 {
+		return true
+	}
+	if !isIP {
+		return false
+	}
+	uplink := pdi.SourceInterface == pfcp.InterfaceAccess
+	for i := range p.filters {
+		if p.filters[i].matches(ip, uplink) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply applies the FAR of a PDR to a packet. Forwarded packets go
+// through the QERs of the PDR first and are marked with the QFI of their
+// flow in the tunnels. It runs holding s.mu.
+func (u *UPF) apply(s *session, p *pdr, pkt []byte, qfi uint8) {
+	dir := directionOf(p)
+	far := s.rules.fars[*p.FARID]
+
+	if far.ApplyAction&pfcp.ApplyActionDuplicate != 0 {
+		for _, d := range far.DuplicatingParameters {
+			u.send(d.DestinationInterface, "", d.OuterHeaderCreation, pkt, nil)
+		}
+	}
+
+	switch action := far.ApplyAction; {
+	case action&pfcp.ApplyActionDrop != 0:
+		u.dropped(dir, dropAction, len(pkt))
+
+	case action&pfcp.ApplyActionForward != 0:
+		if reason := u.police(s, p, len(pkt)); reason != "" {
+			u.dropped(dir, reason, len(pkt))
+			if reason == dropPoliced {
+				u.policed(dir, qfi, len(pkt))
+			}
+			return
+		}
+		var pdu *gtpu.PDUSessionContainer
+		if qfi != 0 {
+			pdu = &gtpu.PDUSessionContainer{Uplink: dir == metrics.DirectionUplink, QFI: qfi}
+		}
+		fp := far.ForwardingParameters
+		if fp == nil || !u.send(fp.DestinationInterface, fp.NetworkInstance, fp.OuterHeaderCreation, pkt, pdu) {
+			u.dropped(dir, dropNoRoute, len(pkt))
+			return
+		}
+		u.forwarded(dir, qfi, len(pkt))
+
+	case action&pfcp.ApplyActionBuffer != 0:
+		u.buffer(s, p, far, pkt)
+
+	default:
+		u.dropped(dir, dropAction, len(pkt))
+	}
+}
+
+// send sends a packet through the GTP-U tunnel of an outer header
+// creation, marked with the QFI of a PDU session container when not nil,
+// or to a data network when there is none, reporting whether there was a
+// way to
+func (u *UPF) send(dst pfcp.Interface, instance string, ohc *pfcp.OuterHeaderCreation, pkt []byte,
+	pdu *gtpu.PDUSessionContainer) bool {
+	if ohc != nil {
+		peer := tunnelPeer(ohc)
+		if peer == nil {
+			return false
+		}
+		b, err := gtpu.Encode(gtpu.Header{Type: gtpu.MsgGPDU, TEID: ohc.TEID, PDUSession: pdu}, pkt)
+		if err != nil {
+			return false
+		}
+		_, err = u.n3.WriteTo(b, peer)
+		return err == nil
+	}
+
+	if dst != pfcp.InterfaceCore && dst != pfcp.InterfaceSGiLAN {
+		return false
+	}
+	var ue net.IP
+	if ip, ok := parsePacket(pkt); ok {
+		ue = ip.src.AsSlice()
+	}
+	dn, ok := u.config.dataNetwork(instance, ue)
+	if !ok {
+		return false
+	}
+	_, err := u.n6[dn].Write(pkt)
+	return err == nil
+}
+
+// buffer buffers a downlink packet until the FAR of its PDR forwards or
+// drops it, up to the number of packets the BAR suggests or else the
+// configured one. The CP function is told about the first one when the
+// FAR asks for it. It runs holding s.mu.
+func (u *UPF) buffer(s *session, p *pdr, far *pfcp.CreateFAR, pkt []byte) {
+	limit := u.config.BufferedPackets
+	if bar := s.rules.bar; bar != nil && bar.SuggestedBufferingPackets != nil {
+		limit = int(*bar.SuggestedBufferingPackets)
+	}
+	if len(s.buffer) < limit {
+		s.buffer = append(s.buffer, buffered{pdrID: p.PDRID, pkt: append([]byte(nil), pkt...)})
+	} else {
+		u.dropped(directionOf(p), dropBufferFull, len(pkt))
+	}
+
+	if far.ApplyAction&pfcp.ApplyActionNotifyCP != 0 && !s.notified {
+		u.notifyDownlinkData(s, p.PDRID)
+	}
+}
+
+// flush applies the FARs of the buffered packets again once the FARs stop
+// buffering them, dropping those whose PDR was removed. The CP function is
+// told about the next buffered packets once no FAR buffers anymore. It
+// runs holding s.mu.
+func (u *UPF) flush(s *session) {
+	var kept []buffered
+	for _, b := range s.buffer {
+		p, ok := s.rules.pdrs[b.pdrID]
+		if !ok {
+			u.dropped(metrics.DirectionDownlink, dropNoRule, len(b.pkt))
+			continue
+		}
+		if s.rules.fars[*p.FARID].ApplyAction&pfcp.ApplyActionBuffer != 0 {
+			kept = append(kept, b)
+			continue
+		}
+		u.apply(s, p, b.pkt, s.qfi(p, nil))
+	}
+	s.buffer = kept
+
+	for _, far := range s.rules.fars {
+		if far.ApplyAction&pfcp.ApplyActionBuffer != 0 {
+			return
+		}
+	}
+	s.notified = false
+	if s.notify != nil {
+		s.notify.Stop()
+		s.notify = nil
+	}
+}
+
+// sendEndMarker sends an end marker on a tunnel
+func (u *UPF) sendEndMarker(teid uint32, peer *net.UDPAddr) {
+	if b, err := gtpu.EndMarker(teid); err == nil {
+		u.n3.WriteTo(b, peer)
+	}
+}
+
+// allSessions returns the sessions of the UPF
+func (u *UPF) allSessions() []*session {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	sessions := make([]*session, 0, len(u.sessions))
+	for _, s := range u.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// qfi returns the QFI of the packets of a PDR: the one of its QERs, or
+// else the one of the PDU session container they came in
+func (s *session) qfi(p *pdr, pdu *gtpu.PDUSessionContainer) uint8 {
+	for _, id := range p.QERIDs {
+		if q := s.rules.qers[id]; q != nil && q.QFI != nil {
+			return *q.QFI
+		}
+	}
+	if pdu != nil {
+		return pdu.QFI
+	}
+	return 0
+}
+
+// directionOf returns the direction of the packets of a PDR, nil standing
+// for a removed one of a buffered downlink packet
+func directionOf(p *pdr) string {
+	if p != nil && p.PDI.SourceInterface == pfcp.InterfaceAccess {
+		return metrics.DirectionUplink
+	}
+	return metrics.DirectionDownlink
+}
+
+// forwarded counts a forwarded packet
+func (u *UPF) forwarded(dir string, qfi uint8, n int) {
+	if u.metrics == nil {
+		return
+	}
+	label := strconv.Itoa(int(qfi))
+	u.metrics.Bytes.WithLabelValues(dir, label).Add(float64(n))
+	u.metrics.Packets.WithLabelValues(dir, label).Inc()
+}
+
+// policed counts a packet dropped for exceeding the bit rates of its QERs
+func (u *UPF) policed(dir string, qfi uint8, n int) {
+	if u.metrics != nil {
+		u.metrics.PolicedBytes.WithLabelValues(dir, strconv.Itoa(int(qfi))).Add(float64(n))
+	}
+}
+
+// dropped counts a dropped packet
+func (u *UPF) dropped(dir, reason string, n int) {
+	if u.metrics != nil {
+		u.metrics.DroppedBytes.WithLabelValues(dir, reason).Add(float64(n))
+	}
+}
@@ -0,0 +1,141 @@
+package upf
+
+import (
+	"net"
+	"sync"
+)
+
+// PacketConn carries the GTP-U messages of N3 and N9, one per read or
+// write, as a UDP socket does
+type PacketConn interface {
+	// ReadFrom reads the next message and the address of its sender
+	ReadFrom(b []byte) (int, net.Addr, error)
+
+	// WriteTo sends a message to a peer
+	WriteTo(b []byte, addr net.Addr) (int, error)
+
+	// Close closes the connection, failing the reads waiting for a
+	// message
+	Close() error
+}
+
+// Device carries the packets of a data network over N6, one per read or
+// write, as a TUN device does
+type Device interface {
+	// Read reads the next packet sent to the UEs
+	Read(b []byte) (int, error)
+
+	// Write sends a packet of a UE to the data network
+	Write(b []byte) (int, error)
+
+	// Close closes the device, failing the reads waiting for a packet
+	Close() error
+}
+
+// Datagram is a GTP-U message of a MemoryConn
+type Datagram struct {
+	Data []byte
+	Addr *net.UDPAddr
+}
+
+// MemoryConn is a PacketConn exchanging messages over channels, driving
+// the UPF in tests: the UPF reads the datagrams sent on In and writes its
+// own to Out. Datagrams are dropped when Out is full, as UDP would.
+type MemoryConn struct {
+	In  chan Datagram
+	Out chan Datagram
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewMemoryConn creates a MemoryConn with channels of the given size
+func NewMemoryConn(size int) *MemoryConn {
+	return &MemoryConn{
+		In:     make(chan Datagram, size),
+		Out:    make(chan Datagram, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// ReadFrom implements PacketConn
+func (c *MemoryConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case d := <-c.In:
+		return copy(b, d.Data), d.Addr, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteTo implements PacketConn
+func (c *MemoryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udp, _ := addr.(*net.UDPAddr)
+	d := Datagram{Data: append([]byte(nil), b...), Addr: udp}
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	select {
+	case c.Out <- d:
+	default:
+	}
+	return len(b), nil
+}
+
+// Close implements PacketConn
+func (c *MemoryConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// MemoryDevice is a Device exchanging packets over channels, driving the
+// UPF in tests: the UPF reads the packets sent on In and writes its own
+// to Out. Packets are dropped when Out is full.
+type MemoryDevice struct {
+	In  chan []byte
+	Out chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewMemoryDevice creates a MemoryDevice with channels of the given size
+func NewMemoryDevice(size int) *MemoryDevice {
+	return &MemoryDevice{
+		In:     make(chan []byte, size),
+		Out:    make(chan []byte, size),
+		closed: make(chan struct{}),
+	}
+}
+
+// Read implements Device
+func (d *MemoryDevice) Read(b []byte) (int, error) {
+	select {
+	case p := <-d.In:
+		return copy(b, p), nil
+	case <-d.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+// Write implements Device
+func (d *MemoryDevice) Write(b []byte) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	select {
+	case d.Out <- append([]byte(nil), b...):
+	default:
+	}
+	return len(b), nil
+}
+
+// Close implements Device
+func (d *MemoryDevice) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return nil
+}
@@ -0,0 +1,382 @@
+package upf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// notificationDelayUnit is the unit of the downlink data notification
+// delay of a BAR (TS 29.244 8.2.28)
+const notificationDelayUnit = 50 * time.Millisecond
+
+// handle answers the requests of the CP functions
+func (u *UPF) handle(req *pfcp.Request) (pfcp.Message, uint64) {
+	switch m := req.Message.(type) {
+	case *pfcp.AssociationSetupRequest:
+		return u.associate(req.Peer, m), 0
+
+	case *pfcp.AssociationUpdateRequest:
+		cause := pfcp.CauseRequestAccepted
+		if !u.associated(m.NodeID.String()) {
+			cause = pfcp.CauseNoEstablishedAssociation
+		}
+		return &pfcp.AssociationUpdateResponse{NodeID: u.nodeID, Cause: cause}, 0
+
+	case *pfcp.AssociationReleaseRequest:
+		node := m.NodeID.String()
+		cause := pfcp.CauseRequestAccepted
+		if !u.associated(node) {
+			cause = pfcp.CauseNoEstablishedAssociation
+		}
+		u.mu.Lock()
+		delete(u.associations, node)
+		u.mu.Unlock()
+		n := u.dropSessions(node)
+		u.log.Info("PFCP association released", zap.String("cp_node_id", node), zap.Int("sessions", n))
+		return &pfcp.AssociationReleaseResponse{NodeID: u.nodeID, Cause: cause}, 0
+
+	case *pfcp.SessionEstablishmentRequest:
+		return u.establish(req.Peer, m), m.CPFSEID.SEID
+
+	case *pfcp.SessionModificationRequest:
+		return u.modify(req.Header.SEID, m)
+
+	case *pfcp.SessionDeletionRequest:
+		return u.delete(req.Header.SEID)
+
+	default:
+		return nil, 0
+	}
+}
+
+// associate sets up the association with a CP function. Its sessions
+// are dropped when it sets the association up again, having restarted.
+func (u *UPF) associate(peer *net.UDPAddr, m *pfcp.AssociationSetupRequest) pfcp.Message {
+	node := m.NodeID.String()
+	u.mu.Lock()
+	_, known := u.associations[node]
+	u.associations[node] = &association{peer: peer, recovery: m.RecoveryTimeStamp}
+	u.mu.Unlock()
+
+	log := u.log.With(zap.String("cp_node_id", node), zap.Stringer("peer", peer))
+	if known {
+		n := u.dropSessions(node)
+		log.Warn("PFCP association set up again, sessions dropped", zap.Int("sessions", n))
+	} else {
+		log.Info("PFCP association set up")
+	}
+	return &pfcp.AssociationSetupResponse{
+		NodeID:            u.nodeID,
+		Cause:             pfcp.CauseRequestAccepted,
+		RecoveryTimeStamp: u.node.RecoveryTimeStamp(),
+	}
+}
+
+// associated reports whether there is an association with a CP function
+func (u *UPF) associated(node string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	_, ok := u.associations[node]
+	return ok
+}
+
+// establish creates a session with its rules
+func (u *UPF) establish(peer *net.UDPAddr, m *pfcp.SessionEstablishmentRequest) pfcp.Message {
+	rsp := &pfcp.SessionEstablishmentResponse{NodeID: u.nodeID}
+	node := m.NodeID.String()
+	if !u.associated(node) {
+		rsp.Cause = pfcp.CauseNoEstablishedAssociation
+		return rsp
+	}
+
+	r := newRules()
+	r.bar = m.CreateBAR
+	err := r.createFARs(m.CreateFARs)
+	if err == nil {
+		err = r.createQERs(m.CreateQERs)
+	}
+	if err == nil {
+		err = r.createURRs(m.CreateURRs, false)
+	}
+	if err == nil {
+		err = r.createPDRs(m.CreatePDRs)
+	}
+	if err == nil {
+		err = r.check()
+	}
+
+	s := &session{
+		cpNode: node,
+		cpSEID: m.CPFSEID.SEID,
+		cp:     peer,
+		rules:  r,
+		order:  r.ordered(),
+		meters: make(map[uint32]*meter),
+	}
+	if err == nil {
+		u.mu.Lock()
+		u.nextSEID++
+		s.seid = u.nextSEID
+		s.log = u.log.With(zap.Uint64("seid", s.seid), zap.String("cp_node_id", node))
+		if err = u.index(s, r.keys()); err == nil {
+			u.sessions[s.seid] = s
+		}
+		u.mu.Unlock()
+	}
+	if err != nil {
+		rsp.Cause, rsp.FailedRuleID = ruleFailure(err)
+		u.log.Warn("Session establishment rejected", zap.String("cp_node_id", node), zap.Error(err))
+		return rsp
+	}
+
+	if u.metrics != nil {
+		u.metrics.ActiveSessions.Inc()
+	}
+	s.log.Info("Session established", zap.Uint64("cp_seid", s.cpSEID), zap.Int("pdrs", len(r.pdrs)),
+		zap.Uint8("pdn_type", uint8(m.PDNType)))
+	rsp.Cause = pfcp.CauseRequestAccepted
+	rsp.UPFSEID = u.fseid(s.seid)
+	return rsp
+}
+
+// modify changes the rules of a session. The changes apply at once or
+// not at all. End markers are sent on the tunnels the FARs stopped
+// forwarding to, and buffered packets forwarded once their FAR stopped
+// buffering.
+func (u *UPF) modify(seid uint64, m *pfcp.SessionModificationRequest) (pfcp.Message, uint64) {
+	s := u.session(seid)
+	if s == nil {
+		return &pfcp.SessionModificationResponse{Cause: pfcp.CauseSessionContextNotFound}, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.rules.clone()
+	for _, id := range m.RemovePDRs {
+		delete(r.pdrs, id)
+	}
+	for _, id := range m.RemoveFARs {
+		delete(r.fars, id)
+	}
+	for _, id := range m.RemoveQERs {
+		delete(r.qers, id)
+	}
+	for _, id := range m.RemoveURRs {
+		delete(r.urrs, id)
+	}
+	if m.RemoveBAR != nil && r.bar != nil && r.bar.BARID == *m.RemoveBAR {
+		r.bar = nil
+	}
+
+	var markers []endMarker
+	err := r.createFARs(m.CreateFARs)
+	if err == nil {
+		markers, err = r.updateFARs(m.UpdateFARs)
+	}
+	if err == nil {
+		err = r.createQERs(m.CreateQERs)
+	}
+	if err == nil {
+		err = r.updateQERs(m.UpdateQERs)
+	}
+	if err == nil {
+		err = r.createURRs(m.CreateURRs, false)
+	}
+	if err == nil {
+		err = r.createURRs(m.UpdateURRs, true)
+	}
+	if err == nil {
+		err = r.createPDRs(m.CreatePDRs)
+	}
+	if err == nil {
+		err = r.updatePDRs(m.UpdatePDRs)
+	}
+	if err == nil {
+		err = r.setBAR(m.CreateBAR, m.UpdateBAR)
+	}
+	if err == nil {
+		err = r.check()
+	}
+	if err == nil {
+		u.mu.Lock()
+		err = u.index(s, r.keys())
+		u.mu.Unlock()
+	}
+	if err != nil {
+		rsp := &pfcp.SessionModificationResponse{}
+		rsp.Cause, rsp.FailedRuleID = ruleFailure(err)
+		s.log.Warn("Session modification rejected", zap.Error(err))
+		return rsp, s.cpSEID
+	}
+
+	s.rules, s.order = r, r.ordered()
+	s.pruneMeters()
+	if m.CPFSEID != nil {
+		s.cpSEID = m.CPFSEID.SEID
+	}
+	for _, em := range markers {
+		u.sendEndMarker(em.teid, em.peer)
+	}
+	u.flush(s)
+	s.log.Debug("Session modified", zap.Int("pdrs", len(r.pdrs)), zap.Int("end_markers", len(markers)))
+	return &pfcp.SessionModificationResponse{Cause: pfcp.CauseRequestAccepted}, s.cpSEID
+}
+
+// setBAR creates or updates the BAR of a rule set
+func (r *rules) setBAR(create, update *pfcp.BAR) error {
+	if create != nil {
+		if r.bar != nil {
+			return failedRule(pfcp.RuleBAR, uint32(create.BARID), "BAR %d already exists", r.bar.BARID)
+		}
+		r.bar = create
+	}
+	if update != nil {
+		if r.bar == nil || r.bar.BARID != update.BARID {
+			return failedRule(pfcp.RuleBAR, uint32(update.BARID), "no BAR %d to update", update.BARID)
+		}
+		r.bar = mergeBAR(r.bar, update)
+	}
+	return nil
+}
+
+// mergeBAR returns a BAR updated with the fields set in update
+func mergeBAR(bar, update *pfcp.BAR) *pfcp.BAR {
+	b := *bar
+	if update.DownlinkDataNotificationDelay != nil {
+		b.DownlinkDataNotificationDelay = update.DownlinkDataNotificationDelay
+	}
+	if update.SuggestedBufferingPackets != nil {
+		b.SuggestedBufferingPackets = update.SuggestedBufferingPackets
+	}
+	return &b
+}
+
+// delete deletes a session, dropping its buffered packets
+func (u *UPF) delete(seid uint64) (pfcp.Message, uint64) {
+	u.mu.Lock()
+	s := u.sessions[seid]
+	if s != nil {
+		delete(u.sessions, seid)
+		u.unindex(s)
+	}
+	u.mu.Unlock()
+	if s == nil {
+		return &pfcp.SessionDeletionResponse{Cause: pfcp.CauseSessionContextNotFound}, 0
+	}
+
+	cpSEID := s.stop()
+	if u.metrics != nil {
+		u.metrics.ActiveSessions.Dec()
+	}
+	s.log.Info("Session deleted")
+	return &pfcp.SessionDeletionResponse{Cause: pfcp.CauseRequestAccepted}, cpSEID
+}
+
+// dropSessions deletes the sessions of a CP function, returning how many
+// there were
+func (u *UPF) dropSessions(node string) int {
+	var dropped []*session
+	u.mu.Lock()
+	for seid, s := range u.sessions {
+		if s.cpNode == node {
+			delete(u.sessions, seid)
+			u.unindex(s)
+			dropped = append(dropped, s)
+		}
+	}
+	u.mu.Unlock()
+
+	for _, s := range dropped {
+		s.stop()
+		if u.metrics != nil {
+			u.metrics.ActiveSessions.Dec()
+		}
+	}
+	return len(dropped)
+}
+
+// session returns a session by local SEID
+func (u *UPF) session(seid uint64) *session {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.sessions[seid]
+}
+
+// fseid returns the F-SEID of a session in the UPF, with the address of
+// the node ID or else of the N4 endpoint
+func (u *UPF) fseid(seid uint64) *pfcp.FSEID {
+	ip := u.nodeID.IP
+	if ip == nil {
+		ip = u.node.LocalAddr().IP
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &pfcp.FSEID{SEID: seid, IPv4: v4}
+	}
+	return &pfcp.FSEID{SEID: seid, IPv6: ip}
+}
+
+// ruleFailure returns the cause and failed rule of an error applying the
+// rules of a request
+func ruleFailure(err error) (pfcp.Cause, *pfcp.FailedRuleID) {
+	var re *ruleError
+	if errors.As(err, &re) {
+		rule := re.rule
+		return re.cause, &rule
+	}
+	return pfcp.CauseRuleCreationFailure, nil
+}
+
+// notifyDownlinkData tells the CP function of a session that a PDR
+// buffered a downlink packet, after the notification delay of the BAR.
+// It runs holding s.mu.
+func (u *UPF) notifyDownlinkData(s *session, pdrID uint16) {
+	s.notified = true
+	req := &pfcp.SessionReportRequest{
+		ReportType:         pfcp.ReportDownlinkData,
+		DownlinkDataReport: &pfcp.DownlinkDataReport{PDRIDs: []uint16{pdrID}},
+	}
+	peer, seid := s.cp.String(), s.cpSEID
+
+	var delay time.Duration
+	if bar := s.rules.bar; bar != nil && bar.DownlinkDataNotificationDelay != nil {
+		delay = time.Duration(*bar.DownlinkDataNotificationDelay) * notificationDelayUnit
+	}
+	if delay == 0 {
+		go u.report(s, peer, seid, req)
+		return
+	}
+	s.notify = time.AfterFunc(delay, func() { u.report(s, peer, seid, req) })
+}
+
+// report sends a session report to the CP function of a session,
+// dropping the buffered packets or updating the BAR as the response
+// asks
+func (u *UPF) report(s *session, peer string, seid uint64, req *pfcp.SessionReportRequest) {
+	rsp, err := u.node.Request(context.Background(), peer, seid, req)
+	if err != nil {
+		s.log.Warn("Session report failed", zap.Error(err))
+		return
+	}
+	r := rsp.(*pfcp.SessionReportResponse)
+	if !r.Cause.Accepted() {
+		s.log.Warn("Session report rejected", zap.Stringer("cause", r.Cause))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.DropBufferedPackets {
+		for _, b := range s.buffer {
+			u.dropped(directionOf(s.rules.pdrs[b.pdrID]), dropDiscarded, len(b.pkt))
+		}
+		s.buffer = nil
+	}
+	if r.UpdateBAR != nil && s.rules.bar != nil && s.rules.bar.BARID == r.UpdateBAR.BARID {
+		s.rules.bar = mergeBAR(s.rules.bar, r.UpdateBAR)
+	}
+}
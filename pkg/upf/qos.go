@@ -0,0 +1,221 @@
+package upf
+
+import (
+	"math"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/pfcp"
+)
+
+// burstWindow is how long the token buckets fill for at most, the burst
+// they let through being what their bit rate carries in that time
+const burstWindow = 100 * time.Millisecond
+
+// minBurst lets full sized packets through the token buckets of the
+// lowest bit rates
+const minBurst = 2 * 1500
+
+// Directions of the token buckets of a meter
+const (
+	uplink = iota
+	downlink
+)
+
+// tokenBucket limits traffic to a bit rate. A nil bucket limits nothing.
+type tokenBucket struct {
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket of a bit rate in bps
+func newTokenBucket(bps uint64, now time.Time) *tokenBucket {
+	b := &tokenBucket{last: now}
+	b.setRate(bps)
+	b.tokens = b.burst
+	return b
+}
+
+// setRate changes the bit rate of the bucket, keeping the tokens the new
+// burst holds
+func (b *tokenBucket) setRate(bps uint64) {
+	b.rate = float64(bps) / 8
+	b.burst = math.Max(b.rate*burstWindow.Seconds(), minBurst)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+// refill adds the tokens earned since the last refill
+func (b *tokenBucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+b.rate*elapsed)
+	}
+	b.last = now
+}
+
+// allows reports whether n bytes fit in the bucket
+func (b *tokenBucket) allows(n int) bool {
+	return b == nil || b.tokens >= float64(n)
+}
+
+// take removes the tokens of n bytes
+func (b *tokenBucket) take(n int) {
+	if b != nil {
+		b.tokens -= float64(n)
+	}
+}
+
+// setBucket returns a bucket of a bit rate in bps, reusing b when there
+// is one, and nil for the rate 0, which is no limit
+func setBucket(b *tokenBucket, bps uint64, now time.Time) *tokenBucket {
+	switch {
+	case bps == 0:
+		return nil
+	case b == nil:
+		return newTokenBucket(bps, now)
+	default:
+		b.refill(now)
+		b.setRate(bps)
+		return b
+	}
+}
+
+// meter holds the token buckets of a QER by direction: the MBR limiting
+// its traffic and the GBR guaranteeing part of it
+type meter struct {
+	qer *pfcp.QER // the QER the buckets were set for
+	mbr [2]*tokenBucket
+	gbr [2]*tokenBucket
+}
+
+// set sets the buckets for the bit rates of a QER, the token counts of
+// the existing ones being kept
+func (m *meter) set(q *pfcp.QER, profiles map[uint8]QoSProfile, now time.Time) {
+	mbr, gbr := bitRates(q, profiles)
+	m.qer = q
+	m.mbr[uplink] = setBucket(m.mbr[uplink], mbr.UL, now)
+	m.mbr[downlink] = setBucket(m.mbr[downlink], mbr.DL, now)
+	m.gbr[uplink] = setBucket(m.gbr[uplink], gbr.UL, now)
+	m.gbr[downlink] = setBucket(m.gbr[downlink], gbr.DL, now)
+}
+
+// refill refills the buckets of a direction
+func (m *meter) refill(dir int, now time.Time) {
+	m.mbr[dir].refill(now)
+	m.gbr[dir].refill(now)
+}
+
+// bitRates returns the MBR and GBR of a QER in bps, those of the QoS
+// profile of its QFI standing for the ones it does not give
+func bitRates(q *pfcp.QER, profiles map[uint8]QoSProfile) (mbr, gbr pfcp.BitRate) {
+	if q.MBR != nil {
+		mbr = pfcp.BitRate{UL: q.MBR.UL * 1000, DL: q.MBR.DL * 1000}
+	}
+	if q.GBR != nil {
+		gbr = pfcp.BitRate{UL: q.GBR.UL * 1000, DL: q.GBR.DL * 1000}
+	}
+	if q.QFI == nil {
+		return mbr, gbr
+	}
+	if p, ok := profiles[*q.QFI]; ok {
+		if q.MBR == nil {
+			mbr = pfcp.BitRate{UL: p.MBR, DL: p.MBR}
+		}
+		if q.GBR == nil {
+			gbr = pfcp.BitRate{UL: p.GBR, DL: p.GBR}
+		}
+	}
+	return mbr, gbr
+}
+
+// meter returns the meter of a QER, set for its current bit rates. It
+// runs holding s.mu.
+func (s *session) meter(id uint32, q *pfcp.QER, profiles map[uint8]QoSProfile, now time.Time) *meter {
+	m, ok := s.meters[id]
+	if !ok {
+		m = &meter{}
+		s.meters[id] = m
+	}
+	if m.qer != q {
+		m.set(q, profiles, now)
+	}
+	return m
+}
+
+// pruneMeters drops the meters of the removed QERs. It runs holding s.mu.
+func (s *session) pruneMeters() {
+	for id := range s.meters {
+		if _, ok := s.rules.qers[id]; !ok {
+			delete(s.meters, id)
+		}
+	}
+}
+
+// police enforces the QERs of a PDR on a packet of n bytes, returning the
+// reason to drop it for, empty when it conforms. Closed gates drop every
+// packet. A packet must then fit in the MBR of the QERs of its QoS flow,
+// those with a QFI, and in the MBR of the session QERs, the session AMBR,
+// unless it fits in the GBR of its flow: the traffic a flow is
+// guaranteed is not shared with the other flows of the session. It runs
+// holding s.mu.
+func (u *UPF) police(s *session, p *pdr, n int) string {
+	if len(p.QERIDs) == 0 {
+		return ""
+	}
+	dir := downlink
+	if p.PDI.SourceInterface == pfcp.InterfaceAccess {
+		dir = uplink
+	}
+
+	now := time.Now()
+	var flows, sessions []*meter
+	for _, id := range p.QERIDs {
+		q := s.rules.qers[id]
+		if dir == uplink && q.GateStatus.ULClosed || dir == downlink && q.GateStatus.DLClosed {
+			return dropGateClosed
+		}
+		m := s.meter(id, q, u.config.QoSProfiles, now)
+		m.refill(dir, now)
+		if q.QFI != nil {
+			flows = append(flows, m)
+		} else {
+			sessions = append(sessions, m)
+		}
+	}
+
+	// only the flows whose GBR lets the packet through pay for it from
+	// their guarantee
+	var guarantors []*meter
+	for _, m := range flows {
+		if !m.mbr[dir].allows(n) {
+			return dropPoliced
+		}
+		if m.gbr[dir] != nil && m.gbr[dir].allows(n) {
+			guarantors = append(guarantors, m)
+		}
+	}
+	guaranteed := len(guarantors) > 0
+	if !guaranteed {
+		for _, m := range sessions {
+			if !m.mbr[dir].allows(n) {
+				return dropPoliced
+			}
+		}
+	}
+
+	for _, m := range flows {
+		m.mbr[dir].take(n)
+	}
+	for _, m := range guarantors {
+		m.gbr[dir].take(n)
+	}
+	if !guaranteed {
+		for _, m := range sessions {
+			m.mbr[dir].take(n)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package upf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// IP protocols carrying ports
+const (
+	protoTCP  = 6
+	protoUDP  = 17
+	protoSCTP = 132
+)
+
+// packet holds the fields of an IP packet the PDRs match
+type packet struct {
+	src, dst netip.Addr
+	proto    uint8
+
+	// Ports are 0 for protocols without ports and non-first fragments
+	srcPort, dstPort uint16
+}
+
+// parsePacket decodes the header of an IPv4 or IPv6 packet, reporting
+// false when b holds none. IPv6 extension headers are not followed.
+func parsePacket(b []byte) (packet, bool) {
+	var p packet
+	if len(b) < 1 {
+		return p, false
+	}
+
+	var payload []byte
+	switch b[0] >> 4 {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if ihl < 20 || len(b) < ihl {
+			return p, false
+		}
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		if binary.BigEndian.Uint16(b[6:])&0x1fff == 0 {
+			payload = b[ihl:]
+		}
+	case 6:
+		if len(b) < 40 {
+			return p, false
+		}
+		p.proto = b[6]
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		payload = b[40:]
+	default:
+		return p, false
+	}
+
+	switch p.proto {
+	case protoTCP, protoUDP, protoSCTP:
+		if len(payload) >= 4 {
+			p.srcPort = binary.BigEndian.Uint16(payload)
+			p.dstPort = binary.BigEndian.Uint16(payload[2:])
+		}
+	}
+	return p, true
+}
+
+// portRange is a range of ports, bounds included
+type portRange struct {
+	from, to uint16
+}
+
+// sdfFilter is a parsed flow description: an IPFilterRule of RFC 6733
+// in the downlink direction, its source being the remote end and its
+// destination the UE
+type sdfFilter struct {
+	// proto is the IP protocol, -1 for any
+	proto int
+
+	// remote is the prefix of the remote end, invalid for any address
+	remote      netip.Prefix
+	remotePorts []portRange
+
+	// local is the prefix of the UE, invalid for any address or the one
+	// assigned to the UE, which the PDI matches already
+	local      netip.Prefix
+	localPorts []portRange
+}
+
+// parseSDFFilter parses a flow description of the form "permit out
+// <protocol> from <address> [<ports>] to <address> [<ports>]", the
+// addresses being "any", "assigned" or a prefix and the ports a list of
+// ports and ranges
+func parseSDFFilter(rule string) (sdfFilter, error) {
+	f := sdfFilter{proto: -1}
+	fields := strings.Fields(rule)
+	if len(fields) < 6 || fields[0] != "permit" || fields[1] != "out" {
+		return f, fmt.Errorf("unsupported flow description %q", rule)
+	}
+
+	switch p := fields[2]; p {
+	case "ip":
+	case "tcp":
+		f.proto = protoTCP
+	case "udp":
+		f.proto = protoUDP
+	default:
+		n, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return f, fmt.Errorf("invalid protocol in flow description %q", rule)
+		}
+		f.proto = int(n)
+	}
+
+	rest := fields[3:]
+	for _, side := range []struct {
+		keyword string
+		prefix  *netip.Prefix
+		ports   *[]portRange
+	}{{"from", &f.remote, &f.remotePorts}, {"to", &f.local, &f.localPorts}} {
+		if len(rest) < 2 || rest[0] != side.keyword {
+			return f, fmt.Errorf("unsupported flow description %q", rule)
+		}
+		var err error
+		if *side.prefix, err = parsePrefix(rest[1]); err != nil {
+			return f, fmt.Errorf("flow description %q: %w", rule, err)
+		}
+		rest = rest[2:]
+		if len(rest) > 0 && rest[0] != "to" {
+			if *side.ports, err = parsePorts(rest[0]); err != nil {
+				return f, fmt.Errorf("flow description %q: %w", rule, err)
+			}
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 {
+		return f, fmt.Errorf("unsupported options in flow description %q", rule)
+	}
+	return f, nil
+}
+
+// parsePrefix parses an address of an IPFilterRule, returning an invalid
+// prefix for any address or the one of the UE
+func parsePrefix(addr string) (netip.Prefix, error) {
+	if addr == "any" || addr == "assigned" {
+		return netip.Prefix{}, nil
+	}
+	if strings.Contains(addr, "/") {
+		p, err := netip.ParsePrefix(addr)
+		if err != nil {
+			return p, fmt.Errorf("unsupported address %s", addr)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("unsupported address %s", addr)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// parsePorts parses a comma separated list of ports and port ranges
+func parsePorts(ports string) ([]portRange, error) {
+	var ranges []portRange
+	for _, p := range strings.Split(ports, ",") {
+		low, high, isRange := strings.Cut(p, "-")
+		from, err := strconv.ParseUint(low, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported ports %s", ports)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.ParseUint(high, 10, 16); err != nil || to < from {
+				return nil, fmt.Errorf("unsupported ports %s", ports)
+			}
+		}
+		ranges = append(ranges, portRange{uint16(from), uint16(to)})
+	}
+	return ranges, nil
+}
+
+// matches reports whether a packet matches the filter. The ends of
+// uplink packets are swapped, the filter describing the downlink
+// direction.
+func (f *sdfFilter) matches(p *packet, uplink bool) bool {
+	remote, local := p.src, p.dst
+	remotePort, localPort := p.srcPort, p.dstPort
+	if uplink {
+		remote, local = local, remote
+		remotePort, localPort = localPort, remotePort
+	}
+
+	if f.proto >= 0 && int(p.proto) != f.proto {
+		return false
+	}
+	if f.remote.IsValid() && !f.remote.Contains(remote) {
+		return false
+	}
+	if f.local.IsValid() && !f.local.Contains(local) {
+		return false
+	}
+	return inPorts(f.remotePorts, remotePort) && inPorts(f.localPorts, localPort)
+}
+
+// inPorts reports whether a port is in the ranges, every port being when
+// there is none
+func inPorts(ranges []portRange, port uint16) bool {
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,520 @@
+package upf
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/gtpu"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// session is an N4 session: the rules a CP function set up for a PDU
+// session, and the downlink packets buffered while its UE is idle
+type session struct {
+	seid   uint64 // local
+	cpNode string
+	log    *zap.Logger
+	keys   sessionKeys // guarded by the mutex of the UPF
+
+	mu     sync.Mutex
+	cpSEID uint64
+	cp     *net.UDPAddr // where session reports are sent
+	rules  rules
+	order  []*pdr            // by precedence
+	meters map[uint32]*meter // by QER ID
+	buffer []buffered
+
+	// notified tells that the CP function was told about the buffered
+	// packets, until the FARs stop buffering
+	notified bool
+	notify   *time.Timer
+}
+
+// buffered is a downlink packet buffered for a PDR
+type buffered struct {
+	pdrID uint16
+	pkt   []byte
+}
+
+// stop drops the buffered packets and the pending downlink data report,
+// returning the SEID of the session in the CP function
+func (s *session) stop() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buffer = nil
+	if s.notify != nil {
+		s.notify.Stop()
+	}
+	return s.cpSEID
+}
+
+// rules are the PDRs, FARs, QERs, URRs and BAR of a session. URRs are
+// kept but no usage is measured.
+type rules struct {
+	pdrs map[uint16]*pdr
+	fars map[uint32]*pfcp.CreateFAR
+	qers map[uint32]*pfcp.QER
+	urrs map[uint32]*pfcp.URR
+	bar  *pfcp.BAR
+}
+
+// pdr is a PDR with its SDF filters and UE addresses parsed
+type pdr struct {
+	pfcp.CreatePDR
+	filters []sdfFilter
+
+	// ueIPv4 and ueIPv6 are the addresses of the UE in the PDI, invalid
+	// when there are none
+	ueIPv4 netip.Addr
+	ueIPv6 netip.Prefix
+}
+
+// ruleError is a rule of a request that cannot be applied
+type ruleError struct {
+	cause pfcp.Cause
+	rule  pfcp.FailedRuleID
+	msg   string
+}
+
+// Error implements error
+func (e *ruleError) Error() string {
+	return e.msg
+}
+
+// failedRule returns the error of a rule that cannot be applied
+func failedRule(t pfcp.RuleType, id uint32, format string, args ...interface{}) *ruleError {
+	return &ruleError{
+		cause: pfcp.CauseRuleCreationFailure,
+		rule:  pfcp.FailedRuleID{Type: t, ID: id},
+		msg:   fmt.Sprintf(format, args...),
+	}
+}
+
+// newRules returns an empty rule set
+func newRules() rules {
+	return rules{
+		pdrs: make(map[uint16]*pdr),
+		fars: make(map[uint32]*pfcp.CreateFAR),
+		qers: make(map[uint32]*pfcp.QER),
+		urrs: make(map[uint32]*pfcp.URR),
+	}
+}
+
+// clone returns a copy of the rule set that can be changed without
+// changing r. The rules themselves are replaced, never changed.
+func (r rules) clone() rules {
+	c := rules{
+		pdrs: make(map[uint16]*pdr, len(r.pdrs)),
+		fars: make(map[uint32]*pfcp.CreateFAR, len(r.fars)),
+		qers: make(map[uint32]*pfcp.QER, len(r.qers)),
+		urrs: make(map[uint32]*pfcp.URR, len(r.urrs)),
+		bar:  r.bar,
+	}
+	for id, p := range r.pdrs {
+		c.pdrs[id] = p
+	}
+	for id, f := range r.fars {
+		c.fars[id] = f
+	}
+	for id, q := range r.qers {
+		c.qers[id] = q
+	}
+	for id, u := range r.urrs {
+		c.urrs[id] = u
+	}
+	return c
+}
+
+// newPDR parses the PDI of a PDR. The SMF allocates the F-TEIDs, the UPF
+// not supporting the CHOOSE flag.
+func newPDR(c pfcp.CreatePDR) (*pdr, error) {
+	p := &pdr{CreatePDR: c}
+	if c.PDI.FTEID != nil && c.PDI.FTEID.Choose {
+		return nil, &ruleError{
+			cause: pfcp.CauseInvalidFTEIDAllocation,
+			rule:  pfcp.FailedRuleID{Type: pfcp.RulePDR, ID: uint32(c.PDRID)},
+			msg:   fmt.Sprintf("PDR %d asks for an F-TEID", c.PDRID),
+		}
+	}
+	for _, f := range c.PDI.SDFFilters {
+		filter, err := parseSDFFilter(f.FlowDescription)
+		if err != nil {
+			return nil, failedRule(pfcp.RulePDR, uint32(c.PDRID), "PDR %d: %v", c.PDRID, err)
+		}
+		p.filters = append(p.filters, filter)
+	}
+	if ue := c.PDI.UEIPAddress; ue != nil {
+		if ip, ok := netip.AddrFromSlice(ue.IPv4.To4()); ok {
+			p.ueIPv4 = ip
+		}
+		if ip, ok := netip.AddrFromSlice(ue.IPv6.To16()); ok && ue.IPv6.To4() == nil {
+			length := int(ue.IPv6PrefixLength)
+			if length == 0 || length > 128 {
+				length = 64
+			}
+			p.ueIPv6 = netip.PrefixFrom(ip, length).Masked()
+		}
+	}
+	return p, nil
+}
+
+// createPDRs adds PDRs to the rule set
+func (r *rules) createPDRs(pdrs []pfcp.CreatePDR) error {
+	for _, c := range pdrs {
+		if _, ok := r.pdrs[c.PDRID]; ok {
+			return failedRule(pfcp.RulePDR, uint32(c.PDRID), "PDR %d already exists", c.PDRID)
+		}
+		p, err := newPDR(c)
+		if err != nil {
+			return err
+		}
+		r.pdrs[c.PDRID] = p
+	}
+	return nil
+}
+
+// updatePDRs changes PDRs of the rule set
+func (r *rules) updatePDRs(updates []pfcp.UpdatePDR) error {
+	for _, u := range updates {
+		old, ok := r.pdrs[u.PDRID]
+		if !ok {
+			return failedRule(pfcp.RulePDR, uint32(u.PDRID), "no PDR %d to update", u.PDRID)
+		}
+		c := old.CreatePDR
+		if u.OuterHeaderRemoval != nil {
+			c.OuterHeaderRemoval = u.OuterHeaderRemoval
+		}
+		if u.Precedence != nil {
+			c.Precedence = *u.Precedence
+		}
+		if u.PDI != nil {
+			c.PDI = *u.PDI
+		}
+		if u.FARID != nil {
+			c.FARID = u.FARID
+		}
+		if u.URRIDs != nil {
+			c.URRIDs = u.URRIDs
+		}
+		if u.QERIDs != nil {
+			c.QERIDs = u.QERIDs
+		}
+		p, err := newPDR(c)
+		if err != nil {
+			return err
+		}
+		r.pdrs[u.PDRID] = p
+	}
+	return nil
+}
+
+// createFARs adds FARs to the rule set
+func (r *rules) createFARs(fars []pfcp.CreateFAR) error {
+	for i := range fars {
+		f := fars[i]
+		if _, ok := r.fars[f.FARID]; ok {
+			return failedRule(pfcp.RuleFAR, f.FARID, "FAR %d already exists", f.FARID)
+		}
+		if fp := f.ForwardingParameters; fp != nil {
+			params := *fp
+			params.SendEndMarker = false
+			f.ForwardingParameters = &params
+		}
+		r.fars[f.FARID] = &f
+	}
+	return nil
+}
+
+// endMarker is an end marker to send on the previous tunnel of a FAR
+type endMarker struct {
+	teid uint32
+	peer *net.UDPAddr
+}
+
+// updateFARs changes FARs of the rule set, returning the end markers to
+// send on the tunnels the FARs stopped forwarding to
+func (r *rules) updateFARs(updates []pfcp.UpdateFAR) ([]endMarker, error) {
+	var markers []endMarker
+	for _, u := range updates {
+		old, ok := r.fars[u.FARID]
+		if !ok {
+			return nil, failedRule(pfcp.RuleFAR, u.FARID, "no FAR %d to update", u.FARID)
+		}
+		f := *old
+		if u.ApplyAction != nil {
+			f.ApplyAction = *u.ApplyAction
+		}
+		if fp := u.ForwardingParameters; fp != nil {
+			next := *fp
+			next.SendEndMarker = false
+			if fp.SendEndMarker && old.ForwardingParameters != nil {
+				prev, cur := old.ForwardingParameters.OuterHeaderCreation, next.OuterHeaderCreation
+				if peer := tunnelPeer(prev); peer != nil && !sameTunnel(prev, cur) {
+					markers = append(markers, endMarker{teid: prev.TEID, peer: peer})
+				}
+			}
+			f.ForwardingParameters = &next
+		}
+		if u.DuplicatingParameters != nil {
+			f.DuplicatingParameters = u.DuplicatingParameters
+		}
+		if u.BARID != nil {
+			f.BARID = u.BARID
+		}
+		r.fars[u.FARID] = &f
+	}
+	return markers, nil
+}
+
+// createQERs adds QERs to the rule set
+func (r *rules) createQERs(qers []pfcp.QER) error {
+	for i := range qers {
+		q := qers[i]
+		if _, ok := r.qers[q.QERID]; ok {
+			return failedRule(pfcp.RuleQER, q.QERID, "QER %d already exists", q.QERID)
+		}
+		r.qers[q.QERID] = &q
+	}
+	return nil
+}
+
+// updateQERs changes QERs of the rule set, the bit rates and QFI left
+// unset being kept
+func (r *rules) updateQERs(updates []pfcp.QER) error {
+	for _, u := range updates {
+		old, ok := r.qers[u.QERID]
+		if !ok {
+			return failedRule(pfcp.RuleQER, u.QERID, "no QER %d to update", u.QERID)
+		}
+		q := *old
+		q.GateStatus = u.GateStatus
+		if u.MBR != nil {
+			q.MBR = u.MBR
+		}
+		if u.GBR != nil {
+			q.GBR = u.GBR
+		}
+		if u.QFI != nil {
+			q.QFI = u.QFI
+		}
+		r.qers[u.QERID] = &q
+	}
+	return nil
+}
+
+// createURRs adds URRs to the rule set, replacing the existing ones when
+// update is set
+func (r *rules) createURRs(urrs []pfcp.URR, update bool) error {
+	for i := range urrs {
+		u := urrs[i]
+		_, ok := r.urrs[u.URRID]
+		switch {
+		case ok && !update:
+			return failedRule(pfcp.RuleURR, u.URRID, "URR %d already exists", u.URRID)
+		case !ok && update:
+			return failedRule(pfcp.RuleURR, u.URRID, "no URR %d to update", u.URRID)
+		}
+		r.urrs[u.URRID] = &u
+	}
+	return nil
+}
+
+// check verifies that the rules the PDRs and FARs refer to exist
+func (r *rules) check() error {
+	for _, id := range sortedIDs(r.pdrs) {
+		p := r.pdrs[id]
+		if p.FARID == nil {
+			return failedRule(pfcp.RulePDR, uint32(id), "PDR %d without FAR", id)
+		}
+		if _, ok := r.fars[*p.FARID]; !ok {
+			return failedRule(pfcp.RulePDR, uint32(id), "PDR %d refers to unknown FAR %d", id, *p.FARID)
+		}
+		for _, q := range p.QERIDs {
+			if _, ok := r.qers[q]; !ok {
+				return failedRule(pfcp.RulePDR, uint32(id), "PDR %d refers to unknown QER %d", id, q)
+			}
+		}
+		for _, u := range p.URRIDs {
+			if _, ok := r.urrs[u]; !ok {
+				return failedRule(pfcp.RulePDR, uint32(id), "PDR %d refers to unknown URR %d", id, u)
+			}
+		}
+	}
+	for _, id := range sortedIDs(r.fars) {
+		f := r.fars[id]
+		if f.BARID != nil && (r.bar == nil || r.bar.BARID != *f.BARID) {
+			return failedRule(pfcp.RuleFAR, id, "FAR %d refers to unknown BAR %d", id, *f.BARID)
+		}
+	}
+	return nil
+}
+
+// ordered returns the PDRs by precedence, the lowest value first
+func (r *rules) ordered() []*pdr {
+	order := make([]*pdr, 0, len(r.pdrs))
+	for _, id := range sortedIDs(r.pdrs) {
+		order = append(order, r.pdrs[id])
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Precedence < order[j].Precedence })
+	return order
+}
+
+// sessionKeys are the local TEIDs and UE addresses packets are matched
+// to a session on
+type sessionKeys struct {
+	teids []uint32
+	ipv4  []netip.Addr
+	ipv6  []netip.Prefix
+}
+
+// keys returns the TEIDs of the PDRs with an F-TEID and the UE addresses
+// of the PDRs matching the packets of the data network
+func (r *rules) keys() sessionKeys {
+	var k sessionKeys
+	teids := make(map[uint32]bool)
+	ipv4 := make(map[netip.Addr]bool)
+	ipv6 := make(map[netip.Prefix]bool)
+	for _, id := range sortedIDs(r.pdrs) {
+		p := r.pdrs[id]
+		switch {
+		case p.PDI.FTEID != nil:
+			teids[p.PDI.FTEID.TEID] = true
+		default:
+			if p.ueIPv4.IsValid() {
+				ipv4[p.ueIPv4] = true
+			}
+			if p.ueIPv6.IsValid() {
+				ipv6[p.ueIPv6] = true
+			}
+		}
+	}
+	for teid := range teids {
+		k.teids = append(k.teids, teid)
+	}
+	for ip := range ipv4 {
+		k.ipv4 = append(k.ipv4, ip)
+	}
+	for p := range ipv6 {
+		k.ipv6 = append(k.ipv6, p)
+	}
+	return k
+}
+
+// sortedIDs returns the IDs of rules in order
+func sortedIDs[K ~uint16 | ~uint32, V any](m map[K]V) []K {
+	ids := make([]K, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// tunnelPeer returns the GTP-U endpoint of an outer header creation, nil
+// when it creates no GTP-U header
+func tunnelPeer(o *pfcp.OuterHeaderCreation) *net.UDPAddr {
+	switch {
+	case o == nil:
+		return nil
+	case o.Description&pfcp.OuterHeaderGTPUIPv4 != 0 && o.IPv4 != nil:
+		return &net.UDPAddr{IP: o.IPv4, Port: gtpu.Port}
+	case o.Description&pfcp.OuterHeaderGTPUIPv6 != 0 && o.IPv6 != nil:
+		return &net.UDPAddr{IP: o.IPv6, Port: gtpu.Port}
+	default:
+		return nil
+	}
+}
+
+// sameTunnel reports whether two outer header creations lead to the same
+// tunnel
+func sameTunnel(a, b *pfcp.OuterHeaderCreation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Description == b.Description && a.TEID == b.TEID && a.IPv4.Equal(b.IPv4) && a.IPv6.Equal(b.IPv6)
+}
+
+// index makes the TEIDs and UE addresses of a session lead to it,
+// failing when another session has one of them. It runs holding u.mu.
+func (u *UPF) index(s *session, k sessionKeys) error {
+	for _, teid := range k.teids {
+		if other, ok := u.byTEID[teid]; ok && other != s {
+			return fmt.Errorf("TEID %#x used by session %#x", teid, other.seid)
+		}
+	}
+	for _, ip := range k.ipv4 {
+		if other, ok := u.byIPv4[ip]; ok && other != s {
+			return fmt.Errorf("UE address %s used by session %#x", ip, other.seid)
+		}
+	}
+	for _, p := range k.ipv6 {
+		if other, ok := u.byIPv6[p]; ok && other != s {
+			return fmt.Errorf("UE prefix %s used by session %#x", p, other.seid)
+		}
+	}
+
+	u.unindex(s)
+	for _, teid := range k.teids {
+		u.byTEID[teid] = s
+	}
+	for _, ip := range k.ipv4 {
+		u.byIPv4[ip] = s
+	}
+	for _, p := range k.ipv6 {
+		u.byIPv6[p] = s
+		u.ipv6Lengths[p.Bits()]++
+	}
+	s.keys = k
+	return nil
+}
+
+// unindex removes the TEIDs and UE addresses of a session. It runs
+// holding u.mu.
+func (u *UPF) unindex(s *session) {
+	for _, teid := range s.keys.teids {
+		if u.byTEID[teid] == s {
+			delete(u.byTEID, teid)
+		}
+	}
+	for _, ip := range s.keys.ipv4 {
+		if u.byIPv4[ip] == s {
+			delete(u.byIPv4, ip)
+		}
+	}
+	for _, p := range s.keys.ipv6 {
+		if u.byIPv6[p] == s {
+			delete(u.byIPv6, p)
+			if u.ipv6Lengths[p.Bits()]--; u.ipv6Lengths[p.Bits()] == 0 {
+				delete(u.ipv6Lengths, p.Bits())
+			}
+		}
+	}
+	s.keys = sessionKeys{}
+}
+
+// sessionOfTEID returns the session of a local TEID
+func (u *UPF) sessionOfTEID(teid uint32) *session {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.byTEID[teid]
+}
+
+// sessionOfUE returns the session of the UE a packet is sent to
+func (u *UPF) sessionOfUE(dst netip.Addr) *session {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if dst.Is4() {
+		return u.byIPv4[dst]
+	}
+	for bits := range u.ipv6Lengths {
+		if s, ok := u.byIPv6[netip.PrefixFrom(dst, bits).Masked()]; ok {
+			return s
+		}
+	}
+	return nil
+}
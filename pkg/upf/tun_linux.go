@@ -0,0 +1,60 @@
+//go:build linux
+
+package upf
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenTUN creates or attaches to a TUN device carrying IP packets
+// without packet information, and brings it up. Routing the UE pools to
+// the device is left to the operator.
+func OpenTUN(name string) (Device, error) {
+	fd, err := unix.Open("/dev/net/tun", unix.O_RDWR|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening /dev/net/tun: %w", err)
+	}
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("TUN device %s: %w", name, err)
+	}
+	ifr.SetUint16(unix.IFF_TUN | unix.IFF_NO_PI)
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("creating TUN device %s: %w", name, os.NewSyscallError("ioctl", err))
+	}
+	if err := linkUp(name); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("bringing TUN device %s up: %w", name, err)
+	}
+
+	// The descriptor is non-blocking, so that reads go through the runtime
+	// poller and fail once the file is closed
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// linkUp sets the up flag of a network device
+func linkUp(name string) error {
+	s, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(s)
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(s, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(s, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package upf
+
+import (
+	"errors"
+)
+
+// errTUNUnsupported is returned on platforms without TUN support
+var errTUNUnsupported = errors.New("upf: TUN devices are only supported on linux, use another Device")
+
+// OpenTUN creates or attaches to a TUN device
+func OpenTUN(name string) (Device, error) {
+	return nil, errTUNUnsupported
+}
@@ -0,0 +1,267 @@
+package upf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/health"
+	"github.com/0had0/5G-core/pkg/common/logger"
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/models"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"go.uber.org/zap"
+)
+
+// maxPacketSize bounds the size of the packets read from N3 and N6
+const maxPacketSize = 0xffff
+
+// UPF forwards the user plane of the N4 sessions set up by the SMFs
+// between the GTP-U tunnels of N3 and N9 and the data networks of N6
+type UPF struct {
+	config  *Config
+	metrics *metrics.UPFMetrics
+	log     *zap.Logger
+	node    *pfcp.Node
+	nodeID  pfcp.NodeID
+	n3      PacketConn
+	n6      map[string]Device // by DNN
+
+	// n3IP is the address of the N3 endpoint given in error indications,
+	// nil when unknown
+	n3IP net.IP
+
+	mu           sync.RWMutex
+	associations map[string]*association // by node ID of the CP function
+	sessions     map[uint64]*session     // by local SEID
+	byTEID       map[uint32]*session     // by local TEID of N3 and N9
+	byIPv4       map[netip.Addr]*session // by UE IPv4 address
+	byIPv6       map[netip.Prefix]*session
+	ipv6Lengths  map[int]int // prefix lengths of byIPv6, with their use count
+	nextSEID     uint64
+
+	closed chan struct{}
+	wg     sync.WaitGroup
+}
+
+// association is the PFCP association with a CP function
+type association struct {
+	peer     *net.UDPAddr
+	recovery time.Time
+}
+
+// Listen opens the N3 UDP endpoint and the TUN devices of the data
+// networks and starts a UPF over them
+func Listen(cfg *Config, m *metrics.UPFMetrics) (*UPF, error) {
+	n3, err := net.ListenPacket("udp", cfg.N3Address)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", cfg.N3Address, err)
+	}
+	n6 := make(map[string]Device)
+	for _, dn := range cfg.DataNetworks {
+		d, err := OpenTUN(dn.Device)
+		if err != nil {
+			n3.Close()
+			for _, d := range n6 {
+				d.Close()
+			}
+			return nil, err
+		}
+		n6[dn.Name] = d
+	}
+	return New(cfg, n3, n6, m)
+}
+
+// New starts a UPF forwarding packets over the given N3 connection and
+// N6 devices by DNN, and listening for PFCP on the configured N4
+// address. Metrics are recorded on m when it is not nil.
+func New(cfg *Config, n3 PacketConn, n6 map[string]Device, m *metrics.UPFMetrics) (*UPF, error) {
+	for _, dn := range cfg.DataNetworks {
+		if n6[dn.Name] == nil {
+			return nil, fmt.Errorf("no device for data network %s", dn.Name)
+		}
+	}
+
+	u := &UPF{
+		config:       cfg,
+		metrics:      m,
+		log:          logger.Named("upf"),
+		nodeID:       pfcp.NewNodeID(cfg.NodeID),
+		n3:           n3,
+		n6:           n6,
+		associations: make(map[string]*association),
+		sessions:     make(map[uint64]*session),
+		byTEID:       make(map[uint32]*session),
+		byIPv4:       make(map[netip.Addr]*session),
+		byIPv6:       make(map[netip.Prefix]*session),
+		ipv6Lengths:  make(map[int]int),
+		closed:       make(chan struct{}),
+	}
+	if host, _, err := net.SplitHostPort(cfg.N3Address); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			u.n3IP = ip
+		}
+	}
+	if u.n3IP == nil && u.nodeID.IP != nil {
+		u.n3IP = u.nodeID.IP
+	}
+
+	node, err := pfcp.Listen(pfcp.NodeConfig{Address: cfg.N4Address, Handler: u.handle})
+	if err != nil {
+		return nil, err
+	}
+	u.node = node
+
+	u.wg.Add(1 + len(n6))
+	go u.readN3()
+	for dn, d := range n6 {
+		go u.readN6(dn, d)
+	}
+	u.log.Info("UPF started", zap.String("node_id", cfg.NodeID), zap.String("n3", cfg.N3Address),
+		zap.Stringer("n4", node.LocalAddr()))
+	return u, nil
+}
+
+// N4Address returns the address the UPF listens for PFCP on
+func (u *UPF) N4Address() *net.UDPAddr {
+	return u.node.LocalAddr()
+}
+
+// Profile returns the NF profile the UPF registers in the NRF, from
+// which the SMFs discovering it learn its N4 and N3 addresses and DNNs
+func (u *UPF) Profile() models.NfProfile {
+	profile := models.NfProfile{
+		NfInstanceID: u.config.InstanceID,
+		NfType:       models.NfTypeUPF,
+		NfStatus:     models.NfStatusRegistered,
+	}
+	switch {
+	case u.nodeID.IP.To4() != nil:
+		profile.IPv4Addresses = []string{u.nodeID.IP.String()}
+	case u.nodeID.IP != nil:
+		profile.IPv6Addresses = []string{u.nodeID.IP.String()}
+	default:
+		profile.FQDN = u.nodeID.FQDN
+	}
+
+	info := &models.UpfInfo{}
+	if len(u.config.DataNetworks) > 0 {
+		item := models.SnssaiUpfInfoItem{}
+		for _, dn := range u.config.DataNetworks {
+			item.DnnUpfInfoList = append(item.DnnUpfInfoList, models.DnnUpfInfoItem{Dnn: dn.Name})
+		}
+		info.SNssaiUpfInfoList = []models.SnssaiUpfInfoItem{item}
+	}
+	if u.n3IP != nil {
+		info.InterfaceUpfInfoList = []models.InterfaceUpfInfoItem{{
+			InterfaceType:         models.UPInterfaceN3,
+			Ipv4EndpointAddresses: []string{u.n3IP.String()},
+		}}
+	}
+	profile.UpfInfo = info
+	return profile
+}
+
+// RegisterHealth adds the checks of the UPF to r. The PFCP association
+// is informational: an SMF associates on its first session, so a UPF
+// without one is still ready to receive it.
+func (u *UPF) RegisterHealth(r *health.Registry) {
+	r.Register(health.CheckPFCPAssociation, health.Informational, u.checkAssociation)
+}
+
+// checkAssociation implements health.CheckFunc, failing while no SMF is
+// associated
+func (u *UPF) checkAssociation(context.Context) error {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if len(u.associations) == 0 {
+		return errors.New("no PFCP association")
+	}
+	return nil
+}
+
+// Close stops the UPF, closing its N3 connection and N6 devices
+func (u *UPF) Close() error {
+	select {
+	case <-u.closed:
+		return nil
+	default:
+	}
+	close(u.closed)
+
+	err := u.node.Close()
+	if e := u.n3.Close(); err == nil {
+		err = e
+	}
+	for _, d := range u.n6 {
+		if e := d.Close(); err == nil {
+			err = e
+		}
+	}
+	u.wg.Wait()
+
+	for _, s := range u.allSessions() {
+		s.stop()
+	}
+	return err
+}
+
+// readN3 reads the GTP-U messages of the tunnels until the connection
+// is closed
+func (u *UPF) readN3() {
+	defer u.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := u.n3.ReadFrom(buf)
+		if err != nil {
+			if u.stopped(err) {
+				return
+			}
+			continue
+		}
+		peer, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		u.handleGTPU(buf[:n], peer)
+	}
+}
+
+// readN6 reads the packets of a data network sent to the UEs until the
+// device is closed
+func (u *UPF) readN6(dn string, d Device) {
+	defer u.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := d.Read(buf)
+		if err != nil {
+			if u.stopped(err) {
+				return
+			}
+			continue
+		}
+		u.handleN6(dn, buf[:n])
+	}
+}
+
+// stopped reports whether a read error is the UPF closing its
+// connection or devices
+func (u *UPF) stopped(err error) bool {
+	select {
+	case <-u.closed:
+		return true
+	default:
+	}
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrClosed) {
+		return true
+	}
+	u.log.Warn("Read failed", zap.Error(err))
+	return false
+}
@@ -0,0 +1,624 @@
+package upf
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0had0/5G-core/pkg/common/metrics"
+	"github.com/0had0/5G-core/pkg/gtpu"
+	"github.com/0had0/5G-core/pkg/pfcp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// The data network and tunnels of the tests
+const (
+	testDNN = "internet"
+
+	// testULTEID is the N3 tunnel of the UPF, testDLTEID the one of the
+	// gNB
+	testULTEID = 0x100
+	testDLTEID = 0x200
+
+	testQFI = 9
+)
+
+var (
+	testUE     = net.IPv4(10, 60, 0, 1).To4()
+	testServer = net.IPv4(192, 0, 2, 80).To4()
+	testGNB    = &net.UDPAddr{IP: net.IPv4(192, 168, 1, 10).To4(), Port: gtpu.Port}
+	testGNB2   = &net.UDPAddr{IP: net.IPv4(192, 168, 1, 20).To4(), Port: gtpu.Port}
+)
+
+// recvTimeout bounds the wait for a packet of the UPF
+const recvTimeout = 2 * time.Second
+
+// quietPeriod is how long the UPF is watched for packets it must not send
+const quietPeriod = 100 * time.Millisecond
+
+// harness runs a UPF over memory N3 and N6 with a PFCP node playing the
+// SMF, associated and with one session of testUE
+type harness struct {
+	t       *testing.T
+	upf     *UPF
+	n3      *MemoryConn
+	n6      *MemoryDevice
+	smf     *pfcp.Node
+	metrics *metrics.UPFMetrics
+
+	// seid is the session in the UPF
+	seid uint64
+}
+
+// newHarness starts a UPF and associates the SMF with it
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	cfg := &Config{
+		NodeID:          "127.0.0.1",
+		N3Address:       "127.0.0.1:2152",
+		N4Address:       "127.0.0.1:0",
+		DataNetworks:    []DataNetwork{{Name: testDNN, Device: testDNN}},
+		BufferedPackets: 4,
+	}
+	n3, n6 := NewMemoryConn(16), NewMemoryDevice(16)
+	m := metrics.NewUPFMetrics(metrics.New("upf"), "test")
+	u, err := New(cfg, n3, map[string]Device{testDNN: n6}, m)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { u.Close() })
+
+	smf, err := pfcp.Listen(pfcp.NodeConfig{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { smf.Close() })
+
+	h := &harness{t: t, upf: u, n3: n3, n6: n6, smf: smf, metrics: m}
+	rsp := h.request(0, &pfcp.AssociationSetupRequest{
+		NodeID:            pfcp.NewNodeID("127.0.0.2"),
+		RecoveryTimeStamp: time.Now(),
+	}).(*pfcp.AssociationSetupResponse)
+	if !rsp.Cause.Accepted() {
+		t.Fatalf("association setup rejected: %v", rsp.Cause)
+	}
+	return h
+}
+
+// request sends a PFCP request of the SMF to the UPF
+func (h *harness) request(seid uint64, req pfcp.Message) pfcp.Message {
+	h.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), recvTimeout)
+	defer cancel()
+	rsp, err := h.smf.Request(ctx, h.upf.N4Address().String(), seid, req)
+	if err != nil {
+		h.t.Fatalf("%s failed: %v", req.MessageType(), err)
+	}
+	return rsp
+}
+
+// establish creates the session of testUE: PDR 1 takes the uplink of
+// testULTEID to the data network, PDR 2 the downlink to testUE through
+// FAR 2 applying dl. Both go through qers, QER 1 marking testQFI when
+// none are given.
+func (h *harness) establish(dl pfcp.CreateFAR, qers ...pfcp.QER) {
+	h.t.Helper()
+
+	if len(qers) == 0 {
+		qers = []pfcp.QER{{QERID: 1, QFI: ptr[uint8](testQFI)}}
+	}
+	var ids []uint32
+	for _, q := range qers {
+		ids = append(ids, q.QERID)
+	}
+	dl.FARID = 2
+	ohr := pfcp.OuterHeaderRemovalGTPUIPv4
+	rsp := h.request(0, &pfcp.SessionEstablishmentRequest{
+		NodeID:  pfcp.NewNodeID("127.0.0.2"),
+		CPFSEID: pfcp.FSEID{SEID: 1, IPv4: net.IPv4(127, 0, 0, 2)},
+		CreatePDRs: []pfcp.CreatePDR{
+			{
+				PDRID:      1,
+				Precedence: 100,
+				PDI: pfcp.PDI{
+					SourceInterface: pfcp.InterfaceAccess,
+					FTEID:           &pfcp.FTEID{TEID: testULTEID, IPv4: net.IPv4(127, 0, 0, 1)},
+					NetworkInstance: testDNN,
+					UEIPAddress:     &pfcp.UEIPAddress{IPv4: testUE},
+				},
+				OuterHeaderRemoval: &ohr,
+				FARID:              ptr[uint32](1),
+				QERIDs:             ids,
+			},
+			{
+				PDRID:      2,
+				Precedence: 100,
+				PDI: pfcp.PDI{
+					SourceInterface: pfcp.InterfaceCore,
+					NetworkInstance: testDNN,
+					UEIPAddress:     &pfcp.UEIPAddress{IPv4: testUE, Destination: true},
+				},
+				FARID:  ptr[uint32](2),
+				QERIDs: ids,
+			},
+		},
+		CreateFARs: []pfcp.CreateFAR{
+			{
+				FARID:       1,
+				ApplyAction: pfcp.ApplyActionForward,
+				ForwardingParameters: &pfcp.ForwardingParameters{
+					DestinationInterface: pfcp.InterfaceCore,
+					NetworkInstance:      testDNN,
+				},
+			},
+			dl,
+		},
+		CreateQERs: qers,
+	}).(*pfcp.SessionEstablishmentResponse)
+	if !rsp.Cause.Accepted() || rsp.UPFSEID == nil {
+		h.t.Fatalf("session establishment rejected: %v", rsp.Cause)
+	}
+	h.seid = rsp.UPFSEID.SEID
+}
+
+// updateDownlink changes FAR 2 of the session
+func (h *harness) updateDownlink(far pfcp.UpdateFAR) {
+	h.t.Helper()
+
+	far.FARID = 2
+	rsp := h.request(h.seid, &pfcp.SessionModificationRequest{
+		UpdateFARs: []pfcp.UpdateFAR{far},
+	}).(*pfcp.SessionModificationResponse)
+	if !rsp.Cause.Accepted() {
+		h.t.Fatalf("session modification rejected: %v", rsp.Cause)
+	}
+}
+
+// sendN3 sends a GTP-U message of a peer to the UPF
+func (h *harness) sendN3(peer *net.UDPAddr, hdr gtpu.Header, payload []byte) {
+	h.t.Helper()
+
+	b, err := gtpu.Encode(hdr, payload)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.n3.In <- Datagram{Data: b, Addr: peer}
+}
+
+// sendUplink sends an uplink packet of testUE on testULTEID
+func (h *harness) sendUplink(pkt []byte) {
+	h.t.Helper()
+
+	h.sendN3(testGNB, gtpu.Header{
+		Type:       gtpu.MsgGPDU,
+		TEID:       testULTEID,
+		PDUSession: &gtpu.PDUSessionContainer{Uplink: true, QFI: testQFI},
+	}, pkt)
+}
+
+// recvN3 returns the next GTP-U message the UPF sent, checking its peer
+func (h *harness) recvN3(peer *net.UDPAddr) (gtpu.Header, []byte) {
+	h.t.Helper()
+
+	select {
+	case d := <-h.n3.Out:
+		if !d.Addr.IP.Equal(peer.IP) || d.Addr.Port != peer.Port {
+			h.t.Fatalf("GTP-U message sent to %v, want %v", d.Addr, peer)
+		}
+		hdr, payload, err := gtpu.Decode(d.Data)
+		if err != nil {
+			h.t.Fatalf("decoding GTP-U message: %v", err)
+		}
+		return hdr, payload
+	case <-time.After(recvTimeout):
+		h.t.Fatalf("no GTP-U message sent to %v", peer)
+	}
+	return gtpu.Header{}, nil
+}
+
+// recvN6 returns the next packet the UPF sent to the data network
+func (h *harness) recvN6() []byte {
+	h.t.Helper()
+
+	select {
+	case pkt := <-h.n6.Out:
+		return pkt
+	case <-time.After(recvTimeout):
+		h.t.Fatal("no packet sent to the data network")
+	}
+	return nil
+}
+
+// expectNothing checks that the UPF sends nothing on N3 or N6
+func (h *harness) expectNothing() {
+	h.t.Helper()
+
+	select {
+	case d := <-h.n3.Out:
+		h.t.Fatalf("unexpected GTP-U message of %d bytes sent to %v", len(d.Data), d.Addr)
+	case pkt := <-h.n6.Out:
+		h.t.Fatalf("unexpected packet of %d bytes sent to the data network", len(pkt))
+	case <-time.After(quietPeriod):
+	}
+}
+
+// expectGPDU checks that the UPF tunneled pkt to the gNB at peer on
+// teid, in a downlink container of testQFI
+func (h *harness) expectGPDU(peer *net.UDPAddr, teid uint32, pkt []byte) {
+	h.t.Helper()
+
+	hdr, payload := h.recvN3(peer)
+	if hdr.Type != gtpu.MsgGPDU || hdr.TEID != teid {
+		h.t.Fatalf("got %v on TEID %#x, want G-PDU on %#x", hdr.Type, hdr.TEID, teid)
+	}
+	if pdu := hdr.PDUSession; pdu == nil || pdu.Uplink || pdu.QFI != testQFI {
+		h.t.Errorf("PDU session container = %+v, want downlink QFI %d", pdu, testQFI)
+	}
+	if !bytes.Equal(payload, pkt) {
+		h.t.Errorf("T-PDU = %x, want %x", payload, pkt)
+	}
+}
+
+// forwardToGNB is the downlink FAR forwarding to the gNB at peer on teid
+func forwardToGNB(peer *net.UDPAddr, teid uint32) *pfcp.ForwardingParameters {
+	return &pfcp.ForwardingParameters{
+		DestinationInterface: pfcp.InterfaceAccess,
+		NetworkInstance:      testDNN,
+		OuterHeaderCreation:  pfcp.GTPUTunnel(teid, peer.IP),
+	}
+}
+
+// udpPacket returns an IPv4 UDP packet carrying payload
+func udpPacket(src, dst net.IP, payload string) []byte {
+	b := make([]byte, 28, 28+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)+len(payload)))
+	b[8] = 64
+	b[9] = protoUDP
+	copy(b[12:], src.To4())
+	copy(b[16:], dst.To4())
+	binary.BigEndian.PutUint16(b[20:], 40000)
+	binary.BigEndian.PutUint16(b[22:], 53)
+	binary.BigEndian.PutUint16(b[24:], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+// ptr returns a pointer to v
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestUplink(t *testing.T) {
+	tests := []struct {
+		name string
+		teid uint32
+		pkt  []byte
+
+		// forwarded tells that pkt reaches the data network
+		forwarded bool
+
+		// errorIndication tells that the gNB is told the tunnel is unknown
+		errorIndication bool
+	}{
+		{
+			name:      "forwarded",
+			teid:      testULTEID,
+			pkt:       udpPacket(testUE, testServer, "uplink"),
+			forwarded: true,
+		},
+		{
+			name: "spoofed source",
+			teid: testULTEID,
+			pkt:  udpPacket(net.IPv4(10, 60, 0, 2), testServer, "uplink"),
+		},
+		{
+			name:            "unknown tunnel",
+			teid:            testULTEID + 1,
+			pkt:             udpPacket(testUE, testServer, "uplink"),
+			errorIndication: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)})
+
+			h.sendN3(testGNB, gtpu.Header{
+				Type:       gtpu.MsgGPDU,
+				TEID:       tt.teid,
+				PDUSession: &gtpu.PDUSessionContainer{Uplink: true, QFI: testQFI},
+			}, tt.pkt)
+			if tt.forwarded {
+				if got := h.recvN6(); !bytes.Equal(got, tt.pkt) {
+					t.Errorf("packet sent to the data network = %x, want %x", got, tt.pkt)
+				}
+			}
+			if tt.errorIndication {
+				hdr, payload := h.recvN3(testGNB)
+				if hdr.Type != gtpu.MsgErrorIndication {
+					t.Fatalf("got %v, want an error indication", hdr.Type)
+				}
+				if teid, _, err := gtpu.DecodeErrorIndication(payload); err != nil || teid != tt.teid {
+					t.Errorf("error indication of TEID %#x (%v), want %#x", teid, err, tt.teid)
+				}
+			}
+			h.expectNothing()
+		})
+	}
+}
+
+func TestDownlink(t *testing.T) {
+	tests := []struct {
+		name      string
+		pkt       []byte
+		forwarded bool
+	}{
+		{
+			name:      "forwarded",
+			pkt:       udpPacket(testServer, testUE, "downlink"),
+			forwarded: true,
+		},
+		{
+			name: "unknown UE",
+			pkt:  udpPacket(testServer, net.IPv4(10, 60, 0, 2), "downlink"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)})
+
+			h.n6.In <- tt.pkt
+			if tt.forwarded {
+				h.expectGPDU(testGNB, testDLTEID, tt.pkt)
+			}
+			h.expectNothing()
+		})
+	}
+}
+
+// TestBuffering buffers the downlink of an idle UE, then forwards it in
+// order once the SMF points the FAR at the gNB the UE came back through
+func TestBuffering(t *testing.T) {
+	h := newHarness(t)
+	h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionBuffer})
+
+	pkts := [][]byte{
+		udpPacket(testServer, testUE, "first"),
+		udpPacket(testServer, testUE, "second"),
+	}
+	for _, pkt := range pkts {
+		h.n6.In <- pkt
+	}
+	h.expectNothing()
+
+	forward := pfcp.ApplyActionForward
+	h.updateDownlink(pfcp.UpdateFAR{ApplyAction: &forward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)})
+	for _, pkt := range pkts {
+		h.expectGPDU(testGNB, testDLTEID, pkt)
+	}
+	h.expectNothing()
+}
+
+func TestEcho(t *testing.T) {
+	h := newHarness(t)
+
+	h.sendN3(testGNB, gtpu.Header{Type: gtpu.MsgEchoRequest, HasSequenceNumber: true, SequenceNumber: 7}, nil)
+	hdr, _ := h.recvN3(testGNB)
+	if hdr.Type != gtpu.MsgEchoResponse || !hdr.HasSequenceNumber || hdr.SequenceNumber != 7 {
+		t.Errorf("got %v with sequence number %d, want an echo response with 7", hdr.Type, hdr.SequenceNumber)
+	}
+	h.expectNothing()
+}
+
+// TestEndMarker switches the downlink path to another gNB, as an N2
+// handover does: the old gNB gets an end marker on its tunnel, and the
+// next packets go to the new one
+func TestEndMarker(t *testing.T) {
+	h := newHarness(t)
+	h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)})
+
+	fp := forwardToGNB(testGNB2, testDLTEID+1)
+	fp.SendEndMarker = true
+	h.updateDownlink(pfcp.UpdateFAR{ForwardingParameters: fp})
+
+	hdr, _ := h.recvN3(testGNB)
+	if hdr.Type != gtpu.MsgEndMarker || hdr.TEID != testDLTEID {
+		t.Fatalf("got %v on TEID %#x, want an end marker on %#x", hdr.Type, hdr.TEID, testDLTEID)
+	}
+
+	pkt := udpPacket(testServer, testUE, "downlink")
+	h.n6.In <- pkt
+	h.expectGPDU(testGNB2, testDLTEID+1, pkt)
+	h.expectNothing()
+}
+
+func TestGateStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		gate     pfcp.GateStatus
+		uplink   bool
+		downlink bool
+	}{
+		{name: "open", uplink: true, downlink: true},
+		{name: "uplink closed", gate: pfcp.GateStatus{ULClosed: true}, downlink: true},
+		{name: "downlink closed", gate: pfcp.GateStatus{DLClosed: true}, uplink: true},
+		{name: "closed", gate: pfcp.GateStatus{ULClosed: true, DLClosed: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)},
+				pfcp.QER{QERID: 1, GateStatus: tt.gate, QFI: ptr[uint8](testQFI)})
+
+			ul := udpPacket(testUE, testServer, "uplink")
+			h.sendUplink(ul)
+			if tt.uplink {
+				if got := h.recvN6(); !bytes.Equal(got, ul) {
+					t.Errorf("packet sent to the data network = %x, want %x", got, ul)
+				}
+			}
+			dl := udpPacket(testServer, testUE, "downlink")
+			h.n6.In <- dl
+			if tt.downlink {
+				h.expectGPDU(testGNB, testDLTEID, dl)
+			}
+			h.expectNothing()
+
+			var want float64
+			if !tt.uplink {
+				want = float64(len(ul))
+			}
+			if got := testutil.ToFloat64(h.metrics.DroppedBytes.WithLabelValues(metrics.DirectionUplink, dropGateClosed)); got != want {
+				t.Errorf("uplink bytes dropped at the gate = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestPolicing sends a burst of uplink packets through the bit rates of
+// the QERs. 8 kbps buckets hold minBurst, two packets of policedSize.
+func TestPolicing(t *testing.T) {
+	const policedSize = 1400
+	const sent = 6
+
+	slow := &pfcp.BitRate{UL: 8, DL: 8}
+	fast := &pfcp.BitRate{UL: 800, DL: 800}
+	tests := []struct {
+		name      string
+		qers      []pfcp.QER
+		forwarded int
+	}{
+		{
+			name:      "unlimited",
+			qers:      []pfcp.QER{{QERID: 1, QFI: ptr[uint8](testQFI)}},
+			forwarded: sent,
+		},
+		{
+			name:      "flow MBR",
+			qers:      []pfcp.QER{{QERID: 1, MBR: slow, QFI: ptr[uint8](testQFI)}},
+			forwarded: 2,
+		},
+		{
+			name: "session AMBR",
+			qers: []pfcp.QER{
+				{QERID: 1, QFI: ptr[uint8](testQFI)},
+				{QERID: 2, MBR: slow},
+			},
+			forwarded: 2,
+		},
+		{
+			// the guaranteed packets do not count against the AMBR
+			name: "GBR exempt from session AMBR",
+			qers: []pfcp.QER{
+				{QERID: 1, GBR: slow, QFI: ptr[uint8](testQFI)},
+				{QERID: 2, MBR: slow},
+			},
+			forwarded: 4,
+		},
+		{
+			name: "flow MBR over GBR",
+			qers: []pfcp.QER{
+				{QERID: 1, MBR: slow, GBR: fast, QFI: ptr[uint8](testQFI)},
+			},
+			forwarded: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)},
+				tt.qers...)
+
+			pkt := udpPacket(testUE, testServer, strings.Repeat("x", policedSize-28))
+			for i := 0; i < sent; i++ {
+				h.sendUplink(pkt)
+			}
+			for i := 0; i < tt.forwarded; i++ {
+				h.recvN6()
+			}
+			h.expectNothing()
+
+			want := float64((sent - tt.forwarded) * policedSize)
+			if got := testutil.ToFloat64(h.metrics.PolicedBytes.WithLabelValues(metrics.DirectionUplink, "9")); got != want {
+				t.Errorf("policed bytes = %v, want %v", got, want)
+			}
+			if got := testutil.ToFloat64(h.metrics.DroppedBytes.WithLabelValues(metrics.DirectionUplink, dropPoliced)); got != want {
+				t.Errorf("bytes dropped for policing = %v, want %v", got, want)
+			}
+			if got := testutil.ToFloat64(h.metrics.Bytes.WithLabelValues(metrics.DirectionUplink, "9")); got != float64(tt.forwarded*policedSize) {
+				t.Errorf("forwarded bytes = %v, want %v", got, tt.forwarded*policedSize)
+			}
+		})
+	}
+}
+
+// TestGBRDebit checks that a packet only one flow QER guarantees is not
+// taken from the GBR of the others, which would have run out for it
+func TestGBRDebit(t *testing.T) {
+	const size = 1400
+
+	h := newHarness(t)
+	h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)},
+		pfcp.QER{QERID: 1, GBR: &pfcp.BitRate{UL: 8}, QFI: ptr[uint8](testQFI)},
+		pfcp.QER{QERID: 2, GBR: &pfcp.BitRate{UL: 800}, QFI: ptr[uint8](testQFI)},
+		pfcp.QER{QERID: 3, MBR: &pfcp.BitRate{UL: 8}})
+
+	// the GBR of QER 1 guarantees the first two packets, the one of QER 2
+	// all three
+	pkt := udpPacket(testUE, testServer, strings.Repeat("x", size-28))
+	for i := 0; i < 3; i++ {
+		h.sendUplink(pkt)
+		h.recvN6()
+	}
+
+	s := h.upf.allSessions()[0]
+	s.mu.Lock()
+	tokens := s.meters[1].gbr[uplink].tokens
+	s.mu.Unlock()
+	if tokens < minBurst-2*size {
+		t.Errorf("GBR tokens of QER 1 = %v, want at least %v", tokens, minBurst-2*size)
+	}
+}
+
+func TestQFIMarking(t *testing.T) {
+	tests := []struct {
+		name string
+		qers []pfcp.QER
+
+		// qfi is the QFI of the downlink PDU session container, 0 for none
+		qfi uint8
+	}{
+		{name: "flow QER", qers: []pfcp.QER{{QERID: 1, QFI: ptr[uint8](5)}}, qfi: 5},
+		{
+			name: "session and flow QERs",
+			qers: []pfcp.QER{{QERID: 1}, {QERID: 2, QFI: ptr[uint8](6)}},
+			qfi:  6,
+		},
+		{name: "session QER", qers: []pfcp.QER{{QERID: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.establish(pfcp.CreateFAR{ApplyAction: pfcp.ApplyActionForward, ForwardingParameters: forwardToGNB(testGNB, testDLTEID)},
+				tt.qers...)
+
+			h.n6.In <- udpPacket(testServer, testUE, "downlink")
+			hdr, _ := h.recvN3(testGNB)
+			pdu := hdr.PDUSession
+			switch {
+			case tt.qfi == 0 && pdu != nil:
+				t.Errorf("PDU session container = %+v, want none", pdu)
+			case tt.qfi != 0 && (pdu == nil || pdu.Uplink || pdu.QFI != tt.qfi):
+				t.Errorf("PDU session container = %+v, want downlink QFI %d", pdu, tt.qfi)
+			}
+			h.expectNothing()
+		})
+	}
+}